@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+)
+
+// clusterManagementAddOnGVR registers an addon with the hub. Only addons
+// registered this way can be enabled on a cluster.
+var clusterManagementAddOnGVR = schema.GroupVersionResource{
+	Group:    "addon.open-cluster-management.io",
+	Version:  "v1alpha1",
+	Resource: "clustermanagementaddons",
+}
+
+// States of an addon on a cluster, summarised from its ManagedClusterAddOn
+const (
+	addonStatePending     = "Pending"
+	addonStateProgressing = "Progressing"
+	addonStateAvailable   = "Available"
+	addonStateDegraded    = "Degraded"
+	addonStateUnavailable = "Unavailable"
+	addonStateDeleting    = "Deleting"
+)
+
+// Actions of POST /clusters/addons/:addon
+const (
+	addonActionEnable  = "enable"
+	addonActionDisable = "disable"
+)
+
+// errAddonNotRegistered is returned when enabling an addon the hub has no
+// ClusterManagementAddOn for
+var errAddonNotRegistered = errors.New("addon not registered on hub")
+
+// AvailableAddon is an addon a hub can deploy to its clusters
+type AvailableAddon struct {
+	Name        string `json:"name"`
+	Hub         string `json:"hub"`
+	DisplayName string `json:"displayName,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// ClusterAddon is an addon enabled on a cluster and how it is doing
+type ClusterAddon struct {
+	Name             string `json:"name"`
+	InstallNamespace string `json:"installNamespace,omitempty"`
+	// State is Pending until the addon agent reports, then Progressing,
+	// Available, Degraded or Unavailable, and Deleting once disabled
+	State      string             `json:"state"`
+	Message    string             `json:"message,omitempty"`
+	Conditions []ClusterCondition `json:"conditions"`
+}
+
+// EnableAddonRequest is the optional JSON body accepted by
+// PUT /clusters/:name/addons/:addon
+type EnableAddonRequest struct {
+	// InstallNamespace is where the addon agent runs on the spoke, the
+	// addon's default when empty
+	InstallNamespace string `json:"installNamespace,omitempty"`
+}
+
+// Validate checks the install namespace is a namespace name
+func (r EnableAddonRequest) Validate() error {
+	if r.InstallNamespace == "" {
+		return nil
+	}
+	if errs := validation.IsDNS1123Label(r.InstallNamespace); len(errs) > 0 {
+		return fmt.Errorf("invalid installNamespace '%s': %s", r.InstallNamespace, errs[0])
+	}
+	return nil
+}
+
+// AddonBulkRequest is the JSON body accepted by POST /clusters/addons/:addon.
+// It enables or disables an addon on the named clusters or on those matching
+// a label selector.
+type AddonBulkRequest struct {
+	Action           string   `json:"action"`
+	Clusters         []string `json:"clusters,omitempty"`
+	LabelSelector    string   `json:"labelSelector,omitempty"`
+	InstallNamespace string   `json:"installNamespace,omitempty"`
+}
+
+// Validate checks the action and that the clusters are given one way
+func (r AddonBulkRequest) Validate() error {
+	if r.Action != addonActionEnable && r.Action != addonActionDisable {
+		return fmt.Errorf("action must be %s or %s", addonActionEnable, addonActionDisable)
+	}
+	if r.LabelSelector != "" && len(r.Clusters) > 0 {
+		return fmt.Errorf("labelSelector cannot be combined with clusters")
+	}
+	if r.LabelSelector == "" && len(r.Clusters) == 0 {
+		return fmt.Errorf("clusters or labelSelector is required")
+	}
+	return EnableAddonRequest{InstallNamespace: r.InstallNamespace}.Validate()
+}
+
+// AddonResult is the outcome of enabling or disabling an addon on a cluster
+type AddonResult struct {
+	ClusterName string `json:"clusterName"`
+	// Changed is false when the addon already was as requested
+	Changed bool          `json:"changed"`
+	Addon   *ClusterAddon `json:"addon,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+func availableAddonFrom(hubName string, u *unstructured.Unstructured) AvailableAddon {
+	addon := AvailableAddon{Name: u.GetName(), Hub: hubName}
+	addon.DisplayName, _, _ = unstructured.NestedString(u.Object, "spec", "addOnMeta", "displayName")
+	addon.Description, _, _ = unstructured.NestedString(u.Object, "spec", "addOnMeta", "description")
+	return addon
+}
+
+// clusterAddonFrom reads a ManagedClusterAddOn and summarises its conditions
+// into a state
+func clusterAddonFrom(u *unstructured.Unstructured) ClusterAddon {
+	addon := ClusterAddon{Name: u.GetName(), State: addonStatePending, Conditions: []ClusterCondition{}}
+	addon.InstallNamespace, _, _ = unstructured.NestedString(u.Object, "spec", "installNamespace")
+
+	status := map[string]ClusterCondition{}
+	conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	for _, raw := range conditions {
+		condition, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		parsed := ClusterCondition{
+			Type:               stringField(condition, "type"),
+			Status:             stringField(condition, "status"),
+			Reason:             stringField(condition, "reason"),
+			Message:            stringField(condition, "message"),
+			LastTransitionTime: stringField(condition, "lastTransitionTime"),
+		}
+		addon.Conditions = append(addon.Conditions, parsed)
+		status[parsed.Type] = parsed
+	}
+
+	available, degraded, progressing := status["Available"], status["Degraded"], status["Progressing"]
+	switch {
+	case u.GetDeletionTimestamp() != nil:
+		addon.State = addonStateDeleting
+	case available.Status == "True" && degraded.Status == "True":
+		addon.State, addon.Message = addonStateDegraded, degraded.Message
+	case available.Status == "True":
+		addon.State, addon.Message = addonStateAvailable, available.Message
+	case progressing.Status == "True":
+		addon.State, addon.Message = addonStateProgressing, progressing.Message
+	case available.Status == "False":
+		addon.State, addon.Message = addonStateUnavailable, available.Message
+	}
+	return addon
+}
+
+// listAvailableAddons lists the ClusterManagementAddOns of a hub
+func listAvailableAddons(ctx context.Context, hubName string, hub dynamic.Interface) ([]AvailableAddon, error) {
+	list, err := hub.Resource(clusterManagementAddOnGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	addons := make([]AvailableAddon, 0, len(list.Items))
+	for i := range list.Items {
+		addons = append(addons, availableAddonFrom(hubName, &list.Items[i]))
+	}
+	return addons, nil
+}
+
+// listClusterAddons lists the ManagedClusterAddOns in the cluster namespace
+func listClusterAddons(ctx context.Context, hub dynamic.Interface, clusterName string) ([]ClusterAddon, error) {
+	list, err := hub.Resource(managedClusterAddOnGVR).Namespace(clusterName).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	addons := make([]ClusterAddon, 0, len(list.Items))
+	for i := range list.Items {
+		addons = append(addons, clusterAddonFrom(&list.Items[i]))
+	}
+	sort.Slice(addons, func(i, j int) bool { return addons[i].Name < addons[j].Name })
+	return addons, nil
+}
+
+// enableAddon creates the ManagedClusterAddOn of a registered addon in the
+// cluster namespace, leaving one that already exists as it is
+func enableAddon(ctx context.Context, hub dynamic.Interface, clusterName, name, installNamespace string) (ClusterAddon, bool, error) {
+	existing, err := hub.Resource(managedClusterAddOnGVR).Namespace(clusterName).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return clusterAddonFrom(existing), false, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return ClusterAddon{}, false, fmt.Errorf("failed to get addon: %w", err)
+	}
+	if _, err := hub.Resource(clusterManagementAddOnGVR).Get(ctx, name, metav1.GetOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ClusterAddon{}, false, fmt.Errorf("%w: %s", errAddonNotRegistered, name)
+		}
+		return ClusterAddon{}, false, fmt.Errorf("failed to get ClusterManagementAddOn: %w", err)
+	}
+
+	spec := map[string]interface{}{}
+	if installNamespace != "" {
+		spec["installNamespace"] = installNamespace
+	}
+	addon := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": managedClusterAddOnGVR.GroupVersion().String(),
+		"kind":       "ManagedClusterAddOn",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": clusterName,
+			"labels":    map[string]interface{}{"app.kubernetes.io/managed-by": "kubestellar-cluster-plugin"},
+		},
+		"spec": spec,
+	}}
+	created, err := hub.Resource(managedClusterAddOnGVR).Namespace(clusterName).Create(ctx, addon, metav1.CreateOptions{})
+	if err != nil {
+		return ClusterAddon{}, false, fmt.Errorf("failed to create addon: %w", err)
+	}
+	return clusterAddonFrom(created), true, nil
+}
+
+// disableAddon deletes the ManagedClusterAddOn of a cluster, which has the
+// hub remove the addon agent from the spoke
+func disableAddon(ctx context.Context, hub dynamic.Interface, clusterName, name string) (bool, error) {
+	err := hub.Resource(managedClusterAddOnGVR).Namespace(clusterName).Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete addon: %w", err)
+	}
+	return true, nil
+}
+
+// hubDynamic connects a dynamic client to a hub
+func hubDynamic(hub Hub) (dynamic.Interface, error) {
+	_, hubConfig, err := hub.clientset()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hub clientset: %w", err)
+	}
+	client, err := dynamic.NewForConfig(hubConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	return client, nil
+}
+
+// addonCluster looks up the record of the cluster named in the path,
+// responding and returning false when there is none
+func (cp *ClusterPlugin) addonCluster(c *gin.Context) (ClusterStatus, bool) {
+	clusterName := c.Param("name")
+	cp.mutex.RLock()
+	record, exists, err := cp.store.Get(clusterName)
+	cp.mutex.RUnlock()
+	if err != nil {
+		respondStoreError(c, err)
+		return record, false
+	}
+	if !exists {
+		respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin", clusterName))
+		return record, false
+	}
+	return record, true
+}
+
+// ListAddonsHandler lists the addons registered on every hub, or on ?hub
+func (cp *ClusterPlugin) ListAddonsHandler(c *gin.Context) {
+	hubs := cp.hubs.List()
+	if name := c.Query("hub"); name != "" {
+		hub, err := cp.hubs.Get(name)
+		if err != nil {
+			respondProblem(c, http.StatusNotFound, CodeHubNotFound, err.Error())
+			return
+		}
+		hubs = []Hub{hub}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	addons := []AvailableAddon{}
+	var problems []string
+	for _, hub := range hubs {
+		client, err := hubDynamic(hub)
+		if err == nil {
+			var found []AvailableAddon
+			if found, err = listAvailableAddons(ctx, hub.Name, client); err == nil {
+				addons = append(addons, found...)
+				continue
+			}
+		}
+		problems = append(problems, fmt.Sprintf("hub %s: %v", hub.Name, err))
+	}
+	if cp.abortOnContext(c) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"addons":    addons,
+		"errors":    problems,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// GetClusterAddonsHandler lists the addons enabled on a cluster and their state
+func (cp *ClusterPlugin) GetClusterAddonsHandler(c *gin.Context) {
+	record, ok := cp.addonCluster(c)
+	if !ok {
+		return
+	}
+	hub, err := cp.hubs.Get(hubName(record))
+	if err != nil {
+		respondProblem(c, http.StatusConflict, CodeConflict, err.Error())
+		return
+	}
+	client, err := hubDynamic(hub)
+	if err != nil {
+		respondProblem(c, http.StatusBadGateway, CodeHubUnreachable, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	addons, err := listClusterAddons(ctx, client, record.ClusterName)
+	if cp.abortOnContext(c) {
+		return
+	}
+	if err != nil {
+		respondProblem(c, http.StatusBadGateway, CodeHubUnreachable, fmt.Sprintf("Failed to list addons on hub %s: %v", hub.Name, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clusterName": record.ClusterName,
+		"hub":         hub.Name,
+		"addons":      addons,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// setClusterAddon enables or disables an addon on one cluster
+func (cp *ClusterPlugin) setClusterAddon(ctx context.Context, record ClusterStatus, addon, action, installNamespace string) (AddonResult, error) {
+	result := AddonResult{ClusterName: record.ClusterName}
+	hub, err := cp.hubs.Get(hubName(record))
+	if err != nil {
+		return result, err
+	}
+	client, err := hubDynamic(hub)
+	if err != nil {
+		return result, err
+	}
+	if action == addonActionDisable {
+		result.Changed, err = disableAddon(ctx, client, record.ClusterName, addon)
+	} else {
+		var enabled ClusterAddon
+		if enabled, result.Changed, err = enableAddon(ctx, client, record.ClusterName, addon, installNamespace); err == nil {
+			result.Addon = &enabled
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+	logger().Info("Cluster addon updated", "cluster", record.ClusterName, "addon", addon, "action", action, "changed", result.Changed)
+	return result, nil
+}
+
+// EnableClusterAddonHandler enables an addon registered on the hub on a cluster
+func (cp *ClusterPlugin) EnableClusterAddonHandler(c *gin.Context) {
+	var req EnableAddonRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+	}
+	if err := req.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	cp.changeClusterAddon(c, addonActionEnable, req.InstallNamespace)
+}
+
+// DisableClusterAddonHandler removes an addon from a cluster
+func (cp *ClusterPlugin) DisableClusterAddonHandler(c *gin.Context) {
+	cp.changeClusterAddon(c, addonActionDisable, "")
+}
+
+func (cp *ClusterPlugin) changeClusterAddon(c *gin.Context, action, installNamespace string) {
+	record, ok := cp.addonCluster(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	result, err := cp.setClusterAddon(ctx, record, c.Param("addon"), action, installNamespace)
+	if cp.abortOnContext(c) {
+		return
+	}
+	switch {
+	case errors.Is(err, errAddonNotRegistered):
+		respondProblem(c, http.StatusNotFound, CodeNotFound, err.Error())
+		return
+	case errors.Is(err, errHubNotFound):
+		respondProblem(c, http.StatusConflict, CodeConflict, err.Error())
+		return
+	case err != nil:
+		respondProblem(c, http.StatusBadGateway, CodeHubUnreachable, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result":    result,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// BulkAddonHandler enables or disables an addon on several clusters, named
+// or matching a label selector, reporting the outcome for each
+func (cp *ClusterPlugin) BulkAddonHandler(c *gin.Context) {
+	var req AddonBulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	results := []AddonResult{}
+	var records []ClusterStatus
+	if req.LabelSelector != "" {
+		cp.mutex.RLock()
+		selected, err := cp.selectClusters(req.LabelSelector)
+		cp.mutex.RUnlock()
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		records = tenantClusters(c.Request.Context(), selected)
+	} else {
+		tenant, scoped := tenantFromContext(c.Request.Context())
+		for _, name := range req.Clusters {
+			cp.mutex.RLock()
+			record, exists, err := cp.store.Get(name)
+			cp.mutex.RUnlock()
+			switch {
+			case err != nil:
+				results = append(results, AddonResult{ClusterName: name, Error: err.Error()})
+			case !exists || scoped && record.Tenant != tenant:
+				results = append(results, AddonResult{ClusterName: name, Error: errClusterNotFound.Error()})
+			default:
+				records = append(records, record)
+			}
+		}
+	}
+	if len(records)+len(results) > cp.maxBatchSize {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Request targets more than the maximum of %d clusters", cp.maxBatchSize))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+	for _, record := range records {
+		result, err := cp.setClusterAddon(ctx, record, c.Param("addon"), req.Action, req.InstallNamespace)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	if cp.abortOnContext(c) {
+		return
+	}
+
+	summary := map[string]int{"total": len(results), "changed": 0, "unchanged": 0, "failed": 0}
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			summary["failed"]++
+		case result.Changed:
+			summary["changed"]++
+		default:
+			summary["unchanged"]++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"addon":     c.Param("addon"),
+		"action":    req.Action,
+		"results":   results,
+		"summary":   summary,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func addonObject(kind, namespace, name string, conditions ...map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "addon.open-cluster-management.io/v1alpha1",
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name},
+	}}
+	if namespace != "" {
+		u.SetNamespace(namespace)
+	}
+	if len(conditions) > 0 {
+		raw := make([]interface{}, len(conditions))
+		for i := range conditions {
+			raw[i] = conditions[i]
+		}
+		unstructured.SetNestedSlice(u.Object, raw, "status", "conditions")
+	}
+	return u
+}
+
+func condition(conditionType, status, message string) map[string]interface{} {
+	return map[string]interface{}{"type": conditionType, "status": status, "message": message}
+}
+
+func TestClusterAddonFrom(t *testing.T) {
+	deleting := addonObject("ManagedClusterAddOn", "edge-1", "deleting", condition("Available", "True", ""))
+	now := metav1.Now()
+	deleting.SetDeletionTimestamp(&now)
+
+	tests := []struct {
+		addon *unstructured.Unstructured
+		want  string
+	}{
+		{addonObject("ManagedClusterAddOn", "edge-1", "new"), addonStatePending},
+		{addonObject("ManagedClusterAddOn", "edge-1", "installing", condition("Progressing", "True", "applying")), addonStateProgressing},
+		{addonObject("ManagedClusterAddOn", "edge-1", "up", condition("Available", "True", "ok")), addonStateAvailable},
+		{addonObject("ManagedClusterAddOn", "edge-1", "sick", condition("Available", "True", ""), condition("Degraded", "True", "crashloop")), addonStateDegraded},
+		{addonObject("ManagedClusterAddOn", "edge-1", "down", condition("Available", "False", "lease expired")), addonStateUnavailable},
+		{deleting, addonStateDeleting},
+	}
+	for _, tt := range tests {
+		if got := clusterAddonFrom(tt.addon); got.State != tt.want {
+			t.Errorf("clusterAddonFrom(%s).State = %s, want %s", tt.addon.GetName(), got.State, tt.want)
+		}
+	}
+}
+
+func TestEnableDisableAddon(t *testing.T) {
+	listKinds := map[schema.GroupVersionResource]string{
+		managedClusterAddOnGVR:    "ManagedClusterAddOnList",
+		clusterManagementAddOnGVR: "ClusterManagementAddOnList",
+	}
+	registered := addonObject("ClusterManagementAddOn", "", "governance-policy-framework")
+	unstructured.SetNestedField(registered.Object, "Policy framework", "spec", "addOnMeta", "displayName")
+	hub := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, registered)
+	ctx := context.Background()
+
+	available, err := listAvailableAddons(ctx, "its1", hub)
+	if err != nil || len(available) != 1 || available[0].DisplayName != "Policy framework" || available[0].Hub != "its1" {
+		t.Fatalf("listAvailableAddons() = %+v, %v", available, err)
+	}
+
+	if _, _, err := enableAddon(ctx, hub, "edge-1", "unknown", ""); !errors.Is(err, errAddonNotRegistered) {
+		t.Errorf("enableAddon(unknown) error = %v, want errAddonNotRegistered", err)
+	}
+	addon, changed, err := enableAddon(ctx, hub, "edge-1", "governance-policy-framework", "policy-agent")
+	if err != nil || !changed || addon.State != addonStatePending || addon.InstallNamespace != "policy-agent" {
+		t.Fatalf("enableAddon() = %+v, %v, %v", addon, changed, err)
+	}
+	if _, changed, err := enableAddon(ctx, hub, "edge-1", "governance-policy-framework", ""); err != nil || changed {
+		t.Errorf("enableAddon() again changed = %v, %v", changed, err)
+	}
+	enabled, err := listClusterAddons(ctx, hub, "edge-1")
+	if err != nil || len(enabled) != 1 {
+		t.Fatalf("listClusterAddons() = %+v, %v", enabled, err)
+	}
+
+	if changed, err := disableAddon(ctx, hub, "edge-1", "governance-policy-framework"); err != nil || !changed {
+		t.Errorf("disableAddon() = %v, %v", changed, err)
+	}
+	if changed, err := disableAddon(ctx, hub, "edge-1", "governance-policy-framework"); err != nil || changed {
+		t.Errorf("disableAddon() again = %v, %v", changed, err)
+	}
+}
+
+func TestAddonBulkRequestValidate(t *testing.T) {
+	tests := []struct {
+		req     AddonBulkRequest
+		wantErr string
+	}{
+		{AddonBulkRequest{Action: "enable", Clusters: []string{"edge-1"}}, ""},
+		{AddonBulkRequest{Action: "disable", LabelSelector: "env=prod"}, ""},
+		{AddonBulkRequest{Action: "upgrade", Clusters: []string{"edge-1"}}, "action"},
+		{AddonBulkRequest{Action: "enable"}, "required"},
+		{AddonBulkRequest{Action: "enable", Clusters: []string{"edge-1"}, LabelSelector: "env=prod"}, "combined"},
+		{AddonBulkRequest{Action: "enable", Clusters: []string{"edge-1"}, InstallNamespace: "Bad_NS"}, "installNamespace"},
+	}
+	for _, tt := range tests {
+		err := tt.req.Validate()
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("Validate(%+v) = %v, want %q", tt.req, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBulkAddonReportsEachCluster(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("KUBECONFIG", t.TempDir()+"/missing")
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	body := `{"action":"enable","clusters":["edge-1","missing"]}`
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/clusters/addons/governance-policy-framework", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"failed":2`) || !strings.Contains(recorder.Body.String(), errClusterNotFound.Error()) {
+		t.Errorf("bulk enable = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/clusters/missing/addons", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("addons of unknown cluster = %d, want 404", recorder.Code)
+	}
+}
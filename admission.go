@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Admission failure policies, as for Kubernetes admission webhooks
+const (
+	admissionFailClosed = "fail"
+	admissionFailOpen   = "ignore"
+)
+
+// defaultAdmissionPath is the package rule admission policies are queried at
+const defaultAdmissionPath = "kubestellar/admission"
+
+// errAdmissionUnavailable is returned when the policy can't be evaluated and
+// the failure policy refuses the request
+var errAdmissionUnavailable = errors.New("admission policy could not be evaluated")
+
+// AdmissionConfig is the opa section of the Initialize config. Policies are
+// served by an OPA server at URL; the plugin doesn't evaluate Rego itself.
+type AdmissionConfig struct {
+	URL string `json:"url,omitempty"`
+	// Token is sent as a bearer token to the OPA server
+	Token string `json:"token,omitempty"`
+	// Path is the rule evaluated, such as kubestellar/admission for
+	// data.kubestellar.admission
+	Path string `json:"path,omitempty"`
+	// FailurePolicy is fail to refuse requests the policy can't be evaluated
+	// for, or ignore to let them through
+	FailurePolicy  string `json:"failurePolicy,omitempty"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+}
+
+// AdmissionInput is the input document policies decide on
+type AdmissionInput struct {
+	// Operation is onboard or detach
+	Operation     string            `json:"operation"`
+	ClusterName   string            `json:"clusterName,omitempty"`
+	LabelSelector string            `json:"labelSelector,omitempty"`
+	Group         string            `json:"group,omitempty"`
+	Hub           string            `json:"hub,omitempty"`
+	Provider      string            `json:"provider,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	Force         bool              `json:"force,omitempty"`
+	DryRun        bool              `json:"dryRun,omitempty"`
+	Scheduled     bool              `json:"scheduled,omitempty"`
+	// User is the authenticated caller, absent for anonymous requests and
+	// background work
+	User      *AdmissionUser `json:"user,omitempty"`
+	RequestID string         `json:"requestId,omitempty"`
+}
+
+// AdmissionUser is the caller as policies see it
+type AdmissionUser struct {
+	Subject string                 `json:"subject"`
+	Scopes  []string               `json:"scopes,omitempty"`
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+}
+
+// AdmissionDecision is the decision log of one evaluation, recorded in the
+// audit entry of the request
+type AdmissionDecision struct {
+	Operation   string `json:"operation"`
+	ClusterName string `json:"clusterName,omitempty"`
+	// Engine is url, for the OPA server the policy was evaluated by
+	Engine string `json:"engine"`
+	Query  string `json:"query"`
+	// DecisionID is the ID of the decision in the OPA server's own logs
+	DecisionID string   `json:"decisionId,omitempty"`
+	Allowed    bool     `json:"allowed"`
+	Reasons    []string `json:"reasons,omitempty"`
+	// Error tells why the policy couldn't be evaluated; Allowed then follows
+	// the failure policy
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// AdmissionDeniedError rejects a request the policy denied
+type AdmissionDeniedError struct {
+	Decision AdmissionDecision
+}
+
+func (e *AdmissionDeniedError) Error() string {
+	target := e.Decision.ClusterName
+	if target == "" {
+		target = "the requested clusters"
+	}
+	message := fmt.Sprintf("%s of %s denied by admission policy", e.Decision.Operation, target)
+	if len(e.Decision.Reasons) > 0 {
+		message += ": " + strings.Join(e.Decision.Reasons, "; ")
+	}
+	return message
+}
+
+// admissionController evaluates onboarding and detach requests against the
+// configured policy before they run
+type admissionController struct {
+	config  AdmissionConfig
+	query   string
+	timeout time.Duration
+	client  *http.Client
+}
+
+func newAdmissionControllerFromConfig(config map[string]interface{}) (*admissionController, error) {
+	raw, ok := config["opa"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid opa config: %w", err)
+	}
+	var ac AdmissionConfig
+	if err := json.Unmarshal(data, &ac); err != nil {
+		return nil, fmt.Errorf("invalid opa config: %w", err)
+	}
+	if section, ok := raw.(map[string]interface{}); ok && section["policyFiles"] != nil {
+		return nil, fmt.Errorf("opa.policyFiles is not supported, serve the policies from an OPA server at opa.url")
+	}
+	if ac.URL == "" {
+		return nil, fmt.Errorf("opa config requires a url")
+	}
+	parsed, err := url.Parse(ac.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("opa.url must be an absolute http or https URL")
+	}
+	ac.Path = strings.Trim(ac.Path, "/")
+	if ac.Path == "" {
+		ac.Path = defaultAdmissionPath
+	}
+	switch ac.FailurePolicy {
+	case "":
+		ac.FailurePolicy = admissionFailClosed
+	case admissionFailClosed, admissionFailOpen:
+	default:
+		return nil, fmt.Errorf("opa.failurePolicy must be %s or %s", admissionFailClosed, admissionFailOpen)
+	}
+	if ac.TimeoutSeconds < 0 {
+		return nil, fmt.Errorf("opa.timeoutSeconds must not be negative")
+	}
+	if ac.TimeoutSeconds == 0 {
+		ac.TimeoutSeconds = 5
+	}
+	timeout := time.Duration(ac.TimeoutSeconds) * time.Second
+	return &admissionController{
+		config:  ac,
+		query:   "data." + strings.ReplaceAll(ac.Path, "/", "."),
+		timeout: timeout,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// evaluate decides on input, failing open or closed when the policy can't
+// be evaluated
+func (ac *admissionController) evaluate(ctx context.Context, input AdmissionInput) AdmissionDecision {
+	decision := AdmissionDecision{Operation: input.Operation, ClusterName: input.ClusterName, Engine: "url", Query: ac.query}
+	ctx, cancel := context.WithTimeout(ctx, ac.timeout)
+	defer cancel()
+
+	start := time.Now()
+	result, decisionID, err := ac.queryServer(ctx, input)
+	decision.DecisionID = decisionID
+	if err == nil {
+		decision.Allowed, decision.Reasons, err = parseAdmissionResult(result)
+	}
+	decision.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		decision.Error = err.Error()
+		decision.Allowed = ac.config.FailurePolicy == admissionFailOpen
+		decision.Reasons = nil
+	}
+	return decision
+}
+
+// queryServer asks the OPA server through its data API
+func (ac *admissionController) queryServer(ctx context.Context, input AdmissionInput) (json.RawMessage, string, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, "", err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(ac.config.URL, "/")+"/v1/data/"+ac.config.Path, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if ac.config.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+ac.config.Token)
+	}
+	response, err := ac.client.Do(request)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+		return nil, "", fmt.Errorf("opa answered %s", response.Status)
+	}
+	var answer struct {
+		Result     json.RawMessage `json:"result"`
+		DecisionID string          `json:"decision_id"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&answer); err != nil {
+		return nil, "", fmt.Errorf("invalid opa response: %w", err)
+	}
+	return answer.Result, answer.DecisionID, nil
+}
+
+// probe is the preflight check of the OPA server, asking its health API
+func (ac *admissionController) probe(ctx context.Context) DependencyCheck {
+	check := DependencyCheck{Name: "opa", Path: ac.config.URL}
+	ctx, cancel := context.WithTimeout(ctx, ac.timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(ac.config.URL, "/")+"/health", nil)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	if ac.config.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+ac.config.Token)
+	}
+	response, err := ac.client.Do(request)
+	if err != nil {
+		check.Error = fmt.Sprintf("unreachable: %v", err)
+		return check
+	}
+	io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+	response.Body.Close()
+	check.Available = true
+	if response.StatusCode != http.StatusOK {
+		check.Error = fmt.Sprintf("opa health answered %s", response.Status)
+		return check
+	}
+	check.Compatible = true
+	return check
+}
+
+// parseAdmissionResult reads a decision: either a boolean, or an object with
+// an allow boolean and the reasons for it. An undefined decision denies.
+func parseAdmissionResult(result json.RawMessage) (bool, []string, error) {
+	if len(result) == 0 || string(result) == "null" {
+		return false, []string{"policy made no decision"}, nil
+	}
+	var allowed bool
+	if err := json.Unmarshal(result, &allowed); err == nil {
+		return allowed, nil, nil
+	}
+	var decision struct {
+		Allow   *bool    `json:"allow"`
+		Reasons []string `json:"reasons"`
+	}
+	if err := json.Unmarshal(result, &decision); err != nil || decision.Allow == nil {
+		return false, nil, fmt.Errorf("policy decision must be a boolean or an object with an allow boolean")
+	}
+	return *decision.Allow, decision.Reasons, nil
+}
+
+// admissionTrail collects the decisions made while handling a request, for
+// its audit entry
+type admissionTrail struct {
+	decisions []AdmissionDecision
+	mutex     sync.Mutex
+}
+
+// admissionTrailContextKey stores the admissionTrail on the request context
+type admissionTrailContextKey struct{}
+
+func (t *admissionTrail) add(decision AdmissionDecision) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.decisions = append(t.decisions, decision)
+}
+
+func (t *admissionTrail) list() []AdmissionDecision {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]AdmissionDecision(nil), t.decisions...)
+}
+
+// admit evaluates an onboarding or detach of the request ctx belongs to. It
+// returns an AdmissionDeniedError when the policy denies it, and
+// errAdmissionUnavailable when evaluating failed closed.
+func (cp *ClusterPlugin) admit(ctx context.Context, input AdmissionInput) error {
+	if cp.admission == nil {
+		return nil
+	}
+	input.RequestID = requestIDFromContext(ctx)
+	if caller, ok := principalFromContext(ctx); ok {
+		input.User = &AdmissionUser{Subject: caller.Subject, Scopes: caller.Scopes, Claims: caller.Claims}
+	}
+	decision := cp.admission.evaluate(ctx, input)
+	if trail, ok := ctx.Value(admissionTrailContextKey{}).(*admissionTrail); ok {
+		trail.add(decision)
+	}
+
+	log := logger().With("operation", decision.Operation, "cluster", decision.ClusterName, "allowed", decision.Allowed)
+	if input.RequestID != "" {
+		log = log.With("requestId", input.RequestID)
+	}
+	switch {
+	case decision.Error != "":
+		log.Warn("Admission policy evaluation failed", "failurePolicy", cp.admission.config.FailurePolicy, "error", decision.Error)
+		if !decision.Allowed {
+			return fmt.Errorf("%w: %s", errAdmissionUnavailable, decision.Error)
+		}
+	case !decision.Allowed:
+		log.Info("Admission denied", "reasons", decision.Reasons)
+		return &AdmissionDeniedError{Decision: decision}
+	default:
+		log.Debug("Admission allowed")
+	}
+	return nil
+}
+
+// respondAdmission answers a request admit refused, reporting whether it did
+func respondAdmission(c *gin.Context, err error) bool {
+	var denied *AdmissionDeniedError
+	switch {
+	case errors.As(err, &denied):
+		respondProblem(c, http.StatusForbidden, CodeAdmissionDenied, denied.Error(), "reasons", denied.Decision.Reasons)
+	case errors.Is(err, errAdmissionUnavailable):
+		respondProblem(c, http.StatusServiceUnavailable, CodeUnavailable, err.Error())
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// opaServer answers the data API like OPA with a policy denying prod clusters
+func opaServer(t *testing.T) (*httptest.Server, *[]AdmissionInput) {
+	t.Helper()
+	var inputs []AdmissionInput
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.Write([]byte("{}"))
+			return
+		}
+		if r.URL.Path != "/v1/data/kubestellar/admission" {
+			http.NotFound(w, r)
+			return
+		}
+		var body struct {
+			Input AdmissionInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		inputs = append(inputs, body.Input)
+		result := map[string]interface{}{"allow": true}
+		if strings.HasPrefix(body.Input.ClusterName, "prod-") {
+			result = map[string]interface{}{"allow": false, "reasons": []string{"prod clusters are managed by the platform team"}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "decision_id": "decision-" + body.Input.ClusterName})
+	}))
+	t.Cleanup(server.Close)
+	return server, &inputs
+}
+
+func TestAdmissionDecisionsAudited(t *testing.T) {
+	server, inputs := opaServer(t)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"opa": map[string]interface{}{"url": server.URL}})
+	plugin.putStatus(ClusterStatus{ClusterName: "prod-1", Status: "Ready"})
+	plugin.putStatus(ClusterStatus{ClusterName: "dev-1", Status: "Ready"})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		caller := Principal{Subject: "alice", Scopes: []string{"cluster.write"}}
+		c.Set(principalKey, caller)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), principalContextKey{}, caller))
+	})
+	router.POST("/detach", plugin.withAudit("DetachClusterHandler", plugin.DetachClusterHandler))
+	serve := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/detach", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve(`{"clusterName": "prod-1", "force": true}`)
+	var problem struct {
+		Code    ErrorCode `json:"code"`
+		Reasons []string  `json:"reasons"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &problem)
+	if recorder.Code != http.StatusForbidden || problem.Code != CodeAdmissionDenied || len(problem.Reasons) != 1 {
+		t.Errorf("denied detach = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(`{"clusterName": "dev-1", "dryRun": true}`); recorder.Code != http.StatusOK {
+		t.Errorf("allowed detach = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	if len(*inputs) != 2 {
+		t.Fatalf("opa was asked %d times", len(*inputs))
+	}
+	if input := (*inputs)[0]; input.Operation != "detach" || !input.Force || input.User == nil || input.User.Subject != "alice" {
+		t.Errorf("opa input = %+v", input)
+	}
+	entries := plugin.audit.Query(AuditQuery{})
+	if len(entries) != 2 {
+		t.Fatalf("audited %d entries", len(entries))
+	}
+	denied, allowed := entries[1], entries[0]
+	if denied.Result != "denied" || len(denied.Admission) != 1 || denied.Admission[0].Allowed || denied.Admission[0].DecisionID != "decision-prod-1" {
+		t.Errorf("denied entry = %+v", denied)
+	}
+	if len(allowed.Admission) != 1 || !allowed.Admission[0].Allowed || allowed.Admission[0].Engine != "url" {
+		t.Errorf("allowed entry = %+v", allowed)
+	}
+
+	// Batches record a denial per cluster rather than failing the request
+	item, task := plugin.submitBatchCluster(context.Background(), OnboardRequest{ClusterName: "prod-2", Kubeconfig: "unused"}, nil)
+	if task != nil || !strings.Contains(item.Error, "denied by admission policy") {
+		t.Errorf("batch item = %+v", item)
+	}
+}
+
+func TestAdmissionFailurePolicy(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "policy compile error", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	for policy, allowed := range map[string]bool{admissionFailClosed: false, admissionFailOpen: true} {
+		ac, err := newAdmissionControllerFromConfig(map[string]interface{}{"opa": map[string]interface{}{"url": broken.URL, "failurePolicy": policy}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		decision := ac.evaluate(context.Background(), AdmissionInput{Operation: "onboard", ClusterName: "edge-1"})
+		if decision.Allowed != allowed || !strings.Contains(decision.Error, "500") {
+			t.Errorf("%s: decision = %+v", policy, decision)
+		}
+	}
+}
+
+func TestAdmissionPreflight(t *testing.T) {
+	server, _ := opaServer(t)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"opa": map[string]interface{}{"url": server.URL}})
+	if check, found := plugin.preflight.Check("opa"); !found || !check.Available || !check.Compatible || check.Path != server.URL {
+		t.Errorf("opa preflight = %+v, found %v", check, found)
+	}
+
+	server.Close()
+	report := plugin.checkDependencies(context.Background(), plugin.GetMetadata())
+	if check, _ := report.Check("opa"); check.Available || report.Passed || !strings.Contains(check.Error, "unreachable") {
+		t.Errorf("preflight of a stopped opa = %+v", report)
+	}
+}
+
+func TestAdmissionConfigValidation(t *testing.T) {
+	for name, config := range map[string]interface{}{
+		"no url":             map[string]interface{}{},
+		"policy files":       map[string]interface{}{"url": "http://opa:8181", "policyFiles": []string{"admission.rego"}},
+		"relative url":       map[string]interface{}{"url": "opa:8181"},
+		"bad failure policy": map[string]interface{}{"url": "http://opa:8181", "failurePolicy": "allow"},
+		"negative timeout":   map[string]interface{}{"url": "http://opa:8181", "timeoutSeconds": -1},
+	} {
+		if _, err := newAdmissionControllerFromConfig(map[string]interface{}{"opa": config}); err == nil {
+			t.Errorf("%s: newAdmissionControllerFromConfig() accepted", name)
+		}
+	}
+}
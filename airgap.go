@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ocmRegistry is where clusteradm pulls the klusterlet images from by default
+const ocmRegistry = "quay.io/open-cluster-management"
+
+// AirGapConfig is the airGap section of the Initialize config, for spokes
+// that cannot reach public registries
+type AirGapConfig struct {
+	// Registry is the private registry mirroring the klusterlet images. It is
+	// the default manifestValues registry and is passed to clusteradm join.
+	Registry string `json:"registry,omitempty"`
+	// Mirrors rewrite image references by registry prefix in every manifest
+	// the plugin applies, e.g. docker.io/library to registry.local/library
+	Mirrors map[string]string `json:"mirrors,omitempty"`
+	// PullSecretPath is a docker config JSON file installed on the spoke as
+	// the pull secret of the klusterlet images
+	PullSecretPath string `json:"pullSecretPath,omitempty"`
+	// BundleDir holds manifest templates, one per .yaml file, applied to the
+	// spoke after the join, e.g. the agents a disconnected cluster needs
+	BundleDir string `json:"bundleDir,omitempty"`
+}
+
+// mirrors returns the registry prefixes to rewrite, including the klusterlet
+// registry when Registry is set
+func (a AirGapConfig) mirrors() map[string]string {
+	if a.Registry == "" && len(a.Mirrors) == 0 {
+		return nil
+	}
+	mirrors := make(map[string]string, len(a.Mirrors)+1)
+	for source, target := range a.Mirrors {
+		mirrors[source] = target
+	}
+	if a.Registry != "" {
+		if _, ok := mirrors[ocmRegistry]; !ok {
+			mirrors[ocmRegistry] = a.Registry
+		}
+	}
+	return mirrors
+}
+
+func airGapFromConfig(config map[string]interface{}) (AirGapConfig, error) {
+	var airGap AirGapConfig
+	raw, ok := config["airGap"]
+	if !ok {
+		return airGap, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return airGap, fmt.Errorf("invalid airGap config: %w", err)
+	}
+	if err := json.Unmarshal(data, &airGap); err != nil {
+		return airGap, fmt.Errorf("invalid airGap config: %w", err)
+	}
+	if err := (ManifestValues{Registry: airGap.Registry}).Validate(); err != nil {
+		return airGap, fmt.Errorf("invalid airGap config: %w", err)
+	}
+	for source, target := range airGap.Mirrors {
+		for _, prefix := range []string{source, target} {
+			if prefix == "" || strings.ContainsAny(prefix, " \t\n") || strings.HasSuffix(prefix, "/") {
+				return airGap, fmt.Errorf("invalid airGap mirror %q: %q", source, target)
+			}
+		}
+	}
+	return airGap, nil
+}
+
+// loadPullSecret reads the docker config JSON at path
+func loadPullSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pull secret: %w", err)
+	}
+	var dockerConfig struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &dockerConfig); err != nil || len(dockerConfig.Auths) == 0 {
+		return nil, fmt.Errorf("pull secret %s is not a docker config JSON with auths", path)
+	}
+	return data, nil
+}
+
+// loadBundle parses the manifest templates of an offline bundle, in file
+// name order
+func loadBundle(dir string) ([]ManifestTemplate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	templates := make([]ManifestTemplate, 0, len(names))
+	for _, name := range names {
+		text, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		t, err := parseManifestTemplate(ManifestTemplate{
+			Name:        "bundle/" + name,
+			Target:      "spoke",
+			Description: "Offline bundle manifest",
+			Template:    string(text),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle template %s: %w", name, err)
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// rewriteImage points image at its mirror, matching the longest registry prefix
+func rewriteImage(image string, mirrors map[string]string) string {
+	match := ""
+	for source := range mirrors {
+		if (image == source || strings.HasPrefix(image, source+"/") || strings.HasPrefix(image, source+":")) && len(source) > len(match) {
+			match = source
+		}
+	}
+	if match == "" {
+		return image
+	}
+	return mirrors[match] + strings.TrimPrefix(image, match)
+}
+
+// rewriteImages rewrites the image references of a manifest: container image
+// fields and the image pull specs of the Klusterlet
+func rewriteImages(manifest string, mirrors map[string]string) (string, error) {
+	if len(mirrors) == 0 {
+		return manifest, nil
+	}
+	var object interface{}
+	if err := yaml.Unmarshal([]byte(manifest), &object); err != nil {
+		return "", err
+	}
+	if !rewriteImageFields(object, mirrors) {
+		return manifest, nil
+	}
+	data, err := yaml.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func rewriteImageFields(value interface{}, mirrors map[string]string) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if image, ok := field.(string); ok && (key == "image" || strings.HasSuffix(key, "ImagePullSpec")) {
+				if rewritten := rewriteImage(image, mirrors); rewritten != image {
+					v[key] = rewritten
+					changed = true
+				}
+				continue
+			}
+			changed = rewriteImageFields(field, mirrors) || changed
+		}
+	case []interface{}:
+		for _, item := range v {
+			changed = rewriteImageFields(item, mirrors) || changed
+		}
+	}
+	return changed
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteImage(t *testing.T) {
+	mirrors := AirGapConfig{
+		Registry: "registry.local/ocm",
+		Mirrors:  map[string]string{"docker.io": "registry.local/dockerhub", "docker.io/library": "registry.local/library"},
+	}.mirrors()
+
+	tests := map[string]string{
+		"quay.io/open-cluster-management/registration:v0.13.1": "registry.local/ocm/registration:v0.13.1",
+		"docker.io/library/busybox:1.36":                       "registry.local/library/busybox:1.36",
+		"docker.io/bitnami/kubectl":                            "registry.local/dockerhub/bitnami/kubectl",
+		"docker.io.example.com/app":                            "docker.io.example.com/app",
+		"ghcr.io/acme/agent:v1":                                "ghcr.io/acme/agent:v1",
+	}
+	for image, want := range tests {
+		if got := rewriteImage(image, mirrors); got != want {
+			t.Errorf("rewriteImage(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestAirGapOnboardingManifests(t *testing.T) {
+	dir := t.TempDir()
+	pullSecretPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(pullSecretPath, []byte(`{"auths":{"registry.local":{"auth":"c2VjcmV0"}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	bundleDir := filepath.Join(dir, "bundle")
+	if err := os.Mkdir(bundleDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	agent := `apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: monitoring-agent
+  namespace: {{ .Namespace }}
+spec:
+  template:
+    spec:
+      containers:
+      - name: agent
+        image: docker.io/acme/agent:v1
+`
+	if err := os.WriteFile(filepath.Join(bundleDir, "agent.yaml"), []byte(agent), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"airGap": map[string]interface{}{
+			"registry":       "registry.local/ocm",
+			"mirrors":        map[string]interface{}{"docker.io": "registry.local/dockerhub"},
+			"pullSecretPath": pullSecretPath,
+			"bundleDir":      bundleDir,
+		},
+	})
+	if plugin.manifestValues.Registry != "registry.local/ocm" {
+		t.Errorf("default registry = %q, want the airGap registry", plugin.manifestValues.Registry)
+	}
+
+	data := plugin.manifestData("edge-1", plugin.manifestValues)
+	before, err := renderManifests(plugin.manifestTemplates(), "spoke", true, data)
+	if err != nil {
+		t.Fatalf("renderManifests(before join) error = %v", err)
+	}
+	// A namespace and a pull secret for both the operator and agent namespaces
+	if len(before) != 4 || !strings.Contains(before[1], "kubernetes.io/dockerconfigjson") {
+		t.Errorf("before join manifests = %v", before)
+	}
+
+	after, err := renderManifests(plugin.manifestTemplates(), "spoke", false, data)
+	if err != nil {
+		t.Fatalf("renderManifests(after join) error = %v", err)
+	}
+	if len(after) != 2 || !strings.Contains(after[0], "registry.local/ocm/registration:latest") ||
+		!strings.Contains(after[1], "image: registry.local/dockerhub/acme/agent:v1") {
+		t.Errorf("after join manifests = %v", after)
+	}
+
+	args := strings.Join(joinCommand("clusteradm join --hub-token t --cluster-name <cluster_name>", "edge-1", "kind-edge-1", data.Values), " ")
+	if !strings.Contains(args, "--cluster-name edge-1") || !strings.Contains(args, "--image-registry registry.local/ocm") {
+		t.Errorf("join command = %s", args)
+	}
+}
+
+func TestAirGapFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		airGap  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "registry", airGap: map[string]interface{}{"registry": "registry.local:5000/ocm"}},
+		{name: "registry with trailing slash", airGap: map[string]interface{}{"registry": "registry.local/"}, wantErr: true},
+		{name: "empty mirror", airGap: map[string]interface{}{"mirrors": map[string]interface{}{"docker.io": ""}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := airGapFromConfig(map[string]interface{}{"airGap": tt.airGap}); (err != nil) != tt.wantErr {
+				t.Errorf("airGapFromConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := loadPullSecret(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loadPullSecret() of a missing file succeeded")
+	}
+}
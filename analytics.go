@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAnalyticsBuckets bounds the onboarding series, so an hourly interval
+// over the whole retention period is refused rather than built
+const maxAnalyticsBuckets = 1000
+
+// providerLabels and regionLabels are the cluster labels fleet analytics
+// group by, in order of preference. The OCM ones are set by the cluster
+// claims of the registration agent.
+var (
+	providerLabels = []string{"provider", "cloud", "platform.open-cluster-management.io"}
+	regionLabels   = []string{"region", "topology.kubernetes.io/region", "region.open-cluster-management.io"}
+)
+
+// OnboardingBucket counts the onboarding jobs created in one interval
+type OnboardingBucket struct {
+	Start       string  `json:"start"`
+	Total       int     `json:"total"`
+	Succeeded   int     `json:"succeeded"`
+	Failed      int     `json:"failed"`
+	SuccessRate float64 `json:"successRate"`
+}
+
+// OnboardingStats sums up the onboarding jobs of a window. SuccessRate is
+// the share of finished jobs that succeeded.
+type OnboardingStats struct {
+	Total       int                `json:"total"`
+	Succeeded   int                `json:"succeeded"`
+	Failed      int                `json:"failed"`
+	Cancelled   int                `json:"cancelled"`
+	InProgress  int                `json:"inProgress"`
+	SuccessRate float64            `json:"successRate"`
+	Series      []OnboardingBucket `json:"series"`
+}
+
+// TimeToReady describes how long clusters took from being registered to
+// turning Ready for the first time
+type TimeToReady struct {
+	Samples       int     `json:"samples"`
+	MeanSeconds   float64 `json:"meanSeconds"`
+	MedianSeconds float64 `json:"medianSeconds"`
+	P95Seconds    float64 `json:"p95Seconds"`
+}
+
+// FailureReason counts the onboarding jobs that failed in one step
+type FailureReason struct {
+	Step  string `json:"step"`
+	Count int    `json:"count"`
+	// LastMessage is the error of the most recent of them
+	LastMessage string `json:"lastMessage"`
+}
+
+// FleetBreakdown counts the clusters of the inventory along each dimension
+type FleetBreakdown struct {
+	Total      int            `json:"total"`
+	ByStatus   map[string]int `json:"byStatus"`
+	ByHub      map[string]int `json:"byHub"`
+	ByProvider map[string]int `json:"byProvider"`
+	ByRegion   map[string]int `json:"byRegion"`
+	// Resources sums the collected resources of the clusters
+	Resources FleetResources `json:"resources"`
+}
+
+// FleetAvailability averages the availability of the clusters observed in
+// the window
+type FleetAvailability struct {
+	Clusters    int     `json:"clusters"`
+	MeanPercent float64 `json:"meanPercent"`
+	Outages     int     `json:"outages"`
+}
+
+// analyticsWindow is the time range and series interval of GET /analytics
+type analyticsWindow struct {
+	since    time.Time
+	until    time.Time
+	interval string
+}
+
+// bucketStart returns the start of the interval holding t, in UTC
+func (w analyticsWindow) bucketStart(t time.Time) time.Time {
+	t = t.UTC()
+	switch w.interval {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		// Weeks start on Monday
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// next returns the start of the interval after the one starting at start
+func (w analyticsWindow) next(start time.Time) time.Time {
+	switch w.interval {
+	case "hour":
+		return start.Add(time.Hour)
+	case "week":
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// rate is part/whole as a percentage rounded to two decimals
+func rate(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(whole)*10000) / 100
+}
+
+// onboardingStats buckets the onboarding jobs created within the window
+func onboardingStats(jobs []Job, window analyticsWindow) OnboardingStats {
+	stats := OnboardingStats{Series: []OnboardingBucket{}}
+	index := map[time.Time]int{}
+	for start := window.bucketStart(window.since); start.Before(window.until); start = window.next(start) {
+		index[start] = len(stats.Series)
+		stats.Series = append(stats.Series, OnboardingBucket{Start: start.Format(time.RFC3339)})
+	}
+
+	for _, job := range jobs {
+		created, err := time.Parse(time.RFC3339, job.CreatedAt)
+		if job.Type != "onboard" || err != nil || created.Before(window.since) || created.After(window.until) {
+			continue
+		}
+		stats.Total++
+		bucket := &OnboardingBucket{}
+		if i, ok := index[window.bucketStart(created)]; ok {
+			bucket = &stats.Series[i]
+		}
+		bucket.Total++
+		switch job.State {
+		case JobSucceeded:
+			stats.Succeeded++
+			bucket.Succeeded++
+		case JobFailed, JobTimedOut:
+			stats.Failed++
+			bucket.Failed++
+		case JobCancelled:
+			stats.Cancelled++
+		default:
+			stats.InProgress++
+		}
+	}
+
+	stats.SuccessRate = rate(stats.Succeeded, stats.Succeeded+stats.Failed)
+	for i := range stats.Series {
+		bucket := &stats.Series[i]
+		bucket.SuccessRate = rate(bucket.Succeeded, bucket.Succeeded+bucket.Failed)
+	}
+	return stats
+}
+
+// failureReasons groups the failed onboarding jobs of the window by the step
+// they failed in, most frequent first
+func failureReasons(jobs []Job, window analyticsWindow) []FailureReason {
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].UpdatedAt < jobs[j].UpdatedAt })
+	byStep := map[string]*FailureReason{}
+	for _, job := range jobs {
+		created, err := time.Parse(time.RFC3339, job.CreatedAt)
+		if job.Type != "onboard" || (job.State != JobFailed && job.State != JobTimedOut) || err != nil || created.Before(window.since) || created.After(window.until) {
+			continue
+		}
+		// The last step is the failure itself, the one before it where it happened
+		step := "unknown"
+		if len(job.Steps) > 1 {
+			step = job.Steps[len(job.Steps)-2].Name
+		}
+		reason, exists := byStep[step]
+		if !exists {
+			reason = &FailureReason{Step: step}
+			byStep[step] = reason
+		}
+		reason.Count++
+		reason.LastMessage = job.Message
+	}
+
+	reasons := make([]FailureReason, 0, len(byStep))
+	for _, reason := range byStep {
+		reasons = append(reasons, *reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if reasons[i].Count != reasons[j].Count {
+			return reasons[i].Count > reasons[j].Count
+		}
+		return reasons[i].Step < reasons[j].Step
+	})
+	return reasons
+}
+
+// timeToReady measures, from the cluster histories, how long clusters that
+// turned Ready within the window took since they were registered
+func timeToReady(history map[string][]HistoryEntry, window analyticsWindow) TimeToReady {
+	var samples []float64
+	for _, entries := range history {
+		var registered time.Time
+		for _, entry := range entries {
+			timestamp, err := time.Parse(time.RFC3339, entry.Timestamp)
+			if err != nil {
+				continue
+			}
+			switch entry.Event {
+			case "registered":
+				registered = timestamp
+			case "detached":
+				// A detached cluster is registered again when onboarded anew
+				registered = time.Time{}
+			case "onboarded":
+				if !registered.IsZero() && !timestamp.Before(window.since) && !timestamp.After(window.until) {
+					samples = append(samples, timestamp.Sub(registered).Seconds())
+				}
+				registered = time.Time{}
+			}
+		}
+	}
+
+	result := TimeToReady{Samples: len(samples)}
+	if len(samples) == 0 {
+		return result
+	}
+	sort.Float64s(samples)
+	total := 0.0
+	for _, sample := range samples {
+		total += sample
+	}
+	result.MeanSeconds = math.Round(total / float64(len(samples)))
+	result.MedianSeconds = percentile(samples, 50)
+	result.P95Seconds = percentile(samples, 95)
+	return result
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// fleetAvailability averages the availability of every cluster with history
+// in the window
+func fleetAvailability(history map[string][]HistoryEntry, window analyticsWindow) FleetAvailability {
+	var result FleetAvailability
+	total := 0.0
+	for _, entries := range history {
+		availability := computeAvailability(entries, window.since, window.until)
+		if availability.ObservedSeconds == 0 {
+			continue
+		}
+		result.Clusters++
+		result.Outages += availability.Outages
+		total += availability.Percent
+	}
+	if result.Clusters > 0 {
+		result.MeanPercent = math.Round(total/float64(result.Clusters)*100) / 100
+	}
+	return result
+}
+
+// firstLabel returns the value of the first of keys set, or unknown
+func firstLabel(set map[string]string, keys []string) string {
+	for _, key := range keys {
+		if value := set[key]; value != "" {
+			return value
+		}
+	}
+	return "unknown"
+}
+
+// fleetBreakdown counts the clusters by status, hub, provider and region and
+// sums their resources. Clusters without a provider or region label count as
+// unknown.
+func fleetBreakdown(clusters []ClusterStatus) FleetBreakdown {
+	breakdown := FleetBreakdown{
+		Total:      len(clusters),
+		ByStatus:   map[string]int{},
+		ByHub:      map[string]int{},
+		ByProvider: map[string]int{},
+		ByRegion:   map[string]int{},
+	}
+	for _, cluster := range clusters {
+		set := clusterLabelSet(cluster)
+		breakdown.ByStatus[cluster.Status]++
+		breakdown.ByHub[hubName(cluster)]++
+		breakdown.ByProvider[firstLabel(set, providerLabels)]++
+		breakdown.ByRegion[firstLabel(set, regionLabels)]++
+	}
+	breakdown.Resources = fleetResources(clusters)
+	return breakdown
+}
+
+// GetAnalyticsHandler returns fleet-level rollups over the ?since and ?until
+// RFC 3339 time range, 30 days by default, with the onboarding series
+// bucketed by ?interval: hour, day or week. A tenant only sees its own
+// clusters and jobs.
+func (cp *ClusterPlugin) GetAnalyticsHandler(c *gin.Context) {
+	since, until, err := parseTimeRange(c, 30*24*time.Hour)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	window := analyticsWindow{since: since, until: until, interval: c.DefaultQuery("interval", "day")}
+	buckets := 0
+	switch window.interval {
+	case "hour":
+		buckets = int(window.until.Sub(window.since) / time.Hour)
+	case "day":
+		buckets = int(window.until.Sub(window.since) / (24 * time.Hour))
+	case "week":
+		buckets = int(window.until.Sub(window.since) / (7 * 24 * time.Hour))
+	default:
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid interval %q, must be hour, day or week", window.interval))
+		return
+	}
+	if buckets > maxAnalyticsBuckets {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("the range holds more than %d %ss, use a longer interval", maxAnalyticsBuckets, window.interval))
+		return
+	}
+
+	snapshot, err := cp.statusCache.get(false, cp.loadStatusSnapshot)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	history, err := cp.store.AllHistory()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	clusters := tenantClusters(ctx, snapshot.clusters)
+	jobs := []Job{}
+	for _, job := range cp.jobs.List() {
+		if visibleTo(ctx, job.Tenant) {
+			jobs = append(jobs, job)
+		}
+	}
+	// History carries no tenant, so a tenant only sees that of its current
+	// clusters
+	if _, scoped := tenantFromContext(ctx); scoped {
+		visible := make(map[string][]HistoryEntry, len(clusters))
+		for _, cluster := range clusters {
+			if entries, ok := history[cluster.ClusterName]; ok {
+				visible[cluster.ClusterName] = entries
+			}
+		}
+		history = visible
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"window": gin.H{
+			"since":    window.since.Format(time.RFC3339),
+			"until":    window.until.Format(time.RFC3339),
+			"interval": window.interval,
+		},
+		"onboarding":     onboardingStats(jobs, window),
+		"timeToReady":    timeToReady(history, window),
+		"failureReasons": failureReasons(jobs, window),
+		"availability":   fleetAvailability(history, window),
+		"clusters":       fleetBreakdown(clusters),
+		"plugin":         "kubestellar-cluster-plugin",
+		"timestamp":      time.Now().Format(time.RFC3339),
+	})
+}
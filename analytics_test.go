@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOnboardingStats(t *testing.T) {
+	start := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) string { return start.Add(time.Duration(hours) * time.Hour).Format(time.RFC3339) }
+	failed := func(created int, step, message string) Job {
+		return Job{Type: "onboard", State: JobFailed, CreatedAt: at(created), UpdatedAt: at(created + 1), Message: message,
+			Steps: []JobStep{{Name: "Running"}, {Name: step}, {Name: string(JobFailed)}}}
+	}
+	jobs := []Job{
+		{Type: "onboard", State: JobSucceeded, CreatedAt: at(1)},
+		{Type: "onboard", State: JobSucceeded, CreatedAt: at(2)},
+		failed(3, "Joining", "join timed out"),
+		failed(26, "Joining", "agent never registered"),
+		failed(27, "Preparing", "kubeconfig rejected"),
+		{Type: "onboard", State: JobRunning, CreatedAt: at(28)},
+		{Type: "detach", State: JobFailed, CreatedAt: at(29)},
+		{Type: "onboard", State: JobSucceeded, CreatedAt: at(-1)},
+	}
+	window := analyticsWindow{since: start, until: start.Add(48 * time.Hour), interval: "day"}
+
+	stats := onboardingStats(jobs, window)
+	if stats.Total != 6 || stats.Succeeded != 2 || stats.Failed != 3 || stats.InProgress != 1 || stats.SuccessRate != 40 {
+		t.Errorf("onboardingStats() = %+v", stats)
+	}
+	if len(stats.Series) != 2 || stats.Series[0].SuccessRate != 66.67 || stats.Series[1].Total != 3 {
+		t.Errorf("series = %+v", stats.Series)
+	}
+
+	reasons := failureReasons(jobs, window)
+	if len(reasons) != 2 || reasons[0].Step != "Joining" || reasons[0].Count != 2 || reasons[0].LastMessage != "agent never registered" {
+		t.Errorf("failureReasons() = %+v", reasons)
+	}
+
+	weekly := analyticsWindow{interval: "week"}
+	if got := weekly.bucketStart(time.Date(2026, 3, 8, 23, 0, 0, 0, time.UTC)); !got.Equal(start) {
+		t.Errorf("week of Sunday 8 March = %v, want Monday 2 March", got)
+	}
+}
+
+func TestTimeToReady(t *testing.T) {
+	start := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) string { return start.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339) }
+	history := map[string][]HistoryEntry{
+		"edge-1": {{Event: "registered", Timestamp: at(0)}, {Event: "onboarding", Timestamp: at(2)}, {Event: "onboarded", Timestamp: at(5)}},
+		"edge-2": {
+			{Event: "registered", Timestamp: at(0)}, {Event: "onboarded", Timestamp: at(10)},
+			{Event: "detached", Timestamp: at(60)}, {Event: "registered", Timestamp: at(70)}, {Event: "onboarded", Timestamp: at(90)},
+		},
+		// Recovering from an outage is not an onboarding
+		"edge-3": {{Event: "unreachable", Timestamp: at(0)}, {Event: "recovered", Timestamp: at(30)}},
+	}
+	got := timeToReady(history, analyticsWindow{since: start, until: start.Add(24 * time.Hour)})
+	if got.Samples != 3 || got.MeanSeconds != 700 || got.MedianSeconds != 600 || got.P95Seconds != 1200 {
+		t.Errorf("timeToReady() = %+v", got)
+	}
+}
+
+func TestFleetBreakdown(t *testing.T) {
+	breakdown := fleetBreakdown([]ClusterStatus{
+		{ClusterName: "edge-1", Status: "Ready", Labels: map[string]string{"provider": "eks", "region": "eu-west-1"}},
+		{ClusterName: "edge-2", Status: "Ready", Hub: "its2", ManagedCluster: &ManagedClusterState{Labels: map[string]string{"cloud": "Amazon"}}},
+		{ClusterName: "edge-3", Status: "Failed"},
+	})
+	if breakdown.ByProvider["eks"] != 1 || breakdown.ByProvider["Amazon"] != 1 || breakdown.ByRegion["unknown"] != 2 ||
+		breakdown.ByHub["its2"] != 1 || breakdown.ByStatus["Ready"] != 2 {
+		t.Errorf("fleetBreakdown() = %+v", breakdown)
+	}
+}
+
+func TestGetAnalyticsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Pending", LastUpdated: time.Now().Add(-time.Hour).Format(time.RFC3339)})
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Ready", LastUpdated: time.Now().Add(-50 * time.Minute).Format(time.RFC3339)})
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/plugins/kubestellar-cluster-plugin/v1/analytics"+query, nil))
+		return recorder
+	}
+
+	recorder := get("?interval=hour&since=" + time.Now().Add(-24*time.Hour).UTC().Format(time.RFC3339))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("analytics = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		Onboarding   OnboardingStats   `json:"onboarding"`
+		TimeToReady  TimeToReady       `json:"timeToReady"`
+		Availability FleetAvailability `json:"availability"`
+		Clusters     FleetBreakdown    `json:"clusters"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Onboarding.Series) < 24 || response.TimeToReady.MeanSeconds != 600 ||
+		response.Availability.Clusters != 1 || response.Clusters.Total != 1 {
+		t.Errorf("analytics = %+v", response)
+	}
+
+	if recorder := get("?interval=hour&since=2020-01-01T00:00:00Z"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("too many buckets = %d", recorder.Code)
+	}
+	if recorder := get("?interval=month"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown interval = %d", recorder.Code)
+	}
+	// Without since the range is the 30 days before until, not before now
+	recorder = get("?until=" + time.Now().AddDate(-1, 0, 0).UTC().Format(time.RFC3339))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("analytics until a year ago = %d %s", recorder.Code, recorder.Body.String())
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if len(response.Onboarding.Series) < 30 || len(response.Onboarding.Series) > 31 {
+		t.Errorf("series until a year ago has %d daily buckets, want 30 days", len(response.Onboarding.Series))
+	}
+}
+
+func TestGetAnalyticsHandlerTenantScoped(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"tenancy": map[string]interface{}{"enabled": true}})
+	hourAgo := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	plugin.putStatus(ClusterStatus{ClusterName: "acme-1", Status: "Ready", Tenant: "acme", LastUpdated: hourAgo})
+	plugin.putStatus(ClusterStatus{ClusterName: "globex-1", Status: "Ready", Tenant: "globex", LastUpdated: hourAgo})
+	plugin.putStatus(ClusterStatus{ClusterName: "globex-2", Status: "Failed", Tenant: "globex", LastUpdated: hourAgo})
+	globexCtx := context.WithValue(context.Background(), tenantContextKey{}, "globex")
+	job := plugin.jobs.Create(globexCtx, "onboard", "globex-3")
+	plugin.jobs.Run(job.ID, func(context.Context) error { return errors.New("globex credentials rejected") })
+	waitForJob(t, plugin.jobs, job.ID, func(job Job) bool { return job.Finished() })
+
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	get := func(tenant string) (*httptest.ResponseRecorder, FleetBreakdown, OnboardingStats, FleetAvailability) {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/analytics", nil)
+		request.Header.Set("X-Tenant-ID", tenant)
+		router.ServeHTTP(recorder, request)
+		var response struct {
+			Onboarding   OnboardingStats   `json:"onboarding"`
+			Availability FleetAvailability `json:"availability"`
+			Clusters     FleetBreakdown    `json:"clusters"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder, response.Clusters, response.Onboarding, response.Availability
+	}
+
+	recorder, clusters, onboarding, availability := get("acme")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("acme analytics = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if clusters.Total != 1 || clusters.ByStatus["Failed"] != 0 || onboarding.Total != 0 || availability.Clusters != 1 {
+		t.Errorf("acme sees clusters %+v, onboarding %+v, availability %+v", clusters, onboarding, availability)
+	}
+	if strings.Contains(recorder.Body.String(), "globex") {
+		t.Errorf("acme analytics leak globex: %s", recorder.Body.String())
+	}
+
+	recorder, clusters, onboarding, availability = get("globex")
+	if clusters.Total != 2 || onboarding.Total != 1 || availability.Clusters != 2 || !strings.Contains(recorder.Body.String(), "globex credentials rejected") {
+		t.Errorf("globex analytics = %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireApproval policies decide which detachments wait for a second
+// person to approve them
+const (
+	approvalOff = "off"
+	// approvalForce holds forced detachments only
+	approvalForce = "force"
+	// approvalDetach holds every detachment
+	approvalDetach = "detach"
+)
+
+// ApprovalState is the lifecycle state of an approval
+type ApprovalState string
+
+const (
+	ApprovalPending  ApprovalState = "Pending"
+	ApprovalApproved ApprovalState = "Approved"
+	ApprovalExpired  ApprovalState = "Expired"
+	// ApprovalFailed means the approved operation couldn't be started
+	ApprovalFailed ApprovalState = "Failed"
+)
+
+var (
+	// errApprovalConflict is returned when the same operation already awaits approval
+	errApprovalConflict = errors.New("operation already awaits approval")
+	// errApprovalNotFound is returned for unknown approval IDs
+	errApprovalNotFound = errors.New("approval not found")
+	// errSelfApproval is returned when the requester tries to approve their own operation
+	errSelfApproval = errors.New("an operation must be approved by someone other than its requester")
+)
+
+// Approval is a destructive operation held until a second authorized caller
+// confirms it
+type Approval struct {
+	ID        string        `json:"id"`
+	Operation string        `json:"operation"`
+	State     ApprovalState `json:"state"`
+	Message   string        `json:"message,omitempty"`
+	// Reason says why the operation needs approval
+	Reason        string `json:"reason"`
+	ClusterName   string `json:"clusterName,omitempty"`
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// Tenant is the tenant of the requester; only its callers may approve
+	Tenant      string `json:"tenant,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Hub         string `json:"hub,omitempty"`
+	Force       bool   `json:"force,omitempty"`
+	Drain       bool   `json:"drain,omitempty"`
+	RequestedBy string `json:"requestedBy"`
+	ApprovedBy  string `json:"approvedBy,omitempty"`
+	RequestID   string `json:"requestId,omitempty"`
+	CreatedAt   string `json:"createdAt"`
+	ExpiresAt   string `json:"expiresAt"`
+	UpdatedAt   string `json:"updatedAt"`
+
+	// request is the held detachment, run as made once approved
+	request    DetachRequest
+	kubeconfig []byte
+}
+
+// Finished reports whether the approval no longer waits for an approver
+func (a Approval) Finished() bool {
+	return a.State != ApprovalPending
+}
+
+// ApprovalManager holds operations until they are approved or expire.
+// Approvals are kept in memory, so pending ones are lost on restart.
+type ApprovalManager struct {
+	approvals map[string]*Approval
+	mutex     sync.Mutex
+	// expiry is how long an approval waits for an approver
+	expiry time.Duration
+	// retention is how many finished approvals are kept; 0 keeps them all
+	retention int
+}
+
+// NewApprovalManager creates an approval manager
+func NewApprovalManager(expiry time.Duration, retention int) *ApprovalManager {
+	return &ApprovalManager{
+		approvals: make(map[string]*Approval),
+		expiry:    expiry,
+		retention: retention,
+	}
+}
+
+// Add holds an operation for approval and returns a snapshot of it
+func (am *ApprovalManager) Add(ctx context.Context, approval Approval) (Approval, error) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	am.expire(time.Now())
+	approval.Tenant, _ = tenantFromContext(ctx)
+	for _, existing := range am.approvals {
+		if !existing.Finished() && existing.Operation == approval.Operation && existing.Tenant == approval.Tenant &&
+			existing.ClusterName == approval.ClusterName && existing.LabelSelector == approval.LabelSelector &&
+			existing.Group == approval.Group {
+			return Approval{}, fmt.Errorf("%w: %s", errApprovalConflict, existing.ID)
+		}
+	}
+
+	now := time.Now()
+	approval.ID = newJobID("approval")
+	approval.RequestID = requestIDFromContext(ctx)
+	approval.State = ApprovalPending
+	approval.CreatedAt = now.Format(time.RFC3339)
+	approval.ExpiresAt = now.Add(am.expiry).Format(time.RFC3339)
+	approval.UpdatedAt = approval.CreatedAt
+	am.approvals[approval.ID] = &approval
+	logger().Info("Operation awaits approval", "approval", approval.ID, "operation", approval.Operation,
+		"cluster", approval.ClusterName, "labelSelector", approval.LabelSelector, "requestedBy", approval.RequestedBy)
+	return copyApproval(&approval), nil
+}
+
+// Approve marks a pending approval approved by approver and returns it with
+// the held request, ready to run
+func (am *ApprovalManager) Approve(id, approver string) (Approval, error) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	am.expire(time.Now())
+
+	approval, exists := am.approvals[id]
+	if !exists {
+		return Approval{}, fmt.Errorf("%w: %s", errApprovalNotFound, id)
+	}
+	if approval.Finished() {
+		return Approval{}, fmt.Errorf("approval '%s' is not pending (state: %s)", id, approval.State)
+	}
+	if approver == approval.RequestedBy {
+		return Approval{}, errSelfApproval
+	}
+	held := *approval
+	approval.ApprovedBy = approver
+	am.finish(approval, ApprovalApproved, fmt.Sprintf("Approved by %s", approver))
+	held.ApprovedBy = approver
+	held.State = approval.State
+	held.Message = approval.Message
+	held.UpdatedAt = approval.UpdatedAt
+	return held, nil
+}
+
+// Fail records that an approved operation couldn't be started
+func (am *ApprovalManager) Fail(id, message string) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	if approval, exists := am.approvals[id]; exists {
+		approval.State = ApprovalFailed
+		approval.Message = message
+		approval.UpdatedAt = time.Now().Format(time.RFC3339)
+	}
+}
+
+// Get returns a snapshot of a single approval
+func (am *ApprovalManager) Get(id string) (Approval, bool) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	am.expire(time.Now())
+
+	approval, exists := am.approvals[id]
+	if !exists {
+		return Approval{}, false
+	}
+	return copyApproval(approval), true
+}
+
+// List returns snapshots of all approvals, the newest first
+func (am *ApprovalManager) List() []Approval {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	am.expire(time.Now())
+
+	approvals := make([]Approval, 0, len(am.approvals))
+	for _, approval := range am.approvals {
+		approvals = append(approvals, copyApproval(approval))
+	}
+	sort.Slice(approvals, func(i, j int) bool {
+		if approvals[i].CreatedAt != approvals[j].CreatedAt {
+			return approvals[i].CreatedAt > approvals[j].CreatedAt
+		}
+		return approvals[i].ID > approvals[j].ID
+	})
+	return approvals
+}
+
+// expire moves the pending approvals past their expiry to Expired. The
+// caller must hold the mutex.
+func (am *ApprovalManager) expire(now time.Time) {
+	for _, approval := range am.approvals {
+		if expiresAt, _ := time.Parse(time.RFC3339, approval.ExpiresAt); !approval.Finished() && !now.Before(expiresAt) {
+			logger().Info("Approval expired", "approval", approval.ID, "cluster", approval.ClusterName)
+			am.finish(approval, ApprovalExpired, fmt.Sprintf("Not approved before %s", approval.ExpiresAt))
+		}
+	}
+}
+
+// finish moves an approval to a terminal state. The caller must hold the mutex.
+func (am *ApprovalManager) finish(approval *Approval, state ApprovalState, message string) {
+	approval.State = state
+	approval.Message = message
+	approval.UpdatedAt = time.Now().Format(time.RFC3339)
+	// Only a pending approval needs its request
+	approval.request = DetachRequest{}
+	approval.kubeconfig = nil
+	am.evictFinished()
+}
+
+// evictFinished drops the oldest finished approvals beyond the retention
+// limit. The caller must hold the mutex.
+func (am *ApprovalManager) evictFinished() {
+	if am.retention <= 0 {
+		return
+	}
+	var finished []*Approval
+	for _, approval := range am.approvals {
+		if approval.Finished() {
+			finished = append(finished, approval)
+		}
+	}
+	if len(finished) <= am.retention {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].UpdatedAt < finished[j].UpdatedAt
+	})
+	for _, approval := range finished[:len(finished)-am.retention] {
+		delete(am.approvals, approval.ID)
+	}
+}
+
+func copyApproval(approval *Approval) Approval {
+	snapshot := *approval
+	snapshot.request = DetachRequest{}
+	snapshot.kubeconfig = nil
+	return snapshot
+}
+
+// approvalReason says why the detachment of req needs approval under the
+// requireApproval policy, "" when it doesn't
+func (cp *ClusterPlugin) approvalReason(req DetachRequest) string {
+	switch {
+	case req.DryRun || cp.approvals == nil:
+		return ""
+	case cp.requireApproval == approvalDetach:
+		return "Detachments require a second approver"
+	case cp.requireApproval == approvalForce && req.Force:
+		return "Forced detachments require a second approver"
+	}
+	return ""
+}
+
+// requestApproval holds a detachment until a second caller approves it
+func (cp *ClusterPlugin) requestApproval(c *gin.Context, req DetachRequest, spokeKubeconfig []byte, reason string) {
+	caller, ok := principal(c)
+	if !ok {
+		respondProblem(c, http.StatusUnauthorized, CodeUnauthenticated, "Detachments requiring approval must be made by an authenticated caller")
+		return
+	}
+	if req.ClusterName != "" {
+		if _, exists, err := cp.store.Get(req.ClusterName); err != nil {
+			respondStoreError(c, err)
+			return
+		} else if !exists {
+			respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin", req.ClusterName))
+			return
+		}
+	}
+
+	created, err := cp.approvals.Add(c.Request.Context(), Approval{
+		Operation:     "detach",
+		Reason:        reason,
+		ClusterName:   req.ClusterName,
+		LabelSelector: req.LabelSelector,
+		Group:         req.Group,
+		Hub:           req.Hub,
+		Force:         req.Force,
+		Drain:         req.Drain,
+		RequestedBy:   caller.Subject,
+		request:       req,
+		kubeconfig:    spokeKubeconfig,
+	})
+	if err != nil {
+		respondProblem(c, http.StatusConflict, CodeConflict, err.Error())
+		return
+	}
+	code, params := MsgApprovalPendingCluster, []interface{}{"cluster", created.ClusterName}
+	if created.LabelSelector != "" {
+		code, params = MsgApprovalPendingSelector, []interface{}{"selector", created.LabelSelector}
+	}
+	if created.Group != "" {
+		code, params = MsgApprovalPendingGroup, []interface{}{"group", created.Group}
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     message(c, code, append(params, "expiresAt", created.ExpiresAt)...),
+		"messageCode": code,
+		"approval":    created,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// ListApprovalsHandler returns all approvals, optionally filtered by state or cluster
+func (cp *ClusterPlugin) ListApprovalsHandler(c *gin.Context) {
+	stateFilter := c.Query("state")
+	clusterFilter := c.Query("cluster")
+
+	approvals := []Approval{}
+	if cp.approvals != nil {
+		for _, approval := range cp.approvals.List() {
+			if !visibleTo(c.Request.Context(), approval.Tenant) {
+				continue
+			}
+			if stateFilter != "" && string(approval.State) != stateFilter {
+				continue
+			}
+			if clusterFilter != "" && approval.ClusterName != clusterFilter {
+				continue
+			}
+			approvals = append(approvals, approval)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"approvals": approvals,
+		"total":     len(approvals),
+		"policy":    cp.requireApproval,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// ApproveHandler confirms a pending approval and runs the held operation as
+// it was requested, answering as the operation's own endpoint would
+func (cp *ClusterPlugin) ApproveHandler(c *gin.Context) {
+	if cp.approvals == nil {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, "Approvals are not enabled")
+		return
+	}
+	caller, ok := principal(c)
+	if !ok {
+		respondProblem(c, http.StatusUnauthorized, CodeUnauthenticated, "Approvals must be made by an authenticated caller")
+		return
+	}
+
+	id := c.Param("id")
+	if pending, exists := cp.approvals.Get(id); exists && !visibleTo(c.Request.Context(), pending.Tenant) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Approval '%s' not found", id))
+		return
+	}
+	approval, err := cp.approvals.Approve(id, caller.Subject)
+	switch {
+	case errors.Is(err, errApprovalNotFound):
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Approval '%s' not found", id))
+		return
+	case errors.Is(err, errSelfApproval):
+		respondProblem(c, http.StatusForbidden, CodePermissionDenied, err.Error())
+		return
+	case err != nil:
+		respondProblem(c, http.StatusConflict, CodeConflict, err.Error())
+		return
+	}
+	requestLogger(c).Info("Operation approved", "approval", id, "operation", approval.Operation,
+		"cluster", approval.ClusterName, "requestedBy", approval.RequestedBy, "approvedBy", approval.ApprovedBy)
+	if approval.ClusterName != "" {
+		cp.logs.Append(approval.ClusterName, "info", fmt.Sprintf("Detachment requested by %s approved by %s", approval.RequestedBy, approval.ApprovedBy))
+	}
+
+	cp.detach(c, approval.request, approval.kubeconfig)
+	if status := c.Writer.Status(); status >= http.StatusMultipleChoices {
+		cp.approvals.Fail(id, fmt.Sprintf("Approved by %s, but the detachment failed to start (HTTP %d)", approval.ApprovedBy, status))
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// approvalRouter serves detach and the approval endpoints, taking the
+// caller's subject from the X-Test-User header in place of a verified token
+func approvalRouter(t *testing.T, policy string) (*ClusterPlugin, func(method, path, user, body string) *httptest.ResponseRecorder) {
+	t.Helper()
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"auth":            map[string]interface{}{"enabled": true, "signingKeys": []interface{}{map[string]interface{}{"algorithm": "HS256", "secret": "test"}}},
+		"requireApproval": policy,
+		"detachSafety":    detachSafetyOff,
+	})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-Test-User"); user != "" {
+			c.Set(principalKey, Principal{Subject: user})
+		}
+	})
+	router.POST("/detach", plugin.DetachClusterHandler)
+	router.GET("/approvals", plugin.ListApprovalsHandler)
+	router.POST("/approvals/:id/approve", plugin.ApproveHandler)
+	return plugin, func(method, path, user, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			request.Header.Set("X-Test-User", user)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+}
+
+func TestDetachApproval(t *testing.T) {
+	plugin, serve := approvalRouter(t, approvalForce)
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-2", Status: "Ready"})
+
+	// Only forced detachments wait under the force policy
+	if recorder := serve(http.MethodPost, "/detach", "alice", `{"clusterName": "edge-2"}`); recorder.Code != http.StatusOK {
+		t.Fatalf("unforced detach = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder := serve(http.MethodPost, "/detach", "alice", `{"clusterName": "edge-1", "force": true}`)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("forced detach = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var held struct {
+		Approval Approval `json:"approval"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &held)
+	if held.Approval.State != ApprovalPending || held.Approval.RequestedBy != "alice" || !held.Approval.Force {
+		t.Fatalf("approval = %+v", held.Approval)
+	}
+	if cluster, _, _ := plugin.store.Get("edge-1"); cluster.Status != "Ready" {
+		t.Errorf("held cluster status = %s", cluster.Status)
+	}
+	if recorder := serve(http.MethodPost, "/detach", "carol", `{"clusterName": "edge-1", "force": true}`); recorder.Code != http.StatusConflict {
+		t.Errorf("second forced detach = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = serve(http.MethodGet, "/approvals?state=Pending", "", "")
+	var listed struct {
+		Approvals []Approval `json:"approvals"`
+		Policy    string     `json:"policy"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &listed)
+	if len(listed.Approvals) != 1 || listed.Approvals[0].ID != held.Approval.ID || listed.Policy != approvalForce {
+		t.Fatalf("approvals = %s", recorder.Body.String())
+	}
+
+	approve := "/approvals/" + held.Approval.ID + "/approve"
+	if recorder := serve(http.MethodPost, approve, "", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous approval = %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodPost, approve, "alice", ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("self approval = %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = serve(http.MethodPost, approve, "bob", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("approval = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var started DetachResponse
+	json.Unmarshal(recorder.Body.Bytes(), &started)
+	if started.JobID == "" {
+		t.Errorf("approved detach = %s", recorder.Body.String())
+	}
+	if approval, _ := plugin.approvals.Get(held.Approval.ID); approval.State != ApprovalApproved || approval.ApprovedBy != "bob" {
+		t.Errorf("approved approval = %+v", approval)
+	}
+	if recorder := serve(http.MethodPost, approve, "carol", ""); recorder.Code != http.StatusConflict {
+		t.Errorf("second approval = %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodPost, "/approvals/approval-unknown/approve", "bob", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown approval = %d", recorder.Code)
+	}
+}
+
+func TestApprovalExpiry(t *testing.T) {
+	approvals := NewApprovalManager(-time.Second, 1)
+	for _, cluster := range []string{"edge-1", "edge-2"} {
+		if _, err := approvals.Add(context.Background(), Approval{Operation: "detach", ClusterName: cluster, RequestedBy: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listed := approvals.List()
+	if len(listed) != 1 || listed[0].State != ApprovalExpired {
+		t.Fatalf("approvals = %+v, want one expired approval kept", listed)
+	}
+	if _, err := approvals.Approve(listed[0].ID, "bob"); err == nil {
+		t.Error("expired approval approved")
+	}
+}
+
+func TestRequireApprovalConfig(t *testing.T) {
+	for _, config := range []map[string]interface{}{
+		{"requireApproval": approvalDetach},
+		{"requireApproval": "always"},
+	} {
+		dir := t.TempDir()
+		config["storePath"] = filepath.Join(dir, "clusters.db")
+		config["scheduleStorePath"] = filepath.Join(dir, "schedules.json")
+		config["hubStoreDir"] = t.TempDir()
+		config["encryptionKey"] = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+		config["monitorHeartbeats"] = false
+		config["logLevel"] = "error"
+		plugin := &ClusterPlugin{}
+		if err := plugin.Initialize(config); err == nil {
+			plugin.Cleanup()
+			t.Errorf("Initialize(%v) accepted", config)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditEventType is sent in the event header of audit webhook deliveries
+const auditEventType = "audit.entry"
+
+// AuditEntry records one state-changing request
+type AuditEntry struct {
+	ID        string `json:"id"`
+	Timestamp string `json:"timestamp"`
+	RequestID string `json:"requestId,omitempty"`
+	// Subject is the authenticated caller, empty for anonymous requests
+	Subject string `json:"subject,omitempty"`
+	// Tenant is the tenant the request was made for
+	Tenant      string `json:"tenant,omitempty"`
+	ClientIP    string `json:"clientIp,omitempty"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Handler     string `json:"handler"`
+	ClusterName string `json:"clusterName,omitempty"`
+	// PayloadHash is the SHA-256 of the request body, which is never stored
+	// since it may hold kubeconfigs
+	PayloadHash string `json:"payloadHash,omitempty"`
+	StatusCode  int    `json:"statusCode"`
+	// Result is success for 2xx responses, denied for 401, 403 and 429, and
+	// failure otherwise
+	Result     string `json:"result"`
+	DurationMs int64  `json:"durationMs"`
+	// Admission logs the admission policy decisions made for the request
+	Admission []AdmissionDecision `json:"admission,omitempty"`
+	// Changes lists the settings a PUT /settings changed
+	Changes []SettingChange `json:"changes,omitempty"`
+}
+
+// auditSink exports audit entries outside the plugin
+type auditSink interface {
+	Write(entry AuditEntry) error
+	Close() error
+}
+
+// AuditLog is an append-only history of state-changing requests. The newest
+// retention entries are kept in memory for GET /audit; sinks keep them all.
+type AuditLog struct {
+	entries   []AuditEntry
+	retention int
+	sinks     []auditSink
+	mutex     sync.RWMutex
+}
+
+// NewAuditLog creates an audit log keeping retention entries in memory
+func NewAuditLog(retention int, sinks ...auditSink) *AuditLog {
+	if retention < 1 {
+		retention = 1
+	}
+	return &AuditLog{retention: retention, sinks: sinks}
+}
+
+// Record appends entry to the log and its sinks
+func (al *AuditLog) Record(entry AuditEntry) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	al.append(entry)
+	// Sinks don't block, so they are written under the lock to keep Close
+	// from closing one in between
+	for _, sink := range al.sinks {
+		if err := sink.Write(entry); err != nil {
+			logger().Warn("Failed to export audit entry", "entry", entry.ID, "error", err)
+		}
+	}
+}
+
+func (al *AuditLog) append(entry AuditEntry) {
+	al.entries = append(al.entries, entry)
+	if excess := len(al.entries) - al.retention; excess > 0 {
+		al.entries = append([]AuditEntry(nil), al.entries[excess:]...)
+	}
+}
+
+// AuditQuery selects audit entries; zero values match everything
+type AuditQuery struct {
+	Since       time.Time
+	Until       time.Time
+	ClusterName string
+	Subject     string
+	Limit       int
+}
+
+// Query returns the matching entries, newest first
+func (al *AuditLog) Query(query AuditQuery) []AuditEntry {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	matched := []AuditEntry{}
+	for i := len(al.entries) - 1; i >= 0; i-- {
+		entry := al.entries[i]
+		if query.ClusterName != "" && entry.ClusterName != query.ClusterName {
+			continue
+		}
+		if query.Subject != "" && entry.Subject != query.Subject {
+			continue
+		}
+		timestamp, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err != nil {
+			continue
+		}
+		if !query.Since.IsZero() && timestamp.Before(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && timestamp.After(query.Until) {
+			continue
+		}
+		matched = append(matched, entry)
+		if query.Limit > 0 && len(matched) == query.Limit {
+			break
+		}
+	}
+	return matched
+}
+
+// Close closes the sinks
+func (al *AuditLog) Close() {
+	al.mutex.Lock()
+	sinks := al.sinks
+	al.sinks = nil
+	al.mutex.Unlock()
+
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			logger().Warn("Failed to close audit sink", "error", err)
+		}
+	}
+}
+
+// newAuditLogFromConfig creates the audit log with the file and webhook sinks
+// the config asks for. Entries already in the file are reloaded, so history
+// survives restarts.
+func newAuditLogFromConfig(config map[string]interface{}) (*AuditLog, error) {
+	auditLog := NewAuditLog(configInt(config, "auditRetention", 1000))
+
+	if path := configString(config, "auditLogPath", ""); path != "" {
+		sink, existing, err := openFileAuditSink(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range existing {
+			auditLog.append(entry)
+		}
+		auditLog.sinks = append(auditLog.sinks, sink)
+	}
+	if target := configString(config, "auditWebhookURL", ""); target != "" {
+		sink, err := newWebhookAuditSink(target, configString(config, "auditWebhookSecret", ""))
+		if err != nil {
+			auditLog.Close()
+			return nil, err
+		}
+		auditLog.sinks = append(auditLog.sinks, sink)
+	}
+	return auditLog, nil
+}
+
+// fileAuditSink appends entries as JSON lines to a file
+type fileAuditSink struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// openFileAuditSink opens path for appending and returns the entries it holds
+func openFileAuditSink(path string) (*fileAuditSink, []AuditEntry, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		// A line cut short by a crash is skipped rather than failing startup
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+	return &fileAuditSink{file: file}, entries, nil
+}
+
+func (s *fileAuditSink) Write(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *fileAuditSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+// webhookAuditSink POSTs entries to a URL from a background worker, signed
+// like lifecycle webhooks. Entries are dropped when the queue is full so a
+// slow receiver never holds up requests.
+type webhookAuditSink struct {
+	url    string
+	secret string
+	client *http.Client
+	queue  chan AuditEntry
+	done   chan struct{}
+}
+
+func newWebhookAuditSink(target, secret string) (*webhookAuditSink, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("auditWebhookURL must be an absolute http or https URL")
+	}
+	s := &webhookAuditSink{
+		url:    target,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan AuditEntry, 1000),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *webhookAuditSink) Write(entry AuditEntry) error {
+	select {
+	case s.queue <- entry:
+		return nil
+	default:
+		return fmt.Errorf("audit webhook queue is full")
+	}
+}
+
+func (s *webhookAuditSink) run() {
+	defer close(s.done)
+	for entry := range s.queue {
+		if err := s.post(entry); err != nil {
+			logger().Warn("Audit webhook delivery failed", "entry", entry.ID, "error", err)
+		}
+	}
+}
+
+func (s *webhookAuditSink) post(entry AuditEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookEventHeader, auditEventType)
+	request.Header.Set(webhookDeliveryHeader, entry.ID)
+	if s.secret != "" {
+		request.Header.Set(webhookSignatureHeader, signWebhookBody(s.secret, body))
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("receiver answered %s", response.Status)
+	}
+	return nil
+}
+
+// Close stops the worker after it delivers the queued entries, waiting up to
+// five seconds for it
+func (s *webhookAuditSink) Close() error {
+	close(s.queue)
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("%d audit entries not delivered on shutdown", len(s.queue))
+	}
+}
+
+// withAudit wraps a handler so that every state-changing request is recorded
+// in the audit log, including the ones rejected by the checks it wraps
+func (cp *ClusterPlugin) withAudit(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		auditLog := cp.audit
+		cp.mutex.RUnlock()
+
+		method := c.Request.Method
+		if auditLog == nil || method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+			handler(c)
+			return
+		}
+
+		entry := AuditEntry{
+			ID:        newJobID("audit"),
+			RequestID: requestID(c),
+			ClientIP:  c.ClientIP(),
+			Method:    method,
+			Path:      c.Request.URL.Path,
+			Handler:   handlerName,
+		}
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			if body, err = io.ReadAll(c.Request.Body); err != nil {
+				abortWithProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Failed to read request body")
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if len(body) > 0 {
+			sum := sha256.Sum256(body)
+			entry.PayloadHash = hex.EncodeToString(sum[:])
+		}
+		entry.ClusterName = auditClusterName(c, body)
+		trail := &admissionTrail{}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), admissionTrailContextKey{}, trail))
+
+		start := time.Now()
+		handler(c)
+
+		if caller, ok := principal(c); ok {
+			entry.Subject = caller.Subject
+		}
+		entry.Tenant = c.GetString(tenantKey)
+		entry.Timestamp = start.Format(time.RFC3339)
+		entry.DurationMs = time.Since(start).Milliseconds()
+		entry.StatusCode = c.Writer.Status()
+		entry.Admission = trail.list()
+		if changes, ok := c.Get(auditChangesKey); ok {
+			entry.Changes, _ = changes.([]SettingChange)
+		}
+		switch {
+		case entry.StatusCode < 300:
+			entry.Result = "success"
+		case entry.StatusCode == http.StatusUnauthorized || entry.StatusCode == http.StatusForbidden || entry.StatusCode == http.StatusTooManyRequests:
+			entry.Result = "denied"
+		default:
+			entry.Result = "failure"
+		}
+		auditLog.Record(entry)
+	}
+}
+
+// auditClusterName finds the cluster a request targets, from the path or a
+// clusterName field of a JSON body
+func auditClusterName(c *gin.Context, body []byte) string {
+	for _, param := range []string{"name", "cluster"} {
+		if value := c.Param(param); value != "" {
+			return value
+		}
+	}
+	if len(body) > 0 && strings.HasPrefix(c.ContentType(), "application/json") {
+		var payload struct {
+			ClusterName string `json:"clusterName"`
+		}
+		if json.Unmarshal(body, &payload) == nil {
+			return payload.ClusterName
+		}
+	}
+	return ""
+}
+
+// ListAuditHandler returns audit entries, newest first, filtered by ?cluster,
+// ?subject and the ?since and ?until RFC 3339 time range
+func (cp *ClusterPlugin) ListAuditHandler(c *gin.Context) {
+	query := AuditQuery{
+		ClusterName: c.Query("cluster"),
+		Subject:     c.Query("subject"),
+	}
+	for param, target := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if raw := c.Query(param); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid %s %q, must be an RFC 3339 time", param, raw))
+				return
+			}
+			*target = parsed
+		}
+	}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 0 {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid limit %q", raw))
+			return
+		}
+		query.Limit = limit
+	}
+
+	cp.mutex.RLock()
+	auditLog := cp.audit
+	cp.mutex.RUnlock()
+
+	entries := []AuditEntry{}
+	if auditLog != nil {
+		entries = auditLog.Query(query)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"entries":   entries,
+		"total":     len(entries),
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
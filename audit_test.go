@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWithAudit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := newAuditLogFromConfig(map[string]interface{}{"auditLogPath": path})
+	if err != nil {
+		t.Fatalf("newAuditLogFromConfig() error = %v", err)
+	}
+	plugin := &ClusterPlugin{audit: auditLog}
+
+	router := gin.New()
+	router.POST("/detach", plugin.withAudit("DetachClusterHandler", func(c *gin.Context) {
+		c.Set(principalKey, Principal{Subject: "alice"})
+		c.Status(http.StatusNotFound)
+	}))
+	router.PATCH("/clusters/:name/labels", plugin.withAudit("PatchClusterLabelsHandler", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	}))
+	router.GET("/status", plugin.withAudit("GetClusterStatusHandler", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	}))
+
+	requests := []struct{ method, path, body string }{
+		{http.MethodPost, "/detach", `{"clusterName":"prod"}`},
+		{http.MethodPatch, "/clusters/dev/labels", `{"labels":{"env":"dev"}}`},
+		{http.MethodGet, "/status", ""},
+	}
+	for _, r := range requests {
+		request := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	entries := auditLog.Query(AuditQuery{})
+	if len(entries) != 2 {
+		t.Fatalf("recorded %d entries, want 2 (reads aren't audited)", len(entries))
+	}
+	detach := entries[1]
+	if detach.ClusterName != "prod" || detach.Subject != "alice" || detach.Result != "failure" || len(detach.PayloadHash) != 64 {
+		t.Errorf("detach entry = %+v", detach)
+	}
+	if got := auditLog.Query(AuditQuery{ClusterName: "dev"}); len(got) != 1 || got[0].Result != "success" {
+		t.Errorf("Query(cluster=dev) = %+v", got)
+	}
+	if got := auditLog.Query(AuditQuery{Since: time.Now().Add(time.Hour)}); len(got) != 0 {
+		t.Errorf("Query(since=future) = %+v", got)
+	}
+
+	// History is reloaded from the file
+	auditLog.Close()
+	reloaded, err := newAuditLogFromConfig(map[string]interface{}{"auditLogPath": path})
+	if err != nil {
+		t.Fatalf("newAuditLogFromConfig() error = %v", err)
+	}
+	defer reloaded.Close()
+	if got := reloaded.Query(AuditQuery{}); len(got) != 2 || got[0].ID != entries[0].ID {
+		t.Errorf("reloaded entries = %+v", got)
+	}
+}
+
+func TestInitializeInvalidAuditConfig(t *testing.T) {
+	dir := t.TempDir()
+	config := map[string]interface{}{
+		"storePath":         filepath.Join(dir, "clusters.db"),
+		"scheduleStorePath": filepath.Join(dir, "schedules.json"),
+		"hubStoreDir":       t.TempDir(),
+		"encryptionKey":     base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)),
+		"monitorHeartbeats": false,
+		"logLevel":          "error",
+		// The store is a file, so nothing can be created below it
+		"auditLogPath": filepath.Join(dir, "clusters.db", "audit.log"),
+		"updateCheck":  map[string]interface{}{"url": "https://releases.example.com/latest"},
+	}
+	plugin := &ClusterPlugin{}
+	if err := plugin.Initialize(config); err == nil {
+		t.Fatal("Initialize() with an unwritable audit log succeeded")
+	}
+	if plugin.hub == nil || plugin.hub.cancel != nil {
+		t.Error("Initialize() started the ManagedCluster watcher before failing")
+	}
+	if plugin.updates == nil || plugin.updates.cancel != nil {
+		t.Error("Initialize() started the update checker before failing")
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey is the gin context key holding the authenticated caller
+const principalKey = "principal"
+
+// principalContextKey stores the authenticated caller on the request context
+// too, for the checks work started by a handler runs
+type principalContextKey struct{}
+
+// clockSkew is the leeway allowed when checking token lifetimes
+const clockSkew = 30 * time.Second
+
+// errTokenInactive is returned for tokens the introspection endpoint rejects
+var errTokenInactive = errors.New("token is not active")
+
+// errTokenExpired is returned for tokens past their exp claim
+var errTokenExpired = errors.New("token has expired")
+
+// Principal is the caller a bearer token was issued to
+type Principal struct {
+	Subject string `json:"subject"`
+	// Method is how the token was verified: jwt or introspection
+	Method string   `json:"method"`
+	Scopes []string `json:"scopes,omitempty"`
+	// Claims holds every claim of the token, for the checks layered on top
+	Claims map[string]interface{} `json:"-"`
+}
+
+// SigningKey is a key trusted to sign bearer tokens
+type SigningKey struct {
+	// ID matches the kid header of the tokens; tokens without one try every key
+	ID string `json:"id,omitempty"`
+	// Algorithm is HS256, RS256 or ES256
+	Algorithm string `json:"algorithm"`
+	// Secret is the shared HMAC secret for HS256
+	Secret string `json:"secret,omitempty"`
+	// PublicKey is the PEM encoded public key for RS256 and ES256
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// IntrospectionConfig points at an RFC 7662 token introspection endpoint
+type IntrospectionConfig struct {
+	URL          string `json:"url"`
+	ClientID     string `json:"clientId,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// CacheSeconds is how long an active token is trusted before asking again
+	CacheSeconds int `json:"cacheSeconds,omitempty"`
+}
+
+// AuthConfig is the auth section of the Initialize config
+type AuthConfig struct {
+	Enabled bool `json:"enabled"`
+	// PublicReads lets GET requests through without a token
+	PublicReads bool `json:"publicReads,omitempty"`
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer        string               `json:"issuer,omitempty"`
+	Audience      string               `json:"audience,omitempty"`
+	SigningKeys   []SigningKey         `json:"signingKeys,omitempty"`
+	Introspection *IntrospectionConfig `json:"introspection,omitempty"`
+}
+
+func authConfigFromConfig(config map[string]interface{}) (AuthConfig, error) {
+	var ac AuthConfig
+	raw, ok := config["auth"]
+	if !ok {
+		return ac, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return ac, fmt.Errorf("invalid auth config: %w", err)
+	}
+	if err := json.Unmarshal(data, &ac); err != nil {
+		return ac, fmt.Errorf("invalid auth config: %w", err)
+	}
+	return ac, nil
+}
+
+// verifier checks the signature of a token signed with one trusted key
+type verifier struct {
+	id        string
+	algorithm string
+	verify    func(signed, signature []byte) error
+}
+
+type cachedPrincipal struct {
+	principal Principal
+	expiresAt time.Time
+}
+
+// authenticator verifies bearer tokens against the trusted signing keys or
+// the introspection endpoint
+type authenticator struct {
+	config    AuthConfig
+	verifiers []verifier
+	client    *http.Client
+	// cache remembers active introspected tokens, keyed by their hash
+	cache map[string]cachedPrincipal
+	mutex sync.Mutex
+}
+
+func newAuthenticator(config AuthConfig) (*authenticator, error) {
+	if len(config.SigningKeys) == 0 && config.Introspection == nil {
+		return nil, fmt.Errorf("auth requires signingKeys or an introspection endpoint")
+	}
+	a := &authenticator{
+		config: config,
+		client: &http.Client{Timeout: 5 * time.Second},
+		cache:  make(map[string]cachedPrincipal),
+	}
+	for i, key := range config.SigningKeys {
+		v, err := newVerifier(key)
+		if err != nil {
+			return nil, fmt.Errorf("signingKeys[%d]: %w", i, err)
+		}
+		a.verifiers = append(a.verifiers, v)
+	}
+	if config.Introspection != nil {
+		if parsed, err := url.Parse(config.Introspection.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("introspection.url must be an absolute http(s) URL")
+		}
+	}
+	return a, nil
+}
+
+func newVerifier(key SigningKey) (verifier, error) {
+	v := verifier{id: key.ID, algorithm: key.Algorithm}
+	switch key.Algorithm {
+	case "HS256":
+		if key.Secret == "" {
+			return v, fmt.Errorf("HS256 requires a secret")
+		}
+		secret := []byte(key.Secret)
+		v.verify = func(signed, signature []byte) error {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(signed)
+			if !hmac.Equal(mac.Sum(nil), signature) {
+				return errors.New("signature mismatch")
+			}
+			return nil
+		}
+	case "RS256", "ES256":
+		block, _ := pem.Decode([]byte(key.PublicKey))
+		if block == nil {
+			return v, fmt.Errorf("%s requires a PEM encoded publicKey", key.Algorithm)
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return v, fmt.Errorf("invalid publicKey: %w", err)
+		}
+		if key.Algorithm == "RS256" {
+			public, ok := parsed.(*rsa.PublicKey)
+			if !ok {
+				return v, fmt.Errorf("RS256 requires an RSA publicKey")
+			}
+			v.verify = func(signed, signature []byte) error {
+				digest := sha256.Sum256(signed)
+				return rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature)
+			}
+		} else {
+			public, ok := parsed.(*ecdsa.PublicKey)
+			if !ok {
+				return v, fmt.Errorf("ES256 requires an ECDSA publicKey")
+			}
+			v.verify = func(signed, signature []byte) error {
+				if len(signature) != 64 {
+					return errors.New("malformed signature")
+				}
+				digest := sha256.Sum256(signed)
+				r := new(big.Int).SetBytes(signature[:32])
+				s := new(big.Int).SetBytes(signature[32:])
+				if !ecdsa.Verify(public, digest[:], r, s) {
+					return errors.New("signature mismatch")
+				}
+				return nil
+			}
+		}
+	default:
+		return v, fmt.Errorf("unsupported algorithm %q, must be HS256, RS256 or ES256", key.Algorithm)
+	}
+	return v, nil
+}
+
+// Authenticate verifies token and returns the caller it was issued to. JWTs
+// are checked locally when signing keys are configured; any other token is
+// sent to the introspection endpoint.
+func (a *authenticator) Authenticate(ctx context.Context, token string) (Principal, error) {
+	if len(a.verifiers) > 0 && strings.Count(token, ".") == 2 {
+		return a.verifyJWT(token)
+	}
+	if a.config.Introspection != nil {
+		return a.introspect(ctx, token)
+	}
+	return Principal{}, errors.New("token is not a JWT")
+}
+
+func (a *authenticator) verifyJWT(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("malformed token signature: %w", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, v := range a.verifiers {
+		if v.algorithm != header.Algorithm || (header.KeyID != "" && v.id != "" && v.id != header.KeyID) {
+			continue
+		}
+		if v.verify(signed, signature) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return Principal{}, fmt.Errorf("no trusted %s key verifies the token", header.Algorithm)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := a.checkClaims(claims); err != nil {
+		return Principal{}, err
+	}
+	return newPrincipal("jwt", claims), nil
+}
+
+// checkClaims checks the lifetime, issuer and audience of a token
+func (a *authenticator) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return errTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return fmt.Errorf("token issuer is not %s", a.config.Issuer)
+	}
+	if a.config.Audience != "" && !claimContains(claims["aud"], a.config.Audience) {
+		return fmt.Errorf("token audience does not include %s", a.config.Audience)
+	}
+	return nil
+}
+
+func (a *authenticator) introspect(ctx context.Context, token string) (Principal, error) {
+	sum := sha256.Sum256([]byte(token))
+	cacheKey := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	a.mutex.Lock()
+	cached, exists := a.cache[cacheKey]
+	if exists && now.Before(cached.expiresAt) {
+		a.mutex.Unlock()
+		return cached.principal, nil
+	}
+	delete(a.cache, cacheKey)
+	a.mutex.Unlock()
+
+	introspection := a.config.Introspection
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, introspection.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Principal{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if introspection.ClientID != "" {
+		req.SetBasicAuth(introspection.ClientID, introspection.ClientSecret)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return Principal{}, fmt.Errorf("token introspection failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Principal{}, fmt.Errorf("token introspection failed: HTTP %d", resp.StatusCode)
+	}
+	var claims map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return Principal{}, fmt.Errorf("invalid introspection response: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return Principal{}, errTokenInactive
+	}
+	if err := a.checkClaims(claims); err != nil {
+		return Principal{}, err
+	}
+
+	principal := newPrincipal("introspection", claims)
+	ttl := time.Duration(introspection.CacheSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	expiresAt := now.Add(ttl)
+	if exp, ok := claims["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(expiresAt) {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for key, entry := range a.cache {
+		if now.After(entry.expiresAt) {
+			delete(a.cache, key)
+		}
+	}
+	a.cache[cacheKey] = cachedPrincipal{principal: principal, expiresAt: expiresAt}
+	return principal, nil
+}
+
+func newPrincipal(method string, claims map[string]interface{}) Principal {
+	principal := Principal{Method: method, Claims: claims}
+	principal.Subject, _ = claims["sub"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		principal.Scopes = strings.Fields(scope)
+	} else if scopes, ok := claims["scp"].([]interface{}); ok {
+		for _, scope := range scopes {
+			if s, ok := scope.(string); ok {
+				principal.Scopes = append(principal.Scopes, s)
+			}
+		}
+	}
+	return principal
+}
+
+// claimContains reports whether a string or string array claim holds want
+func claimContains(claim interface{}, want string) bool {
+	switch value := claim.(type) {
+	case string:
+		return value == want
+	case []interface{}:
+		for _, item := range value {
+			if item == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// bearerToken extracts the token of an Authorization: Bearer header
+func bearerToken(header string) (string, bool) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// principal returns the authenticated caller of the request, if any
+func principal(c *gin.Context) (Principal, bool) {
+	value, exists := c.Get(principalKey)
+	if !exists {
+		return Principal{}, false
+	}
+	p, ok := value.(Principal)
+	return p, ok
+}
+
+// principalFromContext returns the caller stored on a request context
+func principalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(Principal)
+	return p, ok
+}
+
+// withAuthentication wraps a handler so that it only runs for callers with a
+// valid bearer token. Reads may be left public with publicReads.
+func (cp *ClusterPlugin) withAuthentication(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		auth := cp.auth
+		cp.mutex.RUnlock()
+
+		if auth == nil {
+			handler(c)
+			return
+		}
+
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			if auth.config.PublicReads && c.Request.Method == http.MethodGet {
+				handler(c)
+				return
+			}
+			rejectUnauthenticated(c, CodeUnauthenticated, "Missing bearer token")
+			return
+		}
+		caller, err := auth.Authenticate(c.Request.Context(), token)
+		if err != nil {
+			requestLogger(c).Warn("Rejected bearer token", "method", c.Request.Method, "path", c.Request.URL.Path, "error", err)
+			if errors.Is(err, errTokenExpired) {
+				rejectUnauthenticated(c, CodeTokenExpired, "Bearer token has expired")
+				return
+			}
+			rejectUnauthenticated(c, CodeUnauthenticated, "Invalid bearer token")
+			return
+		}
+		c.Set(principalKey, caller)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), principalContextKey{}, caller))
+		handler(c)
+	}
+}
+
+func rejectUnauthenticated(c *gin.Context, code ErrorCode, message string) {
+	challenge := `Bearer realm="kubestellar-cluster-plugin"`
+	if code == CodeTokenExpired {
+		challenge += `, error="invalid_token", error_description="The token expired"`
+	}
+	c.Header("WWW-Authenticate", challenge)
+	abortWithProblem(c, http.StatusUnauthorized, code, message)
+}
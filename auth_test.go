@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func signHS256(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	auth, err := newAuthenticator(AuthConfig{
+		Enabled:     true,
+		Issuer:      "https://hub.example.com",
+		Audience:    "kubestellar-cluster-plugin",
+		SigningKeys: []SigningKey{{Algorithm: "HS256", Secret: "s3cret"}},
+	})
+	if err != nil {
+		t.Fatalf("newAuthenticator() error = %v", err)
+	}
+	valid := map[string]interface{}{
+		"sub":   "alice",
+		"iss":   "https://hub.example.com",
+		"aud":   []string{"kubestellar-cluster-plugin"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "cluster.read cluster.write",
+	}
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := make(map[string]interface{}, len(valid))
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[key] = value
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "valid", token: signHS256(t, "s3cret", valid)},
+		{name: "wrong key", token: signHS256(t, "other", valid), wantErr: true},
+		{name: "expired", token: signHS256(t, "s3cret", with("exp", time.Now().Add(-time.Hour).Unix())), wantErr: true},
+		{name: "wrong issuer", token: signHS256(t, "s3cret", with("iss", "https://evil.example.com")), wantErr: true},
+		{name: "wrong audience", token: signHS256(t, "s3cret", with("aud", "other")), wantErr: true},
+		{name: "opaque token", token: "opaque", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller, err := auth.Authenticate(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (caller.Subject != "alice" || len(caller.Scopes) != 2) {
+				t.Errorf("Authenticate() = %+v", caller)
+			}
+		})
+	}
+}
+
+func TestAuthenticateIntrospection(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if user, pass, _ := r.BasicAuth(); user != "plugin" || pass != "pw" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		json.NewEncoder(w).Encode(map[string]interface{}{"active": r.Form.Get("token") == "good", "sub": "bob"})
+	}))
+	defer server.Close()
+
+	auth, err := newAuthenticator(AuthConfig{
+		Enabled:       true,
+		Introspection: &IntrospectionConfig{URL: server.URL, ClientID: "plugin", ClientSecret: "pw"},
+	})
+	if err != nil {
+		t.Fatalf("newAuthenticator() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		caller, err := auth.Authenticate(context.Background(), "good")
+		if err != nil || caller.Subject != "bob" {
+			t.Fatalf("Authenticate(good) = %+v, %v", caller, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("introspection calls = %d, want 1 thanks to the cache", calls)
+	}
+	if _, err := auth.Authenticate(context.Background(), "bad"); err != errTokenInactive {
+		t.Errorf("Authenticate(bad) error = %v, want %v", err, errTokenInactive)
+	}
+}
+
+func TestWithAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth, err := newAuthenticator(AuthConfig{
+		Enabled:     true,
+		PublicReads: true,
+		SigningKeys: []SigningKey{{Algorithm: "HS256", Secret: "s3cret"}},
+	})
+	if err != nil {
+		t.Fatalf("newAuthenticator() error = %v", err)
+	}
+	plugin := &ClusterPlugin{auth: auth}
+	handler := plugin.withAuthentication(func(c *gin.Context) {
+		caller, _ := principal(c)
+		c.String(http.StatusOK, caller.Subject)
+	})
+	token := signHS256(t, "s3cret", map[string]interface{}{"sub": "alice"})
+	expired := signHS256(t, "s3cret", map[string]interface{}{"sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()})
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		wantStatus    int
+		wantBody      string
+		wantCode      ErrorCode
+	}{
+		{name: "public read", method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "anonymous write", method: http.MethodPost, wantStatus: http.StatusUnauthorized},
+		{name: "authenticated write", method: http.MethodPost, authorization: "Bearer " + token, wantStatus: http.StatusOK, wantBody: "alice"},
+		{name: "invalid token on read", method: http.MethodGet, authorization: "Bearer nope", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthenticated},
+		{name: "expired token", method: http.MethodPost, authorization: "Bearer " + expired, wantStatus: http.StatusUnauthorized, wantCode: CodeTokenExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(tt.method, "/onboard", nil)
+			if tt.authorization != "" {
+				c.Request.Header.Set("Authorization", tt.authorization)
+			}
+			handler(c)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && recorder.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", recorder.Body.String(), tt.wantBody)
+			}
+			if tt.wantCode != "" {
+				var problem Problem
+				if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil || problem.Code != tt.wantCode {
+					t.Errorf("problem = %s, want code %s", recorder.Body.String(), tt.wantCode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BatchItem links a cluster of a batch to its onboarding job
+type BatchItem struct {
+	// Row is the line or list position of the cluster in an imported manifest
+	Row         int      `json:"row,omitempty"`
+	ClusterName string   `json:"clusterName"`
+	JobID       string   `json:"jobId,omitempty"`
+	State       JobState `json:"state,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	// DryRun holds the validation outcome of an item submitted with dryRun
+	DryRun *DryRunResult `json:"dryRun,omitempty"`
+}
+
+// Batch groups the onboarding jobs submitted by a single batch request
+type Batch struct {
+	ID        string `json:"id"`
+	CreatedAt string `json:"createdAt"`
+	// Source is "import" for batches created by POST /clusters/import
+	Source string      `json:"source,omitempty"`
+	Items  []BatchItem `json:"items"`
+	// Tenant is the tenant of the request that submitted the batch
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// BatchManager keeps track of submitted batches
+type BatchManager struct {
+	batches map[string]*Batch
+	mutex   sync.RWMutex
+}
+
+// NewBatchManager creates an empty batch manager
+func NewBatchManager() *BatchManager {
+	return &BatchManager{batches: make(map[string]*Batch)}
+}
+
+// Add registers a batch
+func (bm *BatchManager) Add(batch *Batch) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	bm.batches[batch.ID] = batch
+}
+
+// Get returns a copy of a batch
+func (bm *BatchManager) Get(id string) (Batch, bool) {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+
+	batch, exists := bm.batches[id]
+	if !exists {
+		return Batch{}, false
+	}
+	snapshot := *batch
+	snapshot.Items = append([]BatchItem(nil), batch.Items...)
+	return snapshot, true
+}
+
+// beginRetry restarts onboarding of a recorded cluster that failed, with the
+// kubeconfig saved by its first attempt
+func (cp *ClusterPlugin) beginRetry(ctx context.Context, clusterName string, labels map[string]string) (batchTask, error) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	existing, exists, err := cp.store.Get(clusterName)
+	if err != nil {
+		return batchTask{}, fmt.Errorf("failed to read cluster store: %v", err)
+	}
+	if !exists {
+		return batchTask{}, errClusterNotFound
+	}
+	if existing.Status != "Failed" {
+		return batchTask{}, fmt.Errorf("cluster is not in a failed state (status: %s)", existing.Status)
+	}
+	kubeconfigData := cp.savedKubeconfig(clusterName)
+	if len(kubeconfigData) == 0 {
+		return batchTask{}, fmt.Errorf("no saved kubeconfig to retry onboarding with")
+	}
+
+	merged := make(map[string]string, len(existing.Labels)+len(labels))
+	for key, value := range existing.Labels {
+		merged[key] = value
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	if len(merged) == 0 {
+		merged = nil
+	}
+
+	if err := cp.jobs.Admit(); err != nil {
+		return batchTask{}, err
+	}
+	jobID := cp.jobs.Create(ctx, "onboard", clusterName).ID
+	cp.putStatus(ClusterStatus{
+		ClusterName: clusterName,
+		JobID:       jobID,
+		Status:      "Pending",
+		Message:     "Onboarding retried by batch",
+		LastUpdated: time.Now().Format(time.RFC3339),
+		Labels:      merged,
+	})
+	return batchTask{jobID: jobID, clusterName: clusterName, kubeconfigData: kubeconfigData, values: existing.ManifestValues}, nil
+}
+
+// batchTask is a single onboarding queued for the batch worker pool
+type batchTask struct {
+	jobID          string
+	clusterName    string
+	kubeconfigData []byte
+	values         *ManifestValues
+}
+
+// BatchOnboardHandler onboards several clusters at once using a bounded worker pool
+func (cp *ClusterPlugin) BatchOnboardHandler(c *gin.Context) {
+	var req BatchOnboardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	if (req.LabelSelector != "" || req.Group != "") && len(req.Clusters) > 0 {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "labelSelector and group cannot be combined with clusters")
+		return
+	}
+	if req.LabelSelector != "" && req.Group != "" {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "only one of labelSelector and group may be set")
+		return
+	}
+	if req.LabelSelector == "" && req.Group == "" && len(req.Clusters) == 0 {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "At least one cluster is required")
+		return
+	}
+	if len(req.Clusters) > cp.maxBatchSize {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Batch exceeds the maximum of %d clusters", cp.maxBatchSize))
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	batch := &Batch{
+		ID:        newJobID("batch"),
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	batch.Tenant, _ = tenantFromContext(c.Request.Context())
+	var tasks []batchTask
+
+	if req.LabelSelector != "" || req.Group != "" {
+		var selected []ClusterStatus
+		var err error
+		if req.Group != "" {
+			_, selected, err = cp.groupMembers(c.Request.Context(), req.Group)
+		} else {
+			selected, err = cp.selectClusters(req.LabelSelector)
+			selected = tenantClusters(c.Request.Context(), selected)
+		}
+		if errors.Is(err, errGroupNotFound) {
+			respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Group '%s' not found", req.Group))
+			return
+		}
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		if len(selected) > cp.maxBatchSize {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Selector matches more than the maximum of %d clusters", cp.maxBatchSize))
+			return
+		}
+		for _, cluster := range selected {
+			item := BatchItem{ClusterName: cluster.ClusterName}
+			if task, err := cp.beginRetry(c.Request.Context(), cluster.ClusterName, req.Labels); err != nil {
+				item.Error = err.Error()
+			} else {
+				item.JobID = task.jobID
+				item.State = JobPending
+				tasks = append(tasks, task)
+			}
+			batch.Items = append(batch.Items, item)
+		}
+	}
+
+	for _, spec := range req.Clusters {
+		item, task := cp.submitBatchCluster(c.Request.Context(), spec, req.Labels)
+		if task != nil {
+			tasks = append(tasks, *task)
+		}
+		batch.Items = append(batch.Items, item)
+	}
+
+	cp.batches.Add(batch)
+	go cp.runBatch(batch.ID, tasks)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     message(c, MsgBatchStarted, "count", len(tasks)),
+		"messageCode": MsgBatchStarted,
+		"batchId":     batch.ID,
+		"items":       batch.Items,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// submitBatchCluster validates one cluster of a batch and records its
+// onboarding job, which the returned task runs. The item carries the reason
+// when the cluster is rejected, and no task is returned.
+func (cp *ClusterPlugin) submitBatchCluster(ctx context.Context, spec OnboardRequest, batchLabels map[string]string) (BatchItem, *batchTask) {
+	item := BatchItem{ClusterName: spec.ClusterName}
+	if err := spec.Validate(); err != nil {
+		item.Error = err.Error()
+		return item, nil
+	}
+	if err := cp.checkCharts(spec.values()); err != nil {
+		item.Error = err.Error()
+		return item, nil
+	}
+
+	hub, err := cp.hubs.Get(spec.Hub)
+	if err != nil {
+		item.Error = err.Error()
+		return item, nil
+	}
+	labels := make(map[string]string, len(batchLabels)+len(spec.Labels))
+	for key, value := range batchLabels {
+		labels[key] = value
+	}
+	for key, value := range spec.Labels {
+		labels[key] = value
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+
+	if err := cp.admit(ctx, AdmissionInput{
+		Operation:   "onboard",
+		ClusterName: spec.ClusterName,
+		Hub:         hub.Name,
+		Provider:    providerName(spec.Provider),
+		Labels:      labels,
+		Annotations: spec.Annotations,
+		DryRun:      spec.DryRun,
+	}); err != nil {
+		item.Error = err.Error()
+		return item, nil
+	}
+	kubeconfigData, err := cp.resolveKubeconfig(ctx, spec)
+	if err != nil {
+		item.Error = err.Error()
+		return item, nil
+	}
+
+	if spec.DryRun {
+		plan := cp.planOnboarding(ctx, hub, spec.ClusterName, kubeconfigData, spec.values())
+		item.DryRun = &plan
+		if !plan.Valid {
+			item.Error = "dry run validation failed"
+		}
+		return item, nil
+	}
+
+	jobID, existing, err := cp.beginOnboarding(ctx, spec.ClusterName, hub.Name, providerName(spec.Provider), "", labels, spec.Annotations)
+	var violation *PolicyViolationError
+	switch {
+	case errors.Is(err, errJobQueueFull), errors.Is(err, errTenantConflict), errors.As(err, &violation):
+		item.Error = err.Error()
+	case err != nil:
+		item.Error = fmt.Sprintf("failed to read cluster store: %v", err)
+	case existing != nil:
+		item.Error = fmt.Sprintf("cluster is already onboarded (status: %s)", existing.Status)
+	default:
+		item.JobID = jobID
+		item.State = JobPending
+		return item, &batchTask{jobID: jobID, clusterName: spec.ClusterName, kubeconfigData: kubeconfigData, values: spec.values()}
+	}
+	return item, nil
+}
+
+// runBatch executes the onboarding jobs of a batch with bounded concurrency
+func (cp *ClusterPlugin) runBatch(batchID string, tasks []batchTask) {
+	queue := make(chan batchTask)
+	var wg sync.WaitGroup
+
+	workers := cp.batchConcurrency
+	if workers > len(tasks) {
+		workers = len(tasks)
+	}
+	// Register every task up front so queued ones survive a state handoff
+	for _, task := range tasks {
+		cp.trackJob(resumableJob{JobID: task.jobID, Type: "onboard", ClusterName: task.clusterName, ManifestValues: task.values, kubeconfig: task.kubeconfigData})
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				cp.jobs.Execute(task.jobID, cp.onboardingJob(task.jobID, task.clusterName, task.kubeconfigData, task.values))
+			}
+		}()
+	}
+
+	for _, task := range tasks {
+		queue <- task
+	}
+	close(queue)
+	wg.Wait()
+
+	logger().Info("Batch finished", "batch", batchID, "clusters", len(tasks))
+}
+
+// GetBatchHandler returns a batch with the current state of each of its jobs
+func (cp *ClusterPlugin) GetBatchHandler(c *gin.Context) {
+	id := c.Param("id")
+	batch, exists := cp.batches.Get(id)
+	if !exists || !visibleTo(c.Request.Context(), batch.Tenant) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Batch '%s' not found", id))
+		return
+	}
+
+	state, summary := cp.refreshBatch(&batch)
+	c.JSON(http.StatusOK, gin.H{
+		"batch":     batch,
+		"state":     state,
+		"summary":   summary,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// refreshBatch fills in the current state of the jobs of a batch snapshot and
+// returns the overall state with the number of items in each state
+func (cp *ClusterPlugin) refreshBatch(batch *Batch) (string, map[string]int) {
+	summary := map[string]int{"total": len(batch.Items), "rejected": 0}
+	finished := true
+	for i := range batch.Items {
+		item := &batch.Items[i]
+		if item.JobID == "" {
+			summary["rejected"]++
+			continue
+		}
+		if job, ok := cp.jobs.Get(item.JobID); ok {
+			item.State = job.State
+			if !job.Finished() {
+				finished = false
+			}
+		}
+		summary[string(item.State)]++
+	}
+
+	state := "Running"
+	if finished {
+		state = "Completed"
+		if summary[string(JobSucceeded)] < summary["total"] {
+			state = "CompletedWithErrors"
+		}
+	}
+	return state, summary
+}
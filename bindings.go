@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// defaultWDSContext is the kubeconfig context of the KubeStellar WDS the
+// BindingPolicies are applied to, as named by the getting started setup
+const defaultWDSContext = "wds1"
+
+// BindingPolicyConfig is the bindingPolicies section of the Initialize
+// config. Its templates render the BindingPolicies (or Placements on older
+// KubeStellar releases) that take an onboarded cluster into workload
+// distribution, usually by selecting it on its labels.
+type BindingPolicyConfig struct {
+	// Context selects the WDS in the plugin's kubeconfig, defaulting to wds1
+	Context   string            `json:"context,omitempty"`
+	Templates []BindingTemplate `json:"templates"`
+}
+
+// BindingTemplate is one template of the bindingPolicies config. It is
+// rendered with the same data as the manifest templates, with Labels holding
+// every label of the ManagedCluster and Hub the hub it joined.
+type BindingTemplate struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Template    string `json:"template"`
+	// DeleteOnDetach removes the rendered objects when the cluster is
+	// detached, for policies made for that cluster alone
+	DeleteOnDetach bool `json:"deleteOnDetach,omitempty"`
+}
+
+func bindingPolicyConfigFromConfig(config map[string]interface{}) (BindingPolicyConfig, error) {
+	bindings := BindingPolicyConfig{Context: defaultWDSContext}
+	raw, ok := config["bindingPolicies"]
+	if !ok {
+		return bindings, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return bindings, fmt.Errorf("invalid bindingPolicies config: %w", err)
+	}
+	if err := json.Unmarshal(data, &bindings); err != nil {
+		return bindings, fmt.Errorf("invalid bindingPolicies config: %w", err)
+	}
+	if bindings.Context == "" {
+		bindings.Context = defaultWDSContext
+	}
+	return bindings, nil
+}
+
+// manifestTemplates parses the binding templates into manifest templates
+// targeting the WDS
+func (bc BindingPolicyConfig) manifestTemplates() ([]ManifestTemplate, error) {
+	seen := make(map[string]bool, len(bc.Templates))
+	templates := make([]ManifestTemplate, 0, len(bc.Templates))
+	for i, bt := range bc.Templates {
+		if bt.Name == "" || bt.Template == "" {
+			return nil, fmt.Errorf("invalid bindingPolicies config: templates[%d] requires a name and a template", i)
+		}
+		if seen[bt.Name] {
+			return nil, fmt.Errorf("invalid bindingPolicies config: template %s is listed twice", bt.Name)
+		}
+		seen[bt.Name] = true
+		description := bt.Description
+		if description == "" {
+			description = "BindingPolicy applied to the WDS once the cluster joins"
+		}
+		t, err := parseManifestTemplate(ManifestTemplate{
+			Name:           "binding/" + bt.Name,
+			Target:         wdsTarget,
+			Description:    description,
+			DeleteOnDetach: bt.DeleteOnDetach,
+			Template:       bt.Template,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid bindingPolicies config: template %s: %w", bt.Name, err)
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// bindingData is the template data of a cluster's binding templates: the
+// labels are those the label step puts on the ManagedCluster, which the
+// policies select on
+func (cp *ClusterPlugin) bindingData(clusterName string, values ManifestValues) (manifestData, error) {
+	cp.mutex.RLock()
+	record, _, err := cp.store.Get(clusterName)
+	cp.mutex.RUnlock()
+	if err != nil {
+		return manifestData{}, fmt.Errorf("failed to read cluster record: %w", err)
+	}
+	data := cp.manifestData(clusterName, values)
+	data.Labels = onboardingLabels(clusterName, record.Labels)
+	data.Hub = hubName(record)
+	return data, nil
+}
+
+// applyBindingPolicies creates or updates the cluster's BindingPolicies on the WDS
+func (cp *ClusterPlugin) applyBindingPolicies(ctx context.Context, r *onboardingRun) error {
+	data, err := cp.bindingData(r.clusterName, r.values)
+	if err != nil {
+		return err
+	}
+	manifests, err := renderManifests(cp.manifestTemplates(), wdsTarget, false, data)
+	if err != nil {
+		return err
+	}
+	if len(manifests) == 0 {
+		return nil
+	}
+	_, wdsConfig, err := GetClientSetWithConfigContext(cp.wdsContext)
+	if err != nil {
+		return fmt.Errorf("failed to connect to WDS %s: %w", cp.wdsContext, err)
+	}
+	if err := applyManifests(ctx, wdsConfig, manifests); err != nil {
+		return err
+	}
+	logger().Info("BindingPolicies applied", "cluster", r.clusterName, "wds", cp.wdsContext, "count", len(manifests))
+	return nil
+}
+
+// removeBindingPolicies deletes the DeleteOnDetach BindingPolicies of a cluster from the WDS
+func (cp *ClusterPlugin) removeBindingPolicies(ctx context.Context, clusterName string) error {
+	var templates []ManifestTemplate
+	for _, t := range cp.manifestTemplates() {
+		if t.Target == wdsTarget && t.DeleteOnDetach {
+			templates = append(templates, t)
+		}
+	}
+	if len(templates) == 0 {
+		return nil
+	}
+	cp.mutex.RLock()
+	values := cp.manifestValues
+	cp.mutex.RUnlock()
+	data, err := cp.bindingData(clusterName, values)
+	if err != nil {
+		return err
+	}
+	manifests, err := renderManifests(templates, wdsTarget, false, data)
+	if err != nil || len(manifests) == 0 {
+		return err
+	}
+	_, wdsConfig, err := GetClientSetWithConfigContext(cp.wdsContext)
+	if err != nil {
+		return fmt.Errorf("failed to connect to WDS %s: %w", cp.wdsContext, err)
+	}
+	return deleteManifests(ctx, wdsConfig, manifests)
+}
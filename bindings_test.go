@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const testBindingTemplate = `apiVersion: control.kubestellar.io/v1alpha1
+kind: BindingPolicy
+metadata:
+  name: {{ .ClusterName }}-workloads
+spec:
+  clusterSelectors:
+  - matchLabels: {"name": "{{ index .Labels "name" }}", "env": "{{ index .Labels "env" }}", "hub": "{{ .Hub }}"}`
+
+func TestBindingPolicyConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      map[string]interface{}
+		wantContext string
+		wantCount   int
+		wantErr     string
+	}{
+		{name: "unset", config: map[string]interface{}{}, wantContext: defaultWDSContext},
+		{
+			name: "templates",
+			config: map[string]interface{}{"bindingPolicies": map[string]interface{}{
+				"context":   "wds2",
+				"templates": []interface{}{map[string]interface{}{"name": "workloads", "template": testBindingTemplate, "deleteOnDetach": true}},
+			}},
+			wantContext: "wds2",
+			wantCount:   1,
+		},
+		{
+			name: "missing template",
+			config: map[string]interface{}{"bindingPolicies": map[string]interface{}{
+				"templates": []interface{}{map[string]interface{}{"name": "workloads"}},
+			}},
+			wantErr: "requires a name and a template",
+		},
+		{
+			name: "duplicate",
+			config: map[string]interface{}{"bindingPolicies": map[string]interface{}{
+				"templates": []interface{}{
+					map[string]interface{}{"name": "workloads", "template": testBindingTemplate},
+					map[string]interface{}{"name": "workloads", "template": testBindingTemplate},
+				},
+			}},
+			wantErr: "listed twice",
+		},
+		{
+			name: "unparsable",
+			config: map[string]interface{}{"bindingPolicies": map[string]interface{}{
+				"templates": []interface{}{map[string]interface{}{"name": "workloads", "template": "{{ .ClusterName"}},
+			}},
+			wantErr: "template workloads",
+		},
+		{name: "invalid section", config: map[string]interface{}{"bindingPolicies": "wds1"}, wantErr: "invalid bindingPolicies config"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bindings, err := bindingPolicyConfigFromConfig(tt.config)
+			var templates []ManifestTemplate
+			if err == nil {
+				templates, err = bindings.manifestTemplates()
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if bindings.Context != tt.wantContext || len(templates) != tt.wantCount {
+				t.Errorf("context %s with %d templates, want %s with %d", bindings.Context, len(templates), tt.wantContext, tt.wantCount)
+			}
+			for _, template := range templates {
+				if template.Target != wdsTarget {
+					t.Errorf("template %s targets %s", template.Name, template.Target)
+				}
+			}
+		})
+	}
+}
+
+func TestBindingPolicies(t *testing.T) {
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"bindingPolicies": map[string]interface{}{
+		"templates": []interface{}{map[string]interface{}{"name": "workloads", "template": testBindingTemplate}},
+	}})
+	steps := make([]string, 0, len(plugin.onboarding))
+	for _, step := range plugin.onboarding {
+		steps = append(steps, step.name)
+	}
+	if steps[len(steps)-1] != "bind" {
+		t.Errorf("onboarding pipeline %v doesn't end with bind", steps)
+	}
+
+	// A configured pipeline has to bind the clusters itself
+	if _, err := pipelineFromConfig(map[string]interface{}{"onboardingPipeline": []interface{}{
+		map[string]interface{}{"name": "token"},
+		map[string]interface{}{"name": "apply-klusterlet"},
+	}}, true); err == nil || !strings.Contains(err.Error(), "must include bind") {
+		t.Errorf("pipeline without bind error = %v", err)
+	}
+
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready", Hub: "its2", Labels: map[string]string{"env": "prod"}})
+	data, err := plugin.bindingData("edge-1", plugin.manifestValues)
+	if err != nil {
+		t.Fatalf("bindingData() error = %v", err)
+	}
+	manifests, err := renderManifests(plugin.manifestTemplates(), wdsTarget, false, data)
+	if err != nil {
+		t.Fatalf("renderManifests() error = %v", err)
+	}
+	if len(manifests) != 1 {
+		t.Fatalf("rendered %d manifests, want 1", len(manifests))
+	}
+	for _, want := range []string{"name: edge-1-workloads", `"name": "edge-1"`, `"env": "prod"`, `"hub": "its2"`} {
+		if !strings.Contains(manifests[0], want) {
+			t.Errorf("rendered BindingPolicy lacks %s:\n%s", want, manifests[0])
+		}
+	}
+
+	// Without DeleteOnDetach templates detaching leaves the WDS alone
+	if err := plugin.removeBindingPolicies(context.Background(), "edge-1"); err != nil {
+		t.Errorf("removeBindingPolicies() error = %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Capabilities describes what this plugin version and configuration support,
+// so the host UI can enable the controls that apply
+type Capabilities struct {
+	Version    string `json:"version"`
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Mode is live, or mock when the plugin serves a generated fleet
+	Mode string `json:"mode"`
+	// Providers are the cloud and local providers onboarding can fetch
+	// kubeconfigs from, and ProvisioningTools those that create clusters
+	Providers         []string           `json:"providers"`
+	ProvisioningTools []string           `json:"provisioningTools"`
+	Batch             BatchCapabilities  `json:"batch"`
+	Streaming         StreamCapabilities `json:"streaming"`
+	Auth              AuthCapabilities   `json:"auth"`
+	Concurrency       ConcurrencyLimits  `json:"concurrency"`
+	Features          map[string]bool    `json:"features"`
+	// Languages are those response messages can be rendered in, chosen by
+	// the Accept-Language of each request
+	Languages []string `json:"languages"`
+	// Tags are the capabilities plugin.yaml declares
+	Tags []string `json:"tags,omitempty"`
+}
+
+// BatchCapabilities bounds batch onboarding and lists the inventory formats
+type BatchCapabilities struct {
+	Onboard       bool     `json:"onboard"`
+	MaxBatchSize  int      `json:"maxBatchSize"`
+	Concurrency   int      `json:"concurrency"`
+	ImportFormats []string `json:"importFormats"`
+	ExportFormats []string `json:"exportFormats"`
+}
+
+// StreamCapabilities lists the live feeds and how they are delivered
+type StreamCapabilities struct {
+	// StatusEvents streams cluster state transitions over Server-Sent Events
+	StatusEvents bool `json:"statusEvents"`
+	// OnboardingLogs streams onboarding logs over a WebSocket
+	OnboardingLogs bool `json:"onboardingLogs"`
+}
+
+// AuthCapabilities reports how callers are authenticated and authorized
+type AuthCapabilities struct {
+	Enabled bool `json:"enabled"`
+	// Methods are how bearer tokens are verified: jwt, introspection or both
+	Methods     []string `json:"methods,omitempty"`
+	PublicReads bool     `json:"publicReads,omitempty"`
+	RBAC        bool     `json:"rbac"`
+	Permissions []string `json:"permissions"`
+}
+
+// ConcurrencyLimits bounds how much work the plugin runs at once
+type ConcurrencyLimits struct {
+	// MaxJobs is how many onboarding and detach jobs run at once, 0 for no
+	// limit, and QueueSize how many more may wait
+	MaxJobs   int  `json:"maxJobs"`
+	QueueSize int  `json:"queueSize"`
+	RateLimit bool `json:"rateLimit"`
+}
+
+// capabilities collects what the plugin supports as configured
+func (cp *ClusterPlugin) capabilities() Capabilities {
+	metadata := cp.GetMetadata()
+	pool := cp.jobs.PoolStats()
+
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	mode := cp.mode
+	if mode == "" {
+		mode = modeLive
+	}
+	capabilities := Capabilities{
+		Version:           metadata.Version,
+		APIVersion:        metadata.APIVersion,
+		Mode:              mode,
+		Providers:         sortedNames(providers),
+		ProvisioningTools: sortedNames(localProvisioners),
+		Batch: BatchCapabilities{
+			Onboard:       true,
+			MaxBatchSize:  cp.maxBatchSize,
+			Concurrency:   cp.batchConcurrency,
+			ImportFormats: []string{"csv", "yaml", "terraform"},
+			ExportFormats: []string{"json", "yaml", "csv"},
+		},
+		Streaming: StreamCapabilities{StatusEvents: true, OnboardingLogs: true},
+		Auth: AuthCapabilities{
+			RBAC:        cp.rbac != nil,
+			Permissions: append([]string(nil), metadata.Permissions...),
+		},
+		Concurrency: ConcurrencyLimits{
+			MaxJobs:   pool.MaxConcurrency,
+			QueueSize: pool.QueueSize,
+			RateLimit: cp.rateLimiter != nil,
+		},
+		Features: map[string]bool{
+			"hubWatch":          cp.hub != nil,
+			"heartbeats":        cp.heartbeats != nil,
+			"tokenExpiryChecks": cp.tokenChecks != nil,
+			"schedules":         cp.schedules != nil,
+			"updateCheck":       cp.updates != nil,
+			"compression":       cp.compressResponses,
+			"faultInjection":    cp.faults != nil,
+			"debugEndpoints":    cp.debugEndpoints,
+			"mockScenarios":     mode == modeMock,
+			"cloudDiscovery":    cp.discovery != nil,
+			"approvals":         cp.approvals != nil,
+			"onboardingPolicy":  cp.onboardingPolicy != nil,
+			"admissionPolicy":   cp.admission != nil,
+			"tenancy":           cp.tenancy != nil,
+			"leaderElection":    cp.leader != nil,
+			"jobBackend":        cp.jobBackend != nil,
+		},
+		Tags: append([]string(nil), metadata.Capabilities...),
+	}
+	catalog := cp.messages
+	if catalog == nil {
+		catalog = builtinCatalog
+	}
+	capabilities.Languages = catalog.Languages()
+	if cp.auth != nil {
+		capabilities.Auth.Enabled = true
+		capabilities.Auth.PublicReads = cp.auth.config.PublicReads
+		if len(cp.auth.config.SigningKeys) > 0 {
+			capabilities.Auth.Methods = append(capabilities.Auth.Methods, "jwt")
+		}
+		if cp.auth.config.Introspection != nil {
+			capabilities.Auth.Methods = append(capabilities.Auth.Methods, "introspection")
+		}
+	}
+	return capabilities
+}
+
+// sortedNames returns the keys of a registry in order
+func sortedNames[T any](registry map[string]T) []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetCapabilitiesHandler describes the features this plugin version supports
+func (cp *ClusterPlugin) GetCapabilitiesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"capabilities": cp.capabilities(),
+		"plugin":       "kubestellar-cluster-plugin",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCapabilitiesHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"maxBatchSize":   20,
+		"faultInjection": true,
+	})
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/plugins/kubestellar-cluster-plugin/v1/capabilities", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /capabilities = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		Capabilities Capabilities `json:"capabilities"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	capabilities := response.Capabilities
+
+	if want := []string{"aks", "eks", "gke", "k3d", "kind"}; !reflect.DeepEqual(capabilities.Providers, want) {
+		t.Errorf("providers = %v, want %v", capabilities.Providers, want)
+	}
+	if capabilities.Version != defaultMetadata().Version || capabilities.Mode != modeLive {
+		t.Errorf("version %q mode %q", capabilities.Version, capabilities.Mode)
+	}
+	if capabilities.Batch.MaxBatchSize != 20 || !capabilities.Streaming.StatusEvents || !capabilities.Streaming.OnboardingLogs {
+		t.Errorf("batch %+v streaming %+v", capabilities.Batch, capabilities.Streaming)
+	}
+	if capabilities.Auth.Enabled || capabilities.Auth.RBAC {
+		t.Errorf("auth = %+v, want disabled", capabilities.Auth)
+	}
+	if !capabilities.Features["faultInjection"] || capabilities.Features["debugEndpoints"] {
+		t.Errorf("features = %v", capabilities.Features)
+	}
+	found := false
+	for _, tag := range capabilities.Tags {
+		found = found || tag == "capability_discovery"
+	}
+	if !found {
+		t.Errorf("tags = %v", capabilities.Tags)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// States of a chart in the job record
+const (
+	chartPending    = "Pending"
+	chartInstalling = "Installing"
+	chartInstalled  = "Installed"
+	chartFailed     = "Failed"
+)
+
+// HelmChart is a chart installed on the spoke once it has joined
+type HelmChart struct {
+	// Repo is the URL of the chart repository; leave it out for an oci://
+	// chart reference or a chart in a repository helm already knows
+	Repo    string `json:"repo,omitempty"`
+	Chart   string `json:"chart"`
+	Version string `json:"version,omitempty"`
+	// Release names the release, the chart name by default
+	Release string `json:"release,omitempty"`
+	// Namespace is created if missing, default by default
+	Namespace string                 `json:"namespace,omitempty"`
+	Values    map[string]interface{} `json:"values,omitempty"`
+}
+
+// release is the release name of the chart
+func (hc HelmChart) release() string {
+	if hc.Release != "" {
+		return hc.Release
+	}
+	name := hc.Chart[strings.LastIndex(hc.Chart, "/")+1:]
+	return strings.TrimSuffix(name, ".tgz")
+}
+
+func (hc HelmChart) namespace() string {
+	if hc.Namespace == "" {
+		return "default"
+	}
+	return hc.Namespace
+}
+
+// Validate checks the chart can be handed to helm
+func (hc HelmChart) Validate() error {
+	if hc.Chart == "" {
+		return fmt.Errorf("chart is required")
+	}
+	if strings.HasPrefix(hc.Chart, "-") || strings.ContainsAny(hc.Chart+hc.Version+hc.Repo, " \t\n") {
+		return fmt.Errorf("invalid chart %q", hc.Chart)
+	}
+	if hc.Repo != "" && !strings.HasPrefix(hc.Repo, "https://") && !strings.HasPrefix(hc.Repo, "http://") {
+		return fmt.Errorf("invalid repo %q for chart %s, must be an http(s) URL", hc.Repo, hc.Chart)
+	}
+	// helm keeps release names to 53 characters
+	if errs := validation.IsDNS1123Label(hc.release()); len(errs) > 0 || len(hc.release()) > 53 {
+		return fmt.Errorf("invalid release name %q for chart %s, must be a DNS label of at most 53 characters", hc.release(), hc.Chart)
+	}
+	if errs := validation.IsDNS1123Label(hc.namespace()); len(errs) > 0 {
+		return fmt.Errorf("invalid namespace %q for chart %s: %s", hc.namespace(), hc.Chart, errs[0])
+	}
+	return nil
+}
+
+// validateCharts checks a list of charts, whose releases must be unique
+func validateCharts(charts []HelmChart) error {
+	releases := make(map[string]bool, len(charts))
+	for i, chart := range charts {
+		if err := chart.Validate(); err != nil {
+			return fmt.Errorf("charts[%d]: %w", i, err)
+		}
+		key := chart.namespace() + "/" + chart.release()
+		if releases[key] {
+			return fmt.Errorf("charts[%d]: release %s is listed twice", i, key)
+		}
+		releases[key] = true
+	}
+	return nil
+}
+
+// ChartStatus is the progress of installing a chart, kept on the job
+type ChartStatus struct {
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+	Chart     string `json:"chart"`
+	Version   string `json:"version,omitempty"`
+	// State is Pending, Installing, Installed or Failed
+	State       string `json:"state"`
+	Message     string `json:"message,omitempty"`
+	CompletedAt string `json:"completedAt,omitempty"`
+}
+
+// helmCommand builds the helm upgrade --install command of a chart, so a
+// retried step picks up a release a failed attempt left behind
+func helmCommand(chart HelmChart, kubeconfigPath, kubeContext, valuesPath string, timeout time.Duration) []string {
+	args := []string{"helm", "upgrade", "--install", chart.release(), chart.Chart,
+		"--namespace", chart.namespace(), "--create-namespace",
+		"--kubeconfig", kubeconfigPath,
+		"--wait", "--timeout", timeout.String(),
+	}
+	if kubeContext != "" {
+		args = append(args, "--kube-context", kubeContext)
+	}
+	if chart.Repo != "" {
+		args = append(args, "--repo", chart.Repo)
+	}
+	if chart.Version != "" {
+		args = append(args, "--version", chart.Version)
+	}
+	if valuesPath != "" {
+		args = append(args, "--values", valuesPath)
+	}
+	return args
+}
+
+// installCharts installs the charts of an onboarding in order, recording the
+// state of each on the job. Charts a failed attempt got through are skipped
+// when the step is retried.
+func (cp *ClusterPlugin) installCharts(ctx context.Context, r *onboardingRun) error {
+	if len(r.values.Charts) == 0 {
+		return nil
+	}
+	if r.charts == nil {
+		for _, chart := range r.values.Charts {
+			r.charts = append(r.charts, ChartStatus{
+				Release:   chart.release(),
+				Namespace: chart.namespace(),
+				Chart:     chart.Chart,
+				Version:   chart.Version,
+				State:     chartPending,
+			})
+		}
+	}
+
+	for i, chart := range r.values.Charts {
+		if r.charts[i].State == chartInstalled {
+			continue
+		}
+		r.charts[i].State, r.charts[i].Message = chartInstalling, ""
+		cp.jobs.SetCharts(r.jobID, r.charts)
+
+		err := cp.installChart(ctx, r, chart)
+		r.charts[i].CompletedAt = time.Now().Format(time.RFC3339)
+		if err != nil {
+			r.charts[i].State, r.charts[i].Message = chartFailed, err.Error()
+			cp.jobs.SetCharts(r.jobID, r.charts)
+			return fmt.Errorf("failed to install chart %s: %w", chart.Chart, err)
+		}
+		r.charts[i].State = chartInstalled
+		cp.jobs.SetCharts(r.jobID, r.charts)
+		cp.logs.Append(r.clusterName, "info", fmt.Sprintf("Installed chart %s as release %s/%s", chart.Chart, chart.namespace(), chart.release()))
+	}
+	return nil
+}
+
+// installChart runs helm against the spoke, with the values of the chart
+// in a scratch file
+func (cp *ClusterPlugin) installChart(ctx context.Context, r *onboardingRun, chart HelmChart) error {
+	valuesPath := ""
+	if len(chart.Values) > 0 {
+		// helm reads JSON as YAML
+		data, err := json.Marshal(chart.Values)
+		if err != nil {
+			return fmt.Errorf("invalid values: %w", err)
+		}
+		file, err := os.CreateTemp("", "chart-values-*.json")
+		if err != nil {
+			return fmt.Errorf("failed to write values: %w", err)
+		}
+		defer os.Remove(file.Name())
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write values: %w", err)
+		}
+		valuesPath = file.Name()
+	}
+
+	// helm waits for the release within the time left to the step
+	timeout := 5 * time.Minute
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline).Round(time.Second)
+	}
+	if timeout < time.Second {
+		timeout = time.Second
+	}
+	command := helmCommand(chart, r.spokeKubeconfigPath, r.spokeContext, valuesPath, timeout)
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	if output, err := cp.runLogged(r.clusterName, cmd); err != nil {
+		return fmt.Errorf("%s, %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// checkCharts refuses charts the configured pipeline wouldn't install, or
+// that the helm CLI the dependency preflight found missing couldn't
+func (cp *ClusterPlugin) checkCharts(values *ManifestValues) error {
+	if values == nil || len(values.Charts) == 0 {
+		return nil
+	}
+	if !hasStep(cp.onboarding, "helm-charts") {
+		return fmt.Errorf("charts were requested but the onboardingPipeline has no helm-charts step")
+	}
+	if check, found := cp.preflight.Check("helm"); found && cp.mode == modeLive && (!check.Available || !check.Compatible) {
+		return fmt.Errorf("charts were requested but helm failed the dependency preflight: %s", check.Error)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateCharts(t *testing.T) {
+	tests := []struct {
+		name    string
+		charts  []HelmChart
+		wantErr string
+	}{
+		{name: "repo chart", charts: []HelmChart{{Repo: "https://charts.example.com", Chart: "podinfo", Version: "6.5.0"}}},
+		{name: "oci chart", charts: []HelmChart{{Chart: "oci://ghcr.io/stefanprodan/charts/podinfo", Namespace: "apps"}}},
+		{name: "missing chart", charts: []HelmChart{{Repo: "https://charts.example.com"}}, wantErr: "chart is required"},
+		{name: "flag as chart", charts: []HelmChart{{Chart: "--post-renderer=/bin/sh"}}, wantErr: "invalid chart"},
+		{name: "repo not a URL", charts: []HelmChart{{Repo: "file:///etc", Chart: "podinfo"}}, wantErr: "invalid repo"},
+		{name: "bad release", charts: []HelmChart{{Chart: "podinfo", Release: "Pod_Info"}}, wantErr: "invalid release name"},
+		{
+			name:    "release listed twice",
+			charts:  []HelmChart{{Chart: "podinfo"}, {Chart: "oci://ghcr.io/stefanprodan/charts/podinfo"}},
+			wantErr: "listed twice",
+		},
+		{name: "same chart, other namespace", charts: []HelmChart{{Chart: "podinfo"}, {Chart: "podinfo", Namespace: "apps"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCharts(tt.charts)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validateCharts() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHelmCommand(t *testing.T) {
+	chart := HelmChart{Repo: "https://charts.example.com", Chart: "podinfo", Version: "6.5.0", Namespace: "apps"}
+	got := strings.Join(helmCommand(chart, "/tmp/spoke", "kind-edge", "/tmp/values.json", 90*time.Second), " ")
+	want := "helm upgrade --install podinfo podinfo --namespace apps --create-namespace --kubeconfig /tmp/spoke --wait --timeout 1m30s" +
+		" --kube-context kind-edge --repo https://charts.example.com --version 6.5.0 --values /tmp/values.json"
+	if got != want {
+		t.Errorf("helmCommand() = %s\nwant %s", got, want)
+	}
+}
+
+func TestInstallChartsRecordsStatus(t *testing.T) {
+	// A helm that fails for the broken chart and records its values file
+	bin := t.TempDir()
+	valuesCopy := filepath.Join(t.TempDir(), "values.json")
+	script := "#!/bin/sh\ncase \"$*\" in *broken*) echo 'chart not found' >&2; exit 1;; esac\n" +
+		"while [ $# -gt 0 ]; do [ \"$1\" = --values ] && cp \"$2\" " + valuesCopy + "; shift; done\n"
+	if err := os.WriteFile(filepath.Join(bin, "helm"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0})
+	job := plugin.jobs.Create(context.Background(), "onboard", "edge-1")
+	run := &onboardingRun{jobID: job.ID, clusterName: "edge-1", spokeKubeconfigPath: "/tmp/spoke", values: ManifestValues{Charts: []HelmChart{
+		{Chart: "podinfo", Values: map[string]interface{}{"replicaCount": 2}},
+		{Chart: "broken"},
+	}}}
+
+	if err := plugin.installCharts(context.Background(), run); err == nil || !strings.Contains(err.Error(), "chart not found") {
+		t.Fatalf("installCharts() error = %v, want the helm output", err)
+	}
+	got, _ := plugin.jobs.Get(job.ID)
+	if len(got.Charts) != 2 || got.Charts[0].State != chartInstalled || got.Charts[1].State != chartFailed {
+		t.Fatalf("job charts = %+v", got.Charts)
+	}
+	if data, err := os.ReadFile(valuesCopy); err != nil || string(data) != `{"replicaCount":2}` {
+		t.Errorf("values handed to helm = %s, %v", data, err)
+	}
+
+	// A retry only installs what is left
+	run.values.Charts[1].Chart = "fixed"
+	if err := plugin.installCharts(context.Background(), run); err != nil {
+		t.Fatalf("installCharts() retry error = %v", err)
+	}
+	if got, _ := plugin.jobs.Get(job.ID); got.Charts[1].State != chartInstalled {
+		t.Errorf("job charts after retry = %+v", got.Charts)
+	}
+}
+
+func TestChartsNeedTheStep(t *testing.T) {
+	config := map[string]interface{}{
+		"mode":               modeMock,
+		"mockFleetSize":      0,
+		"onboardingPipeline": []interface{}{map[string]interface{}{"name": "token"}, map[string]interface{}{"name": "apply-klusterlet"}},
+	}
+	plugin := newTestPluginWithConfig(t, config)
+	if err := plugin.checkCharts(OnboardRequest{Charts: []HelmChart{{Chart: "podinfo"}}}.values()); err == nil {
+		t.Error("expected charts to be refused without the helm-charts step")
+	}
+	if err := plugin.checkCharts(OnboardRequest{}.values()); err != nil {
+		t.Errorf("checkCharts() without charts = %v", err)
+	}
+}
+
+func TestChartsNeedHelm(t *testing.T) {
+	plugin := newTestPlugin(t)
+	values := OnboardRequest{Charts: []HelmChart{{Chart: "podinfo"}}}.values()
+	plugin.preflight = PreflightReport{Checks: []DependencyCheck{{Name: "helm", Error: "not found in PATH"}}}
+	if err := plugin.checkCharts(values); err == nil || !strings.Contains(err.Error(), "helm failed the dependency preflight") {
+		t.Errorf("checkCharts() without helm = %v", err)
+	}
+	plugin.preflight = PreflightReport{Checks: []DependencyCheck{{Name: "helm", Available: true, Compatible: true, Version: "3.14.0"}}}
+	if err := plugin.checkCharts(values); err != nil {
+		t.Errorf("checkCharts() with helm = %v", err)
+	}
+	if !containsString(plugin.GetMetadata().Dependencies, "helm") {
+		t.Errorf("helm isn't a declared dependency: %v", plugin.GetMetadata().Dependencies)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Outcomes of a checkpointed step
+const (
+	checkpointCompleted = "completed"
+	// checkpointSkipped is an optional step that failed without failing the
+	// onboarding
+	checkpointSkipped = "skipped"
+)
+
+// StepCheckpoint records an onboarding step a job got through
+type StepCheckpoint struct {
+	Step        string `json:"step"`
+	Outcome     string `json:"outcome"`
+	Message     string `json:"message,omitempty"`
+	CompletedAt string `json:"completedAt"`
+}
+
+// JobCheckpoint is the progress of an onboarding job. It is kept on disk as
+// the job runs so that a plugin restarting after a crash resumes the job
+// after its last completed step instead of leaving the cluster half-joined.
+type JobCheckpoint struct {
+	JobID          string           `json:"jobId"`
+	ClusterName    string           `json:"clusterName"`
+	ManifestValues *ManifestValues  `json:"manifestValues,omitempty"`
+	Steps          []StepCheckpoint `json:"steps"`
+	// Resumed holds when the job was resumed after a restart
+	Resumed []string `json:"resumed,omitempty"`
+	// Finished checkpoints are kept for debugging only
+	Finished  bool   `json:"finished"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+
+	// kubeconfig and joinToken are what a resumed job needs to carry on;
+	// they are dropped once the job finishes
+	kubeconfig []byte
+	joinToken  string
+}
+
+// LastStep returns the name of the last checkpointed step, if any
+func (jc JobCheckpoint) LastStep() string {
+	if len(jc.Steps) == 0 {
+		return ""
+	}
+	return jc.Steps[len(jc.Steps)-1].Step
+}
+
+// storedCheckpoint is a checkpoint as written to the checkpoints file, its
+// secrets sealed
+type storedCheckpoint struct {
+	JobCheckpoint
+	SealedKubeconfig []byte `json:"sealedKubeconfig,omitempty"`
+	SealedJoinToken  []byte `json:"sealedJoinToken,omitempty"`
+}
+
+// CheckpointStore keeps the checkpoints of the onboarding jobs in a file
+type CheckpointStore struct {
+	checkpoints map[string]*JobCheckpoint
+	path        string
+	box         *secretBox
+	// retention is how many finished checkpoints are kept; 0 keeps them all
+	retention int
+	mutex     sync.Mutex
+}
+
+// NewCheckpointStore loads the checkpoints of path. Without a path they are
+// kept in memory and don't survive a restart.
+func NewCheckpointStore(path string, box *secretBox, retention int) (*CheckpointStore, error) {
+	cs := &CheckpointStore{
+		checkpoints: make(map[string]*JobCheckpoint),
+		path:        path,
+		box:         box,
+		retention:   retention,
+	}
+	if path == "" {
+		return cs, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints file: %w", err)
+	}
+	var stored []storedCheckpoint
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoints file: %w", err)
+	}
+	for i := range stored {
+		checkpoint := stored[i].JobCheckpoint
+		if !checkpoint.Finished {
+			if checkpoint.kubeconfig, err = cs.open(stored[i].SealedKubeconfig); err == nil {
+				var token []byte
+				token, err = cs.open(stored[i].SealedJoinToken)
+				checkpoint.joinToken = string(token)
+			}
+			// Without its secrets the job can't be resumed
+			if err != nil {
+				logger().Warn("Cannot resume checkpointed job", "job", checkpoint.JobID, "cluster", checkpoint.ClusterName, "error", err)
+				checkpoint.Finished = true
+			}
+		}
+		cs.checkpoints[checkpoint.JobID] = &checkpoint
+	}
+	return cs, nil
+}
+
+func (cs *CheckpointStore) open(sealed []byte) ([]byte, error) {
+	if len(sealed) == 0 {
+		return nil, nil
+	}
+	return cs.box.Open(sealed)
+}
+
+// Begin starts checkpointing an onboarding job. A job resumed after a
+// restart carries on with the checkpoint it already has.
+func (cs *CheckpointStore) Begin(jobID, clusterName string, kubeconfig []byte, values *ManifestValues) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if checkpoint, ok := cs.checkpoints[jobID]; ok && !checkpoint.Finished {
+		return
+	}
+	now := time.Now().Format(time.RFC3339)
+	cs.checkpoints[jobID] = &JobCheckpoint{
+		JobID:          jobID,
+		ClusterName:    clusterName,
+		ManifestValues: values,
+		Steps:          []StepCheckpoint{},
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		kubeconfig:     kubeconfig,
+	}
+	cs.persist()
+}
+
+// Complete records that a job got through a step. joinToken is the token of
+// the run so far, which later steps need.
+func (cs *CheckpointStore) Complete(jobID, step, outcome, message, joinToken string) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	checkpoint, ok := cs.checkpoints[jobID]
+	if !ok || checkpoint.Finished {
+		return
+	}
+	now := time.Now().Format(time.RFC3339)
+	checkpoint.Steps = append(checkpoint.Steps, StepCheckpoint{Step: step, Outcome: outcome, Message: message, CompletedAt: now})
+	checkpoint.UpdatedAt = now
+	checkpoint.joinToken = joinToken
+	cs.persist()
+}
+
+// Progress returns the steps a job already got through, and the join token
+// it got
+func (cs *CheckpointStore) Progress(jobID string) (map[string]bool, string) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	done := make(map[string]bool)
+	checkpoint, ok := cs.checkpoints[jobID]
+	if !ok || checkpoint.Finished {
+		return done, ""
+	}
+	for _, step := range checkpoint.Steps {
+		done[step.Step] = true
+	}
+	return done, checkpoint.joinToken
+}
+
+// Resume marks the unfinished checkpoints as resumed and returns them, with
+// their secrets
+func (cs *CheckpointStore) Resume() []JobCheckpoint {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	now := time.Now().Format(time.RFC3339)
+	var resumed []JobCheckpoint
+	for _, checkpoint := range cs.checkpoints {
+		if checkpoint.Finished {
+			continue
+		}
+		checkpoint.Resumed = append(checkpoint.Resumed, now)
+		checkpoint.UpdatedAt = now
+		snapshot := *checkpoint
+		snapshot.Steps = append([]StepCheckpoint(nil), checkpoint.Steps...)
+		resumed = append(resumed, snapshot)
+	}
+	if len(resumed) > 0 {
+		cs.persist()
+	}
+	sort.Slice(resumed, func(i, j int) bool {
+		return resumed[i].CreatedAt < resumed[j].CreatedAt
+	})
+	return resumed
+}
+
+// Finish ends the checkpoint of a job, dropping its secrets
+func (cs *CheckpointStore) Finish(jobID string) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	checkpoint, ok := cs.checkpoints[jobID]
+	if !ok || checkpoint.Finished {
+		return
+	}
+	checkpoint.Finished = true
+	checkpoint.UpdatedAt = time.Now().Format(time.RFC3339)
+	checkpoint.kubeconfig = nil
+	checkpoint.joinToken = ""
+	cs.evictFinished(jobID)
+	cs.persist()
+}
+
+// Get returns the checkpoint of a job, without its secrets
+func (cs *CheckpointStore) Get(jobID string) (JobCheckpoint, bool) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	checkpoint, ok := cs.checkpoints[jobID]
+	if !ok {
+		return JobCheckpoint{}, false
+	}
+	snapshot := *checkpoint
+	snapshot.Steps = append([]StepCheckpoint(nil), checkpoint.Steps...)
+	snapshot.Resumed = append([]string(nil), checkpoint.Resumed...)
+	snapshot.kubeconfig = nil
+	snapshot.joinToken = ""
+	return snapshot, true
+}
+
+// evictFinished drops the oldest finished checkpoints beyond the retention
+// limit, sparing the one of the job that just finished. The caller must
+// hold the mutex.
+func (cs *CheckpointStore) evictFinished(latest string) {
+	if cs.retention <= 0 {
+		return
+	}
+	var finished []*JobCheckpoint
+	for _, checkpoint := range cs.checkpoints {
+		if checkpoint.Finished && checkpoint.JobID != latest {
+			finished = append(finished, checkpoint)
+		}
+	}
+	if len(finished) < cs.retention {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].UpdatedAt < finished[j].UpdatedAt
+	})
+	for _, checkpoint := range finished[:len(finished)-cs.retention+1] {
+		delete(cs.checkpoints, checkpoint.JobID)
+	}
+}
+
+// reseal rewrites the checkpoints file when a secret in it isn't sealed
+// with the current encryption key
+func (cs *CheckpointStore) reseal() (int, error) {
+	if cs.path == "" {
+		return 0, nil
+	}
+	data, err := os.ReadFile(cs.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read checkpoints file: %w", err)
+	}
+	var stored []storedCheckpoint
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return 0, fmt.Errorf("failed to decode checkpoints file: %w", err)
+	}
+	stale := 0
+	for _, entry := range stored {
+		for _, sealed := range [][]byte{entry.SealedKubeconfig, entry.SealedJoinToken} {
+			if len(sealed) == 0 {
+				continue
+			}
+			if _, changed, err := cs.box.Reseal(sealed); err == nil && changed {
+				stale++
+			}
+		}
+	}
+	if stale == 0 {
+		return 0, nil
+	}
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return stale, cs.save()
+}
+
+// persist saves the checkpoints, logging failures. The caller must hold the mutex.
+func (cs *CheckpointStore) persist() {
+	if err := cs.save(); err != nil {
+		logger().Warn("Failed to persist job checkpoints", "error", err)
+	}
+}
+
+// save writes every checkpoint to the checkpoints file. The caller must hold the mutex.
+func (cs *CheckpointStore) save() error {
+	if cs.path == "" {
+		return nil
+	}
+	stored := make([]storedCheckpoint, 0, len(cs.checkpoints))
+	for _, checkpoint := range cs.checkpoints {
+		entry := storedCheckpoint{JobCheckpoint: *checkpoint}
+		var err error
+		if len(checkpoint.kubeconfig) > 0 {
+			if entry.SealedKubeconfig, err = cs.box.Seal(checkpoint.kubeconfig); err != nil {
+				return fmt.Errorf("failed to seal kubeconfig of job %s: %w", checkpoint.JobID, err)
+			}
+		}
+		if checkpoint.joinToken != "" {
+			if entry.SealedJoinToken, err = cs.box.Seal([]byte(checkpoint.joinToken)); err != nil {
+				return fmt.Errorf("failed to seal join token of job %s: %w", checkpoint.JobID, err)
+			}
+		}
+		stored = append(stored, entry)
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoints: %w", err)
+	}
+	tmpPath := cs.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoints file: %w", err)
+	}
+	return os.Rename(tmpPath, cs.path)
+}
+
+// skipCheckpointedStep records that a resumed onboarding skips a step it got
+// through before the restart
+func (cp *ClusterPlugin) skipCheckpointedStep(jobID, clusterName, step string) {
+	message := fmt.Sprintf("Step %s completed before the restart, skipping", step)
+	cp.jobs.RecordStep(jobID, "Checkpoint", message)
+	cp.logs.Append(clusterName, "info", message)
+}
+
+// resumeCheckpointedJobs restarts the onboarding jobs a crash interrupted,
+// after the last step they completed. With a job backend the job queue
+// hands them to a replica instead.
+func (cp *ClusterPlugin) resumeCheckpointedJobs() {
+	for _, checkpoint := range cp.checkpoints.Resume() {
+		if cp.jobBackend != nil {
+			cp.checkpoints.Finish(checkpoint.JobID)
+			continue
+		}
+		job, found := cp.jobs.Get(checkpoint.JobID)
+		if !found {
+			job = Job{ID: checkpoint.JobID, Type: "onboard", ClusterName: checkpoint.ClusterName, CreatedAt: checkpoint.CreatedAt}
+		}
+		message := "Resuming after a plugin restart"
+		if last := checkpoint.LastStep(); last != "" {
+			message = fmt.Sprintf("Resuming after a plugin restart from step %s", last)
+		}
+		logger().Info("Resuming checkpointed onboarding", "job", checkpoint.JobID, "cluster", checkpoint.ClusterName, "lastStep", checkpoint.LastStep())
+		cp.jobs.Adopt(job, message)
+		// Building the job body takes the plugin lock, which Initialize holds
+		go func(checkpoint JobCheckpoint) {
+			cp.jobs.Execute(checkpoint.JobID, cp.onboardingJob(checkpoint.JobID, checkpoint.ClusterName, checkpoint.kubeconfig, checkpoint.ManifestValues))
+		}(checkpoint)
+	}
+}
+
+// GetJobCheckpointsHandler returns the checkpoint of an onboarding job, for
+// debugging where it stopped and where a restart would resume it
+func (cp *ClusterPlugin) GetJobCheckpointsHandler(c *gin.Context) {
+	id := c.Param("id")
+	job, exists := cp.jobs.Get(id)
+	if exists && !visibleTo(c.Request.Context(), job.Tenant) {
+		exists = false
+	}
+	checkpoint, found := cp.checkpoints.Get(id)
+	if !exists || !found {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("No checkpoints for job '%s'", id))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"checkpoint": checkpoint,
+		"lastStep":   checkpoint.LastStep(),
+		"plugin":     "kubestellar-cluster-plugin",
+		"timestamp":  time.Now().Format(time.RFC3339),
+	})
+}
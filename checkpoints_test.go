@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResumeCheckpointedOnboarding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	box, err := newSecretBox(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The plugin crashed after getting the join token
+	crashed, err := NewCheckpointStore(path, box, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed.Begin("onboard-crashed", "edge-1", []byte("kubeconfig"), nil)
+	crashed.Complete("onboard-crashed", "validate", checkpointCompleted, "", "")
+	crashed.Complete("onboard-crashed", "token", checkpointCompleted, "", "join-secret")
+	if data, _ := os.ReadFile(path); bytes.Contains(data, []byte("join-secret")) || bytes.Contains(data, []byte("kubeconfig\"")) {
+		t.Fatalf("checkpoints file holds secrets in the clear: %s", data)
+	}
+
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode":                modeMock,
+		"mockFleetSize":       0,
+		"mockStepDelayMillis": 1,
+		"checkpointStorePath": path,
+	})
+	job := waitForJob(t, plugin.jobs, "onboard-crashed", func(job Job) bool { return job.Finished() })
+	if job.State != JobSucceeded {
+		t.Fatalf("resumed job = %s %q", job.State, job.Message)
+	}
+	var messages []string
+	for _, step := range job.Steps {
+		messages = append(messages, step.Message)
+	}
+	history := strings.Join(messages, "\n")
+	for _, want := range []string{"Resuming after a plugin restart from step token", "Step token completed before the restart, skipping"} {
+		if !strings.Contains(history, want) {
+			t.Errorf("job history lacks %q:\n%s", want, history)
+		}
+	}
+	if strings.Contains(history, "Getting join token from hub") {
+		t.Errorf("checkpointed step ran again:\n%s", history)
+	}
+
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jobs/onboard-crashed/checkpoints", nil))
+	var response struct {
+		Checkpoint JobCheckpoint `json:"checkpoint"`
+		LastStep   string        `json:"lastStep"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if recorder.Code != http.StatusOK || !response.Checkpoint.Finished || len(response.Checkpoint.Resumed) != 1 || response.LastStep != defaultPipeline[len(defaultPipeline)-1] {
+		t.Errorf("checkpoints = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if len(response.Checkpoint.Steps) != len(defaultPipeline) {
+		t.Errorf("checkpointed %d steps, want %d", len(response.Checkpoint.Steps), len(defaultPipeline))
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jobs/onboard-missing/checkpoints", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("checkpoints of an unknown job = %d", recorder.Code)
+	}
+}
+
+func TestCheckpointStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	box, _ := newSecretBox(bytes.Repeat([]byte{7}, 32))
+	store, err := NewCheckpointStore(path, box, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Begin("onboard-a", "edge-a", []byte("a"), nil)
+	store.Complete("onboard-a", "token", checkpointCompleted, "", "token-a")
+	store.Begin("onboard-b", "edge-b", []byte("b"), nil)
+	store.Complete("onboard-b", "label", checkpointSkipped, "hub unreachable", "")
+
+	if done, token := store.Progress("onboard-a"); !done["token"] || token != "token-a" {
+		t.Errorf("Progress() = %v, %q", done, token)
+	}
+	store.Finish("onboard-a")
+	if done, token := store.Progress("onboard-a"); len(done) != 0 || token != "" {
+		t.Errorf("Progress() of a finished job = %v, %q", done, token)
+	}
+
+	// Only the unfinished checkpoint is resumed, and only with the right key
+	reloaded, err := NewCheckpointStore(path, box, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed := reloaded.Resume(); len(resumed) != 1 || resumed[0].JobID != "onboard-b" || string(resumed[0].kubeconfig) != "b" {
+		t.Errorf("Resume() = %+v", resumed)
+	}
+	otherBox, _ := newSecretBox(bytes.Repeat([]byte{8}, 32))
+	if other, err := NewCheckpointStore(path, otherBox, 1); err != nil || len(other.Resume()) != 0 {
+		t.Errorf("checkpoints sealed with another key were resumed, error = %v", err)
+	}
+
+	// Finishing another one evicts the oldest finished checkpoint
+	reloaded.Finish("onboard-b")
+	if _, found := reloaded.Get("onboard-a"); found {
+		t.Error("finished checkpoint beyond the retention kept")
+	}
+	if checkpoint, found := reloaded.Get("onboard-b"); !found || checkpoint.Steps[0].Outcome != checkpointSkipped {
+		t.Errorf("Get() = %+v, %v", checkpoint, found)
+	}
+}
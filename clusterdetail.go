@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// clusterLeaseName is the Lease the registration agent renews in the
+// cluster namespace on the hub
+const clusterLeaseName = "managed-cluster-lease"
+
+// agentNamespaces hold the klusterlet operator and the agents it runs
+var agentNamespaces = []string{"open-cluster-management", agentNamespace}
+
+// ClusterCondition is a condition of a ManagedCluster
+type ClusterCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// ClusterTaint is a taint of a ManagedCluster, keeping placements off it
+type ClusterTaint struct {
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	Effect    string `json:"effect"`
+	TimeAdded string `json:"timeAdded,omitempty"`
+}
+
+// ClusterClaim is a property the spoke reports about itself to the hub
+type ClusterClaim struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// AgentVersion is a klusterlet Deployment running on the spoke
+type AgentVersion struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Image     string `json:"image"`
+	Version   string `json:"version"`
+	Ready     bool   `json:"ready"`
+}
+
+// ClusterDetail is the drill-down view of a cluster. Errors lists the parts
+// that couldn't be read, so one unreachable cluster doesn't hide the rest.
+type ClusterDetail struct {
+	ClusterName string `json:"clusterName"`
+	Hub         string `json:"hub"`
+	Status      string `json:"status"`
+	// Phase summarises the ManagedCluster conditions as in ManagedClusterState
+	Phase             string             `json:"phase,omitempty"`
+	HubAcceptsClient  bool               `json:"hubAcceptsClient"`
+	KubernetesVersion string             `json:"kubernetesVersion,omitempty"`
+	Labels            map[string]string  `json:"labels,omitempty"`
+	Conditions        []ClusterCondition `json:"conditions"`
+	Allocatable       map[string]string  `json:"allocatable,omitempty"`
+	Capacity          map[string]string  `json:"capacity,omitempty"`
+	Taints            []ClusterTaint     `json:"taints"`
+	Claims            []ClusterClaim     `json:"claims"`
+	// LastHeartbeat is when the registration agent last renewed its lease
+	LastHeartbeat        string         `json:"lastHeartbeat,omitempty"`
+	LeaseDurationSeconds int64          `json:"leaseDurationSeconds,omitempty"`
+	Agents               []AgentVersion `json:"agents"`
+	// Resources are the nodes and allocatable CPU and memory the plugin last
+	// collected
+	Resources *ClusterResources `json:"resources,omitempty"`
+	// Record is what the plugin knows of the cluster, absent for clusters
+	// only discovered on the hub
+	Record *ClusterStatus `json:"record,omitempty"`
+	Errors []string       `json:"errors,omitempty"`
+}
+
+// clusterDetailFrom reads the spec and status of a ManagedCluster
+func clusterDetailFrom(u *unstructured.Unstructured) ClusterDetail {
+	detail := newClusterDetail(u.GetName())
+	detail.Labels = u.GetLabels()
+	detail.Phase = managedClusterStateFrom(u).Phase()
+	detail.HubAcceptsClient, _, _ = unstructured.NestedBool(u.Object, "spec", "hubAcceptsClient")
+	detail.LeaseDurationSeconds, _, _ = unstructured.NestedInt64(u.Object, "spec", "leaseDurationSeconds")
+	detail.KubernetesVersion, _, _ = unstructured.NestedString(u.Object, "status", "version", "kubernetes")
+	detail.Allocatable, _, _ = unstructured.NestedStringMap(u.Object, "status", "allocatable")
+	detail.Capacity, _, _ = unstructured.NestedStringMap(u.Object, "status", "capacity")
+
+	conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	for _, raw := range conditions {
+		condition, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		detail.Conditions = append(detail.Conditions, ClusterCondition{
+			Type:               stringField(condition, "type"),
+			Status:             stringField(condition, "status"),
+			Reason:             stringField(condition, "reason"),
+			Message:            stringField(condition, "message"),
+			LastTransitionTime: stringField(condition, "lastTransitionTime"),
+		})
+	}
+
+	taints, _, _ := unstructured.NestedSlice(u.Object, "spec", "taints")
+	for _, raw := range taints {
+		taint, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		detail.Taints = append(detail.Taints, ClusterTaint{
+			Key:       stringField(taint, "key"),
+			Value:     stringField(taint, "value"),
+			Effect:    stringField(taint, "effect"),
+			TimeAdded: stringField(taint, "timeAdded"),
+		})
+	}
+
+	claims, _, _ := unstructured.NestedSlice(u.Object, "status", "clusterClaims")
+	for _, raw := range claims {
+		claim, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		detail.Claims = append(detail.Claims, ClusterClaim{Name: stringField(claim, "name"), Value: stringField(claim, "value")})
+	}
+	sort.Slice(detail.Claims, func(i, j int) bool { return detail.Claims[i].Name < detail.Claims[j].Name })
+	return detail
+}
+
+// newClusterDetail returns a detail with empty rather than null lists
+func newClusterDetail(clusterName string) ClusterDetail {
+	return ClusterDetail{
+		ClusterName: clusterName,
+		Conditions:  []ClusterCondition{},
+		Taints:      []ClusterTaint{},
+		Claims:      []ClusterClaim{},
+		Agents:      []AgentVersion{},
+	}
+}
+
+func stringField(object map[string]interface{}, key string) string {
+	value, _ := object[key].(string)
+	return value
+}
+
+// imageVersion is the tag or digest of an image reference, "latest" when it has neither
+func imageVersion(image string) string {
+	if at := strings.LastIndex(image, "@"); at >= 0 {
+		return image[at+1:]
+	}
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		return image[colon+1:]
+	}
+	return "latest"
+}
+
+// agentVersions lists the klusterlet Deployments on the spoke with the
+// version of the image each runs
+func agentVersions(ctx context.Context, spoke kubernetes.Interface) ([]AgentVersion, error) {
+	agents := []AgentVersion{}
+	for _, namespace := range agentNamespaces {
+		deployments, err := spoke.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return agents, fmt.Errorf("failed to list deployments in %s: %w", namespace, err)
+		}
+		for _, deployment := range deployments.Items {
+			containers := deployment.Spec.Template.Spec.Containers
+			if len(containers) == 0 {
+				continue
+			}
+			replicas := int32(1)
+			if deployment.Spec.Replicas != nil {
+				replicas = *deployment.Spec.Replicas
+			}
+			agents = append(agents, AgentVersion{
+				Name:      deployment.Name,
+				Namespace: namespace,
+				Image:     containers[0].Image,
+				Version:   imageVersion(containers[0].Image),
+				Ready:     deployment.Status.AvailableReplicas >= replicas,
+			})
+		}
+	}
+	return agents, nil
+}
+
+// describeCluster reads the ManagedCluster and its lease from the hub and,
+// when target.spoke is set, the agents running on the spoke. Only a failure
+// to get the ManagedCluster is returned as an error.
+func describeCluster(ctx context.Context, target readinessTarget) (ClusterDetail, error) {
+	object, err := target.hubDynamic.Resource(managedClusterGVR).Get(ctx, target.clusterName, metav1.GetOptions{})
+	if err != nil {
+		return newClusterDetail(target.clusterName), err
+	}
+	detail := clusterDetailFrom(object)
+
+	lease, err := target.hub.CoordinationV1().Leases(target.clusterName).Get(ctx, clusterLeaseName, metav1.GetOptions{})
+	switch {
+	case err == nil && lease.Spec.RenewTime != nil:
+		detail.LastHeartbeat = lease.Spec.RenewTime.Format(time.RFC3339)
+	case err != nil && !apierrors.IsNotFound(err):
+		detail.Errors = append(detail.Errors, fmt.Sprintf("failed to get cluster lease: %v", err))
+	}
+
+	if target.spoke != nil {
+		agents, err := agentVersions(ctx, target.spoke)
+		detail.Agents = agents
+		if err != nil {
+			detail.Errors = append(detail.Errors, err.Error())
+		}
+	}
+	return detail, nil
+}
+
+// detailTarget connects to the hub of a cluster and, when its kubeconfig
+// was saved at onboarding, to the spoke
+func (cp *ClusterPlugin) detailTarget(hub Hub, clusterName string) (readinessTarget, error) {
+	target := readinessTarget{clusterName: clusterName}
+	hubClient, hubConfig, err := hub.clientset()
+	if err != nil {
+		return target, fmt.Errorf("failed to get hub clientset: %w", err)
+	}
+	target.hub = hubClient
+	if target.hubDynamic, err = dynamic.NewForConfig(hubConfig); err != nil {
+		return target, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	if kubeconfigData := cp.savedKubeconfig(clusterName); len(kubeconfigData) > 0 {
+		spokeConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigData)
+		if err != nil {
+			return target, fmt.Errorf("failed to parse kubeconfig: %w", err)
+		}
+		if target.spoke, err = kubernetes.NewForConfig(spokeConfig); err != nil {
+			return target, fmt.Errorf("failed to create Kubernetes client: %w", err)
+		}
+	}
+	return target, nil
+}
+
+// clusterDetail describes a cluster the plugin onboarded, or one only found
+// on hubName. The hub failing to describe an onboarded cluster is reported
+// in Errors next to the plugin's record rather than failing the request.
+func (cp *ClusterPlugin) clusterDetail(ctx context.Context, clusterName, hubName string, record *ClusterStatus) (ClusterDetail, error) {
+	hub, err := cp.hubs.Get(hubName)
+	if err != nil {
+		return ClusterDetail{}, err
+	}
+	detail := newClusterDetail(clusterName)
+	target, err := cp.detailTarget(hub, clusterName)
+	if err == nil {
+		detail, err = describeCluster(ctx, target)
+	}
+	if err != nil {
+		if record == nil {
+			return detail, err
+		}
+		detail.Errors = append(detail.Errors, fmt.Sprintf("failed to describe cluster on hub %s: %v", hub.Name, err))
+	}
+	detail.Hub = hub.Name
+	detail.Status = detail.Phase
+	if record != nil {
+		detail.Status = record.Status
+		detail.Resources = record.Resources
+		detail.Record = record
+	}
+	return detail, nil
+}
+
+// GetClusterDetailHandler returns the hub conditions, resources, taints,
+// claims, heartbeat and agent versions of a cluster. Clusters the plugin
+// didn't onboard are looked up on the hub query parameter or the default hub.
+func (cp *ClusterPlugin) GetClusterDetailHandler(c *gin.Context) {
+	clusterName := c.Param("name")
+
+	cp.mutex.RLock()
+	record, exists, err := cp.store.Get(clusterName)
+	cp.mutex.RUnlock()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	var detail ClusterDetail
+	if exists {
+		detail, err = cp.clusterDetail(ctx, clusterName, hubName(record), &record)
+	} else {
+		detail, err = cp.clusterDetail(ctx, clusterName, c.Query("hub"), nil)
+	}
+	if cp.abortOnContext(c) {
+		return
+	}
+	switch {
+	case errors.Is(err, errHubNotFound):
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	case apierrors.IsNotFound(err):
+		respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin or on hub", clusterName))
+		return
+	case err != nil:
+		respondProblem(c, http.StatusBadGateway, CodeHubUnreachable, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"cluster":   detail,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
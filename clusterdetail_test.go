@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDescribeCluster(t *testing.T) {
+	managedCluster := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.open-cluster-management.io/v1",
+		"kind":       "ManagedCluster",
+		"metadata":   map[string]interface{}{"name": "edge-1", "labels": map[string]interface{}{"location-group": "edge"}},
+		"spec": map[string]interface{}{
+			"hubAcceptsClient":     true,
+			"leaseDurationSeconds": int64(60),
+			"taints": []interface{}{
+				map[string]interface{}{"key": "cluster.open-cluster-management.io/unreachable", "effect": "NoSelect", "timeAdded": "2024-05-01T10:00:00Z"},
+			},
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "HubAcceptedManagedCluster", "status": "True", "reason": "HubClusterAdminAccepted"},
+				map[string]interface{}{"type": "ManagedClusterJoined", "status": "True"},
+				map[string]interface{}{"type": "ManagedClusterConditionAvailable", "status": "False", "message": "Registration agent stopped updating its lease."},
+			},
+			"version":       map[string]interface{}{"kubernetes": "v1.29.2"},
+			"allocatable":   map[string]interface{}{"cpu": "3800m", "memory": "7Gi"},
+			"capacity":      map[string]interface{}{"cpu": "4", "memory": "8Gi"},
+			"clusterClaims": []interface{}{map[string]interface{}{"name": "platform.open-cluster-management.io", "value": "KIND"}, map[string]interface{}{"name": "id.k8s.io", "value": "edge-1"}},
+		},
+	}}
+	hubDynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		managedClusterGVR: "ManagedClusterList",
+	}, managedCluster)
+	renewed := metav1.NewMicroTime(time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC))
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterLeaseName, Namespace: "edge-1"},
+		Spec:       coordinationv1.LeaseSpec{RenewTime: &renewed},
+	}
+	replicas := int32(2)
+	agent := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "klusterlet-agent", Namespace: agentNamespace},
+		Spec: appsv1.DeploymentSpec{Replicas: &replicas, Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "agent", Image: "quay.io/open-cluster-management/registration-operator:v0.13.1"}},
+		}}},
+		Status: appsv1.DeploymentStatus{AvailableReplicas: 1},
+	}
+
+	target := readinessTarget{
+		clusterName: "edge-1",
+		hub:         fake.NewSimpleClientset(lease),
+		hubDynamic:  hubDynamic,
+		spoke:       fake.NewSimpleClientset(agent),
+	}
+	detail, err := describeCluster(context.Background(), target)
+	if err != nil {
+		t.Fatalf("describeCluster() error = %v", err)
+	}
+	if detail.Phase != "Unavailable" || !detail.HubAcceptsClient || detail.KubernetesVersion != "v1.29.2" || detail.LeaseDurationSeconds != 60 {
+		t.Errorf("describeCluster() = %+v", detail)
+	}
+	if len(detail.Conditions) != 3 || detail.Conditions[0].Reason != "HubClusterAdminAccepted" {
+		t.Errorf("Conditions = %+v", detail.Conditions)
+	}
+	if want := map[string]string{"cpu": "3800m", "memory": "7Gi"}; !reflect.DeepEqual(detail.Allocatable, want) {
+		t.Errorf("Allocatable = %v, want %v", detail.Allocatable, want)
+	}
+	if len(detail.Taints) != 1 || detail.Taints[0].Effect != "NoSelect" {
+		t.Errorf("Taints = %+v", detail.Taints)
+	}
+	if len(detail.Claims) != 2 || detail.Claims[0].Name != "id.k8s.io" {
+		t.Errorf("Claims = %+v, want them sorted by name", detail.Claims)
+	}
+	if detail.LastHeartbeat != "2024-05-01T10:05:00Z" {
+		t.Errorf("LastHeartbeat = %s", detail.LastHeartbeat)
+	}
+	want := []AgentVersion{{Name: "klusterlet-agent", Namespace: agentNamespace, Image: agent.Spec.Template.Spec.Containers[0].Image, Version: "v0.13.1"}}
+	if !reflect.DeepEqual(detail.Agents, want) {
+		t.Errorf("Agents = %+v, want %+v", detail.Agents, want)
+	}
+
+	target.clusterName = "edge-2"
+	if _, err := describeCluster(context.Background(), target); err == nil {
+		t.Error("describeCluster() of a missing ManagedCluster succeeded")
+	}
+}
+
+func TestImageVersion(t *testing.T) {
+	tests := map[string]string{
+		"quay.io/open-cluster-management/registration:v0.13.1": "v0.13.1",
+		"localhost:5000/registration":                          "latest",
+		"localhost:5000/registration:v1":                       "v1",
+		"quay.io/ocm/work@sha256:abc":                          "sha256:abc",
+	}
+	for image, want := range tests {
+		if got := imageVersion(image); got != want {
+			t.Errorf("imageVersion(%s) = %s, want %s", image, got, want)
+		}
+	}
+}
+
+func TestGetClusterDetailHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("KUBECONFIG", t.TempDir()+"/missing")
+	plugin := newTestPlugin(t)
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	router := gin.New()
+	router.GET("/clusters/:name", plugin.GetClusterDetailHandler)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/clusters/edge-2", http.StatusBadGateway},
+		{"/clusters/edge-2?hub=its9", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if recorder.Code != tt.want {
+			t.Errorf("GET %s status = %d, want %d, body %s", tt.path, recorder.Code, tt.want, recorder.Body)
+		}
+	}
+
+	// The plugin's record is still returned when the hub can't be reached
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/clusters/edge-1", nil))
+	var response struct {
+		Cluster ClusterDetail `json:"cluster"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("GET /clusters/edge-1 status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	detail := response.Cluster
+	if detail.Status != "Ready" || detail.Hub != builtinHub.Name || detail.Record == nil || len(detail.Errors) != 1 {
+		t.Errorf("GET /clusters/edge-1 = %+v", detail)
+	}
+}
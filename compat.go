@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// errIncompatibleHost is returned when the host doesn't satisfy the plugin
+// compatibility constraints
+var errIncompatibleHost = errors.New("plugin is incompatible with its host")
+
+// negotiatedComponents are the Compatibility entries checked against the
+// host; the others describe tools checked by the dependency preflight
+var negotiatedComponents = []string{"kubestellar", "kubernetes", "go"}
+
+// HostInfo describes the host loading the plugin, as reported in the
+// compatibility handshake or the host section of the Initialize config
+type HostInfo struct {
+	KubeStellarVersion string `json:"kubestellarVersion,omitempty"`
+	KubernetesVersion  string `json:"kubernetesVersion,omitempty"`
+	// GoVersion defaults to the runtime the plugin runs in, which is the
+	// host's own when the plugin is loaded in process
+	GoVersion string `json:"goVersion,omitempty"`
+}
+
+// version returns the version the host reported for a component
+func (h HostInfo) version(component string) string {
+	switch component {
+	case "kubestellar":
+		return h.KubeStellarVersion
+	case "kubernetes":
+		return h.KubernetesVersion
+	case "go":
+		if h.GoVersion == "" {
+			return strings.TrimPrefix(runtime.Version(), "go")
+		}
+		return strings.TrimPrefix(h.GoVersion, "go")
+	}
+	return ""
+}
+
+// CompatibilityCheck is the outcome of checking one component of the host
+type CompatibilityCheck struct {
+	Component  string `json:"component"`
+	Constraint string `json:"constraint"`
+	Version    string `json:"version,omitempty"`
+	Compatible bool   `json:"compatible"`
+	// Skipped is set when the host didn't report the component version
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// NegotiationResult is the outcome of the compatibility handshake
+type NegotiationResult struct {
+	PluginVersion string               `json:"pluginVersion"`
+	Compatible    bool                 `json:"compatible"`
+	Checks        []CompatibilityCheck `json:"checks"`
+}
+
+// negotiate checks the host against the metadata compatibility constraints.
+// Components the host doesn't report are skipped rather than refused.
+func negotiate(metadata PluginMetadata, host HostInfo) NegotiationResult {
+	result := NegotiationResult{PluginVersion: metadata.Version, Compatible: true}
+	for _, component := range negotiatedComponents {
+		constraint, ok := metadata.Compatibility[component]
+		if !ok {
+			continue
+		}
+		check := CompatibilityCheck{Component: component, Constraint: constraint, Version: host.version(component)}
+		if check.Version == "" {
+			check.Skipped = true
+			check.Compatible = true
+		} else {
+			check.Compatible, check.Error = evaluateConstraint(check.Version, constraint)
+		}
+		if !check.Compatible {
+			result.Compatible = false
+		}
+		result.Checks = append(result.Checks, check)
+	}
+	return result
+}
+
+// Err describes every incompatible component, or returns nil
+func (r NegotiationResult) Err() error {
+	var problems []string
+	for _, check := range r.Checks {
+		if !check.Compatible {
+			problems = append(problems, fmt.Sprintf("%s: %s", check.Component, check.Error))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errIncompatibleHost, strings.Join(problems, "; "))
+}
+
+// Negotiate is the compatibility handshake: the host reports its versions and
+// learns whether the plugin supports them. It may be called before
+// Initialize; the outcome is reported in the metadata extensions.
+func (cp *ClusterPlugin) Negotiate(host HostInfo) (NegotiationResult, error) {
+	result := negotiate(cp.GetMetadata(), host)
+	cp.mutex.Lock()
+	cp.host = host
+	cp.negotiation = &result
+	cp.mutex.Unlock()
+	if err := result.Err(); err != nil {
+		logger().Error("Host failed the compatibility check", "error", err)
+		return result, err
+	}
+	return result, nil
+}
+
+// hostInfoFromConfig reads the host section of the Initialize config,
+// falling back to what the host reported in the handshake
+func hostInfoFromConfig(config map[string]interface{}, negotiated HostInfo) (HostInfo, error) {
+	var host HostInfo
+	raw, ok := config["host"]
+	if !ok {
+		return negotiated, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return host, fmt.Errorf("invalid host config: %w", err)
+	}
+	if err := json.Unmarshal(data, &host); err != nil {
+		return host, fmt.Errorf("invalid host config: %w", err)
+	}
+	return host, nil
+}
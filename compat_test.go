@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	metadata := PluginMetadata{
+		Version: "1.0.0",
+		Compatibility: map[string]string{
+			"kubestellar": ">=0.21.0 <0.30",
+			"kubernetes":  ">=1.28.0",
+			"go":          ">=1.21",
+			"kubectl":     ">=1.28.0",
+		},
+	}
+	tests := []struct {
+		name       string
+		host       HostInfo
+		compatible bool
+		wantError  string
+	}{
+		{name: "compatible", host: HostInfo{KubeStellarVersion: "v0.23.0", KubernetesVersion: "v1.29.4+k3s1", GoVersion: "go1.22.3"}, compatible: true},
+		{name: "unreported versions are skipped", host: HostInfo{}, compatible: true},
+		{name: "kubestellar too new", host: HostInfo{KubeStellarVersion: "0.30.0"}, wantError: "kubestellar: version 0.30.0 does not satisfy >=0.21.0 <0.30"},
+		{name: "go too old", host: HostInfo{GoVersion: "go1.20.14"}, wantError: "go: version 1.20.14 does not satisfy >=1.21"},
+		{name: "unparsable version", host: HostInfo{KubernetesVersion: "unknown"}, wantError: `kubernetes: invalid version "unknown"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := negotiate(metadata, tt.host)
+			if result.Compatible != tt.compatible {
+				t.Errorf("Compatible = %v, want %v", result.Compatible, tt.compatible)
+			}
+			err := result.Err()
+			if tt.wantError == "" {
+				if err != nil {
+					t.Errorf("Err() = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errIncompatibleHost) || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("Err() = %v, want %q", err, tt.wantError)
+			}
+			for _, check := range result.Checks {
+				if check.Component == "kubectl" {
+					t.Error("preflight tool kubectl was negotiated with the host")
+				}
+			}
+		})
+	}
+}
+
+func TestInitializeRefusesIncompatibleHost(t *testing.T) {
+	config := map[string]interface{}{
+		"storePath":            filepath.Join(t.TempDir(), "clusters.db"),
+		"watchManagedClusters": false,
+		"logLevel":             "error",
+		"host":                 map[string]interface{}{"kubestellarVersion": "0.20.0"},
+	}
+	plugin := &ClusterPlugin{}
+	if err := plugin.Initialize(config); !errors.Is(err, errIncompatibleHost) {
+		plugin.Cleanup()
+		t.Fatalf("Initialize() error = %v, want %v", err, errIncompatibleHost)
+	}
+
+	// The handshake outcome carries over to Initialize
+	delete(config, "host")
+	if _, err := plugin.Negotiate(HostInfo{KubeStellarVersion: "0.19.0"}); err == nil {
+		t.Fatal("Negotiate() accepted an incompatible host")
+	}
+	if err := plugin.Initialize(config); !errors.Is(err, errIncompatibleHost) {
+		plugin.Cleanup()
+		t.Fatalf("Initialize() after the handshake error = %v, want %v", err, errIncompatibleHost)
+	}
+
+	result, err := plugin.Negotiate(HostInfo{KubeStellarVersion: "0.23.0"})
+	if err != nil || !result.Compatible {
+		t.Fatalf("Negotiate() = %+v, %v", result, err)
+	}
+	config["scheduleStorePath"] = filepath.Join(t.TempDir(), "schedules.json")
+	if err := plugin.Initialize(config); err != nil {
+		t.Fatalf("Initialize() of a compatible host error = %v", err)
+	}
+	defer plugin.Cleanup()
+	if _, ok := plugin.GetMetadata().Extensions["compatibility"]; !ok {
+		t.Error("metadata extensions don't report the compatibility check")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipWriters reuses compressors across responses, as each holds a sizable
+// window buffer
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// gzipResponseWriter compresses the body written through it. The compressor
+// is only started by the first write, so bodiless answers such as 204 and
+// 304 stay empty.
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipResponseWriter) start() {
+	if w.gz != nil {
+		return
+	}
+	header := w.ResponseWriter.Header()
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.gz = gzipWriters.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	w.start()
+	return w.gz.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush pushes what was compressed so far to the client, so streamed
+// responses stay incremental
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	if err := w.gz.Close(); err != nil {
+		logger().Debug("Failed to finish compressed response", "error", err)
+	}
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// withCompression gzips the answers of clients that accept it. WebSocket
+// and Server-Sent Events endpoints are left alone, as are all endpoints when
+// compressResponses is off.
+func (cp *ClusterPlugin) withCompression(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc {
+	doc := endpointDocs[handlerName]
+	if doc.upgrade || doc.contentType == "text/event-stream" {
+		return handler
+	}
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		enabled := cp.compressResponses
+		cp.mutex.RUnlock()
+		if !enabled {
+			handler(c)
+			return
+		}
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if c.Request.Method == http.MethodHead || !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			handler(c)
+			return
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer func() {
+			writer.close()
+			c.Writer = writer.ResponseWriter
+		}()
+		handler(c)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, gzip;q=1": true,
+		"br, GZIP":          true,
+		"gzip;q=0":          false,
+		"gzip; q=0":         false,
+		"identity":          false,
+	}
+	for header, want := range tests {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := &ClusterPlugin{compressResponses: true}
+	router := gin.New()
+	router.GET("/status", plugin.withCompression("GetClusterStatusHandler", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"plugin": "kubestellar-cluster-plugin"})
+	}))
+	router.GET("/empty", plugin.withCompression("GetClusterStatusHandler", func(c *gin.Context) {
+		c.Status(http.StatusNotModified)
+	}))
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	compressed := get("/status", "gzip")
+	if compressed.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", compressed.Header().Get("Content-Encoding"))
+	}
+	reader, err := gzip.NewReader(compressed.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	if body, _ := io.ReadAll(reader); string(body) != `{"plugin":"kubestellar-cluster-plugin"}` {
+		t.Errorf("decompressed body = %s", body)
+	}
+
+	if plain := get("/status", ""); plain.Header().Get("Content-Encoding") != "" || plain.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("uncompressed answer headers = %v", plain.Header())
+	}
+	if empty := get("/empty", "gzip"); empty.Code != http.StatusNotModified || empty.Body.Len() != 0 || empty.Header().Get("Content-Encoding") != "" {
+		t.Errorf("304 answer = %d with %d bytes, headers %v", empty.Code, empty.Body.Len(), empty.Header())
+	}
+
+	plugin.compressResponses = false
+	if off := get("/status", "gzip"); off.Header().Get("Content-Encoding") != "" {
+		t.Error("answer compressed with compressResponses off")
+	}
+}
+
+func TestGetClusterStatusNDJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	plugin.mutex.Lock()
+	for _, name := range []string{"edge-1", "edge-2", "edge-3"} {
+		plugin.putStatus(ClusterStatus{ClusterName: name, Status: "Ready"})
+	}
+	plugin.mutex.Unlock()
+	router := gin.New()
+	router.GET("/status", plugin.withCompression("GetClusterStatusHandler", plugin.GetClusterStatusHandler))
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/status?format=ndjson&limit=2", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("GET /status?format=ndjson = %d, %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if recorder.Header().Get(totalCountHeader) != "3" || recorder.Header().Get(continueTokenHeader) == "" {
+		t.Errorf("headers = %v, want the total count and a continue token", recorder.Header())
+	}
+	reader, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	var names []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var cluster ClusterStatus
+		if err := json.Unmarshal(scanner.Bytes(), &cluster); err != nil {
+			t.Fatalf("line %q is not a cluster: %v", scanner.Text(), err)
+		}
+		names = append(names, cluster.ClusterName)
+	}
+	if len(names) != 2 || names[0] != "edge-1" || names[1] != "edge-2" {
+		t.Errorf("streamed clusters = %v, want the first page", names)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status?format=xml", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("GET /status?format=xml = %d, want 400", recorder.Code)
+	}
+}
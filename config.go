@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// configString reads a string value from the Initialize config map
+func configString(config map[string]interface{}, key, fallback string) string {
+	if value, ok := config[key].(string); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// configInt reads an integer value from the Initialize config map, accepting
+// the float64 values produced by JSON decoding
+func configInt(config map[string]interface{}, key string, fallback int) int {
+	switch value := config[key].(type) {
+	case int:
+		return value
+	case int64:
+		return int(value)
+	case float64:
+		return int(value)
+	}
+	return fallback
+}
+
+// configBool reads a boolean value from the Initialize config map, accepting
+// "true"/"false" strings as passed through environment-style settings
+func configBool(config map[string]interface{}, key string, fallback bool) bool {
+	switch value := config[key].(type) {
+	case bool:
+		return value
+	case string:
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// configFloat reads a floating point value from the Initialize config map
+func configFloat(config map[string]interface{}, key string, fallback float64) float64 {
+	switch value := config[key].(type) {
+	case float64:
+		return value
+	case float32:
+		return float64(value)
+	case int:
+		return float64(value)
+	case string:
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// configStrings reads a list of strings from the Initialize config map,
+// accepting a JSON array or a comma separated string
+func configStrings(config map[string]interface{}, key string) []string {
+	var values []string
+	switch value := config[key].(type) {
+	case []string:
+		values = value
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	case string:
+		values = strings.Split(value, ",")
+	}
+	var trimmed []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			trimmed = append(trimmed, value)
+		}
+	}
+	return trimmed
+}
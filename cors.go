@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSConfig is the cors section of the Initialize config. Cross-origin
+// requests are refused unless their origin is listed in AllowedOrigins.
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as http://localhost:3000; "*" allows any
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders   []string `json:"exposedHeaders,omitempty"`
+	AllowCredentials bool     `json:"allowCredentials,omitempty"`
+	// MaxAgeSeconds is how long browsers may cache a preflight answer
+	MaxAgeSeconds int `json:"maxAgeSeconds,omitempty"`
+}
+
+// SecurityHeadersConfig is the securityHeaders section of the Initialize config
+type SecurityHeadersConfig struct {
+	Enabled bool `json:"enabled"`
+	// HSTSMaxAgeSeconds sets Strict-Transport-Security when positive; only
+	// worth it when the plugin is served over TLS
+	HSTSMaxAgeSeconds int `json:"hstsMaxAgeSeconds,omitempty"`
+}
+
+// Validate checks the origins and methods
+func (cc CORSConfig) Validate() error {
+	for _, origin := range cc.AllowedOrigins {
+		if origin == "*" {
+			if cc.AllowCredentials {
+				return fmt.Errorf("allowCredentials can't be combined with the \"*\" origin")
+			}
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || strings.TrimSuffix(origin, "/") != parsed.Scheme+"://"+parsed.Host {
+			return fmt.Errorf("origin %q must be a scheme and host such as https://dashboard.example.com", origin)
+		}
+	}
+	for _, method := range cc.AllowedMethods {
+		if !validHTTPMethods[method] {
+			return fmt.Errorf("invalid method %q", method)
+		}
+	}
+	if cc.MaxAgeSeconds < 0 {
+		return fmt.Errorf("maxAgeSeconds must not be negative")
+	}
+	return nil
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for origin,
+// or "" when it may not make cross-origin requests
+func (cc CORSConfig) allowedOrigin(origin string) string {
+	for _, allowed := range cc.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func corsConfigFromConfig(config map[string]interface{}) (CORSConfig, SecurityHeadersConfig, error) {
+	cc := CORSConfig{
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders: []string{"Authorization", "Content-Type", requestIDHeader, idempotencyKeyHeader},
+		ExposedHeaders: []string{requestIDHeader, "Retry-After", totalCountHeader, continueTokenHeader},
+		MaxAgeSeconds:  600,
+	}
+	headers := SecurityHeadersConfig{Enabled: true}
+	for key, target := range map[string]interface{}{"cors": &cc, "securityHeaders": &headers} {
+		raw, ok := config[key]
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return cc, headers, fmt.Errorf("invalid %s config: %w", key, err)
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			return cc, headers, fmt.Errorf("invalid %s config: %w", key, err)
+		}
+	}
+	if err := cc.Validate(); err != nil {
+		return cc, headers, fmt.Errorf("invalid cors config: %w", err)
+	}
+	if headers.HSTSMaxAgeSeconds < 0 {
+		return cc, headers, fmt.Errorf("invalid securityHeaders config: hstsMaxAgeSeconds must not be negative")
+	}
+	return cc, headers, nil
+}
+
+// withResponseHeaders wraps a handler so that its answers carry the CORS
+// headers for allowed origins and the security headers, including the
+// errors returned by authentication and rate limiting
+func (cp *ClusterPlugin) withResponseHeaders(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		cors, security := cp.cors, cp.securityHeaders
+		cp.mutex.RUnlock()
+
+		header := c.Writer.Header()
+		if security.Enabled {
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", "DENY")
+			header.Set("Referrer-Policy", "no-referrer")
+			header.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+			if security.HSTSMaxAgeSeconds > 0 {
+				header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(security.HSTSMaxAgeSeconds))
+			}
+		}
+		if origin := c.GetHeader("Origin"); origin != "" {
+			header.Add("Vary", "Origin")
+			if allowed := cors.allowedOrigin(origin); allowed != "" {
+				header.Set("Access-Control-Allow-Origin", allowed)
+				if cors.AllowCredentials {
+					header.Set("Access-Control-Allow-Credentials", "true")
+				}
+				if len(cors.ExposedHeaders) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+				}
+			}
+		}
+		handler(c)
+	}
+}
+
+// corsPreflight answers the OPTIONS request a browser sends before a
+// cross-origin call. Preflights carry no credentials, so it runs outside
+// authentication.
+func (cp *ClusterPlugin) corsPreflight(c *gin.Context) {
+	cp.mutex.RLock()
+	cors := cp.cors
+	cp.mutex.RUnlock()
+
+	origin := c.GetHeader("Origin")
+	method := c.GetHeader("Access-Control-Request-Method")
+	allowed := cors.allowedOrigin(origin)
+	methodAllowed := false
+	for _, m := range cors.AllowedMethods {
+		methodAllowed = methodAllowed || strings.EqualFold(m, method)
+	}
+	if origin == "" || allowed == "" || !methodAllowed {
+		c.Header("Vary", "Origin")
+		abortWithProblem(c, http.StatusForbidden, CodeOriginNotAllowed, fmt.Sprintf("Cross-origin %s requests from %q are not allowed", method, origin))
+		return
+	}
+
+	c.Header("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+	if len(cors.AllowedHeaders) > 0 {
+		c.Header("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+	}
+	if cors.MaxAgeSeconds > 0 {
+		c.Header("Access-Control-Max-Age", strconv.Itoa(cors.MaxAgeSeconds))
+	}
+	c.Status(http.StatusNoContent)
+}
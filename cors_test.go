@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSConfigFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cors    map[string]interface{}
+		wantErr bool
+	}{
+		{name: "default", cors: nil},
+		{name: "origins", cors: map[string]interface{}{"allowedOrigins": []interface{}{"http://localhost:3000", "https://dashboard.example.com"}}},
+		{name: "any origin", cors: map[string]interface{}{"allowedOrigins": []interface{}{"*"}}},
+		{name: "any origin with credentials", cors: map[string]interface{}{"allowedOrigins": []interface{}{"*"}, "allowCredentials": true}, wantErr: true},
+		{name: "origin with path", cors: map[string]interface{}{"allowedOrigins": []interface{}{"https://dashboard.example.com/app"}}, wantErr: true},
+		{name: "bad method", cors: map[string]interface{}{"allowedMethods": []interface{}{"FETCH"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{}
+			if tt.cors != nil {
+				config["cors"] = tt.cors
+			}
+			_, headers, err := corsConfigFromConfig(config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("corsConfigFromConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !headers.Enabled {
+				t.Error("security headers are off by default")
+			}
+		})
+	}
+}
+
+func TestCORSHeaders(t *testing.T) {
+	plugin := &ClusterPlugin{
+		cors: CORSConfig{
+			AllowedOrigins: []string{"http://localhost:3000"},
+			AllowedMethods: []string{http.MethodGet, http.MethodPost},
+			AllowedHeaders: []string{"Content-Type"},
+			ExposedHeaders: []string{requestIDHeader},
+			MaxAgeSeconds:  600,
+		},
+		securityHeaders: SecurityHeadersConfig{Enabled: true, HSTSMaxAgeSeconds: 3600},
+	}
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	const openapi = "/api/plugins/kubestellar-cluster-plugin/openapi.json"
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		preflight   string
+		wantStatus  int
+		wantOrigin  string
+		wantMethods string
+	}{
+		{name: "same origin", method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "allowed origin", method: http.MethodGet, origin: "http://localhost:3000", wantStatus: http.StatusOK, wantOrigin: "http://localhost:3000"},
+		{name: "other origin", method: http.MethodGet, origin: "https://evil.example.com", wantStatus: http.StatusOK},
+		{name: "preflight", method: http.MethodOptions, origin: "http://localhost:3000", preflight: http.MethodPost, wantStatus: http.StatusNoContent, wantOrigin: "http://localhost:3000", wantMethods: "GET, POST"},
+		{name: "preflight of a method not allowed", method: http.MethodOptions, origin: "http://localhost:3000", preflight: http.MethodDelete, wantStatus: http.StatusForbidden, wantOrigin: "http://localhost:3000"},
+		{name: "preflight from another origin", method: http.MethodOptions, origin: "https://evil.example.com", preflight: http.MethodGet, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, openapi, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			header := recorder.Header()
+			if got := header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := header.Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+			if header.Get("X-Content-Type-Options") != "nosniff" || header.Get("Strict-Transport-Security") != "max-age=3600" {
+				t.Errorf("security headers missing: %v", header)
+			}
+		})
+	}
+}
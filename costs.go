@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// costReportKind identifies a cost report export
+const costReportKind = "CostReport"
+
+// unassignedTeam collects the clusters without the tag a report groups by
+const unassignedTeam = "unassigned"
+
+// Tags a cost report groups clusters by
+const (
+	groupByCostCenter = "costCenter"
+	groupByOwner      = "owner"
+)
+
+// CostReport is the usage of the clusters over a time window per team, for
+// chargeback, produced by GET /costs/export
+type CostReport struct {
+	Kind        string `json:"kind"`
+	GeneratedAt string `json:"generatedAt"`
+	Since       string `json:"since"`
+	Until       string `json:"until"`
+	// GroupBy is the profile tag the teams are keyed by, costCenter or owner
+	GroupBy  string        `json:"groupBy"`
+	Teams    []TeamUsage   `json:"teams"`
+	Clusters []ClusterCost `json:"clusters"`
+}
+
+// ClusterCost is the usage of one cluster over the window. Usage is its
+// collected capacity times the hours it was Ready; a cluster whose resources
+// were never collected only reports ready hours.
+type ClusterCost struct {
+	ClusterName   string  `json:"clusterName"`
+	Hub           string  `json:"hub"`
+	Status        string  `json:"status"`
+	Team          string  `json:"team"`
+	Owner         string  `json:"owner,omitempty"`
+	CostCenter    string  `json:"costCenter,omitempty"`
+	ReadyHours    float64 `json:"readyHours"`
+	UptimePercent float64 `json:"uptimePercent"`
+	// Nodes, AllocatableCPU and AllocatableMemory are the resources last
+	// collected, as of ResourcesCollectedAt
+	Nodes                int     `json:"nodes"`
+	AllocatableCPU       string  `json:"allocatableCpu,omitempty"`
+	AllocatableMemory    string  `json:"allocatableMemory,omitempty"`
+	ResourcesCollectedAt string  `json:"resourcesCollectedAt,omitempty"`
+	NodeHours            float64 `json:"nodeHours"`
+	CPUCoreHours         float64 `json:"cpuCoreHours"`
+	MemoryGiBHours       float64 `json:"memoryGiBHours"`
+}
+
+// TeamUsage sums the usage of the clusters of one team
+type TeamUsage struct {
+	Team           string   `json:"team"`
+	Clusters       []string `json:"clusters"`
+	ReadyHours     float64  `json:"readyHours"`
+	NodeHours      float64  `json:"nodeHours"`
+	CPUCoreHours   float64  `json:"cpuCoreHours"`
+	MemoryGiBHours float64  `json:"memoryGiBHours"`
+}
+
+// costCSVHeader names the columns of a CSV cost report, one line per cluster
+var costCSVHeader = []string{
+	"team", "clusterName", "hub", "status", "owner", "costCenter", "readyHours", "uptimePercent",
+	"nodes", "allocatableCpu", "allocatableMemory", "nodeHours", "cpuCoreHours", "memoryGiBHours",
+}
+
+// roundHours rounds to two decimals
+func roundHours(hours float64) float64 {
+	return math.Round(hours*100) / 100
+}
+
+// clusterCost measures the usage of a cluster from its availability over the
+// window
+func clusterCost(cluster ClusterStatus, availability Availability, groupBy string) ClusterCost {
+	cost := ClusterCost{
+		ClusterName:   cluster.ClusterName,
+		Hub:           hubName(cluster),
+		Status:        cluster.Status,
+		ReadyHours:    roundHours(float64(availability.ReadySeconds) / 3600),
+		UptimePercent: availability.Percent,
+	}
+	if profile := cluster.Profile; profile != nil {
+		cost.Owner, cost.CostCenter = profile.Owner, profile.CostCenter
+	}
+	cost.Team = cost.CostCenter
+	if groupBy == groupByOwner {
+		cost.Team = cost.Owner
+	}
+	if cost.Team == "" {
+		cost.Team = unassignedTeam
+	}
+
+	resources := cluster.Resources
+	if resources == nil {
+		return cost
+	}
+	hours := float64(availability.ReadySeconds) / 3600
+	cost.Nodes = resources.Nodes
+	cost.AllocatableCPU, cost.AllocatableMemory = resources.AllocatableCPU, resources.AllocatableMemory
+	cost.ResourcesCollectedAt = resources.CollectedAt
+	cost.NodeHours = roundHours(float64(resources.Nodes) * hours)
+	if q, err := resource.ParseQuantity(resources.AllocatableCPU); err == nil {
+		cost.CPUCoreHours = roundHours(q.AsApproximateFloat64() * hours)
+	}
+	if q, err := resource.ParseQuantity(resources.AllocatableMemory); err == nil {
+		cost.MemoryGiBHours = roundHours(q.AsApproximateFloat64() / (1 << 30) * hours)
+	}
+	return cost
+}
+
+// teamUsage sums the cluster costs per team, sorted by team with the
+// unassigned clusters last
+func teamUsage(clusters []ClusterCost) []TeamUsage {
+	byTeam := map[string]*TeamUsage{}
+	for _, cluster := range clusters {
+		team, ok := byTeam[cluster.Team]
+		if !ok {
+			team = &TeamUsage{Team: cluster.Team}
+			byTeam[cluster.Team] = team
+		}
+		team.Clusters = append(team.Clusters, cluster.ClusterName)
+		team.ReadyHours += cluster.ReadyHours
+		team.NodeHours += cluster.NodeHours
+		team.CPUCoreHours += cluster.CPUCoreHours
+		team.MemoryGiBHours += cluster.MemoryGiBHours
+	}
+
+	teams := make([]TeamUsage, 0, len(byTeam))
+	for _, team := range byTeam {
+		team.ReadyHours = roundHours(team.ReadyHours)
+		team.NodeHours = roundHours(team.NodeHours)
+		team.CPUCoreHours = roundHours(team.CPUCoreHours)
+		team.MemoryGiBHours = roundHours(team.MemoryGiBHours)
+		sort.Strings(team.Clusters)
+		teams = append(teams, *team)
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		if (teams[i].Team == unassignedTeam) != (teams[j].Team == unassignedTeam) {
+			return teams[j].Team == unassignedTeam
+		}
+		return teams[i].Team < teams[j].Team
+	})
+	return teams
+}
+
+// buildCostReport joins the clusters with their uptime from the history
+// store over the window
+func (cp *ClusterPlugin) buildCostReport(clusters []ClusterStatus, since, until time.Time, groupBy string) (CostReport, error) {
+	report := CostReport{
+		Kind:        costReportKind,
+		GeneratedAt: time.Now().Format(time.RFC3339),
+		Since:       since.Format(time.RFC3339),
+		Until:       until.Format(time.RFC3339),
+		GroupBy:     groupBy,
+		Clusters:    make([]ClusterCost, 0, len(clusters)),
+	}
+	for _, cluster := range clusters {
+		entries, err := cp.store.History(cluster.ClusterName)
+		if err != nil {
+			return report, err
+		}
+		report.Clusters = append(report.Clusters, clusterCost(cluster, computeAvailability(entries, since, until), groupBy))
+	}
+	report.Teams = teamUsage(report.Clusters)
+	return report, nil
+}
+
+// encodeCostCSV writes one line per cluster of the report, so the teams can
+// be pivoted on the team column
+func encodeCostCSV(report CostReport) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(costCSVHeader); err != nil {
+		return nil, err
+	}
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) }
+	for _, cluster := range report.Clusters {
+		record := []string{
+			cluster.Team, cluster.ClusterName, cluster.Hub, cluster.Status, cluster.Owner, cluster.CostCenter,
+			formatFloat(cluster.ReadyHours), formatFloat(cluster.UptimePercent),
+			strconv.Itoa(cluster.Nodes), cluster.AllocatableCPU, cluster.AllocatableMemory,
+			formatFloat(cluster.NodeHours), formatFloat(cluster.CPUCoreHours), formatFloat(cluster.MemoryGiBHours),
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// ExportCostsHandler returns the per-team usage of the clusters over the
+// ?since and ?until RFC 3339 time range, 30 days by default, as JSON or CSV.
+// Teams are keyed by the ?groupBy profile tag, costCenter or owner. The
+// status, hub and labelSelector filters of GET /status narrow it down.
+// Detached clusters are left out, their tags gone with their records.
+func (cp *ClusterPlugin) ExportCostsHandler(c *gin.Context) {
+	query, err := parseClusterQuery(c)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	contentTypes := map[string]string{
+		"json": "application/json; charset=utf-8",
+		"csv":  "text/csv; charset=utf-8",
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid format %q, must be json or csv", format))
+		return
+	}
+	groupBy := c.DefaultQuery("groupBy", groupByCostCenter)
+	if groupBy != groupByCostCenter && groupBy != groupByOwner {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid groupBy %q, must be %s or %s", groupBy, groupByCostCenter, groupByOwner))
+		return
+	}
+	since, until, err := parseTimeRange(c, 30*24*time.Hour)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	snapshot, err := cp.statusCache.get(c.Query("refresh") == "true", cp.loadStatusSnapshot)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	report, err := cp.buildCostReport(query.Filter(tenantClusters(c.Request.Context(), snapshot.clusters)), since, until, groupBy)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	var data []byte
+	if format == "csv" {
+		data, err = encodeCostCSV(report)
+	} else {
+		data, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to encode cost report: %v", err))
+		return
+	}
+
+	filename := fmt.Sprintf("cost-report-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, contentType, data)
+}
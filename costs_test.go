@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClusterCost(t *testing.T) {
+	cluster := ClusterStatus{
+		ClusterName: "edge-1",
+		Status:      "Ready",
+		Profile:     &ClusterProfile{Owner: "platform", CostCenter: "cc-42"},
+		Resources:   &ClusterResources{Nodes: 3, AllocatableCPU: "7500m", AllocatableMemory: "32Gi"},
+	}
+	availability := Availability{ReadySeconds: 2 * 3600, Percent: 50}
+
+	cost := clusterCost(cluster, availability, groupByCostCenter)
+	if cost.Team != "cc-42" || cost.ReadyHours != 2 || cost.NodeHours != 6 || cost.CPUCoreHours != 15 || cost.MemoryGiBHours != 64 {
+		t.Errorf("cost by cost center = %+v", cost)
+	}
+	if cost := clusterCost(cluster, availability, groupByOwner); cost.Team != "platform" {
+		t.Errorf("team by owner = %q, want platform", cost.Team)
+	}
+	// Clusters without the tag or resources still report their hours
+	cost = clusterCost(ClusterStatus{ClusterName: "edge-2"}, availability, groupByCostCenter)
+	if cost.Team != unassignedTeam || cost.ReadyHours != 2 || cost.NodeHours != 0 {
+		t.Errorf("untagged cost = %+v", cost)
+	}
+
+	teams := teamUsage([]ClusterCost{
+		{ClusterName: "b", Team: unassignedTeam, ReadyHours: 1},
+		{ClusterName: "c", Team: "cc-42", ReadyHours: 2, NodeHours: 4},
+		{ClusterName: "a", Team: "cc-42", ReadyHours: 3, NodeHours: 6},
+	})
+	if len(teams) != 2 || teams[0].Team != "cc-42" || teams[0].ReadyHours != 5 || teams[0].NodeHours != 10 || teams[0].Clusters[0] != "a" || teams[1].Team != unassignedTeam {
+		t.Errorf("teamUsage() = %+v", teams)
+	}
+}
+
+func TestExportCostsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0})
+	plugin.store.Put(ClusterStatus{
+		ClusterName: "edge-1", Status: "Unreachable",
+		Profile:   &ClusterProfile{Owner: "platform", CostCenter: "cc-42"},
+		Resources: &ClusterResources{Nodes: 2, AllocatableCPU: "4", AllocatableMemory: "16Gi"},
+	})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-2", Status: "Ready"})
+	for _, entry := range []HistoryEntry{
+		{ClusterName: "edge-1", Timestamp: "2026-01-01T00:00:00Z", Status: "Ready", Event: "onboarded"},
+		{ClusterName: "edge-1", Timestamp: "2026-01-01T12:00:00Z", Status: "Unreachable", Previous: "Ready", Event: "unreachable"},
+		{ClusterName: "edge-2", Timestamp: "2025-12-31T00:00:00Z", Status: "Ready", Event: "onboarded"},
+	} {
+		if err := plugin.store.AppendHistory(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	export := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/costs/export"+query, nil))
+		return recorder
+	}
+	window := "?since=2026-01-01T00:00:00Z&until=" + time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+
+	recorder := export(window)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /costs/export = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var report CostReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Kind != costReportKind || report.GroupBy != groupByCostCenter || len(report.Teams) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	if team := report.Teams[0]; team.Team != "cc-42" || team.ReadyHours != 12 || team.NodeHours != 24 || team.CPUCoreHours != 48 || team.MemoryGiBHours != 192 {
+		t.Errorf("cc-42 usage = %+v", team)
+	}
+	if team := report.Teams[1]; team.Team != unassignedTeam || team.ReadyHours != 24 {
+		t.Errorf("unassigned usage = %+v", team)
+	}
+
+	recorder = export(window + "&format=csv&groupBy=owner&status=Unreachable")
+	records, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][0] != "platform" || records[1][1] != "edge-1" || records[1][6] != "12.00" {
+		t.Errorf("CSV report = %v", records)
+	}
+
+	// Without since the report covers the 30 days before until, not before now
+	recorder = export("?until=2026-01-02T00:00:00Z")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /costs/export?until = %d %s", recorder.Code, recorder.Body.String())
+	}
+	report = CostReport{}
+	json.Unmarshal(recorder.Body.Bytes(), &report)
+	if report.Since != "2025-12-03T00:00:00Z" || len(report.Teams) != 2 || report.Teams[1].ReadyHours != 48 {
+		t.Errorf("report until 2026-01-02 = %+v", report)
+	}
+
+	for _, query := range []string{"?groupBy=team", "?format=yaml", "?since=yesterday", "?since=2026-01-02T00:00:00Z&until=2026-01-01T00:00:00Z"} {
+		if recorder := export(query); recorder.Code != http.StatusBadRequest {
+			t.Errorf("GET /costs/export%s = %d, want 400", query, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// envelopeMagic starts every blob sealed by envelope encryption. Blobs
+// without it were sealed directly with a key by older versions.
+var envelopeMagic = []byte("ksp\x01")
+
+const (
+	keyIDSize = 8
+	dekSize   = 32
+)
+
+// secretBox encrypts small blobs such as kubeconfigs with envelope
+// encryption: each blob gets a random AES-256-GCM data key, which is itself
+// sealed with the current key encryption key and stored next to the
+// ciphertext. Previous keys are kept to open older blobs until they are
+// resealed, so keys can be rotated without losing state.
+type secretBox struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+	// order lists the key IDs, current first, for blobs that carry none
+	order []string
+}
+
+// newSecretBox seals with key and opens blobs sealed with key or any of the
+// previous keys
+func newSecretBox(key []byte, previous ...[]byte) (*secretBox, error) {
+	sb := &secretBox{keys: make(map[string]cipher.AEAD)}
+	for i, k := range append([][]byte{key}, previous...) {
+		aead, err := newAEAD(k)
+		if err != nil {
+			if i > 0 {
+				return nil, fmt.Errorf("previous encryption key %d: %w", i, err)
+			}
+			return nil, err
+		}
+		id := keyID(k)
+		if i == 0 {
+			sb.currentID = id
+		}
+		if _, seen := sb.keys[id]; !seen {
+			sb.keys[id] = aead
+			sb.order = append(sb.order, id)
+		}
+	}
+	return sb, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// keyID names a key encryption key without revealing it
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return string(sum[:keyIDSize])
+}
+
+// KeyID returns the hex ID of the current key encryption key
+func (sb *secretBox) KeyID() string {
+	return hex.EncodeToString([]byte(sb.currentID))
+}
+
+// Seal encrypts plaintext with a fresh data key and wraps that key with the
+// current key encryption key
+func (sb *secretBox) Seal(plaintext []byte) ([]byte, error) {
+	dek := make([]byte, dekSize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	dataAEAD, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := sealWith(dataAEAD, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return sb.envelope(dek, payload)
+}
+
+// envelope wraps dek with the current key and prefixes payload with it
+func (sb *secretBox) envelope(dek, payload []byte) ([]byte, error) {
+	wrapped, err := sealWith(sb.keys[sb.currentID], dek)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(envelopeMagic)+keyIDSize+len(wrapped)+len(payload))
+	out = append(out, envelopeMagic...)
+	out = append(out, sb.currentID...)
+	out = append(out, wrapped...)
+	return append(out, payload...), nil
+}
+
+// Open decrypts data produced by Seal, or sealed directly with one of the
+// keys by versions before envelope encryption
+func (sb *secretBox) Open(data []byte) ([]byte, error) {
+	dek, payload, err := sb.unwrap(data)
+	if err == nil {
+		dataAEAD, err := newAEAD(dek)
+		if err != nil {
+			return nil, err
+		}
+		return openWith(dataAEAD, payload)
+	}
+	for _, id := range sb.order {
+		if plaintext, err := openWith(sb.keys[id], data); err == nil {
+			return plaintext, nil
+		}
+	}
+	if bytes.HasPrefix(data, envelopeMagic) {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return nil, fmt.Errorf("failed to decrypt: no encryption key opens the data")
+}
+
+// Reseal wraps data under the current key. It reports false when data
+// already was, leaving it untouched; only the data key is rewrapped, so
+// large blobs aren't encrypted again.
+func (sb *secretBox) Reseal(data []byte) ([]byte, bool, error) {
+	dek, payload, err := sb.unwrap(data)
+	if err != nil {
+		plaintext, err := sb.Open(data)
+		if err != nil {
+			return nil, false, err
+		}
+		sealed, err := sb.Seal(plaintext)
+		return sealed, err == nil, err
+	}
+	if string(data[len(envelopeMagic):len(envelopeMagic)+keyIDSize]) == sb.currentID {
+		return data, false, nil
+	}
+	sealed, err := sb.envelope(dek, payload)
+	return sealed, err == nil, err
+}
+
+// unwrap splits an envelope into its opened data key and payload
+func (sb *secretBox) unwrap(data []byte) ([]byte, []byte, error) {
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		return nil, nil, fmt.Errorf("not an envelope")
+	}
+	rest := data[len(envelopeMagic):]
+	if len(rest) < keyIDSize {
+		return nil, nil, fmt.Errorf("envelope too short")
+	}
+	aead, ok := sb.keys[string(rest[:keyIDSize])]
+	if !ok {
+		return nil, nil, fmt.Errorf("sealed with unknown key %s", hex.EncodeToString(rest[:keyIDSize]))
+	}
+	rest = rest[keyIDSize:]
+	wrappedSize := aead.NonceSize() + dekSize + aead.Overhead()
+	if len(rest) < wrappedSize {
+		return nil, nil, fmt.Errorf("envelope too short")
+	}
+	dek, err := openWith(aead, rest[:wrappedSize])
+	if err != nil {
+		return nil, nil, err
+	}
+	return dek, rest[wrappedSize:], nil
+}
+
+// resealer is implemented by the components that keep sealed state on disk
+type resealer interface {
+	// reseal seals anything not sealed with the current key under it,
+	// returning how many entries it rewrote
+	reseal() (int, error)
+}
+
+// resealState moves all persisted state under the current encryption key,
+// so that after a rotation the previous keys can be dropped from the config
+func (cp *ClusterPlugin) resealState() {
+	components := map[string]interface{}{
+		"clusters":    cp.store,
+		"secrets":     cp.secrets,
+		"contexts":    cp.kubeconfigs,
+		"hubs":        cp.hubs,
+		"schedules":   cp.schedules,
+		"checkpoints": cp.checkpoints,
+	}
+	for name, component := range components {
+		r, ok := component.(resealer)
+		if !ok {
+			continue
+		}
+		resealed, err := r.reseal()
+		if err != nil {
+			logger().Warn("Failed to reseal state with the current encryption key", "component", name, "error", err)
+		}
+		if resealed > 0 {
+			logger().Info("Resealed state with the current encryption key", "component", name, "entries", resealed, "key", cp.box.KeyID())
+		}
+	}
+}
+
+// resealFiles reseals the files matching pattern that aren't sealed with
+// the current key, returning how many it rewrote
+func resealFiles(box *secretBox, pattern string) (int, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return 0, err
+	}
+	resealed := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return resealed, err
+		}
+		sealed, changed, err := box.Reseal(data)
+		if err != nil {
+			return resealed, fmt.Errorf("%s: %w", file, err)
+		}
+		if !changed {
+			continue
+		}
+		tmpPath := file + ".tmp"
+		if err := os.WriteFile(tmpPath, sealed, 0600); err != nil {
+			return resealed, err
+		}
+		if err := os.Rename(tmpPath, file); err != nil {
+			return resealed, err
+		}
+		resealed++
+	}
+	return resealed, nil
+}
+
+// sealWith encrypts plaintext and prefixes the result with its random nonce
+func sealWith(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openWith decrypts data produced by sealWith
+func openWith(aead cipher.AEAD, data []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	plaintext, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// loadEncryptionKey returns the base64 key passed via Initialize config or
+// printed by encryptionKeyCommand, a host hook that can fetch the key from a
+// KMS. Otherwise it reads (creating on first use) a random key from
+// encryptionKeyPath, which defaults to the user config directory. The key file is refused inside
+// dataDir so that a copy of the plugin state never carries its own key.
+//
+// A generated key only protects the ciphertexts when the data directory leaks
+// on its own (backups, shared volumes); anyone who can read the host's config
+// directory can still decrypt them. Hosts that need a real boundary should
+// supply encryptionKey from their own secret store.
+func loadEncryptionKey(config map[string]interface{}, dataDir string) ([]byte, error) {
+	if encoded := configString(config, "encryptionKey", ""); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryptionKey is not valid base64: %w", err)
+		}
+		return key, nil
+	}
+	if command := strings.Fields(configString(config, "encryptionKeyCommand", "")); len(command) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		output, err := exec.CommandContext(ctx, command[0], command[1:]...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("encryptionKeyCommand failed: %w", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(output)))
+		if err != nil {
+			return nil, fmt.Errorf("encryptionKeyCommand printed invalid base64: %w", err)
+		}
+		return key, nil
+	}
+
+	keyPath := configString(config, "encryptionKeyPath", "")
+	if keyPath == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("no encryptionKey given and no config directory for a key file: %w", err)
+		}
+		keyPath = filepath.Join(configDir, "kubestellar-cluster-plugin", "encryption.key")
+	}
+	if within(dataDir, keyPath) {
+		return nil, fmt.Errorf("encryptionKeyPath %s must not be inside the data directory %s", keyPath, dataDir)
+	}
+
+	if data, err := os.ReadFile(keyPath); err == nil {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create encryption key directory: %w", err)
+	}
+	if err := os.WriteFile(keyPath, []byte(base64.StdEncoding.EncodeToString(key)), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write encryption key: %w", err)
+	}
+	return key, nil
+}
+
+// loadPreviousEncryptionKeys returns the base64 keys listed in
+// previousEncryptionKeys. They only open state sealed before a key rotation,
+// which Initialize then reseals with the current key.
+func loadPreviousEncryptionKeys(config map[string]interface{}) ([][]byte, error) {
+	var keys [][]byte
+	for i, encoded := range configStrings(config, "previousEncryptionKeys") {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("previousEncryptionKeys[%d] is not valid base64: %w", i, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// within reports whether path is dir or lies below it
+func within(dir, path string) bool {
+	dir, errDir := filepath.Abs(dir)
+	path, errPath := filepath.Abs(path)
+	if errDir != nil || errPath != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
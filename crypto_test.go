@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretBox(t *testing.T) {
+	box, err := newSecretBox(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("newSecretBox() error = %v", err)
+	}
+	sealed, err := box.Seal([]byte("kubeconfig"))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if bytes.Contains(sealed, []byte("kubeconfig")) {
+		t.Error("Seal() left the plaintext readable")
+	}
+	if opened, err := box.Open(sealed); err != nil || string(opened) != "kubeconfig" {
+		t.Errorf("Open() = %q, %v, want the plaintext", opened, err)
+	}
+
+	other, _ := newSecretBox(bytes.Repeat([]byte{8}, 32))
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("Open() with another key succeeded")
+	}
+	if _, err := newSecretBox([]byte("short")); err == nil {
+		t.Error("newSecretBox() accepted a 5 byte key")
+	}
+}
+
+func TestSecretBoxRotation(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{7}, 32), bytes.Repeat([]byte{8}, 32)
+	oldBox, _ := newSecretBox(oldKey)
+	sealed, _ := oldBox.Seal([]byte("kubeconfig"))
+	// Versions before envelope encryption sealed with the key directly
+	direct, _ := sealWith(oldBox.keys[oldBox.currentID], []byte("kubeconfig"))
+
+	rotated, err := newSecretBox(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("newSecretBox() with a previous key error = %v", err)
+	}
+	newOnly, _ := newSecretBox(newKey)
+	for name, data := range map[string][]byte{"envelope": sealed, "direct": direct} {
+		if opened, err := rotated.Open(data); err != nil || string(opened) != "kubeconfig" {
+			t.Errorf("Open() of %s data sealed with the previous key = %q, %v", name, opened, err)
+		}
+		resealed, changed, err := rotated.Reseal(data)
+		if err != nil || !changed {
+			t.Fatalf("Reseal() of %s data = %v, %v, want it resealed", name, changed, err)
+		}
+		if opened, err := newOnly.Open(resealed); err != nil || string(opened) != "kubeconfig" {
+			t.Errorf("Open() of resealed %s data without the previous key = %q, %v", name, opened, err)
+		}
+		if again, changed, err := rotated.Reseal(resealed); err != nil || changed || !bytes.Equal(again, resealed) {
+			t.Errorf("Reseal() of current data = %v, %v, want it untouched", changed, err)
+		}
+	}
+	if _, err := newOnly.Open(sealed); err == nil {
+		t.Error("Open() succeeded after the previous key was dropped")
+	}
+	if _, err := newSecretBox(newKey, []byte("short")); err == nil {
+		t.Error("newSecretBox() accepted a 5 byte previous key")
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	dataDir := t.TempDir()
+	key := bytes.Repeat([]byte{7}, 32)
+
+	got, err := loadEncryptionKey(map[string]interface{}{"encryptionKey": base64.StdEncoding.EncodeToString(key)}, dataDir)
+	if err != nil || !bytes.Equal(got, key) {
+		t.Errorf("loadEncryptionKey() from config = %v, %v, want the configured key", got, err)
+	}
+	if _, err := loadEncryptionKey(map[string]interface{}{"encryptionKey": "not base64!"}, dataDir); err == nil {
+		t.Error("loadEncryptionKey() accepted an invalid encryptionKey")
+	}
+	command := map[string]interface{}{"encryptionKeyCommand": "echo " + base64.StdEncoding.EncodeToString(key)}
+	if got, err := loadEncryptionKey(command, dataDir); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("loadEncryptionKey() from encryptionKeyCommand = %v, %v, want the printed key", got, err)
+	}
+	if _, err := loadEncryptionKey(map[string]interface{}{"encryptionKeyCommand": "false"}, dataDir); err == nil {
+		t.Error("loadEncryptionKey() accepted a failing encryptionKeyCommand")
+	}
+
+	keyPath := filepath.Join(t.TempDir(), "keys", "encryption.key")
+	config := map[string]interface{}{"encryptionKeyPath": keyPath}
+	generated, err := loadEncryptionKey(config, dataDir)
+	if err != nil || len(generated) != 32 {
+		t.Fatalf("loadEncryptionKey() generating = %d bytes, %v, want 32", len(generated), err)
+	}
+	if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file = %v, %v, want mode 0600", info, err)
+	}
+	if reused, err := loadEncryptionKey(config, dataDir); err != nil || !bytes.Equal(reused, generated) {
+		t.Errorf("loadEncryptionKey() again = %v, %v, want the generated key", reused, err)
+	}
+
+	for _, inside := range []string{filepath.Join(dataDir, "encryption.key"), filepath.Join(dataDir, "keys", "encryption.key")} {
+		if _, err := loadEncryptionKey(map[string]interface{}{"encryptionKeyPath": inside}, dataDir); err == nil {
+			t.Errorf("loadEncryptionKey() accepted %s inside the data directory", inside)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "encryption.key")); !os.IsNotExist(err) {
+		t.Error("loadEncryptionKey() wrote a key inside the data directory")
+	}
+}
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		dir, path string
+		want      bool
+	}{
+		{dir: "/data", path: "/data", want: true},
+		{dir: "/data", path: "/data/keys/key", want: true},
+		{dir: "/data", path: "/data/../etc/key"},
+		{dir: "/data", path: "/data-keys/key"},
+		{dir: "/data", path: "/etc/key"},
+	}
+	for _, tt := range tests {
+		if got := within(tt.dir, tt.path); got != tt.want {
+			t.Errorf("within(%q, %q) = %v, want %v", tt.dir, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSavedKubeconfigSealed(t *testing.T) {
+	box, _ := newSecretBox(bytes.Repeat([]byte{7}, 32))
+	dir := t.TempDir()
+	plugin := &ClusterPlugin{box: box, secrets: &fileSecretStore{dir: dir, box: box}, tokens: NewTokenManager()}
+
+	if err := plugin.saveKubeconfig("edge-1", []byte(testHubKubeconfig)); err != nil {
+		t.Fatalf("saveKubeconfig() error = %v", err)
+	}
+	onDisk, _ := os.ReadFile(filepath.Join(dir, "edge-1-kubeconfig.enc"))
+	if len(onDisk) == 0 || bytes.Contains(onDisk, []byte("token: secret")) {
+		t.Error("saved kubeconfig is missing or readable on disk")
+	}
+	if got := plugin.savedKubeconfig("edge-1"); string(got) != testHubKubeconfig {
+		t.Errorf("savedKubeconfig() = %q, want the saved kubeconfig", got)
+	}
+
+	if err := plugin.cleanupLocalResources("edge-1"); err != nil || plugin.savedKubeconfig("edge-1") != nil {
+		t.Errorf("cleanupLocalResources() = %v, kubeconfig still saved", err)
+	}
+}
+
+func TestInitializeReseals(t *testing.T) {
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32))
+	config := map[string]interface{}{
+		"storePath":            filepath.Join(t.TempDir(), "clusters.db"),
+		"hubStoreDir":          t.TempDir(),
+		"scheduleStorePath":    filepath.Join(t.TempDir(), "schedules.json"),
+		"watchManagedClusters": false,
+		"monitorHeartbeats":    false,
+		"logLevel":             "error",
+	}
+	start := func(key string, previous ...interface{}) *ClusterPlugin {
+		config["encryptionKey"] = key
+		config["previousEncryptionKeys"] = previous
+		plugin := &ClusterPlugin{}
+		if err := plugin.Initialize(config); err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		return plugin
+	}
+
+	plugin := start(oldKey)
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	plugin.Cleanup()
+
+	// The rotation reseals the inventory, so the old key can be dropped next
+	start(newKey, oldKey).Cleanup()
+	plugin = start(newKey)
+	defer plugin.Cleanup()
+	if status, exists, err := plugin.store.Get("edge-1"); err != nil || !exists || status.Status != "Ready" {
+		t.Errorf("cluster after the key rotation = %+v, %v, %v", status, exists, err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The profiling endpoints are built on runtime/pprof rather than
+// net/http/pprof and expvar, which register their handlers on the default
+// mux of the host process as soon as they are imported.
+
+// cpuProfileSeconds and traceSeconds are how long a CPU profile and an
+// execution trace record by default
+const (
+	cpuProfileSeconds = 30
+	traceSeconds      = 1
+)
+
+// ProfileInfo lists a runtime profile served under /debug/pprof
+type ProfileInfo struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Path  string `json:"path"`
+}
+
+// requireDebugEndpoints answers 404 unless debugEndpoints is enabled
+func (cp *ClusterPlugin) requireDebugEndpoints(c *gin.Context) bool {
+	if !cp.debugEndpoints {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, "Debug endpoints are disabled")
+		return false
+	}
+	return true
+}
+
+// GetPprofIndexHandler lists the runtime profiles
+func (cp *ClusterPlugin) GetPprofIndexHandler(c *gin.Context) {
+	if !cp.requireDebugEndpoints(c) {
+		return
+	}
+	base := strings.TrimSuffix(c.Request.URL.Path, "/")
+	profiles := []ProfileInfo{}
+	for _, profile := range pprof.Profiles() {
+		profiles = append(profiles, ProfileInfo{Name: profile.Name(), Count: profile.Count(), Path: base + "/" + profile.Name()})
+	}
+	for _, name := range []string{"profile", "trace"} {
+		profiles = append(profiles, ProfileInfo{Name: name, Path: base + "/" + name})
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	c.JSON(http.StatusOK, gin.H{
+		"profiles":  profiles,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// recordingSeconds reads ?seconds for a CPU profile or trace, which must end
+// before the request times out
+func recordingSeconds(c *gin.Context, fallback int) (time.Duration, bool) {
+	seconds := fallback
+	if raw := c.Query("seconds"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid seconds %q, must be a positive integer", raw))
+			return 0, false
+		}
+		seconds = parsed
+	}
+	duration := time.Duration(seconds) * time.Second
+	if deadline, ok := c.Request.Context().Deadline(); ok && time.Now().Add(duration).After(deadline) {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("recording for %ds would outlast the request timeout", seconds))
+		return 0, false
+	}
+	return duration, true
+}
+
+// GetPprofProfileHandler serves a runtime profile in the pprof format, or as
+// text with ?debug=1. The profile endpoint records the CPU and trace the
+// execution for ?seconds.
+func (cp *ClusterPlugin) GetPprofProfileHandler(c *gin.Context) {
+	if !cp.requireDebugEndpoints(c) {
+		return
+	}
+	name := c.Param("profile")
+	attachment := func() {
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	wait := func(duration time.Duration) {
+		select {
+		case <-c.Request.Context().Done():
+		case <-time.After(duration):
+		}
+	}
+
+	switch name {
+	case "profile":
+		duration, ok := recordingSeconds(c, cpuProfileSeconds)
+		if !ok {
+			return
+		}
+		attachment()
+		if err := pprof.StartCPUProfile(c.Writer); err != nil {
+			// Only one CPU profile can run at a time
+			respondProblem(c, http.StatusConflict, CodeConflict, fmt.Sprintf("Could not start CPU profile: %v", err))
+			return
+		}
+		wait(duration)
+		pprof.StopCPUProfile()
+	case "trace":
+		duration, ok := recordingSeconds(c, traceSeconds)
+		if !ok {
+			return
+		}
+		attachment()
+		if err := trace.Start(c.Writer); err != nil {
+			respondProblem(c, http.StatusConflict, CodeConflict, fmt.Sprintf("Could not start trace: %v", err))
+			return
+		}
+		wait(duration)
+		trace.Stop()
+	default:
+		profile := pprof.Lookup(name)
+		if profile == nil {
+			respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Unknown profile %q", name))
+			return
+		}
+		debug, _ := strconv.Atoi(c.Query("debug"))
+		if name == "heap" && c.Query("gc") != "" {
+			runtime.GC()
+		}
+		if debug > 0 {
+			c.Header("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			attachment()
+		}
+		c.Status(http.StatusOK)
+		if err := profile.WriteTo(c.Writer, debug); err != nil {
+			requestLogger(c).Warn("Failed to write profile", "profile", name, "error", err)
+		}
+	}
+}
+
+// GetDebugVarsHandler serves the runtime and plugin variables in the layout
+// of expvar's /debug/vars
+func (cp *ClusterPlugin) GetDebugVarsHandler(c *gin.Context) {
+	if !cp.requireDebugEndpoints(c) {
+		return
+	}
+	var memstats runtime.MemStats
+	runtime.ReadMemStats(&memstats)
+	vars := gin.H{
+		"cmdline":    os.Args,
+		"memstats":   memstats,
+		"goroutines": runtime.NumGoroutine(),
+		"jobs": gin.H{
+			"active": cp.jobs.Active(),
+			"pool":   cp.jobs.PoolStats(),
+		},
+	}
+	if clusters, err := cp.store.List(); err == nil {
+		vars["clusters"] = len(clusters)
+	}
+	c.JSON(http.StatusOK, vars)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"debugEndpoints": true})
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/plugins/kubestellar-cluster-plugin/v1/debug"+path, nil))
+		return recorder
+	}
+
+	var index struct {
+		Profiles []ProfileInfo `json:"profiles"`
+	}
+	if err := json.Unmarshal(get("/pprof").Body.Bytes(), &index); err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]string{}
+	for _, profile := range index.Profiles {
+		found[profile.Name] = profile.Path
+	}
+	if found["heap"] != "/api/plugins/kubestellar-cluster-plugin/v1/debug/pprof/heap" || found["profile"] == "" {
+		t.Errorf("profiles = %+v", index.Profiles)
+	}
+
+	recorder := get("/pprof/goroutine?debug=1")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "goroutine profile") {
+		t.Errorf("goroutine profile = %d %.100s", recorder.Code, recorder.Body.String())
+	}
+	recorder = get("/pprof/heap?gc=1")
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/octet-stream" || recorder.Body.Len() == 0 {
+		t.Errorf("heap profile = %d %v", recorder.Code, recorder.Header())
+	}
+	if recorder := get("/pprof/profile?seconds=1"); recorder.Code != http.StatusOK || recorder.Body.Len() == 0 {
+		t.Errorf("CPU profile = %d", recorder.Code)
+	}
+	if recorder := get("/pprof/profile?seconds=600"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("CPU profile past the request timeout = %d", recorder.Code)
+	}
+	if recorder := get("/pprof/nosuch"); recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown profile = %d", recorder.Code)
+	}
+
+	var vars struct {
+		Memstats struct {
+			HeapAlloc uint64
+		} `json:"memstats"`
+		Goroutines int `json:"goroutines"`
+		Clusters   int `json:"clusters"`
+	}
+	if err := json.Unmarshal(get("/vars").Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Memstats.HeapAlloc == 0 || vars.Goroutines == 0 || vars.Clusters != 1 {
+		t.Errorf("vars = %+v", vars)
+	}
+}
+
+func TestDebugEndpointsDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router, err := newServerRouter(newTestPlugin(t), "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	for _, path := range []string{"/debug/pprof", "/debug/pprof/heap", "/debug/vars"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/plugins/kubestellar-cluster-plugin/v1"+path, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("%s = %d, want 404", path, recorder.Code)
+		}
+	}
+}
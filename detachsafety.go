@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// detachSafety policies decide what happens when a cluster being detached
+// still serves workloads, unless the detachment is forced
+const (
+	detachSafetyRefuse = "refuse"
+	detachSafetyWarn   = "warn"
+	detachSafetyOff    = "off"
+)
+
+var (
+	bindingPolicyGVR = schema.GroupVersionResource{Group: "control.kubestellar.io", Version: "v1alpha1", Resource: "bindingpolicies"}
+	bindingGVR       = schema.GroupVersionResource{Group: "control.kubestellar.io", Version: "v1alpha1", Resource: "bindings"}
+	// PlacementDecisions list the clusters an OCM Placement selected
+	placementDecisionGVR = schema.GroupVersionResource{Group: "cluster.open-cluster-management.io", Version: "v1beta1", Resource: "placementdecisions"}
+	manifestWorkGVR      = schema.GroupVersionResource{Group: "work.open-cluster-management.io", Version: "v1", Resource: "manifestworks"}
+)
+
+// placementLabel names the Placement a PlacementDecision belongs to
+const placementLabel = "cluster.open-cluster-management.io/placement"
+
+// addOnLabel marks the ManifestWorks that deploy add-ons rather than workloads
+const addOnLabel = "open-cluster-management.io/addon-name"
+
+// DetachSafetyReport lists what still targets a cluster about to be detached
+type DetachSafetyReport struct {
+	ClusterName string `json:"clusterName"`
+	// Safe is true when nothing targets the cluster
+	Safe bool `json:"safe"`
+	// BindingPolicies on the WDS whose cluster selectors match the cluster
+	BindingPolicies []string `json:"bindingPolicies,omitempty"`
+	// Placements on the hub that decided for the cluster, as namespace/name
+	Placements []string `json:"placements,omitempty"`
+	// Workloads are the ManifestWorks delivering objects to the cluster and
+	// the number of objects each Binding distributes to it
+	Workloads []string `json:"workloads,omitempty"`
+	// Warnings name the checks that couldn't be made
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// summary describes what targets the cluster in one line
+func (r DetachSafetyReport) summary() string {
+	var parts []string
+	if len(r.BindingPolicies) > 0 {
+		parts = append(parts, fmt.Sprintf("BindingPolicies %s", strings.Join(r.BindingPolicies, ", ")))
+	}
+	if len(r.Placements) > 0 {
+		parts = append(parts, fmt.Sprintf("Placements %s", strings.Join(r.Placements, ", ")))
+	}
+	if len(r.Workloads) > 0 {
+		parts = append(parts, fmt.Sprintf("workloads %s", strings.Join(r.Workloads, ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ClusterInUseError refuses to detach a cluster that still serves workloads
+type ClusterInUseError struct {
+	Report DetachSafetyReport
+}
+
+func (e *ClusterInUseError) Error() string {
+	return fmt.Sprintf("cluster '%s' is still targeted by %s; detach with force to proceed", e.Report.ClusterName, e.Report.summary())
+}
+
+// inspectWorkloads collects what targets the cluster: the BindingPolicies and
+// Bindings on the WDS, and the PlacementDecisions and ManifestWorks on the
+// hub. Either client may be nil when it couldn't be created, and a missing
+// API just means that kind of object isn't in use.
+func inspectWorkloads(ctx context.Context, wds, hub dynamic.Interface, clusterName string, clusterLabels map[string]string) DetachSafetyReport {
+	report := DetachSafetyReport{ClusterName: clusterName}
+	list := func(client dynamic.Interface, gvr schema.GroupVersionResource, namespace string) []unstructured.Unstructured {
+		list, err := client.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("failed to list %s: %v", gvr.Resource, err))
+			}
+			return nil
+		}
+		return list.Items
+	}
+
+	if wds != nil {
+		for _, policy := range list(wds, bindingPolicyGVR, "") {
+			selectors, _, _ := unstructured.NestedSlice(policy.Object, "spec", "clusterSelectors")
+			for _, raw := range selectors {
+				object, ok := raw.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				var selector metav1.LabelSelector
+				if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object, &selector); err != nil {
+					continue
+				}
+				parsed, err := metav1.LabelSelectorAsSelector(&selector)
+				// An empty selector selects no cluster in KubeStellar
+				if err != nil || parsed.Empty() || !parsed.Matches(k8slabels.Set(clusterLabels)) {
+					continue
+				}
+				report.BindingPolicies = append(report.BindingPolicies, policy.GetName())
+				break
+			}
+		}
+		for _, binding := range list(wds, bindingGVR, "") {
+			destinations, _, _ := unstructured.NestedSlice(binding.Object, "spec", "destinations")
+			for _, raw := range destinations {
+				if destination, ok := raw.(map[string]interface{}); ok && destination["clusterId"] == clusterName {
+					clusterScope, _, _ := unstructured.NestedSlice(binding.Object, "spec", "workload", "clusterScope")
+					namespaceScope, _, _ := unstructured.NestedSlice(binding.Object, "spec", "workload", "namespaceScope")
+					report.Workloads = append(report.Workloads, fmt.Sprintf("binding/%s (%d objects)", binding.GetName(), len(clusterScope)+len(namespaceScope)))
+					break
+				}
+			}
+		}
+	}
+
+	if hub != nil {
+		for _, decision := range list(hub, placementDecisionGVR, "") {
+			decisions, _, _ := unstructured.NestedSlice(decision.Object, "status", "decisions")
+			for _, raw := range decisions {
+				if item, ok := raw.(map[string]interface{}); ok && item["clusterName"] == clusterName {
+					placement := decision.GetLabels()[placementLabel]
+					if placement == "" {
+						placement = decision.GetName()
+					}
+					report.Placements = append(report.Placements, decision.GetNamespace()+"/"+placement)
+					break
+				}
+			}
+		}
+		for _, work := range list(hub, manifestWorkGVR, clusterName) {
+			if _, addOn := work.GetLabels()[addOnLabel]; addOn {
+				continue
+			}
+			report.Workloads = append(report.Workloads, "manifestwork/"+work.GetName())
+		}
+	}
+
+	report.Safe = len(report.BindingPolicies) == 0 && len(report.Placements) == 0 && len(report.Workloads) == 0
+	return report
+}
+
+// workloadClients connects to the WDS and to the hub of a cluster. It is a
+// variable so tests can hand out fake clients.
+var workloadClients = func(cp *ClusterPlugin, clusterName string) (wds, hub dynamic.Interface, warnings []string) {
+	_, wdsConfig, err := GetClientSetWithConfigContext(cp.wdsContext)
+	if err == nil {
+		wds, err = newDynamicClient(wdsConfig)
+	}
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("failed to connect to WDS %s: %v", cp.wdsContext, err))
+	}
+	target, err := cp.clusterHub(clusterName)
+	var hubConfig *rest.Config
+	if err == nil {
+		_, hubConfig, err = target.clientset()
+	}
+	if err == nil {
+		hub, err = newDynamicClient(hubConfig)
+	}
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("failed to connect to hub: %v", err))
+	}
+	return wds, hub, warnings
+}
+
+// newDynamicClient returns a nil interface rather than a nil client on error
+func newDynamicClient(config *rest.Config) (dynamic.Interface, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// checkDetachSafety inspects what still targets a cluster about to be
+// detached. Under the refuse policy a cluster that isn't safe to detach is
+// refused with a ClusterInUseError, unless the detachment is forced.
+func (cp *ClusterPlugin) checkDetachSafety(ctx context.Context, clusterName string, force bool) (*DetachSafetyReport, error) {
+	if cp.mode == modeMock || cp.detachSafety == detachSafetyOff {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	cp.mutex.RLock()
+	record, exists, err := cp.store.Get(clusterName)
+	cp.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errClusterNotFound
+	}
+	wds, hub, warnings := workloadClients(cp, clusterName)
+	report := inspectWorkloads(ctx, wds, hub, clusterName, onboardingLabels(clusterName, record.Labels))
+	report.Warnings = append(warnings, report.Warnings...)
+
+	if !report.Safe && cp.detachSafety == detachSafetyRefuse && !force {
+		return &report, &ClusterInUseError{Report: report}
+	}
+	return &report, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func fakeWDS(objects ...runtime.Object) dynamic.Interface {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		bindingPolicyGVR: "BindingPolicyList",
+		bindingGVR:       "BindingList",
+	}, objects...)
+}
+
+func fakeWorkloadHub(objects ...runtime.Object) dynamic.Interface {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		placementDecisionGVR: "PlacementDecisionList",
+		manifestWorkGVR:      "ManifestWorkList",
+	}, objects...)
+}
+
+func unstructuredObject(apiVersion, kind, namespace, name string, labels map[string]interface{}, fields map[string]interface{}) *unstructured.Unstructured {
+	metadata := map[string]interface{}{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	if labels != nil {
+		metadata["labels"] = labels
+	}
+	object := map[string]interface{}{"apiVersion": apiVersion, "kind": kind, "metadata": metadata}
+	for key, value := range fields {
+		object[key] = value
+	}
+	return &unstructured.Unstructured{Object: object}
+}
+
+func TestInspectWorkloads(t *testing.T) {
+	selecting := func(selector map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"spec": map[string]interface{}{"clusterSelectors": []interface{}{selector}}}
+	}
+	wds := fakeWDS(
+		unstructuredObject("control.kubestellar.io/v1alpha1", "BindingPolicy", "", "nginx", nil,
+			selecting(map[string]interface{}{"matchLabels": map[string]interface{}{"location-group": "edge"}})),
+		unstructuredObject("control.kubestellar.io/v1alpha1", "BindingPolicy", "", "prod-only", nil,
+			selecting(map[string]interface{}{"matchExpressions": []interface{}{map[string]interface{}{"key": "env", "operator": "In", "values": []interface{}{"prod"}}}})),
+		unstructuredObject("control.kubestellar.io/v1alpha1", "BindingPolicy", "", "empty", nil, selecting(map[string]interface{}{})),
+		unstructuredObject("control.kubestellar.io/v1alpha1", "Binding", "", "nginx", nil, map[string]interface{}{"spec": map[string]interface{}{
+			"destinations": []interface{}{map[string]interface{}{"clusterId": "edge-2"}, map[string]interface{}{"clusterId": "edge-1"}},
+			"workload": map[string]interface{}{
+				"clusterScope":   []interface{}{map[string]interface{}{"resource": "namespaces", "name": "nginx"}},
+				"namespaceScope": []interface{}{map[string]interface{}{"resource": "deployments", "namespace": "nginx", "name": "nginx"}},
+			},
+		}}),
+	)
+	hub := fakeWorkloadHub(
+		unstructuredObject("cluster.open-cluster-management.io/v1beta1", "PlacementDecision", "apps", "web-decision-1",
+			map[string]interface{}{placementLabel: "web"},
+			map[string]interface{}{"status": map[string]interface{}{"decisions": []interface{}{map[string]interface{}{"clusterName": "edge-1"}}}}),
+		unstructuredObject("cluster.open-cluster-management.io/v1beta1", "PlacementDecision", "apps", "db-decision-1", nil,
+			map[string]interface{}{"status": map[string]interface{}{"decisions": []interface{}{map[string]interface{}{"clusterName": "edge-2"}}}}),
+		unstructuredObject("work.open-cluster-management.io/v1", "ManifestWork", "edge-1", "nginx-wds1", nil, nil),
+		unstructuredObject("work.open-cluster-management.io/v1", "ManifestWork", "edge-1", "addon-status", map[string]interface{}{addOnLabel: "status"}, nil),
+		unstructuredObject("work.open-cluster-management.io/v1", "ManifestWork", "edge-2", "other", nil, nil),
+	)
+
+	report := inspectWorkloads(context.Background(), wds, hub, "edge-1", map[string]string{"location-group": "edge", "env": "dev"})
+	want := DetachSafetyReport{
+		ClusterName:     "edge-1",
+		BindingPolicies: []string{"nginx"},
+		Placements:      []string{"apps/web"},
+		Workloads:       []string{"binding/nginx (2 objects)", "manifestwork/nginx-wds1"},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	if report := inspectWorkloads(context.Background(), fakeWDS(), nil, "edge-3", nil); !report.Safe {
+		t.Errorf("idle cluster report = %+v", report)
+	}
+}
+
+func TestDetachRefusedWhileServingWorkloads(t *testing.T) {
+	original := workloadClients
+	t.Cleanup(func() { workloadClients = original })
+	workloadClients = func(*ClusterPlugin, string) (dynamic.Interface, dynamic.Interface, []string) {
+		return nil, fakeWorkloadHub(unstructuredObject("work.open-cluster-management.io/v1", "ManifestWork", "edge-1", "nginx-wds1", nil, nil)), []string{"failed to connect to WDS wds1"}
+	}
+	gin.SetMode(gin.TestMode)
+
+	for _, policy := range []string{detachSafetyRefuse, detachSafetyWarn} {
+		t.Run(policy, func(t *testing.T) {
+			plugin := newTestPluginWithConfig(t, map[string]interface{}{"detachSafety": policy})
+			plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+			router := gin.New()
+			router.POST("/detach", plugin.DetachClusterHandler)
+			detach := func(body string) *httptest.ResponseRecorder {
+				recorder := httptest.NewRecorder()
+				request := httptest.NewRequest(http.MethodPost, "/detach", strings.NewReader(body))
+				request.Header.Set("Content-Type", "application/json")
+				router.ServeHTTP(recorder, request)
+				return recorder
+			}
+
+			recorder := detach(`{"clusterName": "edge-1", "dryRun": true}`)
+			var plan DryRunResult
+			json.Unmarshal(recorder.Body.Bytes(), &plan)
+			for _, check := range plan.Checks {
+				if check.Name == "workloads" && check.Passed != (policy == detachSafetyWarn) {
+					t.Errorf("dry run workloads check = %+v", check)
+				}
+			}
+
+			recorder = detach(`{"clusterName": "edge-1"}`)
+			if policy == detachSafetyWarn {
+				if recorder.Code != http.StatusOK {
+					t.Fatalf("detach under warn = %d %s", recorder.Code, recorder.Body.String())
+				}
+				return
+			}
+			if recorder.Code != http.StatusConflict {
+				t.Fatalf("detach under refuse = %d %s", recorder.Code, recorder.Body.String())
+			}
+			var problem struct {
+				Code   ErrorCode          `json:"code"`
+				Safety DetachSafetyReport `json:"safety"`
+			}
+			json.Unmarshal(recorder.Body.Bytes(), &problem)
+			if problem.Code != CodeClusterInUse || len(problem.Safety.Workloads) != 1 || len(problem.Safety.Warnings) != 1 {
+				t.Errorf("problem = %s", recorder.Body.String())
+			}
+			if cluster, _, _ := plugin.store.Get("edge-1"); cluster.Status != "Ready" {
+				t.Errorf("refused cluster status = %s", cluster.Status)
+			}
+
+			if recorder := detach(`{"clusterName": "edge-1", "force": true}`); recorder.Code != http.StatusOK {
+				t.Errorf("forced detach = %d %s", recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DiscoveryAccount is a set of cloud credentials the plugin lists the
+// clusters of, read from the "discoveryAccounts" list of the Initialize config
+type DiscoveryAccount struct {
+	// Name identifies the account in discovered clusters and provider specs
+	Name string `json:"name"`
+	// Provider is eks, gke or aks
+	Provider string `json:"provider"`
+	// Regions are the AWS regions EKS clusters are listed in
+	Regions []string `json:"regions,omitempty"`
+	// Project is the Google Cloud project GKE clusters are listed in,
+	// defaulting to the one gcloud is configured with
+	Project string `json:"project,omitempty"`
+	// Env holds the credentials the provider CLI runs with, such as
+	// AWS_PROFILE, CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE or AZURE_CONFIG_DIR
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// Validate checks that the account names a provider that can be listed
+func (a DiscoveryAccount) Validate() error {
+	if a.Name == "" || strings.ContainsAny(a.Name, ":/") {
+		return fmt.Errorf("account name is required and must not contain ':' or '/'")
+	}
+	if _, ok := cloudListers[a.Provider]; !ok {
+		return fmt.Errorf("account %s: provider %q can't be discovered, must be one of %s", a.Name, a.Provider, strings.Join(sortedNames(cloudListers), ", "))
+	}
+	if a.Provider == "eks" && len(a.Regions) == 0 {
+		return fmt.Errorf("account %s: eks requires regions", a.Name)
+	}
+	for _, region := range a.Regions {
+		if region == "" || strings.HasPrefix(region, "-") {
+			return fmt.Errorf("account %s: invalid region %q", a.Name, region)
+		}
+	}
+	if strings.HasPrefix(a.Project, "-") {
+		return fmt.Errorf("account %s: invalid project %q", a.Name, a.Project)
+	}
+	for key := range a.Env {
+		if key == "" || strings.Contains(key, "=") {
+			return fmt.Errorf("account %s: invalid env variable %q", a.Name, key)
+		}
+	}
+	return nil
+}
+
+// environ returns the credentials of the account as KEY=value pairs
+func (a DiscoveryAccount) environ() []string {
+	env := make([]string, 0, len(a.Env))
+	for key, value := range a.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// discoveryAccountsFromConfig reads the "discoveryAccounts" list of the
+// Initialize config
+func discoveryAccountsFromConfig(config map[string]interface{}) ([]DiscoveryAccount, error) {
+	raw, ok := config["discoveryAccounts"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid discoveryAccounts config: %w", err)
+	}
+	var accounts []DiscoveryAccount
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("invalid discoveryAccounts config: %w", err)
+	}
+	names := map[string]bool{}
+	for _, account := range accounts {
+		if err := account.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid discoveryAccounts config: %w", err)
+		}
+		if names[account.Name] {
+			return nil, fmt.Errorf("invalid discoveryAccounts config: duplicate account %s", account.Name)
+		}
+		names[account.Name] = true
+	}
+	return accounts, nil
+}
+
+// providerEnvKey carries the credentials of a discovery account to the
+// provider CLIs run on behalf of a request
+type providerEnvKey struct{}
+
+// withProviderEnv returns a context the provider CLIs run with env in
+func withProviderEnv(ctx context.Context, env []string) context.Context {
+	return context.WithValue(ctx, providerEnvKey{}, env)
+}
+
+// providerEnv returns the credentials set by withProviderEnv
+func providerEnv(ctx context.Context) []string {
+	env, _ := ctx.Value(providerEnvKey{}).([]string)
+	return env
+}
+
+// CloudCluster is a cluster found by listing the clusters of an account
+type CloudCluster struct {
+	// ID identifies the cluster at its provider, such as eks:<region>:<name>
+	ID      string `json:"id"`
+	Account string `json:"account"`
+	// Provider onboards the cluster with the credentials of the account
+	Provider ProviderSpec `json:"provider"`
+	Endpoint string       `json:"endpoint,omitempty"`
+	Status   string       `json:"status,omitempty"`
+	Version  string       `json:"version,omitempty"`
+	// ClusterName is the name the cluster is onboarded under by default
+	ClusterName  string `json:"clusterName"`
+	Onboarded    bool   `json:"onboarded"`
+	DiscoveredAt string `json:"discoveredAt"`
+}
+
+// AccountScan is the outcome of the last listing of an account
+type AccountScan struct {
+	Account   string `json:"account"`
+	Provider  string `json:"provider"`
+	ScannedAt string `json:"scannedAt,omitempty"`
+	Clusters  int    `json:"clusters"`
+	Error     string `json:"error,omitempty"`
+}
+
+// cloudListers list the clusters an account can see
+var cloudListers = map[string]func(ctx context.Context, account DiscoveryAccount) ([]CloudCluster, error){
+	"eks": listEKSClusters,
+	"gke": listGKEClusters,
+	"aks": listAKSClusters,
+}
+
+func listEKSClusters(ctx context.Context, account DiscoveryAccount) ([]CloudCluster, error) {
+	var clusters []CloudCluster
+	for _, region := range account.Regions {
+		output, err := runProviderCommand(ctx, nil, "aws", "eks", "list-clusters", "--region", region, "--output", "json")
+		if err != nil {
+			return nil, err
+		}
+		var list struct {
+			Clusters []string `json:"clusters"`
+		}
+		if err := json.Unmarshal(output, &list); err != nil {
+			return nil, fmt.Errorf("invalid aws eks list-clusters output: %w", err)
+		}
+		for _, name := range list.Clusters {
+			cluster := CloudCluster{
+				ID:       strings.Join([]string{"eks", region, name}, ":"),
+				Provider: ProviderSpec{Name: "eks", Cluster: name, Region: region},
+			}
+			output, err := runProviderCommand(ctx, nil, "aws", "eks", "describe-cluster", "--name", name, "--region", region, "--output", "json")
+			if err != nil {
+				return nil, err
+			}
+			var described struct {
+				Cluster struct {
+					Endpoint string `json:"endpoint"`
+					Status   string `json:"status"`
+					Version  string `json:"version"`
+				} `json:"cluster"`
+			}
+			if err := json.Unmarshal(output, &described); err != nil {
+				return nil, fmt.Errorf("invalid aws eks describe-cluster output: %w", err)
+			}
+			cluster.Endpoint, cluster.Status, cluster.Version = described.Cluster.Endpoint, described.Cluster.Status, described.Cluster.Version
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters, nil
+}
+
+func listGKEClusters(ctx context.Context, account DiscoveryAccount) ([]CloudCluster, error) {
+	args := []string{"container", "clusters", "list", "--format", "json"}
+	if account.Project != "" {
+		args = append(args, "--project", account.Project)
+	}
+	output, err := runProviderCommand(ctx, nil, "gcloud", args...)
+	if err != nil {
+		return nil, err
+	}
+	var list []struct {
+		Name                 string `json:"name"`
+		Location             string `json:"location"`
+		Endpoint             string `json:"endpoint"`
+		Status               string `json:"status"`
+		CurrentMasterVersion string `json:"currentMasterVersion"`
+		SelfLink             string `json:"selfLink"`
+	}
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, fmt.Errorf("invalid gcloud container clusters list output: %w", err)
+	}
+	clusters := make([]CloudCluster, 0, len(list))
+	for _, item := range list {
+		// .../projects/<project>/locations/<location>/clusters/<name>
+		project := account.Project
+		if _, rest, found := strings.Cut(item.SelfLink, "/projects/"); found {
+			project, _, _ = strings.Cut(rest, "/")
+		}
+		cluster := CloudCluster{
+			ID:       strings.Join([]string{"gke", project, item.Location, item.Name}, ":"),
+			Provider: ProviderSpec{Name: "gke", Cluster: item.Name, Region: item.Location, Project: project},
+			Status:   item.Status,
+			Version:  item.CurrentMasterVersion,
+		}
+		if item.Endpoint != "" {
+			cluster.Endpoint = "https://" + item.Endpoint
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, nil
+}
+
+func listAKSClusters(ctx context.Context, account DiscoveryAccount) ([]CloudCluster, error) {
+	output, err := runProviderCommand(ctx, nil, "az", "aks", "list", "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+	var list []struct {
+		Name              string `json:"name"`
+		ResourceGroup     string `json:"resourceGroup"`
+		FQDN              string `json:"fqdn"`
+		ProvisioningState string `json:"provisioningState"`
+		KubernetesVersion string `json:"kubernetesVersion"`
+	}
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, fmt.Errorf("invalid az aks list output: %w", err)
+	}
+	clusters := make([]CloudCluster, 0, len(list))
+	for _, item := range list {
+		cluster := CloudCluster{
+			ID:       strings.Join([]string{"aks", item.ResourceGroup, item.Name}, ":"),
+			Provider: ProviderSpec{Name: "aks", Cluster: item.Name, ResourceGroup: item.ResourceGroup},
+			Status:   item.ProvisioningState,
+			Version:  item.KubernetesVersion,
+		}
+		if item.FQDN != "" {
+			cluster.Endpoint = "https://" + item.FQDN + ":443"
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, nil
+}
+
+// discoveredClusterName turns a cloud cluster name into a valid cluster name
+func discoveredClusterName(name string) string {
+	return strings.Trim(strings.ToLower(strings.ReplaceAll(name, "_", "-")), "-.")
+}
+
+// cloudDiscovery periodically lists the clusters of the configured accounts
+type cloudDiscovery struct {
+	accounts []DiscoveryAccount
+	check    *periodicCheck
+
+	mutex    sync.RWMutex
+	clusters map[string]CloudCluster
+	scans    map[string]AccountScan
+	// scanning serializes scans, so a refresh waits for a running one
+	scanning sync.Mutex
+}
+
+func newCloudDiscovery(accounts []DiscoveryAccount, interval time.Duration) *cloudDiscovery {
+	cd := &cloudDiscovery{
+		accounts: accounts,
+		clusters: make(map[string]CloudCluster),
+		scans:    make(map[string]AccountScan),
+	}
+	for _, account := range accounts {
+		cd.scans[account.Name] = AccountScan{Account: account.Name, Provider: account.Provider}
+	}
+	cd.check = newPeriodicCheck(interval, cd.scan)
+	return cd
+}
+
+// account returns the configured account with name
+func (cd *cloudDiscovery) account(name string) (DiscoveryAccount, bool) {
+	for _, account := range cd.accounts {
+		if account.Name == name {
+			return account, true
+		}
+	}
+	return DiscoveryAccount{}, false
+}
+
+// scan lists the clusters of every account. The clusters of an account that
+// can't be listed are kept from its previous scan.
+func (cd *cloudDiscovery) scan(ctx context.Context) {
+	cd.scanning.Lock()
+	defer cd.scanning.Unlock()
+
+	for _, account := range cd.accounts {
+		list := cloudListers[account.Provider]
+		clusters, err := list(withProviderEnv(ctx, account.environ()), account)
+		if ctx.Err() != nil {
+			return
+		}
+		now := time.Now().Format(time.RFC3339)
+		scan := AccountScan{Account: account.Name, Provider: account.Provider, ScannedAt: now}
+
+		cd.mutex.Lock()
+		if err != nil {
+			logger().Warn("Failed to discover clusters", "account", account.Name, "provider", account.Provider, "error", err)
+			scan.Error = err.Error()
+			scan.Clusters = cd.scans[account.Name].Clusters
+			cd.scans[account.Name] = scan
+			cd.mutex.Unlock()
+			continue
+		}
+		previous := map[string]CloudCluster{}
+		for id, cluster := range cd.clusters {
+			if cluster.Account == account.Name {
+				previous[id] = cluster
+				delete(cd.clusters, id)
+			}
+		}
+		for _, cluster := range clusters {
+			cluster.Account = account.Name
+			cluster.Provider.Account = account.Name
+			cluster.ClusterName = discoveredClusterName(cluster.Provider.Cluster)
+			cluster.DiscoveredAt = now
+			if known, exists := previous[cluster.ID]; exists {
+				cluster.DiscoveredAt = known.DiscoveredAt
+			}
+			cd.clusters[cluster.ID] = cluster
+		}
+		scan.Clusters = len(clusters)
+		cd.scans[account.Name] = scan
+		cd.mutex.Unlock()
+	}
+}
+
+// list returns the discovered clusters and account scans in order
+func (cd *cloudDiscovery) list() ([]CloudCluster, []AccountScan) {
+	cd.mutex.RLock()
+	defer cd.mutex.RUnlock()
+
+	clusters := make([]CloudCluster, 0, len(cd.clusters))
+	for _, cluster := range cd.clusters {
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].ID < clusters[j].ID })
+	scans := make([]AccountScan, 0, len(cd.accounts))
+	for _, account := range cd.accounts {
+		scans = append(scans, cd.scans[account.Name])
+	}
+	return clusters, scans
+}
+
+// get returns the discovered cluster with id
+func (cd *cloudDiscovery) get(id string) (CloudCluster, bool) {
+	cd.mutex.RLock()
+	defer cd.mutex.RUnlock()
+	cluster, exists := cd.clusters[id]
+	return cluster, exists
+}
+
+// providerContext returns ctx carrying the credentials of the discovery
+// account spec names, if any
+func (cp *ClusterPlugin) providerContext(ctx context.Context, spec ProviderSpec) (context.Context, error) {
+	if spec.Account == "" {
+		return ctx, nil
+	}
+	if cp.discovery != nil {
+		if account, exists := cp.discovery.account(spec.Account); exists {
+			return withProviderEnv(ctx, account.environ()), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown discovery account %q", spec.Account)
+}
+
+// ListDiscoveredHandler returns the clusters found in the configured cloud
+// accounts, listing them again first with refresh=true
+func (cp *ClusterPlugin) ListDiscoveredHandler(c *gin.Context) {
+	clusters, scans := []CloudCluster{}, []AccountScan{}
+	if cp.discovery != nil {
+		if c.Query("refresh") == "true" {
+			cp.discovery.scan(c.Request.Context())
+			if cp.abortOnContext(c) {
+				return
+			}
+		}
+		clusters, scans = cp.discovery.list()
+	}
+
+	provider, account := c.Query("provider"), c.Query("account")
+	filtered := clusters[:0]
+	for _, cluster := range clusters {
+		if (provider != "" && cluster.Provider.Name != provider) || (account != "" && cluster.Account != account) {
+			continue
+		}
+		_, cluster.Onboarded, _ = cp.store.Get(cluster.ClusterName)
+		filtered = append(filtered, cluster)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clusters":  filtered,
+		"accounts":  scans,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// DiscoveredOnboardRequest overrides how a discovered cluster is onboarded
+type DiscoveredOnboardRequest struct {
+	// ClusterName defaults to the name of the cluster at its provider
+	ClusterName string            `json:"clusterName,omitempty"`
+	Hub         string            `json:"hub,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// OnboardDiscoveredHandler onboards a discovered cluster, fetching its
+// kubeconfig with the credentials of the account it was found in
+func (cp *ClusterPlugin) OnboardDiscoveredHandler(c *gin.Context) {
+	id := c.Param("id")
+	var cluster CloudCluster
+	exists := false
+	if cp.discovery != nil {
+		cluster, exists = cp.discovery.get(id)
+	}
+	if !exists {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Discovered cluster '%s' not found", id))
+		return
+	}
+
+	var req DiscoveredOnboardRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Invalid request payload: %v", err))
+			return
+		}
+	}
+	if req.ClusterName == "" {
+		req.ClusterName = cluster.ClusterName
+	}
+	provider := cluster.Provider
+	spec := OnboardRequest{ClusterName: req.ClusterName, Hub: req.Hub, Provider: &provider, Labels: req.Labels, Annotations: req.Annotations}
+	if err := validateClusterName(spec.ClusterName); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if err := spec.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	hub, err := cp.hubs.Get(spec.Hub)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeHubNotFound, err.Error())
+		return
+	}
+	if err := cp.checkOnboardingPolicy(spec.ClusterName, provider.Name, spec.Labels); err != nil {
+		if !respondPolicyViolation(c, err) {
+			respondStoreError(c, err)
+		}
+		return
+	}
+	if respondAdmission(c, cp.admit(c.Request.Context(), AdmissionInput{
+		Operation:   "onboard",
+		ClusterName: spec.ClusterName,
+		Hub:         hub.Name,
+		Provider:    provider.Name,
+		Labels:      spec.Labels,
+		Annotations: spec.Annotations,
+	})) {
+		return
+	}
+
+	kubeconfigData, err := cp.resolveKubeconfig(c.Request.Context(), spec)
+	if cp.abortOnContext(c) {
+		return
+	}
+	if err != nil {
+		respondProblem(c, http.StatusBadGateway, CodeUnavailable, err.Error())
+		return
+	}
+
+	jobID, existing, err := cp.beginOnboarding(c.Request.Context(), spec.ClusterName, hub.Name, provider.Name, c.GetHeader(idempotencyKeyHeader), spec.Labels, spec.Annotations)
+	if respondPolicyViolation(c, err) {
+		return
+	}
+	if errors.Is(err, errIdempotencyKeyReused) {
+		respondProblem(c, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, err.Error())
+		return
+	}
+	if errors.Is(err, errTenantConflict) {
+		respondProblem(c, http.StatusConflict, CodeClusterExists, err.Error())
+		return
+	}
+	if errors.Is(err, errJobQueueFull) {
+		cp.respondQueueFull(c)
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if existing != nil {
+		respondProblem(c, http.StatusConflict, CodeClusterExists, fmt.Sprintf("Cluster '%s' is already onboarded (status: %s)", spec.ClusterName, existing.Status),
+			"jobId", existing.JobID, "cluster", *existing)
+		return
+	}
+
+	cp.runJob(jobID, cp.onboardingJob(jobID, spec.ClusterName, kubeconfigData, nil))
+
+	requestLogger(c).Info("Discovered cluster onboarding started", "id", id, "cluster", spec.ClusterName, "job", jobID)
+	c.JSON(http.StatusOK, OnboardResponse{
+		Message:       message(c, MsgDiscoveredOnboardStarted, "cluster", spec.ClusterName),
+		MessageCode:   MsgDiscoveredOnboardStarted,
+		Status:        "Pending",
+		Plugin:        "kubestellar-cluster-plugin",
+		ClusterName:   spec.ClusterName,
+		JobID:         jobID,
+		QueuePosition: cp.queuePosition(jobID),
+		Timestamp:     time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDiscoveryAccountValidate(t *testing.T) {
+	if err := (DiscoveryAccount{Name: "prod", Provider: "eks", Regions: []string{"eu-west-1"}, Env: map[string]string{"AWS_PROFILE": "prod"}}).Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	for name, account := range map[string]DiscoveryAccount{
+		"no name":        {Provider: "gke"},
+		"colon name":     {Name: "a:b", Provider: "gke"},
+		"local provider": {Name: "dev", Provider: "kind"},
+		"eks regions":    {Name: "prod", Provider: "eks"},
+		"option region":  {Name: "prod", Provider: "eks", Regions: []string{"--profile=x"}},
+		"env key":        {Name: "prod", Provider: "aks", Env: map[string]string{"A=B": "c"}},
+	} {
+		if err := account.Validate(); err == nil {
+			t.Errorf("%s: account accepted", name)
+		}
+	}
+}
+
+// stubCloudCLIs answers the listing and kubeconfig commands of the provider
+// CLIs, recording the credentials each ran with
+func stubCloudCLIs(t *testing.T) *sync.Map {
+	t.Helper()
+	envs := &sync.Map{}
+	original := runProviderCommand
+	t.Cleanup(func() { runProviderCommand = original })
+	runProviderCommand = func(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+		cmd := strings.Join(append([]string{name}, args...), " ")
+		envs.Store(cmd, strings.Join(providerEnv(ctx), ","))
+		switch {
+		case strings.HasPrefix(cmd, "aws eks list-clusters --region eu-west-1"):
+			return []byte(`{"clusters": ["Prod_1"]}`), nil
+		case strings.HasPrefix(cmd, "aws eks list-clusters"):
+			return []byte(`{"clusters": []}`), nil
+		case strings.HasPrefix(cmd, "aws eks describe-cluster --name Prod_1 --region eu-west-1 --output json"):
+			return []byte(`{"cluster": {"endpoint": "https://prod.eks.amazonaws.com", "status": "ACTIVE", "version": "1.29"}}`), nil
+		case strings.HasPrefix(cmd, "aws eks describe-cluster"):
+			return []byte("ACTIVE\n"), nil
+		case strings.HasPrefix(cmd, "aws eks update-kubeconfig"):
+			for i, arg := range args {
+				if arg == "--kubeconfig" {
+					return nil, os.WriteFile(args[i+1], []byte(testKindKubeconfig), 0600)
+				}
+			}
+		case strings.HasPrefix(cmd, "gcloud container clusters list"):
+			return []byte(`[{"name": "edge", "location": "europe-west1", "endpoint": "34.1.2.3", "status": "RUNNING", "currentMasterVersion": "1.30.1",
+				"selfLink": "https://container.googleapis.com/v1/projects/fleet/locations/europe-west1/clusters/edge"}]`), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	return envs
+}
+
+func TestCloudDiscovery(t *testing.T) {
+	envs := stubCloudCLIs(t)
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode":                modeMock,
+		"mockFleetSize":       0,
+		"mockStepDelayMillis": 1,
+		"discoveryAccounts": []interface{}{
+			map[string]interface{}{"name": "aws-prod", "provider": "eks", "regions": []interface{}{"eu-west-1", "us-east-1"}, "env": map[string]interface{}{"AWS_PROFILE": "prod"}},
+			map[string]interface{}{"name": "gcp", "provider": "gke"},
+			map[string]interface{}{"name": "azure", "provider": "aks"},
+		},
+	})
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, "/api/plugins/kubestellar-cluster-plugin/v1"+path, strings.NewReader(body))
+		if body != "" {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	var listed struct {
+		Clusters []CloudCluster `json:"clusters"`
+		Accounts []AccountScan  `json:"accounts"`
+	}
+	recorder := serve(http.MethodGet, "/discovered?refresh=true", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /discovered = %d %s", recorder.Code, recorder.Body.String())
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &listed)
+	if len(listed.Clusters) != 2 || listed.Clusters[0].ID != "eks:eu-west-1:Prod_1" || listed.Clusters[1].ID != "gke:fleet:europe-west1:edge" {
+		t.Fatalf("clusters = %+v", listed.Clusters)
+	}
+	if eks := listed.Clusters[0]; eks.ClusterName != "prod-1" || eks.Status != "ACTIVE" || eks.Provider.Account != "aws-prod" || eks.Onboarded {
+		t.Errorf("eks cluster = %+v", eks)
+	}
+	if gke := listed.Clusters[1]; gke.Endpoint != "https://34.1.2.3" || gke.Provider.Project != "fleet" {
+		t.Errorf("gke cluster = %+v", gke)
+	}
+	if len(listed.Accounts) != 3 || listed.Accounts[0].Clusters != 1 || listed.Accounts[2].Error == "" {
+		t.Errorf("accounts = %+v", listed.Accounts)
+	}
+	if strings.Contains(recorder.Body.String(), "AWS_PROFILE") {
+		t.Error("account credentials are listed")
+	}
+	if env, _ := envs.Load("aws eks list-clusters --region eu-west-1 --output json"); env != "AWS_PROFILE=prod" {
+		t.Errorf("listing env = %v", env)
+	}
+
+	json.Unmarshal(serve(http.MethodGet, "/discovered?provider=gke", "").Body.Bytes(), &listed)
+	if len(listed.Clusters) != 1 || listed.Clusters[0].Account != "gcp" {
+		t.Errorf("gke clusters = %+v", listed.Clusters)
+	}
+
+	if recorder := serve(http.MethodPost, "/discovered/eks:nowhere:x/onboard", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown id = %d", recorder.Code)
+	}
+	recorder = serve(http.MethodPost, "/discovered/eks:eu-west-1:Prod_1/onboard", `{"labels": {"env": "prod"}}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("POST /discovered/:id/onboard = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var response OnboardResponse
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if response.ClusterName != "prod-1" {
+		t.Errorf("response = %+v", response)
+	}
+	envs.Range(func(cmd, env interface{}) bool {
+		if strings.HasPrefix(cmd.(string), "aws eks update-kubeconfig") && env != "AWS_PROFILE=prod" {
+			t.Errorf("%s ran with %v", cmd, env)
+		}
+		return true
+	})
+	waitForJob(t, plugin.jobs, response.JobID, func(job Job) bool { return job.Finished() })
+
+	json.Unmarshal(serve(http.MethodGet, "/discovered?account=aws-prod", "").Body.Bytes(), &listed)
+	if len(listed.Clusters) != 1 || !listed.Clusters[0].Onboarded {
+		t.Errorf("onboarded cluster = %+v", listed.Clusters)
+	}
+	if recorder := serve(http.MethodPost, "/discovered/eks:eu-west-1:Prod_1/onboard", ""); recorder.Code != http.StatusConflict {
+		t.Errorf("second onboarding = %d", recorder.Code)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// A cordoned cluster carries a NoSelect taint, which keeps OCM Placements
+// from deciding for it, and a label BindingPolicies can exclude with a
+// DoesNotExist selector
+const (
+	cordonTaintKey = "kubestellar.io/draining"
+	cordonLabel    = "kubestellar.io/cordoned"
+)
+
+// drainPollInterval is how often a drain checks whether the workloads left.
+// It is a variable so tests can drain quickly.
+var drainPollInterval = 10 * time.Second
+
+// DrainProgress is the state of the drain phase of a two-phase detach
+type DrainProgress struct {
+	// State is draining, drained or timedOut
+	State     string `json:"state"`
+	StartedAt string `json:"startedAt"`
+	Deadline  string `json:"deadline"`
+	// Remaining lists what still targets the cluster, as of the last check
+	Remaining []string `json:"remaining,omitempty"`
+	Checks    int      `json:"checks"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// drainTimeout is how long the detachment of req drains the cluster, zero
+// when it doesn't
+func (cp *ClusterPlugin) drainTimeout(req DetachRequest) time.Duration {
+	switch {
+	case !req.Drain:
+		return 0
+	case req.DrainTimeoutSeconds > 0:
+		return time.Duration(req.DrainTimeoutSeconds) * time.Second
+	}
+	return cp.defaultDrainTimeout
+}
+
+// remaining lists everything a safety report found targeting the cluster
+func (r DetachSafetyReport) remaining() []string {
+	var remaining []string
+	for _, policy := range r.BindingPolicies {
+		remaining = append(remaining, "bindingpolicy/"+policy)
+	}
+	for _, placement := range r.Placements {
+		remaining = append(remaining, "placement/"+placement)
+	}
+	return append(remaining, r.Workloads...)
+}
+
+// setCordon taints and labels the ManagedCluster so no new placement selects
+// it, or removes both again
+func setCordon(ctx context.Context, hub dynamic.Interface, clusterName string, cordoned bool) error {
+	clusters := hub.Resource(managedClusterGVR)
+	cluster, err := clusters.Get(ctx, clusterName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get ManagedCluster: %w", err)
+	}
+
+	taints, _, _ := unstructured.NestedSlice(cluster.Object, "spec", "taints")
+	kept := make([]interface{}, 0, len(taints)+1)
+	for _, raw := range taints {
+		if taint, ok := raw.(map[string]interface{}); ok && taint["key"] == cordonTaintKey {
+			continue
+		}
+		kept = append(kept, raw)
+	}
+	labels := cluster.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if cordoned {
+		kept = append(kept, map[string]interface{}{
+			"key":       cordonTaintKey,
+			"effect":    "NoSelect",
+			"timeAdded": time.Now().UTC().Format(time.RFC3339),
+		})
+		labels[cordonLabel] = "true"
+	} else {
+		delete(labels, cordonLabel)
+	}
+	if err := unstructured.SetNestedSlice(cluster.Object, kept, "spec", "taints"); err != nil {
+		return err
+	}
+	cluster.SetLabels(labels)
+	if _, err := clusters.Update(ctx, cluster, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update ManagedCluster: %w", err)
+	}
+	return nil
+}
+
+// drainCluster is the first phase of a two-phase detach: it cordons the
+// cluster and waits up to timeout for its workloads to be rescheduled
+// elsewhere, reporting the progress on the job. A drain that times out
+// uncordons the cluster and fails the detachment, unless it is forced.
+func (cp *ClusterPlugin) drainCluster(ctx context.Context, jobID, clusterName string, timeout time.Duration, force bool) (err error) {
+	if err := cp.advance(ctx, clusterName, "Cordoning", "Excluding the cluster from placement decisions"); err != nil {
+		return err
+	}
+	wds, hub, warnings := workloadClients(cp, clusterName)
+	if hub == nil {
+		return fmt.Errorf("failed to cordon cluster: %v", warnings)
+	}
+	if err := setCordon(ctx, hub, clusterName, true); err != nil {
+		return fmt.Errorf("failed to cordon cluster: %w", err)
+	}
+	// Put the cluster back in service rather than leave it cordoned when the
+	// detachment stops here
+	defer func() {
+		if err != nil {
+			if uncordonErr := setCordon(context.WithoutCancel(ctx), hub, clusterName, false); uncordonErr != nil {
+				logger().Warn("Failed to uncordon cluster", "cluster", clusterName, "error", uncordonErr)
+			}
+		}
+	}()
+
+	cp.mutex.RLock()
+	record, _, err := cp.store.Get(clusterName)
+	cp.mutex.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to read cluster record: %w", err)
+	}
+	labels := onboardingLabels(clusterName, record.Labels)
+	labels[cordonLabel] = "true"
+
+	started := time.Now()
+	progress := DrainProgress{
+		State:     "draining",
+		StartedAt: started.Format(time.RFC3339),
+		Deadline:  started.Add(timeout).Format(time.RFC3339),
+	}
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	reported := -1
+	for {
+		report := inspectWorkloads(ctx, wds, hub, clusterName, labels)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		progress.Checks++
+		progress.Remaining = report.remaining()
+		progress.Warnings = append(append([]string(nil), warnings...), report.Warnings...)
+		if report.Safe {
+			progress.State = "drained"
+			cp.jobs.SetDrain(jobID, progress)
+			cp.logs.Append(clusterName, "info", fmt.Sprintf("Drained after %s", time.Since(started).Round(time.Second)))
+			return nil
+		}
+		if time.Since(started) >= timeout {
+			progress.State = "timedOut"
+			cp.jobs.SetDrain(jobID, progress)
+			if force {
+				logger().Warn("Drain timed out, detaching anyway with force flag", "cluster", clusterName, "targets", report.summary())
+				return nil
+			}
+			return fmt.Errorf("workloads were not rescheduled within %s, still targeted by %s", timeout, report.summary())
+		}
+		cp.jobs.SetDrain(jobID, progress)
+		if len(progress.Remaining) != reported {
+			reported = len(progress.Remaining)
+			if err := cp.advance(ctx, clusterName, "Draining", fmt.Sprintf("Waiting for %d workloads to be rescheduled: %s", reported, report.summary())); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
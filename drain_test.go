@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// drainingHub serves edge-1 with one ManifestWork delivered to it
+func drainingHub(t *testing.T) dynamic.Interface {
+	t.Helper()
+	hub := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		managedClusterGVR:    "ManagedClusterList",
+		placementDecisionGVR: "PlacementDecisionList",
+		manifestWorkGVR:      "ManifestWorkList",
+	},
+		unstructuredObject("cluster.open-cluster-management.io/v1", "ManagedCluster", "", "edge-1", map[string]interface{}{"env": "prod"},
+			map[string]interface{}{"spec": map[string]interface{}{"taints": []interface{}{map[string]interface{}{"key": "other", "effect": "NoSelect"}}}}),
+		unstructuredObject("work.open-cluster-management.io/v1", "ManifestWork", "edge-1", "nginx-wds1", nil, nil),
+	)
+	original, interval := workloadClients, drainPollInterval
+	t.Cleanup(func() { workloadClients, drainPollInterval = original, interval })
+	workloadClients = func(*ClusterPlugin, string) (dynamic.Interface, dynamic.Interface, []string) {
+		return nil, hub, nil
+	}
+	drainPollInterval = 10 * time.Millisecond
+	return hub
+}
+
+// runDrain drains edge-1 as a detach job and returns the finished job
+func runDrain(t *testing.T, plugin *ClusterPlugin, timeout time.Duration, force bool) Job {
+	t.Helper()
+	job := plugin.jobs.Create(context.Background(), "detach", "edge-1")
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", JobID: job.ID, Status: "Detaching"})
+	plugin.jobs.Run(job.ID, func(ctx context.Context) error {
+		return plugin.drainCluster(ctx, job.ID, "edge-1", timeout, force)
+	})
+	return waitForJob(t, plugin.jobs, job.ID, func(job Job) bool { return job.Finished() })
+}
+
+// cordonState returns the cordon label and taint keys of edge-1
+func cordonState(t *testing.T, hub dynamic.Interface) (string, []string) {
+	t.Helper()
+	cluster, err := hub.Resource(managedClusterGVR).Get(context.Background(), "edge-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	taints, _, _ := unstructured.NestedSlice(cluster.Object, "spec", "taints")
+	var keys []string
+	for _, taint := range taints {
+		keys = append(keys, taint.(map[string]interface{})["key"].(string))
+	}
+	return cluster.GetLabels()[cordonLabel], keys
+}
+
+func TestDrainCluster(t *testing.T) {
+	hub := drainingHub(t)
+	plugin := newTestPlugin(t)
+
+	// The workload moves elsewhere once the cluster is cordoned
+	go func() {
+		for {
+			cluster, err := hub.Resource(managedClusterGVR).Get(context.Background(), "edge-1", metav1.GetOptions{})
+			if err == nil && cluster.GetLabels()[cordonLabel] == "true" {
+				hub.Resource(manifestWorkGVR).Namespace("edge-1").Delete(context.Background(), "nginx-wds1", metav1.DeleteOptions{})
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	drained := runDrain(t, plugin, 5*time.Second, false)
+	if drained.State != JobSucceeded {
+		t.Fatalf("drain job = %+v", drained)
+	}
+
+	label, taints := cordonState(t, hub)
+	if label != "true" || strings.Join(taints, ",") != "other,"+cordonTaintKey {
+		t.Errorf("cordon label %q, taints %v", label, taints)
+	}
+	if drained.Drain == nil || drained.Drain.State != "drained" || len(drained.Drain.Remaining) != 0 {
+		t.Errorf("drain progress = %+v", drained.Drain)
+	}
+	var steps []string
+	for _, step := range drained.Steps {
+		steps = append(steps, step.Name)
+	}
+	if !strings.Contains(strings.Join(steps, ","), "Cordoning,Draining") {
+		t.Errorf("steps = %v", steps)
+	}
+}
+
+func TestDrainClusterTimesOut(t *testing.T) {
+	hub := drainingHub(t)
+	plugin := newTestPlugin(t)
+
+	timedOut := runDrain(t, plugin, 50*time.Millisecond, false)
+	if timedOut.State != JobFailed || !strings.Contains(timedOut.Message, "manifestwork/nginx-wds1") {
+		t.Fatalf("drain job = %+v, want a timeout naming the workload", timedOut)
+	}
+	// The cluster is back in service
+	if label, taints := cordonState(t, hub); label != "" || strings.Join(taints, ",") != "other" {
+		t.Errorf("cordon label %q, taints %v after a timeout", label, taints)
+	}
+	if timedOut.Drain == nil || timedOut.Drain.State != "timedOut" || timedOut.Drain.Checks < 2 || len(timedOut.Drain.Remaining) != 1 {
+		t.Errorf("drain progress = %+v", timedOut.Drain)
+	}
+
+	// Forced, the detachment goes on with the cluster still cordoned
+	if forced := runDrain(t, plugin, 50*time.Millisecond, true); forced.State != JobSucceeded {
+		t.Errorf("forced drain job = %+v", forced)
+	}
+	if label, _ := cordonState(t, hub); label != "true" {
+		t.Error("forced drain uncordoned the cluster")
+	}
+}
+
+func TestDetachRequestDrain(t *testing.T) {
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"drainTimeoutSeconds": 120})
+	for _, tt := range []struct {
+		req  DetachRequest
+		want time.Duration
+	}{
+		{DetachRequest{ClusterName: "edge-1"}, 0},
+		{DetachRequest{ClusterName: "edge-1", Drain: true}, 2 * time.Minute},
+		{DetachRequest{ClusterName: "edge-1", Drain: true, DrainTimeoutSeconds: 30}, 30 * time.Second},
+	} {
+		if err := tt.req.Validate(); err != nil {
+			t.Errorf("Validate(%+v) error = %v", tt.req, err)
+		}
+		if got := plugin.drainTimeout(tt.req); got != tt.want {
+			t.Errorf("drainTimeout(%+v) = %s, want %s", tt.req, got, tt.want)
+		}
+	}
+	if err := (DetachRequest{ClusterName: "edge-1", DrainTimeoutSeconds: 30}).Validate(); err == nil {
+		t.Error("drainTimeoutSeconds without drain accepted")
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+// DryRunCheck is the outcome of a single validation performed by a dry run
+type DryRunCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// DryRunResult describes what an onboard or detach request would do without
+// applying any change
+type DryRunResult struct {
+	Operation   string `json:"operation"`
+	ClusterName string `json:"clusterName"`
+	// Hub is the hub the operation targets
+	Hub       string        `json:"hub,omitempty"`
+	Valid     bool          `json:"valid"`
+	Checks    []DryRunCheck `json:"checks"`
+	Actions   []string      `json:"actions"`
+	Manifests []string      `json:"manifests,omitempty"`
+	Plugin    string        `json:"plugin"`
+	Timestamp string        `json:"timestamp"`
+}
+
+func (r *DryRunResult) check(name string, err error, success string) {
+	check := DryRunCheck{Name: name, Passed: err == nil, Message: success}
+	if err != nil {
+		check.Message = err.Error()
+		r.Valid = false
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// accessRule is a permission a dry run verifies with a SelfSubjectAccessReview
+type accessRule struct {
+	verb        string
+	group       string
+	resource    string
+	subresource string
+}
+
+// klusterletAccess lists what the spoke credentials need to install the klusterlet
+var klusterletAccess = []accessRule{
+	{verb: "create", resource: "namespaces"},
+	{verb: "create", group: "apiextensions.k8s.io", resource: "customresourcedefinitions"},
+	{verb: "create", group: "rbac.authorization.k8s.io", resource: "clusterroles"},
+	{verb: "create", group: "rbac.authorization.k8s.io", resource: "clusterrolebindings"},
+}
+
+// hubOnboardAccess lists what the hub credentials need to accept a new cluster
+var hubOnboardAccess = []accessRule{
+	{verb: "update", group: "certificates.k8s.io", resource: "certificatesigningrequests", subresource: "approval"},
+	{verb: "patch", group: "cluster.open-cluster-management.io", resource: "managedclusters"},
+}
+
+// hubDetachAccess lists what the hub credentials need to remove a cluster
+var hubDetachAccess = []accessRule{
+	{verb: "delete", group: "cluster.open-cluster-management.io", resource: "managedclusters"},
+	{verb: "patch", group: "cluster.open-cluster-management.io", resource: "managedclusters"},
+}
+
+// checkAccess returns an error naming every rule the caller isn't allowed
+func checkAccess(ctx context.Context, clientset *kubernetes.Clientset, rules []accessRule) error {
+	var denied []string
+	for _, rule := range rules {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:        rule.verb,
+					Group:       rule.group,
+					Resource:    rule.resource,
+					Subresource: rule.subresource,
+				},
+			},
+		}
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to review access: %w", err)
+		}
+		if !result.Status.Allowed {
+			resource := rule.resource
+			if rule.subresource != "" {
+				resource += "/" + rule.subresource
+			}
+			denied = append(denied, fmt.Sprintf("%s %s", rule.verb, resource))
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("missing permissions: %s", strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// planOnboarding validates an onboarding request and describes the steps it would run
+func (cp *ClusterPlugin) planOnboarding(ctx context.Context, target Hub, clusterName string, kubeconfigData []byte, values *ManifestValues) DryRunResult {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result := DryRunResult{
+		Operation:   "onboard",
+		ClusterName: clusterName,
+		Hub:         target.Name,
+		Valid:       true,
+		Plugin:      "kubestellar-cluster-plugin",
+		Timestamp:   time.Now().Format(time.RFC3339),
+	}
+
+	_, exists, err := cp.store.Get(clusterName)
+	if err == nil && exists {
+		err = fmt.Errorf("cluster '%s' is already known to the plugin", clusterName)
+	}
+	result.check("inventory", err, "Cluster is not yet onboarded")
+
+	cp.mutex.RLock()
+	preflight := cp.preflight
+	cp.mutex.RUnlock()
+	err = nil
+	if failures := preflight.Failures(); len(failures) > 0 {
+		names := make([]string, 0, len(failures))
+		for _, check := range failures {
+			names = append(names, check.Name)
+		}
+		err = fmt.Errorf("dependency preflight failed for: %s", strings.Join(names, ", "))
+	}
+	result.check("dependencies", err, "Required tools are installed")
+
+	cp.mutex.RLock()
+	merged := cp.manifestValues.Merge(values)
+	cp.mutex.RUnlock()
+	if merged.Proxy != nil {
+		var proxied []byte
+		proxied, err = withProxy(kubeconfigData, merged.Proxy)
+		result.check("proxy", err, "Applied the proxy settings to the kubeconfig")
+		if err == nil {
+			kubeconfigData = proxied
+		}
+	}
+
+	// Spoke cluster
+	spoke, err := spokeClientset(kubeconfigData)
+	if err == nil {
+		err = cp.validateClusterConnectivity(ctx, kubeconfigData)
+	}
+	result.check("spoke-connectivity", err, "Spoke cluster is reachable")
+	if err == nil {
+		result.check("spoke-rbac", checkAccess(ctx, spoke, klusterletAccess), "Spoke credentials can install the klusterlet")
+
+		err = nil
+		constraint := cp.GetMetadata().Compatibility["kubernetes"]
+		serverVersion, versionErr := spoke.Discovery().ServerVersion()
+		if versionErr != nil {
+			err = fmt.Errorf("failed to read server version: %w", versionErr)
+		} else if constraint != "" {
+			if ok, problem := evaluateConstraint(serverVersion.GitVersion, constraint); !ok {
+				err = fmt.Errorf("%s", problem)
+			}
+		}
+		result.check("spoke-version", err, "Spoke Kubernetes version is compatible")
+	}
+
+	spokeReachable := err == nil
+
+	// ITS hub
+	hub, _, err := target.clientset()
+	result.check("hub-connectivity", err, fmt.Sprintf("ITS hub %s is reachable", target.Name))
+	if err == nil {
+		result.check("hub-rbac", checkAccess(ctx, hub, hubOnboardAccess), "Hub credentials can accept the cluster")
+
+		err = hub.RESTClient().Get().
+			AbsPath("/apis/cluster.open-cluster-management.io/v1").
+			Resource("managedclusters").
+			Name(clusterName).
+			Do(ctx).
+			Error()
+		if err == nil {
+			err = fmt.Errorf("managed cluster '%s' already exists on the hub", clusterName)
+		} else if apierrors.IsNotFound(err) {
+			err = nil
+		}
+		result.check("hub-managedcluster", err, "No ManagedCluster with this name exists on the hub")
+	}
+
+	result.Actions = []string{fmt.Sprintf("Save the kubeconfig to %s", cp.secrets.Describe())}
+	for _, step := range cp.onboarding {
+		result.Actions = append(result.Actions, step.action(clusterName))
+	}
+
+	data := cp.manifestData(clusterName, merged)
+	if merged.Proxy != nil {
+		data.BootstrapKubeconfig = "<bootstrap kubeconfig>"
+	}
+	data.Hub = target.Name
+	templates := cp.manifestTemplates()
+	if manifests, err := renderManifests(templates, "hub", false, data); err == nil {
+		result.Manifests = append(result.Manifests, manifests...)
+	}
+	// The bindingPolicies land on the WDS once the cluster has joined
+	bindings, err := renderManifests(templates, wdsTarget, false, data)
+	result.check("binding-templates", err, "Rendered the bindingPolicies templates")
+	result.Manifests = append(result.Manifests, bindings...)
+
+	// The plugin's own templates are applied around what clusteradm creates
+	before, err := renderManifests(templates, "spoke", true, data)
+	var after []string
+	if err == nil {
+		after, err = renderManifests(templates, "spoke", false, data)
+	}
+	result.check("manifest-templates", err, "Rendered the manifest templates")
+	for _, manifest := range before {
+		result.Manifests = append(result.Manifests, redactSecretData(manifest))
+	}
+
+	// Let clusteradm render the klusterlet and bootstrap manifests it would apply
+	if spokeReachable {
+		manifests, err := cp.renderJoinManifests(ctx, target, clusterName, kubeconfigData, merged)
+		result.check("klusterlet-manifests", err, "Rendered the klusterlet manifests with clusteradm join --dry-run")
+		result.Manifests = append(result.Manifests, manifests...)
+	}
+
+	for _, manifest := range after {
+		result.Manifests = append(result.Manifests, redactSecretData(manifest))
+	}
+
+	return result
+}
+
+// renderJoinManifests runs the join command in dry-run mode and returns the
+// manifests it would apply to the spoke, with Secret data redacted since the
+// bootstrap kubeconfig carries the hub token
+func (cp *ClusterPlugin) renderJoinManifests(ctx context.Context, hub Hub, clusterName string, kubeconfigData []byte, values ManifestValues) ([]string, error) {
+	joinToken, err := cp.getClusterAdmToken(ctx, hub)
+	if err != nil {
+		return nil, err
+	}
+	tempPath, spokeContext, err := cp.createTempKubeconfig(kubeconfigData, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempPath)
+
+	outputFile, err := os.CreateTemp("", fmt.Sprintf("join-%s-*.yaml", clusterName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest file: %w", err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	cmdParts := append(joinCommand(joinToken, clusterName, spokeContext, values), "--dry-run", "--output-file", outputFile.Name())
+	cmd := exec.CommandContext(ctx, cmdParts[0], cmdParts[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("KUBECONFIG=%s", tempPath))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("join dry run failed: %s, %w", string(output), err)
+	}
+
+	data, err := os.ReadFile(outputFile.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rendered manifests: %w", err)
+	}
+
+	var manifests []string
+	for _, document := range strings.Split(string(data), "\n---") {
+		if strings.TrimSpace(document) == "" {
+			continue
+		}
+		manifests = append(manifests, redactSecretData(document))
+	}
+	return manifests, nil
+}
+
+// redactSecretData replaces the values of a Secret manifest's data fields
+func redactSecretData(document string) string {
+	var object map[string]interface{}
+	if err := yaml.Unmarshal([]byte(document), &object); err != nil || object["kind"] != "Secret" {
+		return strings.TrimSpace(document) + "\n"
+	}
+	for _, field := range []string{"data", "stringData"} {
+		if values, ok := object[field].(map[string]interface{}); ok {
+			for key := range values {
+				values[key] = "<redacted>"
+			}
+		}
+	}
+	redacted, err := yaml.Marshal(object)
+	if err != nil {
+		return "# Secret omitted: failed to redact its data\n"
+	}
+	return string(redacted)
+}
+
+// planDetachment validates a detach request and describes the steps it would run
+func (cp *ClusterPlugin) planDetachment(ctx context.Context, clusterName string, spokeKubeconfig []byte, force bool, drain time.Duration) DryRunResult {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result := DryRunResult{
+		Operation:   "detach",
+		ClusterName: clusterName,
+		Valid:       true,
+		Plugin:      "kubestellar-cluster-plugin",
+		Timestamp:   time.Now().Format(time.RFC3339),
+	}
+
+	_, exists, err := cp.store.Get(clusterName)
+	if err == nil && !exists {
+		err = fmt.Errorf("cluster '%s' not found in plugin", clusterName)
+	}
+	result.check("inventory", err, "Cluster is known to the plugin")
+
+	target, err := cp.clusterHub(clusterName)
+	result.Hub = target.Name
+	var hub *kubernetes.Clientset
+	if err == nil {
+		hub, _, err = target.clientset()
+	}
+	result.check("hub-connectivity", err, fmt.Sprintf("ITS hub %s is reachable", target.Name))
+	if err == nil {
+		result.check("hub-rbac", checkAccess(ctx, hub, hubDetachAccess), "Hub credentials can remove the cluster")
+	}
+
+	if safety, err := cp.checkDetachSafety(ctx, clusterName, force || drain > 0); err != nil {
+		if !errors.Is(err, errClusterNotFound) {
+			result.check("workloads", err, "")
+		}
+	} else if safety != nil && !safety.Safe {
+		message := "Detaching anyway, cluster is still targeted by " + safety.summary()
+		if drain > 0 {
+			message = "Draining first, cluster is still targeted by " + safety.summary()
+		}
+		result.Checks = append(result.Checks, DryRunCheck{Name: "workloads", Passed: true, Message: message})
+	} else if safety != nil {
+		result.check("workloads", nil, "No BindingPolicy, Placement or workload targets the cluster")
+	}
+
+	if drain > 0 {
+		result.Actions = append(result.Actions, fmt.Sprintf("Cordon ManagedCluster %s and wait up to %s for its workloads to be rescheduled", clusterName, drain))
+	}
+	result.Actions = append(result.Actions,
+		fmt.Sprintf("Connect to ITS hub %s", target.Name),
+		fmt.Sprintf("Delete ManagedCluster %s and wait up to %s for its finalizers", clusterName, cp.finalizerTimeout),
+	)
+	if force {
+		result.Actions = append(result.Actions, "Strip remaining finalizers after the timeout")
+	}
+
+	if len(spokeKubeconfig) == 0 {
+		spokeKubeconfig = cp.savedKubeconfig(clusterName)
+	}
+	if len(spokeKubeconfig) > 0 {
+		_, err := spokeClientset(spokeKubeconfig)
+		if err == nil {
+			err = cp.validateClusterConnectivity(ctx, spokeKubeconfig)
+		}
+		result.check("spoke-connectivity", err, "Spoke cluster is reachable")
+		result.Actions = append(result.Actions, fmt.Sprintf("Run clusteradm unjoin --cluster-name %s against the spoke", clusterName))
+	}
+
+	result.Actions = append(result.Actions, "Remove the saved kubeconfig and the cluster from the plugin inventory")
+	return result
+}
+
+func spokeClientset(kubeconfigData []byte) (*kubernetes.Clientset, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	return kubernetes.NewForConfig(config)
+}
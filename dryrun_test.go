@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+// newFakeAPIServer serves what the dry runs read from a spoke or hub: nodes,
+// the version, access reviews and ManagedClusters. Access to the denied
+// "verb resource" pairs is refused and only the existing ManagedClusters are found.
+func newFakeAPIServer(t *testing.T, denied []string, existing ...string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/version":
+			fmt.Fprint(w, `{"major": "1", "minor": "29", "gitVersion": "v1.29.2"}`)
+		case r.URL.Path == "/api/v1/nodes":
+			fmt.Fprint(w, `{"kind": "NodeList", "apiVersion": "v1", "items": []}`)
+		case r.URL.Path == "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews":
+			var review authorizationv1.SelfSubjectAccessReview
+			json.NewDecoder(r.Body).Decode(&review)
+			attributes := review.Spec.ResourceAttributes
+			review.Status.Allowed = true
+			for _, rule := range denied {
+				if rule == attributes.Verb+" "+attributes.Resource {
+					review.Status.Allowed = false
+				}
+			}
+			json.NewEncoder(w).Encode(review)
+		case strings.HasPrefix(r.URL.Path, "/apis/cluster.open-cluster-management.io/v1/managedclusters/"):
+			name := strings.TrimPrefix(r.URL.Path, "/apis/cluster.open-cluster-management.io/v1/managedclusters/")
+			for _, cluster := range existing {
+				if cluster == name {
+					fmt.Fprintf(w, `{"apiVersion": "cluster.open-cluster-management.io/v1", "kind": "ManagedCluster", "metadata": {"name": %q}}`, name)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404, "message": "managedclusters %q not found"}`, name)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: fake
+  cluster:
+    server: %s
+contexts:
+- name: fake
+  context:
+    cluster: fake
+    user: admin
+current-context: fake
+users:
+- name: admin
+  user:
+    token: secret
+`, server.URL)
+}
+
+func checkOutcomes(result DryRunResult) map[string]bool {
+	outcomes := make(map[string]bool, len(result.Checks))
+	for _, check := range result.Checks {
+		outcomes[check.Name] = check.Passed
+	}
+	return outcomes
+}
+
+func TestPlanOnboarding(t *testing.T) {
+	// Without clusteradm on the PATH the klusterlet manifests can't be rendered
+	t.Setenv("PATH", t.TempDir())
+	plugin := newTestPlugin(t)
+	spoke := newFakeAPIServer(t, []string{"create customresourcedefinitions"})
+	hub, err := plugin.hubs.Register(HubRegisterRequest{Name: "its2", Kubeconfig: newFakeAPIServer(t, nil, "edge-taken")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-known", Status: "Ready"})
+
+	tests := []struct {
+		cluster string
+		want    map[string]bool
+	}{
+		{cluster: "edge-1", want: map[string]bool{
+			"inventory": true, "spoke-connectivity": true, "spoke-rbac": false, "spoke-version": true,
+			"hub-connectivity": true, "hub-rbac": true, "hub-managedcluster": true, "klusterlet-manifests": false,
+		}},
+		{cluster: "edge-taken", want: map[string]bool{"inventory": true, "hub-managedcluster": false}},
+		{cluster: "edge-known", want: map[string]bool{"inventory": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cluster, func(t *testing.T) {
+			result := plugin.planOnboarding(context.Background(), hub, tt.cluster, []byte(spoke), nil)
+			if result.Operation != "onboard" || result.Hub != "its2" || result.Valid {
+				t.Errorf("result = %+v, want an invalid onboarding on its2", result)
+			}
+			outcomes := checkOutcomes(result)
+			for name, passed := range tt.want {
+				if got, ran := outcomes[name]; !ran || got != passed {
+					t.Errorf("check %s passed = %v (ran %v), want %v", name, got, ran, passed)
+				}
+			}
+			if len(result.Actions) != len(plugin.onboarding)+1 {
+				t.Errorf("actions = %v, want one per pipeline step after saving the kubeconfig", result.Actions)
+			}
+		})
+	}
+
+	for _, check := range plugin.planOnboarding(context.Background(), hub, "edge-1", []byte(spoke), nil).Checks {
+		if check.Name == "spoke-rbac" && check.Message != "missing permissions: create customresourcedefinitions" {
+			t.Errorf("spoke-rbac message = %q", check.Message)
+		}
+	}
+}
+
+func TestPlanDetachment(t *testing.T) {
+	plugin := newTestPlugin(t)
+	if _, err := plugin.hubs.Register(HubRegisterRequest{Name: "its2", Kubeconfig: newFakeAPIServer(t, []string{"delete managedclusters"})}); err != nil {
+		t.Fatal(err)
+	}
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready", Hub: "its2"})
+	spoke := newFakeAPIServer(t, nil)
+
+	result := plugin.planDetachment(context.Background(), "edge-1", []byte(spoke), true, 0)
+	want := map[string]bool{"inventory": true, "hub-connectivity": true, "hub-rbac": false, "workloads": true, "spoke-connectivity": true}
+	if outcomes := checkOutcomes(result); len(outcomes) != len(want) {
+		t.Errorf("checks = %+v, want %v", result.Checks, want)
+	} else {
+		for name, passed := range want {
+			if outcomes[name] != passed {
+				t.Errorf("check %s passed = %v, want %v", name, outcomes[name], passed)
+			}
+		}
+	}
+	if result.Valid || result.Hub != "its2" {
+		t.Errorf("result = %+v, want an invalid detachment on its2", result)
+	}
+	joined := strings.Join(result.Actions, "\n")
+	for _, action := range []string{"Strip remaining finalizers", "clusteradm unjoin --cluster-name edge-1"} {
+		if !strings.Contains(joined, action) {
+			t.Errorf("actions = %v, missing %q", result.Actions, action)
+		}
+	}
+
+	// Without a kubeconfig, given or saved, the spoke is left alone
+	result = plugin.planDetachment(context.Background(), "edge-1", nil, false, 0)
+	if _, ran := checkOutcomes(result)["spoke-connectivity"]; ran || strings.Contains(strings.Join(result.Actions, "\n"), "unjoin") {
+		t.Errorf("planDetachment() without a kubeconfig = %+v, want no spoke checks or unjoin", result)
+	}
+	if outcomes := checkOutcomes(plugin.planDetachment(context.Background(), "edge-9", nil, false, 0)); outcomes["inventory"] {
+		t.Error("planDetachment() of an unknown cluster passed the inventory check")
+	}
+}
+
+func TestBatchOnboardDryRun(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	if _, err := plugin.hubs.Register(HubRegisterRequest{Name: "its2", Kubeconfig: newFakeAPIServer(t, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	spoke, _ := json.Marshal(newFakeAPIServer(t, nil))
+	body := fmt.Sprintf(`{"clusters": [
+		{"clusterName": "edge-1", "hub": "its2", "kubeconfig": %s, "dryRun": true},
+		{"clusterName": "edge-2", "hub": "its9", "kubeconfig": %s, "dryRun": true}
+	]}`, spoke, spoke)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/onboard/batch", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	plugin.BatchOnboardHandler(c)
+
+	var response struct {
+		Items []BatchItem `json:"items"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response %s: %v", recorder.Body, err)
+	}
+	if len(response.Items) != 2 {
+		t.Fatalf("items = %+v, want 2", response.Items)
+	}
+	if item := response.Items[0]; item.DryRun == nil || item.JobID != "" || item.DryRun.Hub != "its2" {
+		t.Errorf("dry-run item = %+v, want a plan on its2 and no job", item)
+	}
+	if item := response.Items[1]; item.DryRun != nil || item.Error == "" {
+		t.Errorf("item on an unknown hub = %+v, want an error", item)
+	}
+	if clusters, _ := plugin.store.List(); len(clusters) != 0 {
+		t.Errorf("dry run recorded clusters %+v", clusters)
+	}
+	if jobs := plugin.jobs.List(); len(jobs) != 0 {
+		t.Errorf("dry run created jobs %+v", jobs)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Job events published on the event bus, next to the lifecycle events also
+// delivered to webhooks
+const (
+	eventJobStarted   = "job.started"
+	eventJobStep      = "job.step"
+	eventJobSucceeded = "job.succeeded"
+	eventJobFailed    = "job.failed"
+	eventJobCancelled = "job.cancelled"
+)
+
+var jobEvents = map[string]bool{
+	eventJobStarted:   true,
+	eventJobStep:      true,
+	eventJobSucceeded: true,
+	eventJobFailed:    true,
+	eventJobCancelled: true,
+}
+
+// knownEvent reports whether eventType is published on the bus
+func knownEvent(eventType string) bool {
+	return webhookEvents[eventType] || jobEvents[eventType]
+}
+
+// Event is something that happened in the plugin, published on the event bus
+type Event struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	ClusterName string `json:"clusterName,omitempty"`
+	JobID       string `json:"jobId,omitempty"`
+	Message     string `json:"message,omitempty"`
+	// Attributes holds event specific details, such as the step of job.step
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Timestamp  string            `json:"timestamp"`
+}
+
+// Warning reports whether the event signals a problem
+func (e Event) Warning() bool {
+	return e.Type == eventJobFailed || e.Type == eventClusterFailed || e.Type == eventClusterUnreachable ||
+		e.Type == eventClusterTokenExpiring || e.Type == eventClusterSLOBurning || e.Type == eventPluginHealthDegraded
+}
+
+// EventSink delivers events to one destination
+type EventSink interface {
+	Send(ctx context.Context, event Event) error
+	Close() error
+}
+
+// sinkWorker feeds one sink from its own queue, so a slow sink neither
+// blocks publishers nor delays the other sinks
+type sinkWorker struct {
+	name    string
+	sink    EventSink
+	types   map[string]bool
+	queue   chan Event
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+func (w *sinkWorker) run() {
+	defer close(w.done)
+	for event := range w.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := w.sink.Send(ctx, event); err != nil {
+			logger().Warn("Event sink failed", "sink", w.name, "event", event.Type, "error", err)
+		}
+		cancel()
+	}
+}
+
+// EventBus fans the events published by every subsystem out to the sinks
+type EventBus struct {
+	workers []*sinkWorker
+	closed  bool
+	mutex   sync.RWMutex
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe delivers the events of the given types to sink, or every event
+// when types is empty
+func (eb *EventBus) Subscribe(name string, sink EventSink, types []string) {
+	worker := &sinkWorker{
+		name:  name,
+		sink:  sink,
+		queue: make(chan Event, 1000),
+		done:  make(chan struct{}),
+	}
+	if len(types) > 0 {
+		worker.types = make(map[string]bool, len(types))
+		for _, eventType := range types {
+			worker.types[eventType] = true
+		}
+	}
+	go worker.run()
+
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+	eb.workers = append(eb.workers, worker)
+}
+
+// Publish queues event for every subscribed sink without blocking; sinks
+// whose queue is full miss it
+func (eb *EventBus) Publish(event Event) {
+	if event.ID == "" {
+		event.ID = newJobID("event")
+	}
+	if event.Timestamp == "" {
+		event.Timestamp = time.Now().Format(time.RFC3339)
+	}
+
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+	if eb.closed {
+		return
+	}
+	for _, worker := range eb.workers {
+		if worker.types != nil && !worker.types[event.Type] {
+			continue
+		}
+		select {
+		case worker.queue <- event:
+		default:
+			if worker.dropped.Add(1) == 1 {
+				logger().Warn("Event sink is falling behind, dropping events", "sink", worker.name)
+			}
+		}
+	}
+}
+
+// Close stops accepting events and waits up to timeout for the sinks to
+// deliver the queued ones before closing them
+func (eb *EventBus) Close(timeout time.Duration) {
+	eb.mutex.Lock()
+	if eb.closed {
+		eb.mutex.Unlock()
+		return
+	}
+	eb.closed = true
+	workers := eb.workers
+	eb.mutex.Unlock()
+
+	deadline := time.After(timeout)
+	for _, worker := range workers {
+		close(worker.queue)
+	}
+	for _, worker := range workers {
+		select {
+		case <-worker.done:
+		case <-deadline:
+			logger().Warn("Event sink still delivering after shutdown timeout", "sink", worker.name, "timeout", timeout)
+			continue
+		}
+		if err := worker.sink.Close(); err != nil {
+			logger().Warn("Failed to close event sink", "sink", worker.name, "error", err)
+		}
+	}
+}
+
+// notify publishes a lifecycle event on the event bus, if it is running
+func (cp *ClusterPlugin) notify(eventType, clusterName, jobID, message string) {
+	if cp.events == nil {
+		return
+	}
+	cp.events.Publish(Event{
+		Type:        eventType,
+		ClusterName: clusterName,
+		JobID:       jobID,
+		Message:     message,
+	})
+}
+
+// EventSinkConfig is one entry of the eventSinks list of the Initialize config
+type EventSinkConfig struct {
+	// Type is log, nats, kubernetes, or the slack, teams and smtp notifiers
+	Type string `json:"type"`
+	// Types limits the sink to these events; empty means every event
+	Types []string `json:"types,omitempty"`
+	// URL is the NATS server URL, or the Slack or Teams incoming webhook
+	URL string `json:"url,omitempty"`
+	// Subject prefixes the NATS subject, which ends with the event type
+	Subject string `json:"subject,omitempty"`
+	// Context is the kubeconfig context of the hub Kubernetes Events are written to
+	Context string `json:"context,omitempty"`
+	// Namespace holds the Kubernetes Events, defaulting to default
+	Namespace string `json:"namespace,omitempty"`
+	// Template is the text/template notifiers render events with, and
+	// Templates overrides it for the event types it names
+	Template  string            `json:"template,omitempty"`
+	Templates map[string]string `json:"templates,omitempty"`
+	// SMTP is the mail server and recipients of the smtp notifier
+	SMTP *SMTPConfig `json:"smtp,omitempty"`
+}
+
+func eventSinksFromConfig(config map[string]interface{}) ([]EventSinkConfig, error) {
+	raw, ok := config["eventSinks"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid eventSinks config: %w", err)
+	}
+	var sinks []EventSinkConfig
+	if err := json.Unmarshal(data, &sinks); err != nil {
+		return nil, fmt.Errorf("invalid eventSinks config: %w", err)
+	}
+	return sinks, nil
+}
+
+// newEventSink creates the sink described by sc
+func newEventSink(sc EventSinkConfig) (EventSink, error) {
+	for _, eventType := range sc.Types {
+		if !knownEvent(eventType) {
+			return nil, fmt.Errorf("unknown event %q", eventType)
+		}
+	}
+	switch sc.Type {
+	case "log":
+		return logEventSink{}, nil
+	case "nats":
+		if sc.URL == "" {
+			return nil, fmt.Errorf("nats sink requires a url")
+		}
+		subject := sc.Subject
+		if subject == "" {
+			subject = "kubestellar.plugin.cluster"
+		}
+		conn, err := nats.Connect(sc.URL, nats.Name("kubestellar-cluster-plugin"), nats.MaxReconnects(-1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to NATS at %s: %w", sc.URL, err)
+		}
+		return &natsEventSink{conn: conn, subject: subject}, nil
+	case "kubernetes":
+		sink := &kubernetesEventSink{context: sc.Context, namespace: sc.Namespace}
+		if sink.context == "" {
+			sink.context = "its1"
+		}
+		if sink.namespace == "" {
+			sink.namespace = metav1.NamespaceDefault
+		}
+		return sink, nil
+	case "slack", "teams", "smtp":
+		return newNotifier(sc)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q, must be log, nats, kubernetes, slack, teams or smtp", sc.Type)
+	}
+}
+
+// logEventSink writes events to the plugin log
+type logEventSink struct{}
+
+func (logEventSink) Send(_ context.Context, event Event) error {
+	args := []any{"event", event.Type, "cluster", event.ClusterName, "job", event.JobID, "message", event.Message}
+	for key, value := range event.Attributes {
+		args = append(args, key, value)
+	}
+	if event.Warning() {
+		logger().Warn("Plugin event", args...)
+	} else {
+		logger().Info("Plugin event", args...)
+	}
+	return nil
+}
+
+func (logEventSink) Close() error {
+	return nil
+}
+
+// webhookEventSink hands lifecycle events to the registered webhooks
+type webhookEventSink struct {
+	notifier *WebhookNotifier
+}
+
+func (s webhookEventSink) Send(_ context.Context, event Event) error {
+	s.notifier.Notify(WebhookEvent{
+		Type:        event.Type,
+		ClusterName: event.ClusterName,
+		JobID:       event.JobID,
+		Message:     event.Message,
+		Timestamp:   event.Timestamp,
+	})
+	return nil
+}
+
+// Close leaves the notifier open; the plugin closes it after the bus
+func (webhookEventSink) Close() error {
+	return nil
+}
+
+// natsEventSink publishes events as JSON to <subject>.<event type>
+type natsEventSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func (s *natsEventSink) Send(_ context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.conn.Publish(s.subject+"."+event.Type, data)
+}
+
+func (s *natsEventSink) Close() error {
+	return s.conn.Drain()
+}
+
+// kubernetesEventSink records events about clusters as Kubernetes Events on
+// their ManagedCluster on the hub. Events about no cluster are skipped.
+type kubernetesEventSink struct {
+	context   string
+	namespace string
+	// client is created on first use, since the hub may be down at startup
+	client kubernetes.Interface
+}
+
+func (s *kubernetesEventSink) Send(ctx context.Context, event Event) error {
+	if event.ClusterName == "" {
+		return nil
+	}
+	if s.client == nil {
+		client, _, err := GetClientSetWithConfigContext(s.context)
+		if err != nil {
+			return fmt.Errorf("failed to connect to hub: %w", err)
+		}
+		s.client = client
+	}
+
+	eventType := corev1.EventTypeNormal
+	if event.Warning() {
+		eventType = corev1.EventTypeWarning
+	}
+	now := metav1.Now()
+	_, err := s.client.CoreV1().Events(s.namespace).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: event.ClusterName + ".",
+			Namespace:    s.namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "cluster.open-cluster-management.io/v1",
+			Kind:       "ManagedCluster",
+			Name:       event.ClusterName,
+		},
+		Reason:              eventReason(event.Type),
+		Message:             event.Message,
+		Type:                eventType,
+		Source:              corev1.EventSource{Component: "kubestellar-cluster-plugin"},
+		ReportingController: "kubestellar.io/cluster-plugin",
+		ReportingInstance:   "kubestellar-cluster-plugin",
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+	}, metav1.CreateOptions{})
+	return err
+}
+
+func (s *kubernetesEventSink) Close() error {
+	return nil
+}
+
+// eventReason turns an event type such as job.step into a reason such as JobStep
+func eventReason(eventType string) string {
+	var reason strings.Builder
+	for _, part := range strings.Split(eventType, ".") {
+		if part != "" {
+			reason.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return reason.String()
+}
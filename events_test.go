@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink keeps the events it receives
+type recordingSink struct {
+	events []Event
+	closed bool
+	mutex  sync.Mutex
+}
+
+func (s *recordingSink) Send(_ context.Context, event Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) types() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	types := make([]string, 0, len(s.events))
+	for _, event := range s.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestEventBusJobEvents(t *testing.T) {
+	bus := NewEventBus()
+	all := &recordingSink{}
+	failures := &recordingSink{}
+	bus.Subscribe("all", all, nil)
+	bus.Subscribe("failures", failures, []string{eventJobFailed})
+
+	jobs := NewJobManager(nil, 0)
+	jobs.events = bus
+	job := jobs.Create(context.Background(), "onboard", "demo")
+	jobs.Execute(job.ID, func(context.Context) error {
+		jobs.RecordStep(job.ID, "Joining", "Joining the hub")
+		return errors.New("join timed out")
+	})
+	bus.Close(time.Second)
+	// Events published after Close are dropped
+	bus.Publish(Event{Type: eventJobStarted})
+
+	want := []string{eventJobStarted, eventJobStep, eventJobFailed}
+	if got := all.types(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("all sink got %v, want %v", got, want)
+	}
+	if len(failures.events) != 1 || failures.events[0].Attributes["step"] != "Joining" || failures.events[0].ClusterName != "demo" {
+		t.Errorf("failures sink got %+v", failures.events)
+	}
+	if !all.closed || !failures.closed {
+		t.Error("Close() did not close the sinks")
+	}
+}
+
+func TestNewEventSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  EventSinkConfig
+		wantErr bool
+	}{
+		{name: "log", config: EventSinkConfig{Type: "log", Types: []string{eventClusterOnboarded, eventJobFailed}}},
+		{name: "kubernetes", config: EventSinkConfig{Type: "kubernetes"}},
+		{name: "unknown type", config: EventSinkConfig{Type: "kafka"}, wantErr: true},
+		{name: "unknown event", config: EventSinkConfig{Type: "log", Types: []string{"cluster.exploded"}}, wantErr: true},
+		{name: "nats without url", config: EventSinkConfig{Type: "nats"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newEventSink(tt.config); (err != nil) != tt.wantErr {
+				t.Fatalf("newEventSink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEventReason(t *testing.T) {
+	for eventType, want := range map[string]string{
+		eventJobStep:              "JobStep",
+		eventClusterOnboarded:     "ClusterOnboarded",
+		eventPluginHealthDegraded: "PluginHealthDegraded",
+	} {
+		if got := eventReason(eventType); got != want {
+			t.Errorf("eventReason(%q) = %q, want %q", eventType, got, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/yaml"
+)
+
+// inventoryKind identifies an inventory export, so tools reading one back
+// can tell it apart from other documents
+const inventoryKind = "ClusterInventory"
+
+// Inventory is a snapshot of every cluster known to the plugin, produced by
+// GET /clusters/export
+type Inventory struct {
+	Kind          string             `json:"kind"`
+	PluginVersion string             `json:"pluginVersion"`
+	ExportedAt    string             `json:"exportedAt"`
+	Hubs          []Hub              `json:"hubs"`
+	Summary       map[string]int     `json:"summary"`
+	Clusters      []InventoryCluster `json:"clusters"`
+}
+
+// InventoryCluster is a cluster of an inventory. A YAML or JSON inventory
+// can be posted to POST /clusters/import to onboard its clusters elsewhere.
+type InventoryCluster struct {
+	Name           string            `json:"name"`
+	Hub            string            `json:"hub"`
+	Status         string            `json:"status"`
+	Message        string            `json:"message,omitempty"`
+	LastUpdated    string            `json:"lastUpdated"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	Available      string            `json:"available,omitempty"`
+	LastHeartbeat  string            `json:"lastHeartbeat,omitempty"`
+	ManifestValues *ManifestValues   `json:"manifestValues,omitempty"`
+	History        HistorySummary    `json:"history"`
+	Timeline       HistoryTimeline   `json:"timeline"`
+}
+
+// HistorySummary sums up the operations run against a cluster
+type HistorySummary struct {
+	Jobs         int      `json:"jobs"`
+	Succeeded    int      `json:"succeeded"`
+	Failed       int      `json:"failed"`
+	FirstJobAt   string   `json:"firstJobAt,omitempty"`
+	LastJobType  string   `json:"lastJobType,omitempty"`
+	LastJobState JobState `json:"lastJobState,omitempty"`
+	LastJobAt    string   `json:"lastJobAt,omitempty"`
+}
+
+// inventoryCSVHeader names the columns of a CSV inventory. Labels are
+// written as key=value pairs separated by semicolons, as an import reads
+// them.
+var inventoryCSVHeader = []string{
+	"name", "hub", "status", "message", "lastUpdated", "labels", "available", "lastHeartbeat",
+	"jobs", "succeeded", "failed", "lastJobType", "lastJobState", "lastJobAt", "onboardedAt", "lastUnreachableAt",
+}
+
+// historySummaries sums up the jobs of each cluster
+func historySummaries(jobs []Job) map[string]HistorySummary {
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt < jobs[j].CreatedAt })
+	summaries := make(map[string]HistorySummary)
+	for _, job := range jobs {
+		summary := summaries[job.ClusterName]
+		summary.Jobs++
+		switch job.State {
+		case JobSucceeded:
+			summary.Succeeded++
+		case JobFailed, JobTimedOut:
+			summary.Failed++
+		}
+		if summary.FirstJobAt == "" {
+			summary.FirstJobAt = job.CreatedAt
+		}
+		summary.LastJobType = job.Type
+		summary.LastJobState = job.State
+		summary.LastJobAt = job.UpdatedAt
+		summaries[job.ClusterName] = summary
+	}
+	return summaries
+}
+
+// buildInventory snapshots the given clusters with their job summaries and
+// timelines
+func (cp *ClusterPlugin) buildInventory(clusters []ClusterStatus) Inventory {
+	summaries := historySummaries(cp.jobs.List())
+	inventory := Inventory{
+		Kind:          inventoryKind,
+		PluginVersion: cp.GetMetadata().Version,
+		ExportedAt:    time.Now().Format(time.RFC3339),
+		Hubs:          cp.hubs.List(),
+		Summary:       map[string]int{"total": len(clusters)},
+		Clusters:      make([]InventoryCluster, 0, len(clusters)),
+	}
+	for _, cluster := range clusters {
+		item := InventoryCluster{
+			Name:           cluster.ClusterName,
+			Hub:            hubName(cluster),
+			Status:         cluster.Status,
+			Message:        cluster.Message,
+			LastUpdated:    cluster.LastUpdated,
+			Labels:         cluster.Labels,
+			Annotations:    cluster.Annotations,
+			LastHeartbeat:  cluster.LastHeartbeat,
+			ManifestValues: cluster.ManifestValues,
+			History:        summaries[cluster.ClusterName],
+		}
+		if entries, err := cp.store.History(cluster.ClusterName); err == nil {
+			item.Timeline = buildTimeline(entries)
+		}
+		if cluster.ManagedCluster != nil {
+			item.Available = cluster.ManagedCluster.Available
+		}
+		inventory.Summary[strings.ToLower(cluster.Status)]++
+		inventory.Clusters = append(inventory.Clusters, item)
+	}
+	return inventory
+}
+
+// formatLabelList writes labels as sorted key=value pairs separated by
+// semicolons, the inverse of parseLabelList
+func formatLabelList(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
+
+// encodeInventoryCSV writes one line per cluster of the inventory
+func encodeInventoryCSV(inventory Inventory) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(inventoryCSVHeader); err != nil {
+		return nil, err
+	}
+	for _, cluster := range inventory.Clusters {
+		history := cluster.History
+		record := []string{
+			cluster.Name, cluster.Hub, cluster.Status, cluster.Message, cluster.LastUpdated,
+			formatLabelList(cluster.Labels), cluster.Available, cluster.LastHeartbeat,
+			strconv.Itoa(history.Jobs), strconv.Itoa(history.Succeeded), strconv.Itoa(history.Failed),
+			history.LastJobType, string(history.LastJobState), history.LastJobAt,
+			cluster.Timeline.OnboardedAt, cluster.Timeline.LastUnreachableAt,
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// ExportClustersHandler returns the cluster inventory as JSON, YAML or CSV
+// for backup, reporting or moving the clusters to another hub. The status,
+// hub and labelSelector filters of GET /status narrow it down.
+func (cp *ClusterPlugin) ExportClustersHandler(c *gin.Context) {
+	query, err := parseClusterQuery(c)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	contentTypes := map[string]string{
+		"json": "application/json; charset=utf-8",
+		"yaml": "application/yaml; charset=utf-8",
+		"csv":  "text/csv; charset=utf-8",
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid format %q, must be json, yaml or csv", format))
+		return
+	}
+
+	snapshot, err := cp.statusCache.get(c.Query("refresh") == "true", cp.loadStatusSnapshot)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	inventory := cp.buildInventory(query.Filter(tenantClusters(c.Request.Context(), snapshot.clusters)))
+
+	var data []byte
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(inventory, "", "  ")
+	case "yaml":
+		data, err = yaml.Marshal(inventory)
+	case "csv":
+		data, err = encodeInventoryCSV(inventory)
+	}
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to encode inventory: %v", err))
+		return
+	}
+
+	filename := fmt.Sprintf("cluster-inventory-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, contentType, data)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHistorySummaries(t *testing.T) {
+	summaries := historySummaries([]Job{
+		{ClusterName: "edge-1", Type: "detach", State: JobSucceeded, CreatedAt: "2026-01-03T00:00:00Z", UpdatedAt: "2026-01-03T00:01:00Z"},
+		{ClusterName: "edge-1", Type: "onboard", State: JobFailed, CreatedAt: "2026-01-01T00:00:00Z", UpdatedAt: "2026-01-01T00:05:00Z"},
+		{ClusterName: "edge-1", Type: "onboard", State: JobSucceeded, CreatedAt: "2026-01-02T00:00:00Z", UpdatedAt: "2026-01-02T00:05:00Z"},
+		{ClusterName: "edge-2", Type: "onboard", State: JobRunning, CreatedAt: "2026-01-02T00:00:00Z"},
+	})
+	want := HistorySummary{
+		Jobs: 3, Succeeded: 2, Failed: 1, FirstJobAt: "2026-01-01T00:00:00Z",
+		LastJobType: "detach", LastJobState: JobSucceeded, LastJobAt: "2026-01-03T00:01:00Z",
+	}
+	if summaries["edge-1"] != want {
+		t.Errorf("edge-1 = %+v, want %+v", summaries["edge-1"], want)
+	}
+	if summaries["edge-2"].Jobs != 1 || summaries["edge-2"].LastJobState != JobRunning {
+		t.Errorf("edge-2 = %+v", summaries["edge-2"])
+	}
+}
+
+func TestExportClustersHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Ready", Labels: map[string]string{"env": "prod", "tier": "web"}})
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-2", Status: "Failed", Message: "join timed out, retrying"})
+	job := plugin.jobs.Create(context.Background(), "onboard", "edge-2")
+	plugin.jobs.Execute(job.ID, func(context.Context) error { return errors.New("join timed out") })
+
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	export := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/plugins/kubestellar-cluster-plugin/v1/clusters/export"+query, nil))
+		return recorder
+	}
+
+	recorder := export("")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Header().Get("Content-Disposition"), ".json") {
+		t.Fatalf("JSON export = %d %v", recorder.Code, recorder.Header())
+	}
+	var inventory Inventory
+	if err := json.Unmarshal(recorder.Body.Bytes(), &inventory); err != nil {
+		t.Fatal(err)
+	}
+	if inventory.Kind != inventoryKind || len(inventory.Clusters) != 2 || inventory.Summary["failed"] != 1 {
+		t.Fatalf("inventory = %+v", inventory)
+	}
+	if history := inventory.Clusters[1].History; history.Jobs != 1 || history.Failed != 1 {
+		t.Errorf("edge-2 history = %+v", history)
+	}
+
+	recorder = export("?format=csv&status=Ready")
+	records, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][0] != "edge-1" || records[1][5] != "env=prod;tier=web" {
+		t.Errorf("CSV export = %v", records)
+	}
+
+	recorder = export("?format=yaml")
+	if recorder.Header().Get("Content-Type") != "application/yaml; charset=utf-8" {
+		t.Fatalf("YAML export Content-Type = %q", recorder.Header().Get("Content-Type"))
+	}
+	rows, _, err := parseImportYAML(recorder.Body.Bytes())
+	if err != nil || len(rows) != 2 || rows[0].cluster.Labels["tier"] != "web" {
+		t.Errorf("importing the YAML export = %+v, %v", rows, err)
+	}
+
+	if recorder := export("?format=xml"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown format = %d", recorder.Code)
+	}
+}
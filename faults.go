@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// faultHandlers serve the fault injection itself and are never faulted, so
+// faults can always be cleared
+var faultHandlers = map[string]bool{
+	"GetFaultsHandler":   true,
+	"SetFaultsHandler":   true,
+	"ClearFaultsHandler": true,
+}
+
+// FaultInjection is the set of faults POST /debug/faults injects. Request
+// faults apply to every endpoint unless Endpoints names the handlers to
+// fault.
+type FaultInjection struct {
+	// LatencyMillis delays matched requests, plus up to LatencyJitterMillis
+	LatencyMillis       int `json:"latencyMillis,omitempty"`
+	LatencyJitterMillis int `json:"latencyJitterMillis,omitempty"`
+	// ErrorRate is the share of matched requests, from 0 to 1, answered
+	// with ErrorStatus, 500 by default
+	ErrorRate   float64  `json:"errorRate,omitempty"`
+	ErrorStatus int      `json:"errorStatus,omitempty"`
+	Endpoints   []string `json:"endpoints,omitempty"`
+	// StepFailures fail attempts of onboarding pipeline steps
+	StepFailures []StepFault `json:"stepFailures,omitempty"`
+	// DurationSeconds clears the faults after that long, never by default
+	DurationSeconds int `json:"durationSeconds,omitempty"`
+}
+
+// StepFault fails attempts of an onboarding step, which its retries may
+// then recover from
+type StepFault struct {
+	Step string `json:"step"`
+	// Rate is the share of attempts, from 0 to 1, that fail, 1 by default
+	Rate    float64 `json:"rate,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+// FaultStats counts the faults injected since they were set
+type FaultStats struct {
+	DelayedRequests int `json:"delayedRequests"`
+	FailedRequests  int `json:"failedRequests"`
+	FailedSteps     int `json:"failedSteps"`
+}
+
+// faultInjector holds the active faults, nil when fault injection is
+// disabled by config
+type faultInjector struct {
+	mutex     sync.Mutex
+	faults    FaultInjection
+	setAt     time.Time
+	expiresAt time.Time
+	stats     FaultStats
+	// random is seeded once so runs differ
+	random *rand.Rand
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// validateFaults checks the faults against the endpoints and pipeline steps,
+// filling in the defaults
+func (cp *ClusterPlugin) validateFaults(faults *FaultInjection) error {
+	if faults.LatencyMillis < 0 || faults.LatencyJitterMillis < 0 || faults.DurationSeconds < 0 {
+		return fmt.Errorf("latencyMillis, latencyJitterMillis and durationSeconds must not be negative")
+	}
+	if faults.ErrorRate < 0 || faults.ErrorRate > 1 {
+		return fmt.Errorf("errorRate must be between 0 and 1")
+	}
+	if faults.ErrorStatus == 0 {
+		faults.ErrorStatus = http.StatusInternalServerError
+	}
+	if faults.ErrorStatus < 500 || faults.ErrorStatus > 599 {
+		return fmt.Errorf("errorStatus must be a 5xx status")
+	}
+	handlers := cp.bareHandlers()
+	for _, endpoint := range faults.Endpoints {
+		if _, exists := handlers[endpoint]; !exists || faultHandlers[endpoint] {
+			return fmt.Errorf("unknown endpoint handler %q", endpoint)
+		}
+	}
+	for i := range faults.StepFailures {
+		fault := &faults.StepFailures[i]
+		known := false
+		for _, step := range cp.onboarding {
+			known = known || step.name == fault.Step
+		}
+		if !known {
+			return fmt.Errorf("stepFailures[%d]: the onboarding pipeline has no step %q", i, fault.Step)
+		}
+		if fault.Rate == 0 {
+			fault.Rate = 1
+		}
+		if fault.Rate < 0 || fault.Rate > 1 {
+			return fmt.Errorf("stepFailures[%d]: rate must be between 0 and 1", i)
+		}
+	}
+	return nil
+}
+
+// set replaces the active faults
+func (fi *faultInjector) set(faults FaultInjection) {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	fi.faults = faults
+	fi.setAt = time.Now()
+	fi.expiresAt = time.Time{}
+	if faults.DurationSeconds > 0 {
+		fi.expiresAt = fi.setAt.Add(time.Duration(faults.DurationSeconds) * time.Second)
+	}
+	fi.stats = FaultStats{}
+}
+
+// active returns the faults in effect, clearing them once expired. The
+// caller holds fi.mutex.
+func (fi *faultInjector) active() FaultInjection {
+	if !fi.expiresAt.IsZero() && time.Now().After(fi.expiresAt) {
+		fi.faults = FaultInjection{}
+		fi.expiresAt = time.Time{}
+	}
+	return fi.faults
+}
+
+// requestFault decides what happens to a request for the handler: how long
+// it is delayed and the status it fails with, 0 to serve it
+func (fi *faultInjector) requestFault(handlerName string) (time.Duration, int) {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	faults := fi.active()
+	if len(faults.Endpoints) > 0 {
+		matched := false
+		for _, endpoint := range faults.Endpoints {
+			matched = matched || endpoint == handlerName
+		}
+		if !matched {
+			return 0, 0
+		}
+	}
+	delay := time.Duration(faults.LatencyMillis) * time.Millisecond
+	if faults.LatencyJitterMillis > 0 {
+		delay += time.Duration(fi.random.Intn(faults.LatencyJitterMillis+1)) * time.Millisecond
+	}
+	if delay > 0 {
+		fi.stats.DelayedRequests++
+	}
+	status := 0
+	if faults.ErrorRate > 0 && fi.random.Float64() < faults.ErrorRate {
+		status = faults.ErrorStatus
+		fi.stats.FailedRequests++
+	}
+	return delay, status
+}
+
+// stepFault returns the error an attempt of the onboarding step fails with,
+// if any
+func (fi *faultInjector) stepFault(step string) error {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	for _, fault := range fi.active().StepFailures {
+		if fault.Step != step || fi.random.Float64() >= fault.Rate {
+			continue
+		}
+		fi.stats.FailedSteps++
+		if fault.Message != "" {
+			return fmt.Errorf("injected fault: %s", fault.Message)
+		}
+		return fmt.Errorf("injected fault in step %s", step)
+	}
+	return nil
+}
+
+// injectStepFault fails an attempt of the onboarding step when a fault says
+// so
+func (cp *ClusterPlugin) injectStepFault(step string) error {
+	if cp.faults == nil {
+		return nil
+	}
+	return cp.faults.stepFault(step)
+}
+
+// withFaults delays and fails requests as the active faults say
+func (cp *ClusterPlugin) withFaults(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc {
+	if faultHandlers[handlerName] {
+		return handler
+	}
+	return func(c *gin.Context) {
+		if cp.faults == nil {
+			handler(c)
+			return
+		}
+		delay, status := cp.faults.requestFault(handlerName)
+		if delay > 0 {
+			select {
+			case <-c.Request.Context().Done():
+			case <-time.After(delay):
+			}
+		}
+		if status != 0 {
+			c.Header("X-Fault-Injected", "true")
+			abortWithProblem(c, status, CodeInternal, "Injected fault")
+			return
+		}
+		handler(c)
+	}
+}
+
+// requireFaultInjection answers 404 unless faultInjection is enabled
+func (cp *ClusterPlugin) requireFaultInjection(c *gin.Context) bool {
+	if cp.faults == nil {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, "Fault injection is disabled")
+		return false
+	}
+	return true
+}
+
+// faultsResponse reports the active faults
+func (cp *ClusterPlugin) faultsResponse(c *gin.Context) {
+	fi := cp.faults
+	fi.mutex.Lock()
+	faults := fi.active()
+	response := gin.H{
+		"faults":    faults,
+		"stats":     fi.stats,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	if !fi.setAt.IsZero() {
+		response["setAt"] = fi.setAt.Format(time.RFC3339)
+	}
+	if !fi.expiresAt.IsZero() {
+		response["expiresAt"] = fi.expiresAt.Format(time.RFC3339)
+	}
+	fi.mutex.Unlock()
+	c.JSON(http.StatusOK, response)
+}
+
+// GetFaultsHandler returns the active faults and how often they were injected
+func (cp *ClusterPlugin) GetFaultsHandler(c *gin.Context) {
+	if !cp.requireFaultInjection(c) {
+		return
+	}
+	cp.faultsResponse(c)
+}
+
+// SetFaultsHandler replaces the active faults
+func (cp *ClusterPlugin) SetFaultsHandler(c *gin.Context) {
+	if !cp.requireFaultInjection(c) {
+		return
+	}
+	var faults FaultInjection
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&faults); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Invalid faults: %v", err))
+		return
+	}
+	if err := cp.validateFaults(&faults); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeValidationFailed, err.Error())
+		return
+	}
+	cp.faults.set(faults)
+	requestLogger(c).Warn("Fault injection updated", "faults", faults)
+	cp.faultsResponse(c)
+}
+
+// ClearFaultsHandler removes every fault
+func (cp *ClusterPlugin) ClearFaultsHandler(c *gin.Context) {
+	if !cp.requireFaultInjection(c) {
+		return
+	}
+	cp.faults.set(FaultInjection{})
+	requestLogger(c).Info("Fault injection cleared")
+	cp.faultsResponse(c)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidateFaults(t *testing.T) {
+	plugin := newTestPlugin(t)
+	faults := FaultInjection{ErrorRate: 0.5, StepFailures: []StepFault{{Step: "token"}}}
+	if err := plugin.validateFaults(&faults); err != nil {
+		t.Fatalf("validateFaults() error = %v", err)
+	}
+	if faults.ErrorStatus != http.StatusInternalServerError || faults.StepFailures[0].Rate != 1 {
+		t.Errorf("defaults not filled in: %+v", faults)
+	}
+
+	for name, faults := range map[string]FaultInjection{
+		"negative latency": {LatencyMillis: -1},
+		"error rate":       {ErrorRate: 1.5},
+		"not a 5xx":        {ErrorRate: 1, ErrorStatus: http.StatusNotFound},
+		"unknown endpoint": {Endpoints: []string{"NoSuchHandler"}},
+		"fault endpoint":   {Endpoints: []string{"ClearFaultsHandler"}},
+		"unknown step":     {StepFailures: []StepFault{{Step: "launch"}}},
+		"step rate":        {StepFailures: []StepFault{{Step: "token", Rate: 2}}},
+	} {
+		if err := plugin.validateFaults(&faults); err == nil {
+			t.Errorf("%s: faults accepted", name)
+		}
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"faultInjection":      true,
+		"mode":                modeMock,
+		"mockFleetSize":       3,
+		"mockStepDelayMillis": 1,
+	})
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, "/api/plugins/kubestellar-cluster-plugin/v1"+path, strings.NewReader(body))
+		if body != "" {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve(http.MethodPost, "/debug/faults", `{
+		"latencyMillis": 20, "errorRate": 1, "errorStatus": 503, "endpoints": ["GetClusterStatusHandler"],
+		"stepFailures": [{"step": "token", "message": "hub token endpoint down"}]
+	}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("set faults = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	started := time.Now()
+	recorder = serve(http.MethodGet, "/status", "")
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("X-Fault-Injected") != "true" || time.Since(started) < 20*time.Millisecond {
+		t.Errorf("faulted /status = %d after %v", recorder.Code, time.Since(started))
+	}
+	if recorder := serve(http.MethodGet, "/jobs", ""); recorder.Code != http.StatusOK {
+		t.Errorf("unmatched endpoint = %d", recorder.Code)
+	}
+
+	var onboard OnboardResponse
+	json.Unmarshal(serve(http.MethodPost, "/onboard?name=edge-1", "").Body.Bytes(), &onboard)
+	job := waitForJob(t, plugin.jobs, onboard.JobID, func(job Job) bool { return job.Finished() })
+	if job.State != JobFailed || !strings.Contains(job.Message, "hub token endpoint down") {
+		t.Errorf("onboarding with a step fault = %s %q", job.State, job.Message)
+	}
+
+	var report struct {
+		Stats FaultStats `json:"stats"`
+	}
+	json.Unmarshal(serve(http.MethodGet, "/debug/faults", "").Body.Bytes(), &report)
+	if report.Stats.FailedRequests != 1 || report.Stats.DelayedRequests != 1 || report.Stats.FailedSteps != 1 {
+		t.Errorf("stats = %+v", report.Stats)
+	}
+
+	if recorder := serve(http.MethodDelete, "/debug/faults", ""); recorder.Code != http.StatusOK {
+		t.Fatalf("clear faults = %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodGet, "/status", ""); recorder.Code != http.StatusOK {
+		t.Errorf("/status after clearing = %d", recorder.Code)
+	}
+}
+
+func TestFaultExpiry(t *testing.T) {
+	faults := newFaultInjector()
+	faults.set(FaultInjection{ErrorRate: 1, ErrorStatus: 500, DurationSeconds: 60})
+	if _, status := faults.requestFault("GetClusterStatusHandler"); status != 500 {
+		t.Fatalf("status = %d, want 500", status)
+	}
+	faults.expiresAt = time.Now().Add(-time.Second)
+	if _, status := faults.requestFault("GetClusterStatusHandler"); status != 0 {
+		t.Errorf("expired fault still injected %d", status)
+	}
+}
+
+func TestRunStepInjectedFault(t *testing.T) {
+	plugin := &ClusterPlugin{faults: newFaultInjector(), logs: NewLogHub(10)}
+	plugin.faults.set(FaultInjection{StepFailures: []StepFault{{Step: "custom", Rate: 1}}})
+	ran := false
+	step := pipelineStep{name: "custom", timeout: time.Second, retries: 1, run: func(*ClusterPlugin, context.Context, *onboardingRun) error {
+		ran = true
+		return nil
+	}}
+	err := plugin.runStep(context.Background(), &onboardingRun{clusterName: "edge-1"}, step)
+	if err == nil || ran || plugin.faults.stats.FailedSteps != 2 {
+		t.Errorf("runStep() = %v, ran %v, stats %+v", err, ran, plugin.faults.stats)
+	}
+}
+
+func TestFaultsDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router, err := newServerRouter(newTestPlugin(t), "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/plugins/kubestellar-cluster-plugin/v1/debug/faults", strings.NewReader(`{"errorRate": 1}`)))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("faults without faultInjection = %d", recorder.Code)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gitSyncTimeout bounds cloning or fetching a Git source
+const gitSyncTimeout = 2 * time.Minute
+
+// maxCommitSyncs is how many commits of a Git source the sync status keeps
+const maxCommitSyncs = 20
+
+// Commit sync states
+const (
+	commitSynced      = "Synced"
+	commitReconciling = "Reconciling"
+	commitFailed      = "Failed"
+)
+
+// GitSource is a Git repository holding the desired clusters as import
+// manifests or inventories. The branch is fetched on every reconcile pass, so
+// the intervalSeconds of the membership is its poll interval; a push webhook
+// sent to POST /reconcile/webhook syncs it right away.
+type GitSource struct {
+	URL string `json:"url"`
+	// Branch is main by default
+	Branch string `json:"branch,omitempty"`
+	// Path is a manifest in the repository, or a directory whose .csv,
+	// .yaml, .yml and .json files are all read. Hidden directories are
+	// skipped. It is the root of the repository by default.
+	Path string `json:"path,omitempty"`
+	// WebhookSecret, when set, must sign the push webhooks: the
+	// X-Hub-Signature-256 of GitHub and Gitea, or the X-Gitlab-Token of GitLab
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+}
+
+// Validate checks the source, refusing values git would read as options
+func (g GitSource) Validate() error {
+	if g.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if strings.HasPrefix(g.URL, "-") || strings.HasPrefix(g.Branch, "-") {
+		return fmt.Errorf("url and branch must not start with '-'")
+	}
+	if strings.ContainsAny(g.Branch, " ~^:?*[\\") || strings.Contains(g.Branch, "..") {
+		return fmt.Errorf("invalid branch %q", g.Branch)
+	}
+	if cleaned := path.Clean(g.Path); path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("path must stay inside the repository")
+	}
+	return nil
+}
+
+func (g GitSource) branch() string {
+	if g.Branch == "" {
+		return "main"
+	}
+	return g.Branch
+}
+
+// GitCommit identifies the commit a Git source was synced at
+type GitCommit struct {
+	SHA         string `json:"sha"`
+	Message     string `json:"message,omitempty"`
+	CommittedAt string `json:"committedAt,omitempty"`
+}
+
+// CommitSync is how reconciling the clusters of a commit went
+type CommitSync struct {
+	GitCommit
+	// State is Synced once a pass found no drift, Failed when the last pass
+	// couldn't complete and Reconciling while clusters are onboarded or
+	// detached to match the commit
+	State         string `json:"state"`
+	FirstSyncedAt string `json:"firstSyncedAt"`
+	LastSyncedAt  string `json:"lastSyncedAt"`
+	Passes        int    `json:"passes"`
+	// Drift and SourceErrors count those of the last pass
+	Drift        int    `json:"drift"`
+	SourceErrors int    `json:"sourceErrors"`
+	Error        string `json:"error,omitempty"`
+}
+
+// recordCommitSync adds a pass over a commit to the sync status. The caller
+// holds mr.mutex.
+func (mr *membershipReconciler) recordCommitSync(report ReconcileReport) {
+	state := commitReconciling
+	switch {
+	case report.Error != "":
+		state = commitFailed
+	case report.InSync:
+		state = commitSynced
+	}
+	if len(mr.commits) == 0 || mr.commits[0].SHA != report.Commit.SHA {
+		sync := CommitSync{GitCommit: *report.Commit, FirstSyncedAt: report.FinishedAt}
+		mr.commits = append([]CommitSync{sync}, mr.commits...)
+		if len(mr.commits) > maxCommitSyncs {
+			mr.commits = mr.commits[:maxCommitSyncs]
+		}
+	}
+	sync := &mr.commits[0]
+	sync.State = state
+	sync.LastSyncedAt = report.FinishedAt
+	sync.Passes++
+	sync.Drift = len(report.Drift)
+	sync.SourceErrors = len(report.SourceErrors)
+	sync.Error = report.Error
+}
+
+// runGit runs git in dir without ever prompting for credentials
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// syncGitCheckout brings the checkout of the source up to the tip of its
+// branch, cloning it the first time, and returns its directory and commit
+func (cp *ClusterPlugin) syncGitCheckout(ctx context.Context, source GitSource) (string, *GitCommit, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitSyncTimeout)
+	defer cancel()
+	if err := os.MkdirAll(cp.reconciler.gitDir, 0700); err != nil {
+		return "", nil, fmt.Errorf("failed to create Git checkout directory: %w", err)
+	}
+	key := sha256.Sum256([]byte(source.URL + "#" + source.branch()))
+	dir := filepath.Join(cp.reconciler.gitDir, hex.EncodeToString(key[:8]))
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		if _, err := runGit(ctx, dir, "fetch", "--quiet", "--depth", "1", "origin", source.branch()); err != nil {
+			return "", nil, err
+		}
+		if _, err := runGit(ctx, dir, "reset", "--quiet", "--hard", "FETCH_HEAD"); err != nil {
+			return "", nil, err
+		}
+	} else {
+		// A checkout left half done by an interrupted clone is started over
+		if err := os.RemoveAll(dir); err != nil {
+			return "", nil, fmt.Errorf("failed to clear Git checkout: %w", err)
+		}
+		if _, err := runGit(ctx, "", "clone", "--quiet", "--depth", "1", "--single-branch", "--branch", source.branch(), "--", source.URL, dir); err != nil {
+			return "", nil, err
+		}
+	}
+
+	output, err := runGit(ctx, dir, "log", "-1", "--format=%H%n%cI%n%s")
+	if err != nil {
+		return "", nil, err
+	}
+	fields := strings.SplitN(strings.TrimRight(string(output), "\n"), "\n", 3)
+	commit := &GitCommit{SHA: fields[0]}
+	if len(fields) == 3 {
+		commit.CommittedAt, commit.Message = fields[1], fields[2]
+	}
+	return dir, commit, nil
+}
+
+// readGitSource syncs the source and reads the clusters of the manifests
+// under its path. Manifest labels are applied to the clusters of their file;
+// files that can't be read are reported as rows in error.
+func (cp *ClusterPlugin) readGitSource(ctx context.Context, source GitSource) ([]importRow, *GitCommit, error) {
+	dir, commit, err := cp.syncGitCheckout(ctx, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	target := filepath.Join(dir, filepath.FromSlash(path.Clean(source.Path)))
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, commit, fmt.Errorf("path %q not found at commit %s", source.Path, commit.SHA)
+	}
+
+	var files []string
+	if info.IsDir() {
+		err = filepath.WalkDir(target, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() && file != target && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !entry.IsDir() && importFormat(entry.Name(), "") != "" {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, commit, fmt.Errorf("failed to list manifests: %w", err)
+		}
+	} else {
+		if importFormat(target, "") == "" {
+			return nil, commit, fmt.Errorf("path %q is not a CSV or YAML manifest", source.Path)
+		}
+		files = []string{target}
+	}
+
+	var rows []importRow
+	limit := cp.uploadLimit()
+	for _, file := range files {
+		name, _ := filepath.Rel(dir, file)
+		name = filepath.ToSlash(name)
+		data, err := os.ReadFile(file)
+		if err == nil && int64(len(data)) > limit {
+			err = fmt.Errorf("manifest exceeds the limit of %d bytes", limit)
+		}
+		var fileRows []importRow
+		var labels map[string]string
+		if err == nil {
+			fileRows, labels, err = parseImportManifest(data, importFormat(file, ""))
+		}
+		if err != nil {
+			rows = append(rows, importRow{file: name, err: err})
+			continue
+		}
+		for _, row := range fileRows {
+			row.file = name
+			if len(labels) > 0 {
+				merged := make(map[string]string, len(labels)+len(row.cluster.Labels))
+				for key, value := range labels {
+					merged[key] = value
+				}
+				for key, value := range row.cluster.Labels {
+					merged[key] = value
+				}
+				row.cluster.Labels = merged
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows, commit, nil
+}
+
+// redactMembership hides the webhook secret of a Git source
+func redactMembership(desired DesiredMembership) DesiredMembership {
+	if desired.Source != nil && desired.Source.Git != nil && desired.Source.Git.WebhookSecret != "" {
+		source := *desired.Source
+		git := *source.Git
+		git.WebhookSecret = "<redacted>"
+		source.Git = &git
+		desired.Source = &source
+	}
+	return desired
+}
+
+// validGitWebhook checks the signature or token a Git host sent the webhook with
+func validGitWebhook(header http.Header, body []byte, secret string) bool {
+	if signature := header.Get("X-Hub-Signature-256"); signature != "" {
+		return hmac.Equal([]byte(signature), []byte(signWebhookBody(secret, body)))
+	}
+	if token := header.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+	return false
+}
+
+// GitWebhookHandler syncs the Git source right away when its branch is
+// pushed to. Pushes to other branches are ignored.
+func (cp *ClusterPlugin) GitWebhookHandler(c *gin.Context) {
+	mr := cp.reconciler
+	mr.mutex.Lock()
+	var source *GitSource
+	if mr.desired != nil && mr.desired.Source != nil && mr.desired.Source.Git != nil {
+		git := *mr.desired.Source.Git
+		source = &git
+	}
+	mr.mutex.Unlock()
+	if source == nil {
+		respondProblem(c, http.StatusConflict, CodeConflict, "No Git source is being reconciled")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, cp.uploadLimit()))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondProblem(c, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Webhook payload is too large")
+		return
+	}
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Failed to read webhook payload")
+		return
+	}
+	if source.WebhookSecret != "" && !validGitWebhook(c.Request.Header, body, source.WebhookSecret) {
+		respondProblem(c, http.StatusUnauthorized, CodeUnauthenticated, "Webhook signature does not match the source's webhookSecret")
+		return
+	}
+	// Pings and hosts that don't say which ref moved sync as well
+	var push struct {
+		Ref string `json:"ref"`
+	}
+	_ = json.Unmarshal(body, &push)
+	if push.Ref != "" && push.Ref != "refs/heads/"+source.branch() {
+		c.JSON(http.StatusOK, gin.H{
+			"message":     message(c, MsgGitPushIgnored, "ref", push.Ref, "branch", source.branch()),
+			"messageCode": MsgGitPushIgnored,
+			"plugin":      "kubestellar-cluster-plugin",
+			"timestamp":   time.Now().Format(time.RFC3339),
+		})
+		return
+	}
+
+	// Restarting the loop waits for a running pass and then syncs right away
+	mr.stop()
+	mr.start(cp.reconcileMembership)
+	requestLogger(c).Info("Git source sync triggered by webhook", "url", source.URL, "branch", source.branch())
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     message(c, MsgGitSyncStarted),
+		"messageCode": MsgGitSyncStarted,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newGitRepo creates a repository on branch main with the given files
+// committed, returning its path and a function committing more changes
+func newGitRepo(t *testing.T, files map[string]string) (string, func(message string, files map[string]string)) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, output)
+		}
+	}
+	commit := func(message string, files map[string]string) {
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		git("add", "-A")
+		git("commit", "--quiet", "-m", message)
+	}
+	git("init", "--quiet", "--initial-branch", "main")
+	commit("Initial fleet", files)
+	return dir, commit
+}
+
+func TestGitSourceValidate(t *testing.T) {
+	if err := (GitSource{URL: "https://example.com/fleet.git", Branch: "release/1.0", Path: "clusters/prod"}).Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	for name, source := range map[string]GitSource{
+		"no url":        {},
+		"option url":    {URL: "--upload-pack=touch /tmp/x"},
+		"option branch": {URL: "https://example.com/fleet.git", Branch: "-b"},
+		"bad branch":    {URL: "https://example.com/fleet.git", Branch: "a..b"},
+		"escaping path": {URL: "https://example.com/fleet.git", Path: "../../etc"},
+		"absolute path": {URL: "https://example.com/fleet.git", Path: "/etc"},
+	} {
+		if err := source.Validate(); err == nil {
+			t.Errorf("%s: source accepted", name)
+		}
+	}
+}
+
+func TestReadGitSource(t *testing.T) {
+	repo, commit := newGitRepo(t, map[string]string{
+		"clusters/prod.yaml":             "labels:\n  env: prod\nclusters:\n  - name: prod-1\n  - name: prod-2\n    labels:\n      env: canary\n",
+		"clusters/edge.csv":              "name,labels\nedge-1,tier=edge\n",
+		"clusters/.github/ci.yaml":       "on: push\n",
+		"clusters/notes/README.md":       "not a manifest\n",
+		"clusters/broken/fleet.yml":      "clusters: [\n",
+		"other/ignored-outside-path.csv": "name\nother-1\n",
+	})
+	plugin := newTestPlugin(t)
+	source := GitSource{URL: repo, Path: "clusters"}
+
+	rows, first, err := plugin.readGitSource(context.Background(), source)
+	if err != nil {
+		t.Fatalf("readGitSource() error = %v", err)
+	}
+	if len(first.SHA) != 40 || first.Message != "Initial fleet" {
+		t.Errorf("commit = %+v", first)
+	}
+	found := map[string]importRow{}
+	var failed []string
+	for _, row := range rows {
+		if row.err != nil {
+			failed = append(failed, row.file)
+			continue
+		}
+		found[row.cluster.Name] = row
+	}
+	if len(found) != 3 || found["prod-1"].cluster.Labels["env"] != "prod" || found["prod-2"].cluster.Labels["env"] != "canary" || found["edge-1"].file != "clusters/edge.csv" {
+		t.Errorf("clusters = %+v", found)
+	}
+	if strings.Join(failed, ",") != "clusters/broken/fleet.yml" {
+		t.Errorf("unreadable files = %v", failed)
+	}
+
+	commit("Add prod-3", map[string]string{"clusters/prod.yaml": "labels:\n  env: prod\nclusters:\n  - name: prod-3\n"})
+	rows, second, err := plugin.readGitSource(context.Background(), source)
+	if err != nil {
+		t.Fatalf("readGitSource() after a push error = %v", err)
+	}
+	if second.SHA == first.SHA || second.Message != "Add prod-3" {
+		t.Errorf("commit after a push = %+v", second)
+	}
+	names := map[string]bool{}
+	for _, row := range rows {
+		names[row.cluster.Name] = true
+	}
+	if !names["prod-3"] || names["prod-1"] {
+		t.Errorf("clusters after a push = %v", names)
+	}
+
+	if _, _, err := plugin.readGitSource(context.Background(), GitSource{URL: repo, Path: "missing"}); err == nil {
+		t.Error("missing path accepted")
+	}
+	if _, _, err := plugin.readGitSource(context.Background(), GitSource{URL: repo, Branch: "nosuch"}); err == nil {
+		t.Error("missing branch accepted")
+	}
+}
+
+func TestReconcileFromGitSource(t *testing.T) {
+	repo, commit := newGitRepo(t, map[string]string{"fleet.csv": "name\nedge-1\n"})
+	plugin, serve := reconcileRouter(t)
+
+	recorder := serve(http.MethodPost, "/reconcile", `{"source": {"git": {"url": "`+repo+`", "path": "fleet.csv", "webhookSecret": "s3cret"}}, "intervalSeconds": 3600}`)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("POST /reconcile = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), "s3cret") {
+		t.Error("the webhook secret is echoed")
+	}
+	report := waitForReconcile(t, serve, func(report ReconcileReport) bool { return report.FinishedAt != "" })
+	if report.Commit == nil || report.Desired != 1 || len(report.Drift) != 1 {
+		t.Fatalf("first pass = %+v", report)
+	}
+	firstSHA := report.Commit.SHA
+	waitForJob(t, plugin.jobs, report.Drift[0].JobID, func(job Job) bool { return job.Finished() })
+
+	commit("Add edge-2", map[string]string{"fleet.csv": "name\nedge-1\nedge-2\n"})
+	webhook := func(body string, header map[string]string) int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/plugins/kubestellar-cluster-plugin/v1/reconcile/webhook", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		for key, value := range header {
+			request.Header.Set(key, value)
+		}
+		router, _ := newServerRouter(plugin, "")
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	push := `{"ref": "refs/heads/main"}`
+	if code := webhook(push, map[string]string{"X-Hub-Signature-256": signWebhookBody("wrong", []byte(push))}); code != http.StatusUnauthorized {
+		t.Errorf("badly signed webhook = %d", code)
+	}
+	other := `{"ref": "refs/heads/feature"}`
+	if code := webhook(other, map[string]string{"X-Gitlab-Token": "s3cret"}); code != http.StatusOK {
+		t.Errorf("push to another branch = %d", code)
+	}
+	if code := webhook(push, map[string]string{"X-Hub-Signature-256": signWebhookBody("s3cret", []byte(push))}); code != http.StatusAccepted {
+		t.Fatalf("signed webhook = %d", code)
+	}
+
+	report = waitForReconcile(t, serve, func(report ReconcileReport) bool {
+		return report.Commit != nil && report.Commit.SHA != firstSHA
+	})
+	if report.Desired != 2 || len(report.Drift) != 1 || report.Drift[0].ClusterName != "edge-2" {
+		t.Fatalf("pass after the push = %+v", report)
+	}
+	waitForJob(t, plugin.jobs, report.Drift[0].JobID, func(job Job) bool { return job.Finished() })
+
+	// A ping names no ref and syncs again, finding the commit in sync
+	if code := webhook(`{}`, map[string]string{"X-Gitlab-Token": "s3cret"}); code != http.StatusAccepted {
+		t.Fatalf("ping = %d", code)
+	}
+	waitForReconcile(t, serve, func(report ReconcileReport) bool { return report.InSync })
+	var status struct {
+		Commits []CommitSync `json:"commits"`
+	}
+	json.Unmarshal(serve(http.MethodGet, "/reconcile/status", "").Body.Bytes(), &status)
+	if len(status.Commits) != 2 || status.Commits[0].Message != "Add edge-2" || status.Commits[0].State != commitSynced || status.Commits[0].Passes != 2 || status.Commits[1].SHA != firstSHA {
+		t.Errorf("commits = %+v", status.Commits)
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// defaultEnvironments are the environments groups can belong to unless the
+// environments config lists others
+var defaultEnvironments = []string{"dev", "stage", "prod"}
+
+var (
+	errGroupNotFound = errors.New("group not found")
+	errGroupExists   = errors.New("group already exists")
+)
+
+// ClusterGroup is a named set of clusters that status summaries and batch
+// operations can target. Its members are the clusters it names and those
+// whose labels match its selector.
+type ClusterGroup struct {
+	Name string `json:"name"`
+	// Environment is one of the configured environments, such as dev, stage or prod
+	Environment string `json:"environment,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Selector adds every cluster whose labels it matches
+	Selector string `json:"selector,omitempty"`
+	// Clusters adds clusters by name
+	Clusters  []string `json:"clusters,omitempty"`
+	CreatedAt string   `json:"createdAt"`
+	UpdatedAt string   `json:"updatedAt"`
+}
+
+// GroupRequest is the JSON body accepted by POST /groups and PUT /groups/:group
+type GroupRequest struct {
+	// Name is required on creation and must match the path on update
+	Name        string   `json:"name,omitempty"`
+	Environment string   `json:"environment,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Selector    string   `json:"selector,omitempty"`
+	Clusters    []string `json:"clusters,omitempty"`
+}
+
+// Validate checks the request against the allowed environments
+func (r GroupRequest) Validate(environments []string) error {
+	if errs := validation.IsDNS1123Label(r.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid group name '%s': %s", r.Name, strings.Join(errs, "; "))
+	}
+	if r.Environment != "" && !containsString(environments, r.Environment) {
+		return fmt.Errorf("invalid environment %q, must be one of %s", r.Environment, strings.Join(environments, ", "))
+	}
+	if r.Selector == "" && len(r.Clusters) == 0 {
+		return fmt.Errorf("a group needs a selector or clusters")
+	}
+	if r.Selector != "" {
+		if _, err := k8slabels.Parse(r.Selector); err != nil {
+			return fmt.Errorf("invalid selector: %w", err)
+		}
+	}
+	for _, name := range r.Clusters {
+		if err := validateClusterName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containsString reports whether values holds value
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether cluster is a member of the group
+func (g ClusterGroup) matches(cluster ClusterStatus) bool {
+	if containsString(g.Clusters, cluster.ClusterName) {
+		return true
+	}
+	if g.Selector == "" {
+		return false
+	}
+	selector, err := k8slabels.Parse(g.Selector)
+	return err == nil && selector.Matches(k8slabels.Set(clusterLabelSet(cluster)))
+}
+
+// GroupManager holds the cluster groups, persisted to a JSON file
+type GroupManager struct {
+	groups map[string]ClusterGroup
+	mutex  sync.RWMutex
+	// path is the file groups are persisted to, "" to keep them in memory
+	path string
+}
+
+// NewGroupManager creates a group manager, restoring the groups persisted
+// at path if any
+func NewGroupManager(path string) (*GroupManager, error) {
+	gm := &GroupManager{groups: make(map[string]ClusterGroup), path: path}
+	if path == "" {
+		return gm, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return gm, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups file: %w", err)
+	}
+	var groups []ClusterGroup
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("failed to decode groups file: %w", err)
+	}
+	for _, group := range groups {
+		gm.groups[group.Name] = group
+	}
+	return gm, nil
+}
+
+// Create adds a group
+func (gm *GroupManager) Create(req GroupRequest) (ClusterGroup, error) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	if _, exists := gm.groups[req.Name]; exists {
+		return ClusterGroup{}, fmt.Errorf("%w: %s", errGroupExists, req.Name)
+	}
+	now := time.Now().Format(time.RFC3339)
+	group := ClusterGroup{
+		Name:        req.Name,
+		Environment: req.Environment,
+		Description: req.Description,
+		Selector:    req.Selector,
+		Clusters:    req.Clusters,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	gm.groups[group.Name] = group
+	if err := gm.save(); err != nil {
+		delete(gm.groups, group.Name)
+		return ClusterGroup{}, err
+	}
+	return group, nil
+}
+
+// Update replaces the definition of a group
+func (gm *GroupManager) Update(req GroupRequest) (ClusterGroup, error) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	previous, exists := gm.groups[req.Name]
+	if !exists {
+		return ClusterGroup{}, fmt.Errorf("%w: %s", errGroupNotFound, req.Name)
+	}
+	group := ClusterGroup{
+		Name:        req.Name,
+		Environment: req.Environment,
+		Description: req.Description,
+		Selector:    req.Selector,
+		Clusters:    req.Clusters,
+		CreatedAt:   previous.CreatedAt,
+		UpdatedAt:   time.Now().Format(time.RFC3339),
+	}
+	gm.groups[group.Name] = group
+	if err := gm.save(); err != nil {
+		gm.groups[group.Name] = previous
+		return ClusterGroup{}, err
+	}
+	return group, nil
+}
+
+// Delete removes a group; its clusters are left alone
+func (gm *GroupManager) Delete(name string) error {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	previous, exists := gm.groups[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", errGroupNotFound, name)
+	}
+	delete(gm.groups, name)
+	if err := gm.save(); err != nil {
+		gm.groups[name] = previous
+		return err
+	}
+	return nil
+}
+
+// Get returns a single group
+func (gm *GroupManager) Get(name string) (ClusterGroup, error) {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+	group, exists := gm.groups[name]
+	if !exists {
+		return ClusterGroup{}, fmt.Errorf("%w: %s", errGroupNotFound, name)
+	}
+	return group, nil
+}
+
+// List returns every group sorted by name
+func (gm *GroupManager) List() []ClusterGroup {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+	groups := make([]ClusterGroup, 0, len(gm.groups))
+	for _, group := range gm.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// save writes every group to the groups file. The caller must hold the mutex.
+func (gm *GroupManager) save() error {
+	if gm.path == "" {
+		return nil
+	}
+	groups := make([]ClusterGroup, 0, len(gm.groups))
+	for _, group := range gm.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode groups: %w", err)
+	}
+	tmpPath := gm.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write groups file: %w", err)
+	}
+	return os.Rename(tmpPath, gm.path)
+}
+
+// groupMembers returns the recorded clusters of the tenant of ctx belonging
+// to the named group
+func (cp *ClusterPlugin) groupMembers(ctx context.Context, name string) (ClusterGroup, []ClusterStatus, error) {
+	group, err := cp.groups.Get(name)
+	if err != nil {
+		return ClusterGroup{}, nil, err
+	}
+	clusters, err := cp.selectClusters("")
+	if err != nil {
+		return group, nil, err
+	}
+	members := []ClusterStatus{}
+	for _, cluster := range tenantClusters(ctx, clusters) {
+		if group.matches(cluster) {
+			members = append(members, cluster)
+		}
+	}
+	return group, members, nil
+}
+
+// GroupSummary is a group with the status counts of its members
+type GroupSummary struct {
+	ClusterGroup
+	Summary map[string]int `json:"summary"`
+}
+
+// ListGroupsHandler returns every group with a status summary of its
+// members, optionally filtered by ?environment
+func (cp *ClusterPlugin) ListGroupsHandler(c *gin.Context) {
+	environment := c.Query("environment")
+	clusters, err := cp.selectClusters("")
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	clusters = tenantClusters(c.Request.Context(), clusters)
+
+	groups := []GroupSummary{}
+	for _, group := range cp.groups.List() {
+		if environment != "" && group.Environment != environment {
+			continue
+		}
+		var members []ClusterStatus
+		for _, cluster := range clusters {
+			if group.matches(cluster) {
+				members = append(members, cluster)
+			}
+		}
+		groups = append(groups, GroupSummary{ClusterGroup: group, Summary: cp.statusSummary(members)})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"groups":       groups,
+		"total":        len(groups),
+		"environments": cp.environments,
+		"plugin":       "kubestellar-cluster-plugin",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	})
+}
+
+// GetGroupHandler returns a group with its member clusters and their status summary
+func (cp *ClusterPlugin) GetGroupHandler(c *gin.Context) {
+	group, members, err := cp.groupMembers(c.Request.Context(), c.Param("group"))
+	if errors.Is(err, errGroupNotFound) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Group '%s' not found", c.Param("group")))
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"group":     group,
+		"clusters":  members,
+		"summary":   cp.statusSummary(members),
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// CreateGroupHandler defines a new group
+func (cp *ClusterPlugin) CreateGroupHandler(c *gin.Context) {
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	if err := req.Validate(cp.environments); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	group, err := cp.groups.Create(req)
+	if errors.Is(err, errGroupExists) {
+		respondProblem(c, http.StatusConflict, CodeConflict, fmt.Sprintf("Group '%s' already exists", req.Name))
+		return
+	}
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	logger().Info("Cluster group created", "group", group.Name, "environment", group.Environment)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     message(c, MsgGroupCreated, "group", group.Name),
+		"messageCode": MsgGroupCreated,
+		"group":       group,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// UpdateGroupHandler replaces the definition of a group
+func (cp *ClusterPlugin) UpdateGroupHandler(c *gin.Context) {
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	name := c.Param("group")
+	if req.Name != "" && req.Name != name {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Groups can't be renamed")
+		return
+	}
+	req.Name = name
+	if err := req.Validate(cp.environments); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	group, err := cp.groups.Update(req)
+	if errors.Is(err, errGroupNotFound) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Group '%s' not found", name))
+		return
+	}
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     message(c, MsgGroupUpdated, "group", group.Name),
+		"messageCode": MsgGroupUpdated,
+		"group":       group,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// DeleteGroupHandler removes a group without touching its clusters
+func (cp *ClusterPlugin) DeleteGroupHandler(c *gin.Context) {
+	name := c.Param("group")
+	err := cp.groups.Delete(name)
+	if errors.Is(err, errGroupNotFound) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Group '%s' not found", name))
+		return
+	}
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	logger().Info("Cluster group deleted", "group", name)
+	c.JSON(http.StatusOK, gin.H{
+		"message":     message(c, MsgGroupDeleted, "group", name),
+		"messageCode": MsgGroupDeleted,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
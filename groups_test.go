@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGroupHandlers(t *testing.T) {
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"detachSafety": detachSafetyOff})
+	plugin.putStatus(ClusterStatus{ClusterName: "ci-1", Status: "Ready", Labels: map[string]string{"purpose": "ephemeral-ci"}})
+	plugin.putStatus(ClusterStatus{ClusterName: "ci-2", Status: "Failed", Labels: map[string]string{"purpose": "ephemeral-ci"}})
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	plugin.putStatus(ClusterStatus{ClusterName: "prod-1", Status: "Ready"})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/groups", plugin.ListGroupsHandler)
+	router.POST("/groups", plugin.CreateGroupHandler)
+	router.GET("/groups/:group", plugin.GetGroupHandler)
+	router.PUT("/groups/:group", plugin.UpdateGroupHandler)
+	router.DELETE("/groups/:group", plugin.DeleteGroupHandler)
+	router.POST("/detach", plugin.DetachClusterHandler)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// In order: the second prod group conflicts with the first
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		{`{"name": "ephemeral-ci", "environment": "dev", "selector": "purpose=ephemeral-ci", "clusters": ["edge-1"]}`, http.StatusCreated},
+		{`{"name": "prod", "environment": "prod", "clusters": ["prod-1"]}`, http.StatusCreated},
+		{`{"name": "prod", "clusters": ["prod-1"]}`, http.StatusConflict},
+		{`{"name": "qa", "environment": "qa", "clusters": ["prod-1"]}`, http.StatusBadRequest},
+		{`{"name": "empty"}`, http.StatusBadRequest},
+		{`{"name": "bad", "selector": "purpose in"}`, http.StatusBadRequest},
+	} {
+		if recorder := serve(http.MethodPost, "/groups", tc.body); recorder.Code != tc.want {
+			t.Errorf("create %s = %d %s, want %d", tc.body, recorder.Code, recorder.Body.String(), tc.want)
+		}
+	}
+
+	recorder := serve(http.MethodGet, "/groups/ephemeral-ci", "")
+	var detail struct {
+		Clusters []ClusterStatus `json:"clusters"`
+		Summary  map[string]int  `json:"summary"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &detail)
+	if len(detail.Clusters) != 3 || detail.Summary["total"] != 3 || detail.Summary["ready"] != 2 || detail.Summary["failed"] != 1 {
+		t.Errorf("group detail = %s", recorder.Body.String())
+	}
+
+	recorder = serve(http.MethodGet, "/groups?environment=prod", "")
+	var listed struct {
+		Groups []GroupSummary `json:"groups"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &listed)
+	if len(listed.Groups) != 1 || listed.Groups[0].Name != "prod" || listed.Groups[0].Summary["total"] != 1 {
+		t.Errorf("prod groups = %s", recorder.Body.String())
+	}
+
+	// Dropping edge-1 leaves the selected clusters
+	if recorder := serve(http.MethodPut, "/groups/ephemeral-ci", `{"environment": "dev", "selector": "purpose=ephemeral-ci"}`); recorder.Code != http.StatusOK {
+		t.Fatalf("update = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(http.MethodPut, "/groups/ephemeral-ci", `{"name": "renamed", "selector": "purpose=ephemeral-ci"}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("rename = %d", recorder.Code)
+	}
+
+	recorder = serve(http.MethodPost, "/detach", `{"group": "ephemeral-ci", "dryRun": true}`)
+	var detached struct {
+		Items []BatchItem `json:"items"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &detached)
+	if recorder.Code != http.StatusOK || len(detached.Items) != 2 || detached.Items[0].ClusterName != "ci-1" || detached.Items[1].ClusterName != "ci-2" {
+		t.Errorf("group detach dry run = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(http.MethodPost, "/detach", `{"group": "missing"}`); recorder.Code != http.StatusNotFound {
+		t.Errorf("detach of a missing group = %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodPost, "/detach", `{"group": "prod", "labelSelector": "env=prod"}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("detach by group and selector = %d", recorder.Code)
+	}
+
+	if recorder := serve(http.MethodDelete, "/groups/prod", ""); recorder.Code != http.StatusOK {
+		t.Errorf("delete = %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodGet, "/groups/prod", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("deleted group = %d", recorder.Code)
+	}
+	if _, exists, _ := plugin.store.Get("prod-1"); !exists {
+		t.Error("deleting the group removed its cluster")
+	}
+}
+
+func TestGroupManagerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "groups.json")
+	groups, err := NewGroupManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := groups.Create(GroupRequest{Name: "edge", Environment: "stage", Selector: "tier=edge"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewGroupManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := reloaded.Get("edge")
+	if err != nil || group.Environment != "stage" || group.Selector != "tier=edge" || group.CreatedAt == "" {
+		t.Errorf("reloaded group = %+v, %v", group, err)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// The host launches the plugin binary with the magic cookie set and reads the
+// handshake line the plugin prints on stdout, following the
+// hashicorp/go-plugin protocol: core version|app version|network|address|protocol
+const (
+	grpcMagicCookieKey   = "KUBESTELLAR_PLUGIN_MAGIC_COOKIE"
+	grpcMagicCookieValue = "d5f0a8c4-kubestellar-cluster-plugin"
+	grpcProtocolVersion  = 1
+	grpcServiceName      = "kubestellar.plugin.v1.Plugin"
+)
+
+// jsonCodec lets the plugin service exchange plain Go structs as JSON, so the
+// transport needs no generated protobuf code. Clients select it with the
+// "json" content subtype; the standard health service keeps using protobuf.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// GRPCInitializeRequest carries the Initialize config
+type GRPCInitializeRequest struct {
+	Config map[string]interface{} `json:"config"`
+}
+
+// GRPCEmpty is the request or response of calls without a payload
+type GRPCEmpty struct{}
+
+// GRPCHealthResponse reports the result of Health; Error is empty when healthy
+type GRPCHealthResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// GRPCState carries the state exported by ExportState
+type GRPCState struct {
+	Data []byte `json:"data"`
+}
+
+// GRPCHandleRequest is an HTTP request for one of the metadata endpoints.
+// Path is relative to the plugin mount point, e.g. /status.
+type GRPCHandleRequest struct {
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	RawQuery   string      `json:"rawQuery,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+	RemoteAddr string      `json:"remoteAddr,omitempty"`
+}
+
+// GRPCHandleResponse is one message of a streamed HTTP response: the first
+// carries the status code and headers, every message may carry body bytes
+type GRPCHandleResponse struct {
+	StatusCode int         `json:"statusCode,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+// grpcPluginServer exposes a ClusterPlugin over gRPC
+type grpcPluginServer struct {
+	plugin *ClusterPlugin
+	mutex  sync.RWMutex
+	router *gin.Engine
+}
+
+func newGRPCPluginServer(plugin *ClusterPlugin) (*grpcPluginServer, error) {
+	server := &grpcPluginServer{plugin: plugin}
+	if err := server.buildRouter(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// buildRouter mounts the endpoints of the current metadata at the root, which
+// changes when Initialize loads an external plugin.yaml
+func (s *grpcPluginServer) buildRouter() error {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	if err := mountPlugin(router, s.plugin); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.router = router
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *grpcPluginServer) Initialize(_ context.Context, req *GRPCInitializeRequest) (*GRPCEmpty, error) {
+	config := req.Config
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	if err := s.plugin.Initialize(config); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err := s.buildRouter(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &GRPCEmpty{}, nil
+}
+
+func (s *grpcPluginServer) GetMetadata(context.Context, *GRPCEmpty) (*PluginMetadata, error) {
+	metadata := s.plugin.GetMetadata()
+	return &metadata, nil
+}
+
+// Negotiate reports an incompatible host in the result rather than as an
+// error, so that the host learns which constraint it failed
+func (s *grpcPluginServer) Negotiate(_ context.Context, req *HostInfo) (*NegotiationResult, error) {
+	result, _ := s.plugin.Negotiate(*req)
+	return &result, nil
+}
+
+func (s *grpcPluginServer) Health(context.Context, *GRPCEmpty) (*GRPCHealthResponse, error) {
+	if err := s.plugin.Health(); err != nil {
+		return &GRPCHealthResponse{Error: err.Error()}, nil
+	}
+	return &GRPCHealthResponse{}, nil
+}
+
+func (s *grpcPluginServer) ExportState(context.Context, *GRPCEmpty) (*GRPCState, error) {
+	data, err := s.plugin.ExportState()
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &GRPCState{Data: data}, nil
+}
+
+func (s *grpcPluginServer) ImportState(_ context.Context, req *GRPCState) (*GRPCEmpty, error) {
+	if err := s.plugin.ImportState(req.Data); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &GRPCEmpty{}, nil
+}
+
+func (s *grpcPluginServer) Cleanup(context.Context, *GRPCEmpty) (*GRPCEmpty, error) {
+	if err := s.plugin.Cleanup(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &GRPCEmpty{}, nil
+}
+
+// Handle dispatches an HTTP request to the endpoint handler and streams the
+// response back, so Server-Sent Events work across the process boundary
+func (s *grpcPluginServer) Handle(req *GRPCHandleRequest, stream grpc.ServerStream) error {
+	if req.Path == "" || req.Path[0] != '/' {
+		return status.Errorf(codes.InvalidArgument, "path %q must start with '/'", req.Path)
+	}
+	url := req.Path
+	if req.RawQuery != "" {
+		url += "?" + req.RawQuery
+	}
+	request, err := http.NewRequestWithContext(stream.Context(), req.Method, url, bytes.NewReader(req.Body))
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.Header != nil {
+		request.Header = req.Header
+	}
+	request.RemoteAddr = req.RemoteAddr
+
+	s.mutex.RLock()
+	router := s.router
+	s.mutex.RUnlock()
+
+	writer := newGRPCResponseWriter(stream)
+	router.ServeHTTP(writer, request)
+	return writer.finish()
+}
+
+// grpcResponseWriter turns what a handler writes into GRPCHandleResponse
+// messages; every Flush sends the bytes written so far
+type grpcResponseWriter struct {
+	stream      grpc.ServerStream
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	sentHeader  bool
+	body        bytes.Buffer
+	err         error
+}
+
+func newGRPCResponseWriter(stream grpc.ServerStream) *grpcResponseWriter {
+	return &grpcResponseWriter{stream: stream, header: http.Header{}, statusCode: http.StatusOK}
+}
+
+func (w *grpcResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.statusCode = statusCode
+	w.wroteHeader = true
+}
+
+func (w *grpcResponseWriter) Write(data []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+func (w *grpcResponseWriter) Flush() {
+	if w.err != nil || (w.sentHeader && w.body.Len() == 0) {
+		return
+	}
+	message := &GRPCHandleResponse{Body: w.body.Bytes()}
+	if !w.sentHeader {
+		message.StatusCode = w.statusCode
+		message.Header = w.header.Clone()
+		w.sentHeader = true
+	}
+	w.err = w.stream.SendMsg(message)
+	w.body.Reset()
+}
+
+// CloseNotify is used by gin's Context.Stream to notice the client going away
+func (w *grpcResponseWriter) CloseNotify() <-chan bool {
+	closed := make(chan bool, 1)
+	go func() {
+		<-w.stream.Context().Done()
+		closed <- true
+	}()
+	return closed
+}
+
+// Hijack is not possible over gRPC, so WebSocket endpoints are unavailable
+func (w *grpcResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("connection hijacking is not supported over the gRPC transport")
+}
+
+func (w *grpcResponseWriter) finish() error {
+	w.Flush()
+	return w.err
+}
+
+// grpcPluginService is the hand-written equivalent of a generated service
+// descriptor for the plugin service
+type grpcPluginService interface {
+	Initialize(context.Context, *GRPCInitializeRequest) (*GRPCEmpty, error)
+	GetMetadata(context.Context, *GRPCEmpty) (*PluginMetadata, error)
+	Negotiate(context.Context, *HostInfo) (*NegotiationResult, error)
+	Health(context.Context, *GRPCEmpty) (*GRPCHealthResponse, error)
+	ExportState(context.Context, *GRPCEmpty) (*GRPCState, error)
+	ImportState(context.Context, *GRPCState) (*GRPCEmpty, error)
+	Cleanup(context.Context, *GRPCEmpty) (*GRPCEmpty, error)
+	Handle(*GRPCHandleRequest, grpc.ServerStream) error
+}
+
+// unaryMethod adapts a typed unary call to the generic gRPC method handler
+func unaryMethod[Req any, Resp any](name string, call func(grpcPluginService, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(grpcPluginService), ctx, req.(*Req))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + grpcServiceName + "/" + name}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+var grpcPluginServiceDesc = grpc.ServiceDesc{
+	ServiceName: grpcServiceName,
+	HandlerType: (*grpcPluginService)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("Initialize", grpcPluginService.Initialize),
+		unaryMethod("GetMetadata", grpcPluginService.GetMetadata),
+		unaryMethod("Negotiate", grpcPluginService.Negotiate),
+		unaryMethod("Health", grpcPluginService.Health),
+		unaryMethod("ExportState", grpcPluginService.ExportState),
+		unaryMethod("ImportState", grpcPluginService.ImportState),
+		unaryMethod("Cleanup", grpcPluginService.Cleanup),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Handle",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := new(GRPCHandleRequest)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(grpcPluginService).Handle(req, stream)
+			},
+		},
+	},
+}
+
+// newGRPCServer registers the plugin service and the standard health service,
+// reporting SERVING for "plugin" as go-plugin hosts expect
+func newGRPCServer(plugin *ClusterPlugin) (*grpc.Server, error) {
+	service, err := newGRPCPluginServer(plugin)
+	if err != nil {
+		return nil, err
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&grpcPluginServiceDesc, service)
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("plugin", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	return server, nil
+}
+
+// runGRPCServer serves the plugin out of process until the host stops it or
+// a signal arrives. The host drives the lifecycle through Initialize and
+// Cleanup; Cleanup also runs on exit in case the host never called it.
+func runGRPCServer(addr string) error {
+	plugin := &ClusterPlugin{}
+	server, err := newGRPCServer(plugin)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	defer func() {
+		if err := plugin.Cleanup(); err != nil {
+			logger().Error("Plugin cleanup failed", "error", err)
+		}
+	}()
+
+	// Handshake for the host; nothing else may be written to stdout before it
+	fmt.Printf("1|%d|tcp|%s|grpc\n", grpcProtocolVersion, listener.Addr().String())
+	os.Stdout.Sync()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		if sig, ok := <-signals; ok {
+			logger().Info("Stopping gRPC server", "signal", sig.String())
+			server.GracefulStop()
+		}
+	}()
+
+	logger().Info("Serving plugin over gRPC", "addr", listener.Addr().String())
+	return server.Serve(listener)
+}
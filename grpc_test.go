@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGRPCClient(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	server, err := newGRPCServer(&ClusterPlugin{})
+	if err != nil {
+		t.Fatalf("newGRPCServer() error = %v", err)
+	}
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCUnaryCalls(t *testing.T) {
+	conn := newTestGRPCClient(t)
+	ctx := context.Background()
+	json := grpc.CallContentSubtype("json")
+
+	var metadata PluginMetadata
+	if err := conn.Invoke(ctx, "/"+grpcServiceName+"/GetMetadata", &GRPCEmpty{}, &metadata, json); err != nil {
+		t.Fatalf("GetMetadata error = %v", err)
+	}
+	if metadata.ID != "kubestellar-cluster-plugin" {
+		t.Errorf("metadata ID = %q", metadata.ID)
+	}
+
+	var health GRPCHealthResponse
+	if err := conn.Invoke(ctx, "/"+grpcServiceName+"/Health", &GRPCEmpty{}, &health, json); err != nil {
+		t.Fatalf("Health error = %v", err)
+	}
+	if health.Error != errNotInitialized.Error() {
+		t.Errorf("Health error = %q, want %q", health.Error, errNotInitialized)
+	}
+
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: "plugin"})
+	if err != nil || response.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("grpc health = %v, %v, want SERVING", response, err)
+	}
+}
+
+func TestGRPCHandle(t *testing.T) {
+	conn := newTestGRPCClient(t)
+
+	tests := []struct {
+		name       string
+		request    GRPCHandleRequest
+		wantCode   codes.Code
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "openapi", request: GRPCHandleRequest{Method: http.MethodGet, Path: "/openapi.json"}, wantStatus: http.StatusOK, wantBody: `"openapi"`},
+		{name: "unknown route", request: GRPCHandleRequest{Method: http.MethodGet, Path: "/nope"}, wantStatus: http.StatusNotFound},
+		{name: "relative path", request: GRPCHandleRequest{Method: http.MethodGet, Path: "status"}, wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desc := &grpc.StreamDesc{StreamName: "Handle", ServerStreams: true}
+			stream, err := conn.NewStream(context.Background(), desc, "/"+grpcServiceName+"/Handle", grpc.CallContentSubtype("json"))
+			if err != nil {
+				t.Fatalf("NewStream() error = %v", err)
+			}
+			if err := stream.SendMsg(&tt.request); err != nil {
+				t.Fatalf("SendMsg() error = %v", err)
+			}
+			stream.CloseSend()
+
+			var statusCode int
+			var body strings.Builder
+			for {
+				var message GRPCHandleResponse
+				if err := stream.RecvMsg(&message); err != nil {
+					if errors.Is(err, io.EOF) {
+						err = nil
+					}
+					if status.Code(err) != tt.wantCode {
+						t.Fatalf("RecvMsg() error = %v, want code %v", err, tt.wantCode)
+					}
+					break
+				}
+				if message.StatusCode != 0 {
+					statusCode = message.StatusCode
+				}
+				body.Write(message.Body)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+			if statusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", statusCode, tt.wantStatus)
+			}
+			if !strings.Contains(body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", body.String(), tt.wantBody)
+			}
+		})
+	}
+}
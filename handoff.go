@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// stateFormatVersion is bumped whenever PluginState changes incompatibly
+const stateFormatVersion = 1
+
+// PluginState is what a plugin instance hands to the instance replacing it
+// when the host hot-reloads the plugin
+type PluginState struct {
+	FormatVersion int    `json:"formatVersion"`
+	PluginVersion string `json:"pluginVersion"`
+	ExportedAt    string `json:"exportedAt"`
+	// Clusters is the whole cluster inventory
+	Clusters []ClusterStatus `json:"clusters"`
+	// Jobs holds every retained job, finished or not
+	Jobs []Job `json:"jobs"`
+	// Resumable lists the unfinished jobs the next instance should restart
+	Resumable []resumableJob `json:"resumable,omitempty"`
+}
+
+// resumableJob holds what is needed to restart an onboarding, detach or
+// deprovision job
+type resumableJob struct {
+	JobID       string `json:"jobId"`
+	Type        string `json:"type"`
+	ClusterName string `json:"clusterName"`
+	Force       bool   `json:"force,omitempty"`
+	// DrainSeconds is the drain timeout of a two-phase detach
+	DrainSeconds int `json:"drainSeconds,omitempty"`
+	// SkipHooks skips the pre-detach hooks of a detach
+	SkipHooks bool `json:"skipHooks,omitempty"`
+	// ManifestValues are the template overrides of an onboarding
+	ManifestValues *ManifestValues `json:"manifestValues,omitempty"`
+	// SealedKubeconfig is the kubeconfig encrypted with the plugin key
+	SealedKubeconfig []byte `json:"sealedKubeconfig,omitempty"`
+
+	kubeconfig []byte
+}
+
+// trackJob records the inputs of a job until it finishes
+func (cp *ClusterPlugin) trackJob(job resumableJob) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if cp.resumable != nil {
+		cp.resumable[job.JobID] = job
+	}
+}
+
+// resumeJob builds the body of a job restarted from its inputs. tool is the
+// provisioning tool of the cluster a deprovision job tears down.
+func (cp *ClusterPlugin) resumeJob(spec resumableJob, tool string) func(ctx context.Context) error {
+	switch spec.Type {
+	case "detach":
+		return cp.detachJob(spec.JobID, spec.ClusterName, spec.kubeconfig, spec.Force, time.Duration(spec.DrainSeconds)*time.Second, spec.SkipHooks)
+	case "deprovision":
+		return cp.deprovisionJob(spec.JobID, spec.ClusterName, tool)
+	default:
+		return cp.onboardingJob(spec.JobID, spec.ClusterName, spec.kubeconfig, spec.ManifestValues)
+	}
+}
+
+// ExportState serializes the cluster inventory and the jobs for the plugin
+// version taking over. The plugin stops recording changes, running jobs are
+// cancelled so the successor can restart them, and webhooks are shut down so
+// the cancellations aren't reported. The host must call Cleanup afterwards.
+func (cp *ClusterPlugin) ExportState() ([]byte, error) {
+	cp.mutex.Lock()
+	if !cp.initialized {
+		cp.mutex.Unlock()
+		return nil, errNotInitialized
+	}
+	clusters, err := cp.store.List()
+	if err != nil {
+		cp.mutex.Unlock()
+		return nil, fmt.Errorf("failed to read cluster store: %w", err)
+	}
+	jobs := cp.jobs.Freeze()
+	// Schedules stay on disk for the next instance to start
+	cp.schedules.Stop()
+	cp.closed = true
+	tracked := make(map[string]resumableJob, len(cp.resumable))
+	for id, job := range cp.resumable {
+		tracked[id] = job
+	}
+	version := cp.metadata.Version
+	cp.mutex.Unlock()
+
+	cp.events.Close(5 * time.Second)
+	cp.webhooks.Close(5 * time.Second)
+	running := cp.jobs.Abandon(cp.shutdownTimeout)
+
+	state := PluginState{
+		FormatVersion: stateFormatVersion,
+		PluginVersion: version,
+		ExportedAt:    time.Now().Format(time.RFC3339),
+		Clusters:      clusters,
+		Jobs:          jobs,
+	}
+	for _, job := range jobs {
+		spec, ok := tracked[job.ID]
+		if !ok || job.Finished() {
+			continue
+		}
+		// Restarting a job that didn't stop here would run it twice
+		if running[job.ID] {
+			logger().Warn("Job did not stop before the handoff and won't be resumed", "job", job.ID, "cluster", job.ClusterName)
+			continue
+		}
+		if len(spec.kubeconfig) > 0 {
+			if spec.SealedKubeconfig, err = cp.box.Seal(spec.kubeconfig); err != nil {
+				return nil, fmt.Errorf("failed to seal kubeconfig of job %s: %w", job.ID, err)
+			}
+		}
+		state.Resumable = append(state.Resumable, spec)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode plugin state: %w", err)
+	}
+	logger().Info("Exported plugin state", "clusters", len(clusters), "jobs", len(jobs), "resumable", len(state.Resumable))
+	return data, nil
+}
+
+// ImportState restores the state exported by a previous plugin version into
+// this freshly initialized instance and restarts the jobs it interrupted.
+// Both instances must use the same encryption key for jobs to resume.
+func (cp *ClusterPlugin) ImportState(data []byte) error {
+	var state PluginState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to decode plugin state: %w", err)
+	}
+	if state.FormatVersion < 1 || state.FormatVersion > stateFormatVersion {
+		return fmt.Errorf("unsupported plugin state format %d, this version reads up to %d", state.FormatVersion, stateFormatVersion)
+	}
+
+	cp.mutex.Lock()
+	if !cp.initialized {
+		cp.mutex.Unlock()
+		return errNotInitialized
+	}
+
+	resume := make(map[string]resumableJob, len(state.Resumable))
+	for _, spec := range state.Resumable {
+		if spec.Type != "onboard" && spec.Type != "detach" && spec.Type != "deprovision" {
+			logger().Warn("Skipping handed over job of unknown type", "job", spec.JobID, "type", spec.Type)
+			continue
+		}
+		if len(spec.SealedKubeconfig) > 0 {
+			kubeconfig, err := cp.box.Open(spec.SealedKubeconfig)
+			if err != nil {
+				logger().Warn("Cannot resume handed over job", "job", spec.JobID, "cluster", spec.ClusterName, "error", err)
+				continue
+			}
+			spec.kubeconfig = kubeconfig
+		}
+		resume[spec.JobID] = spec
+	}
+	resumeIDs := make(map[string]bool, len(resume))
+	interrupted := make(map[string]string)
+	for _, job := range state.Jobs {
+		if _, ok := resume[job.ID]; ok {
+			resumeIDs[job.ID] = true
+		} else if !job.Finished() {
+			interrupted[job.ID] = job.Type
+		}
+	}
+
+	for _, status := range state.Clusters {
+		// Clusters whose job is lost would otherwise stay in progress forever
+		if jobType, ok := interrupted[status.JobID]; ok {
+			status.Status = "Failed"
+			if jobType == "detach" || jobType == "deprovision" {
+				status.Status = "DetachFailed"
+			}
+			status.Message = "Interrupted by plugin handoff"
+			status.LastUpdated = time.Now().Format(time.RFC3339)
+		}
+		if err := cp.store.Put(status); err != nil {
+			cp.mutex.Unlock()
+			return fmt.Errorf("failed to restore cluster %s: %w", status.ClusterName, err)
+		}
+	}
+	cp.statusCache.invalidate()
+	cp.jobs.Import(state.Jobs, resumeIDs)
+	cp.mutex.Unlock()
+
+	tools := make(map[string]string, len(state.Clusters))
+	for _, status := range state.Clusters {
+		tools[status.ClusterName] = status.Annotations[provisionedByAnnotation]
+	}
+	for id := range resumeIDs {
+		spec := resume[id]
+		cp.jobs.Run(spec.JobID, cp.resumeJob(spec, tools[spec.ClusterName]))
+	}
+
+	logger().Info("Imported plugin state", "from", state.PluginVersion, "clusters", len(state.Clusters),
+		"jobs", len(state.Jobs), "resumed", len(resumeIDs))
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func newTestPlugin(t *testing.T) *ClusterPlugin {
+	t.Helper()
+	return newTestPluginWithConfig(t, nil)
+}
+
+// newTestPluginWithConfig initializes a plugin with extra config on top of
+// the test defaults
+func newTestPluginWithConfig(t *testing.T, extra map[string]interface{}) *ClusterPlugin {
+	t.Helper()
+	config := map[string]interface{}{
+		"storePath":            filepath.Join(t.TempDir(), "clusters.db"),
+		"scheduleStorePath":    filepath.Join(t.TempDir(), "schedules.json"),
+		"checkpointStorePath":  filepath.Join(t.TempDir(), "checkpoints.json"),
+		"groupStorePath":       filepath.Join(t.TempDir(), "groups.json"),
+		"hubStoreDir":          t.TempDir(),
+		"encryptionKey":        base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)),
+		"watchManagedClusters": false,
+		"monitorHeartbeats":    false,
+		"logLevel":             "error",
+	}
+	for key, value := range extra {
+		config[key] = value
+	}
+	plugin := &ClusterPlugin{}
+	if err := plugin.Initialize(config); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	t.Cleanup(func() { plugin.Cleanup() })
+	return plugin
+}
+
+func TestStateHandoff(t *testing.T) {
+	old := newTestPlugin(t)
+	ctx := context.Background()
+
+	done := old.jobs.Create(ctx, "onboard", "ready")
+	old.jobs.Execute(done.ID, func(context.Context) error { return nil })
+	old.store.Put(ClusterStatus{ClusterName: "ready", JobID: done.ID, Status: "Ready"})
+
+	running := old.jobs.Create(ctx, "onboard", "joining")
+	old.store.Put(ClusterStatus{ClusterName: "joining", JobID: running.ID, Status: "Joining"})
+	old.trackJob(resumableJob{JobID: running.ID, Type: "onboard", ClusterName: "joining", kubeconfig: []byte("kubeconfig")})
+	started := make(chan struct{})
+	old.jobs.Run(running.ID, func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+
+	lost := old.jobs.Create(ctx, "detach", "leaving")
+	old.store.Put(ClusterStatus{ClusterName: "leaving", JobID: lost.ID, Status: "Detaching"})
+
+	data, err := old.ExportState()
+	if err != nil {
+		t.Fatalf("ExportState() error = %v", err)
+	}
+	if err := old.Cleanup(); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+
+	var state PluginState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("exported state is not JSON: %v", err)
+	}
+	if len(state.Clusters) != 3 || len(state.Jobs) != 3 {
+		t.Fatalf("exported %d clusters and %d jobs, want 3 and 3", len(state.Clusters), len(state.Jobs))
+	}
+	if len(state.Resumable) != 1 || state.Resumable[0].JobID != running.ID {
+		t.Fatalf("resumable = %+v, want only job %s", state.Resumable, running.ID)
+	}
+	if sealed := state.Resumable[0].SealedKubeconfig; len(sealed) == 0 || bytes.Contains(sealed, []byte("kubeconfig")) {
+		t.Fatalf("kubeconfig was not sealed: %q", sealed)
+	}
+
+	// Resuming would start a real onboarding, so hand over the records only
+	state.Resumable = nil
+	data, _ = json.Marshal(state)
+
+	next := newTestPlugin(t)
+	if err := next.ImportState(data); err != nil {
+		t.Fatalf("ImportState() error = %v", err)
+	}
+
+	tests := []struct {
+		cluster    string
+		jobID      string
+		wantStatus string
+		wantState  JobState
+	}{
+		{cluster: "ready", jobID: done.ID, wantStatus: "Ready", wantState: JobSucceeded},
+		{cluster: "joining", jobID: running.ID, wantStatus: "Failed", wantState: JobFailed},
+		{cluster: "leaving", jobID: lost.ID, wantStatus: "DetachFailed", wantState: JobFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cluster, func(t *testing.T) {
+			status, exists, err := next.store.Get(tt.cluster)
+			if err != nil || !exists || status.Status != tt.wantStatus {
+				t.Errorf("cluster = %+v, %v, %v, want status %s", status, exists, err, tt.wantStatus)
+			}
+			job, ok := next.jobs.Get(tt.jobID)
+			if !ok || job.State != tt.wantState {
+				t.Errorf("job = %+v, want state %s", job, tt.wantState)
+			}
+		})
+	}
+
+	if err := next.ImportState([]byte(`{"formatVersion":99}`)); err == nil {
+		t.Errorf("ImportState() accepted an unknown format version")
+	}
+}
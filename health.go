@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthState is the condition of the plugin or one of its components
+type HealthState string
+
+const (
+	HealthHealthy   HealthState = "healthy"
+	HealthDegraded  HealthState = "degraded"
+	HealthUnhealthy HealthState = "unhealthy"
+)
+
+// healthSeverity orders the states so the worst one can be picked
+var healthSeverity = map[HealthState]int{
+	HealthHealthy:   0,
+	HealthDegraded:  1,
+	HealthUnhealthy: 2,
+}
+
+// ComponentHealth is the outcome of the health check of one component
+type ComponentHealth struct {
+	Name    string      `json:"name"`
+	State   HealthState `json:"state"`
+	Message string      `json:"message,omitempty"`
+	// Details holds the measurements the state was derived from
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// HealthReport aggregates the component checks; its state is the worst
+// component state
+type HealthReport struct {
+	State      HealthState       `json:"state"`
+	Components []ComponentHealth `json:"components"`
+	CheckedAt  string            `json:"checkedAt"`
+}
+
+// newHealthReport aggregates components into a report
+func newHealthReport(components ...ComponentHealth) HealthReport {
+	report := HealthReport{
+		State:      HealthHealthy,
+		Components: components,
+		CheckedAt:  time.Now().Format(time.RFC3339),
+	}
+	for _, component := range components {
+		if healthSeverity[component.State] > healthSeverity[report.State] {
+			report.State = component.State
+		}
+	}
+	return report
+}
+
+// Err returns an error naming the unhealthy components, or nil when none
+// is; degraded components don't fail the health check
+func (r HealthReport) Err() error {
+	var problems []string
+	for _, component := range r.Components {
+		if component.State == HealthUnhealthy {
+			problems = append(problems, fmt.Sprintf("%s: %s", component.Name, component.Message))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("unhealthy components: %s", strings.Join(problems, "; "))
+}
+
+// healthReport checks every component of an initialized plugin
+func (cp *ClusterPlugin) healthReport() (HealthReport, error) {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+
+	if !cp.initialized {
+		return newHealthReport(ComponentHealth{Name: "plugin", State: HealthUnhealthy, Message: errNotInitialized.Error()}), errNotInitialized
+	}
+	components := []ComponentHealth{
+		cp.dependencyHealth(),
+		cp.hubHealth(),
+		cp.storeHealth(),
+		cp.jobHealth(),
+		cp.webhookHealth(),
+		cp.tokenHealth(),
+	}
+	if cp.leader != nil {
+		components = append(components, cp.leaderHealth())
+	}
+	report := newHealthReport(components...)
+	return report, report.Err()
+}
+
+func (cp *ClusterPlugin) dependencyHealth() ComponentHealth {
+	component := ComponentHealth{Name: "dependencies", State: HealthHealthy}
+	if failures := cp.preflight.Failures(); len(failures) > 0 {
+		names := make([]string, 0, len(failures))
+		for _, check := range failures {
+			names = append(names, check.Name)
+		}
+		component.State = HealthUnhealthy
+		component.Message = "dependency preflight failed for: " + strings.Join(names, ", ")
+	}
+	return component
+}
+
+func (cp *ClusterPlugin) hubHealth() ComponentHealth {
+	component := ComponentHealth{Name: "hub", State: HealthHealthy}
+	if cp.hub == nil {
+		component.Message = "hub watch disabled"
+		return component
+	}
+	status := cp.hub.Status()
+	component.Details = map[string]interface{}{"connected": status.Connected, "lastSync": status.LastSync}
+	switch {
+	case status.Connected:
+	case status.Error != "":
+		component.State = HealthUnhealthy
+		component.Message = "hub API unreachable: " + status.Error
+	default:
+		component.State = HealthDegraded
+		component.Message = "waiting for the first hub sync"
+	}
+	return component
+}
+
+func (cp *ClusterPlugin) storeHealth() ComponentHealth {
+	component := ComponentHealth{Name: "store", State: HealthHealthy}
+	start := time.Now()
+	clusters, err := cp.store.List()
+	if err != nil {
+		component.State = HealthUnhealthy
+		component.Message = "cluster store unreadable: " + err.Error()
+		return component
+	}
+	backend := "bolt"
+	if _, ok := baseStore(cp.store).(*memoryClusterStore); ok {
+		backend = "memory"
+	}
+	component.Details = map[string]interface{}{
+		"backend":   backend,
+		"clusters":  len(clusters),
+		"latencyMs": time.Since(start).Milliseconds(),
+	}
+	return component
+}
+
+func (cp *ClusterPlugin) jobHealth() ComponentHealth {
+	component := ComponentHealth{Name: "jobs", State: HealthHealthy}
+	active := cp.jobs.Active()
+	component.Details = map[string]interface{}{
+		"active":           active,
+		"threshold":        cp.activeJobsThreshold,
+		"batchConcurrency": cp.batchConcurrency,
+		"pool":             cp.jobs.PoolStats(),
+	}
+	if cp.activeJobsThreshold > 0 && active >= cp.activeJobsThreshold {
+		component.State = HealthDegraded
+		component.Message = fmt.Sprintf("%d jobs active, saturation threshold is %d", active, cp.activeJobsThreshold)
+	}
+	return component
+}
+
+func (cp *ClusterPlugin) webhookHealth() ComponentHealth {
+	component := ComponentHealth{Name: "webhooks", State: HealthHealthy}
+	pending := cp.webhooks.Pending()
+	component.Details = map[string]interface{}{
+		"pending":   pending,
+		"webhooks":  len(cp.webhooks.List()),
+		"threshold": cp.webhookBacklogThreshold,
+	}
+	if cp.webhookBacklogThreshold > 0 && pending >= cp.webhookBacklogThreshold {
+		component.State = HealthDegraded
+		component.Message = fmt.Sprintf("%d deliveries pending, backlog threshold is %d", pending, cp.webhookBacklogThreshold)
+	}
+	return component
+}
+
+// GetHealthDetailsHandler reports the health of every plugin component
+func (cp *ClusterPlugin) GetHealthDetailsHandler(c *gin.Context) {
+	report, _ := cp.healthReport()
+	status := http.StatusOK
+	if report.State == HealthUnhealthy {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"health":    report,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestHealthReport(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []ComponentHealth
+		wantState  HealthState
+		wantErr    string
+	}{
+		{
+			name:       "healthy",
+			components: []ComponentHealth{{Name: "store", State: HealthHealthy}, {Name: "hub", State: HealthHealthy}},
+			wantState:  HealthHealthy,
+		},
+		{
+			name:       "degraded does not fail",
+			components: []ComponentHealth{{Name: "store", State: HealthHealthy}, {Name: "jobs", State: HealthDegraded, Message: "busy"}},
+			wantState:  HealthDegraded,
+		},
+		{
+			name: "unhealthy wins",
+			components: []ComponentHealth{
+				{Name: "hub", State: HealthUnhealthy, Message: "hub API unreachable"},
+				{Name: "jobs", State: HealthDegraded, Message: "busy"},
+			},
+			wantState: HealthUnhealthy,
+			wantErr:   "hub: hub API unreachable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := newHealthReport(tt.components...)
+			if report.State != tt.wantState {
+				t.Errorf("State = %q, want %q", report.State, tt.wantState)
+			}
+			err := report.Err()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Err() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Err() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJobSaturationHealth(t *testing.T) {
+	plugin := newTestPlugin(t)
+	plugin.activeJobsThreshold = 2
+
+	if got := plugin.jobHealth().State; got != HealthHealthy {
+		t.Fatalf("idle jobs state = %q, want %q", got, HealthHealthy)
+	}
+	for i := 0; i < 2; i++ {
+		job := plugin.jobs.Create(context.Background(), "onboard", "demo")
+		defer plugin.jobs.Execute(job.ID, func(context.Context) error { return nil })
+	}
+	if got := plugin.jobHealth().State; got != HealthDegraded {
+		t.Fatalf("saturated jobs state = %q, want %q", got, HealthDegraded)
+	}
+
+	report, _ := plugin.healthReport()
+	if len(report.Components) != 6 {
+		t.Fatalf("components = %d, want 6", len(report.Components))
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HistoryEntry records a cluster moving from one status to another
+type HistoryEntry struct {
+	ClusterName string `json:"clusterName"`
+	Timestamp   string `json:"timestamp"`
+	Status      string `json:"status"`
+	Previous    string `json:"previous,omitempty"`
+	// Event names what the transition means, such as onboarded or recovered
+	Event   string `json:"event"`
+	Message string `json:"message,omitempty"`
+	JobID   string `json:"jobId,omitempty"`
+}
+
+// HistoryTimeline picks the milestones out of a cluster's history
+type HistoryTimeline struct {
+	FirstSeenAt       string `json:"firstSeenAt,omitempty"`
+	OnboardedAt       string `json:"onboardedAt,omitempty"`
+	LastUnreachableAt string `json:"lastUnreachableAt,omitempty"`
+	LastRecoveredAt   string `json:"lastRecoveredAt,omitempty"`
+	LastRepairedAt    string `json:"lastRepairedAt,omitempty"`
+	LastFailedAt      string `json:"lastFailedAt,omitempty"`
+	DetachedAt        string `json:"detachedAt,omitempty"`
+}
+
+// Availability is the share of a time window a cluster spent Ready. Time
+// before its first recorded transition or after it was detached is not
+// observed.
+type Availability struct {
+	Since           string  `json:"since"`
+	Until           string  `json:"until"`
+	ObservedSeconds int64   `json:"observedSeconds"`
+	ReadySeconds    int64   `json:"readySeconds"`
+	Percent         float64 `json:"percent"`
+	// Outages counts the times the cluster left Ready within the window,
+	// other than to be detached
+	Outages int `json:"outages"`
+}
+
+// historyEvent names a transition for the timeline
+func historyEvent(previous, status string) string {
+	switch status {
+	case "Ready":
+		switch previous {
+		case statusUnreachable:
+			return "recovered"
+		case "Repairing":
+			return "repaired"
+		}
+		return "onboarded"
+	case statusUnreachable:
+		return "unreachable"
+	case "Failed", "DetachFailed":
+		return "failed"
+	case "Detached":
+		return "detached"
+	case "Repairing":
+		return "repairing"
+	case "Detaching":
+		return "detaching"
+	}
+	if previous == "" {
+		return "registered"
+	}
+	return "onboarding"
+}
+
+// recordHistory keeps a status transition for the cluster timeline
+func (cp *ClusterPlugin) recordHistory(previous, status ClusterStatus) {
+	timestamp := status.LastUpdated
+	if timestamp == "" {
+		timestamp = time.Now().Format(time.RFC3339)
+	}
+	entry := HistoryEntry{
+		ClusterName: status.ClusterName,
+		Timestamp:   timestamp,
+		Status:      status.Status,
+		Previous:    previous.Status,
+		Event:       historyEvent(previous.Status, status.Status),
+		Message:     status.Message,
+		JobID:       status.JobID,
+	}
+	if err := cp.store.AppendHistory(entry); err != nil {
+		logger().Warn("Failed to record cluster history", "cluster", status.ClusterName, "error", err)
+	}
+}
+
+// pruneHistory drops transitions older than the retention period
+func (cp *ClusterPlugin) pruneHistory(_ context.Context) {
+	pruned, err := cp.store.PruneHistory(time.Now().Add(-cp.historyRetention), cp.historyMaxEntries)
+	if err != nil {
+		logger().Warn("Failed to prune cluster history", "error", err)
+		return
+	}
+	if pruned > 0 {
+		logger().Debug("Pruned cluster history", "entries", pruned)
+	}
+}
+
+// buildTimeline picks the milestones out of a history, oldest first
+func buildTimeline(entries []HistoryEntry) HistoryTimeline {
+	var timeline HistoryTimeline
+	for _, entry := range entries {
+		if timeline.FirstSeenAt == "" {
+			timeline.FirstSeenAt = entry.Timestamp
+		}
+		switch entry.Event {
+		case "onboarded":
+			if timeline.OnboardedAt == "" || timeline.DetachedAt != "" {
+				timeline.OnboardedAt = entry.Timestamp
+				timeline.DetachedAt = ""
+			}
+		case "unreachable":
+			timeline.LastUnreachableAt = entry.Timestamp
+		case "recovered":
+			timeline.LastRecoveredAt = entry.Timestamp
+		case "repaired":
+			timeline.LastRepairedAt = entry.Timestamp
+		case "failed":
+			timeline.LastFailedAt = entry.Timestamp
+		case "detached":
+			timeline.DetachedAt = entry.Timestamp
+		}
+	}
+	return timeline
+}
+
+// computeAvailability measures how long the cluster was Ready between since
+// and until from its history, oldest first
+func computeAvailability(entries []HistoryEntry, since, until time.Time) Availability {
+	availability := Availability{Since: since.Format(time.RFC3339), Until: until.Format(time.RFC3339)}
+	var observed, ready time.Duration
+	status := ""
+	var from time.Time
+	account := func(to time.Time) {
+		if from.IsZero() || status == "Detached" || !to.After(from) {
+			return
+		}
+		observed += to.Sub(from)
+		if status == "Ready" {
+			ready += to.Sub(from)
+		}
+	}
+
+	for _, entry := range entries {
+		timestamp, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err != nil {
+			continue
+		}
+		if timestamp.After(until) {
+			break
+		}
+		if timestamp.Before(since) {
+			status, from = entry.Status, since
+			continue
+		}
+		account(timestamp)
+		// Detaching is not an outage but the end of the cluster
+		if status == "Ready" && entry.Status != "Detaching" && entry.Status != "Detached" {
+			availability.Outages++
+		}
+		status, from = entry.Status, timestamp
+	}
+	account(until)
+
+	availability.ObservedSeconds = int64(observed / time.Second)
+	availability.ReadySeconds = int64(ready / time.Second)
+	if observed > 0 {
+		availability.Percent = math.Round(float64(ready)/float64(observed)*10000) / 100
+	}
+	return availability
+}
+
+// parseTimeRange reads the ?since and ?until RFC 3339 time range of a
+// request. until defaults to now and since to span before until.
+func parseTimeRange(c *gin.Context, span time.Duration) (time.Time, time.Time, error) {
+	until := time.Now()
+	if raw := c.Query("until"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid until %q, must be an RFC 3339 time", raw)
+		}
+		until = parsed
+	}
+	since := until.Add(-span)
+	if raw := c.Query("since"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid since %q, must be an RFC 3339 time", raw)
+		}
+		since = parsed
+	}
+	if !since.Before(until) {
+		return time.Time{}, time.Time{}, fmt.Errorf("since must be before until")
+	}
+	return since, until, nil
+}
+
+// GetClusterHistoryHandler returns the status transitions of a cluster with
+// its milestones and availability over the ?since and ?until RFC 3339 time
+// range, which defaults to the retention period
+func (cp *ClusterPlugin) GetClusterHistoryHandler(c *gin.Context) {
+	clusterName := c.Param("name")
+	since, until, err := parseTimeRange(c, cp.historyRetention)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	entries, err := cp.store.History(clusterName)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	_, exists, err := cp.store.Get(clusterName)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	// Detached clusters keep their history until it is pruned
+	if !exists && len(entries) == 0 {
+		respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found", clusterName))
+		return
+	}
+
+	window := make([]HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		timestamp, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err == nil && !timestamp.Before(since) && !timestamp.After(until) {
+			window = append(window, entry)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clusterName":  clusterName,
+		"timeline":     buildTimeline(entries),
+		"availability": computeAvailability(entries, since, until),
+		"entries":      window,
+		"retention": gin.H{
+			"days":       int(cp.historyRetention / (24 * time.Hour)),
+			"maxEntries": cp.historyMaxEntries,
+		},
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// historyRetentionFromConfig reads how long and how many transitions are
+// kept per cluster
+func historyRetentionFromConfig(config map[string]interface{}) (time.Duration, int, error) {
+	days := configInt(config, "historyRetentionDays", 90)
+	maxEntries := configInt(config, "historyMaxEntries", 1000)
+	if days <= 0 || maxEntries <= 0 {
+		return 0, 0, fmt.Errorf("historyRetentionDays and historyMaxEntries must be positive")
+	}
+	return time.Duration(days) * 24 * time.Hour, maxEntries, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHistoryEvent(t *testing.T) {
+	tests := []struct {
+		previous, status, want string
+	}{
+		{"", "Pending", "registered"},
+		{"Pending", "Joining", "onboarding"},
+		{"Joining", "Ready", "onboarded"},
+		{"Ready", statusUnreachable, "unreachable"},
+		{statusUnreachable, "Ready", "recovered"},
+		{"Repairing", "Ready", "repaired"},
+		{"Detaching", "DetachFailed", "failed"},
+		{"Detaching", "Detached", "detached"},
+	}
+	for _, tt := range tests {
+		if got := historyEvent(tt.previous, tt.status); got != tt.want {
+			t.Errorf("historyEvent(%q, %q) = %q, want %q", tt.previous, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestComputeAvailability(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) string { return start.Add(time.Duration(hours) * time.Hour).Format(time.RFC3339) }
+	entries := []HistoryEntry{
+		{Status: "Pending", Timestamp: at(0)},
+		{Status: "Ready", Timestamp: at(1)},
+		{Status: statusUnreachable, Timestamp: at(10)},
+		{Status: "Ready", Timestamp: at(12)},
+		{Status: "Detached", Timestamp: at(20)},
+	}
+
+	// Ready for 16 of the 18 hours between hour 2 and the detach
+	got := computeAvailability(entries, start.Add(2*time.Hour), start.Add(24*time.Hour))
+	if got.ObservedSeconds != 18*3600 || got.ReadySeconds != 16*3600 || got.Outages != 1 || got.Percent != 88.89 {
+		t.Errorf("computeAvailability() = %+v", got)
+	}
+
+	timeline := buildTimeline([]HistoryEntry{
+		{Event: "registered", Timestamp: at(0)},
+		{Event: "onboarded", Timestamp: at(1)},
+		{Event: "unreachable", Timestamp: at(10)},
+		{Event: "recovered", Timestamp: at(12)},
+		{Event: "detached", Timestamp: at(20)},
+	})
+	want := HistoryTimeline{FirstSeenAt: at(0), OnboardedAt: at(1), LastUnreachableAt: at(10), LastRecoveredAt: at(12), DetachedAt: at(20)}
+	if timeline != want {
+		t.Errorf("buildTimeline() = %+v, want %+v", timeline, want)
+	}
+}
+
+func TestGetClusterHistoryHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	for _, status := range []string{"Pending", "Ready", "Ready", statusUnreachable} {
+		plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: status, LastUpdated: time.Now().Format(time.RFC3339)})
+	}
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/plugins/kubestellar-cluster-plugin/v1"+path, nil))
+		return recorder
+	}
+
+	recorder := get("/clusters/edge-1/history")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("history = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		Timeline HistoryTimeline `json:"timeline"`
+		Entries  []HistoryEntry  `json:"entries"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	// The repeated Ready is not a transition
+	if len(response.Entries) != 3 || response.Entries[2].Event != "unreachable" || response.Timeline.OnboardedAt == "" {
+		t.Errorf("history = %+v", response)
+	}
+
+	if recorder := get("/clusters/edge-9/history"); recorder.Code != http.StatusNotFound {
+		t.Errorf("history of an unknown cluster = %d", recorder.Code)
+	}
+	if recorder := get("/clusters/edge-1/history?since=yesterday"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid since = %d", recorder.Code)
+	}
+	// Without since the range reaches back from until, not from now
+	until := time.Now().AddDate(-1, 0, 0).Format(time.RFC3339)
+	if recorder := get("/clusters/edge-1/history?until=" + until); recorder.Code != http.StatusOK {
+		t.Errorf("history until a year ago = %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Stages hooks run at
+const (
+	hookPostOnboard = "post-onboard"
+	hookPreDetach   = "pre-detach"
+)
+
+// Kinds of hook
+const (
+	hookWebhook = "webhook"
+	hookCommand = "command"
+)
+
+// Failure policies of a hook
+const (
+	hookAbort    = "abort"
+	hookContinue = "continue"
+)
+
+// hookOutputLimit caps the command output quoted in a hook failure; the
+// full output goes to the cluster's logs
+const hookOutputLimit = 4096
+
+// hookPath is the PATH of hook commands, which get none of the plugin's
+// environment
+const hookPath = "/usr/local/bin:/usr/bin:/bin"
+
+// HookConfig is one entry of the postOnboardHooks or preDetachHooks list of
+// the Initialize config: a webhook called or a command run for each cluster
+type HookConfig struct {
+	Name string `json:"name"`
+	// Type is webhook or command
+	Type string `json:"type"`
+	// URL receives a POST of the HookPayload, signed with Secret like
+	// webhook deliveries when set
+	URL     string            `json:"url,omitempty"`
+	Secret  string            `json:"secret,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Command runs from an absolute path in a scratch directory, with only
+	// CLUSTER_NAME, HUB_NAME, JOB_ID, HOOK_STAGE, KUBECONFIG, Env and a
+	// fixed PATH in its environment
+	Command []string          `json:"command,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	// TimeoutSeconds bounds the hook, 60 by default
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// FailurePolicy is abort, failing the operation, or continue
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+}
+
+// Validate checks the hook can run
+func (h HookConfig) Validate() error {
+	if h.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if h.TimeoutSeconds < 0 {
+		return fmt.Errorf("hook %s has a negative timeout", h.Name)
+	}
+	if h.FailurePolicy != "" && h.FailurePolicy != hookAbort && h.FailurePolicy != hookContinue {
+		return fmt.Errorf("hook %s: failurePolicy must be %s or %s", h.Name, hookAbort, hookContinue)
+	}
+	switch h.Type {
+	case hookWebhook:
+		parsed, err := url.Parse(h.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("hook %s: url must be an absolute http or https URL", h.Name)
+		}
+	case hookCommand:
+		if len(h.Command) == 0 || !filepath.IsAbs(h.Command[0]) {
+			return fmt.Errorf("hook %s: command must start with an absolute path", h.Name)
+		}
+		for key := range h.Env {
+			if key == "" || strings.Contains(key, "=") {
+				return fmt.Errorf("hook %s: invalid env variable %q", h.Name, key)
+			}
+		}
+	default:
+		return fmt.Errorf("hook %s: type must be %s or %s", h.Name, hookWebhook, hookCommand)
+	}
+	return nil
+}
+
+func (h HookConfig) timeout() time.Duration {
+	if h.TimeoutSeconds == 0 {
+		return time.Minute
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+// hooksFromConfig reads the hooks listed under key
+func hooksFromConfig(config map[string]interface{}, key string) ([]HookConfig, error) {
+	raw, ok := config[key]
+	if !ok {
+		return nil, nil
+	}
+	var hooks []HookConfig
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s config: %w", key, err)
+	}
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("invalid %s config: %w", key, err)
+	}
+	seen := make(map[string]bool, len(hooks))
+	for i, hook := range hooks {
+		if err := hook.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid %s config: %s[%d]: %w", key, key, i, err)
+		}
+		if seen[hook.Name] {
+			return nil, fmt.Errorf("invalid %s config: hook %s is listed twice", key, hook.Name)
+		}
+		seen[hook.Name] = true
+	}
+	return hooks, nil
+}
+
+// HookPayload is the body POSTed to webhook hooks
+type HookPayload struct {
+	Stage       string `json:"stage"`
+	Hook        string `json:"hook"`
+	ClusterName string `json:"clusterName"`
+	Hub         string `json:"hub"`
+	JobID       string `json:"jobId,omitempty"`
+	Timestamp   string `json:"timestamp"`
+}
+
+// hookRun is what a hook is told about the cluster it runs for
+type hookRun struct {
+	stage       string
+	clusterName string
+	hub         string
+	jobID       string
+	kubeconfig  []byte
+}
+
+// runHooks runs hooks in order. A failing hook with the continue policy is
+// logged; any other failure stops the hooks and is returned.
+func (cp *ClusterPlugin) runHooks(ctx context.Context, hooks []HookConfig, run hookRun) error {
+	for _, hook := range hooks {
+		if err := cp.advance(ctx, run.clusterName, "Running hook "+hook.Name, fmt.Sprintf("Running %s hook %s", run.stage, hook.Name)); err != nil {
+			return err
+		}
+		hookCtx, cancel := context.WithTimeout(ctx, hook.timeout())
+		var err error
+		if hook.Type == hookWebhook {
+			err = callHookWebhook(hookCtx, hook, run)
+		} else {
+			err = cp.runHookCommand(hookCtx, hook, run)
+		}
+		if err != nil && hookCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			err = fmt.Errorf("timed out after %s: %w", hook.timeout(), err)
+		}
+		cancel()
+
+		if err == nil {
+			cp.logs.Append(run.clusterName, "info", fmt.Sprintf("Hook %s succeeded", hook.Name))
+			continue
+		}
+		if hook.FailurePolicy != hookContinue || ctx.Err() != nil {
+			return fmt.Errorf("%s hook %s failed: %w", run.stage, hook.Name, err)
+		}
+		logger().Warn("Hook failed, continuing", "cluster", run.clusterName, "stage", run.stage, "hook", hook.Name, "error", err)
+		cp.logs.Append(run.clusterName, "warn", fmt.Sprintf("Hook %s failed, continuing: %v", hook.Name, err))
+	}
+	return nil
+}
+
+// callHookWebhook POSTs the hook payload, failing on anything but a 2xx
+func callHookWebhook(ctx context.Context, hook HookConfig, run hookRun) error {
+	body, err := json.Marshal(HookPayload{
+		Stage:       run.stage,
+		Hook:        hook.Name,
+		ClusterName: run.clusterName,
+		Hub:         run.hub,
+		JobID:       run.jobID,
+		Timestamp:   time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range hook.Headers {
+		request.Header.Set(key, value)
+	}
+	if hook.Secret != "" {
+		request.Header.Set(webhookSignatureHeader, signWebhookBody(hook.Secret, body))
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s responded %s", hook.URL, response.Status)
+	}
+	return nil
+}
+
+// runHookCommand runs a command hook in a scratch directory holding the
+// kubeconfig of the cluster, with a bare environment. Its output goes to the
+// cluster's logs.
+func (cp *ClusterPlugin) runHookCommand(ctx context.Context, hook HookConfig, run hookRun) error {
+	dir, err := os.MkdirTemp("", "hook-")
+	if err != nil {
+		return fmt.Errorf("failed to create hook directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := []string{
+		"PATH=" + hookPath,
+		"HOME=" + dir,
+		"CLUSTER_NAME=" + run.clusterName,
+		"HUB_NAME=" + run.hub,
+		"JOB_ID=" + run.jobID,
+		"HOOK_STAGE=" + run.stage,
+	}
+	if len(run.kubeconfig) > 0 {
+		kubeconfigPath := filepath.Join(dir, "kubeconfig")
+		if err := os.WriteFile(kubeconfigPath, run.kubeconfig, 0o600); err != nil {
+			return fmt.Errorf("failed to write kubeconfig: %w", err)
+		}
+		env = append(env, "KUBECONFIG="+kubeconfigPath)
+	}
+	for key, value := range hook.Env {
+		env = append(env, key+"="+value)
+	}
+
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Dir, cmd.Env = dir, env
+	// Children left holding the output pipes don't keep the hook running
+	cmd.WaitDelay = 5 * time.Second
+	output, err := cp.runLogged(run.clusterName, cmd)
+	if err != nil {
+		quoted := strings.TrimSpace(string(output))
+		if len(quoted) > hookOutputLimit {
+			quoted = "..." + quoted[len(quoted)-hookOutputLimit:]
+		}
+		return fmt.Errorf("%s, %w", quoted, err)
+	}
+	return nil
+}
+
+// runPostOnboardHooks runs the post-onboard hooks for a cluster the pipeline
+// has onboarded, with the kubeconfig saved for it
+func (cp *ClusterPlugin) runPostOnboardHooks(ctx context.Context, jobID, clusterName string, kubeconfigData []byte) error {
+	if len(cp.postOnboardHooks) == 0 {
+		return nil
+	}
+	run := hookRun{stage: hookPostOnboard, clusterName: clusterName, jobID: jobID, kubeconfig: kubeconfigData}
+	if saved := cp.savedKubeconfig(clusterName); len(saved) > 0 {
+		run.kubeconfig = saved
+	}
+	if hub, err := cp.clusterHub(clusterName); err == nil {
+		run.hub = hub.Name
+	}
+	return cp.runHooks(ctx, cp.postOnboardHooks, run)
+}
+
+// runPreDetachHooks runs the pre-detach hooks for a cluster about to be
+// detached, with the kubeconfig the detach was given or saved for it.
+// skip bypasses them, leaving a warning in the cluster's logs.
+func (cp *ClusterPlugin) runPreDetachHooks(ctx context.Context, jobID, clusterName string, spokeKubeconfig []byte, skip bool) error {
+	if len(cp.preDetachHooks) == 0 {
+		return nil
+	}
+	if skip {
+		logger().Warn("Skipping pre-detach hooks", "cluster", clusterName, "job", jobID, "hooks", len(cp.preDetachHooks))
+		cp.logs.Append(clusterName, "warn", fmt.Sprintf("Skipped %d pre-detach hooks as requested", len(cp.preDetachHooks)))
+		return nil
+	}
+	run := hookRun{stage: hookPreDetach, clusterName: clusterName, jobID: jobID, kubeconfig: spokeKubeconfig}
+	if len(run.kubeconfig) == 0 {
+		run.kubeconfig = cp.savedKubeconfig(clusterName)
+	}
+	if hub, err := cp.clusterHub(clusterName); err == nil {
+		run.hub = hub.Name
+	}
+	return cp.runHooks(ctx, cp.preDetachHooks, run)
+}
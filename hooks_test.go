@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHooksFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		hooks   []interface{}
+		wantErr string
+	}{
+		{name: "webhook and command", hooks: []interface{}{
+			map[string]interface{}{"name": "cmdb", "type": "webhook", "url": "https://cmdb.example.com/register"},
+			map[string]interface{}{"name": "tag", "type": "command", "command": []interface{}{"/usr/bin/true"}, "failurePolicy": "continue"},
+		}},
+		{name: "relative command", hooks: []interface{}{map[string]interface{}{"name": "tag", "type": "command", "command": []interface{}{"tag.sh"}}}, wantErr: "absolute path"},
+		{name: "bad url", hooks: []interface{}{map[string]interface{}{"name": "cmdb", "type": "webhook", "url": "cmdb"}}, wantErr: "url"},
+		{name: "unknown type", hooks: []interface{}{map[string]interface{}{"name": "x", "type": "lambda"}}, wantErr: "type"},
+		{name: "unknown policy", hooks: []interface{}{map[string]interface{}{"name": "x", "type": "command", "command": []interface{}{"/bin/true"}, "failurePolicy": "retry"}}, wantErr: "failurePolicy"},
+		{name: "duplicate", hooks: []interface{}{
+			map[string]interface{}{"name": "x", "type": "command", "command": []interface{}{"/bin/true"}},
+			map[string]interface{}{"name": "x", "type": "command", "command": []interface{}{"/bin/true"}},
+		}, wantErr: "twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hooks, err := hooksFromConfig(map[string]interface{}{"postOnboardHooks": tt.hooks}, "postOnboardHooks")
+			if tt.wantErr == "" && (err != nil || len(hooks) != len(tt.hooks)) || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("hooksFromConfig() = %+v, %v, want %q", hooks, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writeHookScript writes an executable shell script for a command hook
+func writeHookScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunHooks(t *testing.T) {
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0})
+	t.Setenv("PLUGIN_SECRET", "do-not-leak")
+
+	var payload HookPayload
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &payload)
+		signature = r.Header.Get(webhookSignatureHeader)
+		if payload.ClusterName == "rejected" {
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "env")
+	hooks := []HookConfig{
+		{Name: "cmdb", Type: hookWebhook, URL: server.URL, Secret: "s3cret"},
+		{Name: "env", Type: hookCommand, Command: []string{writeHookScript(t, "env > "+out+"\ncat \"$KUBECONFIG\" >> "+out+"\n")}, Env: map[string]string{"TEAM": "edge"}},
+	}
+	run := hookRun{stage: hookPostOnboard, clusterName: "edge-1", hub: "its1", jobID: "onboard-1", kubeconfig: []byte("kubeconfig-data")}
+	if err := plugin.runHooks(context.Background(), hooks, run); err != nil {
+		t.Fatalf("runHooks() error = %v", err)
+	}
+	if payload.ClusterName != "edge-1" || payload.Stage != hookPostOnboard || payload.Hub != "its1" || !strings.HasPrefix(signature, "sha256=") {
+		t.Errorf("webhook got %+v signed %q", payload, signature)
+	}
+	env, _ := os.ReadFile(out)
+	for _, want := range []string{"CLUSTER_NAME=edge-1", "HUB_NAME=its1", "TEAM=edge", "KUBECONFIG=", "kubeconfig-data"} {
+		if !strings.Contains(string(env), want) {
+			t.Errorf("hook environment lacks %s:\n%s", want, env)
+		}
+	}
+	if strings.Contains(string(env), "do-not-leak") {
+		t.Errorf("hook environment leaks the plugin's:\n%s", env)
+	}
+
+	failing := HookConfig{Name: "fail", Type: hookCommand, Command: []string{writeHookScript(t, "echo registration refused\nexit 3\n")}}
+	err := plugin.runHooks(context.Background(), []HookConfig{failing}, run)
+	if err == nil || !strings.Contains(err.Error(), "registration refused") {
+		t.Errorf("abort hook error = %v, want its output", err)
+	}
+	failing.FailurePolicy = hookContinue
+	if err := plugin.runHooks(context.Background(), []HookConfig{failing}, run); err != nil {
+		t.Errorf("continue hook error = %v", err)
+	}
+
+	slow := HookConfig{Name: "slow", Type: hookCommand, Command: []string{writeHookScript(t, "exec sleep 10\n")}, TimeoutSeconds: 1}
+	if err := plugin.runHooks(context.Background(), []HookConfig{slow}, run); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("slow hook error = %v, want a timeout", err)
+	}
+
+	run.clusterName = "rejected"
+	if err := plugin.runHooks(context.Background(), hooks[:1], run); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("rejected webhook error = %v", err)
+	}
+}
+
+func TestPostOnboardHookFailsOnboarding(t *testing.T) {
+	script := writeHookScript(t, "exit 1\n")
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode": modeMock, "mockFleetSize": 0, "mockStepDelayMillis": 0,
+		"postOnboardHooks": []interface{}{map[string]interface{}{"name": "register", "type": "command", "command": []interface{}{script}}},
+	})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Pending"})
+
+	if err := plugin.onboardingJob("onboard-1", "edge-1", nil, nil)(context.Background()); err == nil || !strings.Contains(err.Error(), "post-onboard hook register failed") {
+		t.Fatalf("onboarding error = %v", err)
+	}
+	if record, _, _ := plugin.store.Get("edge-1"); record.Status != "Failed" {
+		t.Errorf("status = %s, want Failed", record.Status)
+	}
+}
+
+func TestPreDetachHookBlocksDetach(t *testing.T) {
+	script := writeHookScript(t, "exit 1\n")
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode": modeMock, "mockFleetSize": 0, "mockStepDelayMillis": 0,
+		"preDetachHooks": []interface{}{map[string]interface{}{"name": "deregister", "type": "command", "command": []interface{}{script}}},
+	})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+
+	if err := plugin.detachJob("detach-1", "edge-1", nil, false, 0, false)(context.Background()); err == nil || !strings.Contains(err.Error(), "pre-detach hook deregister failed") {
+		t.Fatalf("detach error = %v", err)
+	}
+	if record, exists, _ := plugin.store.Get("edge-1"); !exists || record.Status != "DetachFailed" {
+		t.Fatalf("cluster = %+v (exists %v), want it kept as DetachFailed", record, exists)
+	}
+
+	if err := plugin.detachJob("detach-2", "edge-1", nil, false, 0, true)(context.Background()); err != nil {
+		t.Fatalf("detach skipping hooks: %v", err)
+	}
+	if _, exists, _ := plugin.store.Get("edge-1"); exists {
+		t.Error("cluster still recorded after detaching with skipHooks")
+	}
+}
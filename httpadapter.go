@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetHTTPHandlers returns the endpoint handlers as net/http handlers, keyed
+// by handler name like GetHandlers, so hosts built on chi, echo or the
+// standard library can mount the plugin without sharing its gin version.
+//
+// A handler can be mounted at any path that ends with its endpoint path:
+// path parameters are read from the trailing segments of the request path,
+// so /api/plugins/<id>/clusters/edge-1 serves /clusters/:name whatever the
+// host's route syntax is.
+func (cp *ClusterPlugin) GetHTTPHandlers() map[string]http.HandlerFunc {
+	// Hosts without gin can't apply GetMiddlewares, so the concerns are
+	// always baked in here
+	ginHandlers := cp.bareHandlers()
+	for name, handler := range ginHandlers {
+		ginHandlers[name] = cp.wrapHandler(name, handler)
+	}
+	endpoints := map[string][]EndpointConfig{}
+	for _, endpoint := range cp.GetMetadata().Endpoints {
+		endpoints[endpoint.Handler] = append(endpoints[endpoint.Handler], endpoint)
+	}
+
+	handlers := make(map[string]http.HandlerFunc, len(endpoints))
+	for name, served := range endpoints {
+		handler, ok := ginHandlers[name]
+		if !ok {
+			continue
+		}
+		handlers[name] = newHTTPAdapter(served, handler)
+	}
+	return handlers
+}
+
+// newHTTPAdapter serves handler for endpoints through a private gin engine,
+// rewriting the request path to the part its routes match
+func newHTTPAdapter(endpoints []EndpointConfig, handler gin.HandlerFunc) http.HandlerFunc {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	endpoints = append([]EndpointConfig(nil), endpoints...)
+	sortBySpecificity(endpoints)
+	for _, endpoint := range endpoints {
+		router.Handle(endpoint.Method, endpoint.Path, handler)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, endpoint := range endpoints {
+			if endpoint.Method != r.Method {
+				continue
+			}
+			if suffix, ok := matchPathSuffix(endpoint.Path, r.URL.Path); ok {
+				routed := r.Clone(r.Context())
+				routed.URL.Path = suffix
+				routed.URL.RawPath = ""
+				router.ServeHTTP(w, routed)
+				return
+			}
+		}
+		problem := newProblem(http.StatusNotFound, CodeNotFound, "No plugin endpoint matches "+r.Method+" "+r.URL.Path)
+		problem.Instance = r.URL.Path
+		problem.write(w, r.Header.Get("Accept"))
+	}
+}
+
+// matchPathSuffix returns the trailing segments of path that line up with
+// the segments of pattern, where :param segments match any value
+func matchPathSuffix(pattern, path string) (string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(pathSegments) < len(patternSegments) {
+		return "", false
+	}
+	suffix := pathSegments[len(pathSegments)-len(patternSegments):]
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, ":") {
+			if suffix[i] == "" {
+				return "", false
+			}
+			continue
+		}
+		if segment != suffix[i] {
+			return "", false
+		}
+	}
+	return "/" + strings.Join(suffix, "/"), true
+}
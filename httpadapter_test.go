@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMatchPathSuffix(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          string
+		ok            bool
+	}{
+		{pattern: "/status", path: "/api/plugins/x/status", want: "/status", ok: true},
+		{pattern: "/clusters/:name/token", path: "/plugins/x/clusters/edge-1/token", want: "/clusters/edge-1/token", ok: true},
+		{pattern: "/clusters/:name/token", path: "/clusters/edge-1/token/", want: "/clusters/edge-1/token", ok: true},
+		{pattern: "/clusters/:name/token", path: "/clusters/edge-1", ok: false},
+		{pattern: "/clusters/:name", path: "/hubs/its1", ok: false},
+		{pattern: "/jobs/:id", path: "/jobs/", ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := matchPathSuffix(tt.pattern, tt.path)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("matchPathSuffix(%q, %q) = %q, %v, want %q, %v", tt.pattern, tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestGetHTTPHandlers(t *testing.T) {
+	plugin := newTestPlugin(t)
+	handlers := plugin.GetHTTPHandlers()
+	if len(handlers) != len(plugin.GetHandlers()) {
+		t.Errorf("GetHTTPHandlers() = %d handlers, want one per gin handler (%d)", len(handlers), len(plugin.GetHandlers()))
+	}
+	plugin.tokens.Record(IssuedToken{ClusterName: "edge-1", Hub: "its1", ID: "abcdef"})
+
+	// A standard library host mounts handlers under its own prefix
+	mux := http.NewServeMux()
+	mux.Handle("/plugins/cluster/clusters/", handlers["GetClusterTokenHandler"])
+	mux.Handle("/plugins/cluster/openapi.json", handlers["GetOpenAPIHandler"])
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{method: http.MethodGet, path: "/plugins/cluster/clusters/edge-1/token", wantStatus: http.StatusOK, wantBody: `"abcdef"`},
+		{method: http.MethodGet, path: "/plugins/cluster/clusters/edge-2/token", wantStatus: http.StatusNotFound, wantBody: "edge-2"},
+		{method: http.MethodPost, path: "/plugins/cluster/clusters/edge-1/token", wantStatus: http.StatusNotFound},
+		{method: http.MethodGet, path: "/plugins/cluster/openapi.json", wantStatus: http.StatusOK, wantBody: `"openapi"`},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, nil))
+		if recorder.Code != tt.wantStatus || !strings.Contains(recorder.Body.String(), tt.wantBody) {
+			t.Errorf("%s %s = %d %s, want %d containing %s", tt.method, tt.path, recorder.Code, recorder.Body, tt.wantStatus, tt.wantBody)
+		}
+	}
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// builtinHub is the ITS hub the plugin onboarded to before hubs could be
+// registered: the its1 context of the plugin's kubeconfig. Clusters
+// recorded without a hub belong to it.
+var builtinHub = Hub{Name: "its1", Context: "its1"}
+
+var (
+	errHubNotFound = errors.New("hub not registered")
+	errHubExists   = errors.New("hub already registered")
+)
+
+// Hub is a KubeStellar control plane (ITS) clusters can be onboarded to
+type Hub struct {
+	Name string `json:"name"`
+	// Context selects the hub in its kubeconfig, or in the plugin's own
+	// kubeconfig when the hub was registered without one
+	Context string `json:"context,omitempty"`
+	// Kubeconfig is set when the hub was registered with its own kubeconfig
+	Kubeconfig bool `json:"kubeconfig,omitempty"`
+	// InCluster reaches the hub through the service account of the plugin pod
+	InCluster bool   `json:"inCluster,omitempty"`
+	Server    string `json:"server,omitempty"`
+	// Default is set on the hub used when a request names none
+	Default      bool   `json:"default"`
+	RegisteredAt string `json:"registeredAt,omitempty"`
+
+	// kubeconfigPath is the plaintext copy of the registered kubeconfig the
+	// clients and CLIs run against
+	kubeconfigPath string
+}
+
+// clientset connects to the hub
+func (h Hub) clientset() (*kubernetes.Clientset, *rest.Config, error) {
+	if !h.InCluster {
+		path := h.kubeconfigPath
+		if path == "" {
+			path = kubeconfigPath()
+		}
+		return GetClientSetWithKubeconfig(path, h.Context)
+	}
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
+	return clientset, restConfig, nil
+}
+
+// cliArgs are the flags pointing clusteradm and kubectl at the hub. In-cluster
+// hubs need none: the CLIs fall back to the pod service account.
+func (h Hub) cliArgs() []string {
+	var args []string
+	if h.kubeconfigPath != "" {
+		args = append(args, "--kubeconfig", h.kubeconfigPath)
+	}
+	if h.Context != "" {
+		args = append(args, "--context", h.Context)
+	}
+	return args
+}
+
+// HubRegisterRequest is the JSON body accepted by POST /hubs. The hub is
+// reached through inline Kubeconfig content, the service account of the
+// plugin pod (InCluster) or, when both are omitted, Context in the plugin's
+// own kubeconfig.
+type HubRegisterRequest struct {
+	Name string `json:"name" binding:"required"`
+	// Kubeconfig is the raw kubeconfig content of the hub
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Context selects the hub context, defaulting to the current context of Kubeconfig
+	Context   string `json:"context,omitempty"`
+	InCluster bool   `json:"inCluster,omitempty"`
+	// Default makes the hub the one used when a request names none
+	Default bool `json:"default,omitempty"`
+}
+
+// Validate checks the request beyond what the binding tags cover
+func (r HubRegisterRequest) Validate() error {
+	if errs := validation.IsDNS1123Label(r.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid hub name '%s': %s", r.Name, strings.Join(errs, "; "))
+	}
+	if r.InCluster && (r.Kubeconfig != "" || r.Context != "") {
+		return fmt.Errorf("inCluster cannot be combined with kubeconfig or context")
+	}
+	if !r.InCluster && r.Kubeconfig == "" && r.Context == "" {
+		return fmt.Errorf("one of kubeconfig, context and inCluster is required")
+	}
+	return nil
+}
+
+// HubRegistry holds the hubs clusters can be onboarded to, persisted with
+// their kubeconfigs in dir so registrations survive restarts. The kubeconfigs
+// are sealed at rest; plaintext copies live in a private runtime directory
+// until Close.
+type HubRegistry struct {
+	dir         string
+	runtimeDir  string
+	box         *secretBox
+	hubs        map[string]Hub
+	defaultName string
+	mutex       sync.RWMutex
+}
+
+// storedHubs is the registry as persisted in hubs.json
+type storedHubs struct {
+	Default string `json:"default"`
+	Hubs    []Hub  `json:"hubs"`
+}
+
+// NewHubRegistry opens the registry in dir, sealing hub kubeconfigs with
+// box. Until a hub is registered it holds the built-in its1 hub only.
+func NewHubRegistry(dir string, box *secretBox) (*HubRegistry, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create hub directory: %w", err)
+	}
+	runtimeDir, err := os.MkdirTemp("", "hubs-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hub runtime directory: %w", err)
+	}
+	hr := &HubRegistry{
+		dir:         dir,
+		runtimeDir:  runtimeDir,
+		box:         box,
+		hubs:        map[string]Hub{builtinHub.Name: builtinHub},
+		defaultName: builtinHub.Name,
+	}
+	if err := hr.load(); err != nil {
+		hr.Close()
+		return nil, err
+	}
+	return hr, nil
+}
+
+// load reads hubs.json and unseals the kubeconfigs of the stored hubs
+func (hr *HubRegistry) load() error {
+	data, err := os.ReadFile(hr.indexPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read hubs file: %w", err)
+	}
+	var stored storedHubs
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("invalid hubs file: %w", err)
+	}
+	hr.hubs = make(map[string]Hub, len(stored.Hubs))
+	for _, hub := range stored.Hubs {
+		if hub.Kubeconfig {
+			kubeconfig, err := hr.openKubeconfig(hub.Name)
+			if err != nil {
+				return err
+			}
+			hub.kubeconfigPath = hr.runtimeKubeconfigPath(hub.Name)
+			if err := os.WriteFile(hub.kubeconfigPath, kubeconfig, 0600); err != nil {
+				return fmt.Errorf("failed to write kubeconfig of hub '%s': %w", hub.Name, err)
+			}
+		}
+		hub.Default = false
+		hr.hubs[hub.Name] = hub
+	}
+	if _, exists := hr.hubs[stored.Default]; !exists {
+		return fmt.Errorf("invalid hubs file: default hub '%s' not registered", stored.Default)
+	}
+	hr.defaultName = stored.Default
+	return nil
+}
+
+// openKubeconfig reads the sealed kubeconfig of a hub. A plaintext
+// kubeconfig left by an older release is sealed in its place.
+func (hr *HubRegistry) openKubeconfig(name string) ([]byte, error) {
+	sealed, err := os.ReadFile(hr.sealedKubeconfigPath(name))
+	if err == nil {
+		kubeconfig, err := hr.box.Open(sealed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open kubeconfig of hub '%s': %w", name, err)
+		}
+		return kubeconfig, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read kubeconfig of hub '%s': %w", name, err)
+	}
+
+	legacyPath := hr.legacyKubeconfigPath(name)
+	kubeconfig, err := os.ReadFile(legacyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig of hub '%s': %w", name, err)
+	}
+	if err := hr.sealKubeconfig(name, kubeconfig); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(legacyPath); err != nil {
+		logger().Warn("Failed to remove plaintext hub kubeconfig", "hub", name, "error", err)
+	}
+	return kubeconfig, nil
+}
+
+func (hr *HubRegistry) sealKubeconfig(name string, kubeconfig []byte) error {
+	sealed, err := hr.box.Seal(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to seal kubeconfig of hub '%s': %w", name, err)
+	}
+	if err := os.WriteFile(hr.sealedKubeconfigPath(name), sealed, 0600); err != nil {
+		return fmt.Errorf("failed to store kubeconfig of hub '%s': %w", name, err)
+	}
+	return nil
+}
+
+// reseal moves the hub kubeconfigs under the current encryption key
+func (hr *HubRegistry) reseal() (int, error) {
+	return resealFiles(hr.box, filepath.Join(hr.dir, "*.kubeconfig.enc"))
+}
+
+// Close removes the plaintext kubeconfig copies
+func (hr *HubRegistry) Close() {
+	if err := os.RemoveAll(hr.runtimeDir); err != nil {
+		logger().Warn("Failed to remove hub runtime directory", "error", err)
+	}
+}
+
+// Register adds a hub, storing its kubeconfig when one is given
+func (hr *HubRegistry) Register(req HubRegisterRequest) (Hub, error) {
+	hub := Hub{
+		Name:         req.Name,
+		Context:      req.Context,
+		InCluster:    req.InCluster,
+		RegisteredAt: time.Now().Format(time.RFC3339),
+	}
+	if req.Kubeconfig != "" {
+		server, err := hubServer([]byte(req.Kubeconfig), req.Context)
+		if err != nil {
+			return Hub{}, err
+		}
+		hub.Kubeconfig = true
+		hub.Server = server
+	}
+
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	if _, exists := hr.hubs[hub.Name]; exists {
+		return Hub{}, fmt.Errorf("%w: %s", errHubExists, hub.Name)
+	}
+	if hub.Kubeconfig {
+		if err := hr.sealKubeconfig(hub.Name, []byte(req.Kubeconfig)); err != nil {
+			return Hub{}, err
+		}
+		hub.kubeconfigPath = hr.runtimeKubeconfigPath(hub.Name)
+		if err := os.WriteFile(hub.kubeconfigPath, []byte(req.Kubeconfig), 0600); err != nil {
+			os.Remove(hr.sealedKubeconfigPath(hub.Name))
+			return Hub{}, fmt.Errorf("failed to write kubeconfig of hub '%s': %w", hub.Name, err)
+		}
+	}
+	previousDefault := hr.defaultName
+	hr.hubs[hub.Name] = hub
+	if req.Default {
+		hr.defaultName = hub.Name
+	}
+	if err := hr.save(); err != nil {
+		delete(hr.hubs, hub.Name)
+		hr.defaultName = previousDefault
+		if hub.kubeconfigPath != "" {
+			os.Remove(hub.kubeconfigPath)
+			os.Remove(hr.sealedKubeconfigPath(hub.Name))
+		}
+		return Hub{}, err
+	}
+	return hr.describe(hub), nil
+}
+
+// Get returns the named hub, or the default hub when name is empty
+func (hr *HubRegistry) Get(name string) (Hub, error) {
+	hr.mutex.RLock()
+	defer hr.mutex.RUnlock()
+
+	if name == "" {
+		name = hr.defaultName
+	}
+	hub, exists := hr.hubs[name]
+	if !exists {
+		return Hub{}, fmt.Errorf("%w: %s", errHubNotFound, name)
+	}
+	return hr.describe(hub), nil
+}
+
+// List returns every hub sorted by name
+func (hr *HubRegistry) List() []Hub {
+	hr.mutex.RLock()
+	defer hr.mutex.RUnlock()
+
+	hubs := make([]Hub, 0, len(hr.hubs))
+	for _, hub := range hr.hubs {
+		hubs = append(hubs, hr.describe(hub))
+	}
+	sort.Slice(hubs, func(i, j int) bool {
+		return hubs[i].Name < hubs[j].Name
+	})
+	return hubs
+}
+
+// Select makes the named hub the default
+func (hr *HubRegistry) Select(name string) (Hub, error) {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	hub, exists := hr.hubs[name]
+	if !exists {
+		return Hub{}, fmt.Errorf("%w: %s", errHubNotFound, name)
+	}
+	previous := hr.defaultName
+	hr.defaultName = name
+	if err := hr.save(); err != nil {
+		hr.defaultName = previous
+		return Hub{}, err
+	}
+	return hr.describe(hub), nil
+}
+
+// Delete removes a hub other than the default one, together with its kubeconfig
+func (hr *HubRegistry) Delete(name string) error {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	hub, exists := hr.hubs[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", errHubNotFound, name)
+	}
+	if name == hr.defaultName {
+		return fmt.Errorf("hub '%s' is the default, select another hub first", name)
+	}
+	delete(hr.hubs, name)
+	if err := hr.save(); err != nil {
+		hr.hubs[name] = hub
+		return err
+	}
+	if hub.kubeconfigPath != "" {
+		for _, path := range []string{hr.sealedKubeconfigPath(name), hub.kubeconfigPath} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				logger().Warn("Failed to remove hub kubeconfig", "hub", name, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// describe marks the default hub. The caller must hold the mutex.
+func (hr *HubRegistry) describe(hub Hub) Hub {
+	hub.Default = hub.Name == hr.defaultName
+	return hub
+}
+
+// save writes the registry to hubs.json. The caller must hold the mutex.
+func (hr *HubRegistry) save() error {
+	stored := storedHubs{Default: hr.defaultName, Hubs: make([]Hub, 0, len(hr.hubs))}
+	for _, hub := range hr.hubs {
+		stored.Hubs = append(stored.Hubs, hub)
+	}
+	sort.Slice(stored.Hubs, func(i, j int) bool {
+		return stored.Hubs[i].Name < stored.Hubs[j].Name
+	})
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode hubs: %w", err)
+	}
+	tmpPath := hr.indexPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write hubs file: %w", err)
+	}
+	return os.Rename(tmpPath, hr.indexPath())
+}
+
+func (hr *HubRegistry) indexPath() string {
+	return filepath.Join(hr.dir, "hubs.json")
+}
+
+func (hr *HubRegistry) sealedKubeconfigPath(name string) string {
+	return filepath.Join(hr.dir, name+".kubeconfig.enc")
+}
+
+// legacyKubeconfigPath is where older releases stored hub kubeconfigs in plaintext
+func (hr *HubRegistry) legacyKubeconfigPath(name string) string {
+	return filepath.Join(hr.dir, name+".kubeconfig")
+}
+
+func (hr *HubRegistry) runtimeKubeconfigPath(name string) string {
+	return filepath.Join(hr.runtimeDir, name+".kubeconfig")
+}
+
+// hubServer checks that a hub kubeconfig has the context to use and
+// returns the API server it points at
+func hubServer(data []byte, contextName string) (string, error) {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return "", fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+	if contextName == "" {
+		contextName = config.CurrentContext
+	}
+	context, exists := config.Contexts[contextName]
+	if !exists {
+		return "", fmt.Errorf("context '%s' not found in kubeconfig", contextName)
+	}
+	if cluster, exists := config.Clusters[context.Cluster]; exists {
+		return cluster.Server, nil
+	}
+	return "", nil
+}
+
+// clusterHub returns the hub a recorded cluster was onboarded to
+func (cp *ClusterPlugin) clusterHub(clusterName string) (Hub, error) {
+	cp.mutex.RLock()
+	record, _, err := cp.store.Get(clusterName)
+	cp.mutex.RUnlock()
+	if err != nil {
+		return Hub{}, err
+	}
+	return cp.hubs.Get(hubName(record))
+}
+
+// hubName is the hub of a cluster record
+func hubName(record ClusterStatus) string {
+	if record.Hub == "" {
+		return builtinHub.Name
+	}
+	return record.Hub
+}
+
+// ListHubsHandler lists the registered hubs
+func (cp *ClusterPlugin) ListHubsHandler(c *gin.Context) {
+	hubs := cp.hubs.List()
+	defaultHub := ""
+	for _, hub := range hubs {
+		if hub.Default {
+			defaultHub = hub.Name
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"hubs":      hubs,
+		"default":   defaultHub,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// RegisterHubHandler registers a hub clusters can be onboarded to
+func (cp *ClusterPlugin) RegisterHubHandler(c *gin.Context) {
+	var req HubRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload, name is required")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	hub, err := cp.hubs.Register(req)
+	if errors.Is(err, errHubExists) {
+		respondProblem(c, http.StatusConflict, CodeHubExists, err.Error())
+		return
+	}
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	logger().Info("Hub registered", "hub", hub.Name, "default", hub.Default)
+	c.JSON(http.StatusCreated, gin.H{
+		"hub":       hub,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// SelectHubHandler makes a hub the default for requests that name none
+func (cp *ClusterPlugin) SelectHubHandler(c *gin.Context) {
+	hub, err := cp.hubs.Select(c.Param("name"))
+	if errors.Is(err, errHubNotFound) {
+		respondProblem(c, http.StatusNotFound, CodeHubNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	logger().Info("Default hub selected", "hub", hub.Name)
+	c.JSON(http.StatusOK, gin.H{
+		"hub":       hub,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// DeleteHubHandler unregisters a hub no cluster is onboarded to
+func (cp *ClusterPlugin) DeleteHubHandler(c *gin.Context) {
+	name := c.Param("name")
+	if _, err := cp.hubs.Get(name); err != nil {
+		respondProblem(c, http.StatusNotFound, CodeHubNotFound, err.Error())
+		return
+	}
+	cp.mutex.RLock()
+	clusters, err := cp.store.List()
+	cp.mutex.RUnlock()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	for _, cluster := range clusters {
+		if hubName(cluster) == name {
+			respondProblem(c, http.StatusConflict, CodeHubInUse, fmt.Sprintf("Hub '%s' still has onboarded clusters, such as '%s'", name, cluster.ClusterName))
+			return
+		}
+	}
+	if err := cp.hubs.Delete(name); err != nil {
+		respondProblem(c, http.StatusConflict, CodeHubInUse, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     message(c, MsgHubDeleted, "hub", name),
+		"messageCode": MsgHubDeleted,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
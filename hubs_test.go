@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testHubKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: wds2
+  cluster:
+    server: https://wds2.example.com:6443
+contexts:
+- name: its2
+  context:
+    cluster: wds2
+    user: admin
+current-context: its2
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func newTestSecretBox(t *testing.T) *secretBox {
+	t.Helper()
+	box, err := newSecretBox(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return box
+}
+
+func TestHubRegistry(t *testing.T) {
+	dir := t.TempDir()
+	box := newTestSecretBox(t)
+	registry, err := NewHubRegistry(dir, box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hub, err := registry.Get(""); err != nil || hub.Name != builtinHub.Name || !hub.Default {
+		t.Fatalf("default hub = %+v, %v, want the built-in hub", hub, err)
+	}
+
+	hub, err := registry.Register(HubRegisterRequest{Name: "its2", Kubeconfig: testHubKubeconfig})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if hub.Server != "https://wds2.example.com:6443" || !hub.Kubeconfig || hub.Default {
+		t.Errorf("registered hub = %+v", hub)
+	}
+	if want := []string{"--kubeconfig", hub.kubeconfigPath}; !reflect.DeepEqual(hub.cliArgs(), want) {
+		t.Errorf("cliArgs() = %v, want %v", hub.cliArgs(), want)
+	}
+	if sealed, err := os.ReadFile(filepath.Join(dir, "its2.kubeconfig.enc")); err != nil || bytes.Contains(sealed, []byte("token: secret")) {
+		t.Errorf("kubeconfig stored at rest = %q, %v, want it sealed", sealed, err)
+	}
+	if _, err := registry.Register(HubRegisterRequest{Name: "its2", InCluster: true}); !errors.Is(err, errHubExists) {
+		t.Errorf("registering twice error = %v, want %v", err, errHubExists)
+	}
+	if _, err := registry.Register(HubRegisterRequest{Name: "its3", Kubeconfig: testHubKubeconfig, Context: "missing"}); err == nil {
+		t.Error("registered a hub with a context missing from its kubeconfig")
+	}
+	if _, err := registry.Register(HubRegisterRequest{Name: "pod", InCluster: true}); err != nil {
+		t.Fatalf("Register() in-cluster error = %v", err)
+	}
+	if _, err := registry.Select("its2"); err != nil {
+		t.Fatalf("Select() error = %v", err)
+	}
+	if _, err := registry.Select("missing"); !errors.Is(err, errHubNotFound) {
+		t.Errorf("Select() of an unknown hub error = %v", err)
+	}
+
+	// Registrations survive a restart
+	registry.Close()
+	if _, err := os.Stat(hub.kubeconfigPath); !os.IsNotExist(err) {
+		t.Errorf("plaintext kubeconfig kept after Close(): %v", err)
+	}
+	registry, err = NewHubRegistry(dir, box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Close()
+	hub, err = registry.Get("")
+	if err != nil || hub.Name != "its2" || hub.kubeconfigPath == "" {
+		t.Fatalf("default hub after reopening = %+v, %v", hub, err)
+	}
+	if kubeconfig, err := os.ReadFile(hub.kubeconfigPath); err != nil || string(kubeconfig) != testHubKubeconfig {
+		t.Errorf("kubeconfig after reopening = %q, %v", kubeconfig, err)
+	}
+	if got := len(registry.List()); got != 3 {
+		t.Errorf("List() returned %d hubs, want 3", got)
+	}
+
+	if err := registry.Delete("its2"); err == nil {
+		t.Error("deleted the default hub")
+	}
+	registry.Select(builtinHub.Name)
+	if err := registry.Delete("its2"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	for _, path := range []string{hub.kubeconfigPath, filepath.Join(dir, "its2.kubeconfig.enc")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("kubeconfig of the deleted hub was kept at %s: %v", path, err)
+		}
+	}
+}
+
+func TestHubRegistrySealsPlaintextKubeconfig(t *testing.T) {
+	dir := t.TempDir()
+	index := `{"default": "its2", "hubs": [{"name": "its2", "kubeconfig": true}]}`
+	if err := os.WriteFile(filepath.Join(dir, "hubs.json"), []byte(index), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "its2.kubeconfig"), []byte(testHubKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := NewHubRegistry(dir, newTestSecretBox(t))
+	if err != nil {
+		t.Fatalf("NewHubRegistry() error = %v", err)
+	}
+	defer registry.Close()
+	if _, err := os.Stat(filepath.Join(dir, "its2.kubeconfig")); !os.IsNotExist(err) {
+		t.Errorf("plaintext kubeconfig was kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "its2.kubeconfig.enc")); err != nil {
+		t.Errorf("kubeconfig was not sealed: %v", err)
+	}
+	hub, _ := registry.Get("its2")
+	if kubeconfig, err := os.ReadFile(hub.kubeconfigPath); err != nil || string(kubeconfig) != testHubKubeconfig {
+		t.Errorf("kubeconfig = %q, %v", kubeconfig, err)
+	}
+}
+
+func TestHubRegisterRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     HubRegisterRequest
+		wantErr bool
+	}{
+		{name: "kubeconfig", req: HubRegisterRequest{Name: "its2", Kubeconfig: testHubKubeconfig}},
+		{name: "local context", req: HubRegisterRequest{Name: "its2", Context: "its2"}},
+		{name: "in cluster", req: HubRegisterRequest{Name: "its2", InCluster: true}},
+		{name: "no access", req: HubRegisterRequest{Name: "its2"}, wantErr: true},
+		{name: "in cluster with context", req: HubRegisterRequest{Name: "its2", InCluster: true, Context: "its2"}, wantErr: true},
+		{name: "invalid name", req: HubRegisterRequest{Name: "ITS_2", Context: "its2"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHubHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready", Labels: map[string]string{"env": "prod"}})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-2", Status: "Ready", Hub: "its2", Labels: map[string]string{"env": "prod"}})
+	router := gin.New()
+	router.GET("/hubs", plugin.ListHubsHandler)
+	router.POST("/hubs", plugin.RegisterHubHandler)
+	router.POST("/hubs/:name/select", plugin.SelectHubHandler)
+	router.DELETE("/hubs/:name", plugin.DeleteHubHandler)
+	router.POST("/onboard", plugin.OnboardClusterHandler)
+	router.POST("/detach", plugin.DetachClusterHandler)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/hubs", `{"name": "its2", "context": "its2"}`, http.StatusCreated},
+		{http.MethodPost, "/hubs", `{"name": "its2", "inCluster": true}`, http.StatusConflict},
+		{http.MethodPost, "/hubs", `{"name": "its3"}`, http.StatusBadRequest},
+		{http.MethodPost, "/hubs/its2/select", ``, http.StatusOK},
+		{http.MethodPost, "/hubs/its9/select", ``, http.StatusNotFound},
+		{http.MethodPost, "/onboard", `{"clusterName": "edge-3", "hub": "its9", "kubeconfig": "x"}`, http.StatusBadRequest},
+		{http.MethodPost, "/detach", `{"clusterName": "edge-1", "hub": "its2"}`, http.StatusConflict},
+		{http.MethodPost, "/detach", `{"clusterName": "edge-1", "hub": "its9"}`, http.StatusBadRequest},
+		{http.MethodDelete, "/hubs/its2", ``, http.StatusConflict},
+		{http.MethodPost, "/hubs/its1/select", ``, http.StatusOK},
+		{http.MethodDelete, "/hubs/its9", ``, http.StatusNotFound},
+	}
+	for _, step := range steps {
+		if recorder := serve(step.method, step.path, step.body); recorder.Code != step.want {
+			t.Errorf("%s %s %s status = %d, want %d, body %s", step.method, step.path, step.body, recorder.Code, step.want, recorder.Body)
+		}
+	}
+
+	// Detaching by selector only touches the clusters of the named hub
+	recorder := serve(http.MethodPost, "/detach", `{"labelSelector": "env=prod", "hub": "its2", "dryRun": true}`)
+	var selected struct {
+		Items []BatchItem `json:"items"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &selected); err != nil {
+		t.Fatal(err)
+	}
+	if len(selected.Items) != 1 || selected.Items[0].ClusterName != "edge-2" {
+		t.Errorf("detach by selector on its2 selected %+v", selected.Items)
+	}
+
+	plugin.store.Delete("edge-2")
+	if recorder := serve(http.MethodDelete, "/hubs/its2", ``); recorder.Code != http.StatusOK {
+		t.Errorf("DELETE /hubs/its2 status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	var list struct {
+		Hubs    []Hub  `json:"hubs"`
+		Default string `json:"default"`
+	}
+	recorder = serve(http.MethodGet, "/hubs", ``)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Hubs) != 1 || list.Default != builtinHub.Name {
+		t.Errorf("GET /hubs = %+v", list)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/yaml"
+)
+
+// MessageCode identifies a user-facing message. Like ErrorCode it never
+// changes, so UIs can key their own wording off it, while the message next
+// to it is rendered in the language the client asked for.
+type MessageCode string
+
+const (
+	MsgOnboardingStarted          MessageCode = "onboarding.started"
+	MsgOnboardingAlreadyRequested MessageCode = "onboarding.alreadyRequested"
+	MsgDiscoveredOnboardStarted   MessageCode = "discovery.onboardingStarted"
+	MsgBatchStarted               MessageCode = "batch.started"
+	MsgImportStarted              MessageCode = "import.started"
+	MsgTerraformDiscovered        MessageCode = "terraform.discovered"
+	MsgTerraformOnboarding        MessageCode = "terraform.onboarding"
+	MsgProvisionStarted           MessageCode = "provision.started"
+	MsgTeardownStarted            MessageCode = "provision.teardownStarted"
+	MsgDetachStarted              MessageCode = "detach.started"
+	MsgDetachSelectorStarted      MessageCode = "detach.selectorStarted"
+	MsgDetachGroupStarted         MessageCode = "detach.groupStarted"
+	MsgApprovalPendingCluster     MessageCode = "approval.pendingCluster"
+	MsgApprovalPendingSelector    MessageCode = "approval.pendingSelector"
+	MsgApprovalPendingGroup       MessageCode = "approval.pendingGroup"
+	MsgScheduleCreated            MessageCode = "schedule.created"
+	MsgScheduleCancelled          MessageCode = "schedule.cancelled"
+	MsgJobCancelRequested         MessageCode = "job.cancelRequested"
+	MsgRepairStarted              MessageCode = "repair.started"
+	MsgRepairDiagnosisOnly        MessageCode = "repair.diagnosisOnly"
+	MsgRepairNothingFound         MessageCode = "repair.nothingFound"
+	MsgTokenRotated               MessageCode = "token.rotated"
+	MsgGroupCreated               MessageCode = "group.created"
+	MsgGroupUpdated               MessageCode = "group.updated"
+	MsgGroupDeleted               MessageCode = "group.deleted"
+	MsgHubDeleted                 MessageCode = "hub.deleted"
+	MsgContextsStored             MessageCode = "kubeconfig.contextsStored"
+	MsgContextDeleted             MessageCode = "kubeconfig.contextDeleted"
+	MsgWebhookDeleted             MessageCode = "webhook.deleted"
+	MsgReconcileStarted           MessageCode = "reconcile.started"
+	MsgReconcileStopped           MessageCode = "reconcile.stopped"
+	MsgGitPushIgnored             MessageCode = "gitops.pushIgnored"
+	MsgGitSyncStarted             MessageCode = "gitops.syncStarted"
+	MsgScenarioStarted            MessageCode = "scenario.started"
+)
+
+// defaultLanguage is the language of the built-in catalog and of clients
+// that don't send Accept-Language
+const defaultLanguage = "en"
+
+// localizerKey is the gin context key holding the localizer of the request
+const localizerKey = "localizer"
+
+// englishMessages is the built-in catalog. Placeholders in braces are filled
+// from the params a message is rendered with. Problem titles are part of it
+// too, under titleCode.
+var englishMessages = map[MessageCode]string{
+	MsgOnboardingStarted:          "Real cluster '{cluster}' onboarding started via plugin",
+	MsgOnboardingAlreadyRequested: "Cluster '{cluster}' onboarding was already requested",
+	MsgDiscoveredOnboardStarted:   "Discovered cluster '{cluster}' onboarding started via plugin",
+	MsgBatchStarted:               "Batch onboarding of {count} clusters started via plugin",
+	MsgImportStarted:              "Import of {count} clusters started via plugin, {rejected} rejected",
+	MsgTerraformDiscovered:        "Discovered {count} clusters in the state",
+	MsgTerraformOnboarding:        "Discovered {count} clusters in the state, onboarding {onboarding}",
+	MsgProvisionStarted:           "Provisioning {tool} cluster '{cluster}' and onboarding it",
+	MsgTeardownStarted:            "Tearing down {tool} cluster '{cluster}'",
+	MsgDetachStarted:              "Real cluster '{cluster}' detachment started via plugin",
+	MsgDetachSelectorStarted:      "Detachment of {count} clusters matching '{selector}' started via plugin",
+	MsgDetachGroupStarted:         "Detachment of {count} clusters in group '{group}' started via plugin",
+	MsgApprovalPendingCluster:     "Detachment of cluster '{cluster}' awaits approval until {expiresAt}",
+	MsgApprovalPendingSelector:    "Detachment of clusters matching '{selector}' awaits approval until {expiresAt}",
+	MsgApprovalPendingGroup:       "Detachment of clusters in group '{group}' awaits approval until {expiresAt}",
+	MsgScheduleCreated:            "Cluster '{cluster}' {operation} scheduled for {windowStart}",
+	MsgScheduleCancelled:          "Schedule '{schedule}' cancelled",
+	MsgJobCancelRequested:         "Cancellation requested for job '{job}'",
+	MsgRepairStarted:              "Repair of cluster '{cluster}' started",
+	MsgRepairDiagnosisOnly:        "Diagnosis only, nothing was changed",
+	MsgRepairNothingFound:         "No problems found",
+	MsgTokenRotated:               "Join token of cluster '{cluster}' rotated",
+	MsgGroupCreated:               "Group '{group}' created",
+	MsgGroupUpdated:               "Group '{group}' updated",
+	MsgGroupDeleted:               "Group '{group}' deleted",
+	MsgHubDeleted:                 "Hub '{hub}' deleted",
+	MsgContextsStored:             "Stored {count} kubeconfig contexts",
+	MsgContextDeleted:             "Context '{context}' deleted",
+	MsgWebhookDeleted:             "Webhook '{webhook}' deleted",
+	MsgReconcileStarted:           "Reconciling cluster membership",
+	MsgReconcileStopped:           "Stopped reconciling cluster membership",
+	MsgGitPushIgnored:             "Push to {ref} ignored, the source follows branch {branch}",
+	MsgGitSyncStarted:             "Sync of the Git source started",
+	MsgScenarioStarted:            "Scenario '{scenario}' started",
+}
+
+func init() {
+	for code, title := range problemTitles {
+		englishMessages[titleCode(code)] = title
+	}
+}
+
+// titleCode is the message code of the title of a problem code
+func titleCode(code ErrorCode) MessageCode {
+	return MessageCode("problem." + string(code))
+}
+
+// placeholderPattern finds the placeholders of a message
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+
+// languageTagPattern accepts BCP 47 style tags such as de or pt-BR
+var languageTagPattern = regexp.MustCompile(`^[a-z]{2,8}(-[a-z0-9]{1,8})*$`)
+
+// I18nConfig is the i18n section of the Initialize config
+type I18nConfig struct {
+	// DefaultLanguage answers clients that accept none of the catalogs
+	DefaultLanguage string `json:"defaultLanguage,omitempty"`
+	// Catalogs holds translations by language and message code
+	Catalogs map[string]map[MessageCode]string `json:"catalogs,omitempty"`
+	// CatalogDir holds a <language>.yaml or <language>.json file per
+	// language, each mapping message codes to translations
+	CatalogDir string `json:"catalogDir,omitempty"`
+}
+
+// MessageCatalog renders messages in the languages it has translations for.
+// A translation lacking a message falls back to English.
+type MessageCatalog struct {
+	languages       map[string]map[MessageCode]string
+	defaultLanguage string
+}
+
+// newMessageCatalog builds the catalog from the built-in English messages and
+// the translations of the i18n config
+func newMessageCatalog(config map[string]interface{}) (*MessageCatalog, error) {
+	var ic I18nConfig
+	if raw, ok := config["i18n"]; ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid i18n config: %w", err)
+		}
+		if err := json.Unmarshal(data, &ic); err != nil {
+			return nil, fmt.Errorf("invalid i18n config: %w", err)
+		}
+	}
+	mc := &MessageCatalog{
+		languages:       map[string]map[MessageCode]string{defaultLanguage: englishMessages},
+		defaultLanguage: strings.ToLower(ic.DefaultLanguage),
+	}
+	if mc.defaultLanguage == "" {
+		mc.defaultLanguage = defaultLanguage
+	}
+
+	catalogs := map[string]map[MessageCode]string{}
+	if ic.CatalogDir != "" {
+		files, err := filepath.Glob(filepath.Join(ic.CatalogDir, "*"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid i18n config: %w", err)
+		}
+		for _, file := range files {
+			extension := filepath.Ext(file)
+			if extension != ".yaml" && extension != ".yml" && extension != ".json" {
+				continue
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("invalid i18n config: %w", err)
+			}
+			var messages map[MessageCode]string
+			if err := yaml.Unmarshal(data, &messages); err != nil {
+				return nil, fmt.Errorf("invalid i18n catalog %s: %w", file, err)
+			}
+			catalogs[strings.TrimSuffix(filepath.Base(file), extension)] = messages
+		}
+	}
+	// Catalogs in the config override files of the same language
+	for language, messages := range ic.Catalogs {
+		catalogs[language] = messages
+	}
+
+	for language, messages := range catalogs {
+		language = strings.ToLower(language)
+		if !languageTagPattern.MatchString(language) {
+			return nil, fmt.Errorf("invalid i18n config: '%s' is not a language tag", language)
+		}
+		translated := make(map[MessageCode]string, len(messages))
+		for code, message := range messages {
+			english, ok := englishMessages[code]
+			if !ok {
+				return nil, fmt.Errorf("invalid i18n catalog '%s': unknown message code '%s'", language, code)
+			}
+			known := placeholders(english)
+			for name := range placeholders(message) {
+				if !known[name] {
+					return nil, fmt.Errorf("invalid i18n catalog '%s': message '%s' has no placeholder {%s}", language, code, name)
+				}
+			}
+			translated[code] = message
+		}
+		if language == defaultLanguage {
+			// Translations of the default language reword the built-in messages
+			for code, message := range englishMessages {
+				if _, ok := translated[code]; !ok {
+					translated[code] = message
+				}
+			}
+		}
+		mc.languages[language] = translated
+	}
+	if _, ok := mc.languages[mc.defaultLanguage]; !ok {
+		return nil, fmt.Errorf("invalid i18n config: no catalog for default language '%s'", mc.defaultLanguage)
+	}
+	return mc, nil
+}
+
+// placeholders returns the names of the placeholders of a message
+func placeholders(message string) map[string]bool {
+	names := map[string]bool{}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(message, -1) {
+		names[match[1]] = true
+	}
+	return names
+}
+
+// Languages lists the languages with a catalog
+func (mc *MessageCatalog) Languages() []string {
+	languages := make([]string, 0, len(mc.languages))
+	for language := range mc.languages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// negotiate picks the catalog language best matching an Accept-Language
+// header. A region falls back to its base language, so de-AT is served by a
+// de catalog.
+func (mc *MessageCatalog) negotiate(acceptLanguage string) string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var ranges []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, weighted{tag, quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+
+	for _, r := range ranges {
+		if r.tag == "*" {
+			return mc.defaultLanguage
+		}
+		for tag := r.tag; tag != ""; {
+			if _, ok := mc.languages[tag]; ok {
+				return tag
+			}
+			cut := strings.LastIndex(tag, "-")
+			if cut < 0 {
+				break
+			}
+			tag = tag[:cut]
+		}
+	}
+	return mc.defaultLanguage
+}
+
+// render returns a message in the language, with its placeholders filled
+// from params. params alternate names and values, like the attributes of a
+// log call.
+func (mc *MessageCatalog) render(language string, code MessageCode, params ...interface{}) string {
+	message, ok := mc.languages[language][code]
+	if !ok {
+		if message, ok = englishMessages[code]; !ok {
+			return string(code)
+		}
+	}
+	if len(params) == 0 {
+		return message
+	}
+	replacements := make([]string, 0, len(params))
+	for i := 0; i+1 < len(params); i += 2 {
+		name, ok := params[i].(string)
+		if !ok {
+			continue
+		}
+		replacements = append(replacements, "{"+name+"}", fmt.Sprint(params[i+1]))
+	}
+	return strings.NewReplacer(replacements...).Replace(message)
+}
+
+// builtinCatalog renders English when a request wasn't localized, such as in
+// handlers mounted without the plugin's concerns
+var builtinCatalog = &MessageCatalog{
+	languages:       map[string]map[MessageCode]string{defaultLanguage: englishMessages},
+	defaultLanguage: defaultLanguage,
+}
+
+// localizer renders messages in the language negotiated for a request
+type localizer struct {
+	catalog  *MessageCatalog
+	language string
+}
+
+// withLanguage wraps a handler so that its messages are rendered in the
+// language the client accepts. The language is announced in Content-Language.
+func (cp *ClusterPlugin) withLanguage(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		catalog := cp.messages
+		cp.mutex.RUnlock()
+		if catalog == nil {
+			catalog = builtinCatalog
+		}
+		language := catalog.negotiate(c.GetHeader("Accept-Language"))
+		c.Set(localizerKey, localizer{catalog: catalog, language: language})
+		c.Header("Content-Language", language)
+		c.Writer.Header().Add("Vary", "Accept-Language")
+		handler(c)
+	}
+}
+
+// localizerOf returns the localizer of a request, English when it has none
+func localizerOf(c *gin.Context) localizer {
+	if l, ok := c.Get(localizerKey); ok {
+		if l, ok := l.(localizer); ok {
+			return l
+		}
+	}
+	return localizer{catalog: builtinCatalog, language: defaultLanguage}
+}
+
+// message renders a message in the language of the request
+func message(c *gin.Context, code MessageCode, params ...interface{}) string {
+	l := localizerOf(c)
+	return l.catalog.render(l.language, code, params...)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pt-br.yaml"), []byte("group.created: \"Grupo '{group}' criado\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := newMessageCatalog(map[string]interface{}{"i18n": map[string]interface{}{
+		"catalogDir": dir,
+		"catalogs":   map[string]interface{}{"de": map[string]string{"group.created": "Gruppe '{group}' angelegt"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(catalog.Languages(), ","); got != "de,en,pt-br" {
+		t.Errorf("Languages() = %s", got)
+	}
+
+	for _, tt := range []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", "en"},
+		{"de", "de"},
+		{"de-AT, en;q=0.5", "de"},
+		{"fr, de;q=0.8", "de"},
+		{"en;q=0.7, pt-BR", "pt-br"},
+		{"pt-PT", "en"},
+		{"de;q=0, fr", "en"},
+		{"*", "en"},
+	} {
+		if got := catalog.negotiate(tt.acceptLanguage); got != tt.want {
+			t.Errorf("negotiate(%q) = %s, want %s", tt.acceptLanguage, got, tt.want)
+		}
+	}
+
+	if got := catalog.render("de", MsgGroupCreated, "group", "edge"); got != "Gruppe 'edge' angelegt" {
+		t.Errorf("render() = %q", got)
+	}
+	// Untranslated messages fall back to English
+	if got := catalog.render("de", MsgGroupDeleted, "group", "edge"); got != "Group 'edge' deleted" {
+		t.Errorf("render() of an untranslated message = %q", got)
+	}
+}
+
+func TestLocalizedResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"i18n": map[string]interface{}{
+		"catalogs": map[string]interface{}{"de": map[string]string{
+			"group.created":             "Gruppe '{group}' angelegt",
+			"problem.CLUSTER_NOT_FOUND": "Cluster nicht gefunden",
+		}},
+	}})
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, path, language, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Accept-Language", language)
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve(http.MethodPost, "/groups", "de-DE", `{"name": "edge", "selector": "tier=edge"}`)
+	var created struct {
+		Message     string      `json:"message"`
+		MessageCode MessageCode `json:"messageCode"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &created)
+	if created.Message != "Gruppe 'edge' angelegt" || created.MessageCode != MsgGroupCreated || recorder.Header().Get("Content-Language") != "de" {
+		t.Errorf("create group = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// The code and the detail stay the same in every language, the title doesn't
+	for language, title := range map[string]string{"de": "Cluster nicht gefunden", "fr": "Cluster not found"} {
+		recorder := serve(http.MethodPost, "/detach", language, `{"clusterName": "missing"}`)
+		var problem Problem
+		json.Unmarshal(recorder.Body.Bytes(), &problem)
+		if recorder.Code != http.StatusNotFound || problem.Code != CodeClusterNotFound || problem.Title != title {
+			t.Errorf("%s: problem = %d %s", language, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestMessageCatalogValidation(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"unknown code", map[string]interface{}{"catalogs": map[string]interface{}{"de": map[string]string{"group.renamed": "Gruppe umbenannt"}}}},
+		{"unknown placeholder", map[string]interface{}{"catalogs": map[string]interface{}{"de": map[string]string{"group.created": "Gruppe '{name}' angelegt"}}}},
+		{"bad language tag", map[string]interface{}{"catalogs": map[string]interface{}{"de_DE": map[string]string{}}}},
+		{"no default catalog", map[string]interface{}{"defaultLanguage": "fr"}},
+	} {
+		if _, err := newMessageCatalog(map[string]interface{}{"i18n": tt.config}); err == nil {
+			t.Errorf("%s: newMessageCatalog() accepted", tt.name)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// idempotencyKeyHeader lets clients safely retry POST /onboard
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// Conflict policies decide how a duplicate onboarding request is answered
+const (
+	// conflictPolicyReject answers duplicates with 409 Conflict
+	conflictPolicyReject = "conflict"
+	// conflictPolicyReturn answers duplicates with 200 and the existing job
+	conflictPolicyReturn = "return"
+)
+
+// errIdempotencyKeyReused is returned when a key is replayed for another cluster
+var errIdempotencyKeyReused = errors.New("idempotency key was already used for a different cluster")
+
+type idempotencyEntry struct {
+	clusterName string
+	jobID       string
+	expiresAt   time.Time
+}
+
+// idempotencyCache remembers which job an Idempotency-Key started
+type idempotencyCache struct {
+	ttl     time.Duration
+	entries map[string]idempotencyEntry
+	mutex   sync.Mutex
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{ttl: ttl, entries: make(map[string]idempotencyEntry)}
+}
+
+// Lookup returns the entry stored for key if it hasn't expired
+func (ic *idempotencyCache) Lookup(key string) (idempotencyEntry, bool) {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+
+	entry, exists := ic.entries[key]
+	if !exists {
+		return idempotencyEntry{}, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(ic.entries, key)
+		return idempotencyEntry{}, false
+	}
+	return entry, true
+}
+
+// Store records the job started for key, dropping expired entries
+func (ic *idempotencyCache) Store(key, clusterName, jobID string) {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+
+	now := time.Now()
+	for k, entry := range ic.entries {
+		if now.After(entry.expiresAt) {
+			delete(ic.entries, k)
+		}
+	}
+	ic.entries[key] = idempotencyEntry{
+		clusterName: clusterName,
+		jobID:       jobID,
+		expiresAt:   now.Add(ic.ttl),
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		store     bool
+		lookupKey string
+		wantFound bool
+	}{
+		{name: "stored key", ttl: time.Hour, store: true, lookupKey: "key-1", wantFound: true},
+		{name: "unknown key", ttl: time.Hour, store: true, lookupKey: "key-2", wantFound: false},
+		{name: "empty cache", ttl: time.Hour, store: false, lookupKey: "key-1", wantFound: false},
+		{name: "expired key", ttl: -time.Second, store: true, lookupKey: "key-1", wantFound: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newIdempotencyCache(tt.ttl)
+			if tt.store {
+				cache.Store("key-1", "cluster1", "onboard-1")
+			}
+
+			entry, found := cache.Lookup(tt.lookupKey)
+			if found != tt.wantFound {
+				t.Fatalf("Lookup(%q) found = %v, want %v", tt.lookupKey, found, tt.wantFound)
+			}
+			if found && (entry.clusterName != "cluster1" || entry.jobID != "onboard-1") {
+				t.Errorf("Lookup(%q) = %+v, want cluster1/onboard-1", tt.lookupKey, entry)
+			}
+		})
+	}
+}
+
+func TestIdempotencyCacheStoreDropsExpired(t *testing.T) {
+	cache := newIdempotencyCache(-time.Second)
+	cache.Store("old", "cluster1", "onboard-1")
+	cache.Store("new", "cluster2", "onboard-2")
+
+	if _, exists := cache.entries["old"]; exists {
+		t.Errorf("expired entry was not dropped on Store")
+	}
+}
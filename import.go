@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/yaml"
+)
+
+// ImportCluster is one cluster of a manifest posted to POST /clusters/import
+type ImportCluster struct {
+	Name string `json:"name"`
+	// Hub names the registered hub to onboard to, defaulting to the selected one
+	Hub      string            `json:"hub,omitempty"`
+	Provider *ProviderSpec     `json:"provider,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// KubeconfigRef says where the kubeconfig comes from: "context:<name>"
+	// for a context stored through POST /kubeconfigs,
+	// "secret:[<namespace>/]<name>[#<key>]" for a Secret on the hub, or
+	// "local" (the default) for the plugin's local kubeconfig
+	KubeconfigRef string `json:"kubeconfigRef,omitempty"`
+}
+
+// ImportManifest is the YAML form of an import. A bare list of clusters and
+// an inventory from GET /clusters/export are accepted as well.
+type ImportManifest struct {
+	// Labels are added to every cluster of the import
+	Labels   map[string]string `json:"labels,omitempty"`
+	Clusters []ImportCluster   `json:"clusters"`
+}
+
+// importRow is a cluster of a manifest with its position, or the reason the
+// row could not be read
+type importRow struct {
+	row int
+	// file is the file of a Git source the row was read from
+	file    string
+	cluster ImportCluster
+	err     error
+}
+
+// importColumns are the CSV columns understood by an import, keyed by their
+// lower-cased header
+var importColumns = map[string]string{
+	"name":          "name",
+	"clustername":   "name",
+	"hub":           "hub",
+	"provider":      "provider",
+	"region":        "region",
+	"project":       "project",
+	"resourcegroup": "resourceGroup",
+	"labels":        "labels",
+	"kubeconfigref": "kubeconfigRef",
+}
+
+// importFormat tells whether a manifest is CSV or YAML from its file name or
+// media type. JSON is read as YAML.
+func importFormat(filename, contentType string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return "csv"
+	case ".yaml", ".yml", ".json":
+		return "yaml"
+	}
+	switch contentType {
+	case "text/csv", "application/csv":
+		return "csv"
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml", "application/json":
+		return "yaml"
+	}
+	return ""
+}
+
+// parseImportManifest reads the clusters of a manifest. Errors in a single
+// row are kept with the row; the error returned means the manifest as a
+// whole can't be read.
+func parseImportManifest(data []byte, format string) ([]importRow, map[string]string, error) {
+	switch format {
+	case "csv":
+		rows, err := parseImportCSV(data)
+		return rows, nil, err
+	case "yaml":
+		return parseImportYAML(data)
+	}
+	return nil, nil, fmt.Errorf("unsupported manifest format %q, use csv or yaml", format)
+}
+
+func parseImportYAML(data []byte) ([]importRow, map[string]string, error) {
+	var manifest ImportManifest
+	trimmed := bytes.TrimSpace(data)
+	isList := bytes.HasPrefix(trimmed, []byte("-")) || bytes.HasPrefix(trimmed, []byte("["))
+	var document struct {
+		Kind string `json:"kind"`
+	}
+	if !isList {
+		_ = yaml.Unmarshal(data, &document)
+	}
+
+	switch {
+	case document.Kind == inventoryKind:
+		// Clusters move to the hub the import names, not the one exported from
+		var inventory Inventory
+		if err := yaml.Unmarshal(data, &inventory); err != nil {
+			return nil, nil, fmt.Errorf("invalid inventory: %w", err)
+		}
+		for _, cluster := range inventory.Clusters {
+			manifest.Clusters = append(manifest.Clusters, ImportCluster{Name: cluster.Name, Labels: cluster.Labels})
+		}
+	case isList:
+		if err := yaml.UnmarshalStrict(data, &manifest.Clusters); err != nil {
+			return nil, nil, fmt.Errorf("invalid manifest: %w", err)
+		}
+	default:
+		if err := yaml.UnmarshalStrict(data, &manifest); err != nil {
+			return nil, nil, fmt.Errorf("invalid manifest: %w", err)
+		}
+	}
+
+	rows := make([]importRow, 0, len(manifest.Clusters))
+	for i, cluster := range manifest.Clusters {
+		rows = append(rows, importRow{row: i + 1, cluster: cluster})
+	}
+	return rows, manifest.Labels, nil
+}
+
+// parseImportCSV reads a CSV manifest whose first line names the columns.
+// Rows are numbered by the line they start on.
+func parseImportCSV(data []byte) ([]importRow, error) {
+	// Spreadsheets exporting UTF-8 CSV often start it with a byte order mark
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	columns := make([]string, len(header))
+	hasName := false
+	for i, name := range header {
+		column, ok := importColumns[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		columns[i] = column
+		hasName = hasName || column == "name"
+	}
+	if !hasName {
+		return nil, fmt.Errorf("CSV header must include a name column")
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		row := importRow{row: line}
+		if len(record) > len(columns) {
+			row.err = fmt.Errorf("row has %d fields, the header names %d", len(record), len(columns))
+			rows = append(rows, row)
+			continue
+		}
+		provider := ProviderSpec{}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch columns[i] {
+			case "name":
+				row.cluster.Name = value
+			case "hub":
+				row.cluster.Hub = value
+			case "provider":
+				provider.Name = value
+			case "region":
+				provider.Region = value
+			case "project":
+				provider.Project = value
+			case "resourceGroup":
+				provider.ResourceGroup = value
+			case "kubeconfigRef":
+				row.cluster.KubeconfigRef = value
+			case "labels":
+				labels, err := parseLabelList(value)
+				if err != nil {
+					row.err = err
+				}
+				row.cluster.Labels = labels
+			}
+		}
+		if provider != (ProviderSpec{}) {
+			row.cluster.Provider = &provider
+		}
+		rows = append(rows, row)
+	}
+}
+
+// parseLabelList reads labels written as key=value pairs separated by commas
+// or semicolons
+func parseLabelList(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	labels := map[string]string{}
+	for _, pair := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+		key, labelValue, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("label %q is not a key=value pair", pair)
+		}
+		labels[key] = labelValue
+	}
+	return labels, nil
+}
+
+// onboardRequest turns an imported cluster into the request that onboards it
+func (ic ImportCluster) onboardRequest() (OnboardRequest, error) {
+	req := OnboardRequest{ClusterName: ic.Name, Hub: ic.Hub, Labels: ic.Labels, Provider: ic.Provider}
+	if ic.Name == "" {
+		return req, fmt.Errorf("name is required")
+	}
+
+	kind, ref, _ := strings.Cut(ic.KubeconfigRef, ":")
+	switch kind {
+	case "", "local":
+	case "context":
+		if ref == "" {
+			return req, fmt.Errorf("kubeconfigRef %q names no context", ic.KubeconfigRef)
+		}
+		req.Context = ref
+	case "secret":
+		ref, key, _ := strings.Cut(ref, "#")
+		namespace, name, found := strings.Cut(ref, "/")
+		if !found {
+			namespace, name = "", ref
+		}
+		if name == "" {
+			return req, fmt.Errorf("kubeconfigRef %q names no secret", ic.KubeconfigRef)
+		}
+		req.KubeconfigSecretRef = &SecretReference{Namespace: namespace, Name: name, Key: key}
+	default:
+		return req, fmt.Errorf("kubeconfigRef %q must be local, context:<name> or secret:[<namespace>/]<name>[#<key>]", ic.KubeconfigRef)
+	}
+	return req, nil
+}
+
+// readImportManifest reads the manifest of an import, sent either as the
+// request body or as the "manifest" file of a multipart form, and tells its
+// format. It answers the request and returns false when the manifest can't
+// be read.
+func (cp *ClusterPlugin) readImportManifest(c *gin.Context) ([]byte, string, bool) {
+	limit := cp.uploadLimit()
+	tooLarge := func() ([]byte, string, bool) {
+		respondProblem(c, http.StatusRequestEntityTooLarge, CodePayloadTooLarge,
+			fmt.Sprintf("Manifest exceeds the limit of %d bytes", limit), "maxBytes", limit)
+		return nil, "", false
+	}
+	var maxBytesErr *http.MaxBytesError
+
+	format := c.Query("format")
+	var data []byte
+	if c.ContentType() == "multipart/form-data" {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+		file, err := c.FormFile("manifest")
+		if errors.As(err, &maxBytesErr) {
+			return tooLarge()
+		}
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "A manifest file is required")
+			return nil, "", false
+		}
+		if file.Size > limit {
+			return tooLarge()
+		}
+		f, err := file.Open()
+		if err != nil {
+			respondProblem(c, http.StatusInternalServerError, CodeInternal, "Failed to open manifest file")
+			return nil, "", false
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			respondProblem(c, http.StatusInternalServerError, CodeInternal, "Failed to read manifest file")
+			return nil, "", false
+		}
+		if format == "" {
+			format = importFormat(file.Filename, file.Header.Get("Content-Type"))
+		}
+	} else {
+		var err error
+		data, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+		if errors.As(err, &maxBytesErr) {
+			return tooLarge()
+		}
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Failed to read manifest")
+			return nil, "", false
+		}
+		if format == "" {
+			format = importFormat("", c.ContentType())
+		}
+	}
+
+	if format != "csv" && format != "yaml" {
+		respondProblem(c, http.StatusUnsupportedMediaType, CodeInvalidRequest,
+			"Manifest must be CSV or YAML, set the Content-Type or the format parameter")
+		return nil, "", false
+	}
+	return data, format, true
+}
+
+// ImportClustersHandler onboards the clusters described by a CSV or YAML
+// manifest as a batch. Rows that can't be onboarded are reported rather than
+// failing the import.
+func (cp *ClusterPlugin) ImportClustersHandler(c *gin.Context) {
+	data, format, ok := cp.readImportManifest(c)
+	if !ok {
+		return
+	}
+	rows, labels, err := parseImportManifest(data, format)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Manifest describes no clusters")
+		return
+	}
+	if len(rows) > cp.maxBatchSize {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Manifest exceeds the maximum of %d clusters", cp.maxBatchSize))
+		return
+	}
+	if err := validateLabels(labels); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	dryRun := c.Query("dryRun") == "true"
+
+	batch := &Batch{
+		ID:        newJobID("import"),
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Source:    "import",
+	}
+	batch.Tenant, _ = tenantFromContext(c.Request.Context())
+	var tasks []batchTask
+	for _, row := range rows {
+		spec, err := row.cluster.onboardRequest()
+		if row.err != nil {
+			err = row.err
+		}
+		if err != nil {
+			batch.Items = append(batch.Items, BatchItem{Row: row.row, ClusterName: row.cluster.Name, Error: err.Error()})
+			continue
+		}
+		spec.DryRun = dryRun
+		item, task := cp.submitBatchCluster(c.Request.Context(), spec, labels)
+		item.Row = row.row
+		if task != nil {
+			tasks = append(tasks, *task)
+		}
+		batch.Items = append(batch.Items, item)
+	}
+
+	cp.batches.Add(batch)
+	go cp.runBatch(batch.ID, tasks)
+
+	requestLogger(c).Info("Clusters imported", "import", batch.ID, "format", format, "rows", len(rows), "jobs", len(tasks))
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     message(c, MsgImportStarted, "count", len(tasks), "rejected", len(rows)-len(tasks)),
+		"messageCode": MsgImportStarted,
+		"importId":    batch.ID,
+		"items":       batch.Items,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// importReportHeader names the columns of the CSV import report
+var importReportHeader = []string{"row", "clusterName", "jobId", "state", "error"}
+
+// GetImportReportHandler returns the outcome of each row of an import, as a
+// CSV download by default or as JSON with format=json
+func (cp *ClusterPlugin) GetImportReportHandler(c *gin.Context) {
+	id := c.Param("id")
+	batch, exists := cp.batches.Get(id)
+	if !exists || batch.Source != "import" || !visibleTo(c.Request.Context(), batch.Tenant) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Import '%s' not found", id))
+		return
+	}
+	state, summary := cp.refreshBatch(&batch)
+	// The job tells why an onboarding that was started failed
+	for i := range batch.Items {
+		item := &batch.Items[i]
+		if item.State == JobFailed || item.State == JobCancelled || item.State == JobTimedOut {
+			if job, ok := cp.jobs.Get(item.JobID); ok && item.Error == "" {
+				item.Error = job.Message
+			}
+		}
+	}
+
+	switch c.DefaultQuery("format", "csv") {
+	case "json":
+		c.JSON(http.StatusOK, gin.H{
+			"import":    batch,
+			"state":     state,
+			"summary":   summary,
+			"plugin":    "kubestellar-cluster-plugin",
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+	case "csv":
+		var report bytes.Buffer
+		writer := csv.NewWriter(&report)
+		_ = writer.Write(importReportHeader)
+		for _, item := range batch.Items {
+			itemState := string(item.State)
+			if item.JobID == "" {
+				itemState = "Rejected"
+				if item.DryRun != nil && item.Error == "" {
+					itemState = "Validated"
+				}
+			}
+			_ = writer.Write([]string{strconv.Itoa(item.Row), item.ClusterName, item.JobID, itemState, item.Error})
+		}
+		writer.Flush()
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", batch.ID+"-report.csv"))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", report.Bytes())
+	default:
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "format must be csv or json")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	data := "\ufeffname,provider,region,labels,kubeconfigRef\n" +
+		"# staging clusters\n" +
+		"edge-1,,,\"env=prod,tier=web\",context:edge-1\n" +
+		"edge-2,eks,eu-west-1,,\n" +
+		"edge-3,,,env,\n" +
+		"edge-4,,,,,extra\n"
+	rows, err := parseImportCSV([]byte(data))
+	if err != nil {
+		t.Fatalf("parseImportCSV() error = %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("parseImportCSV() = %d rows, want 4", len(rows))
+	}
+	if rows[0].row != 3 || rows[0].cluster.Labels["tier"] != "web" || rows[0].cluster.KubeconfigRef != "context:edge-1" {
+		t.Errorf("row 1 = %+v", rows[0])
+	}
+	if provider := rows[1].cluster.Provider; provider == nil || provider.Name != "eks" || provider.Region != "eu-west-1" {
+		t.Errorf("row 2 provider = %+v", provider)
+	}
+	if rows[2].err == nil || rows[3].err == nil {
+		t.Errorf("rows 3 and 4 = %v, %v, want errors", rows[2].err, rows[3].err)
+	}
+
+	if _, err := parseImportCSV([]byte("name,kubeconfig\nedge-1,x\n")); err == nil || !strings.Contains(err.Error(), "unknown CSV column") {
+		t.Errorf("unknown column error = %v", err)
+	}
+	if _, err := parseImportCSV([]byte("hub\ndefault\n")); err == nil {
+		t.Error("a header without a name column was accepted")
+	}
+}
+
+func TestParseImportYAML(t *testing.T) {
+	rows, labels, err := parseImportYAML([]byte(`
+labels:
+  imported: "true"
+clusters:
+- name: edge-1
+  provider:
+    name: gke
+    project: fleet
+- name: edge-2
+  kubeconfigRef: secret:spokes/edge-2#config
+`))
+	if err != nil {
+		t.Fatalf("parseImportYAML() error = %v", err)
+	}
+	if len(rows) != 2 || rows[1].row != 2 || labels["imported"] != "true" || rows[0].cluster.Provider.Project != "fleet" {
+		t.Errorf("parseImportYAML() = %+v, %v", rows, labels)
+	}
+
+	rows, _, err = parseImportYAML([]byte("- name: edge-1\n- name: edge-2\n"))
+	if err != nil || len(rows) != 2 {
+		t.Errorf("bare list = %+v, %v", rows, err)
+	}
+	if _, _, err := parseImportYAML([]byte("clusters:\n- name: edge-1\n  kubeconfig: inline\n")); err == nil {
+		t.Error("an unknown field was accepted")
+	}
+}
+
+func TestImportClusterOnboardRequest(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    OnboardRequest
+		wantErr bool
+	}{
+		{ref: "", want: OnboardRequest{ClusterName: "edge-1"}},
+		{ref: "local", want: OnboardRequest{ClusterName: "edge-1"}},
+		{ref: "context:prod", want: OnboardRequest{ClusterName: "edge-1", Context: "prod"}},
+		{ref: "secret:spokes/edge-1#config", want: OnboardRequest{ClusterName: "edge-1", KubeconfigSecretRef: &SecretReference{Namespace: "spokes", Name: "edge-1", Key: "config"}}},
+		{ref: "secret:edge-1", want: OnboardRequest{ClusterName: "edge-1", KubeconfigSecretRef: &SecretReference{Name: "edge-1"}}},
+		{ref: "secret:spokes/", wantErr: true},
+		{ref: "file:/tmp/config", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := ImportCluster{Name: "edge-1", KubeconfigRef: tt.ref}.onboardRequest()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("onboardRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			gotRef, wantRef := got.KubeconfigSecretRef, tt.want.KubeconfigSecretRef
+			if got.Context != tt.want.Context || (gotRef == nil) != (wantRef == nil) || (gotRef != nil && *gotRef != *wantRef) {
+				t.Errorf("onboardRequest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportClustersHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	const prefix = "/api/plugins/kubestellar-cluster-plugin/v1"
+
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, prefix+"/clusters/import", strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := post("text/csv", "name,kubeconfigRef\nEdge_1,\nedge-2,context:missing\n")
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("import = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		ImportID string      `json:"importId"`
+		Items    []BatchItem `json:"items"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Items) != 2 || response.Items[0].Row != 2 || response.Items[1].Row != 3 {
+		t.Fatalf("items = %+v", response.Items)
+	}
+	for _, item := range response.Items {
+		if item.JobID != "" || item.Error == "" {
+			t.Errorf("item %+v was not rejected", item)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, prefix+"/clusters/import/"+response.ImportID+"/report", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("report = %d %v", recorder.Code, recorder.Header())
+	}
+	records, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || strings.Join(records[0], ",") != strings.Join(importReportHeader, ",") ||
+		records[1][0] != "2" || records[1][3] != "Rejected" {
+		t.Errorf("report = %v", records)
+	}
+
+	if recorder := post("application/octet-stream", "name\nedge-1\n"); recorder.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("unknown format = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := post("application/yaml", "clusters: []\n"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("empty manifest = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, prefix+"/clusters/import/batch-unknown/report", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown import report = %d", recorder.Code)
+	}
+}
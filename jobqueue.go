@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// JobBackendConfig is the jobBackend section of the Initialize config. With
+// several replicas, onboarding and detach jobs are queued on Redis or NATS
+// JetStream instead of running on the replica that accepted them, so any
+// replica can pick them up, and the job records are shared so every replica
+// reports every job. The replicas must share the encryption key, which seals
+// the kubeconfigs queued with the jobs.
+type JobBackendConfig struct {
+	// Type is redis or nats
+	Type string `json:"type"`
+	// URL is a redis:// or rediss:// URL, or a NATS server URL
+	URL string `json:"url"`
+	// Prefix namespaces the keys, or the stream and bucket, of the plugin
+	Prefix string `json:"prefix,omitempty"`
+	// Identity names this replica, the hostname by default. Redis requeues
+	// the jobs a replica was running right away when a replica with the same
+	// identity starts, and otherwise once their lease expires.
+	Identity string `json:"identity,omitempty"`
+	// AckWaitSeconds is how long NATS waits for a sign of progress from a
+	// replica running a job, or Redis leases a job to one, before handing it
+	// to another one
+	AckWaitSeconds int `json:"ackWaitSeconds,omitempty"`
+	// Consume, true by default, makes this replica run queued jobs
+	Consume *bool `json:"consume,omitempty"`
+}
+
+func jobBackendConfigFromConfig(config map[string]interface{}) (*JobBackendConfig, error) {
+	raw, ok := config["jobBackend"]
+	if !ok {
+		return nil, nil
+	}
+	jc := JobBackendConfig{Prefix: "kubestellar-jobs", AckWaitSeconds: 60}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jobBackend config: %w", err)
+	}
+	if err := json.Unmarshal(data, &jc); err != nil {
+		return nil, fmt.Errorf("invalid jobBackend config: %w", err)
+	}
+	if jc.Type != "redis" && jc.Type != "nats" {
+		return nil, fmt.Errorf("invalid jobBackend config: type must be redis or nats")
+	}
+	if jc.URL == "" {
+		return nil, fmt.Errorf("invalid jobBackend config: url is required")
+	}
+	if jc.AckWaitSeconds <= 0 {
+		return nil, fmt.Errorf("invalid jobBackend config: ackWaitSeconds must be positive")
+	}
+	if jc.Identity == "" {
+		jc.Identity, _ = os.Hostname()
+		if jc.Identity == "" {
+			jc.Identity = newJobID("replica")
+		}
+	}
+	return &jc, nil
+}
+
+// consumes reports whether this replica runs queued jobs
+func (jc JobBackendConfig) consumes() bool {
+	return jc.Consume == nil || *jc.Consume
+}
+
+// JobBackend shares job records and queued jobs between the replicas
+type JobBackend interface {
+	SaveJob(job Job) error
+	DeleteJob(id string) error
+	LoadJob(id string) (Job, bool, error)
+	LoadJobs() ([]Job, error)
+	// Enqueue hands a job over to the replicas
+	Enqueue(job queuedJob) error
+	// Dequeue waits for a queued job, returning nil once ctx is done
+	Dequeue(ctx context.Context) (*jobDelivery, error)
+	Close() error
+}
+
+// queuedJob is what a replica needs to run a job accepted by another one
+type queuedJob struct {
+	Job  Job          `json:"job"`
+	Spec resumableJob `json:"spec"`
+	// Cluster is the record of the cluster when the job was queued
+	Cluster *ClusterStatus `json:"cluster,omitempty"`
+}
+
+// jobDelivery is a queued job handed to this replica. It stays queued for
+// the others until acked.
+type jobDelivery struct {
+	job queuedJob
+	// ack removes the job from the queue, nak requeues it right away
+	ack func() error
+	nak func() error
+	// touch tells the backend the job is still running
+	touch func() error
+}
+
+func newJobBackend(config JobBackendConfig) (JobBackend, error) {
+	switch config.Type {
+	case "nats":
+		return newNATSJobBackend(config)
+	default:
+		return newRedisJobBackend(config)
+	}
+}
+
+// jobConsumer runs the jobs queued by any replica
+type jobConsumer struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running sync.WaitGroup
+}
+
+// startJobConsumer dequeues jobs for as long as the pool admits more
+func (cp *ClusterPlugin) startJobConsumer() {
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer := &jobConsumer{cancel: cancel, done: make(chan struct{})}
+	cp.consumer = consumer
+
+	go func() {
+		defer close(consumer.done)
+		for ctx.Err() == nil {
+			if err := cp.jobs.Admit(); err != nil {
+				sleepContext(ctx, time.Second)
+				continue
+			}
+			delivery, err := cp.jobBackend.Dequeue(ctx)
+			if err != nil {
+				logger().Warn("Failed to dequeue job", "error", err)
+				sleepContext(ctx, time.Second)
+				continue
+			}
+			if delivery == nil {
+				continue
+			}
+			consumer.running.Add(1)
+			go func() {
+				defer consumer.running.Done()
+				cp.runQueuedJob(delivery)
+			}()
+		}
+	}()
+}
+
+// stopJobConsumer stops dequeuing jobs. The jobs already dequeued keep
+// running until the job manager drains them.
+func (cp *ClusterPlugin) stopJobConsumer() {
+	if cp.consumer == nil {
+		return
+	}
+	cp.consumer.cancel()
+	<-cp.consumer.done
+}
+
+// waitQueuedJobs waits up to timeout for the dequeued jobs to be acked
+func (cp *ClusterPlugin) waitQueuedJobs(timeout time.Duration) {
+	if cp.consumer == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		cp.consumer.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logger().Warn("Queued jobs still running after shutdown, leaving them to the job queue")
+	}
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// runJob starts a job. With a job backend, onboarding and detach jobs are
+// queued instead, for whichever replica dequeues them first.
+func (cp *ClusterPlugin) runJob(jobID string, body func(ctx context.Context) error) {
+	if cp.jobBackend != nil {
+		err := cp.enqueueJob(jobID)
+		if err == nil {
+			return
+		}
+		logger().Warn("Failed to queue job, running it on this replica", "job", jobID, "error", err)
+	}
+	cp.jobs.Run(jobID, body)
+}
+
+// enqueueJob hands a pending job over to the job queue. On failure the job
+// stays with this replica.
+func (cp *ClusterPlugin) enqueueJob(jobID string) error {
+	cp.mutex.Lock()
+	spec, tracked := cp.resumable[jobID]
+	if !tracked || (spec.Type != "onboard" && spec.Type != "detach") {
+		cp.mutex.Unlock()
+		return fmt.Errorf("only onboarding and detach jobs are queued")
+	}
+	delete(cp.resumable, jobID)
+	cluster, found, _ := cp.store.Get(spec.ClusterName)
+	cp.mutex.Unlock()
+
+	job, _ := cp.jobs.Get(jobID)
+	queued := queuedJob{Job: job, Spec: spec}
+	if found {
+		queued.Cluster = &cluster
+	}
+	var err error
+	if len(spec.kubeconfig) > 0 {
+		if queued.Spec.SealedKubeconfig, err = cp.box.Seal(spec.kubeconfig); err != nil {
+			cp.trackJob(spec)
+			return fmt.Errorf("failed to seal kubeconfig: %w", err)
+		}
+	}
+
+	cp.jobs.Release(jobID)
+	if err = cp.jobBackend.Enqueue(queued); err != nil {
+		cp.trackJob(spec)
+		cp.jobs.Adopt(job, "Job queue unavailable, running on this replica")
+		return err
+	}
+	logger().Info("Queued job", "job", jobID, "type", spec.Type, "cluster", spec.ClusterName)
+	return nil
+}
+
+// runQueuedJob runs a job dequeued from the job queue and acks it. A job
+// cancelled by a shutdown is requeued for another replica.
+func (cp *ClusterPlugin) runQueuedJob(delivery *jobDelivery) {
+	queued := delivery.job
+	id := queued.Job.ID
+	// A job redelivered after it succeeded, its ack lost, must not run twice
+	if job, found, err := cp.jobBackend.LoadJob(id); err == nil && found && job.State == JobSucceeded {
+		if err := delivery.ack(); err != nil {
+			logger().Warn("Failed to ack job", "job", id, "error", err)
+		}
+		return
+	}
+
+	spec := queued.Spec
+	var body func(ctx context.Context) error
+	if len(spec.SealedKubeconfig) > 0 {
+		kubeconfig, err := cp.box.Open(spec.SealedKubeconfig)
+		if err != nil {
+			err = fmt.Errorf("failed to open the queued kubeconfig, check the replicas share the encryption key: %w", err)
+			body = func(context.Context) error { return err }
+		}
+		spec.kubeconfig = kubeconfig
+	}
+	if queued.Cluster != nil {
+		cp.mutex.Lock()
+		if _, found, _ := cp.store.Get(queued.Cluster.ClusterName); !found {
+			cp.putStatus(*queued.Cluster)
+		}
+		cp.mutex.Unlock()
+	}
+	if body == nil {
+		body = cp.resumeJob(spec, "")
+	}
+
+	cp.jobs.Adopt(queued.Job, fmt.Sprintf("Picked up from the job queue by %s", cp.jobQueueIdentity))
+	logger().Info("Running queued job", "job", id, "type", spec.Type, "cluster", spec.ClusterName)
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(cp.jobTouchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := delivery.touch(); err != nil {
+					logger().Warn("Failed to report job progress to the job queue", "job", id, "error", err)
+				}
+			}
+		}
+	}()
+	cp.jobs.Execute(id, body)
+	close(stop)
+
+	settle, action := delivery.ack, "ack"
+	if job, _ := cp.jobs.Get(id); job.State == JobCancelled && cp.jobs.Draining() {
+		settle, action = delivery.nak, "requeue"
+	}
+	if err := settle(); err != nil {
+		logger().Warn("Failed to "+action+" job", "job", id, "error", err)
+	}
+}
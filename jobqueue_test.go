@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeRedis serves the commands of the redis job backend from memory
+type fakeRedis struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	hashes   map[string]map[string]string
+	lists    map[string][]string
+	sets     map[string]map[string]bool
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := &fakeRedis{listener: listener, hashes: map[string]map[string]string{}, lists: map[string][]string{}, sets: map[string]map[string]bool{}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fr.serve(conn)
+		}
+	}()
+	return fr
+}
+
+func (fr *fakeRedis) url() string {
+	return "redis://" + fr.listener.Addr().String() + "/0"
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, count)
+		for i := range args {
+			reader.ReadString('\n')
+			arg, _ := reader.ReadString('\n')
+			args[i] = strings.TrimSuffix(arg, "\r\n")
+		}
+		conn.Write([]byte(fr.execute(args)))
+	}
+}
+
+func bulk(value string) string {
+	return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
+
+// execute runs a command, blocking BRPOPLPUSH by polling
+func (fr *fakeRedis) execute(args []string) string {
+	if strings.ToUpper(args[0]) == "BRPOPLPUSH" {
+		timeout, _ := strconv.Atoi(args[3])
+		deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+		for {
+			if reply := fr.execute([]string{"RPOPLPUSH", args[1], args[2]}); reply != "$-1\r\n" || time.Now().After(deadline) {
+				return reply
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING", "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "HSET":
+		if fr.hashes[args[1]] == nil {
+			fr.hashes[args[1]] = map[string]string{}
+		}
+		fr.hashes[args[1]][args[2]] = args[3]
+		return ":1\r\n"
+	case "HGET":
+		value, ok := fr.hashes[args[1]][args[2]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "HDEL":
+		delete(fr.hashes[args[1]], args[2])
+		return ":1\r\n"
+	case "HGETALL":
+		reply := "*" + strconv.Itoa(2*len(fr.hashes[args[1]])) + "\r\n"
+		for field, value := range fr.hashes[args[1]] {
+			reply += bulk(field) + bulk(value)
+		}
+		return reply
+	case "LPUSH":
+		fr.lists[args[1]] = append([]string{args[2]}, fr.lists[args[1]]...)
+		return ":1\r\n"
+	case "RPUSH":
+		fr.lists[args[1]] = append(fr.lists[args[1]], args[2])
+		return ":1\r\n"
+	case "RPOPLPUSH":
+		source := fr.lists[args[1]]
+		if len(source) == 0 {
+			return "$-1\r\n"
+		}
+		value := source[len(source)-1]
+		fr.lists[args[1]] = source[:len(source)-1]
+		fr.lists[args[2]] = append([]string{value}, fr.lists[args[2]]...)
+		return bulk(value)
+	case "LRANGE":
+		reply := "*" + strconv.Itoa(len(fr.lists[args[1]])) + "\r\n"
+		for _, value := range fr.lists[args[1]] {
+			reply += bulk(value)
+		}
+		return reply
+	case "LLEN":
+		return ":" + strconv.Itoa(len(fr.lists[args[1]])) + "\r\n"
+	case "SADD":
+		if fr.sets[args[1]] == nil {
+			fr.sets[args[1]] = map[string]bool{}
+		}
+		fr.sets[args[1]][args[2]] = true
+		return ":1\r\n"
+	case "SREM":
+		delete(fr.sets[args[1]], args[2])
+		return ":1\r\n"
+	case "SMEMBERS":
+		reply := "*" + strconv.Itoa(len(fr.sets[args[1]])) + "\r\n"
+		for member := range fr.sets[args[1]] {
+			reply += bulk(member)
+		}
+		return reply
+	case "LREM":
+		list := fr.lists[args[1]]
+		for i, value := range list {
+			if value == args[3] {
+				fr.lists[args[1]] = append(list[:i:i], list[i+1:]...)
+				return ":1\r\n"
+			}
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func (fr *fakeRedis) list(key string) []string {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	return append([]string(nil), fr.lists[key]...)
+}
+
+func newQueuePlugin(t *testing.T, redis *fakeRedis, identity string, consume bool) *ClusterPlugin {
+	return newTestPluginWithConfig(t, map[string]interface{}{
+		"mode":                modeMock,
+		"mockFleetSize":       0,
+		"mockStepDelayMillis": 1,
+		"jobBackend": map[string]interface{}{
+			"type":     "redis",
+			"url":      redis.url(),
+			"identity": identity,
+			"consume":  consume,
+		},
+	})
+}
+
+func TestQueuedJobRunsOnAnotherReplica(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	redis := newFakeRedis(t)
+	front := newQueuePlugin(t, redis, "front", false)
+	worker := newQueuePlugin(t, redis, "worker", true)
+
+	router := gin.New()
+	if err := mountEndpoints(router, front.GetMetadata(), front.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/onboard?name=edge-1", nil))
+	var onboard OnboardResponse
+	json.Unmarshal(recorder.Body.Bytes(), &onboard)
+	if recorder.Code != http.StatusOK || onboard.JobID == "" {
+		t.Fatalf("onboard = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// The accepting replica reports the job the other one runs
+	job := waitForJob(t, front.jobs, onboard.JobID, func(job Job) bool { return job.Finished() })
+	if job.State != JobSucceeded {
+		t.Fatalf("queued job = %s %q", job.State, job.Message)
+	}
+	pickedUp := false
+	for _, step := range job.Steps {
+		pickedUp = pickedUp || step.Message == "Picked up from the job queue by worker"
+	}
+	if !pickedUp {
+		t.Errorf("steps = %+v", job.Steps)
+	}
+	if status, found, _ := worker.store.Get("edge-1"); !found || status.JobID != onboard.JobID {
+		t.Errorf("worker record = %+v, %v", status, found)
+	}
+	if jobs := front.jobs.List(); len(jobs) != 1 || jobs[0].ID != onboard.JobID {
+		t.Errorf("front jobs = %+v", jobs)
+	}
+	if err := front.jobs.Cancel(onboard.JobID); err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Errorf("Cancel() of a finished remote job = %v", err)
+	}
+	if processing := redis.list("kubestellar-jobs:processing:worker"); len(processing) != 0 {
+		t.Errorf("job left unacked: %v", processing)
+	}
+}
+
+func TestInterruptedQueuedJobsAreRequeued(t *testing.T) {
+	redis := newFakeRedis(t)
+	// A replica stopped while running this job
+	payload, _ := json.Marshal(queuedJob{
+		Job:  Job{ID: "onboard-interrupted", Type: "onboard", ClusterName: "edge-2", State: JobRunning, CreatedAt: time.Now().Format(time.RFC3339)},
+		Spec: resumableJob{JobID: "onboard-interrupted", Type: "onboard", ClusterName: "edge-2"},
+	})
+	redis.lists["kubestellar-jobs:processing:worker"] = []string{string(payload)}
+
+	worker := newQueuePlugin(t, redis, "worker", true)
+	job := waitForJob(t, worker.jobs, "onboard-interrupted", func(job Job) bool { return job.Finished() })
+	if job.State != JobSucceeded {
+		t.Errorf("requeued job = %s %q", job.State, job.Message)
+	}
+}
+
+func TestJobsOfAReplicaGoneForGoodAreRequeued(t *testing.T) {
+	redis := newFakeRedis(t)
+	// A pod stopped while running this job and came back under a new name
+	payload, _ := json.Marshal(queuedJob{
+		Job:  Job{ID: "onboard-stranded", Type: "onboard", ClusterName: "edge-3", State: JobRunning, CreatedAt: time.Now().Format(time.RFC3339)},
+		Spec: resumableJob{JobID: "onboard-stranded", Type: "onboard", ClusterName: "edge-3"},
+	})
+	redis.lists["kubestellar-jobs:processing:worker-7d9f"] = []string{string(payload)}
+	redis.sets["kubestellar-jobs:replicas"] = map[string]bool{"kubestellar-jobs:processing:worker-7d9f": true}
+	expired := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	redis.hashes["kubestellar-jobs:leases"] = map[string]string{"onboard-stranded": expired}
+
+	worker := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode":                modeMock,
+		"mockFleetSize":       0,
+		"mockStepDelayMillis": 1,
+		"jobBackend": map[string]interface{}{
+			"type":           "redis",
+			"url":            redis.url(),
+			"identity":       "worker-b4c2",
+			"ackWaitSeconds": 1,
+		},
+	})
+	job := waitForJob(t, worker.jobs, "onboard-stranded", func(job Job) bool { return job.Finished() })
+	if job.State != JobSucceeded {
+		t.Errorf("requeued job = %s %q", job.State, job.Message)
+	}
+	if processing := redis.list("kubestellar-jobs:processing:worker-7d9f"); len(processing) != 0 {
+		t.Errorf("job left in the list of the old replica: %v", processing)
+	}
+	redis.mutex.Lock()
+	lease, leased := redis.hashes["kubestellar-jobs:leases"]["onboard-stranded"]
+	redis.mutex.Unlock()
+	if leased {
+		t.Errorf("lease %s left after the job was acked", lease)
+	}
+}
+
+func TestRedisLeaseSweep(t *testing.T) {
+	redis := newFakeRedis(t)
+	rb, err := newRedisJobBackend(JobBackendConfig{Type: "redis", URL: redis.url(), Prefix: "jobs", Identity: "a", AckWaitSeconds: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rb.Close()
+	payload, _ := json.Marshal(queuedJob{Job: Job{ID: "detach-1"}})
+	redis.lists["jobs:processing:b"] = []string{string(payload)}
+	redis.sets["jobs:replicas"]["jobs:processing:b"] = true
+
+	// A job found without a lease gets one rather than being requeued
+	now := time.Now()
+	if err := rb.sweep(now); err != nil {
+		t.Fatal(err)
+	}
+	if queue := redis.list("jobs:queue"); len(queue) != 0 {
+		t.Fatalf("job requeued before its lease expired: %v", queue)
+	}
+	// A live replica renewing the lease keeps the job
+	if err := rb.renewLease("detach-1", now.Add(50*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rb.sweep(now.Add(90 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if queue := redis.list("jobs:queue"); len(queue) != 0 {
+		t.Fatalf("job with a renewed lease requeued: %v", queue)
+	}
+	if err := rb.sweep(now.Add(2 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if queue := redis.list("jobs:queue"); len(queue) != 1 || len(redis.list("jobs:processing:b")) != 0 {
+		t.Fatalf("expired job not requeued: queue %v", queue)
+	}
+	// The emptied list of the other replica is forgotten, not that of this one
+	if err := rb.sweep(now.Add(3 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	redis.mutex.Lock()
+	defer redis.mutex.Unlock()
+	if replicas := redis.sets["jobs:replicas"]; replicas["jobs:processing:b"] || !replicas["jobs:processing:a"] {
+		t.Errorf("replicas = %v", replicas)
+	}
+}
+
+func TestJobBackendConfig(t *testing.T) {
+	jc, err := jobBackendConfigFromConfig(map[string]interface{}{"jobBackend": map[string]interface{}{"type": "nats", "url": "nats://localhost:4222"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jc.Prefix != "kubestellar-jobs" || jc.AckWaitSeconds != 60 || jc.Identity == "" || !jc.consumes() {
+		t.Errorf("defaults = %+v", jc)
+	}
+	for _, config := range []map[string]interface{}{
+		{"type": "kafka", "url": "kafka://localhost"},
+		{"type": "redis"},
+		{"type": "nats", "url": "nats://localhost:4222", "ackWaitSeconds": -1},
+	} {
+		if _, err := jobBackendConfigFromConfig(map[string]interface{}{"jobBackend": config}); err == nil {
+			t.Errorf("jobBackendConfigFromConfig(%v) accepted", config)
+		}
+	}
+	if _, err := newJobBackend(JobBackendConfig{Type: "redis", URL: "http://localhost:6379"}); err == nil {
+		t.Error("redis backend accepted an http URL")
+	}
+}
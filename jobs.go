@@ -0,0 +1,909 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// JobState describes where a long-running operation is in its lifecycle
+type JobState string
+
+const (
+	JobPending   JobState = "Pending"
+	JobRunning   JobState = "Running"
+	JobSucceeded JobState = "Succeeded"
+	JobFailed    JobState = "Failed"
+	JobCancelled JobState = "Cancelled"
+	// JobTimedOut is an onboarding that ran out of time in one of its phases
+	JobTimedOut JobState = "TimedOut"
+)
+
+// Job tracks a single onboarding or detachment operation
+type Job struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	ClusterName string `json:"clusterName"`
+	// RequestID is the X-Request-ID of the request that created the job
+	RequestID string `json:"requestId,omitempty"`
+	// Tenant is the tenant of that request, when tenancy is enabled
+	Tenant      string    `json:"tenant,omitempty"`
+	State       JobState  `json:"state"`
+	Message     string    `json:"message,omitempty"`
+	Steps       []JobStep `json:"steps"`
+	CreatedAt   string    `json:"createdAt"`
+	UpdatedAt   string    `json:"updatedAt"`
+	CompletedAt string    `json:"completedAt,omitempty"`
+	// QueuePosition is where a pending job waits for a worker
+	QueuePosition int `json:"queuePosition,omitempty"`
+	// Drain is the progress of the drain phase of a two-phase detach
+	Drain *DrainProgress `json:"drain,omitempty"`
+	// TimedOutPhase is the onboarding phase a TimedOut job ran out of time in
+	TimedOutPhase string `json:"timedOutPhase,omitempty"`
+	// Charts is the progress of the Helm charts an onboarding installs
+	Charts []ChartStatus `json:"charts,omitempty"`
+}
+
+// JobStep records a single state transition of a job
+type JobStep struct {
+	Name      string `json:"name"`
+	Message   string `json:"message,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Finished reports whether the job has reached a terminal state
+func (j Job) Finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCancelled || j.State == JobTimedOut
+}
+
+// JobPersistence stores job records so they survive beyond the in-memory manager
+type JobPersistence interface {
+	SaveJobs(jobs []Job) error
+	LoadJobs() ([]Job, error)
+}
+
+// JobManager keeps track of running and finished jobs
+type JobManager struct {
+	jobs     map[string]*Job
+	contexts map[string]context.Context
+	cancels  map[string]context.CancelFunc
+	// executing holds the jobs whose Execute has started
+	executing   map[string]bool
+	mutex       sync.RWMutex
+	persistence JobPersistence
+	// retention is how many finished jobs are kept; 0 keeps them all
+	retention int
+	// tracer records a span per job, with a child span per step
+	tracer trace.Tracer
+	// events, when set, receives an event per job state change and step
+	events *EventBus
+	// pool bounds how many jobs execute at once
+	pool *jobPool
+	// backend, when set, shares job records and queued jobs with the other
+	// replicas. Only the jobs this replica runs are kept in memory.
+	backend JobBackend
+	// evicted are the finished jobs dropped since the last save, for the
+	// backend to drop too
+	evicted []string
+
+	// base is the parent of every job context; stop cancels all jobs at once
+	base     context.Context
+	stop     context.CancelFunc
+	draining bool
+	// closed is set once Shutdown has checkpointed the jobs; later updates
+	// from goroutines that ignored cancellation are dropped
+	closed bool
+}
+
+// NewJobManager creates a job manager, restoring previously persisted jobs if
+// any. At most retention finished jobs are kept, the oldest being evicted first.
+func NewJobManager(persistence JobPersistence, retention int) *JobManager {
+	base, stop := context.WithCancel(context.Background())
+	jm := &JobManager{
+		base:        base,
+		stop:        stop,
+		retention:   retention,
+		jobs:        make(map[string]*Job),
+		contexts:    make(map[string]context.Context),
+		cancels:     make(map[string]context.CancelFunc),
+		executing:   make(map[string]bool),
+		persistence: persistence,
+		pool:        newJobPool(0, 0),
+	}
+
+	if persistence != nil {
+		jobs, err := persistence.LoadJobs()
+		if err != nil {
+			logger().Warn("Failed to load persisted jobs", "error", err)
+		}
+		for i := range jobs {
+			job := jobs[i]
+			// Jobs that were running when the plugin stopped can't be resumed
+			if !job.Finished() {
+				job.State = JobFailed
+				job.Message = "Interrupted by plugin restart"
+				job.CompletedAt = time.Now().Format(time.RFC3339)
+			}
+			jm.jobs[job.ID] = &job
+		}
+	}
+
+	return jm
+}
+
+// Create registers a new pending job and returns a snapshot of it. A pending
+// job can already be cancelled before it starts executing. requestCtx is the
+// context of the request starting the job, which the job doesn't outlive or
+// get cancelled with: only its request ID, tenant and span context are copied,
+// so the job span is a child in the trace of the request.
+func (jm *JobManager) Create(requestCtx context.Context, jobType, clusterName string) Job {
+	parent := trace.ContextWithSpanContext(jm.base, trace.SpanContextFromContext(requestCtx))
+	ctx, cancel := context.WithCancel(parent)
+	now := time.Now().Format(time.RFC3339)
+	tenant, _ := tenantFromContext(requestCtx)
+	job := &Job{
+		ID:          newJobID(jobType),
+		Type:        jobType,
+		ClusterName: clusterName,
+		RequestID:   requestIDFromContext(requestCtx),
+		Tenant:      tenant,
+		State:       JobPending,
+		Steps:       []JobStep{{Name: string(JobPending), Message: "Job created", Timestamp: now}},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	jm.mutex.Lock()
+	jm.jobs[job.ID] = job
+	jm.contexts[job.ID] = ctx
+	jm.cancels[job.ID] = cancel
+	draining := jm.draining
+	snapshot := jm.copyJob(job)
+	jm.mutex.Unlock()
+
+	jm.persist(job.ID)
+
+	// Jobs created while shutting down never start doing work
+	if draining {
+		jm.Cancel(job.ID)
+		snapshot, _ = jm.Get(job.ID)
+	}
+	return snapshot
+}
+
+// Shutdown stops accepting work, cancels pending jobs and waits for running
+// jobs to finish. Jobs still running after the timeout are cancelled, and any
+// that don't stop in time are checkpointed as failed. The final job states are
+// persisted before returning, together with the interrupted jobs.
+func (jm *JobManager) Shutdown(timeout time.Duration) []Job {
+	jm.mutex.Lock()
+	jm.draining = true
+	var pending []string
+	for id := range jm.cancels {
+		if jm.jobs[id].State == JobPending {
+			pending = append(pending, id)
+		}
+	}
+	jm.mutex.Unlock()
+
+	for _, id := range pending {
+		jm.Cancel(id)
+	}
+
+	if !jm.waitIdle(timeout) {
+		logger().Warn("Jobs still running, cancelling them", "timeout", timeout)
+		jm.stop()
+		// Give cancelled jobs a moment to record their outcome
+		jm.waitIdle(5 * time.Second)
+	}
+
+	jm.mutex.Lock()
+	now := time.Now().Format(time.RFC3339)
+	var interrupted []Job
+	for id := range jm.contexts {
+		job := jm.jobs[id]
+		job.State = JobFailed
+		job.Message = "Interrupted by plugin shutdown"
+		job.UpdatedAt = now
+		job.CompletedAt = now
+		job.Steps = append(job.Steps, JobStep{Name: string(JobFailed), Message: job.Message, Timestamp: now})
+		interrupted = append(interrupted, jm.copyJob(job))
+	}
+	jm.closed = true
+	jm.mutex.Unlock()
+
+	if len(interrupted) > 0 {
+		logger().Warn("Jobs did not stop in time and were marked as interrupted", "jobs", len(interrupted))
+	}
+	jm.save()
+	return interrupted
+}
+
+// Freeze stops recording job updates and refuses new jobs, returning a
+// snapshot of every job for handing over to another plugin instance. Running
+// jobs keep running until Abandon cancels them.
+func (jm *JobManager) Freeze() []Job {
+	jm.mutex.Lock()
+	jm.draining = true
+	jm.closed = true
+	jm.mutex.Unlock()
+	return jm.localJobs()
+}
+
+// Abandon cancels every active job of a frozen manager and waits up to
+// timeout for them to stop, returning the IDs of the jobs still running.
+// Jobs that haven't started executing are dropped and will never start.
+func (jm *JobManager) Abandon(timeout time.Duration) map[string]bool {
+	jm.mutex.Lock()
+	for id, cancel := range jm.cancels {
+		if !jm.executing[id] {
+			cancel()
+			delete(jm.contexts, id)
+			delete(jm.cancels, id)
+		}
+	}
+	jm.mutex.Unlock()
+
+	jm.stop()
+	jm.waitIdle(timeout)
+
+	jm.mutex.RLock()
+	defer jm.mutex.RUnlock()
+	running := make(map[string]bool, len(jm.contexts))
+	for id := range jm.contexts {
+		running[id] = true
+	}
+	return running
+}
+
+// Import adds the jobs handed over by a previous plugin instance, replacing
+// records with the same ID. Unfinished jobs listed in resume become pending
+// again so they can be executed under their original ID; the other
+// unfinished jobs are marked as failed.
+func (jm *JobManager) Import(jobs []Job, resume map[string]bool) {
+	jm.mutex.Lock()
+	now := time.Now().Format(time.RFC3339)
+	for i := range jobs {
+		job := jobs[i]
+		if _, active := jm.contexts[job.ID]; active {
+			continue
+		}
+		if !job.Finished() {
+			if resume[job.ID] {
+				job.State = JobPending
+				job.Message = "Resumed after plugin handoff"
+				ctx, cancel := context.WithCancel(jm.base)
+				jm.contexts[job.ID] = ctx
+				jm.cancels[job.ID] = cancel
+			} else {
+				job.State = JobFailed
+				job.Message = "Interrupted by plugin handoff"
+				job.CompletedAt = now
+			}
+			job.UpdatedAt = now
+			job.Steps = append(job.Steps, JobStep{Name: string(job.State), Message: job.Message, Timestamp: now})
+		}
+		jm.jobs[job.ID] = &job
+	}
+	jm.evictFinished()
+	jm.mutex.Unlock()
+
+	jm.persist()
+}
+
+// SetBackend shares the job records and queue with the other replicas
+// through backend. It must be called before any job is created.
+func (jm *JobManager) SetBackend(backend JobBackend) {
+	jm.backend = backend
+}
+
+// Release hands a pending job over to the job queue. Its record is saved to
+// the backend and forgotten here, for the replica dequeuing it to run it.
+func (jm *JobManager) Release(id string) {
+	jm.persist(id)
+	jm.mutex.Lock()
+	defer jm.mutex.Unlock()
+	if cancel, ok := jm.cancels[id]; ok && !jm.executing[id] {
+		cancel()
+		delete(jm.jobs, id)
+		delete(jm.contexts, id)
+		delete(jm.cancels, id)
+	}
+}
+
+// Adopt registers a job started before, dequeued from the job queue or
+// resumed from its checkpoint, as pending so that Execute runs it under its
+// original ID
+func (jm *JobManager) Adopt(job Job, message string) {
+	jm.mutex.Lock()
+	if _, active := jm.contexts[job.ID]; active {
+		jm.mutex.Unlock()
+		return
+	}
+	now := time.Now().Format(time.RFC3339)
+	job.State = JobPending
+	job.Message = message
+	job.UpdatedAt = now
+	job.CompletedAt = ""
+	job.Steps = append(append([]JobStep(nil), job.Steps...), JobStep{Name: string(JobPending), Message: job.Message, Timestamp: now})
+	ctx, cancel := context.WithCancel(jm.base)
+	jm.jobs[job.ID] = &job
+	jm.contexts[job.ID] = ctx
+	jm.cancels[job.ID] = cancel
+	jm.mutex.Unlock()
+
+	jm.persist(job.ID)
+}
+
+// Draining reports whether the manager is shutting down
+func (jm *JobManager) Draining() bool {
+	jm.mutex.RLock()
+	defer jm.mutex.RUnlock()
+	return jm.draining
+}
+
+// waitIdle polls until no job is active, reporting false on timeout
+func (jm *JobManager) waitIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		jm.mutex.RLock()
+		active := len(jm.contexts)
+		jm.mutex.RUnlock()
+		if active == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// SetPool limits jobs to concurrency at once with up to queueSize more waiting
+// for a worker. It must be called before any job is created.
+func (jm *JobManager) SetPool(concurrency, queueSize int) {
+	jm.pool = newJobPool(concurrency, queueSize)
+}
+
+// ResizePool changes the limits of the worker pool of a running manager.
+// Jobs already running or queued past the new limits are kept.
+func (jm *JobManager) ResizePool(concurrency, queueSize int) {
+	jm.pool.resize(concurrency, queueSize)
+}
+
+// Admit returns errJobQueueFull when no worker or queue slot is left for a
+// new job. Callers serialize Admit and Create so that the check holds.
+func (jm *JobManager) Admit() error {
+	return jm.pool.admit(jm.Active())
+}
+
+// PoolStats returns the worker pool counters
+func (jm *JobManager) PoolStats() PoolStats {
+	return jm.pool.Stats()
+}
+
+// Run executes fn asynchronously for the given job, recording its outcome
+func (jm *JobManager) Run(id string, fn func(ctx context.Context) error) {
+	go jm.Execute(id, fn)
+}
+
+// Execute runs fn for the given job in the calling goroutine and records its
+// outcome. fn is invoked even for a job cancelled while pending so that it can
+// record the cancellation on the resources it owns. The job waits for a
+// worker of the pool first.
+func (jm *JobManager) Execute(id string, fn func(ctx context.Context) error) {
+	jm.mutex.RLock()
+	ctx, exists := jm.contexts[id]
+	cancel := jm.cancels[id]
+	jm.mutex.RUnlock()
+	if !exists {
+		return
+	}
+	defer cancel()
+
+	if jm.pool.acquire(ctx, id) {
+		defer jm.pool.release()
+	}
+	jm.mutex.Lock()
+	// Abandon drops the jobs still waiting for a worker
+	_, exists = jm.contexts[id]
+	if exists {
+		jm.executing[id] = true
+	}
+	jm.mutex.Unlock()
+	if !exists {
+		return
+	}
+
+	if ctx.Err() == nil {
+		jm.setState(id, JobRunning, "Job started")
+	}
+
+	var span trace.Span
+	var phases *phaseTracker
+	if jm.tracer != nil {
+		job, _ := jm.Get(id)
+		ctx, span = jm.tracer.Start(ctx, "job."+job.Type, trace.WithAttributes(
+			attribute.String("job.id", id),
+			attribute.String("cluster.name", job.ClusterName),
+			attribute.String("request.id", job.RequestID),
+		))
+		ctx, phases = withPhaseTracker(ctx, jm.tracer)
+	}
+
+	err := fn(ctx)
+
+	if span != nil {
+		phases.end(err)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+
+	var timeout *phaseTimeoutError
+	switch {
+	case ctx.Err() == context.Canceled:
+		if job, _ := jm.Get(id); job.State != JobCancelled {
+			jm.setState(id, JobCancelled, "Job cancelled")
+		}
+	case errors.As(err, &timeout):
+		jm.mutex.Lock()
+		if job, exists := jm.jobs[id]; exists {
+			job.TimedOutPhase = timeout.phase
+		}
+		jm.mutex.Unlock()
+		jm.setState(id, JobTimedOut, err.Error())
+	case err != nil:
+		jm.setState(id, JobFailed, err.Error())
+	default:
+		jm.setState(id, JobSucceeded, "Job completed successfully")
+	}
+
+	jm.mutex.Lock()
+	delete(jm.contexts, id)
+	delete(jm.cancels, id)
+	delete(jm.executing, id)
+	jm.mutex.Unlock()
+
+	if job, ok := jm.Get(id); ok {
+		logger().Info("Job finished", "job", id, "type", job.Type, "cluster", job.ClusterName,
+			"requestId", job.RequestID, "state", job.State, "message", job.Message)
+	}
+}
+
+// RecordStep appends a step transition to the job history
+func (jm *JobManager) RecordStep(id, name, message string) {
+	jm.mutex.Lock()
+	job, exists := jm.jobs[id]
+	if !exists || jm.closed {
+		jm.mutex.Unlock()
+		return
+	}
+	now := time.Now().Format(time.RFC3339)
+	job.Steps = append(job.Steps, JobStep{Name: name, Message: message, Timestamp: now})
+	job.Message = message
+	job.UpdatedAt = now
+	event := Event{
+		Type:        eventJobStep,
+		ClusterName: job.ClusterName,
+		JobID:       id,
+		Message:     message,
+		Attributes:  map[string]string{"jobType": job.Type, "step": name},
+		Timestamp:   now,
+	}
+	jm.mutex.Unlock()
+
+	jm.persist(id)
+	if jm.events != nil {
+		jm.events.Publish(event)
+	}
+}
+
+// SetDrain records the drain progress of a detach job
+func (jm *JobManager) SetDrain(id string, progress DrainProgress) {
+	jm.mutex.Lock()
+	job, exists := jm.jobs[id]
+	if !exists || jm.closed {
+		jm.mutex.Unlock()
+		return
+	}
+	progress.Remaining = append([]string(nil), progress.Remaining...)
+	progress.Warnings = append([]string(nil), progress.Warnings...)
+	job.Drain = &progress
+	job.UpdatedAt = time.Now().Format(time.RFC3339)
+	jm.mutex.Unlock()
+
+	jm.persist(id)
+}
+
+// SetCharts records the progress of the charts an onboarding job installs
+func (jm *JobManager) SetCharts(id string, charts []ChartStatus) {
+	jm.mutex.Lock()
+	job, exists := jm.jobs[id]
+	if !exists || jm.closed {
+		jm.mutex.Unlock()
+		return
+	}
+	job.Charts = append([]ChartStatus(nil), charts...)
+	job.UpdatedAt = time.Now().Format(time.RFC3339)
+	jm.mutex.Unlock()
+
+	jm.persist(id)
+}
+
+// Cancel stops a pending or running job
+func (jm *JobManager) Cancel(id string) error {
+	jm.mutex.RLock()
+	job, exists := jm.jobs[id]
+	cancel, active := jm.cancels[id]
+	var state JobState
+	if exists {
+		state = job.State
+	}
+	jm.mutex.RUnlock()
+
+	if !exists && jm.backend != nil {
+		if shared, found, err := jm.backend.LoadJob(id); err == nil && found {
+			if shared.Finished() {
+				return fmt.Errorf("job '%s' is not running (state: %s)", id, shared.State)
+			}
+			return fmt.Errorf("job '%s' runs on another replica", id)
+		}
+	}
+	if !exists {
+		return fmt.Errorf("job '%s' not found", id)
+	}
+	if !active {
+		return fmt.Errorf("job '%s' is not running (state: %s)", id, state)
+	}
+
+	cancel()
+	if state == JobPending {
+		jm.setState(id, JobCancelled, "Job cancelled before it started")
+	}
+	return nil
+}
+
+// Get returns a snapshot of a single job, looking it up in the backend when
+// another replica runs it
+func (jm *JobManager) Get(id string) (Job, bool) {
+	jm.mutex.RLock()
+	job, exists := jm.jobs[id]
+	var snapshot Job
+	if exists {
+		snapshot = jm.copyJob(job)
+	}
+	jm.mutex.RUnlock()
+
+	if !exists && jm.backend != nil {
+		shared, found, err := jm.backend.LoadJob(id)
+		if err != nil {
+			logger().Warn("Failed to read job from the job backend", "job", id, "error", err)
+		}
+		return shared, found
+	}
+	return snapshot, exists
+}
+
+// List returns snapshots of all jobs, newest first, including those of the
+// other replicas when a backend is shared
+func (jm *JobManager) List() []Job {
+	jobs := jm.localJobs()
+	if jm.backend != nil {
+		local := make(map[string]bool, len(jobs))
+		for _, job := range jobs {
+			local[job.ID] = true
+		}
+		shared, err := jm.backend.LoadJobs()
+		if err != nil {
+			logger().Warn("Failed to read jobs from the job backend", "error", err)
+		}
+		for _, job := range shared {
+			if !local[job.ID] {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt > jobs[j].CreatedAt
+	})
+	return jobs
+}
+
+// localJobs returns snapshots of the jobs kept in memory
+func (jm *JobManager) localJobs() []Job {
+	jm.mutex.RLock()
+	defer jm.mutex.RUnlock()
+
+	jobs := make([]Job, 0, len(jm.jobs))
+	for _, job := range jm.jobs {
+		jobs = append(jobs, jm.copyJob(job))
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt > jobs[j].CreatedAt
+	})
+	return jobs
+}
+
+// Active returns the number of pending and running jobs
+func (jm *JobManager) Active() int {
+	jm.mutex.RLock()
+	defer jm.mutex.RUnlock()
+	return len(jm.contexts)
+}
+
+func (jm *JobManager) setState(id string, state JobState, message string) {
+	jm.mutex.Lock()
+	job, exists := jm.jobs[id]
+	if !exists || jm.closed {
+		jm.mutex.Unlock()
+		return
+	}
+	now := time.Now().Format(time.RFC3339)
+	job.State = state
+	job.Message = message
+	job.UpdatedAt = now
+	// The step the job was in when it ended tells where a failure happened
+	lastStep := ""
+	if len(job.Steps) > 0 {
+		lastStep = job.Steps[len(job.Steps)-1].Name
+	}
+	phase := job.TimedOutPhase
+	job.Steps = append(job.Steps, JobStep{Name: string(state), Message: message, Timestamp: now})
+	event := Event{
+		ClusterName: job.ClusterName,
+		JobID:       id,
+		Message:     message,
+		Attributes:  map[string]string{"jobType": job.Type},
+		Timestamp:   now,
+	}
+	if job.Finished() {
+		job.CompletedAt = now
+		jm.evictFinished()
+	}
+	jm.mutex.Unlock()
+
+	jm.persist(id)
+	event.Type = jobStateEvents[state]
+	if jm.events != nil && event.Type != "" {
+		if (state == JobFailed || state == JobTimedOut) && lastStep != "" {
+			event.Attributes["step"] = lastStep
+		}
+		if state == JobTimedOut {
+			event.Attributes["phase"] = phase
+		}
+		jm.events.Publish(event)
+	}
+}
+
+// jobStateEvents maps job states to the event published on entering them
+var jobStateEvents = map[JobState]string{
+	JobRunning:   eventJobStarted,
+	JobSucceeded: eventJobSucceeded,
+	JobFailed:    eventJobFailed,
+	JobCancelled: eventJobCancelled,
+	JobTimedOut:  eventJobFailed,
+}
+
+// evictFinished drops the oldest finished jobs beyond the retention limit.
+// The caller must hold the mutex.
+func (jm *JobManager) evictFinished() {
+	if jm.retention <= 0 {
+		return
+	}
+	var finished []*Job
+	for _, job := range jm.jobs {
+		if job.Finished() {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= jm.retention {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].CompletedAt < finished[j].CompletedAt
+	})
+	for _, job := range finished[:len(finished)-jm.retention] {
+		delete(jm.jobs, job.ID)
+		if jm.backend != nil {
+			jm.evicted = append(jm.evicted, job.ID)
+		}
+	}
+}
+
+func (jm *JobManager) copyJob(job *Job) Job {
+	snapshot := *job
+	snapshot.Steps = append([]JobStep(nil), job.Steps...)
+	snapshot.Charts = append([]ChartStatus(nil), job.Charts...)
+	if _, active := jm.contexts[job.ID]; active && job.State == JobPending && !jm.executing[job.ID] {
+		snapshot.QueuePosition = jm.pool.position(job.ID)
+	}
+	return snapshot
+}
+
+// persist saves the jobs after a change. A backend only gets the changed
+// jobs, or every job kept here when none is given.
+func (jm *JobManager) persist(changed ...string) {
+	jm.mutex.RLock()
+	closed := jm.closed
+	jm.mutex.RUnlock()
+	if closed {
+		return
+	}
+	jm.save(changed...)
+}
+
+// save writes the jobs to the persistence backend
+func (jm *JobManager) save(changed ...string) {
+	if jm.backend != nil {
+		jm.saveShared(changed)
+		return
+	}
+	if jm.persistence == nil {
+		return
+	}
+	if err := jm.persistence.SaveJobs(jm.localJobs()); err != nil {
+		logger().Warn("Failed to persist jobs", "error", err)
+	}
+}
+
+// saveShared writes jobs to the job backend and drops the evicted ones
+func (jm *JobManager) saveShared(changed []string) {
+	jm.mutex.Lock()
+	evicted := jm.evicted
+	jm.evicted = nil
+	var jobs []Job
+	if len(changed) == 0 {
+		for _, job := range jm.jobs {
+			jobs = append(jobs, jm.copyJob(job))
+		}
+	}
+	for _, id := range changed {
+		if job, exists := jm.jobs[id]; exists {
+			jobs = append(jobs, jm.copyJob(job))
+		}
+	}
+	jm.mutex.Unlock()
+
+	for _, job := range jobs {
+		if err := jm.backend.SaveJob(job); err != nil {
+			logger().Warn("Failed to save job to the job backend", "job", job.ID, "error", err)
+		}
+	}
+	for _, id := range evicted {
+		if err := jm.backend.DeleteJob(id); err != nil {
+			logger().Warn("Failed to drop job from the job backend", "job", id, "error", err)
+		}
+	}
+}
+
+// fileJobPersistence stores jobs as a JSON document on local disk
+type fileJobPersistence struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func newFileJobPersistence(path string) *fileJobPersistence {
+	return &fileJobPersistence{path: path}
+}
+
+func (fp *fileJobPersistence) SaveJobs(jobs []Job) error {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode jobs: %w", err)
+	}
+
+	tmpPath := fp.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write jobs file: %w", err)
+	}
+	return os.Rename(tmpPath, fp.path)
+}
+
+func (fp *fileJobPersistence) LoadJobs() ([]Job, error) {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+
+	data, err := os.ReadFile(fp.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read jobs file: %w", err)
+	}
+
+	var jobs []Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to decode jobs file: %w", err)
+	}
+	return jobs, nil
+}
+
+// ListJobsHandler returns all jobs, optionally filtered by cluster, type or state
+func (cp *ClusterPlugin) ListJobsHandler(c *gin.Context) {
+	clusterFilter := c.Query("cluster")
+	typeFilter := c.Query("type")
+	stateFilter := c.Query("state")
+
+	jobs := []Job{}
+	for _, job := range cp.jobs.List() {
+		if !visibleTo(c.Request.Context(), job.Tenant) {
+			continue
+		}
+		if clusterFilter != "" && job.ClusterName != clusterFilter {
+			continue
+		}
+		if typeFilter != "" && job.Type != typeFilter {
+			continue
+		}
+		if stateFilter != "" && string(job.State) != stateFilter {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":      jobs,
+		"total":     len(jobs),
+		"pool":      cp.jobs.PoolStats(),
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// GetJobHandler returns a single job with its step history
+func (cp *ClusterPlugin) GetJobHandler(c *gin.Context) {
+	id := c.Param("id")
+	job, exists := cp.jobs.Get(id)
+	if !exists || !visibleTo(c.Request.Context(), job.Tenant) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Job '%s' not found", id))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job":       job,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// CancelJobHandler cancels a running job
+func (cp *ClusterPlugin) CancelJobHandler(c *gin.Context) {
+	id := c.Param("id")
+	if job, exists := cp.jobs.Get(id); exists && !visibleTo(c.Request.Context(), job.Tenant) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Job '%s' not found", id))
+		return
+	}
+	if err := cp.jobs.Cancel(id); err != nil {
+		status, code := http.StatusConflict, CodeConflict
+		if _, exists := cp.jobs.Get(id); !exists {
+			status, code = http.StatusNotFound, CodeNotFound
+		}
+		respondProblem(c, status, code, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     message(c, MsgJobCancelRequested, "job", id),
+		"messageCode": MsgJobCancelRequested,
+		"jobId":       id,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestJobJoinsTheRequestTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	jm := NewJobManager(nil, 0)
+	jm.tracer = provider.Tracer("test")
+
+	requestCtx, cancel := context.WithCancel(context.Background())
+	requestCtx, request := jm.tracer.Start(requestCtx, "POST /onboard")
+	job := jm.Create(context.WithValue(requestCtx, requestIDContextKey{}, "req-1"), "onboard", "edge-1")
+	// The request is over before the job runs, which must not cancel it
+	request.End()
+	cancel()
+	jm.Run(job.ID, func(ctx context.Context) error { return ctx.Err() })
+	job = waitForJob(t, jm, job.ID, func(job Job) bool { return job.Finished() })
+	if job.State != JobSucceeded || job.RequestID != "req-1" {
+		t.Errorf("job = %+v", job)
+	}
+
+	var jobSpan *tracetest.SpanStub
+	spans := exporter.GetSpans()
+	for i := range spans {
+		if spans[i].Name == "job.onboard" {
+			jobSpan = &spans[i]
+		}
+	}
+	if jobSpan == nil {
+		t.Fatalf("no job span in %d spans", len(spans))
+	}
+	want := request.SpanContext()
+	if jobSpan.SpanContext.TraceID() != want.TraceID() || jobSpan.Parent.SpanID() != want.SpanID() {
+		t.Errorf("job span is in trace %s under %s, want trace %s under %s",
+			jobSpan.SpanContext.TraceID(), jobSpan.Parent.SpanID(), want.TraceID(), want.SpanID())
+	}
+}
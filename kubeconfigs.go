@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// StoredContext describes a kubeconfig context held by the KubeconfigManager.
+// It never includes credentials.
+type StoredContext struct {
+	Name      string `json:"name"`
+	Cluster   string `json:"cluster"`
+	Server    string `json:"server"`
+	User      string `json:"user"`
+	Namespace string `json:"namespace,omitempty"`
+	StoredAt  string `json:"storedAt"`
+}
+
+// KubeconfigManager stores uploaded kubeconfig contexts encrypted on local disk
+// so onboard and detach requests can reference them by name
+type KubeconfigManager struct {
+	dir      string
+	box      *secretBox
+	contexts map[string]StoredContext
+	mutex    sync.RWMutex
+}
+
+// NewKubeconfigManager opens the context store in dir, loading any contexts saved earlier
+func NewKubeconfigManager(dir string, box *secretBox) (*KubeconfigManager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create context directory: %w", err)
+	}
+
+	km := &KubeconfigManager{
+		dir:      dir,
+		box:      box,
+		contexts: make(map[string]StoredContext),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.enc"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := km.read(file)
+		if err != nil {
+			logger().Warn("Skipping unreadable stored context", "file", file, "error", err)
+			continue
+		}
+		stored, err := describeContext(data)
+		if err != nil {
+			logger().Warn("Skipping invalid stored context", "file", file, "error", err)
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			stored.StoredAt = info.ModTime().Format(time.RFC3339)
+		}
+		km.contexts[stored.Name] = stored
+	}
+
+	return km, nil
+}
+
+// Import splits a kubeconfig into its contexts and stores each one. When names
+// is non-empty only those contexts are imported.
+func (km *KubeconfigManager) Import(data []byte, names []string) ([]StoredContext, error) {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+
+	if len(names) == 0 {
+		for name := range config.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("kubeconfig contains no contexts")
+	}
+
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	var imported []StoredContext
+	for _, name := range names {
+		contextData, err := extractContextConfig(config, name)
+		if err != nil {
+			return imported, err
+		}
+		sealed, err := km.box.Seal(contextData)
+		if err != nil {
+			return imported, err
+		}
+		if err := os.WriteFile(km.path(name), sealed, 0600); err != nil {
+			return imported, fmt.Errorf("failed to store context '%s': %w", name, err)
+		}
+
+		stored, err := describeContext(contextData)
+		if err != nil {
+			return imported, err
+		}
+		stored.StoredAt = time.Now().Format(time.RFC3339)
+		km.contexts[name] = stored
+		imported = append(imported, stored)
+	}
+
+	return imported, nil
+}
+
+// List returns all stored contexts sorted by name
+func (km *KubeconfigManager) List() []StoredContext {
+	km.mutex.RLock()
+	defer km.mutex.RUnlock()
+
+	contexts := make([]StoredContext, 0, len(km.contexts))
+	for _, stored := range km.contexts {
+		contexts = append(contexts, stored)
+	}
+	sort.Slice(contexts, func(i, j int) bool {
+		return contexts[i].Name < contexts[j].Name
+	})
+	return contexts
+}
+
+// Get returns the decrypted single-context kubeconfig for name
+func (km *KubeconfigManager) Get(name string) ([]byte, error) {
+	km.mutex.RLock()
+	defer km.mutex.RUnlock()
+
+	if _, exists := km.contexts[name]; !exists {
+		return nil, fmt.Errorf("context '%s' not found", name)
+	}
+	return km.read(km.path(name))
+}
+
+// Delete removes a stored context
+func (km *KubeconfigManager) Delete(name string) error {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	if _, exists := km.contexts[name]; !exists {
+		return fmt.Errorf("context '%s' not found", name)
+	}
+	if err := os.Remove(km.path(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove context '%s': %w", name, err)
+	}
+	delete(km.contexts, name)
+	return nil
+}
+
+// reseal moves the stored contexts under the current encryption key
+func (km *KubeconfigManager) reseal() (int, error) {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	return resealFiles(km.box, filepath.Join(km.dir, "*.enc"))
+}
+
+func (km *KubeconfigManager) path(name string) string {
+	// Context names may contain characters such as '/' or ':' that aren't safe in file names
+	return filepath.Join(km.dir, base64.RawURLEncoding.EncodeToString([]byte(name))+".enc")
+}
+
+func (km *KubeconfigManager) read(path string) ([]byte, error) {
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return km.box.Open(sealed)
+}
+
+// describeContext summarises the current context of a single-context kubeconfig
+func describeContext(data []byte) (StoredContext, error) {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return StoredContext{}, err
+	}
+	context, exists := config.Contexts[config.CurrentContext]
+	if !exists {
+		return StoredContext{}, fmt.Errorf("current context '%s' not found", config.CurrentContext)
+	}
+
+	stored := StoredContext{
+		Name:      config.CurrentContext,
+		Cluster:   context.Cluster,
+		User:      context.AuthInfo,
+		Namespace: context.Namespace,
+	}
+	if cluster, exists := config.Clusters[context.Cluster]; exists {
+		stored.Server = cluster.Server
+	}
+	return stored, nil
+}
+
+// UploadKubeconfigHandler stores the contexts of an uploaded kubeconfig. It
+// accepts either a multipart "kubeconfig" file or a JSON body.
+func (cp *ClusterPlugin) UploadKubeconfigHandler(c *gin.Context) {
+	var data []byte
+	var names []string
+
+	if strings.Contains(c.GetHeader("Content-Type"), "multipart/form-data") {
+		var ok bool
+		if data, ok = cp.readKubeconfigUpload(c); !ok {
+			return
+		}
+		if data == nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Failed to retrieve kubeconfig file")
+			return
+		}
+		if selected := c.PostForm("contexts"); selected != "" {
+			names = strings.Split(selected, ",")
+		}
+	} else {
+		var req KubeconfigUploadRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload, kubeconfig is required")
+			return
+		}
+		data = []byte(req.Kubeconfig)
+		names = req.Contexts
+	}
+
+	imported, err := cp.kubeconfigs.Import(data, names)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     message(c, MsgContextsStored, "count", len(imported)),
+		"messageCode": MsgContextsStored,
+		"contexts":    imported,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// ListKubeconfigContextsHandler lists the stored kubeconfig contexts
+func (cp *ClusterPlugin) ListKubeconfigContextsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"contexts":  cp.kubeconfigs.List(),
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// DeleteKubeconfigContextHandler removes a stored kubeconfig context
+func (cp *ClusterPlugin) DeleteKubeconfigContextHandler(c *gin.Context) {
+	name := c.Param("context")
+	if err := cp.kubeconfigs.Delete(name); err != nil {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     message(c, MsgContextDeleted, "context", name),
+		"messageCode": MsgContextDeleted,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const testMultiContextKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: edge-1
+  cluster:
+    server: https://edge-1.example.com:6443
+- name: edge-2
+  cluster:
+    server: https://edge-2.example.com:6443
+contexts:
+- name: edge-1
+  context:
+    cluster: edge-1
+    user: admin-1
+- name: kind/edge-2
+  context:
+    cluster: edge-2
+    user: admin-2
+    namespace: apps
+current-context: edge-1
+users:
+- name: admin-1
+  user:
+    token: secret-1
+- name: admin-2
+  user:
+    token: secret-2
+`
+
+func TestKubeconfigManager(t *testing.T) {
+	dir := t.TempDir()
+	box, _ := newSecretBox(bytes.Repeat([]byte{7}, 32))
+	manager, err := NewKubeconfigManager(dir, box)
+	if err != nil {
+		t.Fatalf("NewKubeconfigManager() error = %v", err)
+	}
+
+	imported, err := manager.Import([]byte(testMultiContextKubeconfig), nil)
+	if err != nil || len(imported) != 2 {
+		t.Fatalf("Import() = %+v, %v, want both contexts", imported, err)
+	}
+	contexts := manager.List()
+	if len(contexts) != 2 || contexts[0].Name != "edge-1" || contexts[1].Name != "kind/edge-2" {
+		t.Fatalf("List() = %+v, want edge-1 and kind/edge-2", contexts)
+	}
+	if contexts[1].Server != "https://edge-2.example.com:6443" || contexts[1].User != "admin-2" || contexts[1].Namespace != "apps" {
+		t.Errorf("List()[1] = %+v", contexts[1])
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.enc"))
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if bytes.Contains(data, []byte("secret-")) {
+			t.Errorf("%s stores the credentials in plaintext", file)
+		}
+	}
+
+	data, err := manager.Get("kind/edge-2")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil || config.CurrentContext != "kind/edge-2" || len(config.Contexts) != 1 || config.AuthInfos["admin-2"].Token != "secret-2" {
+		t.Errorf("Get() = %s, %v, want the single kind/edge-2 context", data, err)
+	}
+
+	// A new manager finds the contexts stored by the previous one
+	reopened, err := NewKubeconfigManager(dir, box)
+	if err != nil || len(reopened.List()) != 2 {
+		t.Fatalf("NewKubeconfigManager() reopen = %v, %v", reopened.List(), err)
+	}
+	if err := reopened.Delete("edge-1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := reopened.Get("edge-1"); err == nil {
+		t.Error("Get() found a deleted context")
+	}
+	if err := reopened.Delete("edge-1"); err == nil {
+		t.Error("Delete() of an unknown context succeeded")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.enc")); len(files) != 1 {
+		t.Errorf("%d context files left, want 1", len(files))
+	}
+
+	// Contexts sealed with another key are skipped
+	otherBox, _ := newSecretBox(bytes.Repeat([]byte{8}, 32))
+	if other, err := NewKubeconfigManager(dir, otherBox); err != nil || len(other.List()) != 0 {
+		t.Errorf("NewKubeconfigManager() with another key = %v, %v, want no contexts", other.List(), err)
+	}
+}
+
+func TestKubeconfigManagerImportSelected(t *testing.T) {
+	box, _ := newSecretBox(bytes.Repeat([]byte{7}, 32))
+	manager, err := NewKubeconfigManager(t.TempDir(), box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported, err := manager.Import([]byte(testMultiContextKubeconfig), []string{"kind/edge-2"}); err != nil || len(imported) != 1 {
+		t.Errorf("Import() selected = %+v, %v, want one context", imported, err)
+	}
+	if _, err := manager.Import([]byte(testMultiContextKubeconfig), []string{"missing"}); err == nil {
+		t.Error("Import() accepted a context missing from the kubeconfig")
+	}
+	if _, err := manager.Import([]byte("not a kubeconfig"), nil); err == nil {
+		t.Error("Import() accepted an invalid kubeconfig")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// LabelsPatchRequest is the JSON body accepted by PATCH /clusters/:name/labels.
+// It follows JSON merge patch semantics: a null value removes the key.
+type LabelsPatchRequest struct {
+	Labels      map[string]*string `json:"labels,omitempty"`
+	Annotations map[string]*string `json:"annotations,omitempty"`
+}
+
+// Validate checks that every key and label value is a valid Kubernetes name
+func (r LabelsPatchRequest) Validate() error {
+	if len(r.Labels) == 0 && len(r.Annotations) == 0 {
+		return fmt.Errorf("at least one label or annotation is required")
+	}
+	for key, value := range r.Labels {
+		if err := validateLabel(key, value); err != nil {
+			return err
+		}
+	}
+	for key := range r.Annotations {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid annotation key '%s': %s", key, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// validateLabels checks a complete label set, as given on onboarding
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		value := value
+		if err := validateLabel(key, &value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLabel(key string, value *string) error {
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return fmt.Errorf("invalid label key '%s': %s", key, strings.Join(errs, "; "))
+	}
+	if value != nil {
+		if errs := validation.IsValidLabelValue(*value); len(errs) > 0 {
+			return fmt.Errorf("invalid value for label '%s': %s", key, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// mergeStringMap applies a merge patch to m and returns the result
+func mergeStringMap(m map[string]string, patch map[string]*string) map[string]string {
+	merged := make(map[string]string, len(m)+len(patch))
+	for key, value := range m {
+		merged[key] = value
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = *value
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// PatchClusterLabelsHandler updates the labels and annotations of a cluster
+// record and mirrors them onto its ManagedCluster so placements can select it
+func (cp *ClusterPlugin) PatchClusterLabelsHandler(c *gin.Context) {
+	clusterName := c.Param("name")
+
+	var req LabelsPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	cp.mutex.Lock()
+	record, exists, err := cp.store.Get(clusterName)
+	if err != nil {
+		cp.mutex.Unlock()
+		respondStoreError(c, err)
+		return
+	}
+	if !exists {
+		cp.mutex.Unlock()
+		respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin", clusterName))
+		return
+	}
+	record.Labels = mergeStringMap(record.Labels, req.Labels)
+	record.Annotations = mergeStringMap(record.Annotations, req.Annotations)
+	err = cp.store.Put(record)
+	cp.statusCache.invalidate()
+	cp.mutex.Unlock()
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeStoreError, fmt.Sprintf("Failed to update cluster store: %v", err))
+		return
+	}
+
+	hubSynced := true
+	hubError := ""
+	if err := cp.patchManagedClusterMetadata(c.Request.Context(), clusterName, req); err != nil {
+		logger().Warn("Failed to sync labels to hub", "cluster", clusterName, "error", err)
+		hubSynced = false
+		hubError = err.Error()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"cluster":   record,
+		"hubSynced": hubSynced,
+		"hubError":  hubError,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// selectClusters returns the recorded clusters whose labels, hub labels
+// included, match the selector
+func (cp *ClusterPlugin) selectClusters(labelSelector string) ([]ClusterStatus, error) {
+	selector, err := k8slabels.Parse(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid labelSelector: %w", err)
+	}
+
+	clusters, err := cp.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []ClusterStatus
+	for _, cluster := range clusters {
+		if cp.hub != nil && hubName(cluster) == builtinHub.Name {
+			if state, exists := cp.hub.Get(cluster.ClusterName); exists {
+				cluster.ManagedCluster = &state
+			}
+		}
+		if selector.Matches(k8slabels.Set(clusterLabelSet(cluster))) {
+			selected = append(selected, cluster)
+		}
+	}
+	return selected, nil
+}
+
+// patchManagedClusterMetadata applies a labels patch to the ManagedCluster on
+// the cluster's hub
+func (cp *ClusterPlugin) patchManagedClusterMetadata(ctx context.Context, clusterName string, req LabelsPatchRequest) error {
+	hub, err := cp.clusterHub(clusterName)
+	if err != nil {
+		return err
+	}
+	hubClientset, _, err := hub.clientset()
+	if err != nil {
+		return fmt.Errorf("failed to get hub clientset: %w", err)
+	}
+
+	metadata := map[string]interface{}{}
+	if len(req.Labels) > 0 {
+		metadata["labels"] = req.Labels
+	}
+	if len(req.Annotations) > 0 {
+		metadata["annotations"] = req.Annotations
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return err
+	}
+
+	return hubClientset.RESTClient().Patch(types.MergePatchType).
+		AbsPath("/apis/cluster.open-cluster-management.io/v1").
+		Resource("managedclusters").
+		Name(clusterName).
+		Body(patch).
+		Do(ctx).
+		Error()
+}
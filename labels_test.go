@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeStringMap(t *testing.T) {
+	value := func(s string) *string { return &s }
+
+	tests := []struct {
+		name  string
+		base  map[string]string
+		patch map[string]*string
+		want  map[string]string
+	}{
+		{
+			name:  "add to empty",
+			base:  nil,
+			patch: map[string]*string{"env": value("prod")},
+			want:  map[string]string{"env": "prod"},
+		},
+		{
+			name:  "overwrite and keep",
+			base:  map[string]string{"env": "dev", "team": "edge"},
+			patch: map[string]*string{"env": value("prod")},
+			want:  map[string]string{"env": "prod", "team": "edge"},
+		},
+		{
+			name:  "null removes",
+			base:  map[string]string{"env": "dev", "team": "edge"},
+			patch: map[string]*string{"env": nil},
+			want:  map[string]string{"team": "edge"},
+		},
+		{
+			name:  "removing the last key yields nil",
+			base:  map[string]string{"env": "dev"},
+			patch: map[string]*string{"env": nil},
+			want:  nil,
+		},
+		{
+			name:  "removing a missing key",
+			base:  map[string]string{"env": "dev"},
+			patch: map[string]*string{"region": nil},
+			want:  map[string]string{"env": "dev"},
+		},
+		{
+			name:  "empty value is kept",
+			base:  nil,
+			patch: map[string]*string{"env": value("")},
+			want:  map[string]string{"env": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var original map[string]string
+			if tt.base != nil {
+				original = make(map[string]string, len(tt.base))
+				for k, v := range tt.base {
+					original[k] = v
+				}
+			}
+			if got := mergeStringMap(tt.base, tt.patch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeStringMap() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.base, original) {
+				t.Errorf("mergeStringMap() modified its input: %v", tt.base)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// serviceAccountNamespaceFile names the namespace of the pod the plugin runs in
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// LeaderElectionConfig is the leaderElection section of the Initialize config.
+// With several replicas of the host, only the one holding the Lease runs the
+// background workers and accepts changes; every replica serves reads.
+type LeaderElectionConfig struct {
+	Enabled bool `json:"enabled"`
+	// LeaseName and Namespace locate the Lease the replicas compete for. The
+	// namespace defaults to the one of the pod.
+	LeaseName string `json:"leaseName,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Identity names this replica in the Lease, the hostname by default
+	Identity string `json:"identity,omitempty"`
+	// Context is the kubeconfig context of the cluster holding the Lease,
+	// the current context by default
+	Context              string `json:"context,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	RenewDeadlineSeconds int    `json:"renewDeadlineSeconds,omitempty"`
+	RetryPeriodSeconds   int    `json:"retryPeriodSeconds,omitempty"`
+}
+
+func leaderElectionConfigFromConfig(config map[string]interface{}) (*LeaderElectionConfig, error) {
+	lc := LeaderElectionConfig{
+		LeaseName:            "kubestellar-cluster-plugin",
+		LeaseDurationSeconds: 15,
+		RenewDeadlineSeconds: 10,
+		RetryPeriodSeconds:   2,
+	}
+	raw, ok := config["leaderElection"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid leaderElection config: %w", err)
+	}
+	if err := json.Unmarshal(data, &lc); err != nil {
+		return nil, fmt.Errorf("invalid leaderElection config: %w", err)
+	}
+	if !lc.Enabled {
+		return nil, nil
+	}
+	if lc.Namespace == "" {
+		lc.Namespace = "default"
+		if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+			lc.Namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if lc.Identity == "" {
+		lc.Identity, _ = os.Hostname()
+		if lc.Identity == "" {
+			lc.Identity = newJobID("replica")
+		}
+	}
+	if lc.RetryPeriodSeconds <= 0 || lc.RenewDeadlineSeconds*5 <= lc.RetryPeriodSeconds*6 || lc.LeaseDurationSeconds <= lc.RenewDeadlineSeconds {
+		return nil, fmt.Errorf("invalid leaderElection config: leaseDurationSeconds must exceed renewDeadlineSeconds, which must exceed 1.2 times retryPeriodSeconds")
+	}
+	return &lc, nil
+}
+
+// leaderElectionClient connects to the cluster holding the Lease
+var leaderElectionClient = func(contextName string) (kubernetes.Interface, error) {
+	clientset, _, err := GetClientSetWithConfigContext(contextName)
+	return clientset, err
+}
+
+// leaderElector campaigns for the Lease until stopped, running onStarted
+// whenever it becomes the leader and onStopped when it stops being one
+type leaderElector struct {
+	config LeaderElectionConfig
+	client kubernetes.Interface
+
+	// transition serializes gaining and losing leadership, which client-go
+	// reports from different goroutines
+	transition sync.Mutex
+	mutex      sync.RWMutex
+	leading    bool
+	leader     string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newLeaderElector(config LeaderElectionConfig, client kubernetes.Interface) *leaderElector {
+	return &leaderElector{config: config, client: client}
+}
+
+// Start campaigns in the background
+func (le *leaderElector) Start(onStarted, onStopped func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	le.cancel = cancel
+	le.done = make(chan struct{})
+
+	go func() {
+		defer close(le.done)
+		for {
+			elector, err := leaderelection.NewLeaderElector(le.electionConfig(onStarted, onStopped))
+			if err != nil {
+				logger().Error("Failed to start leader election", "error", err)
+				return
+			}
+			elector.Run(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			logger().Warn("Lost the leader lease, campaigning again", "lease", le.config.LeaseName, "identity", le.config.Identity)
+		}
+	}()
+}
+
+// Stop ends the campaign, handing the Lease over to another replica when
+// this one holds it
+func (le *leaderElector) Stop() {
+	if le.cancel == nil {
+		return
+	}
+	le.cancel()
+	<-le.done
+}
+
+func (le *leaderElector) electionConfig(onStarted, onStopped func()) leaderelection.LeaderElectionConfig {
+	return leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: le.config.LeaseName, Namespace: le.config.Namespace},
+			Client:     le.client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: le.config.Identity},
+		},
+		LeaseDuration:   time.Duration(le.config.LeaseDurationSeconds) * time.Second,
+		RenewDeadline:   time.Duration(le.config.RenewDeadlineSeconds) * time.Second,
+		RetryPeriod:     time.Duration(le.config.RetryPeriodSeconds) * time.Second,
+		ReleaseOnCancel: true,
+		Name:            le.config.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				le.transition.Lock()
+				defer le.transition.Unlock()
+				// Leadership may already be over when this runs
+				if ctx.Err() != nil {
+					return
+				}
+				logger().Info("Became the leader replica", "lease", le.config.LeaseName, "identity", le.config.Identity)
+				onStarted()
+				le.setLeading(true)
+			},
+			OnStoppedLeading: func() {
+				le.transition.Lock()
+				defer le.transition.Unlock()
+				if leading, _ := le.Status(); !leading {
+					return
+				}
+				le.setLeading(false)
+				logger().Info("Stopped being the leader replica", "lease", le.config.LeaseName, "identity", le.config.Identity)
+				onStopped()
+			},
+			OnNewLeader: func(identity string) {
+				le.mutex.Lock()
+				le.leader = identity
+				le.mutex.Unlock()
+			},
+		},
+	}
+}
+
+func (le *leaderElector) setLeading(leading bool) {
+	le.mutex.Lock()
+	defer le.mutex.Unlock()
+	le.leading = leading
+	if leading {
+		le.leader = le.config.Identity
+	}
+}
+
+// Status reports whether this replica leads, and which replica does
+func (le *leaderElector) Status() (bool, string) {
+	le.mutex.RLock()
+	defer le.mutex.RUnlock()
+	return le.leading, le.leader
+}
+
+// readMethods are served by every replica, the leader or not
+var readMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+// withLeadership wraps a handler so that only the leader replica accepts
+// requests that change state or start jobs. The others refuse them with the
+// identity of the leader, for the host to retry there.
+func (cp *ClusterPlugin) withLeadership(_ string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		elector := cp.leader
+		cp.mutex.RUnlock()
+
+		if elector == nil || readMethods[c.Request.Method] {
+			handler(c)
+			return
+		}
+		leading, leader := elector.Status()
+		if !leading {
+			c.Header("Retry-After", strconv.Itoa(elector.config.RetryPeriodSeconds))
+			detail := "This replica isn't the leader and only serves reads"
+			if leader != "" {
+				detail = fmt.Sprintf("This replica isn't the leader and only serves reads; replica '%s' leads", leader)
+			}
+			abortWithProblem(c, http.StatusServiceUnavailable, CodeNotLeader, detail, "leader", leader)
+			return
+		}
+		handler(c)
+	}
+}
+
+// leaderHealth reports the role of the replica. Following is healthy; a
+// replica without a known leader is degraded as no one runs the workers.
+func (cp *ClusterPlugin) leaderHealth() ComponentHealth {
+	component := ComponentHealth{Name: "leaderElection", State: HealthHealthy}
+	leading, leader := cp.leader.Status()
+	component.Details = map[string]interface{}{"identity": cp.leader.config.Identity, "leader": leader, "leading": leading}
+	switch {
+	case leading:
+		component.Message = "leading"
+	case leader != "":
+		component.Message = fmt.Sprintf("following %s", leader)
+	default:
+		component.State = HealthDegraded
+		component.Message = "no leader elected yet"
+	}
+	return component
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// runsWorkers reports whether a replica runs the background workers
+func runsWorkers(plugin *ClusterPlugin) bool {
+	plugin.workersMutex.Lock()
+	defer plugin.workersMutex.Unlock()
+	return plugin.workersRunning
+}
+
+// waitForLeader polls the leadership of a replica until check holds
+func waitForLeader(t *testing.T, plugin *ClusterPlugin, check func(leading bool, leader string) bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if check(plugin.leader.Status()) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	leading, leader := plugin.leader.Status()
+	t.Fatalf("leadership = %v, %q", leading, leader)
+}
+
+func TestLeaderElection(t *testing.T) {
+	cluster := fake.NewSimpleClientset()
+	original := leaderElectionClient
+	t.Cleanup(func() { leaderElectionClient = original })
+	leaderElectionClient = func(string) (kubernetes.Interface, error) { return cluster, nil }
+	replica := func(identity string) *ClusterPlugin {
+		return newTestPluginWithConfig(t, map[string]interface{}{"leaderElection": map[string]interface{}{
+			"enabled":              true,
+			"identity":             identity,
+			"namespace":            "plugins",
+			"leaseDurationSeconds": 3,
+			"renewDeadlineSeconds": 2,
+			"retryPeriodSeconds":   1,
+		}})
+	}
+
+	a := replica("replica-a")
+	waitForLeader(t, a, func(leading bool, _ string) bool { return leading })
+	b := replica("replica-b")
+	waitForLeader(t, b, func(_ bool, leader string) bool { return leader == "replica-a" })
+	if !runsWorkers(a) || runsWorkers(b) {
+		t.Errorf("workers running: a = %v, b = %v", runsWorkers(a), runsWorkers(b))
+	}
+
+	gin.SetMode(gin.TestMode)
+	serve := func(plugin *ClusterPlugin, method, path, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.GET("/groups", plugin.withLeadership("ListGroupsHandler", plugin.ListGroupsHandler))
+		router.POST("/groups", plugin.withLeadership("CreateGroupHandler", plugin.CreateGroupHandler))
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	recorder := serve(b, http.MethodPost, "/groups", `{"name": "edge", "selector": "tier=edge"}`)
+	var problem struct {
+		Code   ErrorCode `json:"code"`
+		Leader string    `json:"leader"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &problem)
+	if recorder.Code != http.StatusServiceUnavailable || problem.Code != CodeNotLeader || problem.Leader != "replica-a" || recorder.Header().Get("Retry-After") != "1" {
+		t.Errorf("follower write = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(b, http.MethodGet, "/groups", ""); recorder.Code != http.StatusOK {
+		t.Errorf("follower read = %d", recorder.Code)
+	}
+	if recorder := serve(a, http.MethodPost, "/groups", `{"name": "edge", "selector": "tier=edge"}`); recorder.Code != http.StatusCreated {
+		t.Errorf("leader write = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if component := b.leaderHealth(); component.State != HealthHealthy || component.Message != "following replica-a" {
+		t.Errorf("follower health = %+v", component)
+	}
+
+	// Shutting the leader down releases the Lease to the other replica
+	a.Cleanup()
+	waitForLeader(t, b, func(leading bool, _ string) bool { return leading })
+	if !runsWorkers(b) {
+		t.Error("new leader doesn't run the workers")
+	}
+}
+
+func TestLeaderElectionConfig(t *testing.T) {
+	lc, err := leaderElectionConfigFromConfig(map[string]interface{}{"leaderElection": map[string]interface{}{"enabled": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lc.LeaseName != "kubestellar-cluster-plugin" || lc.Namespace == "" || lc.Identity == "" {
+		t.Errorf("defaults = %+v", lc)
+	}
+	for _, config := range []map[string]interface{}{
+		{"enabled": true, "leaseDurationSeconds": 10, "renewDeadlineSeconds": 10},
+		{"enabled": true, "renewDeadlineSeconds": 2, "retryPeriodSeconds": 2},
+		{"enabled": true, "retryPeriodSeconds": -1},
+	} {
+		if _, err := leaderElectionConfigFromConfig(map[string]interface{}{"leaderElection": config}); err == nil {
+			t.Errorf("leaderElectionConfigFromConfig(%v) accepted", config)
+		}
+	}
+}
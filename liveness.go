@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// statusUnreachable marks a Ready cluster whose registration agent stopped
+// renewing its lease on the hub
+const statusUnreachable = "Unreachable"
+
+// periodicCheck runs check every interval until Stop is called, such as the
+// heartbeat and join token checks
+type periodicCheck struct {
+	interval time.Duration
+	check    func(ctx context.Context)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newPeriodicCheck(interval time.Duration, check func(ctx context.Context)) *periodicCheck {
+	return &periodicCheck{interval: interval, check: check}
+}
+
+// Start checks right away and then every interval
+func (m *periodicCheck) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			m.check(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends the checks and waits for a running one to finish
+func (m *periodicCheck) Stop() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	<-m.done
+}
+
+// clusterHeartbeat returns when the cluster's lease was last renewed, or the
+// zero time when the hub has no lease for it
+func clusterHeartbeat(ctx context.Context, hub kubernetes.Interface, clusterName string) (time.Time, error) {
+	lease, err := hub.CoordinationV1().Leases(clusterName).Get(ctx, clusterLeaseName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get cluster lease: %w", err)
+	}
+	if lease.Spec.RenewTime == nil {
+		return time.Time{}, nil
+	}
+	return lease.Spec.RenewTime.Time, nil
+}
+
+// checkHeartbeats reads the heartbeat of every Ready or Unreachable cluster
+// from its hub. Clusters on a hub that can't be reached are left alone, since
+// their agents may well be fine.
+func (cp *ClusterPlugin) checkHeartbeats(ctx context.Context) {
+	cp.mutex.RLock()
+	records, err := cp.store.List()
+	cp.mutex.RUnlock()
+	if err != nil {
+		logger().Warn("Failed to list clusters for heartbeat check", "error", err)
+		return
+	}
+
+	clients := make(map[string]kubernetes.Interface)
+	for _, record := range records {
+		if record.Status != "Ready" && record.Status != statusUnreachable {
+			continue
+		}
+		name := hubName(record)
+		client, checked := clients[name]
+		if !checked {
+			if hub, err := cp.hubs.Get(name); err != nil {
+				logger().Warn("Failed to resolve hub for heartbeat check", "hub", name, "error", err)
+			} else if clientset, _, err := hub.clientset(); err != nil {
+				logger().Warn("Failed to connect to hub for heartbeat check", "hub", name, "error", err)
+			} else {
+				client = clientset
+			}
+			clients[name] = client
+		}
+		if client == nil {
+			continue
+		}
+		renewed, err := clusterHeartbeat(ctx, client, record.ClusterName)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger().Warn("Failed to read cluster heartbeat", "cluster", record.ClusterName, "error", err)
+			continue
+		}
+		cp.observeHeartbeat(record.ClusterName, renewed, time.Now())
+	}
+}
+
+// observeHeartbeat records the lease renewal of a cluster and moves it
+// between Ready and Unreachable when it crosses the staleness threshold.
+// Without a lease a Ready cluster is measured from when it became Ready,
+// while an Unreachable one stays so until its lease is renewed.
+func (cp *ClusterPlugin) observeHeartbeat(clusterName string, renewed, now time.Time) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if cp.closed {
+		return
+	}
+	record, exists, err := cp.store.Get(clusterName)
+	if err != nil || !exists {
+		return
+	}
+
+	if !renewed.IsZero() {
+		if heartbeat := renewed.UTC().Format(time.RFC3339); heartbeat != record.LastHeartbeat {
+			record.LastHeartbeat = heartbeat
+			if err := cp.store.Put(record); err != nil {
+				logger().Error("Failed to persist cluster heartbeat", "cluster", clusterName, "error", err)
+				return
+			}
+			cp.statusCache.invalidate()
+		}
+	}
+
+	last := renewed
+	if last.IsZero() {
+		last, _ = time.Parse(time.RFC3339, record.LastHeartbeat)
+		if record.Status == "Ready" {
+			if updated, err := time.Parse(time.RFC3339, record.LastUpdated); err == nil && updated.After(last) {
+				last = updated
+			}
+		}
+	}
+	stale := last.IsZero() || now.Sub(last) > cp.heartbeatStale
+
+	switch {
+	case record.Status == "Ready" && stale:
+		since := "it was onboarded"
+		if record.LastHeartbeat != "" {
+			since = record.LastHeartbeat
+		}
+		record.Status = statusUnreachable
+		record.Message = fmt.Sprintf("No heartbeat from the cluster agent since %s", since)
+		record.LastUpdated = now.Format(time.RFC3339)
+		cp.putStatus(record)
+		logger().Warn("Cluster unreachable", "cluster", clusterName, "lastHeartbeat", record.LastHeartbeat)
+		cp.notify(eventClusterUnreachable, clusterName, "", record.Message)
+	case record.Status == statusUnreachable && !stale:
+		record.Status = "Ready"
+		record.Message = "Cluster agent heartbeat resumed"
+		record.LastUpdated = now.Format(time.RFC3339)
+		cp.putStatus(record)
+		logger().Info("Cluster reachable again", "cluster", clusterName, "lastHeartbeat", record.LastHeartbeat)
+		cp.notify(eventClusterReachable, clusterName, "", record.Message)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClusterHeartbeat(t *testing.T) {
+	renewed := metav1.NewMicroTime(time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC))
+	hub := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterLeaseName, Namespace: "edge-1"},
+		Spec:       coordinationv1.LeaseSpec{RenewTime: &renewed},
+	})
+
+	got, err := clusterHeartbeat(context.Background(), hub, "edge-1")
+	if err != nil || !got.Equal(renewed.Time) {
+		t.Errorf("clusterHeartbeat() = %v, %v, want %v", got, err, renewed.Time)
+	}
+	if got, err := clusterHeartbeat(context.Background(), hub, "edge-2"); err != nil || !got.IsZero() {
+		t.Errorf("clusterHeartbeat() without a lease = %v, %v, want the zero time", got, err)
+	}
+}
+
+func TestObserveHeartbeat(t *testing.T) {
+	t.Setenv("KUBECONFIG", t.TempDir()+"/missing")
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"monitorHeartbeats":        true,
+		"heartbeatStaleSeconds":    60,
+		"heartbeatIntervalSeconds": 3600,
+	})
+	sink := &recordingSink{}
+	plugin.events.Subscribe("test", sink, nil)
+
+	onboarded := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready", LastUpdated: onboarded.Format(time.RFC3339)})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-2", Status: "Pending", LastUpdated: onboarded.Format(time.RFC3339)})
+
+	steps := []struct {
+		name       string
+		cluster    string
+		renewed    time.Time
+		now        time.Time
+		wantStatus string
+	}{
+		{name: "fresh heartbeat", cluster: "edge-1", renewed: onboarded.Add(time.Minute), now: onboarded.Add(90 * time.Second), wantStatus: "Ready"},
+		{name: "lease gone stale", cluster: "edge-1", renewed: onboarded.Add(time.Minute), now: onboarded.Add(3 * time.Minute), wantStatus: statusUnreachable},
+		{name: "lease missing", cluster: "edge-1", now: onboarded.Add(4 * time.Minute), wantStatus: statusUnreachable},
+		{name: "heartbeat resumed", cluster: "edge-1", renewed: onboarded.Add(5 * time.Minute), now: onboarded.Add(5 * time.Minute), wantStatus: "Ready"},
+		{name: "not onboarded yet", cluster: "edge-2", now: onboarded.Add(time.Hour), wantStatus: "Pending"},
+	}
+	for _, step := range steps {
+		plugin.observeHeartbeat(step.cluster, step.renewed, step.now)
+		record, _, _ := plugin.store.Get(step.cluster)
+		if record.Status != step.wantStatus {
+			t.Errorf("%s: status = %s, want %s (%s)", step.name, record.Status, step.wantStatus, record.Message)
+		}
+	}
+
+	record, _, _ := plugin.store.Get("edge-1")
+	if want := onboarded.Add(5 * time.Minute).Format(time.RFC3339); record.LastHeartbeat != want {
+		t.Errorf("LastHeartbeat = %s, want %s", record.LastHeartbeat, want)
+	}
+	plugin.events.Close(time.Second)
+	if want := []string{eventClusterUnreachable, eventClusterReachable}; !reflect.DeepEqual(sink.types(), want) {
+		t.Errorf("events = %v, want %v", sink.types(), want)
+	}
+}
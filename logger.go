@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// pluginLogger holds the structured logger shared by every part of the
+// plugin. It starts as a text logger on stderr and is replaced by Initialize.
+var pluginLogger atomic.Pointer[slog.Logger]
+
+// pluginLogLevel is the level of the loggers newLogger builds, which PUT
+// /settings changes without replacing the logger
+var pluginLogLevel = new(slog.LevelVar)
+
+func init() {
+	pluginLogger.Store(defaultLogger())
+}
+
+// defaultLogger logs as text to stderr at info level
+func defaultLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, nil)).With("plugin", "kubestellar-cluster-plugin")
+}
+
+// logger returns the current plugin logger
+func logger() *slog.Logger {
+	return pluginLogger.Load()
+}
+
+// newLogger builds the logger described by the Initialize config. A host may
+// inject its own logger by passing a *slog.Logger or slog.Handler under
+// "logger"; otherwise logLevel (debug, info, warn, error), logFormat (text or
+// json) and logOutput (stderr, stdout or a file path) are used. The returned
+// closer is non-nil when the plugin opened a log file.
+func newLogger(config map[string]interface{}) (*slog.Logger, io.Closer, error) {
+	switch injected := config["logger"].(type) {
+	case *slog.Logger:
+		return injected.With("plugin", "kubestellar-cluster-plugin"), nil, nil
+	case slog.Handler:
+		return slog.New(injected).With("plugin", "kubestellar-cluster-plugin"), nil, nil
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(configString(config, "logLevel", "info"))); err != nil {
+		return nil, nil, fmt.Errorf("invalid logLevel: %w", err)
+	}
+
+	var output io.Writer
+	var closer io.Closer
+	switch target := configString(config, "logOutput", "stderr"); target {
+	case "stderr":
+		output = os.Stderr
+	case "stdout":
+		output = os.Stdout
+	default:
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		output, closer = file, file
+	}
+
+	options := &slog.HandlerOptions{Level: pluginLogLevel}
+	var handler slog.Handler
+	switch format := strings.ToLower(configString(config, "logFormat", "text")); format {
+	case "text":
+		handler = slog.NewTextHandler(output, options)
+	case "json":
+		handler = slog.NewJSONHandler(output, options)
+	default:
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, nil, fmt.Errorf("invalid logFormat %q, must be text or json", format)
+	}
+	pluginLogLevel.Set(level)
+	return slog.New(handler).With("plugin", "kubestellar-cluster-plugin"), closer, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	previous := pluginLogLevel.Level()
+	t.Cleanup(func() { pluginLogLevel.Set(previous) })
+	dir := t.TempDir()
+	var injected bytes.Buffer
+
+	tests := []struct {
+		name       string
+		config     map[string]interface{}
+		wantErr    string
+		wantCloser bool
+		// logFile is read back when the logger writes to a file
+		logFile string
+		// want is looked for in what the logger wrote for a record at logAt
+		want  string
+		logAt slog.Level
+	}{
+		{name: "injected logger", config: map[string]interface{}{"logger": slog.New(slog.NewTextHandler(&injected, nil)), "logFormat": "yaml"}, want: "plugin=kubestellar-cluster-plugin"},
+		{name: "injected handler", config: map[string]interface{}{"logger": slog.Handler(slog.NewJSONHandler(&injected, nil)), "logLevel": "loud"}, want: `"plugin":"kubestellar-cluster-plugin"`},
+		{name: "defaults", config: map[string]interface{}{}},
+		{name: "stdout", config: map[string]interface{}{"logOutput": "stdout", "logFormat": "JSON"}},
+		{name: "text file", config: map[string]interface{}{"logOutput": filepath.Join(dir, "text.log"), "logLevel": "debug"}, wantCloser: true, logFile: filepath.Join(dir, "text.log"), logAt: slog.LevelDebug, want: "level=DEBUG msg=probe plugin=kubestellar-cluster-plugin"},
+		{name: "json file", config: map[string]interface{}{"logOutput": filepath.Join(dir, "json.log"), "logFormat": "json", "logLevel": "debug"}, wantCloser: true, logFile: filepath.Join(dir, "json.log"), logAt: slog.LevelDebug, want: `"msg":"probe"`},
+		{name: "invalid level", config: map[string]interface{}{"logLevel": "loud"}, wantErr: "invalid logLevel"},
+		{name: "invalid format", config: map[string]interface{}{"logFormat": "yaml"}, wantErr: `invalid logFormat "yaml"`},
+		{name: "unwritable file", config: map[string]interface{}{"logOutput": filepath.Join(dir, "missing", "plugin.log")}, wantErr: "failed to open log file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injected.Reset()
+			pluginLogLevel.Set(slog.LevelInfo)
+			log, closer, err := newLogger(tt.config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newLogger() error = %v, want %q", err, tt.wantErr)
+				}
+				if pluginLogLevel.Level() != slog.LevelInfo {
+					t.Errorf("a rejected config changed the level to %s", pluginLogLevel.Level())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newLogger() error = %v", err)
+			}
+			if (closer != nil) != tt.wantCloser {
+				t.Errorf("closer = %v, want one %v", closer, tt.wantCloser)
+			}
+			log.Log(context.Background(), tt.logAt, "probe")
+			if closer != nil {
+				closer.Close()
+			}
+
+			written := injected.String()
+			if tt.logFile != "" {
+				data, err := os.ReadFile(tt.logFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				written = string(data)
+			}
+			if !strings.Contains(written, tt.want) {
+				t.Errorf("logged %q, want %q", written, tt.want)
+			}
+			if tt.config["logFormat"] == "json" && !json.Valid(bytes.TrimSpace([]byte(written))) {
+				t.Errorf("json log %q is not JSON", written)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// LogEntry is a single line of onboarding output for a cluster
+type LogEntry struct {
+	Timestamp   string `json:"timestamp"`
+	Level       string `json:"level"`
+	ClusterName string `json:"clusterName"`
+	Message     string `json:"message"`
+}
+
+// clusterLogBuffer keeps the most recent log entries of a cluster and its live subscribers
+type clusterLogBuffer struct {
+	entries     []LogEntry
+	subscribers map[chan LogEntry]struct{}
+}
+
+// LogHub buffers per-cluster operation logs so late subscribers get recent history
+type LogHub struct {
+	buffers  map[string]*clusterLogBuffer
+	capacity int
+	mutex    sync.Mutex
+}
+
+// NewLogHub creates a log hub retaining up to capacity entries per cluster
+func NewLogHub(capacity int) *LogHub {
+	if capacity <= 0 {
+		capacity = 500
+	}
+	return &LogHub{
+		buffers:  make(map[string]*clusterLogBuffer),
+		capacity: capacity,
+	}
+}
+
+func (lh *LogHub) buffer(clusterName string) *clusterLogBuffer {
+	buf, exists := lh.buffers[clusterName]
+	if !exists {
+		buf = &clusterLogBuffer{subscribers: make(map[chan LogEntry]struct{})}
+		lh.buffers[clusterName] = buf
+	}
+	return buf
+}
+
+// Append records a log line for a cluster and forwards it to live subscribers
+func (lh *LogHub) Append(clusterName, level, message string) {
+	entry := LogEntry{
+		Timestamp:   time.Now().Format(time.RFC3339Nano),
+		Level:       level,
+		ClusterName: clusterName,
+		Message:     message,
+	}
+
+	lh.mutex.Lock()
+	defer lh.mutex.Unlock()
+
+	buf := lh.buffer(clusterName)
+	buf.entries = append(buf.entries, entry)
+	if len(buf.entries) > lh.capacity {
+		buf.entries = buf.entries[len(buf.entries)-lh.capacity:]
+	}
+
+	for ch := range buf.subscribers {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the buffered history for a cluster and a channel of new entries
+func (lh *LogHub) Subscribe(clusterName string) ([]LogEntry, chan LogEntry) {
+	lh.mutex.Lock()
+	defer lh.mutex.Unlock()
+
+	buf := lh.buffer(clusterName)
+	ch := make(chan LogEntry, 64)
+	buf.subscribers[ch] = struct{}{}
+	return append([]LogEntry(nil), buf.entries...), ch
+}
+
+// Unsubscribe stops delivering entries to the given channel
+func (lh *LogHub) Unsubscribe(clusterName string, ch chan LogEntry) {
+	lh.mutex.Lock()
+	defer lh.mutex.Unlock()
+
+	if buf, exists := lh.buffers[clusterName]; exists {
+		delete(buf.subscribers, ch)
+	}
+}
+
+// Has reports whether any logs were recorded for the cluster
+func (lh *LogHub) Has(clusterName string) bool {
+	lh.mutex.Lock()
+	defer lh.mutex.Unlock()
+	_, exists := lh.buffers[clusterName]
+	return exists
+}
+
+// logLineWriter splits command output into lines and appends them to the log hub
+type logLineWriter struct {
+	hub         *LogHub
+	clusterName string
+	level       string
+	pending     bytes.Buffer
+}
+
+func (lw *logLineWriter) Write(p []byte) (int, error) {
+	lw.pending.Write(p)
+	for {
+		line, err := lw.pending.ReadString('\n')
+		if err != nil {
+			// Keep the partial line until the rest of it arrives
+			lw.pending.Reset()
+			lw.pending.WriteString(line)
+			break
+		}
+		if trimmed := strings.TrimRight(line, "\r\n"); trimmed != "" {
+			lw.hub.Append(lw.clusterName, lw.level, trimmed)
+		}
+	}
+	return len(p), nil
+}
+
+func (lw *logLineWriter) Flush() {
+	if rest := strings.TrimSpace(lw.pending.String()); rest != "" {
+		lw.hub.Append(lw.clusterName, lw.level, rest)
+	}
+	lw.pending.Reset()
+}
+
+// runLogged runs a command, streaming its output into the cluster's log buffer
+// while also returning the combined output like exec.Cmd.CombinedOutput
+func (cp *ClusterPlugin) runLogged(clusterName string, cmd *exec.Cmd) ([]byte, error) {
+	cp.logs.Append(clusterName, "info", fmt.Sprintf("$ %s", strings.Join(redactArgs(cmd.Args), " ")))
+
+	output := &commandOutput{}
+	stdout := &logLineWriter{hub: cp.logs, clusterName: clusterName, level: "info"}
+	stderr := &logLineWriter{hub: cp.logs, clusterName: clusterName, level: "warn"}
+	cmd.Stdout = &outputWriter{output: output, lines: stdout}
+	cmd.Stderr = &outputWriter{output: output, lines: stderr}
+
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+
+	if err != nil {
+		cp.logs.Append(clusterName, "error", fmt.Sprintf("command failed: %v", err))
+	}
+	return output.combined.Bytes(), err
+}
+
+// redactArgs hides credential values so they never reach the log stream
+func redactArgs(args []string) []string {
+	redacted := append([]string(nil), args...)
+	for i := 0; i < len(redacted); i++ {
+		if redacted[i] == "--hub-token" && i+1 < len(redacted) {
+			redacted[i+1] = "<redacted>"
+			i++
+		} else if strings.HasPrefix(redacted[i], "--hub-token=") {
+			redacted[i] = "--hub-token=<redacted>"
+		}
+	}
+	return redacted
+}
+
+// commandOutput collects stdout and stderr of a command in arrival order
+type commandOutput struct {
+	combined bytes.Buffer
+	mutex    sync.Mutex
+}
+
+// outputWriter feeds one stream of a command into the combined output and the log hub
+type outputWriter struct {
+	output *commandOutput
+	lines  *logLineWriter
+}
+
+func (ow *outputWriter) Write(p []byte) (int, error) {
+	ow.output.mutex.Lock()
+	defer ow.output.mutex.Unlock()
+	ow.output.combined.Write(p)
+	return ow.lines.Write(p)
+}
+
+// StreamOnboardingLogsHandler streams a cluster's onboarding logs over a WebSocket
+func (cp *ClusterPlugin) StreamOnboardingLogsHandler(c *gin.Context) {
+	clusterName := c.Param("cluster")
+
+	_, exists, err := cp.store.Get(clusterName)
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeStoreError, "Failed to read cluster store")
+		return
+	}
+	if !exists && !cp.logs.Has(clusterName) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("No onboarding logs for cluster '%s'", clusterName))
+		return
+	}
+
+	server := websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			history, entries := cp.logs.Subscribe(clusterName)
+			defer cp.logs.Unsubscribe(clusterName, entries)
+
+			for _, entry := range history {
+				if err := websocket.JSON.Send(ws, entry); err != nil {
+					return
+				}
+			}
+
+			// Detect the client going away; we don't expect any inbound messages
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var discard string
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			for {
+				select {
+				case <-closed:
+					return
+				case entry := <-entries:
+					if err := websocket.JSON.Send(ws, entry); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "no token",
+			args: []string{"clusteradm", "unjoin", "--cluster-name", "c1"},
+			want: []string{"clusteradm", "unjoin", "--cluster-name", "c1"},
+		},
+		{
+			name: "separate value",
+			args: []string{"clusteradm", "join", "--hub-token", "secret", "--cluster-name", "c1"},
+			want: []string{"clusteradm", "join", "--hub-token", "<redacted>", "--cluster-name", "c1"},
+		},
+		{
+			name: "inline value",
+			args: []string{"clusteradm", "join", "--hub-token=secret"},
+			want: []string{"clusteradm", "join", "--hub-token=<redacted>"},
+		},
+		{
+			name: "flag without value",
+			args: []string{"clusteradm", "join", "--hub-token"},
+			want: []string{"clusteradm", "join", "--hub-token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]string(nil), tt.args...)
+			if got := redactArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("redactArgs() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.args, original) {
+				t.Errorf("redactArgs() modified its input: %v", tt.args)
+			}
+		})
+	}
+}
@@ -2,21 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	certificatesv1 "k8s.io/api/certificates/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -33,6 +43,20 @@ type KubestellarPlugin interface {
 	Cleanup() error
 }
 
+// HTTPPlugin is implemented by plugins whose handlers can be mounted on
+// hosts that aren't built on gin
+type HTTPPlugin interface {
+	GetHTTPHandlers() map[string]http.HandlerFunc
+}
+
+// StatefulPlugin is implemented by plugins that can hand their state over to
+// the new version when the host hot-reloads them: the host calls ExportState
+// and Cleanup on the old instance, then ImportState after Initialize on the new
+type StatefulPlugin interface {
+	ExportState() ([]byte, error)
+	ImportState(data []byte) error
+}
+
 type PluginMetadata struct {
 	ID            string            `json:"id"`
 	Name          string            `json:"name"`
@@ -43,25 +67,56 @@ type PluginMetadata struct {
 	Dependencies  []string          `json:"dependencies"`
 	Permissions   []string          `json:"permissions"`
 	Compatibility map[string]string `json:"compatibility"`
+	// APIVersion is the current API version; every unversioned endpoint is
+	// served under it too
+	APIVersion   string            `json:"apiVersion,omitempty"`
+	LegacyRoutes *RouteDeprecation `json:"legacyRoutes,omitempty"`
+	// Schemas are the JSON Schemas of request bodies, by name
+	Schemas map[string]*JSONSchema `json:"schemas,omitempty"`
+	// Capabilities tag the features of the plugin for GET /capabilities
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Extensions carries details beyond the plugin contract, such as the
+	// outcome of the update check
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
 }
 
 type EndpointConfig struct {
-	Path    string `json:"path"`
-	Method  string `json:"method"`
-	Handler string `json:"handler"`
+	Path        string `json:"path"`
+	Method      string `json:"method"`
+	Handler     string `json:"handler"`
+	Permission  string `json:"permission,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Version is the API version the endpoint belongs to, e.g. v1; its path
+	// starts with it. Unversioned endpoints are the routes of earlier releases.
+	Version string `json:"version,omitempty"`
+	// Deprecation and Sunset date a deprecated endpoint, Successor is the
+	// path of the endpoint replacing it
+	Deprecation string `json:"deprecation,omitempty"`
+	Sunset      string `json:"sunset,omitempty"`
+	Successor   string `json:"successor,omitempty"`
+	// RequestSchema names the schema of the metadata JSON request bodies
+	// must match
+	RequestSchema string `json:"requestSchema,omitempty"`
 }
 
 // ✅ ADDED: Define k8s helper functions locally
 func GetClientSetWithConfigContext(contextName string) (*kubernetes.Clientset, *rest.Config, error) {
+	return GetClientSetWithKubeconfig(kubeconfigPath(), contextName)
+}
+
+// GetClientSetWithKubeconfig connects through a context of the kubeconfig at
+// path, or its current context when contextName is empty
+func GetClientSetWithKubeconfig(path, contextName string) (*kubernetes.Clientset, *rest.Config, error) {
 	// Load the kubeconfig
-	kubeconfig := kubeconfigPath()
-	config, err := clientcmd.LoadFromFile(kubeconfig)
+	config, err := clientcmd.LoadFromFile(path)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to load kubeconfig: %w", err)
 	}
 
 	// Set the current context
-	config.CurrentContext = contextName
+	if contextName != "" {
+		config.CurrentContext = contextName
+	}
 
 	// Build the rest config
 	restConfig, err := clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
@@ -91,18 +146,241 @@ func kubeconfigPath() string {
 
 // ClusterPlugin implements the KubestellarPlugin interface for cluster operations
 type ClusterPlugin struct {
-	clusterStatuses map[string]ClusterStatus
-	mutex           sync.RWMutex
-	initialized     bool
-	kubeconfigDir   string
+	store ClusterStore
+	// search indexes the clusters in store for GET /clusters/search
+	search *searchIndex
+	// mode is live, or mock to serve the generated fleet described by mock
+	mode string
+	mock mockConfig
+	// scenario is the mock scenario being played, if any
+	scenario *scenarioRun
+	// faults injects latency, errors and step failures for resilience
+	// testing, nil unless faultInjection is enabled
+	faults *faultInjector
+	// debugEndpoints serves the /debug/pprof and /debug/vars profiling
+	// endpoints, off by default
+	debugEndpoints bool
+	mutex          sync.RWMutex
+	initialized    bool
+	// closed is set when Cleanup closes the store; job goroutines that
+	// outlive the shutdown must not write to it afterwards
+	closed        bool
+	kubeconfigDir string
+	jobs          *JobManager
+	broadcaster   *statusBroadcaster
+	logs          *LogHub
+	metadata      *PluginMetadata
+	permissions   permissionPolicy
+	preflight     PreflightReport
+	kubeconfigs   *KubeconfigManager
+	// hubs are the control planes clusters can be onboarded to
+	hubs *HubRegistry
+	// hub mirrors the ManagedClusters of the built-in its1 hub
+	hub *managedClusterWatcher
+	// heartbeats flags clusters Unreachable once their lease hasn't been
+	// renewed for heartbeatStale, nil when disabled
+	heartbeats     *periodicCheck
+	heartbeatStale time.Duration
+	// tokens tracks the join tokens issued to clusters; tokenChecks warns
+	// tokenWarning ahead of their expiry, nil when disabled
+	tokens       *TokenManager
+	tokenChecks  *periodicCheck
+	tokenWarning time.Duration
+	// resourceCollection refreshes the node counts and allocatable CPU and
+	// memory of the Ready clusters
+	resourceCollection *periodicCheck
+	// versionSkew bounds how far clusters may trail their hub in the
+	// version report
+	versionSkew VersionSkewConfig
+	// historyPrune drops status transitions older than historyRetention and
+	// beyond historyMaxEntries per cluster
+	historyPrune      *periodicCheck
+	historyRetention  time.Duration
+	historyMaxEntries int
+	// sloChecks publish events when the error budget of an SLO burns too
+	// fast
+	slos      []SLOConfig
+	sloChecks *periodicCheck
+	sloAlerts *sloAlerts
+
+	batches          *BatchManager
+	batchConcurrency int
+	maxBatchSize     int
+
+	// maxUploadBytes bounds uploaded kubeconfig files
+	maxUploadBytes int64
+
+	// queueRetryAfter is the Retry-After, in seconds, sent when the job queue is full
+	queueRetryAfter int
+
+	// finalizerTimeout bounds how long detachment waits for ManagedCluster finalizers
+	finalizerTimeout time.Duration
+	// detachSafety refuses, warns about or ignores detaching a cluster that
+	// still serves workloads
+	detachSafety string
+	// defaultDrainTimeout bounds the drain of a two-phase detach that sets none
+	defaultDrainTimeout time.Duration
+	// requireApproval holds every or only forced detachments in approvals
+	// until a second caller approves them; approvals is nil when it is off
+	requireApproval string
+	approvals       *ApprovalManager
+	// shutdownTimeout bounds how long Cleanup waits for in-flight jobs
+	shutdownTimeout time.Duration
+
+	idempotency    *idempotencyCache
+	conflictPolicy string
+
+	// logCloser closes the log file opened by Initialize, if any
+	logCloser io.Closer
+	// hostLogger is set when the host injected the logger, whose level the
+	// plugin doesn't control
+	hostLogger bool
+	// settingsReadOnly refuses changes through PUT /settings
+	settingsReadOnly bool
+	// config is the typed config Initialize resolved, configSources where
+	// each of its values came from and configSections the other keys given
+	config         PluginConfig
+	configSources  map[string]string
+	configSections []string
+
+	tracer         trace.Tracer
+	tracerShutdown func(context.Context) error
+
+	webhooks *WebhookNotifier
+	// events carries what happens in the plugin to the webhooks and the
+	// configured event sinks
+	events *EventBus
+	// audit records state-changing requests
+	audit *AuditLog
+	// auth verifies bearer tokens, nil when authentication is disabled
+	auth *authenticator
+	// rbac maps caller roles to permissions, nil when RBAC is disabled
+	rbac *roleBinder
+	// rateLimiter throttles requests, nil when no rate limit is configured
+	rateLimiter *rateLimiter
+	// requestTimeouts bound how long each endpoint may take to answer
+	requestTimeouts RequestTimeoutConfig
+	// hostMiddlewares is set when the host wraps the routes in
+	// GetMiddlewares, so GetHandlers leaves them out
+	hostMiddlewares bool
+	// compressResponses gzips answers for clients that accept it
+	compressResponses bool
+	// cors and securityHeaders set the headers every answer carries
+	cors            CORSConfig
+	securityHeaders SecurityHeadersConfig
+	// updates checks for newer plugin releases, nil when not configured
+	updates *updateChecker
+	// host is what the host reported in the compatibility handshake and
+	// negotiation the outcome of the last check against it
+	host        HostInfo
+	negotiation *NegotiationResult
+	// activeJobsThreshold and webhookBacklogThreshold are the job and
+	// webhook delivery counts at which Health reports the plugin degraded
+	activeJobsThreshold     int
+	webhookBacklogThreshold int
+	// onboarding is the pipeline of steps that joins a cluster to the hub
+	onboarding []pipelineStep
+	// phaseTimeouts bound the onboarding phases, each spanning its steps
+	phaseTimeouts map[string]time.Duration
+	// postOnboardHooks run once the pipeline has onboarded a cluster
+	postOnboardHooks []HookConfig
+	// preDetachHooks must succeed before a cluster is detached
+	preDetachHooks []HookConfig
+	// statusCache holds the GET /status snapshot between hub reads
+	statusCache *statusCache
+	// readinessGates decide when an onboarded cluster is Ready
+	readinessGates ReadinessGates
+	// manifestValues are the defaults rendered into the manifest templates
+	manifestValues ManifestValues
+	// wdsContext selects the WDS the bindingPolicies templates are applied to
+	wdsContext string
+	// airGap points onboarding at private registries; pullSecret holds the
+	// credentials read from its pullSecretPath
+	airGap     AirGapConfig
+	pullSecret []byte
+	// templates are the built-in manifest templates followed by the airGap
+	// bundle ones
+	templates []ManifestTemplate
+	// schedules starts onboardings and detachments in their maintenance window
+	schedules *ScheduleManager
+	// checkpoints records the steps onboarding jobs got through, for a
+	// restart to resume them
+	checkpoints *CheckpointStore
+	// groups name sets of clusters in one of the environments
+	groups       *GroupManager
+	environments []string
+	// onboardingPolicy restricts which clusters may be onboarded, nil when
+	// anything goes
+	onboardingPolicy *OnboardingPolicy
+	// admission evaluates onboardings and detachments against OPA policies
+	// before they run, nil when no opa config is given
+	admission *admissionController
+	// tenancy scopes clusters to the tenant of each request, nil when
+	// every caller sees every cluster
+	tenancy *TenancyConfig
+	// messages renders user-facing messages in the language of each request
+	messages *MessageCatalog
+	// leader campaigns for the Lease that decides which replica runs the
+	// background workers, nil when every instance runs them
+	leader *leaderElector
+	// workersMutex guards workersRunning, so that leadership changes and
+	// Cleanup start and stop the background workers once
+	workersMutex   sync.Mutex
+	workersRunning bool
+	// reconciler onboards and detaches clusters to match the desired membership
+	reconciler *membershipReconciler
+	// discovery lists the clusters of the configured cloud accounts
+	discovery *cloudDiscovery
+	// resumable holds the inputs of unfinished jobs so ExportState can hand
+	// them to the next plugin version
+	resumable map[string]resumableJob
+	// jobBackend, when configured, queues onboarding and detach jobs for any
+	// replica to run; consumer runs those this replica dequeues
+	jobBackend       JobBackend
+	consumer         *jobConsumer
+	jobQueueIdentity string
+	jobTouchInterval time.Duration
+	// box seals the secrets that leave the plugin, such as kubeconfigs
+	box *secretBox
+	// secrets keeps the kubeconfigs saved at onboarding
+	secrets SecretStore
+	// degraded remembers the last Health outcome so webhooks fire on transitions only
+	degraded atomic.Bool
 }
 
 type ClusterStatus struct {
 	ClusterName    string `json:"clusterName"`
+	JobID          string `json:"jobId,omitempty"`
 	Status         string `json:"status"`
 	Message        string `json:"message,omitempty"`
 	LastUpdated    string `json:"lastUpdated"`
 	KubeconfigPath string `json:"kubeconfigPath,omitempty"`
+	// Hub is the hub the cluster is onboarded to, empty for the built-in its1 hub
+	Hub         string            `json:"hub,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Profile is the display name, ownership and location of the cluster
+	Profile *ClusterProfile `json:"profile,omitempty"`
+	// ManagedCluster is the live hub view of the cluster, filled in on read
+	ManagedCluster *ManagedClusterState `json:"managedCluster,omitempty"`
+	// Verification is the latest run of the readiness gates
+	Verification *VerificationReport `json:"verification,omitempty"`
+	// LastHeartbeat is when the cluster agent last renewed its lease on the hub
+	LastHeartbeat string `json:"lastHeartbeat,omitempty"`
+	// Resources are the nodes and allocatable CPU and memory last collected
+	Resources *ClusterResources `json:"resources,omitempty"`
+	// ManifestValues are the per-request values the cluster was onboarded
+	// with, reused when the onboarding is retried or the cluster repaired
+	ManifestValues *ManifestValues `json:"manifestValues,omitempty"`
+	// Tenant owns the cluster when tenancy is enabled
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// SecretReference points at a Secret on the ITS hub holding a spoke kubeconfig
+type SecretReference struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Key       string `json:"key,omitempty"`
 }
 
 // Initialize initializes the cluster plugin
@@ -114,298 +392,1458 @@ func (cp *ClusterPlugin) Initialize(config map[string]interface{}) error {
 		return fmt.Errorf("plugin already initialized")
 	}
 
-	cp.clusterStatuses = make(map[string]ClusterStatus)
+	// Resolve the typed config over its defaults and the KS_PLUGIN_
+	// environment, leaving the resolved values in the map for the code that
+	// reads it directly
 	cp.kubeconfigDir = "/tmp/kubestellar-clusters"
+	pc, config, sources, err := resolvePluginConfig(config, cp.kubeconfigDir, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	cp.config, cp.configSources, cp.configSections = pc, sources, configSections(config)
+
+	pluginLog, logCloser, err := newLogger(config)
+	if err != nil {
+		return err
+	}
+	pluginLogger.Store(pluginLog)
+	cp.logCloser = logCloser
+	switch config["logger"].(type) {
+	case *slog.Logger, slog.Handler:
+		cp.hostLogger = true
+	default:
+		cp.hostLogger = false
+	}
+	defer func() {
+		if cp.initialized {
+			return
+		}
+		if cp.tracerShutdown != nil {
+			cp.tracerShutdown(context.Background())
+			cp.tracerShutdown = nil
+		}
+		if cp.events != nil {
+			cp.events.Close(time.Second)
+		}
+		if cp.logCloser != nil {
+			pluginLogger.Store(defaultLogger())
+			cp.logCloser.Close()
+			cp.logCloser = nil
+		}
+	}()
+
+	// Load metadata from plugin.yaml so it can change without rebuilding the .so
+	metadata, err := loadMetadata(pc.MetadataPath)
+	if err != nil {
+		return err
+	}
+	if err := metadata.Validate(cp.bareHandlers()); err != nil {
+		return fmt.Errorf("invalid plugin metadata: %w", err)
+	}
+	cp.metadata = &metadata
+
+	// Refuse to run in a host outside the declared compatibility range
+	host, err := hostInfoFromConfig(config, cp.host)
+	if err != nil {
+		return err
+	}
+	negotiation := negotiate(metadata, host)
+	cp.negotiation = &negotiation
+	if err := negotiation.Err(); err != nil {
+		return err
+	}
+
+	cp.tracer, cp.tracerShutdown, err = newTracer(config, metadata.Version)
+	if err != nil {
+		return err
+	}
+
+	cp.permissions = newPermissionPolicy(config)
+	if err := cp.permissions.checkEndpoints(metadata); err != nil {
+		return fmt.Errorf("invalid plugin metadata: %w", err)
+	}
+
+	authConfig, err := authConfigFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.auth = nil
+	if authConfig.Enabled {
+		if cp.auth, err = newAuthenticator(authConfig); err != nil {
+			return fmt.Errorf("invalid auth config: %w", err)
+		}
+	}
+
+	rbacConfig, err := rbacConfigFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.rbac = nil
+	if rbacConfig.Enabled {
+		// Roles come from verified tokens, so RBAC needs authentication
+		if cp.auth == nil {
+			return fmt.Errorf("rbac requires auth to be enabled")
+		}
+		if cp.rbac, err = newRoleBinder(rbacConfig, metadata); err != nil {
+			return fmt.Errorf("invalid rbac config: %w", err)
+		}
+	}
+
+	rateLimits, err := rateLimitConfigFromConfig(config)
+	if err != nil {
+		return err
+	}
+	if err := rateLimits.Validate(metadata); err != nil {
+		return fmt.Errorf("invalid rateLimit config: %w", err)
+	}
+	cp.rateLimiter = nil
+	if rateLimits.Global != nil || rateLimits.PerCaller != nil || len(rateLimits.Endpoints) > 0 {
+		cp.rateLimiter = newRateLimiter(rateLimits)
+	}
+
+	requestTimeouts, err := requestTimeoutConfigFromConfig(config)
+	if err != nil {
+		return err
+	}
+	if err := requestTimeouts.Validate(metadata); err != nil {
+		return fmt.Errorf("invalid requestTimeout config: %w", err)
+	}
+	cp.requestTimeouts = requestTimeouts
+
+	cp.compressResponses = pc.CompressResponses
+	cp.hostMiddlewares = pc.HostAppliesMiddlewares
+	cp.cors, cp.securityHeaders, err = corsConfigFromConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Jobs are kept in memory unless a persistence file is configured
+	var persistence JobPersistence
+	if pc.JobStorePath != "" {
+		persistence = newFileJobPersistence(pc.JobStorePath)
+	}
+	// A job backend shares the jobs with the other replicas instead
+	jobBackend, err := jobBackendConfigFromConfig(config)
+	if err != nil {
+		return err
+	}
+	if jobBackend != nil && persistence != nil {
+		return fmt.Errorf("jobStorePath and jobBackend can't be used together")
+	}
+	cp.jobs = NewJobManager(persistence, pc.JobRetention)
+	cp.jobs.tracer = cp.tracer
+	cp.jobBackend, cp.consumer = nil, nil
+	if jobBackend != nil {
+		if cp.jobBackend, err = newJobBackend(*jobBackend); err != nil {
+			return err
+		}
+		defer func() {
+			if !cp.initialized {
+				cp.jobBackend.Close()
+			}
+		}()
+		cp.jobs.SetBackend(cp.jobBackend)
+		cp.jobQueueIdentity = jobBackend.Identity
+		cp.jobTouchInterval = time.Duration(jobBackend.AckWaitSeconds) * time.Second / 3
+	}
+	cp.jobs.SetPool(pc.MaxConcurrentJobs, pc.JobQueueSize)
+	cp.queueRetryAfter = pc.JobQueueRetryAfterSeconds
+	cp.resumable = make(map[string]resumableJob)
+	cp.broadcaster = newStatusBroadcaster()
+	cp.statusCache = newStatusCache(time.Duration(pc.StatusCacheTTLSeconds) * time.Second)
+	cp.logs = NewLogHub(pc.LogBufferSize)
+	cp.batches = NewBatchManager()
+	cp.batchConcurrency = pc.BatchConcurrency
+	if cp.batchConcurrency < 1 {
+		cp.batchConcurrency = 1
+	}
+	cp.maxBatchSize = pc.MaxBatchSize
+	cp.maxUploadBytes = int64(pc.MaxKubeconfigUploadBytes)
+	cp.activeJobsThreshold = pc.HealthActiveJobsThreshold
+	cp.webhookBacklogThreshold = pc.HealthWebhookBacklogThreshold
+	cp.finalizerTimeout = time.Duration(pc.FinalizerTimeoutSeconds) * time.Second
+	cp.detachSafety = pc.DetachSafety
+	cp.defaultDrainTimeout = time.Duration(pc.DrainTimeoutSeconds) * time.Second
+	cp.requireApproval = pc.RequireApproval
+	cp.approvals = nil
+	if cp.requireApproval != approvalOff {
+		// Telling the approver from the requester needs verified identities
+		if cp.auth == nil {
+			return fmt.Errorf("requireApproval requires auth to be enabled")
+		}
+		cp.approvals = NewApprovalManager(time.Duration(pc.ApprovalExpirySeconds)*time.Second, pc.ApprovalRetention)
+	}
+	cp.shutdownTimeout = time.Duration(pc.ShutdownTimeoutSeconds) * time.Second
+	cp.idempotency = newIdempotencyCache(time.Duration(pc.IdempotencyTTLSeconds) * time.Second)
+	cp.webhooks = NewWebhookNotifier(
+		time.Duration(pc.WebhookTimeoutSeconds)*time.Second,
+		pc.WebhookMaxAttempts,
+		time.Duration(pc.WebhookBackoffSeconds)*time.Second,
+	)
+	hooks, err := webhooksFromConfig(config)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if _, err := cp.webhooks.Add(hook); err != nil {
+			return fmt.Errorf("invalid webhook %s: %w", hook.URL, err)
+		}
+	}
+	cp.events = NewEventBus()
+	lifecycleEvents := make([]string, 0, len(webhookEvents))
+	for eventType := range webhookEvents {
+		lifecycleEvents = append(lifecycleEvents, eventType)
+	}
+	cp.events.Subscribe("webhooks", webhookEventSink{notifier: cp.webhooks}, lifecycleEvents)
+	sinkConfigs, err := eventSinksFromConfig(config)
+	if err != nil {
+		return err
+	}
+	for i, sinkConfig := range sinkConfigs {
+		sink, err := newEventSink(sinkConfig)
+		if err != nil {
+			return fmt.Errorf("invalid eventSinks[%d]: %w", i, err)
+		}
+		cp.events.Subscribe(sinkConfig.Type, sink, sinkConfig.Types)
+	}
+	cp.jobs.events = cp.events
+	bindings, err := bindingPolicyConfigFromConfig(config)
+	if err != nil {
+		return err
+	}
+	bindingTemplates, err := bindings.manifestTemplates()
+	if err != nil {
+		return err
+	}
+	cp.wdsContext = bindings.Context
+	cp.onboarding, err = pipelineFromConfig(config, len(bindingTemplates) > 0)
+	if err != nil {
+		return err
+	}
+	cp.phaseTimeouts, err = phaseTimeoutsFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.postOnboardHooks, err = hooksFromConfig(config, "postOnboardHooks")
+	if err != nil {
+		return err
+	}
+	cp.preDetachHooks, err = hooksFromConfig(config, "preDetachHooks")
+	if err != nil {
+		return err
+	}
+	cp.manifestValues, err = manifestValuesFromConfig(config)
+	if err != nil {
+		return err
+	}
+	if err := cp.checkCharts(&cp.manifestValues); err != nil {
+		return err
+	}
+	cp.readinessGates, err = readinessGatesFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.airGap, err = airGapFromConfig(config)
+	if err != nil {
+		return err
+	}
+	if cp.manifestValues.Registry == "" {
+		cp.manifestValues.Registry = cp.airGap.Registry
+	}
+	cp.pullSecret = nil
+	if cp.airGap.PullSecretPath != "" {
+		if cp.pullSecret, err = loadPullSecret(cp.airGap.PullSecretPath); err != nil {
+			return fmt.Errorf("invalid airGap config: %w", err)
+		}
+	}
+	cp.templates = manifestTemplates
+	if cp.airGap.BundleDir != "" {
+		bundle, err := loadBundle(cp.airGap.BundleDir)
+		if err != nil {
+			return fmt.Errorf("invalid airGap config: %w", err)
+		}
+		cp.templates = append(append([]ManifestTemplate(nil), manifestTemplates...), bundle...)
+	}
+	if len(bindingTemplates) > 0 {
+		cp.templates = append(append([]ManifestTemplate(nil), cp.templates...), bindingTemplates...)
+	}
+	cp.conflictPolicy = pc.ConflictPolicy
+
+	cp.mode, cp.mock, err = modeFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.debugEndpoints = pc.DebugEndpoints
+	cp.settingsReadOnly = pc.SettingsReadOnly
+	// Fault injection breaks requests on purpose, so it is for test
+	// environments only and off unless asked for
+	cp.faults = nil
+	if pc.FaultInjection {
+		cp.faults = newFaultInjector()
+		logger().Warn("Fault injection is enabled, do not use this configuration in production")
+	}
 
 	// Create kubeconfig directory if it doesn't exist
-	if err := os.MkdirAll(cp.kubeconfigDir, 0755); err != nil {
-		log.Printf("Warning: Failed to create kubeconfig directory: %v", err)
+	if err := os.MkdirAll(cp.kubeconfigDir, 0700); err != nil {
+		logger().Warn("Failed to create kubeconfig directory", "error", err)
+	}
+
+	// State persisted locally is encrypted at rest with a key kept outside
+	// the data directory. Keys listed in previousEncryptionKeys still open
+	// state sealed before a rotation.
+	key, err := loadEncryptionKey(config, cp.kubeconfigDir)
+	if err != nil {
+		return err
+	}
+	previousKeys, err := loadPreviousEncryptionKeys(config)
+	if err != nil {
+		return err
+	}
+	box, err := newSecretBox(key, previousKeys...)
+	if err != nil {
+		return err
+	}
+	cp.box = box
+
+	// Open the embedded cluster inventory so clusters survive plugin restarts
+	// An in-memory inventory loses every cluster on restart, so it is only
+	// used when the host opts in with allowMemoryStore. Mock mode never
+	// touches the real inventory.
+	if cp.mode == modeMock {
+		cp.store = newMemoryClusterStore()
+	} else if store, err := newBoltClusterStore(pc.StorePath, box); err != nil {
+		if !pc.AllowMemoryStore {
+			return fmt.Errorf("failed to open cluster store %s: %w", pc.StorePath, err)
+		}
+		logger().Warn("Falling back to in-memory cluster store", "error", err)
+		cp.store = newMemoryClusterStore()
+	} else {
+		cp.store = store
 	}
+	cp.search = newSearchIndex()
+	indexed, err := newIndexedClusterStore(cp.store, cp.search)
+	if err != nil {
+		cp.store.Close()
+		return err
+	}
+	cp.store = indexed
+	cp.closed = false
+	// Don't leak the store handle if a later step fails
+	defer func() {
+		if !cp.initialized {
+			if err := cp.store.Close(); err != nil {
+				logger().Warn("Failed to close cluster store", "error", err)
+			}
+		}
+	}()
 
-	// Check for required tools
-	if err := cp.checkCommand("kubectl"); err != nil {
-		log.Printf("Warning: kubectl not available: %v", err)
+	// Saved cluster kubeconfigs go to the configured secret store
+	secretConfig, err := secretStoreConfigFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.secrets, err = newSecretStore(secretConfig, cp.kubeconfigDir, box)
+	if err != nil {
+		return err
 	}
-	if err := cp.checkCommand("clusteradm"); err != nil {
-		log.Printf("Warning: clusteradm not available: %v", err)
+	cp.kubeconfigs, err = NewKubeconfigManager(filepath.Join(cp.kubeconfigDir, "contexts"), box)
+	if err != nil {
+		return err
+	}
+	// Registered hubs and their kubeconfigs are kept on disk across restarts
+	cp.hubs, err = NewHubRegistry(pc.HubStoreDir, box)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if !cp.initialized {
+			cp.hubs.Close()
+		}
+	}()
+	// Cluster groups belong to one of the environments
+	cp.environments = pc.Environments
+	cp.groups, err = NewGroupManager(pc.GroupStorePath)
+	if err != nil {
+		return err
+	}
+	cp.onboardingPolicy, err = onboardingPolicyFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.admission, err = newAdmissionControllerFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.tenancy, err = tenancyConfigFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.messages, err = newMessageCatalog(config)
+	if err != nil {
+		return err
+	}
+	leaderElection, err := leaderElectionConfigFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.leader = nil
+	if leaderElection != nil {
+		client, err := leaderElectionClient(leaderElection.Context)
+		if err != nil {
+			return fmt.Errorf("failed to connect to the leader election cluster: %w", err)
+		}
+		cp.leader = newLeaderElector(*leaderElection, client)
+	}
+	// Scheduled operations wait on disk, with their kubeconfig sealed, so a
+	// restart doesn't lose them
+	cp.schedules, err = NewScheduleManager(pc.ScheduleStorePath, box, pc.ScheduleRetention)
+	if err != nil {
+		return err
+	}
+	// Onboarding jobs checkpoint every step so a crash doesn't leave the
+	// cluster half-joined
+	cp.checkpoints, err = NewCheckpointStore(pc.CheckpointStorePath, box, pc.CheckpointRetention)
+	if err != nil {
+		return err
+	}
+	// The desired membership is kept on disk so reconciling resumes after a restart
+	reconcileInterval := time.Duration(pc.ReconcileIntervalSeconds) * time.Second
+	cp.reconciler, err = newMembershipReconciler(pc.ReconcileStorePath, pc.GitopsDir, reconcileInterval)
+	if err != nil {
+		return err
 	}
 
+	// List the clusters of the cloud accounts discovery is configured with
+	accounts, err := discoveryAccountsFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.discovery = nil
+	if len(accounts) > 0 {
+		interval := pc.DiscoveryIntervalSeconds
+		if interval <= 0 {
+			return fmt.Errorf("discoveryIntervalSeconds must be positive")
+		}
+		cp.discovery = newCloudDiscovery(accounts, time.Duration(interval)*time.Second)
+	}
+
+	// A mock scenario replaces the generated fleet
+	var scenario *Scenario
+	if pc.MockScenario != "" {
+		loaded, err := cp.loadScenarioFile(pc.MockScenario)
+		if err != nil {
+			return err
+		}
+		scenario = &loaded
+	} else if cp.mode == modeMock {
+		if err := cp.seedMockFleet(cp.mock); err != nil {
+			return fmt.Errorf("failed to generate mock fleet: %w", err)
+		}
+		logger().Info("Serving a mock fleet", "clusters", cp.mock.FleetSize, "seed", cp.mock.Seed)
+	}
+
+	// Flag clusters whose agent stopped renewing its lease on the hub. Mock
+	// clusters have no hub to watch.
+	cp.heartbeats = nil
+	if cp.mode == modeLive && pc.MonitorHeartbeats {
+		cp.heartbeatStale = time.Duration(pc.HeartbeatStaleSeconds) * time.Second
+		cp.heartbeats = newPeriodicCheck(time.Duration(pc.HeartbeatIntervalSeconds)*time.Second, cp.checkHeartbeats)
+	}
+
+	// Warn about pending clusters whose join token is about to expire
+	cp.tokens = NewTokenManager()
+	cp.tokenChecks = nil
+	cp.tokenWarning = time.Duration(pc.TokenWarningSeconds) * time.Second
+	if cp.mode == modeLive && pc.MonitorTokens {
+		cp.tokenChecks = newPeriodicCheck(time.Duration(pc.TokenCheckIntervalSeconds)*time.Second, cp.checkTokens)
+	}
+
+	// Collect the resources of the fleet for capacity planning
+	cp.resourceCollection = nil
+	if cp.mode == modeLive && pc.CollectResources {
+		cp.resourceCollection = newPeriodicCheck(time.Duration(pc.ResourceIntervalSeconds)*time.Second, cp.collectResources)
+	}
+
+	cp.versionSkew, err = versionSkewFromConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Keep status transitions for the cluster timelines
+	cp.historyRetention, cp.historyMaxEntries, err = historyRetentionFromConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.historyPrune = newPeriodicCheck(time.Hour, cp.pruneHistory)
+
+	// Track availability SLOs from the same history
+	cp.slos, err = slosFromConfig(config, cp.historyRetention)
+	if err != nil {
+		return err
+	}
+	cp.sloAlerts = &sloAlerts{}
+	cp.sloChecks = nil
+	if len(cp.slos) > 0 {
+		interval := pc.SLOCheckIntervalSeconds
+		if interval <= 0 {
+			return fmt.Errorf("sloCheckIntervalSeconds must be positive")
+		}
+		cp.sloChecks = newPeriodicCheck(time.Duration(interval)*time.Second, cp.checkSLOs)
+	}
+
+	// Mirror ManagedCluster conditions from the hub so /status reflects reality
+	cp.hub = nil
+	if cp.mode == modeLive && pc.WatchManagedClusters {
+		resync := time.Duration(pc.HubResyncSeconds) * time.Second
+		cp.hub = newManagedClusterWatcher(builtinHub.Context, resync)
+		cp.hub.onChange = cp.onManagedClusterChange
+	}
+
+	// Look for newer releases when a release registry is configured
+	updateCheck, enabled, err := updateCheckConfigFromConfig(config, filepath.Join(cp.kubeconfigDir, "updates"))
+	if err != nil {
+		return err
+	}
+	cp.updates = nil
+	if enabled {
+		cp.updates = newUpdateChecker(updateCheck, metadata.Version)
+		cp.updates.onUpdate = cp.onPluginUpdate
+	}
+
+	cp.audit, err = newAuditLogFromConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Check for required tools and their versions
+	cp.preflight = cp.checkDependencies(context.Background(), metadata)
+	for _, check := range cp.preflight.Failures() {
+		logger().Warn("Dependency failed preflight", "dependency", check.Name, "error", check.Error)
+	}
+
+	// Move state sealed with a previous key, or not at all, under the current key
+	cp.resealState()
+
+	// Nothing can fail from here on, so the background workers can't leak.
+	// With leader election they wait for this replica to lead.
+	if cp.leader != nil {
+		cp.leader.Start(cp.startWorkers, cp.stopWorkers)
+	} else {
+		cp.startWorkers()
+	}
+	// Every replica runs queued jobs, leading or not
+	if jobBackend != nil && jobBackend.consumes() {
+		cp.startJobConsumer()
+	}
+	cp.resumeCheckpointedJobs()
+	if scenario != nil {
+		if err := cp.playScenario(*scenario); err != nil {
+			logger().Warn("Failed to play mock scenario", "scenario", scenario.Name, "error", err)
+		}
+	}
 	cp.initialized = true
-	log.Println("✅ Cluster plugin initialized successfully with real onboarding capabilities")
+	logger().Info("Cluster plugin initialized")
 	return nil
 }
 
+// startWorkers starts the background workers: watches, periodic checks,
+// scheduled operations and membership reconciling
+func (cp *ClusterPlugin) startWorkers() {
+	cp.workersMutex.Lock()
+	defer cp.workersMutex.Unlock()
+	if cp.workersRunning {
+		return
+	}
+	cp.workersRunning = true
+	if cp.hub != nil {
+		cp.hub.Start()
+	}
+	if cp.updates != nil {
+		cp.updates.Start()
+	}
+	cp.schedules.Start(cp.startScheduled)
+	cp.reconciler.start(cp.reconcileMembership)
+	if cp.discovery != nil {
+		cp.discovery.check.Start()
+	}
+	if cp.heartbeats != nil {
+		cp.heartbeats.Start()
+	}
+	if cp.historyPrune != nil {
+		cp.historyPrune.Start()
+	}
+	if cp.tokenChecks != nil {
+		cp.tokenChecks.Start()
+	}
+	if cp.resourceCollection != nil {
+		cp.resourceCollection.Start()
+	}
+	if cp.sloChecks != nil {
+		cp.sloChecks.Start()
+	}
+}
+
+// stopWorkers stops the background workers, waiting for running checks.
+// Jobs they started keep running.
+func (cp *ClusterPlugin) stopWorkers() {
+	cp.workersMutex.Lock()
+	defer cp.workersMutex.Unlock()
+	if !cp.workersRunning {
+		return
+	}
+	cp.workersRunning = false
+	cp.schedules.Stop()
+	cp.reconciler.stop()
+	if cp.hub != nil {
+		cp.hub.Stop()
+	}
+	if cp.heartbeats != nil {
+		cp.heartbeats.Stop()
+	}
+	if cp.tokenChecks != nil {
+		cp.tokenChecks.Stop()
+	}
+	if cp.resourceCollection != nil {
+		cp.resourceCollection.Stop()
+	}
+	if cp.historyPrune != nil {
+		cp.historyPrune.Stop()
+	}
+	if cp.sloChecks != nil {
+		cp.sloChecks.Stop()
+	}
+	if cp.updates != nil {
+		cp.updates.Stop()
+	}
+	if cp.discovery != nil {
+		cp.discovery.check.Stop()
+	}
+}
+
 // GetMetadata returns plugin metadata
 func (cp *ClusterPlugin) GetMetadata() PluginMetadata {
-	return PluginMetadata{
-		ID:          "kubestellar-cluster-plugin",
-		Name:        "KubeStellar Cluster Management",
-		Version:     "1.0.0",
-		Description: "Plugin for cluster onboarding and detachment operations with real functionality",
-		Author:      "CNCF LFX Mentee",
-		Endpoints: []EndpointConfig{
-			{Path: "/onboard", Method: "POST", Handler: "OnboardClusterHandler"},
-			{Path: "/detach", Method: "POST", Handler: "DetachClusterHandler"},
-			{Path: "/status", Method: "GET", Handler: "GetClusterStatusHandler"},
-		},
-		Dependencies: []string{"kubectl", "clusteradm"},
-		Permissions:  []string{"cluster.read", "cluster.write"},
-		Compatibility: map[string]string{
-			"kubestellar": ">=0.21.0",
-			"go":          ">=1.21",
-		},
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+
+	if cp.metadata == nil {
+		return defaultMetadata()
 	}
+	metadata := *cp.metadata
+	if cp.updates != nil || cp.negotiation != nil {
+		extensions := make(map[string]interface{}, len(metadata.Extensions)+2)
+		for key, value := range metadata.Extensions {
+			extensions[key] = value
+		}
+		if cp.updates != nil {
+			extensions["update"] = cp.updates.Status()
+		}
+		if cp.negotiation != nil {
+			extensions["compatibility"] = *cp.negotiation
+		}
+		metadata.Extensions = extensions
+	}
+	return metadata
 }
 
-// GetHandlers returns the plugin's HTTP handlers
+// GetHandlers returns the plugin's HTTP handlers, each guarded by the
+// permission its endpoint declares in the metadata. When the host applies
+// GetMiddlewares itself the handlers come bare.
 func (cp *ClusterPlugin) GetHandlers() map[string]gin.HandlerFunc {
+	handlers := cp.bareHandlers()
+	cp.mutex.RLock()
+	hostMiddlewares := cp.hostMiddlewares
+	cp.mutex.RUnlock()
+	if hostMiddlewares {
+		return handlers
+	}
+	for name, handler := range handlers {
+		handlers[name] = cp.wrapHandler(name, handler)
+	}
+	return handlers
+}
+
+// bareHandlers returns the endpoint handlers without the concerns of
+// handlerWrappers
+func (cp *ClusterPlugin) bareHandlers() map[string]gin.HandlerFunc {
 	return map[string]gin.HandlerFunc{
-		"OnboardClusterHandler":   cp.OnboardClusterHandler,
-		"DetachClusterHandler":    cp.DetachClusterHandler,
-		"GetClusterStatusHandler": cp.GetClusterStatusHandler,
+		"OnboardClusterHandler":          cp.OnboardClusterHandler,
+		"StreamOnboardingLogsHandler":    cp.StreamOnboardingLogsHandler,
+		"BatchOnboardHandler":            cp.BatchOnboardHandler,
+		"GetBatchHandler":                cp.GetBatchHandler,
+		"ImportClustersHandler":          cp.ImportClustersHandler,
+		"GetImportReportHandler":         cp.GetImportReportHandler,
+		"ImportTerraformHandler":         cp.ImportTerraformHandler,
+		"ListDiscoveredHandler":          cp.ListDiscoveredHandler,
+		"OnboardDiscoveredHandler":       cp.OnboardDiscoveredHandler,
+		"ExportClustersHandler":          cp.ExportClustersHandler,
+		"SearchClustersHandler":          cp.SearchClustersHandler,
+		"GetClusterVersionsHandler":      cp.GetClusterVersionsHandler,
+		"GetClusterHistoryHandler":       cp.GetClusterHistoryHandler,
+		"GetAnalyticsHandler":            cp.GetAnalyticsHandler,
+		"ExportCostsHandler":             cp.ExportCostsHandler,
+		"GetSLOHandler":                  cp.GetSLOHandler,
+		"GetSettingsHandler":             cp.GetSettingsHandler,
+		"GetEffectiveSettingsHandler":    cp.GetEffectiveSettingsHandler,
+		"UpdateSettingsHandler":          cp.UpdateSettingsHandler,
+		"GetCapabilitiesHandler":         cp.GetCapabilitiesHandler,
+		"SetDesiredMembershipHandler":    cp.SetDesiredMembershipHandler,
+		"GetReconcileStatusHandler":      cp.GetReconcileStatusHandler,
+		"ClearDesiredMembershipHandler":  cp.ClearDesiredMembershipHandler,
+		"GitWebhookHandler":              cp.GitWebhookHandler,
+		"LoadScenarioHandler":            cp.LoadScenarioHandler,
+		"GetScenarioHandler":             cp.GetScenarioHandler,
+		"GetFaultsHandler":               cp.GetFaultsHandler,
+		"SetFaultsHandler":               cp.SetFaultsHandler,
+		"ClearFaultsHandler":             cp.ClearFaultsHandler,
+		"GetPprofIndexHandler":           cp.GetPprofIndexHandler,
+		"GetPprofProfileHandler":         cp.GetPprofProfileHandler,
+		"GetDebugVarsHandler":            cp.GetDebugVarsHandler,
+		"DetachClusterHandler":           cp.DetachClusterHandler,
+		"GetClusterStatusHandler":        cp.GetClusterStatusHandler,
+		"StreamClusterStatusHandler":     cp.StreamClusterStatusHandler,
+		"ListJobsHandler":                cp.ListJobsHandler,
+		"ListSchedulesHandler":           cp.ListSchedulesHandler,
+		"CancelScheduleHandler":          cp.CancelScheduleHandler,
+		"ListApprovalsHandler":           cp.ListApprovalsHandler,
+		"ListGroupsHandler":              cp.ListGroupsHandler,
+		"CreateGroupHandler":             cp.CreateGroupHandler,
+		"GetGroupHandler":                cp.GetGroupHandler,
+		"UpdateGroupHandler":             cp.UpdateGroupHandler,
+		"DeleteGroupHandler":             cp.DeleteGroupHandler,
+		"ApproveHandler":                 cp.ApproveHandler,
+		"GetJobHandler":                  cp.GetJobHandler,
+		"CancelJobHandler":               cp.CancelJobHandler,
+		"GetJobCheckpointsHandler":       cp.GetJobCheckpointsHandler,
+		"GetPreflightHandler":            cp.GetPreflightHandler,
+		"GetHealthDetailsHandler":        cp.GetHealthDetailsHandler,
+		"ListAuditHandler":               cp.ListAuditHandler,
+		"ListTemplatesHandler":           cp.ListTemplatesHandler,
+		"GetClusterDetailHandler":        cp.GetClusterDetailHandler,
+		"VerifyClusterHandler":           cp.VerifyClusterHandler,
+		"RepairClusterHandler":           cp.RepairClusterHandler,
+		"GetClusterTokenHandler":         cp.GetClusterTokenHandler,
+		"RotateClusterTokenHandler":      cp.RotateClusterTokenHandler,
+		"UploadKubeconfigHandler":        cp.UploadKubeconfigHandler,
+		"ListKubeconfigContextsHandler":  cp.ListKubeconfigContextsHandler,
+		"DeleteKubeconfigContextHandler": cp.DeleteKubeconfigContextHandler,
+		"PatchClusterLabelsHandler":      cp.PatchClusterLabelsHandler,
+		"PatchClusterHandler":            cp.PatchClusterHandler,
+		"ListAddonsHandler":              cp.ListAddonsHandler,
+		"BulkAddonHandler":               cp.BulkAddonHandler,
+		"GetClusterAddonsHandler":        cp.GetClusterAddonsHandler,
+		"EnableClusterAddonHandler":      cp.EnableClusterAddonHandler,
+		"DisableClusterAddonHandler":     cp.DisableClusterAddonHandler,
+		"GetOpenAPIHandler":              cp.GetOpenAPIHandler,
+		"ListWebhooksHandler":            cp.ListWebhooksHandler,
+		"CreateWebhookHandler":           cp.CreateWebhookHandler,
+		"DeleteWebhookHandler":           cp.DeleteWebhookHandler,
+		"ListHubsHandler":                cp.ListHubsHandler,
+		"RegisterHubHandler":             cp.RegisterHubHandler,
+		"SelectHubHandler":               cp.SelectHubHandler,
+		"DeleteHubHandler":               cp.DeleteHubHandler,
+		"ProvisionClusterHandler":        cp.ProvisionClusterHandler,
+		"DeprovisionClusterHandler":      cp.DeprovisionClusterHandler,
+		"ListSchemasHandler":             cp.ListSchemasHandler,
 	}
 }
 
-// Health performs a health check
+// Health performs a health check of every plugin component, failing when
+// any of them is unhealthy; GET /healthz/details reports them one by one
 func (cp *ClusterPlugin) Health() error {
-	if !cp.initialized {
-		return fmt.Errorf("plugin not initialized")
+	_, err := cp.healthReport()
+
+	// Tell webhooks when the plugin turns unhealthy, once per transition
+	if err != nil && !errors.Is(err, errNotInitialized) && !cp.degraded.Swap(true) {
+		cp.notify(eventPluginHealthDegraded, "", "", err.Error())
+	} else if err == nil {
+		cp.degraded.Store(false)
 	}
-	return nil
+	return err
 }
 
+// errNotInitialized is reported by Health before Initialize succeeds
+var errNotInitialized = errors.New("plugin not initialized")
+
 // Cleanup performs cleanup operations
 func (cp *ClusterPlugin) Cleanup() error {
 	cp.mutex.Lock()
-	defer cp.mutex.Unlock()
+	if !cp.initialized {
+		cp.mutex.Unlock()
+		return nil
+	}
 	cp.initialized = false
-	log.Println("🧹 Cluster plugin cleaned up")
-	return nil
-}
+	cp.mutex.Unlock()
 
-// checkCommand verifies that a command is available in PATH
-func (cp *ClusterPlugin) checkCommand(command string) error {
-	_, err := exec.LookPath(command)
-	return err
+	// Scheduled operations and reconcile passes must not start jobs while
+	// they drain. Stopping the campaign hands the Lease to another replica.
+	if cp.leader != nil {
+		cp.leader.Stop()
+	}
+	cp.stopWorkers()
+	cp.stopJobConsumer()
+
+	// Jobs update cluster status under the plugin lock, so drain them before taking it
+	logger().Info("Draining in-flight jobs", "timeout", cp.shutdownTimeout)
+	interrupted := cp.jobs.Shutdown(cp.shutdownTimeout)
+	// Interrupted queued jobs go back to the queue for another replica
+	if cp.jobBackend != nil {
+		cp.waitQueuedJobs(5 * time.Second)
+		if err := cp.jobBackend.Close(); err != nil {
+			logger().Warn("Failed to close the job backend", "error", err)
+		}
+	}
+
+	cp.events.Close(5 * time.Second)
+	cp.webhooks.Close(5 * time.Second)
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	// Record the clusters whose jobs never stopped so a reload doesn't show
+	// them as still in progress, then shut out any late writes
+	for _, job := range interrupted {
+		cp.putStatus(ClusterStatus{
+			ClusterName: job.ClusterName,
+			JobID:       job.ID,
+			Status:      "Failed",
+			Message:     job.Message,
+			LastUpdated: job.CompletedAt,
+		})
+	}
+	cp.stopScenario()
+	cp.closed = true
+	cp.broadcaster.Close()
+	cp.audit.Close()
+	cp.hubs.Close()
+	if cp.store != nil {
+		if err := cp.store.Close(); err != nil {
+			logger().Warn("Failed to close cluster store", "error", err)
+		}
+	}
+	if cp.tracerShutdown != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := cp.tracerShutdown(ctx); err != nil {
+			logger().Warn("Failed to flush traces", "error", err)
+		}
+		cancel()
+	}
+	logger().Info("Cluster plugin cleaned up")
+	if cp.logCloser != nil {
+		pluginLogger.Store(defaultLogger())
+		cp.logCloser.Close()
+		cp.logCloser = nil
+	}
+	return nil
 }
 
 // OnboardClusterHandler handles cluster onboarding requests with enhanced real functionality
 func (cp *ClusterPlugin) OnboardClusterHandler(c *gin.Context) {
-	log.Println("🚀 Plugin: Handling REAL cluster onboarding request")
+	requestLogger(c).Debug("Handling cluster onboarding request")
 
 	contentType := c.GetHeader("Content-Type")
 	var kubeconfigData []byte
 	var clusterName string
 	var useLocalKubeconfig bool = false
+	var labels, annotations map[string]string
+	var values *ManifestValues
+	var schedule *ScheduleSpec
+	var provider string
+	dryRun := c.Query("dryRun") == "true"
+	hubName := c.Query("hub")
 
 	// Handle different content types (same as before)
 	if strings.Contains(contentType, "multipart/form-data") {
-		file, fileErr := c.FormFile("kubeconfig")
-		clusterName = c.PostForm("name")
-
-		if clusterName != "" && (fileErr != nil || file == nil) {
-			useLocalKubeconfig = true
-		} else if fileErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retrieve kubeconfig file"})
+		data, ok := cp.readKubeconfigUpload(c)
+		if !ok {
 			return
-		} else if clusterName == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Cluster name is required"})
-			return
-		} else {
-			f, err := file.Open()
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open kubeconfig file"})
-				return
-			}
-			defer f.Close()
+		}
+		clusterName = c.PostForm("name")
+		hubName = c.PostForm("hub")
 
-			kubeconfigData, err = io.ReadAll(f)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read kubeconfig file"})
+		switch {
+		case data != nil:
+			if kubeconfigData, clusterName, ok = cp.onboardingUpload(c, data, clusterName); !ok {
 				return
 			}
+		case clusterName != "":
+			useLocalKubeconfig = true
+		default:
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Either a kubeconfig file or a cluster name is required")
+			return
 		}
 	} else if strings.Contains(contentType, "application/json") {
-		var req struct {
-			Kubeconfig  string `json:"kubeconfig"`
-			ClusterName string `json:"clusterName"`
+		var req OnboardRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload, clusterName is required")
+			return
 		}
-
-		if err := c.BindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		if err := req.Validate(); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 			return
 		}
-
-		clusterName = req.ClusterName
-		if clusterName == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "ClusterName is required"})
+		if err := cp.checkCharts(req.values()); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 			return
 		}
 
-		if req.Kubeconfig == "" {
+		clusterName = req.ClusterName
+		labels, annotations = req.Labels, req.Annotations
+		values = req.values()
+		schedule = req.Schedule
+		provider = providerName(req.Provider)
+		dryRun = dryRun || req.DryRun
+		hubName = req.Hub
+		if req.Kubeconfig == "" && req.KubeconfigSecretRef == nil && req.Context == "" && req.Provider == nil {
 			useLocalKubeconfig = true
 		} else {
-			kubeconfigData = []byte(req.Kubeconfig)
+			var err error
+			kubeconfigData, err = cp.resolveKubeconfig(c.Request.Context(), req)
+			if cp.abortOnContext(c) {
+				return
+			}
+			if err != nil {
+				respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+				return
+			}
 		}
 	} else {
 		clusterName = c.Query("name")
 		if clusterName == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Cluster name parameter is required"})
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Cluster name parameter is required")
 			return
 		}
 		useLocalKubeconfig = true
 	}
 
+	if err := validateClusterName(clusterName); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	// Refuse early what the policy forbids; beginOnboarding checks again
+	// under the lock
+	if err := cp.checkOnboardingPolicy(clusterName, provider, labels); err != nil {
+		if !respondPolicyViolation(c, err) {
+			respondStoreError(c, err)
+		}
+		return
+	}
+	// Onboard to the requested hub, or the default one
+	hub, err := cp.hubs.Get(hubName)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeHubNotFound, err.Error())
+		return
+	}
+	if respondAdmission(c, cp.admit(c.Request.Context(), AdmissionInput{
+		Operation:   "onboard",
+		ClusterName: clusterName,
+		Hub:         hub.Name,
+		Provider:    provider,
+		Labels:      labels,
+		Annotations: annotations,
+		DryRun:      dryRun,
+		Scheduled:   schedule != nil,
+	})) {
+		return
+	}
+
 	// Get kubeconfig from local if needed
 	if useLocalKubeconfig {
-		var err error
 		kubeconfigData, err = cp.getClusterConfigFromLocal(clusterName)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to find cluster '%s' in local kubeconfig: %v", clusterName, err)})
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Failed to find cluster '%s' in local kubeconfig: %v", clusterName, err))
+			return
+		}
+	}
+
+	if dryRun {
+		plan := cp.planOnboarding(c.Request.Context(), hub, clusterName, kubeconfigData, values)
+		if cp.abortOnContext(c) {
+			return
+		}
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
+	if schedule != nil {
+		if existing, exists, err := cp.store.Get(clusterName); err != nil {
+			respondStoreError(c, err)
+			return
+		} else if exists {
+			respondProblem(c, http.StatusConflict, CodeClusterExists, fmt.Sprintf("Cluster '%s' is already onboarded (status: %s)", clusterName, existing.Status),
+				"jobId", existing.JobID, "cluster", existing)
+			return
+		}
+		cp.scheduleOperation(c, Schedule{
+			Type:           "onboard",
+			ClusterName:    clusterName,
+			Hub:            hub.Name,
+			Spec:           *schedule,
+			Provider:       provider,
+			Labels:         labels,
+			Annotations:    annotations,
+			ManifestValues: values,
+			kubeconfig:     kubeconfigData,
+		})
+		return
+	}
+
+	// Check if cluster is already being onboarded, either by name or by key
+	jobID, existing, err := cp.beginOnboarding(c.Request.Context(), clusterName, hub.Name, provider, c.GetHeader(idempotencyKeyHeader), labels, annotations)
+	if respondPolicyViolation(c, err) {
+		return
+	}
+	if errors.Is(err, errIdempotencyKeyReused) {
+		respondProblem(c, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, err.Error())
+		return
+	}
+	if errors.Is(err, errTenantConflict) {
+		respondProblem(c, http.StatusConflict, CodeClusterExists, err.Error())
+		return
+	}
+	if errors.Is(err, errJobQueueFull) {
+		cp.respondQueueFull(c)
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if existing != nil {
+		if cp.conflictPolicy == conflictPolicyReturn {
+			c.JSON(http.StatusOK, OnboardResponse{
+				Message:     message(c, MsgOnboardingAlreadyRequested, "cluster", clusterName),
+				MessageCode: MsgOnboardingAlreadyRequested,
+				Status:      existing.Status,
+				Plugin:      "kubestellar-cluster-plugin",
+				ClusterName: clusterName,
+				JobID:       existing.JobID,
+				Timestamp:   time.Now().Format(time.RFC3339),
+			})
 			return
 		}
+		respondProblem(c, http.StatusConflict, CodeClusterExists, fmt.Sprintf("Cluster '%s' is already onboarded (status: %s)", clusterName, existing.Status),
+			"jobId", existing.JobID, "cluster", *existing)
+		return
 	}
 
-	// Check if cluster is already being onboarded
+	// Start enhanced asynchronous onboarding
+	cp.runJob(jobID, cp.onboardingJob(jobID, clusterName, kubeconfigData, values))
+
+	c.JSON(http.StatusOK, OnboardResponse{
+		Message:       message(c, MsgOnboardingStarted, "cluster", clusterName),
+		MessageCode:   MsgOnboardingStarted,
+		Status:        "Pending",
+		Plugin:        "kubestellar-cluster-plugin",
+		ClusterName:   clusterName,
+		JobID:         jobID,
+		QueuePosition: cp.queuePosition(jobID),
+		Timestamp:     time.Now().Format(time.RFC3339),
+	})
+}
+
+// beginOnboarding registers a pending cluster on hubName together with its
+// onboarding job. If the cluster is already known, or idempotencyKey already
+// started a job, the existing record is returned instead. The cluster name
+// acts as the key when none is given. A new cluster from provider must pass
+// the onboarding policy.
+func (cp *ClusterPlugin) beginOnboarding(ctx context.Context, clusterName, hubName, provider, idempotencyKey string, labels, annotations map[string]string) (string, *ClusterStatus, error) {
 	cp.mutex.Lock()
-	if existing, exists := cp.clusterStatuses[clusterName]; exists {
-		cp.mutex.Unlock()
-		c.JSON(http.StatusConflict, gin.H{
-			"message": fmt.Sprintf("Cluster '%s' is already onboarded (status: %s)", clusterName, existing.Status),
-			"status":  existing.Status,
-			"cluster": existing,
-			"plugin":  "kubestellar-cluster-plugin",
+	defer cp.mutex.Unlock()
+
+	// Cluster names are unique on the hubs, but another tenant's cluster
+	// isn't reported
+	if existing, exists, err := cp.store.Get(clusterName); err == nil && exists && !visibleTo(ctx, existing.Tenant) {
+		return "", nil, fmt.Errorf("%w: %s", errTenantConflict, clusterName)
+	}
+	if idempotencyKey != "" {
+		if entry, found := cp.idempotency.Lookup(idempotencyKey); found {
+			if entry.clusterName != clusterName {
+				return "", nil, errIdempotencyKeyReused
+			}
+			if existing, exists, err := cp.store.Get(clusterName); err == nil && exists && existing.JobID == entry.jobID {
+				return "", &existing, nil
+			}
+			// The cluster was detached since; report the job the key started
+			previous := ClusterStatus{ClusterName: clusterName, JobID: entry.jobID}
+			if job, ok := cp.jobs.Get(entry.jobID); ok {
+				previous.Status = string(job.State)
+				previous.Message = job.Message
+				previous.LastUpdated = job.UpdatedAt
+			}
+			return "", &previous, nil
+		}
+	}
+
+	existing, exists, err := cp.store.Get(clusterName)
+	if err != nil {
+		return "", nil, err
+	}
+	if exists {
+		return "", &existing, nil
+	}
+	// Checked under the lock so concurrent onboardings can't overrun a quota
+	if err := cp.checkOnboardingPolicy(clusterName, provider, labels); err != nil {
+		return "", nil, err
+	}
+
+	if err := cp.jobs.Admit(); err != nil {
+		return "", nil, err
+	}
+	// Set initial status with enhanced tracking
+	jobID := cp.jobs.Create(ctx, "onboard", clusterName).ID
+	tenant, _ := tenantFromContext(ctx)
+	cp.putStatus(ClusterStatus{
+		ClusterName: clusterName,
+		JobID:       jobID,
+		Status:      "Pending",
+		Message:     "Real onboarding process initiated",
+		LastUpdated: time.Now().Format(time.RFC3339),
+		Hub:         hubName,
+		Labels:      labels,
+		Annotations: annotations,
+		Tenant:      tenant,
+	})
+	if idempotencyKey != "" {
+		cp.idempotency.Store(idempotencyKey, clusterName, jobID)
+	}
+	return jobID, nil, nil
+}
+
+// onboardingJob returns the job body that onboards a cluster and records its final status
+func (cp *ClusterPlugin) onboardingJob(jobID, clusterName string, kubeconfigData []byte, values *ManifestValues) func(ctx context.Context) error {
+	cp.trackJob(resumableJob{JobID: jobID, Type: "onboard", ClusterName: clusterName, ManifestValues: values, kubeconfig: kubeconfigData})
+	return func(ctx context.Context) error {
+		cp.checkpoints.Begin(jobID, clusterName, kubeconfigData, values)
+		err := cp.onboardClusterEnhanced(ctx, jobID, kubeconfigData, clusterName, values)
+		if err == nil {
+			err = cp.runPostOnboardHooks(ctx, jobID, clusterName, kubeconfigData)
+		}
+		cp.mutex.Lock()
+		defer cp.mutex.Unlock()
+		delete(cp.resumable, jobID)
+		// A job stopped by a shutdown resumes from its checkpoint on restart,
+		// unless it was handed over to the next plugin version
+		if ctx.Err() == nil || !cp.jobs.Draining() || cp.closed {
+			cp.checkpoints.Finish(jobID)
+		}
+		if err != nil {
+			logger().Error("Cluster onboarding failed", "cluster", clusterName, "job", jobID, "error", err)
+			cp.logs.Append(clusterName, "error", fmt.Sprintf("Onboarding failed: %v", err))
+			cp.putStatus(ClusterStatus{
+				ClusterName:    clusterName,
+				JobID:          jobID,
+				Status:         "Failed",
+				Message:        fmt.Sprintf("Onboarding failed: %v", err),
+				LastUpdated:    time.Now().Format(time.RFC3339),
+				ManifestValues: values,
+			})
+			cp.notify(eventClusterFailed, clusterName, jobID, fmt.Sprintf("Onboarding failed: %v", err))
+		} else {
+			cp.putStatus(ClusterStatus{
+				ClusterName:    clusterName,
+				JobID:          jobID,
+				Status:         "Ready",
+				Message:        "Cluster successfully onboarded to KubeStellar",
+				LastUpdated:    time.Now().Format(time.RFC3339),
+				ManifestValues: values,
+			})
+			logger().Info("Cluster onboarded", "cluster", clusterName, "job", jobID)
+			cp.notify(eventClusterOnboarded, clusterName, jobID, "Cluster successfully onboarded to KubeStellar")
+		}
+		return err
+	}
+}
+
+// DetachClusterHandler handles cluster detachment requests with enhanced functionality
+func (cp *ClusterPlugin) DetachClusterHandler(c *gin.Context) {
+	requestLogger(c).Debug("Handling cluster detachment request")
+
+	var req DetachRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload, clusterName is required")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	spokeKubeconfig := []byte(req.Kubeconfig)
+	if req.Context != "" {
+		data, err := cp.kubeconfigs.Get(req.Context)
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		spokeKubeconfig = data
+	}
+	if req.Hub != "" {
+		if _, err := cp.hubs.Get(req.Hub); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if req.ClusterName != "" {
+		if existing, exists, err := cp.store.Get(req.ClusterName); err == nil && exists && !visibleTo(c.Request.Context(), existing.Tenant) {
+			respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found", req.ClusterName))
+			return
+		}
+	}
+	// A named hub guards against detaching a same-named cluster of another hub
+	if req.Hub != "" && req.ClusterName != "" {
+		if existing, exists, err := cp.store.Get(req.ClusterName); err == nil && exists && hubName(existing) != req.Hub {
+			respondProblem(c, http.StatusConflict, CodeConflict, fmt.Sprintf("Cluster '%s' is onboarded to hub '%s', not '%s'", req.ClusterName, hubName(existing), req.Hub))
+			return
+		}
+	}
+	if respondAdmission(c, cp.admit(c.Request.Context(), AdmissionInput{
+		Operation:     "detach",
+		ClusterName:   req.ClusterName,
+		LabelSelector: req.LabelSelector,
+		Group:         req.Group,
+		Hub:           req.Hub,
+		Force:         req.Force,
+		DryRun:        req.DryRun,
+		Scheduled:     req.Schedule != nil,
+	})) {
+		return
+	}
+	if reason := cp.approvalReason(req); reason != "" {
+		cp.requestApproval(c, req, spokeKubeconfig, reason)
+		return
+	}
+	cp.detach(c, req, spokeKubeconfig)
+}
+
+// detach plans, schedules or starts the detachment of a validated request
+func (cp *ClusterPlugin) detach(c *gin.Context, req DetachRequest, spokeKubeconfig []byte) {
+	if req.LabelSelector != "" || req.Group != "" {
+		cp.detachSelected(c, req)
+		return
+	}
+	clusterName := req.ClusterName
+
+	if req.DryRun {
+		plan := cp.planDetachment(c.Request.Context(), clusterName, spokeKubeconfig, req.Force, cp.drainTimeout(req))
+		if cp.abortOnContext(c) {
+			return
+		}
+		c.JSON(http.StatusOK, plan)
+		return
+	}
+
+	if req.Schedule != nil {
+		if _, exists, err := cp.store.Get(clusterName); err != nil {
+			respondStoreError(c, err)
+			return
+		} else if !exists {
+			respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin", clusterName))
+			return
+		}
+		cp.scheduleOperation(c, Schedule{
+			Type:         "detach",
+			ClusterName:  clusterName,
+			Spec:         *req.Schedule,
+			Force:        req.Force,
+			DrainSeconds: int(cp.drainTimeout(req) / time.Second),
+			SkipHooks:    req.SkipHooks,
+			kubeconfig:   spokeKubeconfig,
 		})
 		return
 	}
 
-	// Set initial status with enhanced tracking
-	cp.clusterStatuses[clusterName] = ClusterStatus{
-		ClusterName: clusterName,
-		Status:      "Pending",
-		Message:     "Real onboarding process initiated",
-		LastUpdated: time.Now().Format(time.RFC3339),
-	}
-	cp.mutex.Unlock()
-
-	// Start enhanced asynchronous onboarding
-	go func() {
-		err := cp.onboardClusterEnhanced(kubeconfigData, clusterName)
-		cp.mutex.Lock()
-		if err != nil {
-			log.Printf("🔥 Plugin: Cluster '%s' onboarding failed: %v", clusterName, err)
-			cp.clusterStatuses[clusterName] = ClusterStatus{
-				ClusterName: clusterName,
-				Status:      "Failed",
-				Message:     fmt.Sprintf("Onboarding failed: %v", err),
-				LastUpdated: time.Now().Format(time.RFC3339),
+	jobID, existing, err := cp.beginDetach(c.Request.Context(), clusterName, spokeKubeconfig, req.Force, cp.drainTimeout(req), req.SkipHooks)
+	if errors.Is(err, errClusterNotFound) {
+		respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin", clusterName))
+		return
+	}
+	var inUse *ClusterInUseError
+	if errors.As(err, &inUse) {
+		respondProblem(c, http.StatusConflict, CodeClusterInUse, inUse.Error(), "safety", inUse.Report)
+		return
+	}
+	if errors.Is(err, errJobQueueFull) {
+		cp.respondQueueFull(c)
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, DetachResponse{
+		Message:       message(c, MsgDetachStarted, "cluster", clusterName),
+		MessageCode:   MsgDetachStarted,
+		Status:        "Detaching",
+		JobID:         jobID,
+		QueuePosition: cp.queuePosition(jobID),
+		Previous:      existing,
+		Plugin:        "kubestellar-cluster-plugin",
+		Timestamp:     time.Now().Format(time.RFC3339),
+	})
+}
+
+// detachSelected starts detachment of every cluster matching the request's label selector
+func (cp *ClusterPlugin) detachSelected(c *gin.Context, req DetachRequest) {
+	var clusters []ClusterStatus
+	var err error
+	code, params := MsgDetachSelectorStarted, []interface{}{"selector", req.LabelSelector}
+	if req.Group != "" {
+		code, params = MsgDetachGroupStarted, []interface{}{"group", req.Group}
+		_, clusters, err = cp.groupMembers(c.Request.Context(), req.Group)
+		if errors.Is(err, errGroupNotFound) {
+			respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Group '%s' not found", req.Group))
+			return
+		}
+	} else {
+		if _, err := labels.Parse(req.LabelSelector); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid labelSelector: %v", err))
+			return
+		}
+		clusters, err = cp.selectClusters(req.LabelSelector)
+		clusters = tenantClusters(c.Request.Context(), clusters)
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	items := []BatchItem{}
+	for _, cluster := range clusters {
+		if req.Hub != "" && hubName(cluster) != req.Hub {
+			continue
+		}
+		item := BatchItem{ClusterName: cluster.ClusterName}
+		if req.DryRun {
+			if plan := cp.planDetachment(c.Request.Context(), cluster.ClusterName, nil, req.Force, cp.drainTimeout(req)); !plan.Valid {
+				item.Error = "dry run validation failed"
 			}
+		} else if jobID, _, err := cp.beginDetach(c.Request.Context(), cluster.ClusterName, nil, req.Force, cp.drainTimeout(req), req.SkipHooks); err != nil {
+			item.Error = err.Error()
 		} else {
-			cp.clusterStatuses[clusterName] = ClusterStatus{
-				ClusterName: clusterName,
-				Status:      "Ready",
-				Message:     "Cluster successfully onboarded to KubeStellar",
-				LastUpdated: time.Now().Format(time.RFC3339),
-			}
-			log.Printf("✅ Plugin: Cluster '%s' onboarded successfully", clusterName)
+			item.JobID = jobID
+			item.State = JobPending
 		}
-		cp.mutex.Unlock()
-	}()
+		items = append(items, item)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":     fmt.Sprintf("Real cluster '%s' onboarding started via plugin", clusterName),
-		"status":      "Pending",
+		"message":     message(c, code, append(params, "count", len(items))...),
+		"messageCode": code,
+		"dryRun":      req.DryRun,
+		"items":       items,
 		"plugin":      "kubestellar-cluster-plugin",
-		"clusterName": clusterName,
 		"timestamp":   time.Now().Format(time.RFC3339),
 	})
 }
 
-// DetachClusterHandler handles cluster detachment requests with enhanced functionality
-func (cp *ClusterPlugin) DetachClusterHandler(c *gin.Context) {
-	log.Println("🗑️ Plugin: Handling REAL cluster detachment request")
-
-	var req struct {
-		ClusterName string `json:"clusterName" binding:"required"`
-		Force       bool   `json:"force,omitempty"`
-	}
-
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload, clusterName is required"})
-		return
+// errClusterNotFound is returned when an operation targets an unknown cluster
+var errClusterNotFound = errors.New("cluster not found in plugin")
+
+// beginDetach marks a cluster as detaching and starts its detachment job,
+// draining the cluster first for up to drain when it is positive. A cluster
+// still serving workloads is refused with a ClusterInUseError under the
+// refuse detachSafety policy, unless force is set or it is drained. The
+// pre-detach hooks run first unless skipHooks is set.
+func (cp *ClusterPlugin) beginDetach(ctx context.Context, clusterName string, spokeKubeconfig []byte, force bool, drain time.Duration, skipHooks bool) (string, ClusterStatus, error) {
+	message := "Real detachment process started"
+	safety, err := cp.checkDetachSafety(ctx, clusterName, force || drain > 0)
+	if err != nil {
+		return "", ClusterStatus{}, err
 	}
-
-	clusterName := req.ClusterName
-	if clusterName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cluster name is required"})
-		return
+	if safety != nil && !safety.Safe {
+		logger().Warn("Detaching a cluster that still serves workloads", "cluster", clusterName, "targets", safety.summary(), "force", force)
+		message += "; still targeted by " + safety.summary()
 	}
 
 	cp.mutex.Lock()
-	existing, exists := cp.clusterStatuses[clusterName]
+	existing, exists, err := cp.store.Get(clusterName)
+	if err != nil {
+		cp.mutex.Unlock()
+		return "", ClusterStatus{}, err
+	}
 	if !exists {
 		cp.mutex.Unlock()
-		c.JSON(http.StatusNotFound, gin.H{
-			"error":  fmt.Sprintf("Cluster '%s' not found in plugin", clusterName),
-			"plugin": "kubestellar-cluster-plugin",
-		})
-		return
+		return "", ClusterStatus{}, errClusterNotFound
 	}
 
+	if err := cp.jobs.Admit(); err != nil {
+		cp.mutex.Unlock()
+		return "", ClusterStatus{}, err
+	}
 	// Set detaching status
-	cp.clusterStatuses[clusterName] = ClusterStatus{
+	jobID := cp.jobs.Create(ctx, "detach", clusterName).ID
+	cp.putStatus(ClusterStatus{
 		ClusterName: clusterName,
+		JobID:       jobID,
 		Status:      "Detaching",
-		Message:     "Real detachment process started",
+		Message:     message,
 		LastUpdated: time.Now().Format(time.RFC3339),
-	}
+	})
 	cp.mutex.Unlock()
 
 	// Start enhanced asynchronous detachment
-	go func() {
-		err := cp.detachClusterEnhanced(clusterName, req.Force)
+	cp.runJob(jobID, cp.detachJob(jobID, clusterName, spokeKubeconfig, force, drain, skipHooks))
+
+	return jobID, existing, nil
+}
+
+// detachJob returns the job body that detaches a cluster and records the
+// outcome. The pre-detach hooks run first, and a failing one leaves the
+// cluster attached. A positive drain then cordons the cluster and waits that
+// long for its workloads to move elsewhere.
+func (cp *ClusterPlugin) detachJob(jobID, clusterName string, spokeKubeconfig []byte, force bool, drain time.Duration, skipHooks bool) func(ctx context.Context) error {
+	cp.trackJob(resumableJob{JobID: jobID, Type: "detach", ClusterName: clusterName, kubeconfig: spokeKubeconfig, Force: force, DrainSeconds: int(drain / time.Second), SkipHooks: skipHooks})
+	return func(ctx context.Context) error {
+		err := cp.runPreDetachHooks(ctx, jobID, clusterName, spokeKubeconfig, skipHooks)
+		if err == nil && drain > 0 && cp.mode != modeMock {
+			err = cp.drainCluster(ctx, jobID, clusterName, drain, force)
+		}
+		if err == nil {
+			err = cp.detachClusterEnhanced(ctx, clusterName, spokeKubeconfig, force)
+		}
 		cp.mutex.Lock()
+		defer cp.mutex.Unlock()
+		delete(cp.resumable, jobID)
 		if err != nil {
-			log.Printf("🔥 Plugin: Cluster '%s' detachment failed: %v", clusterName, err)
-			cp.clusterStatuses[clusterName] = ClusterStatus{
+			logger().Error("Cluster detachment failed", "cluster", clusterName, "job", jobID, "error", err)
+			cp.putStatus(ClusterStatus{
 				ClusterName: clusterName,
+				JobID:       jobID,
 				Status:      "DetachFailed",
 				Message:     fmt.Sprintf("Detachment failed: %v", err),
 				LastUpdated: time.Now().Format(time.RFC3339),
+			})
+		} else if !cp.closed {
+			if err := cp.store.Delete(clusterName); err != nil {
+				logger().Error("Failed to remove cluster from store", "cluster", clusterName, "error", err)
 			}
-		} else {
-			delete(cp.clusterStatuses, clusterName)
-			log.Printf("✅ Plugin: Cluster '%s' detached successfully", clusterName)
+			cp.statusCache.invalidate()
+			cp.broadcaster.Publish(StatusEvent{
+				ClusterName: clusterName,
+				Status:      "Detached",
+				Previous:    "Detaching",
+				Message:     "Cluster detached from KubeStellar",
+				JobID:       jobID,
+				Timestamp:   time.Now().Format(time.RFC3339),
+			})
+			logger().Info("Cluster detached", "cluster", clusterName, "job", jobID)
+			cp.notify(eventClusterDetached, clusterName, jobID, "Cluster detached from KubeStellar")
 		}
-		cp.mutex.Unlock()
-	}()
-
-	c.JSON(http.StatusOK, gin.H{
-		"message":   fmt.Sprintf("Real cluster '%s' detachment started via plugin", clusterName),
-		"status":    "Detaching",
-		"previous":  existing,
-		"plugin":    "kubestellar-cluster-plugin",
-		"timestamp": time.Now().Format(time.RFC3339),
-	})
-}
-
-// GetClusterStatusHandler returns the status of all clusters with enhanced information
-func (cp *ClusterPlugin) GetClusterStatusHandler(c *gin.Context) {
-	cp.mutex.RLock()
-	defer cp.mutex.RUnlock()
-
-	var clusters []ClusterStatus
-	for _, status := range cp.clusterStatuses {
-		clusters = append(clusters, status)
+		return err
 	}
+}
 
-	// Create summary statistics
+// statusSummary counts the clusters by status
+func (cp *ClusterPlugin) statusSummary(clusters []ClusterStatus) map[string]int {
 	summary := map[string]int{
-		"total":     len(clusters),
-		"ready":     0,
-		"pending":   0,
-		"failed":    0,
-		"detaching": 0,
+		"total":       len(clusters),
+		"ready":       0,
+		"pending":     0,
+		"failed":      0,
+		"detaching":   0,
+		"unreachable": 0,
+	}
+	if cp.hub != nil {
+		summary["available"] = 0
 	}
 
 	for _, cluster := range clusters {
@@ -418,127 +1856,210 @@ func (cp *ClusterPlugin) GetClusterStatusHandler(c *gin.Context) {
 			summary["failed"]++
 		case "Detaching":
 			summary["detaching"]++
+		case statusUnreachable:
+			summary["unreachable"]++
+		}
+		if cluster.ManagedCluster != nil && cluster.ManagedCluster.Available == "True" {
+			summary["available"]++
 		}
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"clusters":  clusters,
-		"summary":   summary,
-		"plugin":    "kubestellar-cluster-plugin",
-		"timestamp": time.Now().Format(time.RFC3339),
-	})
+	return summary
 }
 
-// Enhanced onboarding logic with real KubeStellar integration
-func (cp *ClusterPlugin) onboardClusterEnhanced(kubeconfigData []byte, clusterName string) error {
-	log.Printf("🔄 Plugin: Starting ENHANCED onboarding for cluster %s", clusterName)
-
-	// Step 1: Update status and validate connectivity
-	cp.updateStatus(clusterName, "Validating", "Validating cluster connectivity")
-	if err := cp.validateClusterConnectivity(kubeconfigData); err != nil {
-		return fmt.Errorf("cluster validation failed: %w", err)
+// GetClusterStatusHandler returns the status of all clusters with enhanced information
+func (cp *ClusterPlugin) GetClusterStatusHandler(c *gin.Context) {
+	query, err := parseClusterQuery(c)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	format := c.DefaultQuery("format", statusFormatJSON)
+	if format != statusFormatJSON && format != statusFormatNDJSON {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid format %q, must be %s or %s", format, statusFormatJSON, statusFormatNDJSON))
+		return
 	}
 
-	// Step 2: Get ITS hub context and clients
-	cp.updateStatus(clusterName, "Connecting", "Connecting to ITS hub")
-	itsContext := "its1"
-	hubClientset, hubConfig, err := GetClientSetWithConfigContext(itsContext) // ✅ FIXED: Use local function
+	// ?refresh=true skips the cache and reads the ManagedClusters from the hub
+	refresh := c.Query("refresh") == "true"
+	if refresh && cp.hub != nil {
+		if err := cp.hub.Refresh(c.Request.Context()); err != nil {
+			if cp.abortOnContext(c) {
+				return
+			}
+			respondProblem(c, http.StatusBadGateway, CodeHubUnreachable, fmt.Sprintf("Failed to read ManagedClusters from hub: %v", err))
+			return
+		}
+	}
+	snapshot, err := cp.statusCache.get(refresh, cp.loadStatusSnapshot)
 	if err != nil {
-		return fmt.Errorf("failed to get hub clientset: %w", err)
+		respondStoreError(c, err)
+		return
 	}
+	clusters := query.Filter(tenantClusters(c.Request.Context(), snapshot.clusters))
 
-	// Step 3: Save kubeconfig and create temporary file
-	cp.updateStatus(clusterName, "Preparing", "Preparing cluster configuration")
-	kubeconfigPath := filepath.Join(cp.kubeconfigDir, fmt.Sprintf("%s-kubeconfig", clusterName))
-	if err := cp.saveKubeconfig(kubeconfigPath, string(kubeconfigData)); err != nil {
-		return fmt.Errorf("failed to save kubeconfig: %w", err)
+	// Create summary statistics over every matching cluster, not just this page
+	summary := cp.statusSummary(clusters)
+
+	page, next := query.Page(clusters)
+	if format == statusFormatNDJSON {
+		writeClustersNDJSON(c, page, next, len(clusters))
+		return
+	}
+	response := ClusterStatusResponse{
+		Clusters:  page,
+		Summary:   summary,
+		Continue:  next,
+		Hub:       snapshot.hub,
+		Mode:      cp.mode,
+		Plugin:    "kubestellar-cluster-plugin",
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if cp.updates != nil {
+		update := cp.updates.Status()
+		response.Update = &update
+	}
+	// Clients revalidate with If-None-Match instead of downloading the fleet again
+	etag := statusETag(response)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
 	}
+	c.JSON(http.StatusOK, response)
+}
 
-	tempPath, err := cp.createTempKubeconfig(kubeconfigData, clusterName)
-	if err != nil {
-		return fmt.Errorf("failed to create temp kubeconfig: %w", err)
+// Enhanced onboarding logic with real KubeStellar integration. The work is
+// done by the configured pipeline, see pipeline.go.
+func (cp *ClusterPlugin) onboardClusterEnhanced(ctx context.Context, jobID string, kubeconfigData []byte, clusterName string, values *ManifestValues) error {
+	if cp.mode == modeMock {
+		return cp.mockOperation(ctx, "onboard", jobID, clusterName)
 	}
-	defer os.Remove(tempPath)
+	logger().Info("Starting onboarding", "cluster", clusterName)
 
-	// Step 4: Get join token from hub
-	cp.updateStatus(clusterName, "Retrieving", "Getting join token from hub")
-	joinToken, err := cp.getClusterAdmToken(itsContext)
+	// Save the kubeconfig and give the steps a scratch copy to run the CLIs against
+	if err := cp.advance(ctx, clusterName, "Preparing", "Preparing cluster configuration"); err != nil {
+		return err
+	}
+	hub, err := cp.clusterHub(clusterName)
 	if err != nil {
-		return fmt.Errorf("failed to get token: %w", err)
+		return fmt.Errorf("failed to resolve hub: %w", err)
 	}
-
-	// Step 5: Join cluster to hub
-	cp.updateStatus(clusterName, "Joining", "Joining cluster to KubeStellar hub")
-	if err := cp.joinClusterToHub(tempPath, clusterName, joinToken); err != nil {
-		return fmt.Errorf("failed to join cluster: %w", err)
+	merged := cp.manifestValues.Merge(values)
+	// The saved kubeconfig carries the proxy, so later operations use it too
+	kubeconfigData, err = withProxy(kubeconfigData, merged.Proxy)
+	if err != nil {
+		return fmt.Errorf("failed to apply proxy settings: %w", err)
 	}
-
-	// Step 6: Enhanced CSR approval with multiple attempts
-	cp.updateStatus(clusterName, "Approving", "Approving Certificate Signing Requests")
-	if err := cp.approveClusterCSRsEnhanced(hubClientset, clusterName); err != nil {
-		return fmt.Errorf("failed to approve CSRs: %w", err)
+	if err := cp.saveKubeconfig(clusterName, kubeconfigData); err != nil {
+		return fmt.Errorf("failed to save kubeconfig: %w", err)
 	}
 
-	// Step 7: Wait for managed cluster with better status tracking
-	cp.updateStatus(clusterName, "Creating", "Waiting for managed cluster resource")
-	if err := cp.waitForManagedClusterEnhanced(hubClientset, clusterName); err != nil {
-		return fmt.Errorf("failed to confirm managed cluster creation: %w", err)
+	tempPath, spokeContext, err := cp.createTempKubeconfig(kubeconfigData, clusterName)
+	if err != nil {
+		return fmt.Errorf("failed to create temp kubeconfig: %w", err)
 	}
+	defer os.Remove(tempPath)
 
-	// Step 8: Apply labels and finalize
-	cp.updateStatus(clusterName, "Finalizing", "Applying cluster labels and configuration")
-	if err := cp.applyClusterLabels(hubClientset, hubConfig, clusterName); err != nil {
-		log.Printf("⚠️ Warning: Failed to apply labels: %v", err)
-		// Don't fail the entire onboarding for label issues
+	run := &onboardingRun{
+		jobID:               jobID,
+		clusterName:         clusterName,
+		kubeconfig:          kubeconfigData,
+		target:              hub,
+		spokeKubeconfigPath: tempPath,
+		spokeContext:        spokeContext,
+		values:              merged,
 	}
-
-	// Step 9: Final verification
-	cp.updateStatus(clusterName, "Verifying", "Performing final verification")
-	if err := cp.verifyClusterHealth(hubClientset, clusterName); err != nil {
-		log.Printf("⚠️ Warning: Health verification issues: %v", err)
-		// Don't fail onboarding for verification warnings
+	if err := cp.runPipeline(ctx, jobID, run); err != nil {
+		return err
 	}
 
-	log.Printf("✅ Plugin: Cluster '%s' onboarding completed successfully", clusterName)
+	logger().Info("Onboarding completed", "cluster", clusterName)
 	return nil
 }
 
 // Enhanced detachment logic
-func (cp *ClusterPlugin) detachClusterEnhanced(clusterName string, force bool) error {
-	log.Printf("🔄 Plugin: Starting ENHANCED detachment for cluster %s", clusterName)
+func (cp *ClusterPlugin) detachClusterEnhanced(ctx context.Context, clusterName string, spokeKubeconfig []byte, force bool) error {
+	if cp.mode == modeMock {
+		return cp.mockOperation(ctx, "detach", "", clusterName)
+	}
+	logger().Info("Starting detachment", "cluster", clusterName)
 
 	// Step 1: Connect to hub
-	cp.updateStatus(clusterName, "Detaching", "Connecting to hub for cleanup")
-	itsContext := "its1"
-	hubClientset, _, err := GetClientSetWithConfigContext(itsContext) // ✅ FIXED: Use local function
+	if err := cp.advance(ctx, clusterName, "Detaching", "Connecting to hub for cleanup"); err != nil {
+		return err
+	}
+	var hubClientset *kubernetes.Clientset
+	hub, err := cp.clusterHub(clusterName)
+	if err == nil {
+		hubClientset, _, err = hub.clientset()
+	}
 	if err != nil {
 		if !force {
 			return fmt.Errorf("failed to get hub clientset: %w", err)
 		}
-		log.Printf("⚠️ Warning: Failed to connect to hub, continuing with force flag")
+		logger().Warn("Failed to connect to hub, continuing with force flag", "cluster", clusterName, "error", err)
 	}
 
 	// Step 2: Remove from hub
 	if hubClientset != nil {
-		cp.updateStatus(clusterName, "Removing", "Removing cluster from hub")
-		if err := cp.removeFromHub(hubClientset, clusterName); err != nil {
+		if err := cp.advance(ctx, clusterName, "Removing", "Removing cluster from hub"); err != nil {
+			return err
+		}
+		if err := cp.removeFromHub(ctx, hubClientset, clusterName, force); err != nil {
 			if !force {
 				return fmt.Errorf("failed to remove from hub: %w", err)
 			}
-			log.Printf("⚠️ Warning: Failed to remove from hub, continuing with force flag: %v", err)
+			logger().Warn("Failed to remove from hub, continuing with force flag", "cluster", clusterName, "error", err)
+		}
+	}
+
+	// Per-cluster BindingPolicies would otherwise outlive the cluster; a
+	// leftover one selects nothing, so failing to remove it doesn't fail the detachment
+	if err := cp.advance(ctx, clusterName, "Unbinding", "Removing the cluster's BindingPolicies"); err != nil {
+		return err
+	}
+	if err := cp.removeBindingPolicies(ctx, clusterName); err != nil {
+		logger().Warn("Failed to remove BindingPolicies", "cluster", clusterName, "error", err)
+		cp.logs.Append(clusterName, "warn", fmt.Sprintf("Failed to remove BindingPolicies: %v", err))
+	}
+
+	// Step 3: Remove the klusterlet from the spoke, falling back to the
+	// kubeconfig saved at onboarding when the request didn't supply one
+	if len(spokeKubeconfig) == 0 {
+		spokeKubeconfig = cp.savedKubeconfig(clusterName)
+	}
+	keepKubeconfig := false
+	if len(spokeKubeconfig) > 0 {
+		if err := cp.advance(ctx, clusterName, "Unjoining", "Removing klusterlet from spoke cluster"); err != nil {
+			return err
+		}
+		if err := cp.unjoinCluster(ctx, clusterName, spokeKubeconfig); err != nil {
+			if !force {
+				return fmt.Errorf("failed to unjoin spoke cluster: %w", err)
+			}
+			// Keep the saved kubeconfig so the klusterlet can still be removed later
+			keepKubeconfig = true
+			logger().Warn("Failed to unjoin spoke cluster, continuing with force flag", "cluster", clusterName, "error", err)
 		}
+	} else {
+		logger().Warn("No kubeconfig known, leaving the klusterlet in place", "cluster", clusterName)
 	}
 
-	// Step 3: Clean up local resources
-	cp.updateStatus(clusterName, "Cleaning", "Cleaning up local resources")
-	if err := cp.cleanupLocalResources(clusterName); err != nil {
+	// Step 4: Clean up local resources
+	if err := cp.advance(ctx, clusterName, "Cleaning", "Cleaning up local resources"); err != nil {
+		return err
+	}
+	if keepKubeconfig {
+		logger().Warn("Keeping saved kubeconfig because the spoke wasn't unjoined", "cluster", clusterName, "store", cp.secrets.Describe())
+	} else if err := cp.cleanupLocalResources(clusterName); err != nil {
 		if !force {
 			return fmt.Errorf("failed to cleanup local resources: %w", err)
 		}
-		log.Printf("⚠️ Warning: Failed to cleanup local resources, continuing with force flag: %v", err)
+		logger().Warn("Failed to clean up local resources, continuing with force flag", "cluster", clusterName, "error", err)
 	}
 
-	log.Printf("✅ Plugin: Cluster '%s' detachment completed successfully", clusterName)
+	logger().Info("Detachment completed", "cluster", clusterName)
 	return nil
 }
 
@@ -546,45 +2067,213 @@ func (cp *ClusterPlugin) detachClusterEnhanced(clusterName string, force bool) e
 
 func (cp *ClusterPlugin) updateStatus(clusterName, status, message string) {
 	cp.mutex.Lock()
-	defer cp.mutex.Unlock()
-
-	cp.clusterStatuses[clusterName] = ClusterStatus{
+	if cp.closed {
+		cp.mutex.Unlock()
+		return
+	}
+	existing, _, err := cp.store.Get(clusterName)
+	if err != nil {
+		logger().Error("Failed to read cluster status", "cluster", clusterName, "error", err)
+	}
+	jobID := existing.JobID
+	cp.putStatus(ClusterStatus{
 		ClusterName: clusterName,
+		JobID:       jobID,
 		Status:      status,
 		Message:     message,
 		LastUpdated: time.Now().Format(time.RFC3339),
+	})
+	cp.mutex.Unlock()
+
+	if jobID != "" {
+		cp.jobs.RecordStep(jobID, status, message)
+	}
+	cp.logs.Append(clusterName, "info", fmt.Sprintf("%s: %s", status, message))
+
+	logger().Info(message, "cluster", clusterName, "status", status, "job", jobID)
+}
+
+// putStatus writes a cluster record to the store, logging any failure, and
+// publishes the transition to stream subscribers when the status changes
+func (cp *ClusterPlugin) putStatus(status ClusterStatus) {
+	if cp.closed {
+		return
+	}
+	previous, _, _ := cp.store.Get(status.ClusterName)
+	// Status updates don't carry labels, so keep the ones already recorded
+	if status.Labels == nil {
+		status.Labels = previous.Labels
+	}
+	if status.Annotations == nil {
+		status.Annotations = previous.Annotations
+	}
+	if status.Verification == nil {
+		status.Verification = previous.Verification
+	}
+	if status.Hub == "" {
+		status.Hub = previous.Hub
+	}
+	if status.LastHeartbeat == "" {
+		status.LastHeartbeat = previous.LastHeartbeat
+	}
+	if status.ManifestValues == nil {
+		status.ManifestValues = previous.ManifestValues
+	}
+	if status.Tenant == "" {
+		status.Tenant = previous.Tenant
+	}
+	if status.Profile == nil {
+		status.Profile = previous.Profile
+	}
+	if status.Resources == nil {
+		status.Resources = previous.Resources
+	}
+	if err := cp.store.Put(status); err != nil {
+		logger().Error("Failed to persist cluster status", "cluster", status.ClusterName, "error", err)
+		return
+	}
+	cp.statusCache.invalidate()
+
+	if previous.Status != status.Status {
+		cp.recordHistory(previous, status)
+		cp.broadcaster.Publish(StatusEvent{
+			ClusterName: status.ClusterName,
+			Tenant:      status.Tenant,
+			Status:      status.Status,
+			Previous:    previous.Status,
+			Message:     status.Message,
+			JobID:       status.JobID,
+			Timestamp:   status.LastUpdated,
+		})
+	}
+}
+
+// advance moves an operation to its next step unless its job has been cancelled
+func (cp *ClusterPlugin) advance(ctx context.Context, clusterName, status, message string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	startPhase(ctx, status, attribute.String("cluster.name", clusterName))
+	cp.updateStatus(clusterName, status, message)
+	return nil
+}
+
+// newJobID returns a random identifier used to track a long-running operation
+func newJobID(prefix string) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%s-%s", prefix, hex.EncodeToString(b))
+}
+
+// getKubeconfigFromSecret reads a spoke kubeconfig stored in a Secret on an ITS hub
+func (cp *ClusterPlugin) getKubeconfigFromSecret(ctx context.Context, hub Hub, ref SecretReference) ([]byte, error) {
+	if ref.Name == "" {
+		return nil, fmt.Errorf("secret name is required")
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	key := ref.Key
+	if key == "" {
+		key = "kubeconfig"
+	}
+
+	hubClientset, _, err := hub.clientset()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hub clientset: %w", err)
+	}
+
+	secret, err := hubClientset.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", namespace, ref.Name, err)
+	}
+
+	data, ok := secret.Data[key]
+	if !ok || len(data) == 0 {
+		return nil, fmt.Errorf("key '%s' not found in secret %s/%s", key, namespace, ref.Name)
 	}
+	return data, nil
+}
 
-	log.Printf("📝 Plugin: %s - %s: %s", clusterName, status, message)
+// resolveKubeconfig returns a cluster's kubeconfig from inline content, a hub
+// secret reference, a stored context, its provider or the local kubeconfig,
+// in that order of preference
+func (cp *ClusterPlugin) resolveKubeconfig(ctx context.Context, req OnboardRequest) ([]byte, error) {
+	if req.Kubeconfig != "" {
+		return []byte(req.Kubeconfig), nil
+	}
+	if req.KubeconfigSecretRef != nil {
+		// The secret lives on the hub the cluster is onboarded to
+		hub, err := cp.hubs.Get(req.Hub)
+		if err != nil {
+			return nil, err
+		}
+		data, err := cp.getKubeconfigFromSecret(ctx, hub, *req.KubeconfigSecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubeconfig from secret: %w", err)
+		}
+		return data, nil
+	}
+	if req.Context != "" {
+		data, err := cp.kubeconfigs.Get(req.Context)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored context: %w", err)
+		}
+		return data, nil
+	}
+	if req.Provider != nil {
+		spec := *req.Provider
+		if spec.Cluster == "" {
+			spec.Cluster = req.ClusterName
+		}
+		ctx, err := cp.providerContext(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+		return fetchProviderKubeconfig(ctx, spec)
+	}
+	data, err := cp.getClusterConfigFromLocal(req.ClusterName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find cluster '%s' in local kubeconfig: %w", req.ClusterName, err)
+	}
+	return data, nil
 }
 
-func (cp *ClusterPlugin) saveKubeconfig(path, content string) error {
-	return os.WriteFile(path, []byte(content), 0600)
+// saveKubeconfig keeps a cluster's kubeconfig in the secret store
+func (cp *ClusterPlugin) saveKubeconfig(clusterName string, kubeconfigData []byte) error {
+	return cp.secrets.Put(context.Background(), savedKubeconfigName(clusterName), kubeconfigData)
 }
 
-func (cp *ClusterPlugin) approveClusterCSRsEnhanced(clientset *kubernetes.Clientset, clusterName string) error {
-	log.Printf("🔍 Plugin: Enhanced CSR approval for cluster %s", clusterName)
+func (cp *ClusterPlugin) approveClusterCSRsEnhanced(ctx context.Context, hub Hub, clientset *kubernetes.Clientset, clusterName string) error {
+	logger().Info("Approving cluster CSRs", "cluster", clusterName, "hub", hub.Name)
 
 	// Try clusteradm accept first
-	cmd := exec.Command("clusteradm", "--context", "its1", "accept", "--clusters", clusterName)
-	output, err := cmd.CombinedOutput()
+	cmd := exec.CommandContext(ctx, "clusteradm", append(hub.cliArgs(), "accept", "--clusters", clusterName)...)
+	output, err := cp.runLogged(clusterName, cmd)
 
 	if err == nil || strings.Contains(string(output), "ManagedClusterAutoApproval") {
-		log.Printf("✅ Plugin: Cluster accepted via clusteradm: %s", string(output))
+		logger().Info("Cluster accepted via clusteradm", "cluster", clusterName, "output", string(output))
 		return nil
 	}
 
-	log.Printf("⚠️ Plugin: clusteradm accept failed, falling back to manual CSR approval: %v", err)
+	logger().Warn("clusteradm accept failed, falling back to manual CSR approval", "cluster", clusterName, "error", err)
 
 	// Manual CSR approval with retries
 	for attempt := 1; attempt <= 3; attempt++ {
-		log.Printf("🔄 Plugin: CSR approval attempt %d/3", attempt)
+		logger().Debug("CSR approval attempt", "cluster", clusterName, "attempt", attempt)
 
-		time.Sleep(time.Duration(attempt*10) * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt*10) * time.Second):
+		}
 
-		csrList, err := clientset.CertificatesV1().CertificateSigningRequests().List(context.TODO(), metav1.ListOptions{})
+		csrList, err := clientset.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{})
 		if err != nil {
-			log.Printf("❌ Plugin: Failed to list CSRs: %v", err)
+			logger().Error("Failed to list CSRs", "cluster", clusterName, "error", err)
 			continue
 		}
 
@@ -596,51 +2285,53 @@ func (cp *ClusterPlugin) approveClusterCSRsEnhanced(clientset *kubernetes.Client
 		}
 
 		if len(pendingCSRs) == 0 {
-			log.Printf("🔍 Plugin: No pending CSRs found for cluster %s on attempt %d", clusterName, attempt)
+			logger().Debug("No pending CSRs found", "cluster", clusterName, "attempt", attempt)
 			if attempt == 3 {
-				log.Printf("⚠️ Plugin: No CSRs found after 3 attempts, proceeding anyway")
+				logger().Warn("No CSRs found after 3 attempts, proceeding anyway", "cluster", clusterName)
 				return nil
 			}
 			continue
 		}
 
-		log.Printf("📋 Plugin: Found %d pending CSRs: %v", len(pendingCSRs), pendingCSRs)
+		logger().Info("Found pending CSRs", "cluster", clusterName, "csrs", pendingCSRs)
 
 		// Try kubectl approve first
-		approveCmd := exec.Command("kubectl", append([]string{"--context", "its1", "certificate", "approve"}, pendingCSRs...)...)
-		output, err := approveCmd.CombinedOutput()
+		approveCmd := exec.CommandContext(ctx, "kubectl", append(append(hub.cliArgs(), "certificate", "approve"), pendingCSRs...)...)
+		output, err := cp.runLogged(clusterName, approveCmd)
 
 		if err == nil {
-			log.Printf("✅ Plugin: CSRs approved via kubectl: %s", string(output))
+			logger().Info("CSRs approved via kubectl", "cluster", clusterName, "output", string(output))
 			return nil
 		}
 
-		log.Printf("⚠️ Plugin: kubectl approve failed, trying SDK approach: %v", err)
+		logger().Warn("kubectl approve failed, trying the API", "cluster", clusterName, "error", err)
 
 		// Fallback to SDK approval
-		if err := cp.approveCSRsWithSDK(clientset, pendingCSRs); err != nil {
-			log.Printf("❌ Plugin: SDK approval failed on attempt %d: %v", attempt, err)
+		if err := cp.approveCSRsWithSDK(ctx, clientset, pendingCSRs); err != nil {
+			logger().Error("CSR approval through the API failed", "cluster", clusterName, "attempt", attempt, "error", err)
 			if attempt == 3 {
 				return err
 			}
 			continue
 		}
 
-		log.Printf("✅ Plugin: CSRs approved via SDK")
+		logger().Info("CSRs approved through the API", "cluster", clusterName)
 		return nil
 	}
 
 	return fmt.Errorf("failed to approve CSRs after 3 attempts")
 }
 
-func (cp *ClusterPlugin) waitForManagedClusterEnhanced(clientset *kubernetes.Clientset, clusterName string) error {
+func (cp *ClusterPlugin) waitForManagedClusterEnhanced(ctx context.Context, clientset *kubernetes.Clientset, clusterName string) error {
 	timeout := time.After(5 * time.Minute)
 	tick := time.Tick(10 * time.Second)
 
-	log.Printf("⏳ Plugin: Waiting for managed cluster %s to be created...", clusterName)
+	logger().Info("Waiting for managed cluster to be created", "cluster", clusterName)
 
 	for {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-timeout:
 			return fmt.Errorf("timeout waiting for managed cluster")
 		case <-tick:
@@ -648,10 +2339,10 @@ func (cp *ClusterPlugin) waitForManagedClusterEnhanced(clientset *kubernetes.Cli
 				AbsPath("/apis/cluster.open-cluster-management.io/v1").
 				Resource("managedclusters").
 				Name(clusterName).
-				Do(context.TODO())
+				Do(ctx)
 
 			if err := result.Error(); err == nil {
-				log.Printf("✅ Plugin: Managed cluster %s created", clusterName)
+				logger().Info("Managed cluster created", "cluster", clusterName)
 
 				// Accept the cluster
 				acceptPatch := []byte(`{"spec":{"hubAcceptsClient":true}}`)
@@ -660,93 +2351,203 @@ func (cp *ClusterPlugin) waitForManagedClusterEnhanced(clientset *kubernetes.Cli
 					Resource("managedclusters").
 					Name(clusterName).
 					Body(acceptPatch).
-					Do(context.TODO())
+					Do(ctx)
 
 				if patchErr := patchResult.Error(); patchErr != nil {
-					log.Printf("⚠️ Plugin: Warning - Failed to accept managed cluster: %v", patchErr)
+					logger().Warn("Failed to accept managed cluster", "cluster", clusterName, "error", patchErr)
 				} else {
-					log.Printf("✅ Plugin: Managed cluster %s accepted", clusterName)
+					logger().Info("Managed cluster accepted", "cluster", clusterName)
 				}
 
 				return nil
 			}
 
-			log.Printf("⏳ Plugin: Still waiting for managed cluster %s...", clusterName)
+			logger().Debug("Still waiting for managed cluster", "cluster", clusterName)
 		}
 	}
 }
 
-func (cp *ClusterPlugin) applyClusterLabels(clientset *kubernetes.Clientset, hubConfig interface{}, clusterName string) error {
-	log.Printf("🏷️ Plugin: Applying labels to cluster %s", clusterName)
+// clusterLabels returns the labels the plugin puts on every ManagedCluster
+func clusterLabels(clusterName string) map[string]string {
+	return map[string]string{
+		"location-group": "edge",
+		"name":           clusterName,
+		"managed-by":     "kubestellar-plugin",
+	}
+}
+
+// onboardingLabels overlays the labels recorded for a cluster on the basic ones
+func onboardingLabels(clusterName string, recorded map[string]string) map[string]string {
+	merged := clusterLabels(clusterName)
+	for key, value := range recorded {
+		merged[key] = value
+	}
+	return merged
+}
+
+func (cp *ClusterPlugin) applyClusterLabels(ctx context.Context, clientset *kubernetes.Clientset, hubConfig interface{}, clusterName string) error {
+	logger().Info("Applying cluster labels", "cluster", clusterName)
 
-	// Apply basic labels
-	labelPatch := []byte(`{"metadata":{"labels":{"location-group":"edge","name":"` + clusterName + `","managed-by":"kubestellar-plugin"}}}`)
+	// Apply the basic labels overlaid with the ones requested for the cluster
+	cp.mutex.RLock()
+	record, _, err := cp.store.Get(clusterName)
+	cp.mutex.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to read cluster record: %w", err)
+	}
+	clusterMetadata := map[string]interface{}{"labels": onboardingLabels(clusterName, record.Labels)}
+	if len(record.Annotations) > 0 {
+		clusterMetadata["annotations"] = record.Annotations
+	}
+	labelPatch, err := json.Marshal(map[string]interface{}{"metadata": clusterMetadata})
+	if err != nil {
+		return fmt.Errorf("failed to encode labels: %w", err)
+	}
 
 	patchResult := clientset.RESTClient().Patch(types.MergePatchType).
 		AbsPath("/apis/cluster.open-cluster-management.io/v1").
 		Resource("managedclusters").
 		Name(clusterName).
 		Body(labelPatch).
-		Do(context.TODO())
+		Do(ctx)
 
 	if err := patchResult.Error(); err != nil {
 		return fmt.Errorf("failed to apply labels: %w", err)
 	}
 
-	log.Printf("✅ Plugin: Labels applied to cluster %s", clusterName)
+	logger().Info("Cluster labels applied", "cluster", clusterName)
 	return nil
 }
 
-func (cp *ClusterPlugin) verifyClusterHealth(clientset *kubernetes.Clientset, clusterName string) error {
-	log.Printf("🔍 Plugin: Verifying health of cluster %s", clusterName)
+// removeFromHub deletes the ManagedCluster and waits for its finalizers to
+// complete. With force, finalizers still present after the timeout are removed.
+func (cp *ClusterPlugin) removeFromHub(ctx context.Context, clientset *kubernetes.Clientset, clusterName string, force bool) error {
+	logger().Info("Removing cluster from hub", "cluster", clusterName)
 
-	// Simple health check - verify the managed cluster exists and is accepted
-	result := clientset.RESTClient().Get().
+	deleteResult := clientset.RESTClient().Delete().
 		AbsPath("/apis/cluster.open-cluster-management.io/v1").
 		Resource("managedclusters").
 		Name(clusterName).
-		Do(context.TODO())
+		Do(ctx)
+
+	if err := deleteResult.Error(); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger().Info("Managed cluster already removed from hub", "cluster", clusterName)
+			return nil
+		}
+		return fmt.Errorf("failed to delete managed cluster: %w", err)
+	}
+
+	finalizers, err := cp.waitForManagedClusterDeletion(ctx, clientset, clusterName, cp.finalizerTimeout)
+	if err != nil {
+		return err
+	}
+	if len(finalizers) > 0 {
+		if !force {
+			return fmt.Errorf("managed cluster still has finalizers after %s: %s", cp.finalizerTimeout, strings.Join(finalizers, ", "))
+		}
+
+		logger().Warn("Stripping finalizers from managed cluster", "cluster", clusterName, "finalizers", finalizers)
+		cp.updateStatus(clusterName, "Removing", "Removing stuck finalizers from managed cluster")
+		patchResult := clientset.RESTClient().Patch(types.MergePatchType).
+			AbsPath("/apis/cluster.open-cluster-management.io/v1").
+			Resource("managedclusters").
+			Name(clusterName).
+			Body([]byte(`{"metadata":{"finalizers":null}}`)).
+			Do(ctx)
+		if err := patchResult.Error(); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to remove finalizers: %w", err)
+		}
 
-	if err := result.Error(); err != nil {
-		return fmt.Errorf("cluster health check failed: %w", err)
+		if finalizers, err = cp.waitForManagedClusterDeletion(ctx, clientset, clusterName, 30*time.Second); err != nil {
+			return err
+		}
+		if len(finalizers) > 0 {
+			return fmt.Errorf("managed cluster was not deleted after removing finalizers")
+		}
 	}
 
-	log.Printf("✅ Plugin: Cluster %s health verification passed", clusterName)
+	logger().Info("Cluster removed from hub", "cluster", clusterName)
 	return nil
 }
 
-func (cp *ClusterPlugin) removeFromHub(clientset *kubernetes.Clientset, clusterName string) error {
-	log.Printf("🗑️ Plugin: Removing cluster %s from hub", clusterName)
+// waitForManagedClusterDeletion polls until the ManagedCluster is gone. When the
+// timeout expires first it returns the finalizers still blocking deletion.
+func (cp *ClusterPlugin) waitForManagedClusterDeletion(ctx context.Context, clientset *kubernetes.Clientset, clusterName string, timeout time.Duration) ([]string, error) {
+	deadline := time.After(timeout)
+	tick := time.NewTicker(2 * time.Second)
+	defer tick.Stop()
 
-	deleteResult := clientset.RESTClient().Delete().
-		AbsPath("/apis/cluster.open-cluster-management.io/v1").
-		Resource("managedclusters").
-		Name(clusterName).
-		Do(context.TODO())
+	var finalizers []string
+	for {
+		raw, err := clientset.RESTClient().Get().
+			AbsPath("/apis/cluster.open-cluster-management.io/v1").
+			Resource("managedclusters").
+			Name(clusterName).
+			Do(ctx).
+			Raw()
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		var cluster metav1.PartialObjectMetadata
+		if err == nil {
+			err = json.Unmarshal(raw, &cluster)
+		}
+		if err == nil {
+			finalizers = cluster.Finalizers
+		} else {
+			logger().Warn("Failed to check managed cluster deletion", "cluster", clusterName, "error", err)
+		}
 
-	if err := deleteResult.Error(); err != nil {
-		return fmt.Errorf("failed to delete managed cluster: %w", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			if len(finalizers) == 0 {
+				return nil, fmt.Errorf("timeout waiting for managed cluster deletion")
+			}
+			return finalizers, nil
+		case <-tick.C:
+		}
 	}
-
-	log.Printf("✅ Plugin: Cluster %s removed from hub", clusterName)
-	return nil
 }
 
 func (cp *ClusterPlugin) cleanupLocalResources(clusterName string) error {
-	log.Printf("🧹 Plugin: Cleaning up local resources for cluster %s", clusterName)
+	logger().Info("Cleaning up local resources", "cluster", clusterName)
 
-	// Remove saved kubeconfig
-	kubeconfigPath := filepath.Join(cp.kubeconfigDir, fmt.Sprintf("%s-kubeconfig", clusterName))
-	if err := os.Remove(kubeconfigPath); err != nil && !os.IsNotExist(err) {
+	if err := cp.secrets.Delete(context.Background(), savedKubeconfigName(clusterName)); err != nil {
 		return fmt.Errorf("failed to remove kubeconfig: %w", err)
 	}
+	cp.tokens.Forget(clusterName)
 
-	log.Printf("✅ Plugin: Local resources cleaned up for cluster %s", clusterName)
+	logger().Info("Local resources cleaned up", "cluster", clusterName)
 	return nil
 }
 
+// savedKubeconfigName is the secret onboarding keeps a cluster's kubeconfig in
+func savedKubeconfigName(clusterName string) string {
+	return clusterName + "-kubeconfig"
+}
+
+// savedKubeconfig returns the kubeconfig saved at onboarding, or nil if there
+// is none
+func (cp *ClusterPlugin) savedKubeconfig(clusterName string) []byte {
+	data, found, err := cp.secrets.Get(context.Background(), savedKubeconfigName(clusterName))
+	if err != nil {
+		logger().Warn("Failed to read saved kubeconfig", "cluster", clusterName, "error", err)
+		return nil
+	}
+	if !found {
+		return nil
+	}
+	return data
+}
+
 // Keep all the existing helper functions (same as before)
 func (cp *ClusterPlugin) getClusterConfigFromLocal(clusterName string) ([]byte, error) {
+	if cp.mode == modeMock {
+		return mockKubeconfig(clusterName)
+	}
 	kubeconfigPath := kubeconfigPath()
 	config, err := clientcmd.LoadFromFile(kubeconfigPath)
 	if err != nil {
@@ -758,7 +2559,7 @@ func (cp *ClusterPlugin) getClusterConfigFromLocal(clusterName string) ([]byte,
 		// Try to find a context that references this cluster
 		for contextName, ctx := range config.Contexts {
 			if ctx.Cluster == clusterName {
-				return cp.extractContextConfig(config, contextName)
+				return extractContextConfig(config, contextName)
 			}
 		}
 		return nil, fmt.Errorf("cluster '%s' not found in local kubeconfig", clusterName)
@@ -804,7 +2605,8 @@ func (cp *ClusterPlugin) getClusterConfigFromLocal(clusterName string) ([]byte,
 	return clientcmd.Write(newConfig)
 }
 
-func (cp *ClusterPlugin) extractContextConfig(config *clientcmdapi.Config, contextName string) ([]byte, error) {
+// extractContextConfig builds a standalone kubeconfig holding a single context
+func extractContextConfig(config *clientcmdapi.Config, contextName string) ([]byte, error) {
 	context, exists := config.Contexts[contextName]
 	if !exists {
 		return nil, fmt.Errorf("context '%s' not found in kubeconfig", contextName)
@@ -841,7 +2643,7 @@ func (cp *ClusterPlugin) extractContextConfig(config *clientcmdapi.Config, conte
 	return clientcmd.Write(newConfig)
 }
 
-func (cp *ClusterPlugin) validateClusterConnectivity(kubeconfigData []byte) error {
+func (cp *ClusterPlugin) validateClusterConnectivity(ctx context.Context, kubeconfigData []byte) error {
 	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigData)
 	if err != nil {
 		return fmt.Errorf("failed to parse kubeconfig: %w", err)
@@ -852,7 +2654,7 @@ func (cp *ClusterPlugin) validateClusterConnectivity(kubeconfigData []byte) erro
 		return fmt.Errorf("failed to create Kubernetes client: %w", err)
 	}
 
-	_, err = client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	_, err = client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to connect to the cluster: %w", err)
 	}
@@ -860,8 +2662,8 @@ func (cp *ClusterPlugin) validateClusterConnectivity(kubeconfigData []byte) erro
 	return nil
 }
 
-func (cp *ClusterPlugin) getClusterAdmToken(hubContext string) (string, error) {
-	cmd := exec.Command("clusteradm", "--context", hubContext, "get", "token")
+func (cp *ClusterPlugin) getClusterAdmToken(ctx context.Context, hub Hub) (string, error) {
+	cmd := exec.CommandContext(ctx, "clusteradm", append(hub.cliArgs(), "get", "token")...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("failed to get token: %s, %w", string(output), err)
@@ -877,13 +2679,20 @@ func (cp *ClusterPlugin) getClusterAdmToken(hubContext string) (string, error) {
 	return "", fmt.Errorf("join command not found in output: %s", outputStr)
 }
 
-func (cp *ClusterPlugin) createTempKubeconfig(kubeconfigData []byte, clusterName string) (string, error) {
+// createTempKubeconfig writes the spoke kubeconfig to a temporary file and
+// returns its path together with the context clusteradm should join with
+func (cp *ClusterPlugin) createTempKubeconfig(kubeconfigData []byte, clusterName string) (string, string, error) {
 	tempDir := os.TempDir()
 	tempFile := filepath.Join(tempDir, fmt.Sprintf("kubeconfig-%s-%d", clusterName, time.Now().UnixNano()))
 
 	config, err := clientcmd.Load(kubeconfigData)
 	if err != nil {
-		return "", fmt.Errorf("invalid kubeconfig format: %w", err)
+		return "", "", fmt.Errorf("invalid kubeconfig format: %w", err)
+	}
+
+	contextName := config.CurrentContext
+	if _, exists := config.Contexts[clusterName]; exists || contextName == "" {
+		contextName = clusterName
 	}
 
 	// Adjust cluster server endpoints if needed
@@ -894,35 +2703,67 @@ func (cp *ClusterPlugin) createTempKubeconfig(kubeconfigData []byte, clusterName
 	}
 
 	if err := clientcmd.WriteToFile(*config, tempFile); err != nil {
-		return "", fmt.Errorf("failed to write temporary kubeconfig: %w", err)
+		return "", "", fmt.Errorf("failed to write temporary kubeconfig: %w", err)
 	}
 
-	return tempFile, nil
+	return tempFile, contextName, nil
+}
+
+// joinCommand turns the join command printed by clusteradm get token into the
+// command run against the spoke, pulling the images from the configured registry
+func joinCommand(joinToken, clusterName, spokeContext string, values ManifestValues) []string {
+	cmdParts := strings.Fields(strings.Replace(joinToken, "<cluster_name>", clusterName, 1))
+	cmdParts = append(cmdParts, "--context", spokeContext, "--singleton", "--force-internal-endpoint-lookup")
+	if values.Registry != "" {
+		cmdParts = append(cmdParts, "--image-registry", values.Registry)
+	}
+	if values.ImageTag != "" {
+		cmdParts = append(cmdParts, "--bundle-version", values.ImageTag)
+	}
+	return cmdParts
 }
 
-func (cp *ClusterPlugin) joinClusterToHub(kubeconfigPath, clusterName, joinToken string) error {
-	joinCmd := strings.Replace(joinToken, "<cluster_name>", clusterName, 1)
-	cmdParts := strings.Fields(joinCmd)
-	cmdParts = append(cmdParts, "--context", clusterName, "--singleton", "--force-internal-endpoint-lookup")
+// joinClusterToHub runs the clusteradm join command against the spoke, which
+// applies the klusterlet manifests using the provided kubeconfig
+func (cp *ClusterPlugin) joinClusterToHub(ctx context.Context, kubeconfigPath, clusterName, spokeContext, joinToken string, values ManifestValues) error {
+	cmdParts := joinCommand(joinToken, clusterName, spokeContext, values)
 
-	cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
+	cmd := exec.CommandContext(ctx, cmdParts[0], cmdParts[1:]...)
 	cmd.Env = append(os.Environ(), fmt.Sprintf("KUBECONFIG=%s", kubeconfigPath))
 
-	output, err := cmd.CombinedOutput()
+	output, err := cp.runLogged(clusterName, cmd)
 	if err != nil {
 		return fmt.Errorf("join command failed: %s, %w", string(output), err)
 	}
 
-	log.Printf("📝 Plugin: Join command output: %s", string(output))
+	logger().Debug("Join command output", "cluster", clusterName, "output", string(output))
+	return nil
+}
+
+// unjoinCluster runs clusteradm unjoin against the spoke, removing the klusterlet
+func (cp *ClusterPlugin) unjoinCluster(ctx context.Context, clusterName string, kubeconfigData []byte) error {
+	tempPath, contextName, err := cp.createTempKubeconfig(kubeconfigData, clusterName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+
+	cmd := exec.CommandContext(ctx, "clusteradm", "unjoin", "--cluster-name", clusterName, "--context", contextName)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("KUBECONFIG=%s", tempPath))
+
+	output, err := cp.runLogged(clusterName, cmd)
+	if err != nil {
+		return fmt.Errorf("unjoin command failed: %s, %w", string(output), err)
+	}
 	return nil
 }
 
-func (cp *ClusterPlugin) approveCSRsWithSDK(clientset *kubernetes.Clientset, csrNames []string) error {
+func (cp *ClusterPlugin) approveCSRsWithSDK(ctx context.Context, clientset *kubernetes.Clientset, csrNames []string) error {
 	for _, csrName := range csrNames {
 		approvalPatch := []byte(`{"status":{"conditions":[{"type":"Approved","status":"True","reason":"ApprovedByPlugin","message":"Approved via KubeStellar Plugin"}]}}`)
 
 		_, err := clientset.CertificatesV1().CertificateSigningRequests().Patch(
-			context.TODO(),
+			ctx,
 			csrName,
 			types.MergePatchType,
 			approvalPatch,
@@ -931,7 +2772,7 @@ func (cp *ClusterPlugin) approveCSRsWithSDK(clientset *kubernetes.Clientset, csr
 		if err != nil {
 			return fmt.Errorf("failed to approve CSR %s: %w", csrName, err)
 		}
-		log.Printf("✅ Plugin: Approved CSR %s via SDK", csrName)
+		logger().Info("Approved CSR through the API", "csr", csrName)
 	}
 	return nil
 }
@@ -948,6 +2789,6 @@ func (cp *ClusterPlugin) isCSRApproved(csr certificatesv1.CertificateSigningRequ
 // NewPlugin creates a new instance of the cluster plugin
 // This is the required symbol that will be looked up when loading the plugin
 func NewPlugin() interface{} {
-	log.Println("🏗️ Plugin: Creating new ClusterPlugin instance with enhanced real onboarding")
+	logger().Debug("Creating ClusterPlugin instance")
 	return &ClusterPlugin{}
 }
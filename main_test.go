@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// terminatingHub serves a ManagedCluster that stays terminating until its
+// finalizers are stripped, and records the patches it receives
+type terminatingHub struct {
+	mutex      sync.Mutex
+	finalizers []string
+	deleted    bool
+	gone       bool
+	patches    []string
+}
+
+func (h *terminatingHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if r.URL.Path != "/apis/cluster.open-cluster-management.io/v1/managedclusters/edge-1" || h.gone {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodDelete:
+		h.deleted = true
+	case http.MethodPatch:
+		body, _ := io.ReadAll(r.Body)
+		h.patches = append(h.patches, string(body))
+		var patch struct {
+			Metadata map[string]interface{} `json:"metadata"`
+		}
+		json.Unmarshal(body, &patch)
+		if finalizers, set := patch.Metadata["finalizers"]; set && finalizers == nil && h.deleted {
+			h.gone = true
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"apiVersion": "cluster.open-cluster-management.io/v1",
+		"kind":       "ManagedCluster",
+		"metadata":   map[string]interface{}{"name": "edge-1", "finalizers": h.finalizers},
+	})
+}
+
+func TestRemoveFromHubStuckTerminating(t *testing.T) {
+	finalizer := "cluster.open-cluster-management.io/api-resource-cleanup"
+	plugin := newTestPlugin(t)
+	plugin.finalizerTimeout = 50 * time.Millisecond
+
+	for _, tt := range []struct {
+		name       string
+		finalizers []string
+		force      bool
+		wantErr    string
+	}{
+		{name: "stuck finalizer", finalizers: []string{finalizer}, wantErr: "managed cluster still has finalizers after 50ms: " + finalizer},
+		{name: "forced", finalizers: []string{finalizer}, force: true},
+		{name: "never deleted", wantErr: "timeout waiting for managed cluster deletion"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := &terminatingHub{finalizers: tt.finalizers}
+			server := httptest.NewServer(hub)
+			t.Cleanup(server.Close)
+			clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = plugin.removeFromHub(context.Background(), clientset, "edge-1", tt.force)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("removeFromHub() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("removeFromHub() error = %v", err)
+			}
+
+			hub.mutex.Lock()
+			defer hub.mutex.Unlock()
+			if !hub.deleted {
+				t.Error("the managed cluster was not deleted")
+			}
+			if tt.force != (len(hub.patches) == 1) || (tt.force && !strings.Contains(hub.patches[0], `"finalizers":null`)) || tt.force != hub.gone {
+				t.Errorf("patches = %v, gone %v", hub.patches, hub.gone)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var managedClusterGVR = schema.GroupVersionResource{
+	Group:    "cluster.open-cluster-management.io",
+	Version:  "v1",
+	Resource: "managedclusters",
+}
+
+// ManagedClusterState is the hub's view of a ManagedCluster. Condition fields
+// hold the condition status ("True", "False" or "Unknown"), or are empty when
+// the hub hasn't reported the condition yet.
+type ManagedClusterState struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	HubAccepted string            `json:"hubAccepted,omitempty"`
+	Joined      string            `json:"joined,omitempty"`
+	Available   string            `json:"available,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	ObservedAt  string            `json:"observedAt"`
+}
+
+// Phase summarises the conditions into a single status
+func (s ManagedClusterState) Phase() string {
+	switch {
+	case s.Available == "True":
+		return "Available"
+	case s.Joined == "True" && s.Available == "False":
+		return "Unavailable"
+	case s.Joined == "True":
+		return "Joined"
+	case s.HubAccepted != "True":
+		return "PendingAcceptance"
+	default:
+		return "Joining"
+	}
+}
+
+// HubSyncStatus reports whether the ManagedCluster watch is connected to the hub
+type HubSyncStatus struct {
+	Connected bool   `json:"connected"`
+	LastSync  string `json:"lastSync,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// managedClusterWatcher mirrors ManagedCluster resources from the ITS hub
+// using a dynamic informer, rebuilding it (and so relisting) after the hub
+// connection is lost
+type managedClusterWatcher struct {
+	hubContext string
+	resync     time.Duration
+	onChange   func(previous *ManagedClusterState, current *ManagedClusterState)
+
+	clusters map[string]ManagedClusterState
+	status   HubSyncStatus
+	cancel   context.CancelFunc
+	done     chan struct{}
+	mutex    sync.RWMutex
+}
+
+func newManagedClusterWatcher(hubContext string, resync time.Duration) *managedClusterWatcher {
+	return &managedClusterWatcher{
+		hubContext: hubContext,
+		resync:     resync,
+		clusters:   make(map[string]ManagedClusterState),
+	}
+}
+
+// Start begins watching in the background until Stop is called
+func (w *managedClusterWatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+		backoff := time.Second
+		for {
+			err := w.watch(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			w.setStatus(false, err)
+			logger().Warn("ManagedCluster watch disconnected", "retryIn", backoff, "error", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	}()
+}
+
+// Stop ends the watch and waits for it to shut down
+func (w *managedClusterWatcher) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+// watch runs a single informer until the context ends or the watch fails
+func (w *managedClusterWatcher) watch(ctx context.Context) error {
+	_, restConfig, err := GetClientSetWithConfigContext(w.hubContext)
+	if err != nil {
+		return fmt.Errorf("failed to connect to hub: %w", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, w.resync)
+	informer := factory.ForResource(managedClusterGVR).Informer()
+
+	watchErrors := make(chan error, 1)
+	if err := informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		cache.DefaultWatchErrorHandler(r, err)
+		select {
+		case watchErrors <- err:
+		default:
+		}
+	}); err != nil {
+		return err
+	}
+
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.observe(obj) },
+		UpdateFunc: func(_, obj interface{}) { w.observe(obj) },
+		DeleteFunc: func(obj interface{}) { w.forget(obj) },
+	}); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	factory.Start(stop)
+
+	// Give up on the initial list as soon as the hub reports an error rather
+	// than letting the reflector retry it forever
+	synced := make(chan struct{})
+	go func() {
+		if cache.WaitForCacheSync(stop, informer.HasSynced) {
+			close(synced)
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-watchErrors:
+		return err
+	case <-synced:
+	}
+	w.prune(informer.GetStore().ListKeys())
+	w.setStatus(true, nil)
+	logger().Info("ManagedCluster watch synced", "hubContext", w.hubContext)
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-watchErrors:
+		return err
+	}
+}
+
+func (w *managedClusterWatcher) observe(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	state := managedClusterStateFrom(u)
+
+	w.mutex.Lock()
+	previous, existed := w.clusters[state.Name]
+	w.clusters[state.Name] = state
+	w.status.LastSync = state.ObservedAt
+	w.mutex.Unlock()
+
+	if w.onChange == nil {
+		return
+	}
+	if !existed {
+		w.onChange(nil, &state)
+	} else if previous.Phase() != state.Phase() {
+		w.onChange(&previous, &state)
+	}
+}
+
+func (w *managedClusterWatcher) forget(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	w.mutex.Lock()
+	previous, existed := w.clusters[u.GetName()]
+	delete(w.clusters, u.GetName())
+	w.mutex.Unlock()
+
+	if existed && w.onChange != nil {
+		w.onChange(&previous, nil)
+	}
+}
+
+// prune drops clusters that disappeared from the hub while disconnected
+func (w *managedClusterWatcher) prune(keys []string) {
+	live := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		live[key] = true
+	}
+
+	w.mutex.Lock()
+	var removed []ManagedClusterState
+	for name, state := range w.clusters {
+		if !live[name] {
+			removed = append(removed, state)
+			delete(w.clusters, name)
+		}
+	}
+	w.mutex.Unlock()
+
+	if w.onChange != nil {
+		for i := range removed {
+			w.onChange(&removed[i], nil)
+		}
+	}
+}
+
+func (w *managedClusterWatcher) setStatus(connected bool, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.status.Connected = connected
+	w.status.Error = ""
+	if err != nil {
+		w.status.Error = err.Error()
+	}
+	if connected {
+		w.status.LastSync = time.Now().Format(time.RFC3339)
+	}
+}
+
+// Refresh lists the ManagedClusters from the hub API right away instead of
+// waiting for the watch to deliver changes
+func (w *managedClusterWatcher) Refresh(ctx context.Context) error {
+	_, restConfig, err := GetClientSetWithConfigContext(w.hubContext)
+	if err != nil {
+		return fmt.Errorf("failed to connect to hub: %w", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	list, err := client.Resource(managedClusterGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(list.Items))
+	for i := range list.Items {
+		w.observe(&list.Items[i])
+		keys = append(keys, list.Items[i].GetName())
+	}
+	w.prune(keys)
+	return nil
+}
+
+// Get returns the hub state of a single cluster
+func (w *managedClusterWatcher) Get(name string) (ManagedClusterState, bool) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	state, exists := w.clusters[name]
+	return state, exists
+}
+
+// List returns the hub state of every ManagedCluster sorted by name
+func (w *managedClusterWatcher) List() []ManagedClusterState {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	states := make([]ManagedClusterState, 0, len(w.clusters))
+	for _, state := range w.clusters {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
+
+// Status reports the current hub connection state
+func (w *managedClusterWatcher) Status() HubSyncStatus {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.status
+}
+
+func managedClusterStateFrom(u *unstructured.Unstructured) ManagedClusterState {
+	state := ManagedClusterState{
+		Name:       u.GetName(),
+		Labels:     u.GetLabels(),
+		ObservedAt: time.Now().Format(time.RFC3339),
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	for _, raw := range conditions {
+		condition, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		conditionType, _ := condition["type"].(string)
+		status, _ := condition["status"].(string)
+		message, _ := condition["message"].(string)
+
+		switch conditionType {
+		case "HubAcceptedManagedCluster":
+			state.HubAccepted = status
+		case "ManagedClusterJoined":
+			state.Joined = status
+		case "ManagedClusterConditionAvailable":
+			state.Available = status
+			state.Message = message
+		}
+	}
+	return state
+}
+
+// mergeHubState attaches the hub view to each known cluster of the built-in
+// hub and appends ManagedClusters that exist on it but weren't onboarded by
+// the plugin
+func (cp *ClusterPlugin) mergeHubState(clusters []ClusterStatus) []ClusterStatus {
+	known := make(map[string]bool, len(clusters))
+	for i := range clusters {
+		known[clusters[i].ClusterName] = true
+		if hubName(clusters[i]) != builtinHub.Name {
+			continue
+		}
+		if state, exists := cp.hub.Get(clusters[i].ClusterName); exists {
+			clusters[i].ManagedCluster = &state
+		}
+	}
+
+	for _, state := range cp.hub.List() {
+		if known[state.Name] {
+			continue
+		}
+		state := state
+		clusters = append(clusters, ClusterStatus{
+			ClusterName:    state.Name,
+			Status:         state.Phase(),
+			Message:        "Discovered on hub",
+			LastUpdated:    state.ObservedAt,
+			Hub:            builtinHub.Name,
+			ManagedCluster: &state,
+		})
+	}
+
+	sortClusters(clusters)
+	return clusters
+}
+
+// onManagedClusterChange publishes hub-side condition changes to status subscribers
+func (cp *ClusterPlugin) onManagedClusterChange(previous, current *ManagedClusterState) {
+	event := StatusEvent{
+		Message:   "ManagedCluster conditions changed on hub",
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if previous != nil {
+		event.ClusterName = previous.Name
+		event.Previous = previous.Phase()
+	}
+	if current != nil {
+		event.ClusterName = current.Name
+		event.Status = current.Phase()
+	} else {
+		event.Status = "Removed"
+		event.Message = "ManagedCluster removed from hub"
+	}
+	cp.broadcaster.Publish(event)
+}
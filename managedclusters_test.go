@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testManagedCluster(name string, conditions map[string]string) *unstructured.Unstructured {
+	return withConditions(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.open-cluster-management.io/v1",
+		"kind":       "ManagedCluster",
+		"metadata":   map[string]interface{}{"name": name, "labels": map[string]interface{}{"location-group": "edge"}},
+	}}, conditions)
+}
+
+func TestManagedClusterStateFrom(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions map[string]string
+		wantPhase  string
+	}{
+		{name: "no conditions", wantPhase: "PendingAcceptance"},
+		{name: "accepted", conditions: map[string]string{"HubAcceptedManagedCluster": "True"}, wantPhase: "Joining"},
+		{name: "joined", conditions: map[string]string{"HubAcceptedManagedCluster": "True", "ManagedClusterJoined": "True"}, wantPhase: "Joined"},
+		{name: "available", conditions: map[string]string{"HubAcceptedManagedCluster": "True", "ManagedClusterJoined": "True", "ManagedClusterConditionAvailable": "True"}, wantPhase: "Available"},
+		{name: "lease lost", conditions: map[string]string{"HubAcceptedManagedCluster": "True", "ManagedClusterJoined": "True", "ManagedClusterConditionAvailable": "False"}, wantPhase: "Unavailable"},
+		{name: "availability unknown", conditions: map[string]string{"HubAcceptedManagedCluster": "True", "ManagedClusterJoined": "True", "ManagedClusterConditionAvailable": "Unknown"}, wantPhase: "Joined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := managedClusterStateFrom(testManagedCluster("edge-1", tt.conditions))
+			if state.Name != "edge-1" || state.Labels["location-group"] != "edge" {
+				t.Errorf("state = %+v, want edge-1 with its labels", state)
+			}
+			if state.HubAccepted != tt.conditions["HubAcceptedManagedCluster"] || state.Joined != tt.conditions["ManagedClusterJoined"] ||
+				state.Available != tt.conditions["ManagedClusterConditionAvailable"] {
+				t.Errorf("conditions = %+v, want %v", state, tt.conditions)
+			}
+			if got := state.Phase(); got != tt.wantPhase {
+				t.Errorf("Phase() = %s, want %s", got, tt.wantPhase)
+			}
+		})
+	}
+}
+
+func TestManagedClusterWatcherChanges(t *testing.T) {
+	watcher := newManagedClusterWatcher("its1", 0)
+	var changes []string
+	watcher.onChange = func(previous, current *ManagedClusterState) {
+		change := ""
+		if previous != nil {
+			change = previous.Name + ":" + previous.Phase()
+		}
+		change += "->"
+		if current != nil {
+			change += current.Name + ":" + current.Phase()
+		}
+		changes = append(changes, change)
+	}
+
+	joined := map[string]string{"HubAcceptedManagedCluster": "True", "ManagedClusterJoined": "True"}
+	available := map[string]string{"HubAcceptedManagedCluster": "True", "ManagedClusterJoined": "True", "ManagedClusterConditionAvailable": "True"}
+	watcher.observe(testManagedCluster("edge-1", joined))
+	watcher.observe(testManagedCluster("edge-1", joined))
+	watcher.observe(testManagedCluster("edge-1", available))
+	watcher.observe(testManagedCluster("edge-2", available))
+	watcher.observe(testManagedCluster("edge-3", available))
+	watcher.forget(cache.DeletedFinalStateUnknown{Key: "edge-2", Obj: testManagedCluster("edge-2", available)})
+	watcher.prune([]string{"edge-1"})
+
+	want := []string{
+		"->edge-1:Joined",
+		"edge-1:Joined->edge-1:Available",
+		"->edge-2:Available",
+		"->edge-3:Available",
+		"edge-2:Available->",
+		"edge-3:Available->",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+	if states := watcher.List(); len(states) != 1 || states[0].Name != "edge-1" {
+		t.Errorf("List() = %+v, want only edge-1", states)
+	}
+	if _, exists := watcher.Get("edge-3"); exists {
+		t.Error("Get() found a pruned cluster")
+	}
+}
+
+func TestMergeHubState(t *testing.T) {
+	watcher := newManagedClusterWatcher("its1", 0)
+	available := map[string]string{"HubAcceptedManagedCluster": "True", "ManagedClusterJoined": "True", "ManagedClusterConditionAvailable": "True"}
+	watcher.observe(testManagedCluster("edge-1", available))
+	watcher.observe(testManagedCluster("edge-2", available))
+	watcher.observe(testManagedCluster("edge-9", nil))
+	plugin := &ClusterPlugin{hub: watcher}
+
+	clusters := plugin.mergeHubState([]ClusterStatus{
+		{ClusterName: "edge-2", Status: "Ready", Hub: "its2"},
+		{ClusterName: "edge-1", Status: "Ready"},
+	})
+
+	names := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		names = append(names, cluster.ClusterName)
+	}
+	if want := []string{"edge-1", "edge-2", "edge-9"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("clusters = %v, want %v", names, want)
+	}
+	if clusters[0].ManagedCluster == nil || clusters[0].ManagedCluster.Available != "True" {
+		t.Errorf("edge-1 hub state = %+v, want the ManagedCluster", clusters[0].ManagedCluster)
+	}
+	// A same-named cluster of another hub isn't matched against the built-in hub
+	if clusters[1].ManagedCluster != nil {
+		t.Errorf("edge-2 of hub its2 got the built-in hub state %+v", clusters[1].ManagedCluster)
+	}
+	if discovered := clusters[2]; discovered.Status != "PendingAcceptance" || discovered.Message != "Discovered on hub" || discovered.Hub != builtinHub.Name {
+		t.Errorf("discovered cluster = %+v", discovered)
+	}
+}
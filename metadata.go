@@ -0,0 +1,151 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/yaml"
+)
+
+// embeddedPluginYAML holds the plugin.yaml the plugin was built with and
+// serves as the metadata default when no external file is configured
+//
+//go:embed plugin.yaml
+var embeddedPluginYAML []byte
+
+// defaultMetadata returns the metadata embedded at build time
+func defaultMetadata() PluginMetadata {
+	metadata, err := parseMetadata(embeddedPluginYAML)
+	if err != nil {
+		// The embedded file is part of the build, so this is a programming error
+		panic(fmt.Sprintf("embedded plugin.yaml is invalid: %v", err))
+	}
+	return metadata
+}
+
+// parseMetadata decodes plugin.yaml content
+func parseMetadata(data []byte) (PluginMetadata, error) {
+	var metadata PluginMetadata
+	if err := yaml.Unmarshal(data, &metadata); err != nil {
+		return PluginMetadata{}, fmt.Errorf("failed to parse plugin metadata: %w", err)
+	}
+	if len(metadata.Endpoints) == 0 {
+		return PluginMetadata{}, fmt.Errorf("plugin metadata declares no endpoints")
+	}
+	expandAPIVersions(&metadata)
+	return metadata, nil
+}
+
+var validHTTPMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+// Validate checks the metadata for consistency and returns all problems found
+// at once. Every endpoint handler must be a key of handlers.
+func (m PluginMetadata) Validate(handlers map[string]gin.HandlerFunc) error {
+	var errs []error
+
+	if strings.TrimSpace(m.ID) == "" {
+		errs = append(errs, fmt.Errorf("id must not be empty"))
+	}
+	if strings.TrimSpace(m.Name) == "" {
+		errs = append(errs, fmt.Errorf("name must not be empty"))
+	}
+	if _, err := version.ParseSemantic(m.Version); err != nil {
+		errs = append(errs, fmt.Errorf("version %q is not a valid semantic version: %w", m.Version, err))
+	}
+
+	if m.APIVersion != "" && !apiVersionPattern.MatchString(m.APIVersion) {
+		errs = append(errs, fmt.Errorf("apiVersion %q must look like v1", m.APIVersion))
+	}
+
+	declared := make(map[string]bool)
+	for _, permission := range m.Permissions {
+		declared[permission] = true
+	}
+
+	routes := make(map[string]bool)
+	for i, endpoint := range m.Endpoints {
+		if !strings.HasPrefix(endpoint.Path, "/") {
+			errs = append(errs, fmt.Errorf("endpoint %d: path %q must start with '/'", i, endpoint.Path))
+		}
+		if !validHTTPMethods[endpoint.Method] {
+			errs = append(errs, fmt.Errorf("endpoint %s: invalid HTTP method %q", endpoint.Path, endpoint.Method))
+		}
+
+		route := endpoint.Method + " " + endpoint.Path
+		if routes[route] {
+			errs = append(errs, fmt.Errorf("endpoint %s: duplicate route %s", endpoint.Path, route))
+		}
+		routes[route] = true
+
+		if endpoint.Permission != "" && !declared[endpoint.Permission] {
+			errs = append(errs, fmt.Errorf("endpoint %s: permission %q is not declared in permissions", endpoint.Path, endpoint.Permission))
+		}
+		if _, exists := handlers[endpoint.Handler]; !exists {
+			errs = append(errs, fmt.Errorf("endpoint %s: handler %q is not provided by the plugin", endpoint.Path, endpoint.Handler))
+		}
+		errs = append(errs, validateVersioning(endpoint)...)
+		if endpoint.RequestSchema != "" && m.Schemas[endpoint.RequestSchema] == nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: request schema %q is not declared in schemas", endpoint.Path, endpoint.RequestSchema))
+		}
+	}
+	errs = append(errs, validateSchemas(m.Schemas)...)
+
+	return errors.Join(errs...)
+}
+
+// loadMetadata reads plugin metadata from path, falling back to the embedded
+// defaults when no path is given or the file can't be read. Optional fields
+// left empty in the file are filled in from the defaults.
+func loadMetadata(path string) (PluginMetadata, error) {
+	defaults := defaultMetadata()
+	if path == "" {
+		return defaults, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger().Warn("Failed to read plugin metadata, using embedded defaults", "path", path, "error", err)
+		return defaults, nil
+	}
+
+	metadata, err := parseMetadata(data)
+	if err != nil {
+		return PluginMetadata{}, fmt.Errorf("invalid plugin metadata in %s: %w", path, err)
+	}
+
+	if metadata.Description == "" {
+		metadata.Description = defaults.Description
+	}
+	if metadata.Author == "" {
+		metadata.Author = defaults.Author
+	}
+	if metadata.Dependencies == nil {
+		metadata.Dependencies = defaults.Dependencies
+	}
+	if metadata.Permissions == nil {
+		metadata.Permissions = defaults.Permissions
+	}
+	if metadata.Compatibility == nil {
+		metadata.Compatibility = defaults.Compatibility
+	}
+	if metadata.Schemas == nil {
+		metadata.Schemas = defaults.Schemas
+	}
+
+	logger().Info("Loaded plugin metadata", "path", path)
+	return metadata, nil
+}
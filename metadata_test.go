@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetadataValidate(t *testing.T) {
+	handlers := map[string]gin.HandlerFunc{"StatusHandler": func(*gin.Context) {}}
+	valid := func() PluginMetadata {
+		return PluginMetadata{
+			ID:          "cluster",
+			Name:        "Cluster",
+			Version:     "1.0.0",
+			Permissions: []string{"cluster.read"},
+			Endpoints: []EndpointConfig{
+				{Path: "/status", Method: "GET", Handler: "StatusHandler", Permission: "cluster.read"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(m *PluginMetadata)
+		wantErr string
+	}{
+		{name: "valid", mutate: func(m *PluginMetadata) {}},
+		{name: "empty id", mutate: func(m *PluginMetadata) { m.ID = " " }, wantErr: "id must not be empty"},
+		{name: "empty name", mutate: func(m *PluginMetadata) { m.Name = "" }, wantErr: "name must not be empty"},
+		{name: "bad version", mutate: func(m *PluginMetadata) { m.Version = "one" }, wantErr: "not a valid semantic version"},
+		{name: "relative path", mutate: func(m *PluginMetadata) { m.Endpoints[0].Path = "status" }, wantErr: "must start with '/'"},
+		{name: "bad method", mutate: func(m *PluginMetadata) { m.Endpoints[0].Method = "FETCH" }, wantErr: "invalid HTTP method"},
+		{
+			name:    "duplicate route",
+			mutate:  func(m *PluginMetadata) { m.Endpoints = append(m.Endpoints, m.Endpoints[0]) },
+			wantErr: "duplicate route GET /status",
+		},
+		{
+			name:    "undeclared permission",
+			mutate:  func(m *PluginMetadata) { m.Endpoints[0].Permission = "cluster.write" },
+			wantErr: `permission "cluster.write" is not declared`,
+		},
+		{
+			name:    "missing handler",
+			mutate:  func(m *PluginMetadata) { m.Endpoints[0].Handler = "MissingHandler" },
+			wantErr: `handler "MissingHandler" is not provided`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := valid()
+			tt.mutate(&metadata)
+			err := metadata.Validate(handlers)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMetadataIsValid(t *testing.T) {
+	plugin := &ClusterPlugin{}
+	if err := defaultMetadata().Validate(plugin.GetHandlers()); err != nil {
+		t.Fatalf("embedded plugin.yaml is invalid: %v", err)
+	}
+}
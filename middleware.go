@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// PluginMiddleware is a cross-cutting concern, such as authentication or
+// auditing, that the host wraps around every plugin route
+type PluginMiddleware struct {
+	Name    string
+	Handler gin.HandlerFunc
+}
+
+// MiddlewarePlugin is implemented by plugins that hand their middlewares to
+// the host instead of baking them into each handler
+type MiddlewarePlugin interface {
+	// GetMiddlewares returns the middlewares outermost first, in the order
+	// the host must apply them
+	GetMiddlewares() []PluginMiddleware
+}
+
+// handlerWrapper adds a concern around the handler of the named endpoint
+type handlerWrapper struct {
+	name string
+	wrap func(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc
+}
+
+// handlerWrappers lists the concerns every endpoint gets, outermost first.
+// Headers wrap everything so that rejections carry them too, and requests
+// are rate limited by address before anything is spent authenticating them.
+func (cp *ClusterPlugin) handlerWrappers() []handlerWrapper {
+	plain := func(wrap func(gin.HandlerFunc) gin.HandlerFunc) func(string, gin.HandlerFunc) gin.HandlerFunc {
+		return func(_ string, handler gin.HandlerFunc) gin.HandlerFunc { return wrap(handler) }
+	}
+	return []handlerWrapper{
+		{"responseHeaders", plain(cp.withResponseHeaders)},
+		{"deprecation", cp.withDeprecation},
+		{"compression", cp.withCompression},
+		{"requestId", plain(cp.withRequestID)},
+		{"language", plain(cp.withLanguage)},
+		{"tracing", cp.withTracing},
+		{"audit", cp.withAudit},
+		{"rateLimit", cp.withRateLimit},
+		{"authentication", plain(cp.withAuthentication)},
+		{"callerRateLimit", plain(cp.withCallerRateLimit)},
+		{"tenant", cp.withTenant},
+		{"timeout", cp.withTimeout},
+		{"rbac", cp.withRBAC},
+		{"permission", cp.withPermission},
+		{"leadership", cp.withLeadership},
+		{"faults", cp.withFaults},
+		{"requestSchema", cp.withSchemaValidation},
+	}
+}
+
+// wrapHandler applies every concern to the handler of an endpoint
+func (cp *ClusterPlugin) wrapHandler(name string, handler gin.HandlerFunc) gin.HandlerFunc {
+	wrappers := cp.handlerWrappers()
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		handler = wrappers[i].wrap(name, handler)
+	}
+	return handler
+}
+
+// GetMiddlewares returns the plugin's concerns as gin middlewares. They are
+// only left out of GetHandlers when Initialize is given
+// hostAppliesMiddlewares, so hosts that don't know about them stay safe.
+func (cp *ClusterPlugin) GetMiddlewares() []PluginMiddleware {
+	wrappers := cp.handlerWrappers()
+	middlewares := make([]PluginMiddleware, 0, len(wrappers))
+	for _, wrapper := range wrappers {
+		wrapper := wrapper
+		middlewares = append(middlewares, PluginMiddleware{
+			Name: wrapper.name,
+			Handler: func(c *gin.Context) {
+				next := false
+				wrapper.wrap(cp.routeHandler(c), func(c *gin.Context) {
+					next = true
+					c.Next()
+				})(c)
+				// A concern that answered the request itself stops the chain
+				if !next {
+					c.Abort()
+				}
+			},
+		})
+	}
+	return middlewares
+}
+
+// routeHandler returns the name of the handler serving the matched route,
+// whatever prefix the host mounted the plugin under
+func (cp *ClusterPlugin) routeHandler(c *gin.Context) string {
+	endpoint, _ := cp.routeEndpoint(c)
+	return endpoint.Handler
+}
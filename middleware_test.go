@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetMiddlewares(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"hostAppliesMiddlewares": true,
+		"enforcePermissions":     true,
+		"hostToken":              "host-secret",
+	})
+
+	var names []string
+	for _, middleware := range plugin.GetMiddlewares() {
+		names = append(names, middleware.Name)
+	}
+	if len(names) == 0 || names[0] != "responseHeaders" || names[len(names)-1] != "requestSchema" {
+		t.Errorf("GetMiddlewares() = %v, want response headers outermost and request validation innermost", names)
+	}
+
+	// The host mounts the bare handlers under its own prefix and wraps them
+	router := gin.New()
+	routes := router.Group("/api/plugins/kubestellar-cluster-plugin")
+	for _, middleware := range plugin.GetMiddlewares() {
+		routes.Use(middleware.Handler)
+	}
+	if err := mountEndpoints(routes, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		permissions string
+		wantStatus  int
+	}{
+		{name: "no host token", permissions: "cluster.read", wantStatus: http.StatusUnauthorized},
+		{name: "missing permission", token: "host-secret", permissions: "cluster.write", wantStatus: http.StatusForbidden},
+		{name: "granted", token: "host-secret", permissions: "cluster.read", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/plugins/kubestellar-cluster-plugin/status", nil)
+			request.Header.Set(defaultHostTokenHeader, tt.token)
+			request.Header.Set(defaultPermissionsHeader, tt.permissions)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if recorder.Header().Get(requestIDHeader) == "" || recorder.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Errorf("headers = %v, want the request ID and security headers", recorder.Header())
+			}
+		})
+	}
+}
+
+func TestGetHandlersBakesMiddlewares(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"enforcePermissions": true,
+		"hostToken":          "host-secret",
+	})
+	router := gin.New()
+	router.GET("/status", plugin.GetHandlers()["GetClusterStatusHandler"])
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status without middlewares applied by the host = %d, want 401", recorder.Code)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// The plugin runs against the real hubs and clusters in live mode. Mock mode
+// serves a generated fleet from memory for UI development and fakes the
+// onboarding and detach operations.
+const (
+	modeLive = "live"
+	modeMock = "mock"
+)
+
+// mockFailPrefix marks the clusters whose mock onboarding fails, so the UI
+// can exercise its failure views
+const mockFailPrefix = "fail-"
+
+// mockConfig shapes the fake fleet of mock mode. The same seed always
+// generates the same fleet.
+type mockConfig struct {
+	FleetSize int
+	Seed      int64
+	// StepDelay is how long each faked onboarding or detach step takes
+	StepDelay time.Duration
+}
+
+var (
+	mockProviders    = []string{"eks", "gke", "aks", "openshift", "kind"}
+	mockRegions      = []string{"us-east-1", "us-west-2", "eu-west-1", "eu-central-1", "ap-southeast-1"}
+	mockEnvironments = []string{"prod", "staging", "dev"}
+)
+
+// modeFromConfig reads the mode and, in mock mode, the fleet to generate
+func modeFromConfig(config map[string]interface{}) (string, mockConfig, error) {
+	mode := configString(config, "mode", modeLive)
+	if mode != modeLive && mode != modeMock {
+		return "", mockConfig{}, fmt.Errorf("invalid mode %q, must be %q or %q", mode, modeLive, modeMock)
+	}
+	mock := mockConfig{
+		FleetSize: configInt(config, "mockFleetSize", 50),
+		Seed:      int64(configInt(config, "mockSeed", 1)),
+		StepDelay: time.Duration(configInt(config, "mockStepDelayMillis", 500)) * time.Millisecond,
+	}
+	if mock.FleetSize < 0 || mock.StepDelay < 0 {
+		return "", mockConfig{}, fmt.Errorf("mockFleetSize and mockStepDelayMillis must not be negative")
+	}
+	return mode, mock, nil
+}
+
+// mockFleet is a generated fleet with the history and onboarding jobs that
+// led to it
+type mockFleet struct {
+	clusters []ClusterStatus
+	history  []HistoryEntry
+	jobs     []Job
+}
+
+// generateMockFleet builds a fleet of the configured size, registered over
+// the 30 days before now. Most clusters are Ready, some have had outages and
+// a few are failed or unreachable.
+func generateMockFleet(mock mockConfig, now time.Time) mockFleet {
+	random := rand.New(rand.NewSource(mock.Seed))
+	now = now.UTC().Truncate(time.Second)
+	var fleet mockFleet
+	for i := 0; i < mock.FleetSize; i++ {
+		provider := mockProviders[random.Intn(len(mockProviders))]
+		env := mockEnvironments[random.Intn(len(mockEnvironments))]
+		name := fmt.Sprintf("%s-%s-%03d", provider, env, i+1)
+		jobID := fmt.Sprintf("onboard-%016x", random.Uint64())
+
+		// The final status decides how the story of the cluster ends
+		final := "Ready"
+		switch roll := random.Intn(100); {
+		case roll < 7:
+			final = "Failed"
+		case roll < 15:
+			final = statusUnreachable
+		}
+
+		// Every cluster had at least an hour to settle
+		registered := now.Add(-time.Duration(60+random.Intn(30*24*60)) * time.Minute)
+		at := registered
+		previous := ""
+		steps := []JobStep{{Name: string(JobRunning), Timestamp: registered.Format(time.RFC3339)}}
+		record := func(status, message string) {
+			entry := HistoryEntry{
+				ClusterName: name,
+				Timestamp:   at.Format(time.RFC3339),
+				Status:      status,
+				Previous:    previous,
+				Event:       historyEvent(previous, status),
+				Message:     message,
+				JobID:       jobID,
+			}
+			fleet.history = append(fleet.history, entry)
+			previous = status
+		}
+		record("Preparing", "Preparing cluster configuration")
+		for _, step := range []string{"Validating", "Retrieving", "Joining"} {
+			at = at.Add(time.Duration(10+random.Intn(120)) * time.Second)
+			steps = append(steps, JobStep{Name: step, Timestamp: at.Format(time.RFC3339)})
+			record(step, "Mock onboarding step "+strings.ToLower(step))
+		}
+
+		job := Job{
+			ID:          jobID,
+			Type:        "onboard",
+			ClusterName: name,
+			State:       JobSucceeded,
+			Message:     "Cluster successfully onboarded to KubeStellar",
+			CreatedAt:   registered.Format(time.RFC3339),
+		}
+		status := ClusterStatus{
+			ClusterName: name,
+			JobID:       jobID,
+			Labels: map[string]string{
+				"provider":    provider,
+				"region":      mockRegions[random.Intn(len(mockRegions))],
+				"environment": env,
+			},
+		}
+		switch final {
+		case "Failed":
+			at = at.Add(time.Duration(1+random.Intn(5)) * time.Minute)
+			job.State, job.Message = JobFailed, "Onboarding failed: mock agent never registered"
+			record("Failed", job.Message)
+		default:
+			at = at.Add(time.Duration(1+random.Intn(10)) * time.Minute)
+			record("Ready", job.Message)
+			// Some clusters went through an outage they recovered from
+			if random.Intn(4) == 0 && now.Sub(at) > 2*time.Hour {
+				at = at.Add(time.Duration(random.Int63n(int64(now.Sub(at) - time.Hour))))
+				record(statusUnreachable, "Cluster agent stopped renewing its lease")
+				at = at.Add(time.Duration(5+random.Intn(50)) * time.Minute)
+				record("Ready", "Cluster agent renewed its lease")
+			}
+			if final == statusUnreachable {
+				at = at.Add(time.Duration(random.Int63n(int64(now.Sub(at)) + 1)))
+				record(statusUnreachable, "Cluster agent stopped renewing its lease")
+			}
+		}
+		job.CompletedAt = at.Format(time.RFC3339)
+		job.UpdatedAt = job.CompletedAt
+		job.Steps = append(steps, JobStep{Name: string(job.State), Message: job.Message, Timestamp: job.CompletedAt})
+		fleet.jobs = append(fleet.jobs, job)
+
+		last := fleet.history[len(fleet.history)-1]
+		status.Status, status.Message, status.LastUpdated = last.Status, last.Message, last.Timestamp
+		if status.Status == "Ready" {
+			status.LastHeartbeat = now.Add(-time.Duration(random.Intn(60)) * time.Second).Format(time.RFC3339)
+			status.Resources = mockResources(rand.New(rand.NewSource(mock.Seed+int64(i))), now)
+		}
+		fleet.clusters = append(fleet.clusters, status)
+	}
+	return fleet
+}
+
+// mockResources sizes a mock cluster. It draws from its own source so the
+// rest of the fleet stays the same for a seed.
+func mockResources(random *rand.Rand, now time.Time) *ClusterResources {
+	nodes := 1 + random.Intn(12)
+	return &ClusterResources{
+		Nodes:             nodes,
+		ReadyNodes:        nodes - random.Intn(2)*random.Intn(nodes),
+		AllocatableCPU:    fmt.Sprintf("%dm", nodes*(3800+random.Intn(4)*4000)),
+		AllocatableMemory: fmt.Sprintf("%dGi", nodes*(7+random.Intn(4)*8)),
+		Source:            resourcesFromHub,
+		CollectedAt:       now.Format(time.RFC3339),
+	}
+}
+
+// seedMockFleet fills the store and job list with a generated fleet
+func (cp *ClusterPlugin) seedMockFleet(mock mockConfig) error {
+	fleet := generateMockFleet(mock, time.Now())
+	for _, cluster := range fleet.clusters {
+		if err := cp.store.Put(cluster); err != nil {
+			return err
+		}
+	}
+	for _, entry := range fleet.history {
+		if err := cp.store.AppendHistory(entry); err != nil {
+			return err
+		}
+	}
+	cp.jobs.Import(fleet.jobs, nil)
+	return nil
+}
+
+// mockStep is a status a faked operation passes through
+type mockStep struct {
+	// name is the pipeline step faked, if any
+	name    string
+	status  string
+	message string
+}
+
+// mockSteps returns the steps of a faked onboarding, which follow the
+// configured pipeline, or detachment
+func (cp *ClusterPlugin) mockSteps(operation string) []mockStep {
+	if operation == "detach" {
+		return []mockStep{{"", "Detaching", "Removing cluster from hub"}}
+	}
+	steps := []mockStep{{"", "Preparing", "Preparing cluster configuration"}}
+	for _, step := range cp.onboarding {
+		steps = append(steps, mockStep{step.name, step.status, step.message})
+	}
+	return steps
+}
+
+// mockWait sleeps for d unless the job is cancelled
+func mockWait(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// mockOperation walks a cluster through the steps of a faked onboarding or
+// detachment without touching any cluster, failing where a fault says so.
+// Onboarding steps are checkpointed like real ones.
+func (cp *ClusterPlugin) mockOperation(ctx context.Context, operation, jobID, clusterName string) error {
+	logger().Info("Starting mock operation", "cluster", clusterName, "operation", operation)
+	started := time.Now()
+	fault := cp.mockFault(operation, clusterName)
+	delay := cp.mockStepDelay()
+	steps := cp.mockSteps(operation)
+	done, _ := cp.checkpoints.Progress(jobID)
+	for i, step := range steps {
+		if step.name != "" && done[step.name] {
+			cp.skipCheckpointedStep(jobID, clusterName, step.name)
+			continue
+		}
+		if i > 0 {
+			if err := mockWait(ctx, delay); err != nil {
+				return err
+			}
+		}
+		if err := cp.advance(ctx, clusterName, step.status, step.message); err != nil {
+			return err
+		}
+		if err := cp.injectStepFault(step.name); step.name != "" && err != nil {
+			return err
+		}
+		// A fault without a step fails the last one
+		if fault != nil && (step.status == fault.Step || fault.Step == "" && i == len(steps)-1) {
+			// The failure comes no earlier than the fault asks for
+			if err := mockWait(ctx, time.Until(started.Add(time.Duration(fault.AfterSeconds)*time.Second))); err != nil {
+				return err
+			}
+			if fault.Message != "" {
+				return errors.New(fault.Message)
+			}
+			return fmt.Errorf("mock step %s failed", step.status)
+		}
+		if step.name != "" && jobID != "" {
+			cp.checkpoints.Complete(jobID, step.name, checkpointCompleted, "", "")
+		}
+	}
+	return mockWait(ctx, delay)
+}
+
+// mockKubeconfig stands in for a local kubeconfig in mock mode, so clusters
+// can be onboarded by name alone
+func mockKubeconfig(clusterName string) ([]byte, error) {
+	return clientcmd.Write(clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{
+			clusterName: {Server: fmt.Sprintf("https://%s.mock.invalid:6443", clusterName)},
+		},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			clusterName: {Token: "mock"},
+		},
+		Contexts: map[string]*clientcmdapi.Context{
+			clusterName: {Cluster: clusterName, AuthInfo: clusterName},
+		},
+		CurrentContext: clusterName,
+	})
+}
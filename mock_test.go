@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGenerateMockFleet(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	fleet := generateMockFleet(mockConfig{FleetSize: 40, Seed: 42}, now)
+	if !reflect.DeepEqual(fleet, generateMockFleet(mockConfig{FleetSize: 40, Seed: 42}, now)) {
+		t.Fatal("the same seed generated different fleets")
+	}
+	if other := generateMockFleet(mockConfig{FleetSize: 40, Seed: 43}, now); reflect.DeepEqual(fleet.clusters, other.clusters) {
+		t.Error("different seeds generated the same fleet")
+	}
+	if len(fleet.clusters) != 40 || len(fleet.jobs) != 40 {
+		t.Fatalf("fleet has %d clusters and %d jobs, want 40", len(fleet.clusters), len(fleet.jobs))
+	}
+
+	names := map[string]bool{}
+	for _, cluster := range fleet.clusters {
+		if names[cluster.ClusterName] {
+			t.Errorf("duplicate cluster %s", cluster.ClusterName)
+		}
+		names[cluster.ClusterName] = true
+		if err := validateClusterName(cluster.ClusterName); err != nil {
+			t.Error(err)
+		}
+		if updated, err := time.Parse(time.RFC3339, cluster.LastUpdated); err != nil || updated.After(now) {
+			t.Errorf("%s last updated %q", cluster.ClusterName, cluster.LastUpdated)
+		}
+	}
+	breakdown := fleetBreakdown(fleet.clusters)
+	if breakdown.ByProvider["unknown"] != 0 || breakdown.ByRegion["unknown"] != 0 || breakdown.ByStatus["Ready"] == 0 {
+		t.Errorf("breakdown = %+v", breakdown)
+	}
+	for _, job := range fleet.jobs {
+		if !job.Finished() {
+			t.Errorf("job %s is %s", job.ID, job.State)
+		}
+	}
+}
+
+func TestModeFromConfig(t *testing.T) {
+	mode, _, err := modeFromConfig(nil)
+	if err != nil || mode != modeLive {
+		t.Errorf("default mode = %q, %v", mode, err)
+	}
+	if _, _, err := modeFromConfig(map[string]interface{}{"mode": "demo"}); err == nil {
+		t.Error("unknown mode accepted")
+	}
+	if _, _, err := modeFromConfig(map[string]interface{}{"mode": modeMock, "mockFleetSize": -1}); err == nil {
+		t.Error("negative fleet size accepted")
+	}
+}
+
+func TestMockMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode":                modeMock,
+		"mockFleetSize":       12,
+		"mockSeed":            7,
+		"mockStepDelayMillis": 1,
+	})
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, "/api/plugins/kubestellar-cluster-plugin/v1"+path, nil))
+		return recorder
+	}
+
+	var status ClusterStatusResponse
+	if err := json.Unmarshal(serve(http.MethodGet, "/status").Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Mode != modeMock || status.Summary["total"] != 12 || status.Hub != nil {
+		t.Errorf("status = mode %q, summary %v, hub %+v", status.Mode, status.Summary, status.Hub)
+	}
+
+	onboard := func(name string) OnboardResponse {
+		recorder := serve(http.MethodPost, "/onboard?name="+name)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("onboard %s = %d %s", name, recorder.Code, recorder.Body.String())
+		}
+		var response OnboardResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	finished := func(job Job) bool { return job.Finished() }
+
+	job := waitForJob(t, plugin.jobs, onboard("edge-mock").JobID, finished)
+	if cluster, _, _ := plugin.store.Get("edge-mock"); job.State != JobSucceeded || cluster.Status != "Ready" {
+		t.Errorf("mock onboarding = %s, cluster %s", job.State, cluster.Status)
+	}
+	job = waitForJob(t, plugin.jobs, onboard(mockFailPrefix+"edge").JobID, finished)
+	if job.State != JobFailed || !strings.Contains(job.Message, "mock step") {
+		t.Errorf("failing mock onboarding = %s %q", job.State, job.Message)
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/plugins/kubestellar-cluster-plugin/v1/detach", strings.NewReader(`{"clusterName": "edge-mock"}`))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("detach = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var detach struct {
+		JobID string `json:"jobId"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &detach)
+	if job := waitForJob(t, plugin.jobs, detach.JobID, finished); job.State != JobSucceeded {
+		t.Errorf("mock detach = %s %q", job.State, job.Message)
+	}
+	if _, exists, _ := plugin.store.Get("edge-mock"); exists {
+		t.Error("detached mock cluster still in the inventory")
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// OnboardRequest is the JSON body accepted by POST /onboard.
+//
+// Exactly one kubeconfig source is used: inline Kubeconfig content, a
+// KubeconfigSecretRef on the ITS hub, a Context stored through POST
+// /kubeconfigs, or (when all are omitted) the entry named ClusterName in the
+// plugin's local kubeconfig.
+type OnboardRequest struct {
+	// ClusterName is the name the cluster is registered under on the hub
+	ClusterName string `json:"clusterName" binding:"required"`
+	// Hub names the registered hub to onboard to, defaulting to the selected one
+	Hub string `json:"hub,omitempty"`
+	// Kubeconfig is the raw kubeconfig content of the spoke cluster
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// KubeconfigSecretRef points at a hub Secret holding the spoke kubeconfig
+	KubeconfigSecretRef *SecretReference `json:"kubeconfigSecretRef,omitempty"`
+	// Context names a kubeconfig context stored with the plugin
+	Context string `json:"context,omitempty"`
+	// Provider fetches the kubeconfig from the service running the cluster
+	Provider *ProviderSpec `json:"provider,omitempty"`
+	// DryRun validates the request and returns the planned actions without applying them
+	DryRun bool `json:"dryRun,omitempty"`
+	// Labels and Annotations are recorded on the cluster for label-based selection
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// ManifestValues override the plugin's manifestValues for this cluster
+	ManifestValues *ManifestValues `json:"manifestValues,omitempty"`
+	// Charts are Helm charts to install on the cluster once it has joined,
+	// in place of the charts of the manifestValues
+	Charts []HelmChart `json:"charts,omitempty"`
+	// Schedule defers the onboarding to a future time or maintenance window
+	Schedule *ScheduleSpec `json:"schedule,omitempty"`
+}
+
+// Validate checks the request beyond what the binding tags cover
+func (r OnboardRequest) Validate() error {
+	if err := validateClusterName(r.ClusterName); err != nil {
+		return err
+	}
+	if err := validateLabels(r.Labels); err != nil {
+		return err
+	}
+	sources := 0
+	for _, set := range []bool{r.Kubeconfig != "", r.KubeconfigSecretRef != nil, r.Context != "", r.Provider != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of kubeconfig, kubeconfigSecretRef, context and provider may be set")
+	}
+	if r.KubeconfigSecretRef != nil && r.KubeconfigSecretRef.Name == "" {
+		return fmt.Errorf("kubeconfigSecretRef.name is required")
+	}
+	if r.Provider != nil {
+		if err := r.Provider.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.ManifestValues != nil {
+		if err := r.ManifestValues.Validate(); err != nil {
+			return fmt.Errorf("invalid manifestValues: %w", err)
+		}
+	}
+	if err := validateCharts(r.Charts); err != nil {
+		return err
+	}
+	if r.Schedule != nil {
+		if err := r.Schedule.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// values returns the manifest values of the request with its charts, which
+// travel with them through schedules, checkpoints and repairs
+func (r OnboardRequest) values() *ManifestValues {
+	if len(r.Charts) == 0 {
+		return r.ManifestValues
+	}
+	var values ManifestValues
+	if r.ManifestValues != nil {
+		values = *r.ManifestValues
+	}
+	values.Charts = r.Charts
+	return &values
+}
+
+// BatchOnboardRequest is the JSON body accepted by POST /onboard/batch
+type BatchOnboardRequest struct {
+	Clusters []OnboardRequest `json:"clusters"`
+	// Labels are added to every cluster of the batch
+	Labels map[string]string `json:"labels,omitempty"`
+	// LabelSelector retries onboarding of the recorded clusters it matches
+	// that previously failed, using their saved kubeconfig. It cannot be
+	// combined with Clusters.
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// Group retries onboarding of the failed clusters of a group like
+	// LabelSelector does
+	Group string `json:"group,omitempty"`
+}
+
+// ProvisionRequest is the JSON body accepted by POST /clusters/provision
+type ProvisionRequest struct {
+	// ClusterName names both the local cluster and the ManagedCluster
+	ClusterName string `json:"clusterName" binding:"required"`
+	// Tool creates the cluster: kind (the default) or k3d
+	Tool string `json:"tool,omitempty"`
+	// Image overrides the node image, e.g. kindest/node:v1.29.2 for kind
+	Image string `json:"image,omitempty"`
+	// Hub names the registered hub to onboard to, defaulting to the selected one
+	Hub string `json:"hub,omitempty"`
+	// Labels and Annotations are recorded on the cluster for label-based selection
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Validate checks the request beyond what the binding tags cover
+func (r ProvisionRequest) Validate() error {
+	if err := validateClusterName(r.ClusterName); err != nil {
+		return err
+	}
+	if err := validateLabels(r.Labels); err != nil {
+		return err
+	}
+	if _, ok := localProvisioners[r.Tool]; !ok {
+		return fmt.Errorf("unsupported tool %q, must be kind or k3d", r.Tool)
+	}
+	return nil
+}
+
+// KubeconfigUploadRequest is the JSON body accepted by POST /kubeconfigs
+type KubeconfigUploadRequest struct {
+	// Kubeconfig is the raw kubeconfig content
+	Kubeconfig string `json:"kubeconfig" binding:"required"`
+	// Contexts limits the import to the named contexts
+	Contexts []string `json:"contexts,omitempty"`
+}
+
+// OnboardResponse is returned by POST /onboard once onboarding has started
+type OnboardResponse struct {
+	// Message is rendered in the language negotiated from Accept-Language.
+	// MessageCode identifies it for clients with their own wording.
+	Message     string      `json:"message"`
+	MessageCode MessageCode `json:"messageCode"`
+	Status      string      `json:"status"`
+	Plugin      string      `json:"plugin"`
+	ClusterName string      `json:"clusterName"`
+	JobID       string      `json:"jobId"`
+	// QueuePosition is where the job waits for a worker when all are busy
+	QueuePosition int    `json:"queuePosition,omitempty"`
+	Timestamp     string `json:"timestamp"`
+}
+
+// DetachRequest is the JSON body accepted by POST /detach. It targets either
+// a single cluster by ClusterName, every cluster matching LabelSelector or
+// every member of Group.
+type DetachRequest struct {
+	// ClusterName is the onboarded cluster to detach
+	ClusterName string `json:"clusterName,omitempty"`
+	// LabelSelector selects the clusters to detach by their recorded labels
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// Group selects the clusters to detach by the group they belong to
+	Group string `json:"group,omitempty"`
+	// Hub is the hub the cluster is onboarded to. With LabelSelector or
+	// Group it limits the detachment to the clusters of that hub.
+	Hub string `json:"hub,omitempty"`
+	// Force continues detachment when hub or local cleanup steps fail and
+	// strips ManagedCluster finalizers that outlive the finalizer timeout
+	Force bool `json:"force,omitempty"`
+	// Drain cordons the cluster and waits for its workloads to be
+	// rescheduled elsewhere before detaching it, for up to
+	// DrainTimeoutSeconds or the drainTimeoutSeconds config
+	Drain               bool `json:"drain,omitempty"`
+	DrainTimeoutSeconds int  `json:"drainTimeoutSeconds,omitempty"`
+	// SkipHooks detaches without running the pre-detach hooks, for a
+	// cluster an external system already forgot or can't be told about
+	SkipHooks bool `json:"skipHooks,omitempty"`
+	// Kubeconfig is the raw spoke kubeconfig used to remove the klusterlet
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Context names a stored kubeconfig context used to unjoin the spoke
+	Context string `json:"context,omitempty"`
+	// DryRun validates the request and returns the planned actions without applying them
+	DryRun bool `json:"dryRun,omitempty"`
+	// Schedule defers the detachment to a future time or maintenance window
+	Schedule *ScheduleSpec `json:"schedule,omitempty"`
+}
+
+// Validate checks the request beyond what the binding tags cover
+func (r DetachRequest) Validate() error {
+	if r.DrainTimeoutSeconds < 0 || (r.DrainTimeoutSeconds > 0 && !r.Drain) {
+		return fmt.Errorf("drainTimeoutSeconds must be positive and requires drain")
+	}
+	if r.LabelSelector != "" || r.Group != "" {
+		if r.ClusterName != "" || (r.LabelSelector != "" && r.Group != "") {
+			return fmt.Errorf("only one of clusterName, labelSelector and group may be set")
+		}
+		if r.Kubeconfig != "" || r.Context != "" {
+			return fmt.Errorf("kubeconfig and context can't be used with labelSelector or group")
+		}
+		if r.Schedule != nil {
+			return fmt.Errorf("schedule can't be used with labelSelector or group")
+		}
+		return nil
+	}
+	if err := validateClusterName(r.ClusterName); err != nil {
+		return err
+	}
+	if r.Kubeconfig != "" && r.Context != "" {
+		return fmt.Errorf("only one of kubeconfig and context may be set")
+	}
+	if r.Schedule != nil {
+		return r.Schedule.Validate()
+	}
+	return nil
+}
+
+// DetachResponse is returned by POST /detach once detachment has started
+type DetachResponse struct {
+	Message     string      `json:"message"`
+	MessageCode MessageCode `json:"messageCode"`
+	Status      string      `json:"status"`
+	JobID       string      `json:"jobId"`
+	// QueuePosition is where the job waits for a worker when all are busy
+	QueuePosition int           `json:"queuePosition,omitempty"`
+	Previous      ClusterStatus `json:"previous"`
+	Plugin        string        `json:"plugin"`
+	Timestamp     string        `json:"timestamp"`
+}
+
+// RepairResponse is returned by POST /clusters/:name/repair once the repair
+// job has started
+type RepairResponse struct {
+	Message     string      `json:"message"`
+	MessageCode MessageCode `json:"messageCode"`
+	Status      string      `json:"status"`
+	ClusterName string      `json:"clusterName"`
+	JobID       string      `json:"jobId"`
+	// QueuePosition is where the job waits for a worker when all are busy
+	QueuePosition int             `json:"queuePosition,omitempty"`
+	Diagnosis     RepairDiagnosis `json:"diagnosis"`
+	Plugin        string          `json:"plugin"`
+	Timestamp     string          `json:"timestamp"`
+}
+
+// TokenRotateResponse is returned by POST /clusters/:name/token/rotate
+type TokenRotateResponse struct {
+	Message     string      `json:"message"`
+	MessageCode MessageCode `json:"messageCode"`
+	ClusterName string      `json:"clusterName"`
+	Token       IssuedToken `json:"token"`
+	// Delivered is set when the token replaced the one in the bootstrap
+	// kubeconfig of a klusterlet already applied to the cluster
+	Delivered bool   `json:"delivered"`
+	Plugin    string `json:"plugin"`
+	Timestamp string `json:"timestamp"`
+}
+
+// ClusterStatusResponse is returned by GET /status
+type ClusterStatusResponse struct {
+	Clusters []ClusterStatus `json:"clusters"`
+	// Summary counts clusters by status; "total" holds the overall count
+	Summary map[string]int `json:"summary"`
+	// Continue is passed back as ?continue= to fetch the next page
+	Continue string `json:"continue,omitempty"`
+	// Hub reports the ManagedCluster watch connection when it is enabled
+	Hub *HubSyncStatus `json:"hub,omitempty"`
+	// Update reports the last plugin update check when it is enabled
+	Update *UpdateStatus `json:"update,omitempty"`
+	// Mode is live, or mock when the plugin serves a generated fleet
+	Mode      string `json:"mode"`
+	Plugin    string `json:"plugin"`
+	Timestamp string `json:"timestamp"`
+}
+
+// validateClusterName checks that a name can be used for a ManagedCluster
+func validateClusterName(name string) error {
+	if name == "" {
+		return fmt.Errorf("clusterName is required")
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("invalid clusterName '%s': %s", name, strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// natsJobBackend queues jobs on a JetStream work queue stream, consumed by
+// every replica through one durable consumer, and keeps the job records in a
+// key-value bucket. A job left unacked for the ack wait goes to another
+// replica.
+type natsJobBackend struct {
+	conn     *nats.Conn
+	subject  string
+	js       jetstream.JetStream
+	consumer jetstream.Consumer
+	records  jetstream.KeyValue
+}
+
+func newNATSJobBackend(config JobBackendConfig) (*natsJobBackend, error) {
+	conn, err := nats.Connect(config.URL, nats.Name("kubestellar-cluster-plugin"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the nats job backend: %w", err)
+	}
+	nb := &natsJobBackend{conn: conn, subject: config.Prefix + ".queue"}
+	if err := nb.setup(config); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set up the nats job backend: %w", err)
+	}
+	return nb, nil
+}
+
+// setup creates the stream, consumer and bucket, or updates them to match
+// the config
+func (nb *natsJobBackend) setup(config JobBackendConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var err error
+	if nb.js, err = jetstream.New(nb.conn); err != nil {
+		return err
+	}
+	// Stream and bucket names can't hold dots
+	name := strings.ReplaceAll(config.Prefix, ".", "-")
+	stream, err := nb.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      name,
+		Subjects:  []string{nb.subject},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		return err
+	}
+	nb.consumer, err = stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:   "replicas",
+		AckPolicy: jetstream.AckExplicitPolicy,
+		AckWait:   time.Duration(config.AckWaitSeconds) * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	nb.records, err = nb.js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: name + "-records", Storage: jetstream.FileStorage})
+	return err
+}
+
+func (nb *natsJobBackend) SaveJob(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = nb.records.Put(ctx, job.ID, data)
+	return err
+}
+
+func (nb *natsJobBackend) DeleteJob(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return nb.records.Purge(ctx, id)
+}
+
+func (nb *natsJobBackend) LoadJob(id string) (Job, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	entry, err := nb.records.Get(ctx, id)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+	var job Job
+	if err := json.Unmarshal(entry.Value(), &job); err != nil {
+		return Job{}, false, fmt.Errorf("invalid record of job %s: %w", id, err)
+	}
+	return job, true, nil
+}
+
+func (nb *natsJobBackend) LoadJobs() ([]Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	keys, err := nb.records.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer keys.Stop()
+	var jobs []Job
+	for key := range keys.Keys() {
+		job, found, err := nb.LoadJob(key)
+		if err != nil {
+			logger().Warn("Skipping unreadable job record", "job", key, "error", err)
+			continue
+		}
+		if found {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (nb *natsJobBackend) Enqueue(job queuedJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = nb.js.Publish(ctx, nb.subject, data, jetstream.WithMsgID(job.Job.ID))
+	return err
+}
+
+// Dequeue fetches for a second at a time so that it notices ctx ending
+func (nb *natsJobBackend) Dequeue(ctx context.Context) (*jobDelivery, error) {
+	for ctx.Err() == nil {
+		batch, err := nb.consumer.Fetch(1, jetstream.FetchMaxWait(time.Second))
+		if err != nil {
+			return nil, err
+		}
+		for msg := range batch.Messages() {
+			var job queuedJob
+			if err := json.Unmarshal(msg.Data(), &job); err != nil {
+				msg.Term()
+				return nil, fmt.Errorf("dropped invalid queued job: %w", err)
+			}
+			return &jobDelivery{job: job, ack: msg.Ack, nak: msg.Nak, touch: msg.InProgress}, nil
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (nb *natsJobBackend) Close() error {
+	return nb.conn.Drain()
+}
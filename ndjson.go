@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Formats of GET /status, picked with ?format=
+const (
+	statusFormatJSON   = "json"
+	statusFormatNDJSON = "ndjson"
+)
+
+// ndjsonFlushEvery is how many clusters are written between flushes, so
+// clients see the first clusters before the last ones are encoded
+const ndjsonFlushEvery = 100
+
+// Headers carrying what the NDJSON status stream has no document for
+const (
+	totalCountHeader    = "X-Total-Count"
+	continueTokenHeader = "X-Continue"
+)
+
+// writeClustersNDJSON streams clusters as newline delimited JSON, one
+// cluster per line, instead of buffering the full status document. The
+// count of matching clusters and the token of the next page go in headers.
+func writeClustersNDJSON(c *gin.Context, clusters []ClusterStatus, next string, total int) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-cache")
+	c.Header(totalCountHeader, strconv.Itoa(total))
+	if next != "" {
+		c.Header(continueTokenHeader, next)
+	}
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	for i, cluster := range clusters {
+		if err := encoder.Encode(cluster); err != nil {
+			requestLogger(c).Info("Stopped streaming cluster status", "error", err)
+			return
+		}
+		if (i+1)%ndjsonFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+	}
+	c.Writer.Flush()
+}
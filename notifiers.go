@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Default notification templates, rendered over the Event
+const (
+	defaultNotificationTemplate = `[{{.Type}}]{{with .ClusterName}} cluster {{.}}:{{end}} {{.Message}}{{with .JobID}} (job {{.}}){{end}}`
+	defaultNotificationSubject  = `[KubeStellar] {{.Type}}{{with .ClusterName}} {{.}}{{end}}`
+)
+
+// SMTPConfig is the mail server and recipients of an smtp event sink
+type SMTPConfig struct {
+	Host string `json:"host"`
+	// Port defaults to 587; SendMail upgrades to TLS when the server offers it
+	Port     int      `json:"port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// Subject is a template rendered over the event like the message
+	Subject string `json:"subject,omitempty"`
+}
+
+// notificationTemplates render the messages of a notifier, picking the
+// template of the event type when one is configured
+type notificationTemplates struct {
+	fallback *template.Template
+	byType   map[string]*template.Template
+}
+
+func newNotificationTemplates(fallback string, byType map[string]string) (*notificationTemplates, error) {
+	if fallback == "" {
+		fallback = defaultNotificationTemplate
+	}
+	parsed, err := template.New("message").Option("missingkey=zero").Parse(fallback)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	nt := &notificationTemplates{fallback: parsed, byType: make(map[string]*template.Template, len(byType))}
+	for eventType, text := range byType {
+		if !knownEvent(eventType) {
+			return nil, fmt.Errorf("template for unknown event %q", eventType)
+		}
+		if nt.byType[eventType], err = template.New(eventType).Option("missingkey=zero").Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid template for %s: %w", eventType, err)
+		}
+	}
+	return nt, nil
+}
+
+// render returns the message of event
+func (nt *notificationTemplates) render(event Event) (string, error) {
+	tmpl := nt.fallback
+	if byType, ok := nt.byType[event.Type]; ok {
+		tmpl = byType
+	}
+	var message strings.Builder
+	if err := tmpl.Execute(&message, event); err != nil {
+		return "", fmt.Errorf("failed to render %s notification: %w", event.Type, err)
+	}
+	return strings.TrimSpace(message.String()), nil
+}
+
+// newNotifier creates the slack, teams or smtp sink described by sc
+func newNotifier(sc EventSinkConfig) (EventSink, error) {
+	templates, err := newNotificationTemplates(sc.Template, sc.Templates)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch sc.Type {
+	case "slack":
+		if sc.URL == "" {
+			return nil, fmt.Errorf("slack sink requires a webhook url")
+		}
+		return &slackNotifier{url: sc.URL, client: client, templates: templates}, nil
+	case "teams":
+		if sc.URL == "" {
+			return nil, fmt.Errorf("teams sink requires a webhook url")
+		}
+		return &teamsNotifier{url: sc.URL, client: client, templates: templates}, nil
+	}
+
+	config := sc.SMTP
+	if config == nil || config.Host == "" || config.From == "" || len(config.To) == 0 {
+		return nil, fmt.Errorf("smtp sink requires smtp.host, smtp.from and smtp.to")
+	}
+	// The envelope takes bare addresses, the headers keep display names
+	var envelope []string
+	for _, address := range append([]string{config.From}, config.To...) {
+		parsed, err := mail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid smtp address %q: %w", address, err)
+		}
+		envelope = append(envelope, parsed.Address)
+	}
+	subject := config.Subject
+	if subject == "" {
+		subject = defaultNotificationSubject
+	}
+	subjects, err := newNotificationTemplates(subject, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp.subject: %w", err)
+	}
+	port := config.Port
+	if port == 0 {
+		port = 587
+	}
+	return &smtpNotifier{
+		config:    *config,
+		address:   net.JoinHostPort(config.Host, strconv.Itoa(port)),
+		from:      envelope[0],
+		to:        envelope[1:],
+		templates: templates,
+		subjects:  subjects,
+	}, nil
+}
+
+// postJSON posts payload to a chat webhook, failing on any non-2xx answer
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+// slackNotifier posts events to a Slack incoming webhook
+type slackNotifier struct {
+	url       string
+	client    *http.Client
+	templates *notificationTemplates
+}
+
+func (s *slackNotifier) Send(ctx context.Context, event Event) error {
+	message, err := s.templates.render(event)
+	if err != nil {
+		return err
+	}
+	if event.Warning() {
+		message = ":warning: " + message
+	}
+	return postJSON(ctx, s.client, s.url, map[string]string{"text": message})
+}
+
+func (s *slackNotifier) Close() error {
+	return nil
+}
+
+// teamsNotifier posts events to a Microsoft Teams incoming webhook as
+// message cards, red for warnings
+type teamsNotifier struct {
+	url       string
+	client    *http.Client
+	templates *notificationTemplates
+}
+
+func (s *teamsNotifier) Send(ctx context.Context, event Event) error {
+	message, err := s.templates.render(event)
+	if err != nil {
+		return err
+	}
+	color := "2EB886"
+	if event.Warning() {
+		color = "D93F0B"
+	}
+	return postJSON(ctx, s.client, s.url, map[string]string{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    event.Type,
+		"themeColor": color,
+		"title":      "KubeStellar " + event.Type,
+		"text":       message,
+	})
+}
+
+func (s *teamsNotifier) Close() error {
+	return nil
+}
+
+// sendMail delivers a message over SMTP. It is a variable so tests can
+// capture the mail instead.
+var sendMail = smtp.SendMail
+
+// smtpNotifier mails events to a fixed list of recipients
+type smtpNotifier struct {
+	config SMTPConfig
+	// address is host:port, from and to the envelope addresses
+	address   string
+	from      string
+	to        []string
+	templates *notificationTemplates
+	subjects  *notificationTemplates
+}
+
+func (s *smtpNotifier) Send(_ context.Context, event Event) error {
+	message, err := s.templates.render(event)
+	if err != nil {
+		return err
+	}
+	subject, err := s.subjects.render(event)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.config.To, ", "))
+	// A header value is a single line, encoded when it isn't ASCII
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject), " ")))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(message, "\n", "\r\n") + "\r\n")
+
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+	if err := sendMail(s.address, auth, s.from, s.to, msg.Bytes()); err != nil {
+		return fmt.Errorf("failed to send mail through %s: %w", s.address, err)
+	}
+	return nil
+}
+
+func (s *smtpNotifier) Close() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+// chatWebhook records the JSON payloads posted to it
+func chatWebhook(t *testing.T) (*httptest.Server, *[]map[string]string) {
+	t.Helper()
+	var payloads []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payloads = append(payloads, payload)
+	}))
+	t.Cleanup(server.Close)
+	return server, &payloads
+}
+
+func TestChatNotifiers(t *testing.T) {
+	failed := Event{Type: eventClusterFailed, ClusterName: "edge-1", JobID: "onboard-1", Message: "join timed out",
+		Attributes: map[string]string{"step": "Joining"}}
+	onboarded := Event{Type: eventClusterOnboarded, ClusterName: "edge-2", Message: "Cluster onboarded"}
+
+	server, payloads := chatWebhook(t)
+	slack, err := newEventSink(EventSinkConfig{Type: "slack", URL: server.URL, Templates: map[string]string{
+		eventClusterFailed: "Onboarding of {{.ClusterName}} failed at {{.Attributes.step}}: {{.Message}}",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []Event{failed, onboarded} {
+		if err := slack.Send(context.Background(), event); err != nil {
+			t.Fatalf("Send(%s) error = %v", event.Type, err)
+		}
+	}
+	if got := (*payloads)[0]["text"]; got != ":warning: Onboarding of edge-1 failed at Joining: join timed out" {
+		t.Errorf("slack failure text = %q", got)
+	}
+	if got := (*payloads)[1]["text"]; got != "[cluster.onboarded] cluster edge-2: Cluster onboarded" {
+		t.Errorf("slack default text = %q", got)
+	}
+
+	server, payloads = chatWebhook(t)
+	teams, err := newEventSink(EventSinkConfig{Type: "teams", URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := teams.Send(context.Background(), failed); err != nil {
+		t.Fatal(err)
+	}
+	card := (*payloads)[0]
+	if card["@type"] != "MessageCard" || card["themeColor"] != "D93F0B" || card["text"] != "[cluster.failed] cluster edge-1: join timed out (job onboard-1)" {
+		t.Errorf("teams card = %v", card)
+	}
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer rejecting.Close()
+	slack, _ = newEventSink(EventSinkConfig{Type: "slack", URL: rejecting.URL})
+	if err := slack.Send(context.Background(), failed); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Send() to a rejecting webhook error = %v", err)
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	type sent struct {
+		addr, from string
+		to         []string
+		msg        string
+		auth       bool
+	}
+	var mails []sent
+	original := sendMail
+	t.Cleanup(func() { sendMail = original })
+	sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		mails = append(mails, sent{addr, from, to, string(msg), auth != nil})
+		return nil
+	}
+
+	sink, err := newEventSink(EventSinkConfig{Type: "smtp", Types: []string{eventClusterUnreachable}, SMTP: &SMTPConfig{
+		Host:     "mail.example.com",
+		Username: "plugin",
+		Password: "secret",
+		From:     "KubeStellar <plugin@example.com>",
+		To:       []string{"oncall@example.com"},
+		Subject:  "{{.ClusterName}} is unreachable",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), Event{Type: eventClusterUnreachable, ClusterName: "edge-1", Message: "Lease not renewed for 5m"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(mails) != 1 {
+		t.Fatalf("sent %d mails", len(mails))
+	}
+	mail := mails[0]
+	if mail.addr != "mail.example.com:587" || mail.from != "plugin@example.com" || !mail.auth || len(mail.to) != 1 {
+		t.Errorf("mail envelope = %+v", mail)
+	}
+	for _, want := range []string{"From: KubeStellar <plugin@example.com>\r\n", "Subject: edge-1 is unreachable\r\n",
+		"\r\n\r\n[cluster.unreachable] cluster edge-1: Lease not renewed for 5m\r\n"} {
+		if !strings.Contains(mail.msg, want) {
+			t.Errorf("mail lacks %q:\n%s", want, mail.msg)
+		}
+	}
+}
+
+func TestNewNotifierValidation(t *testing.T) {
+	for name, config := range map[string]EventSinkConfig{
+		"slack without url":       {Type: "slack"},
+		"teams without url":       {Type: "teams"},
+		"smtp without recipients": {Type: "smtp", SMTP: &SMTPConfig{Host: "mail.example.com", From: "plugin@example.com"}},
+		"smtp bad address":        {Type: "smtp", SMTP: &SMTPConfig{Host: "mail.example.com", From: "plugin", To: []string{"oncall@example.com"}}},
+		"bad template":            {Type: "slack", URL: "https://hooks.slack.com/x", Template: "{{.Message"},
+		"unknown event template":  {Type: "slack", URL: "https://hooks.slack.com/x", Templates: map[string]string{"cluster.exploded": "boom"}},
+	} {
+		if _, err := newEventSink(config); err == nil {
+			t.Errorf("%s: newEventSink() accepted", name)
+		}
+	}
+}
@@ -0,0 +1,836 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// endpointDoc describes the typed request and responses of a handler. A
+// response may be a struct value, a gin.H whose values show each field's type,
+// or nil when it has no body.
+// contentType overrides the JSON media type of successful responses, and
+// upgrade marks a WebSocket endpoint answering with 101 Switching Protocols.
+type endpointDoc struct {
+	request     interface{}
+	responses   map[int]interface{}
+	queryParams []queryParam
+	contentType string
+	upgrade     bool
+}
+
+// queryParam is a query string parameter and its JSON schema type
+type queryParam struct {
+	name       string
+	schemaType string
+}
+
+// problemDoc documents a problem carrying extension members, each given by
+// an example value like a gin.H response
+type problemDoc gin.H
+
+// queueFullResponse is returned when a job can't be admitted to the worker pool
+var queueFullResponse = problemDoc{"queuePosition": 0, "pool": PoolStats{}}
+
+// clusterExistsResponse is returned when the cluster to onboard is already known
+var clusterExistsResponse = problemDoc{"jobId": "", "cluster": ClusterStatus{}}
+
+// policyViolationResponse is returned when an onboarding breaks the onboarding policy
+var policyViolationResponse = problemDoc{"violations": []PolicyViolation{}}
+
+// admissionDeniedResponse is returned when the OPA admission policy denies a request
+var admissionDeniedResponse = problemDoc{"reasons": []string{}}
+
+// faultsResponseDoc is returned by the /debug/faults endpoints
+var faultsResponseDoc = gin.H{
+	"faults": FaultInjection{}, "stats": FaultStats{}, "setAt": "", "expiresAt": "", "plugin": "", "timestamp": "",
+}
+
+// reconcileStatusDoc is returned by the /reconcile endpoints
+var reconcileStatusDoc = gin.H{
+	"message": "", "messageCode": "", "active": false, "passes": 0, "desired": DesiredMembership{}, "setAt": "",
+	"lastPass": ReconcileReport{}, "commits": []CommitSync{}, "plugin": "", "timestamp": "",
+}
+
+// endpointDocs documents each handler listed in the metadata
+var endpointDocs = map[string]endpointDoc{
+	"GetClusterStatusHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:          ClusterStatusResponse{},
+			http.StatusNotModified: nil,
+		},
+		queryParams: []queryParam{
+			{"status", "string"}, {"hub", "string"}, {"labelSelector", "string"}, {"sort", "string"},
+			{"limit", "integer"}, {"continue", "string"}, {"refresh", "boolean"}, {"format", "string"},
+		},
+	},
+	"StreamClusterStatusHandler": {
+		responses:   map[int]interface{}{http.StatusOK: StatusEvent{}},
+		contentType: "text/event-stream",
+	},
+	"OnboardClusterHandler": {
+		request: OnboardRequest{},
+		responses: map[int]interface{}{
+			http.StatusOK:                    OnboardResponse{},
+			http.StatusAccepted:              gin.H{"message": "", "messageCode": "", "schedule": Schedule{}, "plugin": "", "timestamp": ""},
+			http.StatusForbidden:             admissionDeniedResponse,
+			http.StatusConflict:              clusterExistsResponse,
+			http.StatusRequestEntityTooLarge: Problem{},
+			// contexts for an ambiguous upload, violations for a policy violation
+			http.StatusUnprocessableEntity: problemDoc{"contexts": []UploadedContext{}, "violations": []PolicyViolation{}},
+			http.StatusServiceUnavailable:  queueFullResponse,
+		},
+		queryParams: []queryParam{{"name", "string"}, {"hub", "string"}, {"dryRun", "boolean"}},
+	},
+	"StreamOnboardingLogsHandler": {
+		responses: map[int]interface{}{http.StatusSwitchingProtocols: LogEntry{}},
+		upgrade:   true,
+	},
+	"BatchOnboardHandler": {
+		request: BatchOnboardRequest{},
+		responses: map[int]interface{}{http.StatusAccepted: gin.H{
+			"message": "", "messageCode": "", "batchId": "", "items": []BatchItem{}, "plugin": "", "timestamp": "",
+		}},
+	},
+	"GetBatchHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"batch": Batch{}, "state": "", "summary": map[string]int{}, "plugin": "", "timestamp": "",
+		}},
+	},
+	"ImportClustersHandler": {
+		responses: map[int]interface{}{
+			http.StatusAccepted:              gin.H{"message": "", "messageCode": "", "importId": "", "items": []BatchItem{}, "plugin": "", "timestamp": ""},
+			http.StatusRequestEntityTooLarge: Problem{},
+			http.StatusUnsupportedMediaType:  Problem{},
+		},
+		queryParams: []queryParam{{"format", "string"}, {"dryRun", "boolean"}},
+	},
+	"GetImportReportHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"import": Batch{}, "state": "", "summary": map[string]int{}, "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"format", "string"}},
+	},
+	"ImportTerraformHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:                    gin.H{"message": "", "messageCode": "", "clusters": []DiscoveredCluster{}, "plugin": "", "timestamp": ""},
+			http.StatusAccepted:              gin.H{"message": "", "messageCode": "", "clusters": []DiscoveredCluster{}, "importId": "", "items": []BatchItem{}, "plugin": "", "timestamp": ""},
+			http.StatusRequestEntityTooLarge: Problem{},
+			http.StatusBadGateway:            Problem{},
+		},
+		request: TerraformImportRequest{},
+	},
+	"ListDiscoveredHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"clusters": []CloudCluster{}, "accounts": []AccountScan{}, "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"refresh", "boolean"}, {"provider", "string"}, {"account", "string"}},
+	},
+	"OnboardDiscoveredHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:                  OnboardResponse{},
+			http.StatusForbidden:           admissionDeniedResponse,
+			http.StatusUnprocessableEntity: policyViolationResponse,
+			http.StatusBadGateway:          Problem{},
+		},
+		request: DiscoveredOnboardRequest{},
+	},
+	"ExportClustersHandler": {
+		responses: map[int]interface{}{http.StatusOK: Inventory{}},
+		queryParams: []queryParam{
+			{"format", "string"}, {"status", "string"}, {"hub", "string"}, {"labelSelector", "string"}, {"refresh", "boolean"},
+		},
+	},
+	"SearchClustersHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"clusters": []ClusterStatus{}, "total": 0, "continue": "", "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"q", "string"}, {"limit", "integer"}, {"continue", "string"}},
+	},
+	"GetClusterVersionsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"hubs": []HubVersions{}, "clusters": []ClusterVersions{}, "summary": VersionSummary{},
+			"constraint": "", "skew": VersionSkewConfig{}, "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"hub", "string"}},
+	},
+	"GetClusterHistoryHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"clusterName": "", "timeline": HistoryTimeline{}, "availability": Availability{}, "entries": []HistoryEntry{},
+			"retention": gin.H{"days": 0, "maxEntries": 0}, "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"since", "string"}, {"until", "string"}},
+	},
+	"DetachClusterHandler": {
+		request: DetachRequest{},
+		responses: map[int]interface{}{
+			http.StatusOK:                 DetachResponse{},
+			http.StatusAccepted:           gin.H{"message": "", "messageCode": "", "schedule": Schedule{}, "approval": Approval{}, "plugin": "", "timestamp": ""},
+			http.StatusForbidden:          admissionDeniedResponse,
+			http.StatusConflict:           Problem{},
+			http.StatusServiceUnavailable: queueFullResponse,
+		},
+	},
+	"ListApprovalsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"approvals": []Approval{}, "total": 0, "policy": "", "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"state", "string"}, {"cluster", "string"}},
+	},
+	"ApproveHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:        DetachResponse{},
+			http.StatusForbidden: Problem{},
+			http.StatusNotFound:  Problem{},
+			http.StatusConflict:  Problem{},
+		},
+	},
+	"ListJobsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"jobs": []Job{}, "total": 0, "pool": PoolStats{}, "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"cluster", "string"}, {"type", "string"}, {"state", "string"}},
+	},
+	"GetJobHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{"job": Job{}, "plugin": "", "timestamp": ""}},
+	},
+	"GetJobCheckpointsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{"checkpoint": JobCheckpoint{}, "lastStep": "", "plugin": "", "timestamp": ""}},
+	},
+	"CancelJobHandler": {
+		responses: map[int]interface{}{http.StatusAccepted: gin.H{
+			"message": "", "messageCode": "", "jobId": "", "plugin": "", "timestamp": "",
+		}},
+	},
+	"ListSchedulesHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"schedules": []Schedule{}, "total": 0, "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"cluster", "string"}, {"type", "string"}, {"state", "string"}},
+	},
+	"CancelScheduleHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:       gin.H{"message": "", "messageCode": "", "schedule": Schedule{}, "plugin": "", "timestamp": ""},
+			http.StatusNotFound: Problem{},
+			http.StatusConflict: Problem{},
+		},
+	},
+	"GetPreflightHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:                 gin.H{"preflight": PreflightReport{}, "plugin": "", "timestamp": ""},
+			http.StatusServiceUnavailable: gin.H{"preflight": PreflightReport{}, "plugin": "", "timestamp": ""},
+		},
+		queryParams: []queryParam{{"refresh", "boolean"}},
+	},
+	"UploadKubeconfigHandler": {
+		request: KubeconfigUploadRequest{},
+		responses: map[int]interface{}{http.StatusCreated: gin.H{
+			"message": "", "messageCode": "", "contexts": []StoredContext{}, "plugin": "", "timestamp": "",
+		}},
+	},
+	"ListKubeconfigContextsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"contexts": []StoredContext{}, "plugin": "", "timestamp": "",
+		}},
+	},
+	"DeleteKubeconfigContextHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{"message": "", "messageCode": "", "plugin": "", "timestamp": ""}},
+	},
+	"PatchClusterLabelsHandler": {
+		request: LabelsPatchRequest{},
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"cluster": ClusterStatus{}, "hubSynced": false, "hubError": "", "plugin": "", "timestamp": "",
+		}},
+	},
+	"PatchClusterHandler": {
+		request: ClusterPatchRequest{},
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"cluster": ClusterStatus{}, "hubSynced": false, "hubError": "", "plugin": "", "timestamp": "",
+		}},
+	},
+	"ListAddonsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"addons": []AvailableAddon{}, "errors": []string{}, "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"hub", "string"}},
+	},
+	"BulkAddonHandler": {
+		request: AddonBulkRequest{},
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"addon": "", "action": "", "results": []AddonResult{}, "summary": map[string]int{}, "plugin": "", "timestamp": "",
+		}},
+	},
+	"GetClusterAddonsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"clusterName": "", "hub": "", "addons": []ClusterAddon{}, "plugin": "", "timestamp": "",
+		}},
+	},
+	"EnableClusterAddonHandler": {
+		request:   EnableAddonRequest{},
+		responses: map[int]interface{}{http.StatusOK: gin.H{"result": AddonResult{}, "plugin": "", "timestamp": ""}},
+	},
+	"DisableClusterAddonHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{"result": AddonResult{}, "plugin": "", "timestamp": ""}},
+	},
+	"ListWebhooksHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"webhooks": []Webhook{}, "pending": 0, "plugin": "", "timestamp": "",
+		}},
+	},
+	"CreateWebhookHandler": {
+		request: Webhook{},
+		responses: map[int]interface{}{http.StatusCreated: gin.H{
+			"webhook": Webhook{}, "plugin": "", "timestamp": "",
+		}},
+	},
+	"DeleteWebhookHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{"message": "", "messageCode": "", "plugin": "", "timestamp": ""}},
+	},
+	"ListHubsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"hubs": []Hub{}, "default": "", "plugin": "", "timestamp": "",
+		}},
+	},
+	"RegisterHubHandler": {
+		request: HubRegisterRequest{},
+		responses: map[int]interface{}{
+			http.StatusCreated:  gin.H{"hub": Hub{}, "plugin": "", "timestamp": ""},
+			http.StatusConflict: Problem{},
+		},
+	},
+	"SelectHubHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:       gin.H{"hub": Hub{}, "plugin": "", "timestamp": ""},
+			http.StatusNotFound: Problem{},
+		},
+	},
+	"DeleteHubHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:       gin.H{"message": "", "messageCode": "", "plugin": "", "timestamp": ""},
+			http.StatusNotFound: Problem{},
+			http.StatusConflict: Problem{},
+		},
+	},
+	"ListGroupsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"groups": []GroupSummary{}, "total": 0, "environments": []string{}, "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"environment", "string"}},
+	},
+	"CreateGroupHandler": {
+		request: GroupRequest{},
+		responses: map[int]interface{}{
+			http.StatusCreated:  gin.H{"message": "", "messageCode": "", "group": ClusterGroup{}, "plugin": "", "timestamp": ""},
+			http.StatusConflict: Problem{},
+		},
+	},
+	"GetGroupHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK: gin.H{
+				"group": ClusterGroup{}, "clusters": []ClusterStatus{}, "summary": map[string]int{}, "plugin": "", "timestamp": "",
+			},
+			http.StatusNotFound: Problem{},
+		},
+	},
+	"UpdateGroupHandler": {
+		request: GroupRequest{},
+		responses: map[int]interface{}{
+			http.StatusOK:       gin.H{"message": "", "messageCode": "", "group": ClusterGroup{}, "plugin": "", "timestamp": ""},
+			http.StatusNotFound: Problem{},
+		},
+	},
+	"DeleteGroupHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:       gin.H{"message": "", "messageCode": "", "plugin": "", "timestamp": ""},
+			http.StatusNotFound: Problem{},
+		},
+	},
+	"ProvisionClusterHandler": {
+		request: ProvisionRequest{},
+		responses: map[int]interface{}{
+			http.StatusAccepted:            OnboardResponse{},
+			http.StatusForbidden:           admissionDeniedResponse,
+			http.StatusConflict:            clusterExistsResponse,
+			http.StatusUnprocessableEntity: policyViolationResponse,
+			http.StatusServiceUnavailable:  queueFullResponse,
+		},
+	},
+	"DeprovisionClusterHandler": {
+		responses: map[int]interface{}{
+			http.StatusAccepted:           DetachResponse{},
+			http.StatusServiceUnavailable: queueFullResponse,
+		},
+	},
+	"GetHealthDetailsHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:                 gin.H{"health": HealthReport{}, "plugin": "", "timestamp": ""},
+			http.StatusServiceUnavailable: gin.H{"health": HealthReport{}, "plugin": "", "timestamp": ""},
+		},
+	},
+	"GetAnalyticsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"window":         gin.H{"since": "", "until": "", "interval": ""},
+			"onboarding":     OnboardingStats{},
+			"timeToReady":    TimeToReady{},
+			"failureReasons": []FailureReason{},
+			"availability":   FleetAvailability{},
+			"clusters":       FleetBreakdown{},
+			"plugin":         "",
+			"timestamp":      "",
+		}},
+		queryParams: []queryParam{{"since", "string"}, {"until", "string"}, {"interval", "string"}},
+	},
+	"GetSettingsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"settings": Settings{}, "readOnly": false, "plugin": "", "timestamp": "",
+		}},
+	},
+	"GetEffectiveSettingsHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"config": PluginConfig{}, "sources": map[string]string{}, "sections": []string{},
+			"envPrefix": "", "plugin": "", "timestamp": "",
+		}},
+	},
+	"UpdateSettingsHandler": {
+		request: SettingsRequest{},
+		responses: map[int]interface{}{
+			http.StatusOK: gin.H{
+				"settings": Settings{}, "changes": []SettingChange{}, "readOnly": false, "plugin": "", "timestamp": "",
+			},
+			http.StatusForbidden: Problem{},
+			http.StatusConflict:  Problem{},
+		},
+	},
+	"GetSLOHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:       gin.H{"slos": []SLOStatus{}, "plugin": "", "timestamp": ""},
+			http.StatusNotFound: Problem{},
+		},
+		queryParams: []queryParam{{"slo", "string"}, {"cluster", "string"}},
+	},
+	"ExportCostsHandler": {
+		responses: map[int]interface{}{http.StatusOK: CostReport{}},
+		queryParams: []queryParam{
+			{"since", "string"}, {"until", "string"}, {"groupBy", "string"}, {"format", "string"},
+			{"status", "string"}, {"hub", "string"}, {"labelSelector", "string"}, {"refresh", "boolean"},
+		},
+	},
+	"GetCapabilitiesHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"capabilities": Capabilities{}, "plugin": "", "timestamp": "",
+		}},
+	},
+	"SetDesiredMembershipHandler": {
+		request: DesiredMembership{},
+		responses: map[int]interface{}{
+			http.StatusAccepted:   reconcileStatusDoc,
+			http.StatusBadRequest: Problem{},
+		},
+	},
+	"ClearDesiredMembershipHandler": {
+		responses: map[int]interface{}{http.StatusOK: reconcileStatusDoc},
+	},
+	"GetReconcileStatusHandler": {
+		responses: map[int]interface{}{http.StatusOK: reconcileStatusDoc},
+	},
+	"GitWebhookHandler": {
+		responses: map[int]interface{}{
+			http.StatusAccepted:     gin.H{"message": "", "messageCode": "", "plugin": "", "timestamp": ""},
+			http.StatusOK:           gin.H{"message": "", "messageCode": "", "plugin": "", "timestamp": ""},
+			http.StatusUnauthorized: Problem{},
+			http.StatusConflict:     Problem{},
+		},
+	},
+	"LoadScenarioHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK: gin.H{
+				"message": "", "messageCode": "", "scenario": Scenario{}, "startedAt": "", "plugin": "", "timestamp": "",
+			},
+			http.StatusConflict:              Problem{},
+			http.StatusRequestEntityTooLarge: Problem{},
+		},
+		request: Scenario{},
+	},
+	"GetScenarioHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK: gin.H{
+				"scenario": Scenario{}, "startedAt": "", "elapsedSeconds": 0, "applied": 0,
+				"pending": []PendingTransition{}, "plugin": "", "timestamp": "",
+			},
+			http.StatusConflict: Problem{},
+		},
+	},
+	"GetFaultsHandler": {
+		responses: map[int]interface{}{http.StatusOK: faultsResponseDoc, http.StatusNotFound: Problem{}},
+	},
+	"SetFaultsHandler": {
+		responses: map[int]interface{}{http.StatusOK: faultsResponseDoc, http.StatusNotFound: Problem{}},
+		request:   FaultInjection{},
+	},
+	"ClearFaultsHandler": {
+		responses: map[int]interface{}{http.StatusOK: faultsResponseDoc, http.StatusNotFound: Problem{}},
+	},
+	"GetPprofIndexHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:       gin.H{"profiles": []ProfileInfo{}, "plugin": "", "timestamp": ""},
+			http.StatusNotFound: Problem{},
+		},
+	},
+	"GetPprofProfileHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:       nil,
+			http.StatusNotFound: Problem{},
+			http.StatusConflict: Problem{},
+		},
+		queryParams: []queryParam{{"seconds", "integer"}, {"debug", "integer"}, {"gc", "boolean"}},
+		contentType: "application/octet-stream",
+	},
+	"GetDebugVarsHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK: gin.H{
+				"cmdline": []string{}, "memstats": gin.H{}, "goroutines": 0,
+				"jobs": gin.H{"active": 0, "pool": PoolStats{}}, "clusters": 0,
+			},
+			http.StatusNotFound: Problem{},
+		},
+	},
+	"ListAuditHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"entries": []AuditEntry{}, "total": 0, "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"cluster", "string"}, {"subject", "string"}, {"since", "string"}, {"until", "string"}, {"limit", "integer"}},
+	},
+	"GetClusterDetailHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:         gin.H{"cluster": ClusterDetail{}, "plugin": "", "timestamp": ""},
+			http.StatusBadRequest: Problem{},
+			http.StatusNotFound:   Problem{},
+			http.StatusBadGateway: Problem{},
+		},
+		queryParams: []queryParam{{"hub", "string"}},
+	},
+	"RepairClusterHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK: gin.H{
+				"message": "", "messageCode": "", "diagnosis": RepairDiagnosis{}, "plugin": "", "timestamp": "",
+			},
+			http.StatusAccepted:            RepairResponse{},
+			http.StatusNotFound:            Problem{},
+			http.StatusConflict:            Problem{},
+			http.StatusUnprocessableEntity: problemDoc{"diagnosis": RepairDiagnosis{}},
+			http.StatusBadGateway:          Problem{},
+			http.StatusServiceUnavailable:  queueFullResponse,
+		},
+		queryParams: []queryParam{{"dryRun", "boolean"}},
+	},
+	"GetClusterTokenHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:       gin.H{"token": IssuedToken{}, "plugin": "", "timestamp": ""},
+			http.StatusNotFound: Problem{},
+		},
+	},
+	"RotateClusterTokenHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK:         TokenRotateResponse{},
+			http.StatusNotFound:   Problem{},
+			http.StatusConflict:   Problem{},
+			http.StatusBadGateway: Problem{},
+		},
+	},
+	"VerifyClusterHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK: gin.H{
+				"clusterName": "", "verification": VerificationReport{}, "plugin": "", "timestamp": "",
+			},
+			http.StatusNotFound: Problem{},
+		},
+		queryParams: []queryParam{{"refresh", "boolean"}},
+	},
+	"ListTemplatesHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{
+			"templates": []ManifestTemplate{}, "values": ManifestValues{}, "plugin": "", "timestamp": "",
+		}},
+		queryParams: []queryParam{{"cluster", "string"}},
+	},
+	"GetOpenAPIHandler": {
+		responses: map[int]interface{}{http.StatusOK: gin.H{}},
+	},
+	"ListSchemasHandler": {
+		responses: map[int]interface{}{
+			http.StatusOK: gin.H{"schemas": map[string]*JSONSchema{}, "endpoints": []SchemaUsage{}, "plugin": "", "timestamp": ""},
+		},
+	},
+}
+
+var pathParamPattern = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// openAPIBuilder collects component schemas while walking Go types
+type openAPIBuilder struct {
+	schemas map[string]interface{}
+}
+
+// buildOpenAPI generates an OpenAPI 3.0 document for the metadata endpoints;
+// bearerAuth documents that callers need a bearer token
+func buildOpenAPI(metadata PluginMetadata, permissions permissionPolicy, bearerAuth bool) gin.H {
+	builder := &openAPIBuilder{schemas: map[string]interface{}{}}
+	builder.schemaFor(reflect.TypeOf(Problem{}))
+
+	paths := gin.H{}
+	for _, endpoint := range metadata.Endpoints {
+		path := pathParamPattern.ReplaceAllString(endpoint.Path, "{$1}")
+		item, ok := paths[path].(gin.H)
+		if !ok {
+			item = gin.H{}
+			paths[path] = item
+		}
+		item[strings.ToLower(endpoint.Method)] = builder.operation(endpoint)
+	}
+
+	document := gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":       metadata.Name,
+			"version":     metadata.Version,
+			"description": metadata.Description,
+		},
+		"paths": paths,
+		"components": gin.H{
+			"schemas": builder.schemas,
+		},
+	}
+
+	schemes, requirement := gin.H{}, gin.H{}
+	if permissions.enabled {
+		schemes["hostToken"] = gin.H{"type": "apiKey", "in": "header", "name": permissions.tokenHeader}
+		requirement["hostToken"] = []string{}
+	}
+	if bearerAuth {
+		schemes["bearerAuth"] = gin.H{"type": "http", "scheme": "bearer"}
+		requirement["bearerAuth"] = []string{}
+	}
+	if len(schemes) > 0 {
+		document["components"].(gin.H)["securitySchemes"] = schemes
+		document["security"] = []gin.H{requirement}
+	}
+	return document
+}
+
+func (b *openAPIBuilder) operation(endpoint EndpointConfig) gin.H {
+	doc := endpointDocs[endpoint.Handler]
+
+	// The routes of each API version share handlers, so the version keeps
+	// operation IDs unique
+	operationID := strings.TrimSuffix(endpoint.Handler, "Handler")
+	if endpoint.Version != "" {
+		operationID += strings.ToUpper(endpoint.Version[:1]) + endpoint.Version[1:]
+	}
+	operation := gin.H{
+		"operationId": operationID,
+		"summary":     endpoint.Description,
+	}
+	if endpoint.Deprecation != "" {
+		operation["deprecated"] = true
+	}
+	if endpoint.Permission != "" {
+		operation["x-permission"] = endpoint.Permission
+	}
+
+	var parameters []gin.H
+	for _, match := range pathParamPattern.FindAllStringSubmatch(endpoint.Path, -1) {
+		parameters = append(parameters, gin.H{
+			"name": match[1], "in": "path", "required": true, "schema": gin.H{"type": "string"},
+		})
+	}
+	for _, param := range doc.queryParams {
+		parameters = append(parameters, gin.H{
+			"name": param.name, "in": "query", "schema": gin.H{"type": param.schemaType},
+		})
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if doc.request != nil {
+		operation["requestBody"] = gin.H{
+			"required": true,
+			"content": gin.H{
+				"application/json": gin.H{"schema": b.schemaForValue(doc.request)},
+			},
+		}
+	}
+
+	responses := gin.H{
+		"default": gin.H{
+			"description": "Error",
+			"content": gin.H{
+				"application/json": gin.H{"schema": b.schemaFor(reflect.TypeOf(Problem{}))},
+			},
+		},
+	}
+	contentType := doc.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	for status, body := range doc.responses {
+		response := gin.H{"description": http.StatusText(status)}
+		switch {
+		case body == nil:
+			// Such as 304 Not Modified, which has no body
+		case isProblemDoc(body):
+			response["content"] = gin.H{
+				problemContentType: gin.H{"schema": b.problemSchema(body)},
+			}
+		case doc.upgrade:
+			// The schema describes the WebSocket messages sent after the upgrade
+			response["x-websocket-message"] = b.schemaForValue(body)
+		default:
+			response["content"] = gin.H{
+				contentType: gin.H{"schema": b.schemaForValue(body)},
+			}
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+// isProblemDoc reports whether a documented response is a problem
+func isProblemDoc(body interface{}) bool {
+	switch body.(type) {
+	case Problem, problemDoc:
+		return true
+	}
+	return false
+}
+
+// problemSchema returns the schema of a problem, with its extensions if any.
+// OpenAPI 3.0 ignores siblings of $ref, so extensions are combined with allOf.
+func (b *openAPIBuilder) problemSchema(body interface{}) gin.H {
+	schema := b.schemaFor(reflect.TypeOf(Problem{}))
+	if extensions, ok := body.(problemDoc); ok {
+		return gin.H{"allOf": []gin.H{schema, b.schemaForValue(gin.H(extensions))}}
+	}
+	return schema
+}
+
+// schemaForValue returns the schema of a struct value or of a gin.H example
+func (b *openAPIBuilder) schemaForValue(value interface{}) gin.H {
+	if example, ok := value.(gin.H); ok {
+		properties := gin.H{}
+		for name, field := range example {
+			properties[name] = b.schemaFor(reflect.TypeOf(field))
+		}
+		return gin.H{"type": "object", "properties": properties}
+	}
+	return b.schemaFor(reflect.TypeOf(value))
+}
+
+// schemaFor maps a Go type to a JSON schema, registering named structs as components
+func (b *openAPIBuilder) schemaFor(t reflect.Type) gin.H {
+	if t == nil {
+		return gin.H{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schemaFor(t.Elem())
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return gin.H{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		values := b.schemaFor(t.Elem())
+		if t.Elem().Kind() == reflect.Ptr {
+			values = nullable(values)
+		}
+		return gin.H{"type": "object", "additionalProperties": values}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		ref := gin.H{"$ref": "#/components/schemas/" + t.Name()}
+		if _, exists := b.schemas[t.Name()]; !exists {
+			// Register before recursing so self-referencing types terminate
+			b.schemas[t.Name()] = gin.H{}
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return ref
+	default:
+		return gin.H{}
+	}
+}
+
+// nullable marks a schema as accepting null, wrapping references since
+// OpenAPI 3.0 ignores siblings of $ref
+func nullable(schema gin.H) gin.H {
+	if _, isRef := schema["$ref"]; isRef {
+		return gin.H{"allOf": []gin.H{schema}, "nullable": true}
+	}
+	schema["nullable"] = true
+	return schema
+}
+
+func (b *openAPIBuilder) structSchema(t reflect.Type) gin.H {
+	properties := gin.H{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, omitempty := jsonFieldName(field)
+		if name == "-" {
+			continue
+		}
+		properties[name] = b.schemaFor(field.Type)
+		if strings.Contains(field.Tag.Get("binding"), "required") ||
+			(!omitempty && field.Type.Kind() != reflect.Ptr && field.Type.Kind() != reflect.Bool) {
+			required = append(required, name)
+		}
+	}
+
+	schema := gin.H{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		return field.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+// GetOpenAPIHandler serves the OpenAPI document describing the plugin endpoints
+func (cp *ClusterPlugin) GetOpenAPIHandler(c *gin.Context) {
+	cp.mutex.RLock()
+	permissions := cp.permissions
+	bearerAuth := cp.auth != nil
+	cp.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, buildOpenAPI(cp.GetMetadata(), permissions, bearerAuth))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// collectRefs gathers every $ref in a decoded JSON document
+func collectRefs(value interface{}, refs map[string]bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if ref, ok := child.(string); ok && key == "$ref" {
+				refs[ref] = true
+			}
+			collectRefs(child, refs)
+		}
+	case []interface{}:
+		for _, child := range v {
+			collectRefs(child, refs)
+		}
+	}
+}
+
+func TestBuildOpenAPI(t *testing.T) {
+	metadata := defaultMetadata()
+	encoded, err := json.Marshal(buildOpenAPI(metadata, permissionPolicy{enabled: true, tokenHeader: defaultHostTokenHeader}, true))
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var document struct {
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas         map[string]interface{} `json:"schemas"`
+			SecuritySchemes map[string]interface{} `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	for _, endpoint := range metadata.Endpoints {
+		doc, documented := endpointDocs[endpoint.Handler]
+		if !documented {
+			t.Errorf("%s has no entry in endpointDocs", endpoint.Handler)
+		}
+		path := pathParamPattern.ReplaceAllString(endpoint.Path, "{$1}")
+		operation, ok := document.Paths[path][strings.ToLower(endpoint.Method)]
+		if !ok {
+			t.Errorf("%s %s is missing from the document", endpoint.Method, path)
+			continue
+		}
+		if _, hasBody := operation["requestBody"]; hasBody != (doc.request != nil) {
+			t.Errorf("%s %s requestBody present = %v, want %v", endpoint.Method, path, hasBody, doc.request != nil)
+		}
+		responses, _ := operation["responses"].(map[string]interface{})
+		if len(responses) != len(doc.responses)+1 {
+			t.Errorf("%s %s responses = %v, want the %d documented and a default", endpoint.Method, path, responses, len(doc.responses))
+		}
+	}
+
+	refs := map[string]bool{}
+	collectRefs(document.Paths, refs)
+	collectRefs(document.Components.Schemas, refs)
+	if len(refs) == 0 {
+		t.Fatal("document has no schema references")
+	}
+	for ref := range refs {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		if schema, ok := document.Components.Schemas[name].(map[string]interface{}); !ok || schema["type"] != "object" {
+			t.Errorf("%s does not resolve to an object schema", ref)
+		}
+	}
+
+	for _, scheme := range []string{"hostToken", "bearerAuth"} {
+		if _, ok := document.Components.SecuritySchemes[scheme]; !ok {
+			t.Errorf("security scheme %s is missing", scheme)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHostTokenHeader   = "X-KubeStellar-Plugin-Token"
+	defaultPermissionsHeader = "X-KubeStellar-Permissions"
+)
+
+// permissionPolicy holds the host-provided settings used to enforce endpoint permissions
+type permissionPolicy struct {
+	enabled           bool
+	hostToken         string
+	tokenHeader       string
+	permissionsHeader string
+}
+
+func newPermissionPolicy(config map[string]interface{}) permissionPolicy {
+	return permissionPolicy{
+		enabled:           configBool(config, "enforcePermissions", false),
+		hostToken:         configString(config, "hostToken", ""),
+		tokenHeader:       configString(config, "hostTokenHeader", defaultHostTokenHeader),
+		permissionsHeader: configString(config, "permissionsHeader", defaultPermissionsHeader),
+	}
+}
+
+// checkEndpoints makes sure that, when enforcement is on, every endpoint
+// declares a permission so none is served without authentication
+func (p permissionPolicy) checkEndpoints(metadata PluginMetadata) error {
+	if !p.enabled {
+		return nil
+	}
+	var missing []string
+	for _, endpoint := range metadata.Endpoints {
+		if endpoint.Permission == "" {
+			missing = append(missing, fmt.Sprintf("%s %s", endpoint.Method, endpoint.Path))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("enforcePermissions requires a permission on every endpoint, missing on: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// requiredPermission returns the permission declared for the endpoint served by handlerName
+func (cp *ClusterPlugin) requiredPermission(handlerName string) string {
+	for _, endpoint := range cp.GetMetadata().Endpoints {
+		if endpoint.Handler == handlerName {
+			return endpoint.Permission
+		}
+	}
+	return ""
+}
+
+// withPermission wraps a handler so that it only runs when the caller holds the
+// permission its endpoint declares. The host authenticates itself with a shared
+// token and passes the caller's granted permissions in a header.
+func (cp *ClusterPlugin) withPermission(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		policy := cp.permissions
+		cp.mutex.RUnlock()
+
+		if !policy.enabled {
+			handler(c)
+			return
+		}
+
+		// Fail closed: an endpoint without a declared permission is never served
+		required := cp.requiredPermission(handlerName)
+		if required == "" {
+			requestLogger(c).Warn("Denied request, endpoint declares no permission", "method", c.Request.Method, "path", c.Request.URL.Path)
+			abortWithProblem(c, http.StatusForbidden, CodePermissionDenied, "Endpoint declares no permission")
+			return
+		}
+
+		token := c.GetHeader(policy.tokenHeader)
+		if policy.hostToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(policy.hostToken)) != 1 {
+			abortWithProblem(c, http.StatusUnauthorized, CodeUnauthenticated, "Missing or invalid host token")
+			return
+		}
+
+		if !hasPermission(c.GetHeader(policy.permissionsHeader), required) {
+			requestLogger(c).Warn("Denied request, missing permission", "method", c.Request.Method, "path", c.Request.URL.Path, "permission", required)
+			abortWithProblem(c, http.StatusForbidden, CodePermissionDenied, "Caller lacks the permission required for this endpoint",
+				"requiredPermission", required)
+			return
+		}
+
+		handler(c)
+	}
+}
+
+// hasPermission checks a comma-separated permission list for the required entry
+func hasPermission(granted, required string) bool {
+	for _, permission := range strings.Split(granted, ",") {
+		permission = strings.TrimSpace(permission)
+		if permission == required || permission == "*" {
+			return true
+		}
+	}
+	return false
+}
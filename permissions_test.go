@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		granted  string
+		required string
+		want     bool
+	}{
+		{granted: "cluster.read", required: "cluster.read", want: true},
+		{granted: "cluster.read, cluster.write", required: "cluster.write", want: true},
+		{granted: "*", required: "csr.approve", want: true},
+		{granted: "cluster.read", required: "cluster.write"},
+		{granted: "cluster.readonly", required: "cluster.read"},
+		{granted: "", required: "cluster.read"},
+	}
+
+	for _, tt := range tests {
+		if got := hasPermission(tt.granted, tt.required); got != tt.want {
+			t.Errorf("hasPermission(%q, %q) = %v, want %v", tt.granted, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestWithPermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	enforced := permissionPolicy{
+		enabled:           true,
+		hostToken:         "host-secret",
+		tokenHeader:       defaultHostTokenHeader,
+		permissionsHeader: defaultPermissionsHeader,
+	}
+
+	tests := []struct {
+		name        string
+		policy      permissionPolicy
+		handler     string
+		token       string
+		permissions string
+		wantStatus  int
+	}{
+		{name: "enforcement off", policy: permissionPolicy{}, handler: "DetachClusterHandler", wantStatus: http.StatusOK},
+		{name: "granted", policy: enforced, handler: "GetClusterStatusHandler", token: "host-secret", permissions: "cluster.read", wantStatus: http.StatusOK},
+		{name: "wildcard", policy: enforced, handler: "DetachClusterHandler", token: "host-secret", permissions: "*", wantStatus: http.StatusOK},
+		{name: "missing host token", policy: enforced, handler: "GetClusterStatusHandler", permissions: "cluster.read", wantStatus: http.StatusUnauthorized},
+		{name: "wrong host token", policy: enforced, handler: "GetClusterStatusHandler", token: "guess", permissions: "*", wantStatus: http.StatusUnauthorized},
+		{name: "missing permission", policy: enforced, handler: "DetachClusterHandler", token: "host-secret", permissions: "cluster.read", wantStatus: http.StatusForbidden},
+		{name: "endpoint without permission", policy: enforced, handler: "UndeclaredHandler", token: "host-secret", permissions: "*", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := &ClusterPlugin{permissions: tt.policy}
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				c.Request.Header.Set(defaultHostTokenHeader, tt.token)
+			}
+			c.Request.Header.Set(defaultPermissionsHeader, tt.permissions)
+
+			plugin.withPermission(tt.handler, func(c *gin.Context) { c.Status(http.StatusOK) })(c)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// StepConfig is one entry of the onboardingPipeline list of the Initialize
+// config. Built-in steps are named; any other name is a custom step running
+// Command against the spoke.
+type StepConfig struct {
+	Name string `json:"name"`
+	// TimeoutSeconds bounds each attempt, defaulting per built-in step and
+	// to 300 for custom steps
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// Retries is how many times a failed attempt is repeated
+	Retries           int `json:"retries,omitempty"`
+	RetryDelaySeconds int `json:"retryDelaySeconds,omitempty"`
+	// Optional steps log their failure instead of failing the onboarding;
+	// label is optional unless set to false
+	Optional *bool `json:"optional,omitempty"`
+	// Status is the cluster status shown while a custom step runs
+	Status string `json:"status,omitempty"`
+	// Command runs a custom step with KUBECONFIG set to the spoke kubeconfig
+	// and CLUSTER_NAME, HUB_NAME and HUB_CONTEXT in its environment, along
+	// with HUB_KUBECONFIG for hubs registered with a kubeconfig
+	Command []string `json:"command,omitempty"`
+}
+
+// onboardingRun holds what the steps of one onboarding share
+type onboardingRun struct {
+	jobID       string
+	clusterName string
+	kubeconfig  []byte
+	// target is the hub the cluster joins
+	target Hub
+	// spokeKubeconfigPath is a scratch copy of the kubeconfig for the CLIs,
+	// removed when the run ends
+	spokeKubeconfigPath string
+	spokeContext        string
+	joinToken           string
+	// values are rendered into the manifest templates
+	values ManifestValues
+	// charts is the progress of installing the charts of values
+	charts []ChartStatus
+
+	hub       *kubernetes.Clientset
+	hubConfig *rest.Config
+}
+
+// hubClient connects to the hub on first use
+func (r *onboardingRun) hubClient() (*kubernetes.Clientset, error) {
+	if r.hub == nil {
+		hub, hubConfig, err := r.target.clientset()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hub clientset: %w", err)
+		}
+		r.hub, r.hubConfig = hub, hubConfig
+	}
+	return r.hub, nil
+}
+
+// Onboarding phases, each bounded as a whole by its phase timeout
+const (
+	phaseToken  = "token"
+	phaseApply  = "apply"
+	phaseJoin   = "join"
+	phaseVerify = "verify"
+)
+
+// PhaseTimeouts is the onboardingTimeouts section of the Initialize config.
+// Step timeouts bound each attempt of a step; a phase timeout bounds every
+// step of the phase with their retries, and a phase running out of time ends
+// the job as TimedOut.
+type PhaseTimeouts struct {
+	// TokenSeconds bounds getting the join token
+	TokenSeconds int `json:"tokenSeconds,omitempty"`
+	// ApplySeconds bounds applying the klusterlet manifests
+	ApplySeconds int `json:"applySeconds,omitempty"`
+	// JoinWaitSeconds bounds approving the CSRs and waiting for the
+	// ManagedCluster to be accepted
+	JoinWaitSeconds int `json:"joinWaitSeconds,omitempty"`
+	// VerifySeconds bounds waiting for the readiness gates
+	VerifySeconds int `json:"verifySeconds,omitempty"`
+}
+
+func phaseTimeoutsFromConfig(config map[string]interface{}) (map[string]time.Duration, error) {
+	pt := PhaseTimeouts{TokenSeconds: 120, ApplySeconds: 600, JoinWaitSeconds: 600, VerifySeconds: 600}
+	if raw, ok := config["onboardingTimeouts"]; ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid onboardingTimeouts config: %w", err)
+		}
+		if err := json.Unmarshal(data, &pt); err != nil {
+			return nil, fmt.Errorf("invalid onboardingTimeouts config: %w", err)
+		}
+	}
+	timeouts := map[string]time.Duration{
+		phaseToken:  time.Duration(pt.TokenSeconds) * time.Second,
+		phaseApply:  time.Duration(pt.ApplySeconds) * time.Second,
+		phaseJoin:   time.Duration(pt.JoinWaitSeconds) * time.Second,
+		phaseVerify: time.Duration(pt.VerifySeconds) * time.Second,
+	}
+	for phase, timeout := range timeouts {
+		if timeout <= 0 {
+			return nil, fmt.Errorf("invalid onboardingTimeouts config: the %s phase timeout must be positive", phase)
+		}
+	}
+	return timeouts, nil
+}
+
+// phaseTimeoutError is the failure of an onboarding phase that ran out of
+// time. It ends the job as TimedOut.
+type phaseTimeoutError struct {
+	phase   string
+	timeout time.Duration
+	err     error
+}
+
+func (e *phaseTimeoutError) Error() string {
+	return fmt.Sprintf("%s phase timed out after %s: %v", e.phase, e.timeout, e.err)
+}
+
+func (e *phaseTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// builtinStep is an onboarding step provided by the plugin
+type builtinStep struct {
+	status   string
+	message  string
+	timeout  time.Duration
+	optional bool
+	// phase is the onboarding phase the step belongs to, if any
+	phase string
+	// requires names the steps that must run before this one
+	requires []string
+	// action describes the step for dry runs
+	action func(clusterName string) string
+	run    func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error
+}
+
+var builtinSteps = map[string]builtinStep{
+	"validate": {
+		status:  "Validating",
+		message: "Validating cluster connectivity",
+		timeout: time.Minute,
+		action:  func(string) string { return "Validate spoke cluster connectivity" },
+		run: func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error {
+			if err := cp.validateClusterConnectivity(ctx, r.kubeconfig); err != nil {
+				return fmt.Errorf("cluster validation failed: %w", err)
+			}
+			return nil
+		},
+	},
+	"token": {
+		status:  "Retrieving",
+		message: "Getting join token from hub",
+		timeout: time.Minute,
+		phase:   phaseToken,
+		action:  func(string) string { return "Retrieve join token with clusteradm get token" },
+		run: func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error {
+			token, err := cp.getClusterAdmToken(ctx, r.target)
+			if err != nil {
+				return fmt.Errorf("failed to get token: %w", err)
+			}
+			r.joinToken = token
+			cp.recordJoinToken(ctx, r.clusterName, r.target, token)
+			return nil
+		},
+	},
+	"apply-klusterlet": {
+		status:   "Joining",
+		message:  "Joining cluster to KubeStellar hub",
+		timeout:  5 * time.Minute,
+		phase:    phaseApply,
+		requires: []string{"token"},
+		action: func(clusterName string) string {
+			return fmt.Sprintf("Run clusteradm join --cluster-name %s against the spoke and apply the klusterlet templates", clusterName)
+		},
+		run: func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error {
+			if err := cp.applySpokeManifests(ctx, r, true); err != nil {
+				return fmt.Errorf("failed to apply image pull credentials: %w", err)
+			}
+			if err := cp.joinClusterToHub(ctx, r.spokeKubeconfigPath, r.clusterName, r.spokeContext, r.joinToken, r.values); err != nil {
+				return fmt.Errorf("failed to join cluster: %w", err)
+			}
+			if err := cp.applySpokeManifests(ctx, r, false); err != nil {
+				return fmt.Errorf("failed to apply klusterlet manifests: %w", err)
+			}
+			return nil
+		},
+	},
+	"accept-csr": {
+		status:   "Approving",
+		message:  "Approving Certificate Signing Requests",
+		timeout:  2 * time.Minute,
+		phase:    phaseJoin,
+		requires: []string{"apply-klusterlet"},
+		action:   func(string) string { return "Approve the cluster's CertificateSigningRequests on the hub" },
+		run: func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error {
+			hub, err := r.hubClient()
+			if err != nil {
+				return err
+			}
+			if err := cp.approveClusterCSRsEnhanced(ctx, r.target, hub, r.clusterName); err != nil {
+				return fmt.Errorf("failed to approve CSRs: %w", err)
+			}
+			return nil
+		},
+	},
+	"wait-join": {
+		status:   "Creating",
+		message:  "Waiting for managed cluster resource",
+		timeout:  6 * time.Minute,
+		phase:    phaseJoin,
+		requires: []string{"apply-klusterlet"},
+		action: func(clusterName string) string {
+			return fmt.Sprintf("Accept ManagedCluster %s on the hub", clusterName)
+		},
+		run: func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error {
+			hub, err := r.hubClient()
+			if err != nil {
+				return err
+			}
+			if err := cp.waitForManagedClusterEnhanced(ctx, hub, r.clusterName); err != nil {
+				return fmt.Errorf("failed to confirm managed cluster creation: %w", err)
+			}
+			return nil
+		},
+	},
+	"label": {
+		status:   "Finalizing",
+		message:  "Applying cluster labels and configuration",
+		timeout:  time.Minute,
+		optional: true,
+		requires: []string{"wait-join"},
+		action:   func(string) string { return "Apply cluster labels" },
+		run: func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error {
+			hub, err := r.hubClient()
+			if err != nil {
+				return err
+			}
+			return cp.applyClusterLabels(ctx, hub, r.hubConfig, r.clusterName)
+		},
+	},
+	"bind": {
+		status:   "Binding",
+		message:  "Applying BindingPolicies for the cluster",
+		timeout:  2 * time.Minute,
+		optional: true,
+		requires: []string{"wait-join"},
+		action:   func(string) string { return "Create or update the bindingPolicies on the WDS" },
+		run: func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error {
+			if err := cp.applyBindingPolicies(ctx, r); err != nil {
+				return fmt.Errorf("failed to apply BindingPolicies: %w", err)
+			}
+			return nil
+		},
+	},
+	"helm-charts": {
+		status:   "Installing",
+		message:  "Installing Helm charts",
+		timeout:  10 * time.Minute,
+		requires: []string{"wait-join"},
+		action:   func(string) string { return "Install the requested Helm charts with helm upgrade --install" },
+		run: func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error {
+			return cp.installCharts(ctx, r)
+		},
+	},
+	"verify": {
+		status:   "Verifying",
+		message:  "Waiting for the readiness gates",
+		timeout:  5 * time.Minute,
+		phase:    phaseVerify,
+		requires: []string{"wait-join"},
+		action:   func(string) string { return "Wait for the readiness gates to pass" },
+		run: func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error {
+			return cp.verifyReadiness(ctx, r)
+		},
+	},
+}
+
+// defaultPipeline is the onboarding run when no pipeline is configured
+var defaultPipeline = []string{"validate", "token", "apply-klusterlet", "accept-csr", "wait-join", "label", "helm-charts", "verify"}
+
+// pipelineStep is a resolved step of the onboarding pipeline
+type pipelineStep struct {
+	name       string
+	status     string
+	message    string
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+	optional   bool
+	phase      string
+	action     func(clusterName string) string
+	run        func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error
+}
+
+// pipelineFromConfig reads the onboarding pipeline. With bind set, for
+// configured bindingPolicies, the default pipeline ends with the bind step
+// and a configured one must include it.
+func pipelineFromConfig(config map[string]interface{}, bind bool) ([]pipelineStep, error) {
+	var configs []StepConfig
+	if raw, ok := config["onboardingPipeline"]; ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid onboardingPipeline config: %w", err)
+		}
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return nil, fmt.Errorf("invalid onboardingPipeline config: %w", err)
+		}
+	} else {
+		for _, name := range defaultPipeline {
+			configs = append(configs, StepConfig{Name: name})
+		}
+		if bind {
+			configs = append(configs, StepConfig{Name: "bind"})
+		}
+	}
+	steps, err := newPipeline(configs)
+	if err != nil {
+		return nil, err
+	}
+	if bind && !hasStep(steps, "bind") {
+		return nil, fmt.Errorf("onboardingPipeline must include bind to apply the bindingPolicies")
+	}
+	return steps, nil
+}
+
+func hasStep(steps []pipelineStep, name string) bool {
+	for _, step := range steps {
+		if step.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newPipeline resolves and checks the configured steps: names are unique,
+// custom steps have a command, and built-in steps follow the ones they need
+func newPipeline(configs []StepConfig) ([]pipelineStep, error) {
+	seen := make(map[string]bool, len(configs))
+	steps := make([]pipelineStep, 0, len(configs))
+	for i, sc := range configs {
+		if sc.Name == "" {
+			return nil, fmt.Errorf("onboardingPipeline[%d]: name is required", i)
+		}
+		if seen[sc.Name] {
+			return nil, fmt.Errorf("onboardingPipeline: step %s is listed twice", sc.Name)
+		}
+		if sc.TimeoutSeconds < 0 || sc.Retries < 0 || sc.RetryDelaySeconds < 0 {
+			return nil, fmt.Errorf("onboardingPipeline: step %s has a negative timeout, retry count or delay", sc.Name)
+		}
+
+		step := pipelineStep{
+			name:       sc.Name,
+			retries:    sc.Retries,
+			retryDelay: 5 * time.Second,
+		}
+		if sc.RetryDelaySeconds > 0 {
+			step.retryDelay = time.Duration(sc.RetryDelaySeconds) * time.Second
+		}
+		if builtin, ok := builtinSteps[sc.Name]; ok {
+			if len(sc.Command) > 0 {
+				return nil, fmt.Errorf("onboardingPipeline: built-in step %s takes no command", sc.Name)
+			}
+			for _, required := range builtin.requires {
+				if !seen[required] {
+					return nil, fmt.Errorf("onboardingPipeline: step %s must come after %s", sc.Name, required)
+				}
+			}
+			step.status, step.message = builtin.status, builtin.message
+			step.timeout, step.optional = builtin.timeout, builtin.optional
+			step.phase = builtin.phase
+			step.run = builtin.run
+			step.action = builtin.action
+		} else {
+			if len(sc.Command) == 0 {
+				return nil, fmt.Errorf("onboardingPipeline: custom step %s requires a command", sc.Name)
+			}
+			command := append([]string(nil), sc.Command...)
+			step.status = "Running " + sc.Name
+			step.message = fmt.Sprintf("Running step %s", sc.Name)
+			step.timeout = 5 * time.Minute
+			step.action = func(string) string {
+				return fmt.Sprintf("Run custom step %s: %s", sc.Name, strings.Join(command, " "))
+			}
+			step.run = func(cp *ClusterPlugin, ctx context.Context, r *onboardingRun) error {
+				return cp.runCustomStep(ctx, r, sc.Name, command)
+			}
+		}
+		if sc.Status != "" {
+			step.status = sc.Status
+		}
+		if sc.TimeoutSeconds > 0 {
+			step.timeout = time.Duration(sc.TimeoutSeconds) * time.Second
+		}
+		if sc.Optional != nil {
+			step.optional = *sc.Optional
+		}
+		seen[sc.Name] = true
+		steps = append(steps, step)
+	}
+	if !seen["apply-klusterlet"] {
+		return nil, fmt.Errorf("onboardingPipeline must include apply-klusterlet")
+	}
+	return steps, nil
+}
+
+// runCustomStep runs the command of a custom step against the spoke
+func (cp *ClusterPlugin) runCustomStep(ctx context.Context, r *onboardingRun, name string, command []string) error {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"KUBECONFIG="+r.spokeKubeconfigPath,
+		"CLUSTER_NAME="+r.clusterName,
+		"HUB_NAME="+r.target.Name,
+		"HUB_CONTEXT="+r.target.Context,
+	)
+	if r.target.kubeconfigPath != "" {
+		cmd.Env = append(cmd.Env, "HUB_KUBECONFIG="+r.target.kubeconfigPath)
+	}
+	if output, err := cp.runLogged(r.clusterName, cmd); err != nil {
+		return fmt.Errorf("step %s failed: %s, %w", name, string(output), err)
+	}
+	return nil
+}
+
+// runStep runs one step, retrying failed attempts, each bounded by the
+// step timeout
+func (cp *ClusterPlugin) runStep(ctx context.Context, r *onboardingRun, step pipelineStep) error {
+	var err error
+	for attempt := 0; attempt <= step.retries; attempt++ {
+		if attempt > 0 {
+			logger().Warn("Retrying onboarding step", "cluster", r.clusterName, "step", step.name, "attempt", attempt+1, "error", err)
+			cp.logs.Append(r.clusterName, "warn", fmt.Sprintf("Step %s failed, retrying: %v", step.name, err))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(step.retryDelay):
+			}
+		}
+		stepCtx, cancel := context.WithTimeout(ctx, step.timeout)
+		if err = cp.injectStepFault(step.name); err == nil {
+			err = step.run(cp, stepCtx, r)
+		}
+		if err != nil && stepCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			err = fmt.Errorf("step %s timed out after %s: %w", step.name, step.timeout, err)
+		}
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// runPipeline runs the onboarding steps, skipping those a resumed job got
+// through before a restart. The steps of a phase share its deadline, which
+// starts with the first of them.
+func (cp *ClusterPlugin) runPipeline(ctx context.Context, jobID string, r *onboardingRun) error {
+	done, joinToken := cp.checkpoints.Progress(jobID)
+	if r.joinToken == "" {
+		r.joinToken = joinToken
+	}
+	deadlines := make(map[string]time.Time)
+	for _, step := range cp.onboarding {
+		if done[step.name] {
+			cp.skipCheckpointedStep(jobID, r.clusterName, step.name)
+			continue
+		}
+		if err := cp.advance(ctx, r.clusterName, step.status, step.message); err != nil {
+			return err
+		}
+
+		stepCtx, cancel := ctx, context.CancelFunc(func() {})
+		timeout, bounded := cp.phaseTimeouts[step.phase]
+		if bounded {
+			if _, started := deadlines[step.phase]; !started {
+				deadlines[step.phase] = time.Now().Add(timeout)
+			}
+			stepCtx, cancel = context.WithDeadline(ctx, deadlines[step.phase])
+		}
+		err := cp.runStep(stepCtx, r, step)
+		if err != nil && bounded && stepCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			err = &phaseTimeoutError{phase: step.phase, timeout: timeout, err: err}
+		}
+		cancel()
+
+		if err != nil {
+			if !step.optional || ctx.Err() != nil {
+				return err
+			}
+			// Optional steps such as labelling don't fail the onboarding
+			logger().Warn("Optional onboarding step failed", "cluster", r.clusterName, "step", step.name, "error", err)
+			cp.logs.Append(r.clusterName, "warn", fmt.Sprintf("Step %s failed: %v", step.name, err))
+			cp.checkpoints.Complete(jobID, step.name, checkpointSkipped, err.Error(), r.joinToken)
+			continue
+		}
+		cp.checkpoints.Complete(jobID, step.name, checkpointCompleted, "", r.joinToken)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineFromConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    map[string]interface{}
+		wantSteps []string
+		wantErr   string
+	}{
+		{name: "default", config: map[string]interface{}{}, wantSteps: defaultPipeline},
+		{
+			name: "custom step",
+			config: map[string]interface{}{"onboardingPipeline": []interface{}{
+				map[string]interface{}{"name": "token"},
+				map[string]interface{}{"name": "apply-klusterlet", "retries": 2},
+				map[string]interface{}{"name": "install-monitoring", "command": []interface{}{"helm", "install", "agent"}, "timeoutSeconds": 60},
+			}},
+			wantSteps: []string{"token", "apply-klusterlet", "install-monitoring"},
+		},
+		{
+			name:    "custom step without command",
+			config:  map[string]interface{}{"onboardingPipeline": []interface{}{map[string]interface{}{"name": "install-monitoring"}}},
+			wantErr: "requires a command",
+		},
+		{
+			name: "built-in step with command",
+			config: map[string]interface{}{"onboardingPipeline": []interface{}{
+				map[string]interface{}{"name": "token", "command": []interface{}{"true"}},
+			}},
+			wantErr: "takes no command",
+		},
+		{
+			name: "out of order",
+			config: map[string]interface{}{"onboardingPipeline": []interface{}{
+				map[string]interface{}{"name": "apply-klusterlet"},
+				map[string]interface{}{"name": "token"},
+			}},
+			wantErr: "must come after token",
+		},
+		{
+			name: "duplicate",
+			config: map[string]interface{}{"onboardingPipeline": []interface{}{
+				map[string]interface{}{"name": "token"},
+				map[string]interface{}{"name": "token"},
+			}},
+			wantErr: "listed twice",
+		},
+		{
+			name:    "without join",
+			config:  map[string]interface{}{"onboardingPipeline": []interface{}{map[string]interface{}{"name": "validate"}}},
+			wantErr: "must include apply-klusterlet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := pipelineFromConfig(tt.config, false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("pipelineFromConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pipelineFromConfig() error = %v", err)
+			}
+			if len(steps) != len(tt.wantSteps) {
+				t.Fatalf("got %d steps, want %v", len(steps), tt.wantSteps)
+			}
+			for i, step := range steps {
+				if step.name != tt.wantSteps[i] {
+					t.Errorf("step %d = %s, want %s", i, step.name, tt.wantSteps[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRunStep(t *testing.T) {
+	plugin := &ClusterPlugin{logs: NewLogHub(0)}
+	run := &onboardingRun{clusterName: "demo"}
+
+	attempts := 0
+	flaky := pipelineStep{
+		name:    "flaky",
+		timeout: time.Second,
+		retries: 2,
+		run: func(_ *ClusterPlugin, _ context.Context, _ *onboardingRun) error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("not yet")
+			}
+			return nil
+		},
+	}
+	if err := plugin.runStep(context.Background(), run, flaky); err != nil || attempts != 3 {
+		t.Errorf("runStep() error = %v after %d attempts, want success after 3", err, attempts)
+	}
+
+	slow := pipelineStep{
+		name:    "slow",
+		timeout: 10 * time.Millisecond,
+		run: func(_ *ClusterPlugin, ctx context.Context, _ *onboardingRun) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	if err := plugin.runStep(context.Background(), run, slow); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("runStep() error = %v, want a timeout", err)
+	}
+
+	steps, err := newPipeline([]StepConfig{
+		{Name: "token"},
+		{Name: "apply-klusterlet"},
+		{Name: "check-env", Command: []string{"sh", "-c", `test "$CLUSTER_NAME" = demo`}},
+	})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	if err := plugin.runStep(context.Background(), run, steps[2]); err != nil {
+		t.Errorf("custom step error = %v", err)
+	}
+}
+
+func TestPhaseTimeout(t *testing.T) {
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0})
+	sink := &recordingSink{}
+	plugin.events.Subscribe("test", sink, []string{eventJobFailed})
+
+	// Each step fits its own timeout, but not both in the join phase's
+	plugin.phaseTimeouts[phaseJoin] = 50 * time.Millisecond
+	wait := func(_ *ClusterPlugin, ctx context.Context, _ *onboardingRun) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(30 * time.Millisecond):
+			return nil
+		}
+	}
+	plugin.onboarding = []pipelineStep{
+		{name: "accept-csr", status: "Approving", timeout: time.Second, phase: phaseJoin, run: wait},
+		{name: "wait-join", status: "Waiting", timeout: time.Second, phase: phaseJoin, run: wait},
+	}
+
+	job := plugin.jobs.Create(context.Background(), "onboard", "edge-1")
+	plugin.jobs.Execute(job.ID, func(ctx context.Context) error {
+		return plugin.runPipeline(ctx, job.ID, &onboardingRun{clusterName: "edge-1"})
+	})
+	job, _ = plugin.jobs.Get(job.ID)
+	if job.State != JobTimedOut || job.TimedOutPhase != phaseJoin || !job.Finished() {
+		t.Fatalf("job = %s in phase %q: %s", job.State, job.TimedOutPhase, job.Message)
+	}
+	if !strings.Contains(job.Message, "join phase timed out after 50ms") {
+		t.Errorf("message = %q", job.Message)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sink.mutex.Lock()
+		events := append([]Event(nil), sink.events...)
+		sink.mutex.Unlock()
+		if len(events) == 1 {
+			if events[0].Attributes["phase"] != phaseJoin {
+				t.Errorf("event attributes = %v", events[0].Attributes)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("events = %+v", events)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPhaseTimeoutsFromConfig(t *testing.T) {
+	timeouts, err := phaseTimeoutsFromConfig(map[string]interface{}{"onboardingTimeouts": map[string]interface{}{"joinWaitSeconds": 900}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeouts[phaseJoin] != 15*time.Minute || timeouts[phaseToken] != 2*time.Minute {
+		t.Errorf("timeouts = %v", timeouts)
+	}
+	if _, err := phaseTimeoutsFromConfig(map[string]interface{}{"onboardingTimeouts": map[string]interface{}{"verifySeconds": -1}}); err == nil {
+		t.Error("negative verify timeout accepted")
+	}
+}
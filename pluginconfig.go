@@ -0,0 +1,520 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pluginConfigEnvPrefix prefixes the environment variables overriding the
+// Initialize config, KS_PLUGIN_MAX_CONCURRENT_JOBS for maxConcurrentJobs
+const pluginConfigEnvPrefix = "KS_PLUGIN_"
+
+// redactedValue replaces secrets in the effective config
+const redactedValue = "<redacted>"
+
+// Sources of an effective config value
+const (
+	configSourceDefault = "default"
+	configSourceConfig  = "config"
+	configSourceEnv     = "env"
+	// configSourceRuntime is a setting changed through PUT /settings
+	configSourceRuntime = "runtime"
+)
+
+// PluginConfig is the typed contract of the scalar keys of the Initialize
+// config. Each field is read from the key named by its json tag, then from
+// the KS_PLUGIN_ environment variable of that key. Structured sections such
+// as auth, webhooks or slos keep their own types and are read where they are
+// used.
+type PluginConfig struct {
+	// Logging; a logger injected by the host ignores them
+	LogLevel  string `json:"logLevel"`
+	LogOutput string `json:"logOutput"`
+	LogFormat string `json:"logFormat"`
+
+	MetadataPath string `json:"metadataPath"`
+
+	// Mode is live or mock
+	Mode                string `json:"mode"`
+	MockFleetSize       int    `json:"mockFleetSize"`
+	MockSeed            int    `json:"mockSeed"`
+	MockStepDelayMillis int    `json:"mockStepDelayMillis"`
+	MockScenario        string `json:"mockScenario"`
+
+	TracingEnabled     bool    `json:"tracingEnabled"`
+	TracingSampleRatio float64 `json:"tracingSampleRatio"`
+	OTLPEndpoint       string  `json:"otlpEndpoint"`
+	OTLPInsecure       bool    `json:"otlpInsecure"`
+
+	EnforcePermissions bool   `json:"enforcePermissions"`
+	HostToken          string `json:"hostToken" secret:"true"`
+	HostTokenHeader    string `json:"hostTokenHeader"`
+	PermissionsHeader  string `json:"permissionsHeader"`
+
+	CompressResponses      bool `json:"compressResponses"`
+	HostAppliesMiddlewares bool `json:"hostAppliesMiddlewares"`
+	DebugEndpoints         bool `json:"debugEndpoints"`
+	SettingsReadOnly       bool `json:"settingsReadOnly"`
+	FaultInjection         bool `json:"faultInjection"`
+
+	JobStorePath              string `json:"jobStorePath"`
+	JobRetention              int    `json:"jobRetention"`
+	MaxConcurrentJobs         int    `json:"maxConcurrentJobs"`
+	JobQueueSize              int    `json:"jobQueueSize"`
+	JobQueueRetryAfterSeconds int    `json:"jobQueueRetryAfterSeconds"`
+	StatusCacheTTLSeconds     int    `json:"statusCacheTTLSeconds"`
+	LogBufferSize             int    `json:"logBufferSize"`
+	BatchConcurrency          int    `json:"batchConcurrency"`
+	MaxBatchSize              int    `json:"maxBatchSize"`
+	MaxKubeconfigUploadBytes  int    `json:"maxKubeconfigUploadBytes"`
+
+	HealthActiveJobsThreshold     int `json:"healthActiveJobsThreshold"`
+	HealthWebhookBacklogThreshold int `json:"healthWebhookBacklogThreshold"`
+
+	FinalizerTimeoutSeconds int    `json:"finalizerTimeoutSeconds"`
+	DetachSafety            string `json:"detachSafety"`
+	DrainTimeoutSeconds     int    `json:"drainTimeoutSeconds"`
+	RequireApproval         string `json:"requireApproval"`
+	ApprovalExpirySeconds   int    `json:"approvalExpirySeconds"`
+	ApprovalRetention       int    `json:"approvalRetention"`
+	ConflictPolicy          string `json:"conflictPolicy"`
+	ShutdownTimeoutSeconds  int    `json:"shutdownTimeoutSeconds"`
+	IdempotencyTTLSeconds   int    `json:"idempotencyTTLSeconds"`
+
+	WebhookTimeoutSeconds int `json:"webhookTimeoutSeconds"`
+	WebhookMaxAttempts    int `json:"webhookMaxAttempts"`
+	WebhookBackoffSeconds int `json:"webhookBackoffSeconds"`
+
+	EncryptionKey          string   `json:"encryptionKey" secret:"true"`
+	EncryptionKeyCommand   string   `json:"encryptionKeyCommand"`
+	EncryptionKeyPath      string   `json:"encryptionKeyPath"`
+	PreviousEncryptionKeys []string `json:"previousEncryptionKeys" secret:"true"`
+
+	// Storage paths default to files under the kubeconfig directory
+	StorePath           string   `json:"storePath"`
+	AllowMemoryStore    bool     `json:"allowMemoryStore"`
+	HubStoreDir         string   `json:"hubStoreDir"`
+	GroupStorePath      string   `json:"groupStorePath"`
+	Environments        []string `json:"environments"`
+	ScheduleStorePath   string   `json:"scheduleStorePath"`
+	ScheduleRetention   int      `json:"scheduleRetention"`
+	CheckpointStorePath string   `json:"checkpointStorePath"`
+	CheckpointRetention int      `json:"checkpointRetention"`
+	ReconcileStorePath  string   `json:"reconcileStorePath"`
+	GitopsDir           string   `json:"gitopsDir"`
+
+	ReconcileIntervalSeconds int `json:"reconcileIntervalSeconds"`
+	DiscoveryIntervalSeconds int `json:"discoveryIntervalSeconds"`
+
+	// Background checks of live mode
+	MonitorHeartbeats         bool `json:"monitorHeartbeats"`
+	HeartbeatStaleSeconds     int  `json:"heartbeatStaleSeconds"`
+	HeartbeatIntervalSeconds  int  `json:"heartbeatIntervalSeconds"`
+	MonitorTokens             bool `json:"monitorTokens"`
+	TokenWarningSeconds       int  `json:"tokenWarningSeconds"`
+	TokenCheckIntervalSeconds int  `json:"tokenCheckIntervalSeconds"`
+	CollectResources          bool `json:"collectResources"`
+	ResourceIntervalSeconds   int  `json:"resourceIntervalSeconds"`
+	WatchManagedClusters      bool `json:"watchManagedClusters"`
+	HubResyncSeconds          int  `json:"hubResyncSeconds"`
+
+	HistoryRetentionDays    int `json:"historyRetentionDays"`
+	HistoryMaxEntries       int `json:"historyMaxEntries"`
+	SLOCheckIntervalSeconds int `json:"sloCheckIntervalSeconds"`
+
+	AuditRetention     int    `json:"auditRetention"`
+	AuditLogPath       string `json:"auditLogPath"`
+	AuditWebhookURL    string `json:"auditWebhookURL"`
+	AuditWebhookSecret string `json:"auditWebhookSecret" secret:"true"`
+}
+
+// defaultPluginConfig is the config used for the keys Initialize isn't
+// given, with the state files under kubeconfigDir
+func defaultPluginConfig(kubeconfigDir string) PluginConfig {
+	return PluginConfig{
+		LogLevel:                      "info",
+		LogOutput:                     "stderr",
+		LogFormat:                     "text",
+		Mode:                          modeLive,
+		MockFleetSize:                 50,
+		MockSeed:                      1,
+		MockStepDelayMillis:           500,
+		TracingSampleRatio:            1,
+		OTLPEndpoint:                  "localhost:4318",
+		HostTokenHeader:               defaultHostTokenHeader,
+		PermissionsHeader:             defaultPermissionsHeader,
+		CompressResponses:             true,
+		JobRetention:                  500,
+		MaxConcurrentJobs:             10,
+		JobQueueSize:                  100,
+		JobQueueRetryAfterSeconds:     30,
+		StatusCacheTTLSeconds:         5,
+		LogBufferSize:                 500,
+		BatchConcurrency:              4,
+		MaxBatchSize:                  100,
+		MaxKubeconfigUploadBytes:      defaultMaxKubeconfigUploadBytes,
+		HealthActiveJobsThreshold:     50,
+		HealthWebhookBacklogThreshold: 100,
+		FinalizerTimeoutSeconds:       120,
+		DetachSafety:                  detachSafetyRefuse,
+		DrainTimeoutSeconds:           600,
+		RequireApproval:               approvalOff,
+		ApprovalExpirySeconds:         86400,
+		ApprovalRetention:             500,
+		ConflictPolicy:                conflictPolicyReject,
+		ShutdownTimeoutSeconds:        30,
+		IdempotencyTTLSeconds:         86400,
+		WebhookTimeoutSeconds:         10,
+		WebhookMaxAttempts:            5,
+		WebhookBackoffSeconds:         1,
+		StorePath:                     filepath.Join(kubeconfigDir, "clusters.db"),
+		HubStoreDir:                   filepath.Join(kubeconfigDir, "hubs"),
+		GroupStorePath:                filepath.Join(kubeconfigDir, "groups.json"),
+		Environments:                  defaultEnvironments,
+		ScheduleStorePath:             filepath.Join(kubeconfigDir, "schedules.json"),
+		ScheduleRetention:             500,
+		CheckpointStorePath:           filepath.Join(kubeconfigDir, "checkpoints.json"),
+		CheckpointRetention:           100,
+		ReconcileStorePath:            filepath.Join(kubeconfigDir, "reconcile.json"),
+		GitopsDir:                     filepath.Join(kubeconfigDir, "gitops"),
+		ReconcileIntervalSeconds:      60,
+		DiscoveryIntervalSeconds:      900,
+		MonitorHeartbeats:             true,
+		HeartbeatStaleSeconds:         300,
+		HeartbeatIntervalSeconds:      60,
+		MonitorTokens:                 true,
+		TokenWarningSeconds:           900,
+		TokenCheckIntervalSeconds:     60,
+		CollectResources:              true,
+		ResourceIntervalSeconds:       300,
+		WatchManagedClusters:          true,
+		HubResyncSeconds:              300,
+		HistoryRetentionDays:          90,
+		HistoryMaxEntries:             1000,
+		SLOCheckIntervalSeconds:       60,
+		AuditRetention:                1000,
+	}
+}
+
+// Validate checks the values that don't depend on other parts of the config
+func (pc PluginConfig) Validate() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(pc.LogLevel)); err != nil {
+		return fmt.Errorf("invalid logLevel: %w", err)
+	}
+	if format := strings.ToLower(pc.LogFormat); format != "text" && format != "json" {
+		return fmt.Errorf("invalid logFormat %q, must be text or json", pc.LogFormat)
+	}
+	if pc.Mode != modeLive && pc.Mode != modeMock {
+		return fmt.Errorf("invalid mode %q, must be %q or %q", pc.Mode, modeLive, modeMock)
+	}
+	if pc.MockScenario != "" && pc.Mode != modeMock {
+		return fmt.Errorf("mockScenario requires mode %q", modeMock)
+	}
+	if pc.TracingSampleRatio < 0 || pc.TracingSampleRatio > 1 {
+		return fmt.Errorf("tracingSampleRatio must be between 0 and 1, got %v", pc.TracingSampleRatio)
+	}
+	if pc.EnforcePermissions && pc.HostToken == "" {
+		return fmt.Errorf("enforcePermissions requires a hostToken")
+	}
+	switch pc.DetachSafety {
+	case detachSafetyRefuse, detachSafetyWarn, detachSafetyOff:
+	default:
+		return fmt.Errorf("detachSafety must be %s, %s or %s", detachSafetyRefuse, detachSafetyWarn, detachSafetyOff)
+	}
+	switch pc.RequireApproval {
+	case approvalOff:
+	case approvalForce, approvalDetach:
+		if pc.ApprovalExpirySeconds <= 0 {
+			return fmt.Errorf("approvalExpirySeconds must be positive")
+		}
+	default:
+		return fmt.Errorf("requireApproval must be %s, %s or %s", approvalOff, approvalForce, approvalDetach)
+	}
+	if pc.ConflictPolicy != conflictPolicyReject && pc.ConflictPolicy != conflictPolicyReturn {
+		return fmt.Errorf("invalid conflictPolicy %q, must be %q or %q", pc.ConflictPolicy, conflictPolicyReject, conflictPolicyReturn)
+	}
+
+	for _, check := range []struct {
+		key   string
+		value int
+	}{
+		{"mockFleetSize", pc.MockFleetSize},
+		{"mockStepDelayMillis", pc.MockStepDelayMillis},
+		{"maxConcurrentJobs", pc.MaxConcurrentJobs},
+		{"jobQueueSize", pc.JobQueueSize},
+		{"statusCacheTTLSeconds", pc.StatusCacheTTLSeconds},
+	} {
+		if check.value < 0 {
+			return fmt.Errorf("%s must not be negative", check.key)
+		}
+	}
+	for _, check := range []struct {
+		key   string
+		value int
+	}{
+		{"drainTimeoutSeconds", pc.DrainTimeoutSeconds},
+		{"reconcileIntervalSeconds", pc.ReconcileIntervalSeconds},
+		{"historyRetentionDays", pc.HistoryRetentionDays},
+		{"historyMaxEntries", pc.HistoryMaxEntries},
+	} {
+		if check.value <= 0 {
+			return fmt.Errorf("%s must be positive", check.key)
+		}
+	}
+	// The background checks only run in live mode
+	if pc.Mode != modeLive {
+		return nil
+	}
+	if pc.MonitorHeartbeats && (pc.HeartbeatStaleSeconds <= 0 || pc.HeartbeatIntervalSeconds <= 0) {
+		return fmt.Errorf("heartbeatStaleSeconds and heartbeatIntervalSeconds must be positive")
+	}
+	if pc.MonitorTokens && (pc.TokenWarningSeconds <= 0 || pc.TokenCheckIntervalSeconds <= 0) {
+		return fmt.Errorf("tokenWarningSeconds and tokenCheckIntervalSeconds must be positive")
+	}
+	if pc.CollectResources && pc.ResourceIntervalSeconds <= 0 {
+		return fmt.Errorf("resourceIntervalSeconds must be positive")
+	}
+	return nil
+}
+
+// configEnvName is the environment variable overriding a config key:
+// maxConcurrentJobs is KS_PLUGIN_MAX_CONCURRENT_JOBS and statusCacheTTLSeconds
+// is KS_PLUGIN_STATUS_CACHE_TTL_SECONDS
+func configEnvName(key string) string {
+	runes := []rune(key)
+	var name strings.Builder
+	name.WriteString(pluginConfigEnvPrefix)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextLower) {
+				name.WriteByte('_')
+			}
+		}
+		name.WriteRune(unicode.ToUpper(r))
+	}
+	return name.String()
+}
+
+// resolvePluginConfig reads the typed config from the Initialize config map
+// over the defaults, then lets the KS_PLUGIN_ environment variables found
+// by lookupEnv override it. It also returns a copy of the map holding the
+// resolved values, for the code reading the map directly, and where each
+// key's value came from.
+func resolvePluginConfig(config map[string]interface{}, kubeconfigDir string, lookupEnv func(string) (string, bool)) (PluginConfig, map[string]interface{}, map[string]string, error) {
+	resolved := defaultPluginConfig(kubeconfigDir)
+	effective := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		effective[key] = value
+	}
+	sources := map[string]string{}
+
+	value := reflect.ValueOf(&resolved).Elem()
+	fields := value.Type()
+	for i := 0; i < fields.NumField(); i++ {
+		key := configKey(fields.Field(i))
+		field := value.Field(i)
+		sources[key] = configSourceDefault
+		if raw, ok := config[key]; ok && raw != nil {
+			if err := setConfigField(field, raw); err != nil {
+				return resolved, nil, nil, fmt.Errorf("invalid config %s: %w", key, err)
+			}
+			sources[key] = configSourceConfig
+		}
+		if raw, ok := lookupEnv(configEnvName(key)); ok {
+			if err := setConfigField(field, raw); err != nil {
+				return resolved, nil, nil, fmt.Errorf("invalid %s: %w", configEnvName(key), err)
+			}
+			sources[key] = configSourceEnv
+		}
+		effective[key] = field.Interface()
+	}
+	if err := resolved.Validate(); err != nil {
+		return resolved, nil, nil, err
+	}
+	return resolved, effective, sources, nil
+}
+
+// configKey is the config key of a PluginConfig field
+func configKey(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
+
+// setConfigField sets a PluginConfig field from a config map value, as
+// decoded from JSON or passed by a host, or from an environment variable
+func setConfigField(field reflect.Value, raw interface{}) error {
+	switch field.Kind() {
+	case reflect.String:
+		value, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		if value != "" {
+			field.SetString(value)
+		}
+	case reflect.Bool:
+		switch value := raw.(type) {
+		case bool:
+			field.SetBool(value)
+		case string:
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("must be true or false")
+			}
+			field.SetBool(parsed)
+		default:
+			return fmt.Errorf("must be true or false")
+		}
+	case reflect.Int:
+		var parsed int64
+		switch value := raw.(type) {
+		case int:
+			parsed = int64(value)
+		case int64:
+			parsed = value
+		case float64:
+			if value != math.Trunc(value) {
+				return fmt.Errorf("must be an integer")
+			}
+			parsed = int64(value)
+		case string:
+			var err error
+			if parsed, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64); err != nil {
+				return fmt.Errorf("must be an integer")
+			}
+		default:
+			return fmt.Errorf("must be an integer")
+		}
+		field.SetInt(parsed)
+	case reflect.Float64:
+		switch value := raw.(type) {
+		case float64:
+			field.SetFloat(value)
+		case float32:
+			field.SetFloat(float64(value))
+		case int:
+			field.SetFloat(float64(value))
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return fmt.Errorf("must be a number")
+			}
+			field.SetFloat(parsed)
+		default:
+			return fmt.Errorf("must be a number")
+		}
+	case reflect.Slice:
+		switch raw.(type) {
+		case []string, []interface{}, string:
+		default:
+			return fmt.Errorf("must be a list of strings")
+		}
+		values := configStrings(map[string]interface{}{"value": raw}, "value")
+		if len(values) > 0 {
+			field.Set(reflect.ValueOf(values))
+		}
+	}
+	return nil
+}
+
+// redacted returns a copy of the config with its secrets hidden
+func (pc PluginConfig) redacted() PluginConfig {
+	value := reflect.ValueOf(&pc).Elem()
+	fields := value.Type()
+	for i := 0; i < fields.NumField(); i++ {
+		if fields.Field(i).Tag.Get("secret") != "true" {
+			continue
+		}
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			if field.String() != "" {
+				field.SetString(redactedValue)
+			}
+		case reflect.Slice:
+			hidden := make([]string, field.Len())
+			for j := range hidden {
+				hidden[j] = redactedValue
+			}
+			if len(hidden) > 0 {
+				field.Set(reflect.ValueOf(hidden))
+			}
+		}
+	}
+	return pc
+}
+
+// effectiveConfig is the config Initialize resolved with the runtime
+// settings changed since laid over it. The caller holds cp.mutex.
+func (cp *ClusterPlugin) effectiveConfig() (PluginConfig, map[string]string) {
+	config := cp.config
+	sources := make(map[string]string, len(cp.configSources))
+	for key, source := range cp.configSources {
+		sources[key] = source
+	}
+	settings := cp.currentSettings()
+	overlay := func(key string, target *int, current int) {
+		if *target != current {
+			*target, sources[key] = current, configSourceRuntime
+		}
+	}
+	overlay("maxConcurrentJobs", &config.MaxConcurrentJobs, settings.MaxConcurrentJobs)
+	overlay("jobQueueSize", &config.JobQueueSize, settings.JobQueueSize)
+	overlay("statusCacheTTLSeconds", &config.StatusCacheTTLSeconds, settings.StatusCacheTTLSeconds)
+	overlay("mockStepDelayMillis", &config.MockStepDelayMillis, settings.MockStepDelayMillis)
+	if settings.LogLevel != "" && !strings.EqualFold(settings.LogLevel, config.LogLevel) {
+		config.LogLevel, sources["logLevel"] = settings.LogLevel, configSourceRuntime
+	}
+	return config, sources
+}
+
+// GetEffectiveSettingsHandler returns the config the plugin runs with:
+// defaults, the Initialize config, KS_PLUGIN_ environment overrides and the
+// runtime settings changed since, with where each value came from. Secrets
+// are redacted and the structured sections are only listed by name, as they
+// may hold credentials.
+func (cp *ClusterPlugin) GetEffectiveSettingsHandler(c *gin.Context) {
+	cp.mutex.RLock()
+	config, sources := cp.effectiveConfig()
+	sections := append([]string(nil), cp.configSections...)
+	cp.mutex.RUnlock()
+	c.JSON(http.StatusOK, gin.H{
+		"config":    config.redacted(),
+		"sources":   sources,
+		"sections":  sections,
+		"envPrefix": pluginConfigEnvPrefix,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// configSections lists the keys of the Initialize config outside of
+// PluginConfig, sorted
+func configSections(config map[string]interface{}) []string {
+	typed := map[string]bool{}
+	fields := reflect.TypeOf(PluginConfig{})
+	for i := 0; i < fields.NumField(); i++ {
+		typed[configKey(fields.Field(i))] = true
+	}
+	sections := []string{}
+	for key := range config {
+		if !typed[key] {
+			sections = append(sections, key)
+		}
+	}
+	sort.Strings(sections)
+	return sections
+}
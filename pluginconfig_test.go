@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConfigEnvName(t *testing.T) {
+	for key, want := range map[string]string{
+		"maxConcurrentJobs":     "KS_PLUGIN_MAX_CONCURRENT_JOBS",
+		"statusCacheTTLSeconds": "KS_PLUGIN_STATUS_CACHE_TTL_SECONDS",
+		"auditWebhookURL":       "KS_PLUGIN_AUDIT_WEBHOOK_URL",
+		"otlpEndpoint":          "KS_PLUGIN_OTLP_ENDPOINT",
+		"mode":                  "KS_PLUGIN_MODE",
+	} {
+		if got := configEnvName(key); got != want {
+			t.Errorf("configEnvName(%s) = %s, want %s", key, got, want)
+		}
+	}
+}
+
+func TestResolvePluginConfig(t *testing.T) {
+	env := map[string]string{
+		"KS_PLUGIN_MAX_CONCURRENT_JOBS": "3",
+		"KS_PLUGIN_DEBUG_ENDPOINTS":     "true",
+		"KS_PLUGIN_ENVIRONMENTS":        "qa, prod",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	config := map[string]interface{}{
+		"maxConcurrentJobs": 20,
+		"jobQueueSize":      float64(50),
+		"compressResponses": "false",
+		"auth":              map[string]interface{}{"enabled": false},
+	}
+
+	resolved, effective, sources, err := resolvePluginConfig(config, "/data", lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.MaxConcurrentJobs != 3 || resolved.JobQueueSize != 50 || resolved.CompressResponses || !resolved.DebugEndpoints {
+		t.Errorf("resolved = %+v", resolved)
+	}
+	if !reflect.DeepEqual(resolved.Environments, []string{"qa", "prod"}) {
+		t.Errorf("environments = %v", resolved.Environments)
+	}
+	if resolved.StorePath != "/data/clusters.db" || resolved.DrainTimeoutSeconds != 600 {
+		t.Errorf("defaults not applied: %+v", resolved)
+	}
+	for key, want := range map[string]string{
+		"maxConcurrentJobs":   configSourceEnv,
+		"jobQueueSize":        configSourceConfig,
+		"drainTimeoutSeconds": configSourceDefault,
+	} {
+		if sources[key] != want {
+			t.Errorf("source of %s = %s, want %s", key, sources[key], want)
+		}
+	}
+	// The map carries the resolved values to the code reading it directly
+	if configInt(effective, "maxConcurrentJobs", 0) != 3 || effective["auth"] == nil || config["maxConcurrentJobs"] != 20 {
+		t.Errorf("effective map = %v", effective)
+	}
+	if sections := configSections(effective); !reflect.DeepEqual(sections, []string{"auth"}) {
+		t.Errorf("sections = %v", sections)
+	}
+
+	noEnv := func(string) (string, bool) { return "", false }
+	for _, tt := range []struct {
+		name   string
+		config map[string]interface{}
+		env    map[string]string
+		want   string
+	}{
+		{name: "wrong type", config: map[string]interface{}{"maxConcurrentJobs": "many"}, want: "invalid config maxConcurrentJobs"},
+		{name: "fractional", config: map[string]interface{}{"jobQueueSize": 1.5}, want: "must be an integer"},
+		{name: "bad env", env: map[string]string{"KS_PLUGIN_MONITOR_TOKENS": "sometimes"}, want: "invalid KS_PLUGIN_MONITOR_TOKENS"},
+		{name: "mode", config: map[string]interface{}{"mode": "staging"}, want: "invalid mode"},
+		{name: "detach safety", env: map[string]string{"KS_PLUGIN_DETACH_SAFETY": "maybe"}, want: "detachSafety must be"},
+		{name: "negative", config: map[string]interface{}{"jobQueueSize": -1}, want: "jobQueueSize must not be negative"},
+		{name: "drain timeout", config: map[string]interface{}{"drainTimeoutSeconds": 0}, want: "drainTimeoutSeconds must be positive"},
+		{name: "sample ratio", config: map[string]interface{}{"tracingSampleRatio": 2}, want: "tracingSampleRatio"},
+		{name: "host token", config: map[string]interface{}{"enforcePermissions": true}, want: "requires a hostToken"},
+		{name: "scenario", config: map[string]interface{}{"mockScenario": "fleet.yaml"}, want: "mockScenario requires mode"},
+	} {
+		lookup := noEnv
+		if tt.env != nil {
+			lookup = func(name string) (string, bool) {
+				value, ok := tt.env[name]
+				return value, ok
+			}
+		}
+		if _, _, _, err := resolvePluginConfig(tt.config, "/data", lookup); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestGetEffectiveSettings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("KS_PLUGIN_JOB_QUEUE_SIZE", "40")
+	previousKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32))
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode": modeMock, "mockFleetSize": 0, "mockStepDelayMillis": 0,
+		"auditWebhookSecret": "hunter2", "previousEncryptionKeys": []string{previousKey},
+	})
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	if plugin.jobs.PoolStats().QueueSize != 40 {
+		t.Errorf("queue size = %d, want the environment override", plugin.jobs.PoolStats().QueueSize)
+	}
+
+	request := httptest.NewRequest(http.MethodPut, "/settings", strings.NewReader(`{"maxConcurrentJobs": 2}`))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/settings/effective", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /settings/effective = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), "hunter2") || strings.Contains(recorder.Body.String(), previousKey) {
+		t.Errorf("secrets leaked: %s", recorder.Body.String())
+	}
+	var response struct {
+		Config   PluginConfig      `json:"config"`
+		Sources  map[string]string `json:"sources"`
+		Sections []string          `json:"sections"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if response.Config.AuditWebhookSecret != redactedValue || response.Config.Mode != modeMock {
+		t.Errorf("config = %+v", response.Config)
+	}
+	if response.Config.MaxConcurrentJobs != 2 || response.Sources["maxConcurrentJobs"] != configSourceRuntime {
+		t.Errorf("maxConcurrentJobs = %d from %s, want the runtime setting", response.Config.MaxConcurrentJobs, response.Sources["maxConcurrentJobs"])
+	}
+	if response.Config.JobQueueSize != 40 || response.Sources["jobQueueSize"] != configSourceEnv {
+		t.Errorf("jobQueueSize = %d from %s, want the environment override", response.Config.JobQueueSize, response.Sources["jobQueueSize"])
+	}
+	if response.Sources["mode"] != configSourceConfig || response.Sources["logFormat"] != configSourceDefault {
+		t.Errorf("sources = %v", response.Sources)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rules an onboarding can violate
+const (
+	policyRuleNamePattern      = "namePattern"
+	policyRuleRequiredLabel    = "requiredLabel"
+	policyRuleBlockedProvider  = "blockedProvider"
+	policyRuleGroupQuota       = "groupQuota"
+	policyRuleEnvironmentQuota = "environmentQuota"
+)
+
+// OnboardingPolicy is the onboardingPolicy section of the Initialize config.
+// Every onboarding, whichever endpoint it comes through, is checked against it.
+type OnboardingPolicy struct {
+	// NamePatterns are regular expressions one of which must match the
+	// whole cluster name
+	NamePatterns []string `json:"namePatterns,omitempty"`
+	// RequiredLabels are the label keys every cluster must be onboarded with
+	RequiredLabels []string `json:"requiredLabels,omitempty"`
+	// BlockedProviders refuses clusters fetched from or provisioned by these
+	// providers, such as aks or kind
+	BlockedProviders []string `json:"blockedProviders,omitempty"`
+	// MaxClustersPerGroup caps the members of the named groups, and
+	// MaxClustersPerEnvironment those of all the groups of an environment
+	MaxClustersPerGroup       map[string]int `json:"maxClustersPerGroup,omitempty"`
+	MaxClustersPerEnvironment map[string]int `json:"maxClustersPerEnvironment,omitempty"`
+
+	namePatterns []*regexp.Regexp
+}
+
+func onboardingPolicyFromConfig(config map[string]interface{}) (*OnboardingPolicy, error) {
+	raw, ok := config["onboardingPolicy"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid onboardingPolicy config: %w", err)
+	}
+	var policy OnboardingPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid onboardingPolicy config: %w", err)
+	}
+	for _, pattern := range policy.NamePatterns {
+		compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid onboardingPolicy name pattern %q: %w", pattern, err)
+		}
+		policy.namePatterns = append(policy.namePatterns, compiled)
+	}
+	for _, limits := range []map[string]int{policy.MaxClustersPerGroup, policy.MaxClustersPerEnvironment} {
+		for name, limit := range limits {
+			if limit < 0 {
+				return nil, fmt.Errorf("invalid onboardingPolicy limit for %s: must not be negative", name)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// PolicyViolation is one rule an onboarding breaks
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyViolationError rejects an onboarding that breaks the policy
+type PolicyViolationError struct {
+	ClusterName string
+	Violations  []PolicyViolation
+}
+
+func (e *PolicyViolationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return fmt.Sprintf("cluster '%s' violates the onboarding policy: %s", e.ClusterName, strings.Join(messages, "; "))
+}
+
+// check lists the rules onboarding candidate from provider breaks, given the
+// clusters already recorded and the defined groups
+func (p *OnboardingPolicy) check(candidate ClusterStatus, provider string, clusters []ClusterStatus, groups []ClusterGroup) []PolicyViolation {
+	var violations []PolicyViolation
+	if len(p.namePatterns) > 0 {
+		matched := false
+		for _, pattern := range p.namePatterns {
+			matched = matched || pattern.MatchString(candidate.ClusterName)
+		}
+		if !matched {
+			violations = append(violations, PolicyViolation{policyRuleNamePattern,
+				fmt.Sprintf("name doesn't match any allowed pattern (%s)", strings.Join(p.NamePatterns, ", "))})
+		}
+	}
+	for _, key := range p.RequiredLabels {
+		if _, ok := candidate.Labels[key]; !ok {
+			violations = append(violations, PolicyViolation{policyRuleRequiredLabel, fmt.Sprintf("label %s is required", key)})
+		}
+	}
+	if provider != "" && containsString(p.BlockedProviders, provider) {
+		violations = append(violations, PolicyViolation{policyRuleBlockedProvider, fmt.Sprintf("provider %s is blocked", provider)})
+	}
+
+	// A cluster counts once towards an environment however many of its groups it is in
+	environments := make(map[string][]ClusterGroup)
+	for _, group := range groups {
+		if !group.matches(candidate) {
+			continue
+		}
+		if group.Environment != "" {
+			environments[group.Environment] = append(environments[group.Environment], group)
+		}
+		if limit, ok := p.MaxClustersPerGroup[group.Name]; ok {
+			if members := countMembers(clusters, []ClusterGroup{group}); members >= limit {
+				violations = append(violations, PolicyViolation{policyRuleGroupQuota,
+					fmt.Sprintf("group %s already holds %d of its %d clusters", group.Name, members, limit)})
+			}
+		}
+	}
+	names := make([]string, 0, len(environments))
+	for environment := range environments {
+		names = append(names, environment)
+	}
+	sort.Strings(names)
+	for _, environment := range names {
+		limit, ok := p.MaxClustersPerEnvironment[environment]
+		if !ok {
+			continue
+		}
+		var environmentGroups []ClusterGroup
+		for _, group := range groups {
+			if group.Environment == environment {
+				environmentGroups = append(environmentGroups, group)
+			}
+		}
+		if members := countMembers(clusters, environmentGroups); members >= limit {
+			violations = append(violations, PolicyViolation{policyRuleEnvironmentQuota,
+				fmt.Sprintf("environment %s already holds %d of its %d clusters", environment, members, limit)})
+		}
+	}
+	return violations
+}
+
+// countMembers counts the clusters belonging to any of groups
+func countMembers(clusters []ClusterStatus, groups []ClusterGroup) int {
+	count := 0
+	for _, cluster := range clusters {
+		for _, group := range groups {
+			if group.matches(cluster) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// providerName is the name of the provider a cluster comes from, if any
+func providerName(spec *ProviderSpec) string {
+	if spec == nil {
+		return ""
+	}
+	return spec.Name
+}
+
+// checkOnboardingPolicy returns a PolicyViolationError when onboarding the
+// cluster with labels from provider breaks the onboarding policy
+func (cp *ClusterPlugin) checkOnboardingPolicy(clusterName, provider string, labels map[string]string) error {
+	if cp.onboardingPolicy == nil {
+		return nil
+	}
+	clusters, err := cp.selectClusters("")
+	if err != nil {
+		return err
+	}
+	candidate := ClusterStatus{ClusterName: clusterName, Labels: labels}
+	violations := cp.onboardingPolicy.check(candidate, provider, clusters, cp.groups.List())
+	if len(violations) == 0 {
+		return nil
+	}
+	return &PolicyViolationError{ClusterName: clusterName, Violations: violations}
+}
+
+// respondPolicyViolation answers with the violation report when err rejects
+// an onboarding, reporting whether it did
+func respondPolicyViolation(c *gin.Context, err error) bool {
+	var violation *PolicyViolationError
+	if !errors.As(err, &violation) {
+		return false
+	}
+	respondProblem(c, http.StatusUnprocessableEntity, CodePolicyViolation, violation.Error(), "violations", violation.Violations)
+	return true
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOnboardingPolicyCheck(t *testing.T) {
+	policy, err := onboardingPolicyFromConfig(map[string]interface{}{"onboardingPolicy": map[string]interface{}{
+		"namePatterns":              []string{"edge-[0-9]+", "ci-.*"},
+		"requiredLabels":            []string{"team"},
+		"blockedProviders":          []string{"kind"},
+		"maxClustersPerGroup":       map[string]int{"edge": 2},
+		"maxClustersPerEnvironment": map[string]int{"prod": 2},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := []ClusterGroup{
+		{Name: "edge", Environment: "prod", Selector: "tier=edge"},
+		{Name: "core", Environment: "prod", Clusters: []string{"core-1"}},
+	}
+	clusters := []ClusterStatus{
+		{ClusterName: "edge-1", Labels: map[string]string{"tier": "edge"}},
+		{ClusterName: "core-1"},
+	}
+	rules := func(violations []PolicyViolation) string {
+		var names []string
+		for _, violation := range violations {
+			names = append(names, violation.Rule)
+		}
+		return strings.Join(names, ",")
+	}
+
+	for name, tc := range map[string]struct {
+		candidate ClusterStatus
+		provider  string
+		want      string
+	}{
+		"allowed":             {ClusterStatus{ClusterName: "ci-7", Labels: map[string]string{"team": "infra"}}, "eks", ""},
+		"pattern is anchored": {ClusterStatus{ClusterName: "my-edge-1", Labels: map[string]string{"team": "infra"}}, "", "namePattern"},
+		"everything wrong":    {ClusterStatus{ClusterName: "prod-1"}, "kind", "namePattern,requiredLabel,blockedProvider"},
+		"environment full":    {ClusterStatus{ClusterName: "edge-2", Labels: map[string]string{"team": "infra", "tier": "edge"}}, "", "environmentQuota"},
+	} {
+		if got := rules(policy.check(tc.candidate, tc.provider, clusters, groups)); got != tc.want {
+			t.Errorf("%s: violations = %q, want %q", name, got, tc.want)
+		}
+	}
+
+	// One more edge cluster fills the group too
+	clusters = append(clusters, ClusterStatus{ClusterName: "edge-3", Labels: map[string]string{"tier": "edge"}})
+	candidate := ClusterStatus{ClusterName: "edge-4", Labels: map[string]string{"team": "infra", "tier": "edge"}}
+	if got := rules(policy.check(candidate, "", clusters, groups)); got != "groupQuota,environmentQuota" {
+		t.Errorf("full group violations = %q", got)
+	}
+
+	for name, config := range map[string]interface{}{
+		"bad pattern":    map[string]interface{}{"namePatterns": []string{"edge-("}},
+		"negative limit": map[string]interface{}{"maxClustersPerGroup": map[string]int{"edge": -1}},
+		"wrong type":     map[string]interface{}{"requiredLabels": "team"},
+	} {
+		if _, err := onboardingPolicyFromConfig(map[string]interface{}{"onboardingPolicy": config}); err == nil {
+			t.Errorf("%s: onboardingPolicyFromConfig() accepted", name)
+		}
+	}
+}
+
+func TestOnboardingPolicyEnforced(t *testing.T) {
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"onboardingPolicy": map[string]interface{}{
+		"requiredLabels":      []string{"team"},
+		"maxClustersPerGroup": map[string]int{"edge": 1},
+	}})
+	if _, err := plugin.groups.Create(GroupRequest{Name: "edge", Environment: "prod", Selector: "tier=edge"}); err != nil {
+		t.Fatal(err)
+	}
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Ready", Labels: map[string]string{"tier": "edge", "team": "infra"}})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/onboard", plugin.OnboardClusterHandler)
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/onboard", strings.NewReader(`{"clusterName": "edge-2", "labels": {"tier": "edge"}}`))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+
+	var problem struct {
+		Code       ErrorCode         `json:"code"`
+		Violations []PolicyViolation `json:"violations"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &problem)
+	if recorder.Code != http.StatusUnprocessableEntity || problem.Code != CodePolicyViolation || len(problem.Violations) != 2 {
+		t.Errorf("onboard = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Every path into onboarding is checked, not only the handlers
+	_, _, err := plugin.beginOnboarding(context.Background(), "edge-2", builtinHub.Name, "", "", map[string]string{"tier": "edge", "team": "infra"}, nil)
+	var violation *PolicyViolationError
+	if !errors.As(err, &violation) || violation.Violations[0].Rule != policyRuleGroupQuota {
+		t.Errorf("beginOnboarding() error = %v", err)
+	}
+	if _, exists, _ := plugin.store.Get("edge-2"); exists {
+		t.Error("rejected cluster was recorded")
+	}
+	jobID, _, err := plugin.beginOnboarding(context.Background(), "core-1", builtinHub.Name, "", "", map[string]string{"team": "infra"}, nil)
+	if err != nil {
+		t.Fatalf("beginOnboarding() of an allowed cluster error = %v", err)
+	}
+	plugin.jobs.Run(jobID, func(context.Context) error { return nil })
+	waitForJob(t, plugin.jobs, jobID, func(job Job) bool { return job.State == JobSucceeded })
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errJobQueueFull is returned when no job can be admitted until others finish
+var errJobQueueFull = errors.New("job queue is full, retry later")
+
+// PoolStats describes the worker pool jobs run in
+type PoolStats struct {
+	// MaxConcurrency is how many jobs run at once; 0 means no limit
+	MaxConcurrency int `json:"maxConcurrency"`
+	// QueueSize is how many jobs may wait for a worker
+	QueueSize int `json:"queueSize"`
+	Running   int `json:"running"`
+	Queued    int `json:"queued"`
+	// Completed and Rejected count the jobs that released a worker and
+	// those refused because the queue was full
+	Completed uint64 `json:"completed"`
+	Rejected  uint64 `json:"rejected"`
+	// AverageWaitSeconds is how long jobs waited for a worker on average
+	AverageWaitSeconds float64 `json:"averageWaitSeconds"`
+}
+
+// poolWaiter is a job waiting for a worker
+type poolWaiter struct {
+	id       string
+	ready    chan struct{}
+	queuedAt time.Time
+}
+
+// jobPool bounds how many jobs run at once, handing workers to the waiting
+// jobs in the order they arrived
+type jobPool struct {
+	concurrency int
+	queueSize   int
+
+	mutex     sync.Mutex
+	running   int
+	waiting   []*poolWaiter
+	started   uint64
+	completed uint64
+	rejected  uint64
+	waitTotal time.Duration
+}
+
+// newJobPool creates a pool of concurrency workers with room for queueSize
+// waiting jobs. A concurrency of 0 or less runs every job right away.
+func newJobPool(concurrency, queueSize int) *jobPool {
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &jobPool{concurrency: concurrency, queueSize: queueSize}
+}
+
+// admit decides whether a new job fits next to the active ones
+func (p *jobPool) admit(active int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.concurrency > 0 && active >= p.concurrency+p.queueSize {
+		p.rejected++
+		return errJobQueueFull
+	}
+	return nil
+}
+
+// acquire waits for a worker, reporting false if ctx ended first
+func (p *jobPool) acquire(ctx context.Context, id string) bool {
+	p.mutex.Lock()
+	if p.concurrency <= 0 || (p.running < p.concurrency && len(p.waiting) == 0) {
+		p.running++
+		p.started++
+		p.mutex.Unlock()
+		return true
+	}
+	waiter := &poolWaiter{id: id, ready: make(chan struct{}), queuedAt: time.Now()}
+	p.waiting = append(p.waiting, waiter)
+	p.mutex.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return true
+	case <-ctx.Done():
+	}
+
+	p.mutex.Lock()
+	for i, w := range p.waiting {
+		if w == waiter {
+			p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+			p.mutex.Unlock()
+			return false
+		}
+	}
+	p.mutex.Unlock()
+	// The worker was handed over as the context ended
+	p.release()
+	return false
+}
+
+// release returns a worker, handing it to the longest waiting job unless
+// the pool was shrunk below the jobs running
+func (p *jobPool) release() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.completed++
+	if len(p.waiting) == 0 || (p.concurrency > 0 && p.running > p.concurrency) {
+		p.running--
+		return
+	}
+	next := p.waiting[0]
+	p.waiting = p.waiting[1:]
+	p.started++
+	p.waitTotal += time.Since(next.queuedAt)
+	close(next.ready)
+}
+
+// resize changes the number of workers and the room for waiting jobs,
+// handing the workers added to the longest waiting jobs. Shrinking doesn't
+// stop running jobs; their workers go away as they finish.
+func (p *jobPool) resize(concurrency, queueSize int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.concurrency, p.queueSize = concurrency, queueSize
+	for len(p.waiting) > 0 && (p.concurrency <= 0 || p.running < p.concurrency) {
+		next := p.waiting[0]
+		p.waiting = p.waiting[1:]
+		p.running++
+		p.started++
+		p.waitTotal += time.Since(next.queuedAt)
+		close(next.ready)
+	}
+}
+
+// position returns where a pending job waits for a worker, 0 if one is free
+// for it. A job that hasn't reached the queue yet will join at its end.
+func (p *jobPool) position(id string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i, w := range p.waiting {
+		if w.id == id {
+			return i + 1
+		}
+	}
+	if p.concurrency > 0 && p.running >= p.concurrency {
+		return len(p.waiting) + 1
+	}
+	return 0
+}
+
+// Stats returns a snapshot of the pool counters
+func (p *jobPool) Stats() PoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := PoolStats{
+		MaxConcurrency: p.concurrency,
+		QueueSize:      p.queueSize,
+		Running:        p.running,
+		Queued:         len(p.waiting),
+		Completed:      p.completed,
+		Rejected:       p.rejected,
+	}
+	if p.started > 0 {
+		stats.AverageWaitSeconds = p.waitTotal.Seconds() / float64(p.started)
+	}
+	return stats
+}
+
+// queuePosition returns where a job just started waits for a worker
+func (cp *ClusterPlugin) queuePosition(jobID string) int {
+	job, _ := cp.jobs.Get(jobID)
+	return job.QueuePosition
+}
+
+// respondQueueFull answers a request whose job couldn't be admitted with 503
+// Service Unavailable, a Retry-After header and the state of the queue
+func (cp *ClusterPlugin) respondQueueFull(c *gin.Context) {
+	stats := cp.jobs.PoolStats()
+	requestLogger(c).Warn("Job queue is full", "running", stats.Running, "queued", stats.Queued)
+	c.Header("Retry-After", strconv.Itoa(cp.queueRetryAfter))
+	respondProblem(c, http.StatusServiceUnavailable, CodeQueueFull, errJobQueueFull.Error(),
+		"queuePosition", stats.Queued+1, "pool", stats)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func waitForJob(t *testing.T, jm *JobManager, id string, check func(Job) bool) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, _ := jm.Get(id)
+		if check(job) {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s with queue position %d", id, job.State, job.QueuePosition)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitForQueued(t *testing.T, jm *JobManager, queued int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for jm.PoolStats().Queued != queued {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d jobs queued, want %d", jm.PoolStats().Queued, queued)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobPool(t *testing.T) {
+	jm := NewJobManager(nil, 0)
+	jm.SetPool(1, 2)
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	order := make(chan string, 3)
+	body := func(name string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			order <- name
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			return nil
+		}
+	}
+
+	first := jm.Create(ctx, "onboard", "edge-1")
+	jm.Run(first.ID, body("edge-1"))
+	if <-order != "edge-1" {
+		t.Fatal("first job didn't start")
+	}
+	second := jm.Create(ctx, "onboard", "edge-2")
+	jm.Run(second.ID, body("edge-2"))
+	waitForQueued(t, jm, 1)
+	third := jm.Create(ctx, "onboard", "edge-3")
+	jm.Run(third.ID, body("edge-3"))
+	waitForQueued(t, jm, 2)
+	if job, _ := jm.Get(third.ID); job.QueuePosition != 2 {
+		t.Errorf("third job queue position = %d, want 2", job.QueuePosition)
+	}
+
+	if err := jm.Admit(); !errors.Is(err, errJobQueueFull) {
+		t.Fatalf("Admit() with a full queue error = %v", err)
+	}
+	if stats := jm.PoolStats(); stats.Running != 1 || stats.Queued != 2 || stats.Rejected != 1 {
+		t.Errorf("PoolStats() = %+v", stats)
+	}
+
+	// A job cancelled while queued gives up its place
+	if err := jm.Cancel(second.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, jm, third.ID, func(job Job) bool { return job.QueuePosition == 1 })
+	if err := jm.Admit(); err != nil {
+		t.Errorf("Admit() after a cancellation error = %v", err)
+	}
+
+	close(release)
+	for _, want := range []string{"edge-2", "edge-3"} {
+		if got := <-order; got != want {
+			t.Errorf("job %s started, want %s", got, want)
+		}
+	}
+	waitForJob(t, jm, third.ID, func(job Job) bool { return job.State == JobSucceeded })
+	if job, _ := jm.Get(second.ID); job.State != JobCancelled {
+		t.Errorf("queued job cancelled ended as %s", job.State)
+	}
+	if stats := jm.PoolStats(); stats.Running != 0 || stats.Queued != 0 || stats.Completed != 2 {
+		t.Errorf("PoolStats() when idle = %+v", stats)
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"maxConcurrentJobs":         1,
+		"jobQueueSize":              0,
+		"jobQueueRetryAfterSeconds": 15,
+	})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	release := make(chan struct{})
+	defer close(release)
+	busy := plugin.jobs.Create(context.Background(), "onboard", "busy")
+	plugin.jobs.Run(busy.ID, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	router := gin.New()
+	router.POST("/detach", plugin.DetachClusterHandler)
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/detach", strings.NewReader(`{"clusterName": "edge-1"}`))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "15" {
+		t.Fatalf("detach with a full queue = %d, Retry-After %q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+	var response struct {
+		QueuePosition int       `json:"queuePosition"`
+		Pool          PoolStats `json:"pool"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.QueuePosition != 1 || response.Pool.MaxConcurrency != 1 || response.Pool.Rejected != 1 {
+		t.Errorf("queue full response = %+v", response)
+	}
+	if status, _, _ := plugin.store.Get("edge-1"); status.Status != "Ready" {
+		t.Errorf("rejected detach changed the cluster status to %s", status.Status)
+	}
+}
+
+func TestJobPoolResize(t *testing.T) {
+	pool := newJobPool(1, 5)
+	// Cancelled at the end to release the job still waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !pool.acquire(ctx, "first") {
+		t.Fatal("acquire() with a free worker failed")
+	}
+	acquired := make(chan string, 2)
+	for i, id := range []string{"second", "third"} {
+		id := id
+		go func() {
+			if pool.acquire(ctx, id) {
+				acquired <- id
+			}
+		}()
+		for pool.Stats().Queued != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Growing the pool hands the new workers to the waiting jobs
+	pool.resize(3, 5)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-acquired:
+		case <-time.After(5 * time.Second):
+			t.Fatal("waiting job didn't get a worker after resize")
+		}
+	}
+	if stats := pool.Stats(); stats.Running != 3 || stats.Queued != 0 || stats.MaxConcurrency != 3 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	// Shrinking the pool retires workers as the running jobs finish, rather
+	// than handing them to the waiting jobs
+	for i, id := range []string{"fourth", "fifth"} {
+		id := id
+		go func() {
+			if pool.acquire(ctx, id) {
+				acquired <- id
+			}
+		}()
+		for pool.Stats().Queued != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	pool.resize(1, 5)
+	pool.release()
+	pool.release()
+	if stats := pool.Stats(); stats.Running != 1 || stats.Queued != 2 {
+		t.Errorf("Stats() after shrinking = %+v, want 1 running and 2 queued", stats)
+	}
+	select {
+	case id := <-acquired:
+		t.Fatalf("%s got a worker above the new limit", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+	// Below the limit again, the next release goes to the longest waiting job
+	pool.release()
+	select {
+	case id := <-acquired:
+		if id != "fourth" {
+			t.Errorf("%s got the worker, want fourth", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting job didn't get a worker once the pool was back under its limit")
+	}
+	if stats := pool.Stats(); stats.Running != 1 || stats.Queued != 1 {
+		t.Errorf("Stats() = %+v, want 1 running and 1 queued", stats)
+	}
+}
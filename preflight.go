@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DependencyCheck is the preflight result for a single external dependency
+type DependencyCheck struct {
+	Name       string `json:"name"`
+	Available  bool   `json:"available"`
+	Path       string `json:"path,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Constraint string `json:"constraint,omitempty"`
+	Compatible bool   `json:"compatible"`
+	Error      string `json:"error,omitempty"`
+}
+
+// PreflightReport collects the dependency checks run during Initialize
+type PreflightReport struct {
+	Checks    []DependencyCheck `json:"checks"`
+	Passed    bool              `json:"passed"`
+	CheckedAt string            `json:"checkedAt"`
+}
+
+// Failures returns the checks that did not pass
+func (r PreflightReport) Failures() []DependencyCheck {
+	var failures []DependencyCheck
+	for _, check := range r.Checks {
+		if !check.Available || !check.Compatible {
+			failures = append(failures, check)
+		}
+	}
+	return failures
+}
+
+// Check returns the check of a dependency, if it was probed
+func (r PreflightReport) Check(name string) (DependencyCheck, bool) {
+	for _, check := range r.Checks {
+		if check.Name == name {
+			return check, true
+		}
+	}
+	return DependencyCheck{}, false
+}
+
+// versionCommands lists how to ask each known dependency for its version
+var versionCommands = map[string][]string{
+	"kubectl":    {"version", "--client"},
+	"clusteradm": {"version"},
+	"git":        {"version"},
+	"helm":       {"version", "--short"},
+}
+
+var versionPattern = regexp.MustCompile(`v?(\d+\.\d+(\.\d+)?)`)
+
+// runPreflight probes every declared dependency and checks it, as well as the
+// Go runtime, against the metadata compatibility constraints
+func runPreflight(ctx context.Context, metadata PluginMetadata) PreflightReport {
+	report := PreflightReport{
+		Passed:    true,
+		CheckedAt: time.Now().Format(time.RFC3339),
+	}
+
+	for _, dependency := range metadata.Dependencies {
+		check := probeDependency(ctx, dependency, metadata.Compatibility[dependency])
+		if !check.Available || !check.Compatible {
+			report.Passed = false
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	if constraint, ok := metadata.Compatibility["go"]; ok {
+		check := DependencyCheck{
+			Name:       "go",
+			Available:  true,
+			Version:    strings.TrimPrefix(runtime.Version(), "go"),
+			Constraint: constraint,
+		}
+		check.Compatible, check.Error = evaluateConstraint(check.Version, constraint)
+		if !check.Compatible {
+			report.Passed = false
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	return report
+}
+
+// checkDependencies runs the preflight of the plugin metadata and, when
+// admission is configured, of the OPA server the policies are served by
+func (cp *ClusterPlugin) checkDependencies(ctx context.Context, metadata PluginMetadata) PreflightReport {
+	report := runPreflight(ctx, metadata)
+	if cp.admission != nil {
+		check := cp.admission.probe(ctx)
+		if !check.Available || !check.Compatible {
+			report.Passed = false
+		}
+		report.Checks = append(report.Checks, check)
+	}
+	return report
+}
+
+func probeDependency(ctx context.Context, name, constraint string) DependencyCheck {
+	check := DependencyCheck{Name: name, Constraint: constraint}
+
+	path, err := exec.LookPath(name)
+	if err != nil {
+		check.Error = fmt.Sprintf("not found in PATH: %v", err)
+		return check
+	}
+	check.Available = true
+	check.Path = path
+
+	args, known := versionCommands[name]
+	if !known {
+		args = []string{"--version"}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if match := versionPattern.FindStringSubmatch(string(output)); match != nil {
+		check.Version = match[1]
+	} else if err != nil {
+		check.Error = fmt.Sprintf("failed to determine version: %v", err)
+	}
+
+	if constraint == "" {
+		check.Compatible = true
+		return check
+	}
+	if check.Version == "" {
+		check.Error = "version unknown, cannot verify constraint " + constraint
+		return check
+	}
+	check.Compatible, check.Error = evaluateConstraint(check.Version, constraint)
+	return check
+}
+
+// evaluateConstraint checks a version against a constraint such as ">=1.21"
+// or "^0.21 || ^1.0" and returns a description of the problem when it isn't satisfied
+func evaluateConstraint(current, constraint string) (bool, string) {
+	ok, err := satisfiesConstraint(current, constraint)
+	if err != nil {
+		return false, err.Error()
+	}
+	if !ok {
+		return false, fmt.Sprintf("version %s does not satisfy %s", current, constraint)
+	}
+	return true, ""
+}
+
+func satisfiesConstraint(current, constraint string) (bool, error) {
+	have, err := parseVersion(current)
+	if err != nil {
+		return false, fmt.Errorf("invalid version %q: %w", current, err)
+	}
+	allowed, err := parseVersionRange(constraint)
+	if err != nil {
+		return false, fmt.Errorf("invalid constraint %q: %w", constraint, err)
+	}
+	return allowed.allows(have), nil
+}
+
+// GetPreflightHandler returns the dependency preflight report, re-running the
+// checks when ?refresh=true is given
+func (cp *ClusterPlugin) GetPreflightHandler(c *gin.Context) {
+	if c.Query("refresh") == "true" {
+		report := cp.checkDependencies(c.Request.Context(), cp.GetMetadata())
+		if cp.abortOnContext(c) {
+			return
+		}
+		cp.mutex.Lock()
+		cp.preflight = report
+		cp.mutex.Unlock()
+	}
+
+	cp.mutex.RLock()
+	report := cp.preflight
+	cp.mutex.RUnlock()
+
+	status := http.StatusOK
+	if !report.Passed {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"preflight": report,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
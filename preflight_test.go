@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSatisfiesConstraint(t *testing.T) {
+	tests := []struct {
+		current    string
+		constraint string
+		want       bool
+		wantErr    bool
+	}{
+		{"1.28.3", ">=1.28.0", true, false},
+		{"v1.27.9", ">=1.28.0", false, false},
+		{"1.28.0", ">1.28.0", false, false},
+		{"1.29", "> 1.28.0", true, false},
+		{"0.8.1", "<0.9", true, false},
+		{"0.9.0", "<=0.9.0", true, false},
+		{"1.21.0", "!=1.21.0", false, false},
+		{"1.21.0", "1.21.0", true, false},
+		{"1.21.0", "=1.21.1", false, false},
+		{"unknown", ">=1.0", false, true},
+		{"1.0.0", ">=latest", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.current+" "+tt.constraint, func(t *testing.T) {
+			got, err := satisfiesConstraint(tt.current, tt.constraint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("satisfiesConstraint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("satisfiesConstraint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateConstraint(t *testing.T) {
+	tests := []struct {
+		current    string
+		constraint string
+		want       bool
+		wantReason string
+	}{
+		{"1.28.0", ">=1.28.0", true, ""},
+		{"1.27.0", ">=1.28.0", false, "version 1.27.0 does not satisfy >=1.28.0"},
+		{"bogus", ">=1.28.0", false, `invalid version "bogus"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.current, func(t *testing.T) {
+			ok, reason := evaluateConstraint(tt.current, tt.constraint)
+			if ok != tt.want {
+				t.Fatalf("evaluateConstraint() = %v, want %v", ok, tt.want)
+			}
+			if !strings.HasPrefix(reason, tt.wantReason) {
+				t.Errorf("evaluateConstraint() reason = %q, want prefix %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// problemContentType is the media type of RFC 7807 problem details, the body
+// of every failed request
+const problemContentType = "application/problem+json"
+
+// jsonAPIContentType is the JSON:API media type. Clients that accept it get
+// failures as a JSON:API errors document instead.
+const jsonAPIContentType = "application/vnd.api+json"
+
+// problemTypePrefix namespaces the problem type URIs, which end with the code
+const problemTypePrefix = "urn:kubestellar-cluster-plugin:problem:"
+
+// ErrorCode tells why a request failed. Clients should switch on the code
+// rather than on the status or the wording of the detail.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest       ErrorCode = "INVALID_REQUEST"
+	CodeValidationFailed     ErrorCode = "VALIDATION_FAILED"
+	CodeInvalidKubeconfig    ErrorCode = "INVALID_KUBECONFIG"
+	CodeContextRequired      ErrorCode = "CONTEXT_REQUIRED"
+	CodePayloadTooLarge      ErrorCode = "PAYLOAD_TOO_LARGE"
+	CodeUnauthenticated      ErrorCode = "UNAUTHENTICATED"
+	CodeTokenExpired         ErrorCode = "TOKEN_EXPIRED"
+	CodePermissionDenied     ErrorCode = "PERMISSION_DENIED"
+	CodeOriginNotAllowed     ErrorCode = "ORIGIN_NOT_ALLOWED"
+	CodeClusterNotFound      ErrorCode = "CLUSTER_NOT_FOUND"
+	CodeHubNotFound          ErrorCode = "HUB_NOT_FOUND"
+	CodeNotFound             ErrorCode = "NOT_FOUND"
+	CodeClusterExists        ErrorCode = "CLUSTER_EXISTS"
+	CodeClusterBusy          ErrorCode = "CLUSTER_BUSY"
+	CodeClusterInUse         ErrorCode = "CLUSTER_IN_USE"
+	CodeHubExists            ErrorCode = "HUB_EXISTS"
+	CodeHubInUse             ErrorCode = "HUB_IN_USE"
+	CodeConflict             ErrorCode = "CONFLICT"
+	CodeIdempotencyKeyReused ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+	CodeNotRepairable        ErrorCode = "NOT_REPAIRABLE"
+	CodePolicyViolation      ErrorCode = "POLICY_VIOLATION"
+	CodeAdmissionDenied      ErrorCode = "ADMISSION_DENIED"
+	CodeTenantRequired       ErrorCode = "TENANT_REQUIRED"
+	CodeHubUnreachable       ErrorCode = "HUB_UNREACHABLE"
+	CodeClusterUnreachable   ErrorCode = "CLUSTER_UNREACHABLE"
+	CodeRateLimited          ErrorCode = "RATE_LIMITED"
+	CodeQueueFull            ErrorCode = "QUEUE_FULL"
+	CodeUnavailable          ErrorCode = "UNAVAILABLE"
+	CodeNotLeader            ErrorCode = "NOT_LEADER"
+	CodeTimeout              ErrorCode = "TIMEOUT"
+	CodeSettingsLocked       ErrorCode = "SETTINGS_LOCKED"
+	CodeStoreError           ErrorCode = "STORE_ERROR"
+	CodeInternal             ErrorCode = "INTERNAL_ERROR"
+)
+
+// problemTitles are the short, unchanging summaries of each code
+var problemTitles = map[ErrorCode]string{
+	CodeInvalidRequest:       "Invalid request",
+	CodeValidationFailed:     "Request body failed validation",
+	CodeInvalidKubeconfig:    "Invalid kubeconfig",
+	CodeContextRequired:      "Kubeconfig context required",
+	CodePayloadTooLarge:      "Upload too large",
+	CodeUnauthenticated:      "Authentication required",
+	CodeTokenExpired:         "Token expired",
+	CodePermissionDenied:     "Permission denied",
+	CodeOriginNotAllowed:     "Origin not allowed",
+	CodeClusterNotFound:      "Cluster not found",
+	CodeHubNotFound:          "Hub not found",
+	CodeNotFound:             "Resource not found",
+	CodeClusterExists:        "Cluster already onboarded",
+	CodeClusterBusy:          "Cluster busy",
+	CodeClusterInUse:         "Cluster serves workloads",
+	CodeHubExists:            "Hub already registered",
+	CodeHubInUse:             "Hub in use",
+	CodeConflict:             "Conflicting request",
+	CodeIdempotencyKeyReused: "Idempotency key reused",
+	CodeNotRepairable:        "Cluster not repairable",
+	CodePolicyViolation:      "Onboarding policy violated",
+	CodeAdmissionDenied:      "Denied by admission policy",
+	CodeTenantRequired:       "Tenant required",
+	CodeHubUnreachable:       "Hub unreachable",
+	CodeClusterUnreachable:   "Cluster unreachable",
+	CodeRateLimited:          "Rate limit exceeded",
+	CodeQueueFull:            "Job queue full",
+	CodeUnavailable:          "Service unavailable",
+	CodeNotLeader:            "Not the leader replica",
+	CodeTimeout:              "Request timed out",
+	CodeSettingsLocked:       "Settings are read-only",
+	CodeStoreError:           "Cluster store error",
+	CodeInternal:             "Internal error",
+}
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type     string    `json:"type"`
+	Title    string    `json:"title"`
+	Status   int       `json:"status"`
+	Detail   string    `json:"detail,omitempty"`
+	Instance string    `json:"instance,omitempty"`
+	Code     ErrorCode `json:"code"`
+	// Error repeats Detail for clients written against the earlier
+	// {"error": ...} bodies
+	Error     string `json:"error"`
+	Plugin    string `json:"plugin"`
+	RequestID string `json:"requestId,omitempty"`
+	Timestamp string `json:"timestamp"`
+	// Extensions are members specific to the code, such as the permission an
+	// endpoint requires. They sit next to the standard members.
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// newProblem describes a failed request. extensions alternate keys and
+// values, like the attributes of a log call.
+func newProblem(status int, code ErrorCode, detail string, extensions ...interface{}) Problem {
+	title, ok := problemTitles[code]
+	if !ok {
+		title = http.StatusText(status)
+	}
+	problem := Problem{
+		Type:      problemTypePrefix + strings.ReplaceAll(strings.ToLower(string(code)), "_", "-"),
+		Title:     title,
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		Error:     detail,
+		Plugin:    "kubestellar-cluster-plugin",
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	for i := 0; i+1 < len(extensions); i += 2 {
+		key, ok := extensions[i].(string)
+		if !ok {
+			continue
+		}
+		if problem.Extensions == nil {
+			problem.Extensions = map[string]interface{}{}
+		}
+		problem.Extensions[key] = extensions[i+1]
+	}
+	return problem
+}
+
+// MarshalJSON flattens the extensions into the problem object. The standard
+// members win over extensions of the same name.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type standard Problem
+	encoded, err := json.Marshal(standard(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return encoded, err
+	}
+	members := make(map[string]interface{}, len(p.Extensions))
+	for key, value := range p.Extensions {
+		members[key] = value
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range fields {
+		members[key] = value
+	}
+	return json.Marshal(members)
+}
+
+// jsonAPIError is a member of the errors array of a JSON:API document
+type jsonAPIError struct {
+	ID     string                 `json:"id,omitempty"`
+	Status string                 `json:"status"`
+	Code   ErrorCode              `json:"code"`
+	Title  string                 `json:"title"`
+	Detail string                 `json:"detail,omitempty"`
+	Meta   map[string]interface{} `json:"meta,omitempty"`
+}
+
+// write sends the problem as problem+json, or as a JSON:API errors document
+// when the client accepts that instead
+func (p Problem) write(w http.ResponseWriter, accept string) {
+	contentType := problemContentType
+	var body interface{} = p
+	if strings.Contains(accept, jsonAPIContentType) {
+		contentType = jsonAPIContentType
+		body = gin.H{"errors": []jsonAPIError{{
+			ID:     p.RequestID,
+			Status: strconv.Itoa(p.Status),
+			Code:   p.Code,
+			Title:  p.Title,
+			Detail: p.Detail,
+			Meta:   p.Extensions,
+		}}}
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		logger().Error("Failed to encode problem", "code", p.Code, "error", err)
+		w.WriteHeader(p.Status)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(encoded)
+}
+
+// respondProblem fails the request with the given status and code
+func respondProblem(c *gin.Context, status int, code ErrorCode, detail string, extensions ...interface{}) {
+	problem := newProblem(status, code, detail, extensions...)
+	if _, ok := problemTitles[code]; ok {
+		problem.Title = message(c, titleCode(code))
+	}
+	problem.Instance = c.Request.URL.Path
+	problem.RequestID = requestID(c)
+	problem.write(c.Writer, c.GetHeader("Accept"))
+}
+
+// abortWithProblem fails the request like respondProblem and stops the
+// handlers after the calling middleware
+func abortWithProblem(c *gin.Context, status int, code ErrorCode, detail string, extensions ...interface{}) {
+	respondProblem(c, status, code, detail, extensions...)
+	c.Abort()
+}
+
+// respondStoreError fails a request because the cluster store couldn't be used
+func respondStoreError(c *gin.Context, err error) {
+	respondProblem(c, http.StatusInternalServerError, CodeStoreError, "Failed to read cluster store: "+err.Error())
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProblemMarshalJSON(t *testing.T) {
+	problem := newProblem(http.StatusForbidden, CodePermissionDenied, "Caller lacks the permission",
+		"requiredPermission", "cluster.write", "status", "ignored")
+	encoded, err := json.Marshal(problem)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var members map[string]interface{}
+	if err := json.Unmarshal(encoded, &members); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"type":               "urn:kubestellar-cluster-plugin:problem:permission-denied",
+		"title":              "Permission denied",
+		"status":             float64(http.StatusForbidden),
+		"detail":             "Caller lacks the permission",
+		"error":              "Caller lacks the permission",
+		"code":               "PERMISSION_DENIED",
+		"requiredPermission": "cluster.write",
+	}
+	for key, value := range want {
+		if members[key] != value {
+			t.Errorf("%s = %v, want %v", key, members[key], value)
+		}
+	}
+}
+
+func TestRespondProblem(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	router := gin.New()
+	router.POST("/detach", plugin.wrapHandler("DetachClusterHandler", plugin.DetachClusterHandler))
+
+	detach := func(accept string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/detach", strings.NewReader(`{"clusterName": "missing"}`))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Accept", accept)
+		request.Header.Set(requestIDHeader, "req-42")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := detach("application/json")
+	if recorder.Code != http.StatusNotFound || recorder.Header().Get("Content-Type") != problemContentType {
+		t.Fatalf("detach of an unknown cluster = %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	var problem Problem
+	if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Code != CodeClusterNotFound || problem.Status != http.StatusNotFound ||
+		problem.Instance != "/detach" || problem.RequestID != "req-42" || problem.Error != problem.Detail {
+		t.Errorf("problem = %+v", problem)
+	}
+
+	recorder = detach(jsonAPIContentType)
+	if recorder.Header().Get("Content-Type") != jsonAPIContentType {
+		t.Fatalf("Content-Type = %q, want %q", recorder.Header().Get("Content-Type"), jsonAPIContentType)
+	}
+	var document struct {
+		Errors []jsonAPIError `json:"errors"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Errors) != 1 || document.Errors[0].Status != "404" ||
+		document.Errors[0].Code != CodeClusterNotFound || document.Errors[0].ID != "req-42" {
+		t.Errorf("JSON:API errors = %+v", document.Errors)
+	}
+}
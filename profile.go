@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Hub-side keys the profile of a cluster is mirrored to. Region and zone are
+// the well-known topology labels so placements can select on them; the rest
+// are annotations.
+const (
+	regionLabel           = "topology.kubernetes.io/region"
+	zoneLabel             = "topology.kubernetes.io/zone"
+	displayNameAnnotation = "plugin.kubestellar.io/display-name"
+	descriptionAnnotation = "plugin.kubestellar.io/description"
+	ownerAnnotation       = "plugin.kubestellar.io/owner"
+	costCenterAnnotation  = "plugin.kubestellar.io/cost-center"
+	contactAnnotation     = "plugin.kubestellar.io/contact"
+)
+
+const (
+	maxDisplayNameLength = 253
+	maxDescriptionLength = 1024
+)
+
+// ClusterProfile is the descriptive metadata of a cluster. The cluster name
+// is what the hub registers it under and can't change; DisplayName is how
+// people refer to it.
+type ClusterProfile struct {
+	DisplayName string `json:"displayName,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Owner is the team or person responsible for the cluster, Contact how
+	// to reach them
+	Owner   string `json:"owner,omitempty"`
+	Contact string `json:"contact,omitempty"`
+	// CostCenter is the budget the cluster is charged back to
+	CostCenter string `json:"costCenter,omitempty"`
+	Region     string `json:"region,omitempty"`
+	Zone       string `json:"zone,omitempty"`
+}
+
+// ClusterPatchRequest is the JSON body accepted by PATCH /clusters/:name.
+// Fields left out are unchanged and an empty string clears a field.
+type ClusterPatchRequest struct {
+	DisplayName *string `json:"displayName,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Owner       *string `json:"owner,omitempty"`
+	Contact     *string `json:"contact,omitempty"`
+	CostCenter  *string `json:"costCenter,omitempty"`
+	Region      *string `json:"region,omitempty"`
+	Zone        *string `json:"zone,omitempty"`
+}
+
+// Validate checks the lengths of the fields and that region and zone can be
+// label values
+func (r ClusterPatchRequest) Validate() error {
+	if r.DisplayName == nil && r.Description == nil && r.Owner == nil && r.Contact == nil && r.CostCenter == nil && r.Region == nil && r.Zone == nil {
+		return fmt.Errorf("at least one field is required")
+	}
+	if r.DisplayName != nil && utf8.RuneCountInString(*r.DisplayName) > maxDisplayNameLength {
+		return fmt.Errorf("displayName must be at most %d characters", maxDisplayNameLength)
+	}
+	if r.Description != nil && utf8.RuneCountInString(*r.Description) > maxDescriptionLength {
+		return fmt.Errorf("description must be at most %d characters", maxDescriptionLength)
+	}
+	for field, value := range map[string]*string{"owner": r.Owner, "contact": r.Contact, "costCenter": r.CostCenter} {
+		if value != nil && utf8.RuneCountInString(*value) > maxDisplayNameLength {
+			return fmt.Errorf("%s must be at most %d characters", field, maxDisplayNameLength)
+		}
+	}
+	for field, value := range map[string]*string{"region": r.Region, "zone": r.Zone} {
+		if value == nil {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(*value); len(errs) > 0 {
+			return fmt.Errorf("invalid %s '%s': %s", field, *value, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// apply returns the profile with the patch applied, nil once every field is
+// cleared
+func (r ClusterPatchRequest) apply(profile *ClusterProfile) *ClusterProfile {
+	patched := ClusterProfile{}
+	if profile != nil {
+		patched = *profile
+	}
+	set := func(field *string, value *string) {
+		if value != nil {
+			*field = strings.TrimSpace(*value)
+		}
+	}
+	set(&patched.DisplayName, r.DisplayName)
+	set(&patched.Description, r.Description)
+	set(&patched.Owner, r.Owner)
+	set(&patched.Contact, r.Contact)
+	set(&patched.CostCenter, r.CostCenter)
+	set(&patched.Region, r.Region)
+	set(&patched.Zone, r.Zone)
+	if patched == (ClusterProfile{}) {
+		return nil
+	}
+	return &patched
+}
+
+// hubPatch is the patch mirroring the changed fields onto the ManagedCluster;
+// cleared fields remove their label or annotation
+func (r ClusterPatchRequest) hubPatch(profile *ClusterProfile) LabelsPatchRequest {
+	if profile == nil {
+		profile = &ClusterProfile{}
+	}
+	patch := LabelsPatchRequest{Labels: map[string]*string{}, Annotations: map[string]*string{}}
+	set := func(m map[string]*string, key string, changed *string, value string) {
+		if changed == nil {
+			return
+		}
+		if value == "" {
+			m[key] = nil
+			return
+		}
+		m[key] = &value
+	}
+	set(patch.Labels, regionLabel, r.Region, profile.Region)
+	set(patch.Labels, zoneLabel, r.Zone, profile.Zone)
+	set(patch.Annotations, displayNameAnnotation, r.DisplayName, profile.DisplayName)
+	set(patch.Annotations, descriptionAnnotation, r.Description, profile.Description)
+	set(patch.Annotations, ownerAnnotation, r.Owner, profile.Owner)
+	set(patch.Annotations, contactAnnotation, r.Contact, profile.Contact)
+	set(patch.Annotations, costCenterAnnotation, r.CostCenter, profile.CostCenter)
+	return patch
+}
+
+// profileLabels are the topology labels the profile of a cluster sets
+func profileLabels(profile *ClusterProfile) map[string]string {
+	labels := make(map[string]string)
+	if profile == nil {
+		return labels
+	}
+	if profile.Region != "" {
+		labels[regionLabel] = profile.Region
+	}
+	if profile.Zone != "" {
+		labels[zoneLabel] = profile.Zone
+	}
+	return labels
+}
+
+// PatchClusterHandler edits the profile of a cluster record and mirrors it
+// onto its ManagedCluster
+func (cp *ClusterPlugin) PatchClusterHandler(c *gin.Context) {
+	clusterName := c.Param("name")
+
+	var req ClusterPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	cp.mutex.Lock()
+	record, exists, err := cp.store.Get(clusterName)
+	if err != nil {
+		cp.mutex.Unlock()
+		respondStoreError(c, err)
+		return
+	}
+	if !exists {
+		cp.mutex.Unlock()
+		respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin", clusterName))
+		return
+	}
+	record.Profile = req.apply(record.Profile)
+	err = cp.store.Put(record)
+	cp.statusCache.invalidate()
+	cp.mutex.Unlock()
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeStoreError, fmt.Sprintf("Failed to update cluster store: %v", err))
+		return
+	}
+
+	hubSynced := true
+	hubError := ""
+	if err := cp.patchManagedClusterMetadata(c.Request.Context(), clusterName, req.hubPatch(record.Profile)); err != nil {
+		logger().Warn("Failed to sync cluster profile to hub", "cluster", clusterName, "error", err)
+		hubSynced = false
+		hubError = err.Error()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"cluster":   record,
+		"hubSynced": hubSynced,
+		"hubError":  hubError,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPatchCluster(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready", Labels: map[string]string{"env": "prod"}})
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	patch := func(name, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPatch, "/clusters/"+name, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := patch("edge-1", `{"displayName": " Edge One ", "owner": "platform", "costCenter": "cc-42", "region": "eu-west-1", "zone": "eu-west-1a"}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("PATCH = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		Cluster ClusterStatus `json:"cluster"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	want := ClusterProfile{DisplayName: "Edge One", Owner: "platform", CostCenter: "cc-42", Region: "eu-west-1", Zone: "eu-west-1a"}
+	if response.Cluster.Profile == nil || *response.Cluster.Profile != want {
+		t.Fatalf("profile = %+v", response.Cluster.Profile)
+	}
+	set := clusterLabelSet(response.Cluster)
+	if set[regionLabel] != "eu-west-1" || set[zoneLabel] != "eu-west-1a" || set["env"] != "prod" {
+		t.Errorf("label set = %v", set)
+	}
+
+	// Clearing the zone keeps the other fields
+	if recorder := patch("edge-1", `{"zone": ""}`); recorder.Code != http.StatusOK {
+		t.Fatalf("PATCH = %d %s", recorder.Code, recorder.Body.String())
+	}
+	// Status updates keep the profile
+	plugin.updateStatus("edge-1", "Unreachable", "lease expired")
+	record, _, _ := plugin.store.Get("edge-1")
+	if want.Zone = ""; record.Profile == nil || *record.Profile != want {
+		t.Errorf("stored profile = %+v", record.Profile)
+	}
+
+	for _, tt := range []struct {
+		name, cluster, body string
+		want                int
+	}{
+		{name: "empty patch", cluster: "edge-1", body: `{}`, want: http.StatusBadRequest},
+		{name: "invalid region", cluster: "edge-1", body: `{"region": "eu west"}`, want: http.StatusBadRequest},
+		{name: "description too long", cluster: "edge-1", body: `{"description": "` + strings.Repeat("x", maxDescriptionLength+1) + `"}`, want: http.StatusBadRequest},
+		{name: "unknown cluster", cluster: "edge-9", body: `{"owner": "platform"}`, want: http.StatusNotFound},
+	} {
+		if recorder := patch(tt.cluster, tt.body); recorder.Code != tt.want {
+			t.Errorf("%s: PATCH = %d, want %d", tt.name, recorder.Code, tt.want)
+		}
+	}
+}
+
+func TestClusterPatchHubPatch(t *testing.T) {
+	value := func(s string) *string { return &s }
+	req := ClusterPatchRequest{DisplayName: value("Edge One"), Region: value("")}
+	patch := req.hubPatch(req.apply(&ClusterProfile{Region: "us-east-1", Owner: "platform"}))
+	if len(patch.Labels) != 1 || patch.Labels[regionLabel] != nil {
+		t.Errorf("labels = %v, want the region removed", patch.Labels)
+	}
+	if len(patch.Annotations) != 1 || patch.Annotations[displayNameAnnotation] == nil || *patch.Annotations[displayNameAnnotation] != "Edge One" {
+		t.Errorf("annotations = %v", patch.Annotations)
+	}
+	if cleared := (ClusterPatchRequest{Owner: value("")}).apply(&ClusterProfile{Owner: "platform"}); cleared != nil {
+		t.Errorf("apply() of the last field cleared = %+v, want nil", cleared)
+	}
+}
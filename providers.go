@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// providerTimeout bounds each provider CLI call
+const providerTimeout = 2 * time.Minute
+
+// ProviderSpec locates a cluster at the service that runs it
+type ProviderSpec struct {
+	// Name selects the provider: eks, gke, aks, kind or k3d
+	Name string `json:"name"`
+	// Cluster is the cluster name at the provider, defaulting to the onboarded cluster name
+	Cluster string `json:"cluster,omitempty"`
+	// Region is the AWS region for EKS or the location (region or zone) for GKE
+	Region string `json:"region,omitempty"`
+	// Project is the Google Cloud project for GKE
+	Project string `json:"project,omitempty"`
+	// ResourceGroup is the Azure resource group for AKS
+	ResourceGroup string `json:"resourceGroup,omitempty"`
+	// Account names the discovery account whose credentials the CLI runs
+	// with, instead of those of the host
+	Account string `json:"account,omitempty"`
+}
+
+// Provider fetches kubeconfigs for and prepares the clusters of one
+// Kubernetes service, using its CLI with the credentials of the host
+type Provider interface {
+	// Validate checks the provider specific fields of spec
+	Validate(spec ProviderSpec) error
+	// Prepare makes sure the cluster exists and is ready to be joined
+	Prepare(ctx context.Context, spec ProviderSpec) error
+	// Kubeconfig returns a kubeconfig whose current context is the cluster
+	Kubeconfig(ctx context.Context, spec ProviderSpec) ([]byte, error)
+}
+
+var providers = map[string]Provider{
+	"eks":  eksProvider{},
+	"gke":  gkeProvider{},
+	"aks":  aksProvider{},
+	"kind": kindProvider{},
+	"k3d":  k3dProvider{},
+}
+
+// providerNames lists the supported providers for error messages
+func providerNames() string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Validate checks that the provider is known and its fields are complete
+func (s ProviderSpec) Validate() error {
+	provider, ok := providers[s.Name]
+	if !ok {
+		return fmt.Errorf("unknown provider %q, must be one of %s", s.Name, providerNames())
+	}
+	return provider.Validate(s)
+}
+
+// fetchProviderKubeconfig prepares the cluster of spec and returns its kubeconfig
+func fetchProviderKubeconfig(ctx context.Context, spec ProviderSpec) ([]byte, error) {
+	provider, ok := providers[spec.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q, must be one of %s", spec.Name, providerNames())
+	}
+	if err := provider.Prepare(ctx, spec); err != nil {
+		return nil, fmt.Errorf("%s cluster %s is not ready: %w", spec.Name, spec.Cluster, err)
+	}
+	data, err := provider.Kubeconfig(ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s kubeconfig for %s: %w", spec.Name, spec.Cluster, err)
+	}
+	return data, nil
+}
+
+// runProviderCommand runs a provider CLI, with the credentials ctx carries
+// from withProviderEnv, and returns its standard output. It is a variable so
+// tests can stub the CLIs out.
+var runProviderCommand = func(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, providerTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(append(os.Environ(), providerEnv(ctx)...), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %w: %s", name, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// withKubeconfigFile runs fetch with the path of a scratch kubeconfig file and
+// returns what it wrote there, so the user's own kubeconfig is never touched
+func withKubeconfigFile(fetch func(path string) error) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "provider-kubeconfig-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kubeconfig")
+	if err := fetch(path); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// expectState checks the trimmed output of a describe command
+func expectState(output []byte, want string) error {
+	if got := strings.TrimSpace(string(output)); got != want {
+		return fmt.Errorf("cluster state is %q, want %q", got, want)
+	}
+	return nil
+}
+
+// eksProvider handles Amazon EKS clusters through the aws CLI
+type eksProvider struct{}
+
+func (eksProvider) Validate(ProviderSpec) error {
+	return nil
+}
+
+func (eksProvider) args(spec ProviderSpec, args ...string) []string {
+	args = append(args, "--name", spec.Cluster)
+	if spec.Region != "" {
+		args = append(args, "--region", spec.Region)
+	}
+	return args
+}
+
+func (p eksProvider) Prepare(ctx context.Context, spec ProviderSpec) error {
+	output, err := runProviderCommand(ctx, nil, "aws", p.args(spec, "eks", "describe-cluster", "--query", "cluster.status", "--output", "text")...)
+	if err != nil {
+		return err
+	}
+	return expectState(output, "ACTIVE")
+}
+
+func (p eksProvider) Kubeconfig(ctx context.Context, spec ProviderSpec) ([]byte, error) {
+	return withKubeconfigFile(func(path string) error {
+		_, err := runProviderCommand(ctx, nil, "aws", p.args(spec, "eks", "update-kubeconfig", "--kubeconfig", path)...)
+		return err
+	})
+}
+
+// gkeProvider handles Google GKE clusters through the gcloud CLI
+type gkeProvider struct{}
+
+func (gkeProvider) Validate(spec ProviderSpec) error {
+	if spec.Region == "" {
+		return fmt.Errorf("provider.region is required for gke")
+	}
+	return nil
+}
+
+func (gkeProvider) args(spec ProviderSpec, args ...string) []string {
+	args = append(args, spec.Cluster, "--location", spec.Region)
+	if spec.Project != "" {
+		args = append(args, "--project", spec.Project)
+	}
+	return args
+}
+
+func (p gkeProvider) Prepare(ctx context.Context, spec ProviderSpec) error {
+	output, err := runProviderCommand(ctx, nil, "gcloud", p.args(spec, "container", "clusters", "describe", "--format", "value(status)")...)
+	if err != nil {
+		return err
+	}
+	return expectState(output, "RUNNING")
+}
+
+func (p gkeProvider) Kubeconfig(ctx context.Context, spec ProviderSpec) ([]byte, error) {
+	return withKubeconfigFile(func(path string) error {
+		_, err := runProviderCommand(ctx, []string{"KUBECONFIG=" + path}, "gcloud", p.args(spec, "container", "clusters", "get-credentials")...)
+		return err
+	})
+}
+
+// aksProvider handles Azure AKS clusters through the az CLI
+type aksProvider struct{}
+
+func (aksProvider) Validate(spec ProviderSpec) error {
+	if spec.ResourceGroup == "" {
+		return fmt.Errorf("provider.resourceGroup is required for aks")
+	}
+	return nil
+}
+
+func (aksProvider) args(spec ProviderSpec, args ...string) []string {
+	return append(args, "--name", spec.Cluster, "--resource-group", spec.ResourceGroup)
+}
+
+func (p aksProvider) Prepare(ctx context.Context, spec ProviderSpec) error {
+	output, err := runProviderCommand(ctx, nil, "az", p.args(spec, "aks", "show", "--query", "provisioningState", "--output", "tsv")...)
+	if err != nil {
+		return err
+	}
+	return expectState(output, "Succeeded")
+}
+
+func (p aksProvider) Kubeconfig(ctx context.Context, spec ProviderSpec) ([]byte, error) {
+	return withKubeconfigFile(func(path string) error {
+		_, err := runProviderCommand(ctx, nil, "az", p.args(spec, "aks", "get-credentials", "--file", path, "--overwrite-existing")...)
+		return err
+	})
+}
+
+// kindProvider handles local kind clusters
+type kindProvider struct{}
+
+func (kindProvider) Validate(ProviderSpec) error {
+	return nil
+}
+
+func (kindProvider) Prepare(ctx context.Context, spec ProviderSpec) error {
+	output, err := runProviderCommand(ctx, nil, "kind", "get", "clusters")
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Fields(string(output)) {
+		if name == spec.Cluster {
+			return nil
+		}
+	}
+	return fmt.Errorf("no kind cluster named %s", spec.Cluster)
+}
+
+func (kindProvider) Kubeconfig(ctx context.Context, spec ProviderSpec) ([]byte, error) {
+	return runProviderCommand(ctx, nil, "kind", "get", "kubeconfig", "--name", spec.Cluster)
+}
+
+// k3dProvider handles local k3d clusters
+type k3dProvider struct{}
+
+func (k3dProvider) Validate(ProviderSpec) error {
+	return nil
+}
+
+func (k3dProvider) Prepare(ctx context.Context, spec ProviderSpec) error {
+	_, err := runProviderCommand(ctx, nil, "k3d", "cluster", "get", spec.Cluster)
+	return err
+}
+
+func (k3dProvider) Kubeconfig(ctx context.Context, spec ProviderSpec) ([]byte, error) {
+	return runProviderCommand(ctx, nil, "k3d", "kubeconfig", "get", spec.Cluster)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProviderSpecValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    ProviderSpec
+		wantErr string
+	}{
+		{name: "eks", spec: ProviderSpec{Name: "eks", Region: "us-east-1"}},
+		{name: "kind", spec: ProviderSpec{Name: "kind"}},
+		{name: "unknown provider", spec: ProviderSpec{Name: "openshift"}, wantErr: "unknown provider"},
+		{name: "gke without location", spec: ProviderSpec{Name: "gke", Project: "demo"}, wantErr: "provider.region"},
+		{name: "aks without resource group", spec: ProviderSpec{Name: "aks"}, wantErr: "provider.resourceGroup"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFetchProviderKubeconfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     ProviderSpec
+		state    string
+		wantCmds []string
+		wantErr  string
+	}{
+		{
+			name:  "eks",
+			spec:  ProviderSpec{Name: "eks", Cluster: "prod", Region: "eu-west-1"},
+			state: "ACTIVE",
+			wantCmds: []string{
+				"aws eks describe-cluster --query cluster.status --output text --name prod --region eu-west-1",
+				"aws eks update-kubeconfig --kubeconfig <file> --name prod --region eu-west-1",
+			},
+		},
+		{
+			name:  "gke",
+			spec:  ProviderSpec{Name: "gke", Cluster: "prod", Region: "europe-west1", Project: "demo"},
+			state: "RUNNING",
+			wantCmds: []string{
+				"gcloud container clusters describe --format value(status) prod --location europe-west1 --project demo",
+				"gcloud container clusters get-credentials prod --location europe-west1 --project demo",
+			},
+		},
+		{
+			name:     "aks not provisioned",
+			spec:     ProviderSpec{Name: "aks", Cluster: "prod", ResourceGroup: "rg"},
+			state:    "Creating",
+			wantCmds: []string{"az aks show --query provisioningState --output tsv --name prod --resource-group rg"},
+			wantErr:  "not ready",
+		},
+		{
+			name:     "kind",
+			spec:     ProviderSpec{Name: "kind", Cluster: "prod"},
+			state:    "dev\nprod",
+			wantCmds: []string{"kind get clusters", "kind get kubeconfig --name prod"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cmds []string
+			original := runProviderCommand
+			defer func() { runProviderCommand = original }()
+			runProviderCommand = func(_ context.Context, env []string, name string, args ...string) ([]byte, error) {
+				cmd := strings.Join(append([]string{name}, args...), " ")
+				for i, arg := range args {
+					// Write the kubeconfig where the CLI was told to
+					if (arg == "--kubeconfig" || arg == "--file") && i+1 < len(args) {
+						cmds = append(cmds, strings.Replace(cmd, args[i+1], "<file>", 1))
+						return nil, os.WriteFile(args[i+1], []byte("kubeconfig"), 0600)
+					}
+				}
+				for _, variable := range env {
+					if path, ok := strings.CutPrefix(variable, "KUBECONFIG="); ok {
+						cmds = append(cmds, cmd)
+						return nil, os.WriteFile(path, []byte("kubeconfig"), 0600)
+					}
+				}
+				cmds = append(cmds, cmd)
+				if len(cmds) == 1 {
+					return []byte(tt.state + "\n"), nil
+				}
+				return []byte("kubeconfig"), nil
+			}
+
+			data, err := fetchProviderKubeconfig(context.Background(), tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("fetchProviderKubeconfig() = %v, want error containing %q", err, tt.wantErr)
+				}
+			} else if err != nil || string(data) != "kubeconfig" {
+				t.Fatalf("fetchProviderKubeconfig() = %q, %v", data, err)
+			}
+			if fmt.Sprint(cmds) != fmt.Sprint(tt.wantCmds) {
+				t.Errorf("commands = %q, want %q", cmds, tt.wantCmds)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// provisionedByAnnotation records which tool created a cluster, so that only
+// clusters the plugin provisioned can be torn down through it
+const provisionedByAnnotation = "plugin.kubestellar.io/provisioned-by"
+
+// localProvisioner creates and deletes local clusters with one tool
+type localProvisioner struct {
+	createArgs func(clusterName, image string) []string
+	deleteArgs func(clusterName string) []string
+}
+
+var localProvisioners = map[string]localProvisioner{
+	"kind": {
+		createArgs: func(clusterName, image string) []string {
+			args := []string{"create", "cluster", "--name", clusterName, "--wait", "5m"}
+			if image != "" {
+				args = append(args, "--image", image)
+			}
+			return args
+		},
+		deleteArgs: func(clusterName string) []string {
+			return []string{"delete", "cluster", "--name", clusterName}
+		},
+	},
+	"k3d": {
+		createArgs: func(clusterName, image string) []string {
+			args := []string{"cluster", "create", clusterName, "--wait", "--timeout", "5m"}
+			if image != "" {
+				args = append(args, "--image", image)
+			}
+			return args
+		},
+		deleteArgs: func(clusterName string) []string {
+			return []string{"cluster", "delete", clusterName}
+		},
+	},
+}
+
+// ProvisionClusterHandler creates a local kind or k3d cluster and onboards it
+func (cp *ClusterPlugin) ProvisionClusterHandler(c *gin.Context) {
+	var req ProvisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload, clusterName is required")
+		return
+	}
+	if req.Tool == "" {
+		req.Tool = "kind"
+	}
+	if err := req.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if _, err := exec.LookPath(req.Tool); err != nil {
+		respondProblem(c, http.StatusServiceUnavailable, CodeUnavailable, fmt.Sprintf("%s is not installed on the plugin host", req.Tool))
+		return
+	}
+
+	hub, err := cp.hubs.Get(req.Hub)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	annotations := make(map[string]string, len(req.Annotations)+1)
+	for key, value := range req.Annotations {
+		annotations[key] = value
+	}
+	annotations[provisionedByAnnotation] = req.Tool
+	if respondAdmission(c, cp.admit(c.Request.Context(), AdmissionInput{
+		Operation:   "onboard",
+		ClusterName: req.ClusterName,
+		Hub:         hub.Name,
+		Provider:    req.Tool,
+		Labels:      req.Labels,
+		Annotations: annotations,
+	})) {
+		return
+	}
+
+	jobID, existing, err := cp.beginOnboarding(c.Request.Context(), req.ClusterName, hub.Name, req.Tool, c.GetHeader(idempotencyKeyHeader), req.Labels, annotations)
+	if respondPolicyViolation(c, err) {
+		return
+	}
+	if errors.Is(err, errIdempotencyKeyReused) {
+		respondProblem(c, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, err.Error())
+		return
+	}
+	if errors.Is(err, errTenantConflict) {
+		respondProblem(c, http.StatusConflict, CodeClusterExists, err.Error())
+		return
+	}
+	if errors.Is(err, errJobQueueFull) {
+		cp.respondQueueFull(c)
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if existing != nil {
+		respondProblem(c, http.StatusConflict, CodeClusterExists, fmt.Sprintf("Cluster '%s' already exists (status: %s)", req.ClusterName, existing.Status),
+			"jobId", existing.JobID, "cluster", *existing)
+		return
+	}
+
+	requestLogger(c).Info("Provisioning local cluster", "cluster", req.ClusterName, "tool", req.Tool, "job", jobID)
+	cp.jobs.Run(jobID, cp.provisioningJob(jobID, req.ClusterName, req.Tool, req.Image))
+
+	c.JSON(http.StatusAccepted, OnboardResponse{
+		Message:       message(c, MsgProvisionStarted, "tool", req.Tool, "cluster", req.ClusterName),
+		MessageCode:   MsgProvisionStarted,
+		Status:        "Pending",
+		Plugin:        "kubestellar-cluster-plugin",
+		ClusterName:   req.ClusterName,
+		JobID:         jobID,
+		QueuePosition: cp.queuePosition(jobID),
+		Timestamp:     time.Now().Format(time.RFC3339),
+	})
+}
+
+// provisioningJob returns the job body that creates a local cluster and then
+// onboards it like any other cluster
+func (cp *ClusterPlugin) provisioningJob(jobID, clusterName, tool, image string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		kubeconfigData, err := cp.createLocalCluster(ctx, clusterName, tool, image)
+		if err != nil {
+			cp.mutex.Lock()
+			defer cp.mutex.Unlock()
+			logger().Error("Cluster provisioning failed", "cluster", clusterName, "job", jobID, "error", err)
+			cp.logs.Append(clusterName, "error", fmt.Sprintf("Provisioning failed: %v", err))
+			cp.putStatus(ClusterStatus{
+				ClusterName: clusterName,
+				JobID:       jobID,
+				Status:      "Failed",
+				Message:     fmt.Sprintf("Provisioning failed: %v", err),
+				LastUpdated: time.Now().Format(time.RFC3339),
+			})
+			cp.notify(eventClusterFailed, clusterName, jobID, fmt.Sprintf("Provisioning failed: %v", err))
+			return err
+		}
+		return cp.onboardingJob(jobID, clusterName, kubeconfigData, nil)(ctx)
+	}
+}
+
+// createLocalCluster runs the provisioning tool and returns the kubeconfig of
+// the new cluster
+func (cp *ClusterPlugin) createLocalCluster(ctx context.Context, clusterName, tool, image string) ([]byte, error) {
+	if err := cp.advance(ctx, clusterName, "Provisioning", fmt.Sprintf("Creating %s cluster", tool)); err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, tool, localProvisioners[tool].createArgs(clusterName, image)...)
+	if output, err := cp.runLogged(clusterName, cmd); err != nil {
+		return nil, fmt.Errorf("%s failed to create the cluster: %s, %w", tool, string(output), err)
+	}
+	return providers[tool].Kubeconfig(ctx, ProviderSpec{Name: tool, Cluster: clusterName})
+}
+
+// DeprovisionClusterHandler detaches a cluster created by
+// ProvisionClusterHandler from the hub and deletes it
+func (cp *ClusterPlugin) DeprovisionClusterHandler(c *gin.Context) {
+	clusterName := c.Param("name")
+
+	cp.mutex.Lock()
+	existing, exists, err := cp.store.Get(clusterName)
+	if err != nil {
+		cp.mutex.Unlock()
+		respondStoreError(c, err)
+		return
+	}
+	if !exists {
+		cp.mutex.Unlock()
+		respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin", clusterName))
+		return
+	}
+	tool := existing.Annotations[provisionedByAnnotation]
+	if _, ok := localProvisioners[tool]; !ok {
+		cp.mutex.Unlock()
+		respondProblem(c, http.StatusConflict, CodeConflict, fmt.Sprintf("Cluster '%s' was not provisioned by the plugin; detach it instead", clusterName))
+		return
+	}
+
+	if err := cp.jobs.Admit(); err != nil {
+		cp.mutex.Unlock()
+		cp.respondQueueFull(c)
+		return
+	}
+	jobID := cp.jobs.Create(c.Request.Context(), "deprovision", clusterName).ID
+	cp.putStatus(ClusterStatus{
+		ClusterName: clusterName,
+		JobID:       jobID,
+		Status:      "Detaching",
+		Message:     fmt.Sprintf("Tearing down %s cluster", tool),
+		LastUpdated: time.Now().Format(time.RFC3339),
+	})
+	cp.mutex.Unlock()
+
+	requestLogger(c).Info("Tearing down provisioned cluster", "cluster", clusterName, "tool", tool, "job", jobID)
+	cp.jobs.Run(jobID, cp.deprovisionJob(jobID, clusterName, tool))
+
+	c.JSON(http.StatusAccepted, DetachResponse{
+		Message:       message(c, MsgTeardownStarted, "tool", tool, "cluster", clusterName),
+		MessageCode:   MsgTeardownStarted,
+		Status:        "Detaching",
+		JobID:         jobID,
+		QueuePosition: cp.queuePosition(jobID),
+		Previous:      existing,
+		Plugin:        "kubestellar-cluster-plugin",
+		Timestamp:     time.Now().Format(time.RFC3339),
+	})
+}
+
+// deprovisionJob returns the job body that force-detaches a provisioned
+// cluster, deletes it and forgets it
+func (cp *ClusterPlugin) deprovisionJob(jobID, clusterName, tool string) func(ctx context.Context) error {
+	cp.trackJob(resumableJob{JobID: jobID, Type: "deprovision", ClusterName: clusterName})
+	return func(ctx context.Context) error {
+		// The cluster is going away, so a failed hub cleanup must not keep it alive
+		err := cp.detachClusterEnhanced(ctx, clusterName, nil, true)
+		if err == nil {
+			if err = cp.advance(ctx, clusterName, "Deleting", fmt.Sprintf("Deleting %s cluster", tool)); err == nil {
+				cmd := exec.CommandContext(ctx, tool, localProvisioners[tool].deleteArgs(clusterName)...)
+				if output, runErr := cp.runLogged(clusterName, cmd); runErr != nil {
+					err = fmt.Errorf("%s failed to delete the cluster: %s, %w", tool, string(output), runErr)
+				}
+			}
+		}
+
+		cp.mutex.Lock()
+		defer cp.mutex.Unlock()
+		delete(cp.resumable, jobID)
+		if err != nil {
+			logger().Error("Cluster teardown failed", "cluster", clusterName, "job", jobID, "error", err)
+			cp.putStatus(ClusterStatus{
+				ClusterName: clusterName,
+				JobID:       jobID,
+				Status:      "DetachFailed",
+				Message:     fmt.Sprintf("Teardown failed: %v", err),
+				LastUpdated: time.Now().Format(time.RFC3339),
+			})
+			return err
+		}
+		if !cp.closed {
+			if err := cp.store.Delete(clusterName); err != nil {
+				logger().Error("Failed to remove cluster from store", "cluster", clusterName, "error", err)
+			}
+			cp.statusCache.invalidate()
+			cp.broadcaster.Publish(StatusEvent{
+				ClusterName: clusterName,
+				Status:      "Detached",
+				Previous:    "Deleting",
+				Message:     fmt.Sprintf("%s cluster deleted", tool),
+				JobID:       jobID,
+				Timestamp:   time.Now().Format(time.RFC3339),
+			})
+		}
+		logger().Info("Provisioned cluster torn down", "cluster", clusterName, "job", jobID)
+		cp.notify(eventClusterDetached, clusterName, jobID, fmt.Sprintf("%s cluster deleted", tool))
+		return nil
+	}
+}
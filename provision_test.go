@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProvisionRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ProvisionRequest
+		wantErr string
+	}{
+		{name: "kind", req: ProvisionRequest{ClusterName: "demo", Tool: "kind"}},
+		{name: "k3d with image", req: ProvisionRequest{ClusterName: "demo", Tool: "k3d", Image: "rancher/k3s:v1.29.2-k3s1"}},
+		{name: "unsupported tool", req: ProvisionRequest{ClusterName: "demo", Tool: "minikube"}, wantErr: "unsupported tool"},
+		{name: "invalid name", req: ProvisionRequest{ClusterName: "Demo_1", Tool: "kind"}, wantErr: "invalid clusterName"},
+		{name: "invalid label", req: ProvisionRequest{ClusterName: "demo", Tool: "kind", Labels: map[string]string{"bad key": "x"}}, wantErr: "bad key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocalProvisionerArgs(t *testing.T) {
+	tests := []struct {
+		tool       string
+		image      string
+		wantCreate string
+		wantDelete string
+	}{
+		{tool: "kind", wantCreate: "create cluster --name demo --wait 5m", wantDelete: "delete cluster --name demo"},
+		{tool: "kind", image: "kindest/node:v1.29.2", wantCreate: "create cluster --name demo --wait 5m --image kindest/node:v1.29.2", wantDelete: "delete cluster --name demo"},
+		{tool: "k3d", wantCreate: "cluster create demo --wait --timeout 5m", wantDelete: "cluster delete demo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tool+tt.image, func(t *testing.T) {
+			provisioner := localProvisioners[tt.tool]
+			if got := strings.Join(provisioner.createArgs("demo", tt.image), " "); got != tt.wantCreate {
+				t.Errorf("create args = %q, want %q", got, tt.wantCreate)
+			}
+			if got := strings.Join(provisioner.deleteArgs("demo"), " "); got != tt.wantDelete {
+				t.Errorf("delete args = %q, want %q", got, tt.wantDelete)
+			}
+		})
+	}
+}
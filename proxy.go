@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ProxyConfig routes the connections of a cluster that sits behind a
+// corporate proxy: the klusterlet's connection to the hub and the plugin's
+// own connections to the spoke
+type ProxyConfig struct {
+	HTTPProxy  string `json:"httpProxy,omitempty"`
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+	// NoProxy lists the hosts, domains and CIDRs reached directly, as in NO_PROXY
+	NoProxy string `json:"noProxy,omitempty"`
+	// CABundle holds PEM certificates trusted on top of the cluster CAs, such
+	// as that of a TLS inspecting proxy
+	CABundle string `json:"caBundle,omitempty"`
+}
+
+// Validate checks the proxy URLs and the CA bundle
+func (p ProxyConfig) Validate() error {
+	for name, raw := range map[string]string{"httpProxy": p.HTTPProxy, "httpsProxy": p.HTTPSProxy} {
+		if raw == "" {
+			continue
+		}
+		proxy, err := url.Parse(raw)
+		if err != nil || (proxy.Scheme != "http" && proxy.Scheme != "https") || proxy.Host == "" {
+			return fmt.Errorf("invalid %s %q, must be an http or https URL", name, raw)
+		}
+	}
+	if p.CABundle != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(p.CABundle)) {
+		return fmt.Errorf("caBundle holds no PEM certificate")
+	}
+	return nil
+}
+
+// proxyFor returns the proxy to reach server through, or "" to connect directly
+func (p ProxyConfig) proxyFor(server string) (string, error) {
+	target, err := url.Parse(server)
+	if err != nil {
+		return "", fmt.Errorf("invalid server %q: %w", server, err)
+	}
+	proxy, err := (&httpproxy.Config{
+		HTTPProxy:  p.HTTPProxy,
+		HTTPSProxy: p.HTTPSProxy,
+		NoProxy:    p.NoProxy,
+	}).ProxyFunc()(target)
+	if err != nil || proxy == nil {
+		return "", err
+	}
+	return proxy.String(), nil
+}
+
+// appendCABundle adds bundle to the PEM certificates in ca
+func appendCABundle(ca []byte, bundle string) []byte {
+	if bundle == "" {
+		return ca
+	}
+	merged := append([]byte(nil), ca...)
+	if len(merged) > 0 && merged[len(merged)-1] != '\n' {
+		merged = append(merged, '\n')
+	}
+	return append(merged, bundle...)
+}
+
+// withProxy writes the proxy settings into a kubeconfig: each cluster gets
+// the proxy-url its server is reached through and trusts the CA bundle.
+// Everything the plugin runs against the cluster then honours them, both its
+// own clients and clusteradm.
+func withProxy(kubeconfigData []byte, proxy *ProxyConfig) ([]byte, error) {
+	if proxy == nil {
+		return kubeconfigData, nil
+	}
+	config, err := clientcmd.Load(kubeconfigData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig format: %w", err)
+	}
+	for name, cluster := range config.Clusters {
+		proxyURL, err := proxy.proxyFor(cluster.Server)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", name, err)
+		}
+		if proxyURL != "" {
+			cluster.ProxyURL = proxyURL
+		}
+		if proxy.CABundle != "" && !cluster.InsecureSkipTLSVerify {
+			ca := cluster.CertificateAuthorityData
+			if len(ca) == 0 && cluster.CertificateAuthority != "" {
+				if ca, err = os.ReadFile(cluster.CertificateAuthority); err != nil {
+					return nil, fmt.Errorf("cluster %s: failed to read CA: %w", name, err)
+				}
+				cluster.CertificateAuthority = ""
+			}
+			cluster.CertificateAuthorityData = appendCABundle(ca, proxy.CABundle)
+		}
+	}
+	return clientcmd.Write(*config)
+}
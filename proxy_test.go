@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestWithProxy(t *testing.T) {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["edge"] = &clientcmdapi.Cluster{Server: "https://edge.corp.example.com:6443", CertificateAuthorityData: []byte("edge-ca")}
+	config.Clusters["lab"] = &clientcmdapi.Cluster{Server: "https://10.1.2.3:6443"}
+	kubeconfig, err := clientcmd.Write(*config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxied, err := withProxy(kubeconfig, &ProxyConfig{
+		HTTPSProxy: "http://proxy.corp.example.com:3128",
+		NoProxy:    "10.0.0.0/8",
+		CABundle:   "inspection-ca",
+	})
+	if err != nil {
+		t.Fatalf("withProxy() error = %v", err)
+	}
+	result, err := clientcmd.Load(proxied)
+	if err != nil {
+		t.Fatalf("withProxy() wrote an invalid kubeconfig: %v", err)
+	}
+	edge, lab := result.Clusters["edge"], result.Clusters["lab"]
+	if edge.ProxyURL != "http://proxy.corp.example.com:3128" || string(edge.CertificateAuthorityData) != "edge-ca\ninspection-ca" {
+		t.Errorf("edge cluster = %+v", edge)
+	}
+	if lab.ProxyURL != "" || string(lab.CertificateAuthorityData) != "inspection-ca" {
+		t.Errorf("lab cluster in noProxy = %+v", lab)
+	}
+
+	if unchanged, err := withProxy(kubeconfig, nil); err != nil || string(unchanged) != string(kubeconfig) {
+		t.Errorf("withProxy(nil) changed the kubeconfig")
+	}
+}
+
+func TestProxyConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxy   ProxyConfig
+		wantErr bool
+	}{
+		{name: "proxies", proxy: ProxyConfig{HTTPProxy: "http://proxy:3128", HTTPSProxy: "https://proxy:3129", NoProxy: ".corp,10.0.0.0/8"}},
+		{name: "proxy without scheme", proxy: ProxyConfig{HTTPSProxy: "proxy:3128"}, wantErr: true},
+		{name: "ca bundle without certificates", proxy: ProxyConfig{CABundle: "not a certificate"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.proxy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
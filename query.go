@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// clusterQuery holds the filtering, sorting and paging options of GET /status
+type clusterQuery struct {
+	status     string
+	hub        string
+	selector   labels.Selector
+	sortField  string
+	descending bool
+	limit      int
+	offset     int
+}
+
+// parseClusterQuery reads ?status, ?hub, ?labelSelector, ?sort, ?limit and ?continue
+func parseClusterQuery(c *gin.Context) (clusterQuery, error) {
+	query := clusterQuery{
+		status:    c.Query("status"),
+		hub:       c.Query("hub"),
+		selector:  labels.Everything(),
+		sortField: "name",
+	}
+
+	if raw := c.Query("labelSelector"); raw != "" {
+		selector, err := labels.Parse(raw)
+		if err != nil {
+			return query, fmt.Errorf("invalid labelSelector: %w", err)
+		}
+		query.selector = selector
+	}
+
+	if raw := c.Query("sort"); raw != "" {
+		query.descending = strings.HasPrefix(raw, "-")
+		query.sortField = strings.TrimPrefix(raw, "-")
+		switch query.sortField {
+		case "name", "status", "lastUpdated":
+		default:
+			return query, fmt.Errorf("invalid sort field %q, must be name, status or lastUpdated", query.sortField)
+		}
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 0 {
+			return query, fmt.Errorf("invalid limit %q", raw)
+		}
+		query.limit = limit
+	}
+
+	if raw := c.Query("continue"); raw != "" {
+		offset, err := decodeContinue(raw)
+		if err != nil {
+			return query, err
+		}
+		query.offset = offset
+	}
+
+	return query, nil
+}
+
+// Filter returns the clusters matching the status, hub and label selector, sorted
+func (q clusterQuery) Filter(clusters []ClusterStatus) []ClusterStatus {
+	matched := make([]ClusterStatus, 0, len(clusters))
+	for _, cluster := range clusters {
+		if q.status != "" && !strings.EqualFold(cluster.Status, q.status) {
+			continue
+		}
+		if q.hub != "" && hubName(cluster) != q.hub {
+			continue
+		}
+		if !q.selector.Matches(labels.Set(clusterLabelSet(cluster))) {
+			continue
+		}
+		matched = append(matched, cluster)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if q.descending {
+			a, b = b, a
+		}
+		switch q.sortField {
+		case "status":
+			if a.Status != b.Status {
+				return a.Status < b.Status
+			}
+		case "lastUpdated":
+			if a.LastUpdated != b.LastUpdated {
+				return a.LastUpdated < b.LastUpdated
+			}
+		}
+		return a.ClusterName < b.ClusterName
+	})
+	return matched
+}
+
+// Page returns the requested page and the continue token for the next one
+func (q clusterQuery) Page(clusters []ClusterStatus) ([]ClusterStatus, string) {
+	if q.offset >= len(clusters) {
+		return []ClusterStatus{}, ""
+	}
+	clusters = clusters[q.offset:]
+	if q.limit == 0 || q.limit >= len(clusters) {
+		return clusters, ""
+	}
+	return clusters[:q.limit], encodeContinue(q.offset + q.limit)
+}
+
+// clusterLabelSet returns the labels a label selector is matched against:
+// the hub labels overlaid with the ones recorded by the plugin
+func clusterLabelSet(cluster ClusterStatus) map[string]string {
+	set := make(map[string]string)
+	if cluster.ManagedCluster != nil {
+		for key, value := range cluster.ManagedCluster.Labels {
+			set[key] = value
+		}
+	}
+	for key, value := range cluster.Labels {
+		set[key] = value
+	}
+	for key, value := range profileLabels(cluster.Profile) {
+		set[key] = value
+	}
+	return set
+}
+
+func encodeContinue(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeContinue(token string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid continue token")
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid continue token")
+	}
+	return offset, nil
+}
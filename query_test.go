@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func queryContext(rawQuery string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/status?"+rawQuery, nil)
+	return c
+}
+
+func clusterNames(clusters []ClusterStatus) []string {
+	names := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		names = append(names, cluster.ClusterName)
+	}
+	return names
+}
+
+func TestParseClusterQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawQuery string
+		wantErr  bool
+	}{
+		{name: "defaults", rawQuery: ""},
+		{name: "all options", rawQuery: "status=Ready&labelSelector=env%3Dprod&sort=-lastUpdated&limit=2&continue=" + encodeContinue(2)},
+		{name: "bad selector", rawQuery: "labelSelector=env%3D%3D%3D", wantErr: true},
+		{name: "bad sort field", rawQuery: "sort=region", wantErr: true},
+		{name: "negative limit", rawQuery: "limit=-1", wantErr: true},
+		{name: "non-numeric limit", rawQuery: "limit=ten", wantErr: true},
+		{name: "bad continue", rawQuery: "continue=%21%21", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseClusterQuery(queryContext(tt.rawQuery))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClusterQuery(%q) error = %v, wantErr %v", tt.rawQuery, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClusterQueryFilterAndPage(t *testing.T) {
+	clusters := []ClusterStatus{
+		{ClusterName: "c", Status: "Ready", LastUpdated: "2024-01-01T00:00:01Z", Labels: map[string]string{"env": "prod"}},
+		{ClusterName: "a", Status: "Failed", LastUpdated: "2024-01-01T00:00:03Z", Labels: map[string]string{"env": "dev"}},
+		{ClusterName: "b", Status: "Ready", LastUpdated: "2024-01-01T00:00:02Z"},
+		{
+			ClusterName:    "d",
+			Status:         "Ready",
+			LastUpdated:    "2024-01-01T00:00:04Z",
+			ManagedCluster: &ManagedClusterState{Name: "d", Labels: map[string]string{"env": "prod"}},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		rawQuery     string
+		want         []string
+		wantContinue bool
+	}{
+		{name: "sorted by name", rawQuery: "", want: []string{"a", "b", "c", "d"}},
+		{name: "status filter is case insensitive", rawQuery: "status=ready", want: []string{"b", "c", "d"}},
+		{name: "selector matches hub labels", rawQuery: "labelSelector=env%3Dprod", want: []string{"c", "d"}},
+		{name: "descending by update time", rawQuery: "sort=-lastUpdated", want: []string{"d", "a", "b", "c"}},
+		{name: "by status then name", rawQuery: "sort=status", want: []string{"a", "b", "c", "d"}},
+		{name: "first page", rawQuery: "limit=3", want: []string{"a", "b", "c"}, wantContinue: true},
+		{name: "last page", rawQuery: "limit=3&continue=" + encodeContinue(3), want: []string{"d"}},
+		{name: "past the end", rawQuery: "continue=" + encodeContinue(10), want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := parseClusterQuery(queryContext(tt.rawQuery))
+			if err != nil {
+				t.Fatalf("parseClusterQuery(%q) error = %v", tt.rawQuery, err)
+			}
+			page, next := query.Page(query.Filter(clusters))
+			if got := clusterNames(page); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("page = %v, want %v", got, tt.want)
+			}
+			if (next != "") != tt.wantContinue {
+				t.Errorf("continue token = %q, want present %v", next, tt.wantContinue)
+			}
+		})
+	}
+}
+
+func TestContinueTokenRoundTrip(t *testing.T) {
+	for _, offset := range []int{0, 1, 250} {
+		got, err := decodeContinue(encodeContinue(offset))
+		if err != nil || got != offset {
+			t.Errorf("decodeContinue(encodeContinue(%d)) = %d, %v", offset, got, err)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit is a token bucket refilled at RequestsPerSecond and holding at
+// most Burst requests
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	// Burst defaults to RequestsPerSecond rounded up
+	Burst int `json:"burst,omitempty"`
+}
+
+// RateLimitConfig is the rateLimit section of the Initialize config. Each
+// configured limit applies on its own; a request is served only when all of
+// them allow it.
+type RateLimitConfig struct {
+	// Global bounds all requests to the plugin
+	Global *RateLimit `json:"global,omitempty"`
+	// Endpoints bounds the requests to each endpoint, keyed by handler name
+	Endpoints map[string]RateLimit `json:"endpoints,omitempty"`
+	// PerAddress bounds the requests from each client address. Unlike the
+	// per-caller limit it applies before authentication, so callers can't
+	// flood the plugin with bad credentials.
+	PerAddress *RateLimit `json:"perAddress,omitempty"`
+	// PerCaller bounds the requests of each authenticated subject, or of
+	// each client address for anonymous callers
+	PerCaller *RateLimit `json:"perCaller,omitempty"`
+}
+
+func (l RateLimit) validate(name string) error {
+	if l.RequestsPerSecond <= 0 {
+		return fmt.Errorf("%s: requestsPerSecond must be positive", name)
+	}
+	if l.Burst < 0 {
+		return fmt.Errorf("%s: burst must not be negative", name)
+	}
+	return nil
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Ceil(l.RequestsPerSecond)
+}
+
+// Validate checks every limit and that endpoint limits name known handlers
+func (rc RateLimitConfig) Validate(metadata PluginMetadata) error {
+	if rc.Global != nil {
+		if err := rc.Global.validate("global"); err != nil {
+			return err
+		}
+	}
+	if rc.PerAddress != nil {
+		if err := rc.PerAddress.validate("perAddress"); err != nil {
+			return err
+		}
+	}
+	if rc.PerCaller != nil {
+		if err := rc.PerCaller.validate("perCaller"); err != nil {
+			return err
+		}
+	}
+	handlers := make(map[string]bool, len(metadata.Endpoints))
+	for _, endpoint := range metadata.Endpoints {
+		handlers[endpoint.Handler] = true
+	}
+	for handler, limit := range rc.Endpoints {
+		if !handlers[handler] {
+			return fmt.Errorf("endpoints: no endpoint is served by %s", handler)
+		}
+		if err := limit.validate("endpoints." + handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rateLimitConfigFromConfig(config map[string]interface{}) (RateLimitConfig, error) {
+	var rc RateLimitConfig
+	raw, ok := config["rateLimit"]
+	if !ok {
+		return rc, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return rc, fmt.Errorf("invalid rateLimit config: %w", err)
+	}
+	if err := json.Unmarshal(data, &rc); err != nil {
+		return rc, fmt.Errorf("invalid rateLimit config: %w", err)
+	}
+	return rc, nil
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter enforces a RateLimitConfig with one token bucket per limit,
+// endpoint, client address and caller
+type rateLimiter struct {
+	config  RateLimitConfig
+	buckets map[string]*tokenBucket
+	mutex   sync.Mutex
+	// now is replaced in tests
+	now func() time.Time
+	// swept is when idle address and caller buckets were last dropped
+	swept time.Time
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{config: config, buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+// limitCheck pairs a bucket key with the limit refilling it
+type limitCheck struct {
+	key   string
+	limit RateLimit
+}
+
+// Allow applies the limits checked before authentication: the global, the
+// endpoint and the client address limits
+func (rl *rateLimiter) Allow(handlerName, address string) (bool, time.Duration) {
+	var checks []limitCheck
+	if rl.config.Global != nil {
+		checks = append(checks, limitCheck{"global", *rl.config.Global})
+	}
+	if limit, ok := rl.config.Endpoints[handlerName]; ok {
+		checks = append(checks, limitCheck{"endpoint:" + handlerName, limit})
+	}
+	if rl.config.PerAddress != nil {
+		checks = append(checks, limitCheck{"address:" + address, *rl.config.PerAddress})
+	}
+	return rl.take(checks)
+}
+
+// AllowCaller applies the per-caller limit once the caller is known
+func (rl *rateLimiter) AllowCaller(caller string) (bool, time.Duration) {
+	if rl.config.PerCaller == nil {
+		return true, 0
+	}
+	return rl.take([]limitCheck{{"caller:" + caller, *rl.config.PerCaller}})
+}
+
+// take takes a token from every bucket of checks, or none when any of them
+// is empty; it then returns how long to wait before retrying
+func (rl *rateLimiter) take(checks []limitCheck) (bool, time.Duration) {
+	if len(checks) == 0 {
+		return true, 0
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+	var wait time.Duration
+	buckets := make([]*tokenBucket, len(checks))
+	for i, check := range checks {
+		bucket, exists := rl.buckets[check.key]
+		if !exists {
+			bucket = &tokenBucket{tokens: check.limit.burst(), updated: now}
+			rl.buckets[check.key] = bucket
+		}
+		elapsed := now.Sub(bucket.updated).Seconds()
+		bucket.tokens = math.Min(check.limit.burst(), bucket.tokens+elapsed*check.limit.RequestsPerSecond)
+		bucket.updated = now
+		if bucket.tokens < 1 {
+			missing := time.Duration((1 - bucket.tokens) / check.limit.RequestsPerSecond * float64(time.Second))
+			if missing > wait {
+				wait = missing
+			}
+		}
+		buckets[i] = bucket
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return true, 0
+}
+
+// sweep drops the address and caller buckets that have refilled completely,
+// so clients that went away don't accumulate. It runs at most once a minute.
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.swept) < time.Minute {
+		return
+	}
+	rl.swept = now
+	for key, bucket := range rl.buckets {
+		var limit *RateLimit
+		switch {
+		case strings.HasPrefix(key, "address:"):
+			limit = rl.config.PerAddress
+		case strings.HasPrefix(key, "caller:"):
+			limit = rl.config.PerCaller
+		}
+		if limit == nil {
+			continue
+		}
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*limit.RequestsPerSecond >= limit.burst() {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// withRateLimit wraps a handler so that requests over the global, endpoint
+// or client address limits are answered with 429 Too Many Requests and a
+// Retry-After header. It runs before authentication.
+func (cp *ClusterPlugin) withRateLimit(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		limiter := cp.rateLimiter
+		cp.mutex.RUnlock()
+
+		if limiter != nil {
+			if allowed, wait := limiter.Allow(handlerName, c.ClientIP()); !allowed {
+				rejectRateLimited(c, wait)
+				return
+			}
+		}
+		handler(c)
+	}
+}
+
+// withCallerRateLimit wraps a handler with the per-caller limit. It runs after
+// authentication, so that only a verified subject earns its own bucket;
+// anything the caller merely claims, such as an API key header, could be
+// rotated freely.
+func (cp *ClusterPlugin) withCallerRateLimit(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		limiter := cp.rateLimiter
+		cp.mutex.RUnlock()
+
+		if limiter != nil {
+			caller := "ip:" + c.ClientIP()
+			if p, ok := principal(c); ok && p.Subject != "" {
+				caller = "sub:" + p.Subject
+			}
+			if allowed, wait := limiter.AllowCaller(caller); !allowed {
+				rejectRateLimited(c, wait)
+				return
+			}
+		}
+		handler(c)
+	}
+}
+
+func rejectRateLimited(c *gin.Context, wait time.Duration) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	requestLogger(c).Warn("Rate limited request", "method", c.Request.Method, "path", c.Request.URL.Path, "retryAfter", retryAfter)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	abortWithProblem(c, http.StatusTooManyRequests, CodeRateLimited, fmt.Sprintf("Rate limit exceeded, retry in %ds", retryAfter))
+}
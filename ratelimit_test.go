@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := newRateLimiter(RateLimitConfig{
+		Global:     &RateLimit{RequestsPerSecond: 100},
+		Endpoints:  map[string]RateLimit{"OnboardClusterHandler": {RequestsPerSecond: 1, Burst: 2}},
+		PerAddress: &RateLimit{RequestsPerSecond: 0.5, Burst: 3},
+		PerCaller:  &RateLimit{RequestsPerSecond: 1},
+	})
+	now := time.Unix(0, 0)
+	limiter.now = func() time.Time { return now }
+
+	steps := []struct {
+		name     string
+		advance  time.Duration
+		handler  string
+		address  string
+		want     bool
+		wantWait time.Duration
+	}{
+		{name: "first onboard", handler: "OnboardClusterHandler", address: "a", want: true},
+		{name: "second onboard", handler: "OnboardClusterHandler", address: "b", want: true},
+		{name: "endpoint bucket empty", handler: "OnboardClusterHandler", address: "c", want: false, wantWait: time.Second},
+		{name: "other endpoint unaffected", handler: "ListJobsHandler", address: "a", want: true},
+		{name: "address bucket empty", handler: "ListJobsHandler", address: "a", want: true},
+		{name: "address over limit", handler: "ListJobsHandler", address: "a", want: false, wantWait: 2 * time.Second},
+		{name: "endpoint refilled", advance: time.Second, handler: "OnboardClusterHandler", address: "c", want: true},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		allowed, wait := limiter.Allow(step.handler, step.address)
+		if allowed != step.want || wait != step.wantWait {
+			t.Fatalf("%s: Allow() = %v, %v, want %v, %v", step.name, allowed, wait, step.want, step.wantWait)
+		}
+	}
+
+	if allowed, _ := limiter.AllowCaller("sub:alice"); !allowed {
+		t.Fatal("AllowCaller() refused a new caller")
+	}
+	if allowed, wait := limiter.AllowCaller("sub:alice"); allowed || wait != time.Second {
+		t.Fatalf("AllowCaller() over limit = %v, %v, want false, 1s", allowed, wait)
+	}
+}
+
+func TestWithCallerRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := &ClusterPlugin{rateLimiter: newRateLimiter(RateLimitConfig{
+		PerCaller: &RateLimit{RequestsPerSecond: 1},
+	})}
+	handler := plugin.withCallerRateLimit(func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(subject, apiKey string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodGet, "/jobs", nil)
+		c.Request.Header.Set("X-API-Key", apiKey)
+		if subject != "" {
+			c.Set(principalKey, Principal{Subject: subject})
+		}
+		handler(c)
+		return recorder
+	}
+
+	if got := request("dashboard", "").Code; got != http.StatusOK {
+		t.Fatalf("first request status = %d, want %d", got, http.StatusOK)
+	}
+	limited := request("dashboard", "")
+	if limited.Code != http.StatusTooManyRequests || limited.Header().Get("Retry-After") != "1" {
+		t.Fatalf("second request = %d, Retry-After %q, want %d, \"1\"", limited.Code, limited.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+	if got := request("cli", "").Code; got != http.StatusOK {
+		t.Fatalf("other subject status = %d, want %d", got, http.StatusOK)
+	}
+
+	// Anonymous callers share their address's bucket whatever key they send
+	if got := request("", "key-1").Code; got != http.StatusOK {
+		t.Fatalf("anonymous request status = %d, want %d", got, http.StatusOK)
+	}
+	if got := request("", "key-2").Code; got != http.StatusTooManyRequests {
+		t.Fatalf("anonymous request with a new key status = %d, want %d", got, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitBeforeAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth, err := newAuthenticator(AuthConfig{
+		Enabled:     true,
+		SigningKeys: []SigningKey{{Algorithm: "HS256", Secret: "s3cret"}},
+	})
+	if err != nil {
+		t.Fatalf("newAuthenticator() error = %v", err)
+	}
+	plugin := &ClusterPlugin{auth: auth, rateLimiter: newRateLimiter(RateLimitConfig{
+		PerAddress: &RateLimit{RequestsPerSecond: 1, Burst: 2},
+	})}
+	handler := plugin.GetHandlers()["ListJobsHandler"]
+
+	codes := make([]int, 3)
+	for i := range codes {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodGet, "/jobs", nil)
+		c.Request.Header.Set("Authorization", "Bearer forged")
+		handler(c)
+		codes[i] = recorder.Code
+	}
+	if want := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("statuses of forged tokens = %v, want %v", codes, want)
+	}
+}
+
+func TestRateLimitConfigValidate(t *testing.T) {
+	metadata := PluginMetadata{Endpoints: []EndpointConfig{{Handler: "OnboardClusterHandler"}}}
+	tests := []struct {
+		name    string
+		config  RateLimitConfig
+		wantErr bool
+	}{
+		{name: "valid", config: RateLimitConfig{Endpoints: map[string]RateLimit{"OnboardClusterHandler": {RequestsPerSecond: 1}}}},
+		{name: "unknown handler", config: RateLimitConfig{Endpoints: map[string]RateLimit{"NopeHandler": {RequestsPerSecond: 1}}}, wantErr: true},
+		{name: "zero rate", config: RateLimitConfig{Global: &RateLimit{}}, wantErr: true},
+		{name: "negative burst", config: RateLimitConfig{PerCaller: &RateLimit{RequestsPerSecond: 1, Burst: -1}}, wantErr: true},
+		{name: "zero address rate", config: RateLimitConfig{PerAddress: &RateLimit{}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(metadata); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultRoles are the roles available without any configuration
+var defaultRoles = map[string][]string{
+	"viewer":   {"cluster.read"},
+	"operator": {"cluster.read", "cluster.write"},
+	"admin":    {"*"},
+}
+
+// RBACConfig is the rbac section of the Initialize config. Roles can also be
+// kept in a YAML file of the same shape named by RolesPath.
+type RBACConfig struct {
+	Enabled bool `json:"enabled"`
+	// RoleClaim names the token claim listing the caller's roles
+	RoleClaim string `json:"roleClaim,omitempty"`
+	// Roles maps role names to the permissions they grant, "*" granting all;
+	// they are added to or override the viewer, operator and admin defaults
+	Roles map[string][]string `json:"roles,omitempty"`
+	// Subjects grants roles to callers by token subject, for tokens that
+	// carry no role claim
+	Subjects  map[string][]string `json:"subjects,omitempty"`
+	RolesPath string              `json:"rolesPath,omitempty"`
+}
+
+func rbacConfigFromConfig(config map[string]interface{}) (RBACConfig, error) {
+	rc := RBACConfig{RoleClaim: "roles"}
+	if raw, ok := config["rbac"]; ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return rc, fmt.Errorf("invalid rbac config: %w", err)
+		}
+		if err := json.Unmarshal(data, &rc); err != nil {
+			return rc, fmt.Errorf("invalid rbac config: %w", err)
+		}
+	}
+	if rc.RolesPath != "" {
+		data, err := os.ReadFile(rc.RolesPath)
+		if err != nil {
+			return rc, fmt.Errorf("failed to read rbac roles: %w", err)
+		}
+		var file RBACConfig
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return rc, fmt.Errorf("invalid rbac roles in %s: %w", rc.RolesPath, err)
+		}
+		rc.Roles = mergeRoles(rc.Roles, file.Roles)
+		rc.Subjects = mergeRoles(rc.Subjects, file.Subjects)
+	}
+	return rc, nil
+}
+
+// mergeRoles returns base with the entries of override added or replaced
+func mergeRoles(base, override map[string][]string) map[string][]string {
+	merged := make(map[string][]string, len(base)+len(override))
+	for name, values := range base {
+		merged[name] = values
+	}
+	for name, values := range override {
+		merged[name] = values
+	}
+	return merged
+}
+
+// roleBinder maps the roles of callers to the permissions the plugin declares
+type roleBinder struct {
+	roleClaim string
+	roles     map[string]map[string]bool
+	subjects  map[string][]string
+}
+
+// newRoleBinder checks that every role grants declared permissions and every
+// subject is bound to defined roles
+func newRoleBinder(config RBACConfig, metadata PluginMetadata) (*roleBinder, error) {
+	declared := make(map[string]bool, len(metadata.Permissions))
+	for _, permission := range metadata.Permissions {
+		declared[permission] = true
+	}
+
+	rb := &roleBinder{
+		roleClaim: config.RoleClaim,
+		roles:     make(map[string]map[string]bool),
+		subjects:  config.Subjects,
+	}
+	for name, permissions := range mergeRoles(defaultRoles, config.Roles) {
+		granted := make(map[string]bool, len(permissions))
+		for _, permission := range permissions {
+			if permission != "*" && !declared[permission] {
+				return nil, fmt.Errorf("role %s grants undeclared permission %s", name, permission)
+			}
+			granted[permission] = true
+		}
+		rb.roles[name] = granted
+	}
+	for subject, roles := range config.Subjects {
+		for _, role := range roles {
+			if rb.roles[role] == nil {
+				return nil, fmt.Errorf("subject %s is bound to unknown role %s", subject, role)
+			}
+		}
+	}
+	return rb, nil
+}
+
+// callerRoles returns the roles named by the role claim of the caller's
+// token together with those bound to its subject
+func (rb *roleBinder) callerRoles(caller Principal) []string {
+	var roles []string
+	switch claim := caller.Claims[rb.roleClaim].(type) {
+	case string:
+		roles = append(roles, claim)
+	case []interface{}:
+		for _, role := range claim {
+			if name, ok := role.(string); ok {
+				roles = append(roles, name)
+			}
+		}
+	}
+	roles = append(roles, rb.subjects[caller.Subject]...)
+	sort.Strings(roles)
+	return roles
+}
+
+// allowed reports whether any of roles grants permission
+func (rb *roleBinder) allowed(roles []string, permission string) bool {
+	for _, role := range roles {
+		if granted := rb.roles[role]; granted["*"] || granted[permission] {
+			return true
+		}
+	}
+	return false
+}
+
+// withRBAC wraps a handler so that it only runs when one of the caller's roles
+// grants the permission its endpoint declares. Anonymous callers only get
+// here through public reads and are let through.
+func (cp *ClusterPlugin) withRBAC(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		rbac := cp.rbac
+		cp.mutex.RUnlock()
+
+		caller, authenticated := principal(c)
+		if rbac == nil || !authenticated {
+			handler(c)
+			return
+		}
+
+		required := cp.requiredPermission(handlerName)
+		roles := rbac.callerRoles(caller)
+		if required == "" || !rbac.allowed(roles, required) {
+			requestLogger(c).Warn("Denied request, no role grants the permission", "method", c.Request.Method, "path", c.Request.URL.Path,
+				"subject", caller.Subject, "roles", roles, "permission", required)
+			abortWithProblem(c, http.StatusForbidden, CodePermissionDenied, "Caller's roles don't grant the permission required for this endpoint",
+				"requiredPermission", required)
+			return
+		}
+		handler(c)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRoleBinderAllowed(t *testing.T) {
+	binder, err := newRoleBinder(RBACConfig{
+		RoleClaim: "roles",
+		Roles:     map[string][]string{"auditor": {"cluster.read", "secret.read"}},
+		Subjects:  map[string][]string{"ci-bot": {"operator"}},
+	}, defaultMetadata())
+	if err != nil {
+		t.Fatalf("newRoleBinder() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		caller     Principal
+		permission string
+		want       bool
+	}{
+		{name: "viewer reads", caller: Principal{Claims: map[string]interface{}{"roles": []interface{}{"viewer"}}}, permission: "cluster.read", want: true},
+		{name: "viewer can't write", caller: Principal{Claims: map[string]interface{}{"roles": "viewer"}}, permission: "cluster.write"},
+		{name: "operator writes", caller: Principal{Claims: map[string]interface{}{"roles": []interface{}{"operator"}}}, permission: "cluster.write", want: true},
+		{name: "admin gets everything", caller: Principal{Claims: map[string]interface{}{"roles": "admin"}}, permission: "csr.approve", want: true},
+		{name: "custom role", caller: Principal{Claims: map[string]interface{}{"roles": "auditor"}}, permission: "secret.read", want: true},
+		{name: "subject binding", caller: Principal{Subject: "ci-bot"}, permission: "cluster.write", want: true},
+		{name: "no roles", caller: Principal{Subject: "stranger"}, permission: "cluster.read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := binder.allowed(binder.callerRoles(tt.caller), tt.permission); got != tt.want {
+				t.Errorf("allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRoleBinderRejectsUnknown(t *testing.T) {
+	if _, err := newRoleBinder(RBACConfig{Roles: map[string][]string{"bad": {"cluster.delete"}}}, defaultMetadata()); err == nil {
+		t.Error("newRoleBinder() accepted a role granting an undeclared permission")
+	}
+	if _, err := newRoleBinder(RBACConfig{Subjects: map[string][]string{"alice": {"superuser"}}}, defaultMetadata()); err == nil {
+		t.Error("newRoleBinder() accepted a subject bound to an unknown role")
+	}
+}
+
+func TestRBACConfigRolesPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.yaml")
+	roles := "roles:\n  viewer:\n    - cluster.read\n    - configmap.read\nsubjects:\n  alice:\n    - admin\n"
+	if err := os.WriteFile(path, []byte(roles), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := rbacConfigFromConfig(map[string]interface{}{"rbac": map[string]interface{}{"enabled": true, "rolesPath": path}})
+	if err != nil {
+		t.Fatalf("rbacConfigFromConfig() error = %v", err)
+	}
+	if config.RoleClaim != "roles" || len(config.Roles["viewer"]) != 2 || config.Subjects["alice"][0] != "admin" {
+		t.Errorf("rbacConfigFromConfig() = %+v", config)
+	}
+}
+
+func TestWithRBAC(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	binder, err := newRoleBinder(RBACConfig{RoleClaim: "roles"}, defaultMetadata())
+	if err != nil {
+		t.Fatalf("newRoleBinder() error = %v", err)
+	}
+	plugin := &ClusterPlugin{rbac: binder}
+	viewer := Principal{Subject: "alice", Claims: map[string]interface{}{"roles": "viewer"}}
+
+	tests := []struct {
+		handler    string
+		wantStatus int
+	}{
+		{handler: "GetClusterStatusHandler", wantStatus: http.StatusOK},
+		{handler: "DetachClusterHandler", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.handler, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			c.Set(principalKey, viewer)
+			plugin.withRBAC(tt.handler, func(c *gin.Context) { c.Status(http.StatusOK) })(c)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+)
+
+// Drift kinds reported by a reconcile pass
+const (
+	// driftMissing is a desired cluster that isn't onboarded
+	driftMissing = "missing"
+	// driftFailed is a desired cluster whose onboarding failed
+	driftFailed = "failed"
+	// driftExtra is an onboarded cluster that isn't desired
+	driftExtra = "extra"
+	// driftHubMismatch is a desired cluster onboarded to another hub than
+	// the desired one, which is left for an operator to move
+	driftHubMismatch = "hubMismatch"
+)
+
+// DesiredMembership is the cluster membership POST /reconcile asks the
+// plugin to converge on. The desired clusters are listed in Clusters or read
+// from Source on every pass; Selector narrows either down by label.
+type DesiredMembership struct {
+	Clusters []ImportCluster   `json:"clusters,omitempty"`
+	Source   *MembershipSource `json:"source,omitempty"`
+	// Selector keeps the desired clusters whose labels match it
+	Selector string `json:"selector,omitempty"`
+	// Labels are added to every cluster the reconciler onboards
+	Labels map[string]string `json:"labels,omitempty"`
+	// DryRun reports the drift without onboarding or detaching anything
+	DryRun bool `json:"dryRun,omitempty"`
+	// IntervalSeconds is how often the membership is reconciled, the
+	// reconcileIntervalSeconds config by default
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+}
+
+// MembershipSource is an external source of truth holding CSV or YAML import
+// manifests, or inventories from GET /clusters/export: either one served over
+// HTTP at URL or those kept in a Git repository
+type MembershipSource struct {
+	URL string `json:"url,omitempty"`
+	// Format is csv or yaml, told from the URL or the Content-Type of the
+	// answer by default
+	Format string     `json:"format,omitempty"`
+	Git    *GitSource `json:"git,omitempty"`
+}
+
+// Validate checks the membership, which may describe no clusters only
+// through a source
+func (m DesiredMembership) Validate() error {
+	if (len(m.Clusters) > 0) == (m.Source != nil) {
+		return fmt.Errorf("exactly one of clusters and source must be set")
+	}
+	if m.Source != nil && m.Source.Git != nil {
+		if m.Source.URL != "" || m.Source.Format != "" {
+			return fmt.Errorf("source.url and source.format don't apply to source.git")
+		}
+		if err := m.Source.Git.Validate(); err != nil {
+			return fmt.Errorf("source.git: %w", err)
+		}
+	} else if m.Source != nil {
+		parsed, err := url.Parse(m.Source.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("source.url must be an http or https URL")
+		}
+		if m.Source.Format != "" && m.Source.Format != "csv" && m.Source.Format != "yaml" {
+			return fmt.Errorf("source.format must be csv or yaml")
+		}
+	}
+	seen := map[string]bool{}
+	for i, cluster := range m.Clusters {
+		if _, err := cluster.onboardRequest(); err != nil {
+			return fmt.Errorf("clusters[%d]: %w", i, err)
+		}
+		if seen[cluster.Name] {
+			return fmt.Errorf("clusters[%d]: cluster %q is listed twice", i, cluster.Name)
+		}
+		seen[cluster.Name] = true
+	}
+	if m.Selector != "" {
+		if _, err := k8slabels.Parse(m.Selector); err != nil {
+			return fmt.Errorf("invalid selector: %w", err)
+		}
+	}
+	if err := validateLabels(m.Labels); err != nil {
+		return err
+	}
+	if m.IntervalSeconds < 0 {
+		return fmt.Errorf("intervalSeconds must not be negative")
+	}
+	return nil
+}
+
+// MembershipDrift is a difference between the desired and the onboarded
+// clusters, with what the pass did about it
+type MembershipDrift struct {
+	ClusterName string `json:"clusterName"`
+	// Kind is missing, failed, extra or hubMismatch
+	Kind string `json:"kind"`
+	// Action is onboarding, retrying or detaching when the pass started a
+	// job, waiting while an earlier job runs, and none otherwise
+	Action string `json:"action"`
+	JobID  string `json:"jobId,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReconcileReport is the outcome of a reconcile pass
+type ReconcileReport struct {
+	StartedAt  string `json:"startedAt"`
+	FinishedAt string `json:"finishedAt"`
+	Desired    int    `json:"desired"`
+	Onboarded  int    `json:"onboarded"`
+	// InSync is true when the pass found no drift
+	InSync bool              `json:"inSync"`
+	Drift  []MembershipDrift `json:"drift"`
+	// BatchID is the batch of the onboardings the pass started, if any
+	BatchID string `json:"batchId,omitempty"`
+	// Commit is the commit of a Git source the pass synced
+	Commit *GitCommit `json:"commit,omitempty"`
+	// SourceErrors are the rows of the source that couldn't be read
+	SourceErrors []string `json:"sourceErrors,omitempty"`
+	// Error is why the pass couldn't complete
+	Error string `json:"error,omitempty"`
+}
+
+// membershipReconciler converges the onboarded clusters on the desired
+// membership, which it keeps on disk across restarts
+type membershipReconciler struct {
+	path     string
+	interval time.Duration
+	// gitDir holds the checkouts of Git sources
+	gitDir string
+
+	mutex   sync.Mutex
+	desired *DesiredMembership
+	setAt   time.Time
+	loop    *periodicCheck
+	last    *ReconcileReport
+	passes  int
+	// commits reports the sync of the latest commits of a Git source,
+	// newest first
+	commits []CommitSync
+}
+
+func newMembershipReconciler(path, gitDir string, interval time.Duration) (*membershipReconciler, error) {
+	mr := &membershipReconciler{path: path, gitDir: gitDir, interval: interval}
+	if path == "" {
+		return mr, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return mr, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read desired membership file: %w", err)
+	}
+	var stored struct {
+		Desired DesiredMembership `json:"desired"`
+		SetAt   time.Time         `json:"setAt"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode desired membership file: %w", err)
+	}
+	mr.desired = &stored.Desired
+	mr.setAt = stored.SetAt
+	return mr, nil
+}
+
+// save writes the desired membership, removing the file once there is none
+func (mr *membershipReconciler) save() error {
+	if mr.path == "" {
+		return nil
+	}
+	if mr.desired == nil {
+		if err := os.Remove(mr.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove desired membership file: %w", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(gin.H{"desired": mr.desired, "setAt": mr.setAt}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode desired membership: %w", err)
+	}
+	tmpPath := mr.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write desired membership file: %w", err)
+	}
+	return os.Rename(tmpPath, mr.path)
+}
+
+// start reconciles the desired membership, if any, right away and then
+// every interval. The previous loop must have been stopped.
+func (mr *membershipReconciler) start(pass func(ctx context.Context)) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	if mr.desired == nil {
+		return
+	}
+	interval := mr.interval
+	if mr.desired.IntervalSeconds > 0 {
+		interval = time.Duration(mr.desired.IntervalSeconds) * time.Second
+	}
+	mr.loop = newPeriodicCheck(interval, pass)
+	mr.loop.Start()
+}
+
+// stop ends the reconcile loop and waits for a running pass
+func (mr *membershipReconciler) stop() {
+	mr.mutex.Lock()
+	loop := mr.loop
+	mr.loop = nil
+	mr.mutex.Unlock()
+	if loop != nil {
+		loop.Stop()
+	}
+}
+
+// fetchMembershipSource reads the clusters of an external source
+func (cp *ClusterPlugin) fetchMembershipSource(ctx context.Context, source MembershipSource) ([]importRow, map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, source.URL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch source: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("source answered %s", response.Status)
+	}
+	limit := cp.uploadLimit()
+	data, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read source: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, nil, fmt.Errorf("source exceeds the limit of %d bytes", limit)
+	}
+	format := source.Format
+	if format == "" {
+		contentType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+		format = importFormat(request.URL.Path, contentType)
+	}
+	return parseImportManifest(data, format)
+}
+
+// desiredClusters resolves the membership to the clusters it wants onboarded,
+// by name, along with the source rows that couldn't be read and the commit
+// of a Git source
+func (cp *ClusterPlugin) desiredClusters(ctx context.Context, desired DesiredMembership) (map[string]ImportCluster, []string, *GitCommit, error) {
+	clusters := desired.Clusters
+	var sourceLabels map[string]string
+	var sourceErrors []string
+	var commit *GitCommit
+	if desired.Source != nil {
+		var rows []importRow
+		var labels map[string]string
+		var err error
+		if desired.Source.Git != nil {
+			rows, commit, err = cp.readGitSource(ctx, *desired.Source.Git)
+		} else {
+			rows, labels, err = cp.fetchMembershipSource(ctx, *desired.Source)
+		}
+		if err != nil {
+			return nil, nil, commit, err
+		}
+		sourceLabels = labels
+		clusters = nil
+		for _, row := range rows {
+			if row.err == nil {
+				_, row.err = row.cluster.onboardRequest()
+			}
+			if row.err != nil {
+				position := fmt.Sprintf("row %d", row.row)
+				switch {
+				case row.file != "" && row.row == 0:
+					position = row.file
+				case row.file != "":
+					position = fmt.Sprintf("%s row %d", row.file, row.row)
+				}
+				sourceErrors = append(sourceErrors, fmt.Sprintf("%s: %v", position, row.err))
+				continue
+			}
+			clusters = append(clusters, row.cluster)
+		}
+	}
+
+	var selector k8slabels.Selector
+	if desired.Selector != "" {
+		parsed, err := k8slabels.Parse(desired.Selector)
+		if err != nil {
+			return nil, nil, commit, fmt.Errorf("invalid selector: %w", err)
+		}
+		selector = parsed
+	}
+	result := make(map[string]ImportCluster, len(clusters))
+	for _, cluster := range clusters {
+		labels := make(map[string]string, len(sourceLabels)+len(cluster.Labels)+len(desired.Labels))
+		for key, value := range sourceLabels {
+			labels[key] = value
+		}
+		for key, value := range cluster.Labels {
+			labels[key] = value
+		}
+		if selector != nil && !selector.Matches(k8slabels.Set(labels)) {
+			continue
+		}
+		if _, exists := result[cluster.Name]; exists {
+			sourceErrors = append(sourceErrors, fmt.Sprintf("cluster %q is listed twice", cluster.Name))
+			continue
+		}
+		for key, value := range desired.Labels {
+			labels[key] = value
+		}
+		if len(labels) == 0 {
+			labels = nil
+		}
+		cluster.Labels = labels
+		result[cluster.Name] = cluster
+	}
+	return result, sourceErrors, commit, nil
+}
+
+// reconcileMembership runs one pass: it onboards the desired clusters that
+// are missing or failed and detaches the onboarded ones that aren't desired.
+// Clusters with a job in flight are left to it.
+func (cp *ClusterPlugin) reconcileMembership(ctx context.Context) {
+	mr := cp.reconciler
+	mr.mutex.Lock()
+	if mr.desired == nil {
+		mr.mutex.Unlock()
+		return
+	}
+	desired := *mr.desired
+	mr.mutex.Unlock()
+
+	report := cp.reconcilePass(ctx, desired)
+	if report.Error != "" {
+		logger().Warn("Membership reconcile failed", "error", report.Error)
+	} else if !report.InSync {
+		logger().Info("Membership drift reconciled", "desired", report.Desired, "onboarded", report.Onboarded, "drift", len(report.Drift), "dryRun", desired.DryRun)
+	}
+	mr.mutex.Lock()
+	mr.last = &report
+	mr.passes++
+	if report.Commit != nil {
+		mr.recordCommitSync(report)
+	}
+	mr.mutex.Unlock()
+}
+
+func (cp *ClusterPlugin) reconcilePass(ctx context.Context, desired DesiredMembership) ReconcileReport {
+	report := ReconcileReport{StartedAt: time.Now().Format(time.RFC3339), Drift: []MembershipDrift{}}
+	finish := func() ReconcileReport {
+		report.FinishedAt = time.Now().Format(time.RFC3339)
+		report.InSync = report.Error == "" && len(report.Drift) == 0
+		return report
+	}
+
+	wanted, sourceErrors, commit, err := cp.desiredClusters(ctx, desired)
+	report.SourceErrors = sourceErrors
+	report.Commit = commit
+	if err != nil {
+		report.Error = err.Error()
+		return finish()
+	}
+	report.Desired = len(wanted)
+	clusters, err := cp.store.List()
+	if err != nil {
+		report.Error = fmt.Sprintf("failed to read cluster store: %v", err)
+		return finish()
+	}
+	onboarded := make(map[string]ClusterStatus, len(clusters))
+	for _, cluster := range clusters {
+		onboarded[cluster.ClusterName] = cluster
+	}
+	report.Onboarded = len(onboarded)
+
+	busy := func(cluster ClusterStatus) bool {
+		job, ok := cp.jobs.Get(cluster.JobID)
+		return ok && !job.Finished()
+	}
+	// reqCtx carries a request ID so the jobs of a pass can be told apart
+	reqCtx := context.WithValue(ctx, requestIDContextKey{}, newJobID("reconcile"))
+	batch := &Batch{ID: requestIDFromContext(reqCtx), CreatedAt: report.StartedAt, Source: "reconcile"}
+	var tasks []batchTask
+
+	names := make([]string, 0, len(wanted))
+	for name := range wanted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cluster := wanted[name]
+		existing, exists := onboarded[name]
+		drift := MembershipDrift{ClusterName: name, Action: "none"}
+		switch {
+		case !exists:
+			drift.Kind = driftMissing
+		case cluster.Hub != "" && hubName(existing) != cluster.Hub:
+			drift.Kind = driftHubMismatch
+			drift.Error = fmt.Sprintf("onboarded to hub '%s'", hubName(existing))
+		case existing.Status == "Failed":
+			drift.Kind = driftFailed
+		default:
+			continue
+		}
+		switch {
+		case busy(existing):
+			drift.Action = "waiting"
+			drift.JobID = existing.JobID
+		case desired.DryRun || drift.Kind == driftHubMismatch:
+		case drift.Kind == driftFailed:
+			task, err := cp.beginRetry(reqCtx, name, cluster.Labels)
+			if err != nil {
+				drift.Error = err.Error()
+			} else {
+				drift.Action, drift.JobID = "retrying", task.jobID
+				tasks = append(tasks, task)
+			}
+		default:
+			spec, _ := cluster.onboardRequest()
+			item, task := cp.submitBatchCluster(reqCtx, spec, nil)
+			drift.Error = item.Error
+			if task != nil {
+				drift.Action, drift.JobID = "onboarding", task.jobID
+				tasks = append(tasks, *task)
+			}
+		}
+		if drift.JobID != "" && drift.Action != "waiting" {
+			batch.Items = append(batch.Items, BatchItem{ClusterName: name, JobID: drift.JobID, State: JobPending})
+		}
+		report.Drift = append(report.Drift, drift)
+	}
+
+	extras := make([]string, 0)
+	for name := range onboarded {
+		if _, ok := wanted[name]; !ok {
+			extras = append(extras, name)
+		}
+	}
+	sort.Strings(extras)
+	for _, name := range extras {
+		existing := onboarded[name]
+		drift := MembershipDrift{ClusterName: name, Kind: driftExtra, Action: "none"}
+		switch {
+		case busy(existing):
+			drift.Action = "waiting"
+			drift.JobID = existing.JobID
+		case len(wanted) == 0:
+			// An empty source more likely means a broken one than a wish
+			// to detach the whole fleet
+			drift.Error = "desired membership is empty, not detaching"
+		case desired.DryRun:
+		default:
+			jobID, _, err := cp.beginDetach(reqCtx, name, nil, false, 0, false)
+			if err != nil && !errors.Is(err, errClusterNotFound) {
+				drift.Error = err.Error()
+			} else if err == nil {
+				drift.Action, drift.JobID = "detaching", jobID
+			}
+		}
+		report.Drift = append(report.Drift, drift)
+	}
+
+	if len(tasks) > 0 {
+		report.BatchID = batch.ID
+		cp.batches.Add(batch)
+		go cp.runBatch(batch.ID, tasks)
+	}
+	return finish()
+}
+
+// reconcileStatus reports the desired membership and the last pass
+func (cp *ClusterPlugin) reconcileStatus() gin.H {
+	mr := cp.reconciler
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	status := gin.H{
+		"active":    mr.desired != nil,
+		"passes":    mr.passes,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	if mr.desired != nil {
+		status["desired"] = redactMembership(*mr.desired)
+		status["setAt"] = mr.setAt.Format(time.RFC3339)
+	}
+	if mr.last != nil {
+		status["lastPass"] = mr.last
+	}
+	if len(mr.commits) > 0 {
+		status["commits"] = append([]CommitSync(nil), mr.commits...)
+	}
+	return status
+}
+
+// SetDesiredMembershipHandler replaces the desired membership and starts
+// reconciling the clusters towards it
+func (cp *ClusterPlugin) SetDesiredMembershipHandler(c *gin.Context) {
+	var desired DesiredMembership
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&desired); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Invalid desired membership: %v", err))
+		return
+	}
+	if err := desired.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeValidationFailed, err.Error())
+		return
+	}
+
+	mr := cp.reconciler
+	mr.stop()
+	mr.mutex.Lock()
+	mr.desired = &desired
+	mr.setAt = time.Now()
+	mr.last = nil
+	mr.passes = 0
+	mr.commits = nil
+	err := mr.save()
+	mr.mutex.Unlock()
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	mr.start(cp.reconcileMembership)
+
+	requestLogger(c).Info("Desired membership set", "clusters", len(desired.Clusters), "source", desired.Source != nil, "dryRun", desired.DryRun)
+	status := cp.reconcileStatus()
+	status["message"] = message(c, MsgReconcileStarted)
+	status["messageCode"] = MsgReconcileStarted
+	c.JSON(http.StatusAccepted, status)
+}
+
+// GetReconcileStatusHandler reports the desired membership and the drift the
+// last pass found
+func (cp *ClusterPlugin) GetReconcileStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, cp.reconcileStatus())
+}
+
+// ClearDesiredMembershipHandler stops reconciling, leaving the clusters as
+// they are
+func (cp *ClusterPlugin) ClearDesiredMembershipHandler(c *gin.Context) {
+	mr := cp.reconciler
+	mr.stop()
+	mr.mutex.Lock()
+	mr.desired = nil
+	mr.last = nil
+	mr.passes = 0
+	mr.commits = nil
+	err := mr.save()
+	mr.mutex.Unlock()
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	requestLogger(c).Info("Desired membership cleared")
+	status := cp.reconcileStatus()
+	status["message"] = message(c, MsgReconcileStopped)
+	status["messageCode"] = MsgReconcileStopped
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDesiredMembershipValidate(t *testing.T) {
+	valid := DesiredMembership{Clusters: []ImportCluster{{Name: "edge-1"}}, Selector: "env=prod"}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	for name, desired := range map[string]DesiredMembership{
+		"nothing":          {},
+		"both":             {Clusters: []ImportCluster{{Name: "edge-1"}}, Source: &MembershipSource{URL: "https://example.com/fleet.csv"}},
+		"source scheme":    {Source: &MembershipSource{URL: "file:///etc/fleet.csv"}},
+		"source format":    {Source: &MembershipSource{URL: "https://example.com/fleet", Format: "xml"}},
+		"unnamed cluster":  {Clusters: []ImportCluster{{Hub: "its1"}}},
+		"duplicate":        {Clusters: []ImportCluster{{Name: "edge-1"}, {Name: "edge-1"}}},
+		"bad selector":     {Clusters: []ImportCluster{{Name: "edge-1"}}, Selector: "env in prod"},
+		"bad kubeconfig":   {Clusters: []ImportCluster{{Name: "edge-1", KubeconfigRef: "file:/tmp/x"}}},
+		"negative cadence": {Clusters: []ImportCluster{{Name: "edge-1"}}, IntervalSeconds: -1},
+	} {
+		if err := desired.Validate(); err == nil {
+			t.Errorf("%s: membership accepted", name)
+		}
+	}
+}
+
+// reconcileRouter serves a mock plugin holding the given clusters
+func reconcileRouter(t *testing.T, clusters ...string) (*ClusterPlugin, func(method, path, body string) *httptest.ResponseRecorder) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode":                     modeMock,
+		"mockFleetSize":            0,
+		"mockStepDelayMillis":      1,
+		"reconcileIntervalSeconds": 1,
+	})
+	for _, name := range clusters {
+		plugin.putStatus(ClusterStatus{ClusterName: name, Status: "Ready"})
+	}
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	return plugin, func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, "/api/plugins/kubestellar-cluster-plugin/v1"+path, strings.NewReader(body))
+		if body != "" {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+}
+
+// waitForReconcile polls GET /reconcile/status until check accepts the last pass
+func waitForReconcile(t *testing.T, serve func(method, path, body string) *httptest.ResponseRecorder, check func(ReconcileReport) bool) ReconcileReport {
+	t.Helper()
+	var status struct {
+		LastPass *ReconcileReport `json:"lastPass"`
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		status.LastPass = nil
+		json.Unmarshal(serve(http.MethodGet, "/reconcile/status", "").Body.Bytes(), &status)
+		if status.LastPass != nil && check(*status.LastPass) {
+			return *status.LastPass
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("reconcile never reached the expected state, last pass %+v", status.LastPass)
+	return ReconcileReport{}
+}
+
+func TestReconcileMembershipFromSource(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("name,labels\nkeep-1,env=prod\nnew-1,env=prod\nstaging-1,env=dev\n"))
+	}))
+	defer source.Close()
+	plugin, serve := reconcileRouter(t, "keep-1", "extra-1")
+
+	recorder := serve(http.MethodPost, "/reconcile", `{"source": {"url": "`+source.URL+`/fleet.csv"}, "selector": "env=prod"}`)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("POST /reconcile = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	report := waitForReconcile(t, serve, func(report ReconcileReport) bool { return report.InSync })
+	if report.Desired != 2 || report.Onboarded != 2 {
+		t.Errorf("in-sync pass = %+v", report)
+	}
+	for name, want := range map[string]bool{"keep-1": true, "new-1": true, "extra-1": false, "staging-1": false} {
+		cluster, exists, _ := plugin.store.Get(name)
+		if exists != want || (exists && cluster.Status != "Ready") {
+			t.Errorf("%s: exists %v status %q, want exists %v", name, exists, cluster.Status, want)
+		}
+	}
+	if cluster, _, _ := plugin.store.Get("new-1"); cluster.Labels["env"] != "prod" {
+		t.Errorf("new-1 labels = %v", cluster.Labels)
+	}
+
+	if recorder := serve(http.MethodDelete, "/reconcile", ""); recorder.Code != http.StatusOK {
+		t.Fatalf("DELETE /reconcile = %d", recorder.Code)
+	}
+	var status struct {
+		Active bool `json:"active"`
+	}
+	json.Unmarshal(serve(http.MethodGet, "/reconcile/status", "").Body.Bytes(), &status)
+	if status.Active {
+		t.Error("reconciler still active after DELETE")
+	}
+}
+
+func TestReconcileMembershipDryRun(t *testing.T) {
+	plugin, serve := reconcileRouter(t, "keep-1", "extra-1")
+	recorder := serve(http.MethodPost, "/reconcile", `{"clusters": [{"name": "keep-1"}, {"name": "new-1"}], "dryRun": true}`)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("POST /reconcile = %d %s", recorder.Code, recorder.Body.String())
+	}
+	report := waitForReconcile(t, serve, func(report ReconcileReport) bool { return report.FinishedAt != "" })
+	drift := map[string]MembershipDrift{}
+	for _, item := range report.Drift {
+		drift[item.ClusterName] = item
+	}
+	if report.InSync || drift["new-1"].Kind != driftMissing || drift["extra-1"].Kind != driftExtra || len(drift) != 2 {
+		t.Errorf("drift = %+v", report.Drift)
+	}
+	for _, item := range drift {
+		if item.Action != "none" || item.JobID != "" {
+			t.Errorf("dry run acted on %+v", item)
+		}
+	}
+	if _, exists, _ := plugin.store.Get("extra-1"); !exists {
+		t.Error("dry run detached extra-1")
+	}
+
+	// The desired membership survives a restart
+	reloaded, err := newMembershipReconciler(plugin.reconciler.path, "", time.Minute)
+	if err != nil || reloaded.desired == nil || len(reloaded.desired.Clusters) != 2 {
+		t.Errorf("reloaded membership = %+v, %v", reloaded, err)
+	}
+}
+
+func TestReconcileEmptySourceDetachesNothing(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Write([]byte("name\n"))
+	}))
+	defer source.Close()
+	plugin, serve := reconcileRouter(t, "edge-1")
+
+	serve(http.MethodPost, "/reconcile", `{"source": {"url": "`+source.URL+`"}}`)
+	report := waitForReconcile(t, serve, func(report ReconcileReport) bool { return report.FinishedAt != "" })
+	if len(report.Drift) != 1 || report.Drift[0].Action != "none" || report.Drift[0].Error == "" {
+		t.Errorf("drift = %+v", report.Drift)
+	}
+	if _, exists, _ := plugin.store.Get("edge-1"); !exists {
+		t.Error("empty source detached edge-1")
+	}
+}
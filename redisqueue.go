@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisError is an error reply of the Redis server. Other errors break the
+// connection.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn is a connection speaking the part of RESP2 the job backend needs
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialRedis connects to a redis:// or rediss:// URL, authenticating with its
+// credentials and selecting the database of its path
+func dialRedis(target *url.URL) (*redisConn, error) {
+	address := target.Host
+	if target.Port() == "" {
+		address = net.JoinHostPort(target.Hostname(), "6379")
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if target.Scheme == "rediss" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: target.Hostname(), MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if password, ok := target.User.Password(); ok {
+		args := []string{"AUTH", password}
+		if username := target.User.Username(); username != "" {
+			args = []string{"AUTH", username, password}
+		}
+		if _, err := rc.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if db := strings.Trim(target.Path, "/"); db != "" && db != "0" {
+		if _, err := rc.do("SELECT", db); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do sends a command and reads its reply: a string, an int64, a []interface{}
+// or nil
+func (rc *redisConn) do(args ...string) (interface{}, error) {
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(rc.conn, command.String()); err != nil {
+		return nil, err
+	}
+	return rc.read()
+}
+
+func (rc *redisConn) read() (interface{}, error) {
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rc.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = rc.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (rc *redisConn) Close() error {
+	return rc.conn.Close()
+}
+
+// redisJobBackend keeps the job records in a hash and the queue in a list.
+// Dequeuing moves a job to the processing list of the replica until acked,
+// under a lease the replica renews while the job runs. Any consuming replica
+// sweeps the processing lists of all of them and requeues the jobs whose
+// lease expired, so the jobs of a replica that died under a name never seen
+// again aren't stranded. A replica starting with the same identity requeues
+// what is left in its list right away.
+type redisJobBackend struct {
+	target     *url.URL
+	records    string
+	queue      string
+	processing string
+	// replicas is the set of the processing lists, leases the lease
+	// deadline of each job being processed, in Unix milliseconds
+	replicas string
+	leases   string
+	lease    time.Duration
+
+	// commands serves every command but the blocking dequeue, which has a
+	// connection of its own
+	mutex    sync.Mutex
+	commands *redisConn
+	dequeue  *redisConn
+	// swept is when the consumer last swept the processing lists
+	swept time.Time
+}
+
+func newRedisJobBackend(config JobBackendConfig) (*redisJobBackend, error) {
+	target, err := url.Parse(config.URL)
+	if err != nil || (target.Scheme != "redis" && target.Scheme != "rediss") || target.Host == "" {
+		return nil, fmt.Errorf("invalid jobBackend config: url must be a redis:// or rediss:// URL")
+	}
+	lease := time.Duration(config.AckWaitSeconds) * time.Second
+	if lease <= 0 {
+		lease = time.Minute
+	}
+	rb := &redisJobBackend{
+		target:     target,
+		records:    config.Prefix + ":jobs",
+		queue:      config.Prefix + ":queue",
+		processing: config.Prefix + ":processing:" + config.Identity,
+		replicas:   config.Prefix + ":replicas",
+		leases:     config.Prefix + ":leases",
+		lease:      lease,
+	}
+	// Jobs this replica was running when it last stopped go back in the queue
+	requeued := 0
+	for {
+		reply, err := rb.do("RPOPLPUSH", rb.processing, rb.queue)
+		if err != nil {
+			rb.Close()
+			return nil, fmt.Errorf("failed to reach the redis job backend: %w", err)
+		}
+		if reply == nil {
+			break
+		}
+		rb.dropLease(fmt.Sprint(reply))
+		requeued++
+	}
+	if requeued > 0 {
+		logger().Info("Requeued interrupted jobs", "jobs", requeued, "identity", config.Identity)
+	}
+	if _, err := rb.do("SADD", rb.replicas, rb.processing); err != nil {
+		rb.Close()
+		return nil, fmt.Errorf("failed to reach the redis job backend: %w", err)
+	}
+	return rb, nil
+}
+
+// queuedJobID reads the ID of the job of a queue entry
+func queuedJobID(payload string) string {
+	var job queuedJob
+	json.Unmarshal([]byte(payload), &job)
+	return job.Job.ID
+}
+
+// renewLease gives the replica processing a job another lease period
+func (rb *redisJobBackend) renewLease(id string, now time.Time) error {
+	_, err := rb.do("HSET", rb.leases, id, strconv.FormatInt(now.Add(rb.lease).UnixMilli(), 10))
+	return err
+}
+
+func (rb *redisJobBackend) dropLease(payload string) {
+	if id := queuedJobID(payload); id != "" {
+		rb.do("HDEL", rb.leases, id)
+	}
+}
+
+// sweep requeues the jobs of every processing list whose lease expired. A
+// job found without a lease, such as one dequeued by a replica that died
+// before taking the lease, gets one first, so it is only requeued a lease
+// period after being seen.
+func (rb *redisJobBackend) sweep(now time.Time) error {
+	reply, err := rb.do("SMEMBERS", rb.replicas)
+	if err != nil {
+		return err
+	}
+	lists, _ := reply.([]interface{})
+	for _, item := range lists {
+		list := fmt.Sprint(item)
+		reply, err := rb.do("LRANGE", list, "0", "-1")
+		if err != nil {
+			return err
+		}
+		entries, _ := reply.([]interface{})
+		if len(entries) == 0 && list != rb.processing {
+			// Forget the list of a replica that may be gone, keeping it if
+			// a job landed in it meanwhile
+			if _, err := rb.do("SREM", rb.replicas, list); err != nil {
+				return err
+			}
+			if length, err := rb.do("LLEN", list); err == nil && length != int64(0) {
+				rb.do("SADD", rb.replicas, list)
+			}
+			continue
+		}
+		for _, entry := range entries {
+			payload := fmt.Sprint(entry)
+			id := queuedJobID(payload)
+			deadline, err := rb.do("HGET", rb.leases, id)
+			if err != nil {
+				return err
+			}
+			if deadline == nil {
+				if err := rb.renewLease(id, now); err != nil {
+					return err
+				}
+				continue
+			}
+			if expiry, err := strconv.ParseInt(fmt.Sprint(deadline), 10, 64); err == nil && now.UnixMilli() < expiry {
+				continue
+			}
+			// Whoever removes the entry requeues it, so an ack racing the
+			// sweep can't run the job twice
+			removed, err := rb.do("LREM", list, "1", payload)
+			if err != nil {
+				return err
+			}
+			if removed != int64(1) {
+				continue
+			}
+			if _, err := rb.do("RPUSH", rb.queue, payload); err != nil {
+				return err
+			}
+			rb.do("HDEL", rb.leases, id)
+			logger().Warn("Requeued job whose lease expired", "job", id, "list", list)
+		}
+	}
+	return nil
+}
+
+// do runs a command on the shared connection, reconnecting after a failure
+func (rb *redisJobBackend) do(args ...string) (interface{}, error) {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	if rb.commands == nil {
+		conn, err := dialRedis(rb.target)
+		if err != nil {
+			return nil, err
+		}
+		rb.commands = conn
+	}
+	reply, err := rb.commands.do(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		rb.commands.Close()
+		rb.commands = nil
+	}
+	return reply, err
+}
+
+func (rb *redisJobBackend) SaveJob(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = rb.do("HSET", rb.records, job.ID, string(data))
+	return err
+}
+
+func (rb *redisJobBackend) DeleteJob(id string) error {
+	_, err := rb.do("HDEL", rb.records, id)
+	return err
+}
+
+func (rb *redisJobBackend) LoadJob(id string) (Job, bool, error) {
+	reply, err := rb.do("HGET", rb.records, id)
+	if err != nil || reply == nil {
+		return Job{}, false, err
+	}
+	var job Job
+	if err := json.Unmarshal([]byte(fmt.Sprint(reply)), &job); err != nil {
+		return Job{}, false, fmt.Errorf("invalid record of job %s: %w", id, err)
+	}
+	return job, true, nil
+}
+
+func (rb *redisJobBackend) LoadJobs() ([]Job, error) {
+	reply, err := rb.do("HGETALL", rb.records)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := reply.([]interface{})
+	jobs := make([]Job, 0, len(fields)/2)
+	for i := 1; i < len(fields); i += 2 {
+		var job Job
+		if err := json.Unmarshal([]byte(fmt.Sprint(fields[i])), &job); err != nil {
+			logger().Warn("Skipping invalid job record", "job", fields[i-1], "error", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (rb *redisJobBackend) Enqueue(job queuedJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = rb.do("LPUSH", rb.queue, string(data))
+	return err
+}
+
+// Dequeue blocks for a second at a time so that it notices ctx ending
+func (rb *redisJobBackend) Dequeue(ctx context.Context) (*jobDelivery, error) {
+	for ctx.Err() == nil {
+		if now := time.Now(); now.Sub(rb.swept) >= rb.lease/2 {
+			rb.swept = now
+			if err := rb.sweep(now); err != nil {
+				logger().Warn("Failed to sweep the job processing lists", "error", err)
+			}
+		}
+		if rb.dequeue == nil {
+			conn, err := dialRedis(rb.target)
+			if err != nil {
+				return nil, err
+			}
+			rb.dequeue = conn
+		}
+		reply, err := rb.dequeue.do("BRPOPLPUSH", rb.queue, rb.processing, "1")
+		if err != nil {
+			rb.dequeue.Close()
+			rb.dequeue = nil
+			return nil, err
+		}
+		if reply == nil {
+			continue
+		}
+		payload := fmt.Sprint(reply)
+		ack := func() error {
+			if _, err := rb.do("LREM", rb.processing, "1", payload); err != nil {
+				return err
+			}
+			rb.dropLease(payload)
+			return nil
+		}
+		var job queuedJob
+		if err := json.Unmarshal([]byte(payload), &job); err != nil {
+			ack()
+			return nil, fmt.Errorf("dropped invalid queued job: %w", err)
+		}
+		if err := rb.renewLease(job.Job.ID, time.Now()); err != nil {
+			logger().Warn("Failed to take the lease of a queued job", "job", job.Job.ID, "error", err)
+		}
+		// A sweeper may have dropped the list while it was empty
+		rb.do("SADD", rb.replicas, rb.processing)
+		return &jobDelivery{
+			job: job,
+			ack: ack,
+			// The job goes to the front of the queue before leaving the
+			// processing list, so a failure in between can't lose it
+			nak: func() error {
+				if _, err := rb.do("RPUSH", rb.queue, payload); err != nil {
+					return err
+				}
+				return ack()
+			},
+			touch: func() error { return rb.renewLease(job.Job.ID, time.Now()) },
+		}, nil
+	}
+	return nil, nil
+}
+
+func (rb *redisJobBackend) Close() error {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	if rb.commands != nil {
+		rb.commands.Close()
+		rb.commands = nil
+	}
+	// The dequeue connection belongs to the consumer, which has stopped
+	if rb.dequeue != nil {
+		rb.dequeue.Close()
+		rb.dequeue = nil
+	}
+	return nil
+}
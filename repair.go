@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Failure modes the repair diagnosis recognises
+const (
+	problemSpokeUnreachable   = "spoke-unreachable"
+	problemHubUnreachable     = "hub-unreachable"
+	problemNotJoined          = "managedcluster-missing"
+	problemNotAccepted        = "cluster-not-accepted"
+	problemPendingCSR         = "csr-pending"
+	problemTokenExpired       = "bootstrap-token-expired"
+	problemKlusterletMissing  = "klusterlet-missing"
+	problemKlusterletCrashing = "klusterlet-crashlooping"
+)
+
+// Fixes applied by the repair job
+const (
+	fixRejoin        = "Join the cluster to the hub again with a fresh token"
+	fixAccept        = "Accept the cluster and approve its CertificateSigningRequests on the hub"
+	fixRestartAgents = "Restart the crashlooping agent pods, then join the cluster again"
+)
+
+// statusRepairing marks a cluster while its repair job runs
+const statusRepairing = "Repairing"
+
+// clusterNameLabel carries the cluster name on the CertificateSigningRequests
+// of its agents
+const clusterNameLabel = "open-cluster-management.io/cluster-name"
+
+// repairableStatuses are the cluster states a repair may start from; the
+// others have a job in progress
+var repairableStatuses = map[string]bool{"Ready": true, "Failed": true, statusUnreachable: true}
+
+// bootstrapTokenPattern matches a Kubernetes bootstrap token, id.secret
+var bootstrapTokenPattern = regexp.MustCompile(`^([a-z0-9]{6})\.[a-z0-9]{16}$`)
+
+// RepairFinding is a problem the diagnosis found. Fix says what the repair
+// does about it and is empty when an operator has to step in.
+type RepairFinding struct {
+	Problem string `json:"problem"`
+	Detail  string `json:"detail"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+// RepairDiagnosis is the outcome of diagnosing a cluster. It is repairable
+// when every finding has a fix.
+type RepairDiagnosis struct {
+	ClusterName string          `json:"clusterName"`
+	Findings    []RepairFinding `json:"findings"`
+	Repairable  bool            `json:"repairable"`
+	DiagnosedAt string          `json:"diagnosedAt"`
+}
+
+func (d *RepairDiagnosis) add(problem, detail, fix string) {
+	d.Findings = append(d.Findings, RepairFinding{Problem: problem, Detail: detail, Fix: fix})
+	if fix == "" {
+		d.Repairable = false
+	}
+}
+
+// diagnoseCluster checks the hub and the spoke for the failure modes the
+// repair knows how to fix
+func diagnoseCluster(ctx context.Context, target readinessTarget, now time.Time) RepairDiagnosis {
+	diagnosis := RepairDiagnosis{ClusterName: target.clusterName, Findings: []RepairFinding{}, Repairable: true}
+
+	hubReachable := true
+	managedCluster, err := target.hubDynamic.Resource(managedClusterGVR).Get(ctx, target.clusterName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		diagnosis.add(problemNotJoined, "No ManagedCluster with this name exists on the hub", fixRejoin)
+	case err != nil:
+		hubReachable = false
+		diagnosis.add(problemHubUnreachable, fmt.Sprintf("Failed to get ManagedCluster: %v", err), "")
+	default:
+		if detail := clusterDetailFrom(managedCluster); !detail.HubAcceptsClient {
+			diagnosis.add(problemNotAccepted, "The hub has not accepted the cluster", fixAccept)
+		}
+	}
+	if hubReachable {
+		if pending, err := pendingCSRs(ctx, target.hub, target.clusterName); err != nil {
+			diagnosis.add(problemHubUnreachable, err.Error(), "")
+		} else if len(pending) > 0 {
+			diagnosis.add(problemPendingCSR, fmt.Sprintf("CertificateSigningRequests awaiting approval: %s", strings.Join(pending, ", ")), fixAccept)
+		}
+	}
+
+	if _, err := target.spoke.Discovery().ServerVersion(); err != nil {
+		diagnosis.add(problemSpokeUnreachable, fmt.Sprintf("Failed to reach the cluster API: %v", err), "")
+		diagnosis.DiagnosedAt = now.Format(time.RFC3339)
+		return diagnosis
+	}
+
+	deployments, err := target.spoke.AppsV1().Deployments(agentNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		diagnosis.add(problemSpokeUnreachable, fmt.Sprintf("Failed to list agents: %v", err), "")
+	} else if len(deployments.Items) == 0 {
+		diagnosis.add(problemKlusterletMissing, fmt.Sprintf("No klusterlet agents run in %s", agentNamespace), fixRejoin)
+	}
+
+	if crashing, err := crashLoopingAgents(ctx, target.spoke); err != nil {
+		diagnosis.add(problemSpokeUnreachable, err.Error(), "")
+	} else if len(crashing) > 0 {
+		var details []string
+		for _, pod := range crashing {
+			details = append(details, crashDetail(pod))
+		}
+		diagnosis.add(problemKlusterletCrashing, strings.Join(details, "; "), fixRestartAgents)
+	}
+
+	if hubReachable {
+		if expired, detail, err := bootstrapTokenExpired(ctx, target, now); err != nil {
+			logger().Debug("Failed to check bootstrap token", "cluster", target.clusterName, "error", err)
+		} else if expired {
+			diagnosis.add(problemTokenExpired, detail, fixRejoin)
+		}
+	}
+
+	diagnosis.DiagnosedAt = now.Format(time.RFC3339)
+	return diagnosis
+}
+
+// pendingCSRs lists the cluster's CertificateSigningRequests that are
+// neither approved nor denied
+func pendingCSRs(ctx context.Context, hub kubernetes.Interface, clusterName string) ([]string, error) {
+	csrs, err := hub.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", clusterNameLabel, clusterName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CertificateSigningRequests: %w", err)
+	}
+	var pending []string
+	for _, csr := range csrs.Items {
+		decided := false
+		for _, condition := range csr.Status.Conditions {
+			decided = decided || condition.Type == certificatesv1.CertificateApproved || condition.Type == certificatesv1.CertificateDenied
+		}
+		if !decided {
+			pending = append(pending, csr.Name)
+		}
+	}
+	return pending, nil
+}
+
+// crashLoopingAgents returns the agent pods with a container in CrashLoopBackOff
+func crashLoopingAgents(ctx context.Context, spoke kubernetes.Interface) ([]corev1.Pod, error) {
+	pods, err := spoke.CoreV1().Pods(agentNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agent pods: %w", err)
+	}
+	var crashing []corev1.Pod
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" {
+				crashing = append(crashing, pod)
+				break
+			}
+		}
+	}
+	return crashing, nil
+}
+
+// crashDetail describes why a pod's containers keep restarting
+func crashDetail(pod corev1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting == nil || status.State.Waiting.Reason != "CrashLoopBackOff" {
+			continue
+		}
+		detail := fmt.Sprintf("pod %s container %s restarted %d times", pod.Name, status.Name, status.RestartCount)
+		if terminated := status.LastTerminationState.Terminated; terminated != nil {
+			reason := strings.TrimSpace(terminated.Message)
+			if reason == "" {
+				reason = terminated.Reason
+			}
+			detail += ": " + reason
+		}
+		return detail
+	}
+	return "pod " + pod.Name
+}
+
+// bootstrapTokenExpired reads the token of the klusterlet's bootstrap
+// kubeconfig and reports whether it has expired: a service account token by
+// its exp claim, a bootstrap token by its Secret on the hub
+func bootstrapTokenExpired(ctx context.Context, target readinessTarget, now time.Time) (bool, string, error) {
+	secret, err := target.spoke.CoreV1().Secrets(agentNamespace).Get(ctx, "bootstrap-hub-kubeconfig", metav1.GetOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	config, err := clientcmd.Load(secret.Data["kubeconfig"])
+	if err != nil {
+		return false, "", fmt.Errorf("invalid bootstrap kubeconfig: %w", err)
+	}
+	var token string
+	if kubeContext, ok := config.Contexts[config.CurrentContext]; ok {
+		if auth, ok := config.AuthInfos[kubeContext.AuthInfo]; ok {
+			token = auth.Token
+		}
+	}
+
+	expiry, err := joinTokenExpiry(ctx, target.hub, token)
+	if errors.Is(err, errBootstrapTokenGone) {
+		return true, fmt.Sprintf("Bootstrap token %s no longer exists on the hub", tokenID(token)), nil
+	}
+	if err != nil || expiry.IsZero() || now.Before(expiry) {
+		return false, "", err
+	}
+	if bootstrapTokenPattern.MatchString(token) {
+		return true, fmt.Sprintf("Bootstrap token %s expired at %s", tokenID(token), expiry.Format(time.RFC3339)), nil
+	}
+	return true, fmt.Sprintf("Bootstrap token expired at %s", expiry.Format(time.RFC3339)), nil
+}
+
+// restartCrashLoopingAgents deletes the crashlooping agent pods so their
+// Deployments start them afresh, without the restart backoff
+func restartCrashLoopingAgents(ctx context.Context, spoke kubernetes.Interface) error {
+	crashing, err := crashLoopingAgents(ctx, spoke)
+	if err != nil {
+		return err
+	}
+	for _, pod := range crashing {
+		if err := spoke.CoreV1().Pods(agentNamespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to restart pod %s: %w", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// repairJob returns the job body that applies the fixes of the diagnosis and
+// joins the cluster again through the onboarding pipeline, which also accepts
+// the cluster and approves its CertificateSigningRequests. values are those
+// the cluster was onboarded with.
+func (cp *ClusterPlugin) repairJob(jobID, clusterName string, kubeconfigData []byte, values *ManifestValues, diagnosis RepairDiagnosis, spoke kubernetes.Interface) func(ctx context.Context) error {
+	join := cp.onboardingJob(jobID, clusterName, kubeconfigData, values)
+	return func(ctx context.Context) error {
+		for _, finding := range diagnosis.Findings {
+			if finding.Problem != problemKlusterletCrashing {
+				continue
+			}
+			if err := cp.advance(ctx, clusterName, statusRepairing, "Restarting crashlooping agent pods"); err != nil {
+				return err
+			}
+			// The join replaces what the agents crash on, so carry on regardless
+			if err := restartCrashLoopingAgents(ctx, spoke); err != nil {
+				logger().Warn("Failed to restart agent pods", "cluster", clusterName, "error", err)
+				cp.logs.Append(clusterName, "warn", fmt.Sprintf("Failed to restart agent pods: %v", err))
+			}
+		}
+		return join(ctx)
+	}
+}
+
+// RepairClusterHandler diagnoses a failed, unreachable or misbehaving
+// cluster and, when every problem found has a fix, starts a job applying the
+// fixes and joining the cluster again. Otherwise it answers 422 with the
+// diagnosis; dryRun=true only diagnoses.
+func (cp *ClusterPlugin) RepairClusterHandler(c *gin.Context) {
+	clusterName := c.Param("name")
+
+	cp.mutex.RLock()
+	record, exists, err := cp.store.Get(clusterName)
+	cp.mutex.RUnlock()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if !exists {
+		respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin", clusterName))
+		return
+	}
+	if !repairableStatuses[record.Status] {
+		respondProblem(c, http.StatusConflict, CodeClusterBusy, fmt.Sprintf("Cluster '%s' is %s; wait for its job to finish", clusterName, record.Status))
+		return
+	}
+	kubeconfigData := cp.savedKubeconfig(clusterName)
+	if len(kubeconfigData) == 0 {
+		respondProblem(c, http.StatusConflict, CodeConflict, fmt.Sprintf("No saved kubeconfig to repair cluster '%s' with", clusterName))
+		return
+	}
+	target, err := cp.readinessTarget(clusterName, kubeconfigData)
+	if err != nil {
+		respondProblem(c, http.StatusBadGateway, CodeHubUnreachable, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	diagnosis := diagnoseCluster(ctx, target, time.Now())
+	if cp.abortOnContext(c) {
+		return
+	}
+	requestLogger(c).Info("Diagnosed cluster", "cluster", clusterName, "findings", len(diagnosis.Findings), "repairable", diagnosis.Repairable)
+
+	switch {
+	case !diagnosis.Repairable:
+		respondProblem(c, http.StatusUnprocessableEntity, CodeNotRepairable, fmt.Sprintf("Cluster '%s' can't be repaired automatically", clusterName),
+			"diagnosis", diagnosis)
+		return
+	case c.Query("dryRun") == "true" || (len(diagnosis.Findings) == 0 && record.Status == "Ready"):
+		code := MsgRepairDiagnosisOnly
+		if len(diagnosis.Findings) == 0 && record.Status == "Ready" {
+			code = MsgRepairNothingFound
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":     message(c, code),
+			"messageCode": code,
+			"diagnosis":   diagnosis,
+			"plugin":      "kubestellar-cluster-plugin",
+			"timestamp":   time.Now().Format(time.RFC3339),
+		})
+		return
+	}
+
+	// The cluster may have moved on while it was being diagnosed
+	cp.mutex.Lock()
+	current, exists, err := cp.store.Get(clusterName)
+	if err != nil || !exists || current.JobID != record.JobID || !repairableStatuses[current.Status] {
+		cp.mutex.Unlock()
+		respondProblem(c, http.StatusConflict, CodeConflict, fmt.Sprintf("Cluster '%s' changed while it was being diagnosed", clusterName))
+		return
+	}
+	if err := cp.jobs.Admit(); err != nil {
+		cp.mutex.Unlock()
+		cp.respondQueueFull(c)
+		return
+	}
+	jobID := cp.jobs.Create(c.Request.Context(), "repair", clusterName).ID
+	current.JobID = jobID
+	current.Status = statusRepairing
+	current.Message = fmt.Sprintf("Repairing %d problems found by the diagnosis", len(diagnosis.Findings))
+	current.LastUpdated = time.Now().Format(time.RFC3339)
+	cp.putStatus(current)
+	cp.mutex.Unlock()
+
+	requestLogger(c).Info("Repairing cluster", "cluster", clusterName, "job", jobID)
+	cp.jobs.Run(jobID, cp.repairJob(jobID, clusterName, kubeconfigData, current.ManifestValues, diagnosis, target.spoke))
+
+	c.JSON(http.StatusAccepted, RepairResponse{
+		Message:       message(c, MsgRepairStarted, "cluster", clusterName),
+		MessageCode:   MsgRepairStarted,
+		Status:        statusRepairing,
+		ClusterName:   clusterName,
+		JobID:         jobID,
+		QueuePosition: cp.queuePosition(jobID),
+		Diagnosis:     diagnosis,
+		Plugin:        "kubestellar-cluster-plugin",
+		Timestamp:     time.Now().Format(time.RFC3339),
+	})
+}
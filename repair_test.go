@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// bootstrapKubeconfigSecret is the klusterlet's bootstrap kubeconfig authenticating with token
+func bootstrapKubeconfigSecret(token string) *corev1.Secret {
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: hub
+  cluster:
+    server: https://hub.example.com:6443
+contexts:
+- name: bootstrap
+  context:
+    cluster: hub
+    user: bootstrap
+current-context: bootstrap
+users:
+- name: bootstrap
+  user:
+    token: %s
+`, token)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-hub-kubeconfig", Namespace: agentNamespace},
+		Data:       map[string][]byte{"kubeconfig": []byte(kubeconfig)},
+	}
+}
+
+// testJWT is an unsigned token expiring at exp
+func testJWT(exp time.Time) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix()))) + ".sig"
+}
+
+func TestDiagnoseCluster(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	accepted := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.open-cluster-management.io/v1",
+		"kind":       "ManagedCluster",
+		"metadata":   map[string]interface{}{"name": "edge-1"},
+		"spec":       map[string]interface{}{"hubAcceptsClient": true},
+	}}
+	notAccepted := accepted.DeepCopy()
+	notAccepted.Object["spec"] = map[string]interface{}{"hubAcceptsClient": false}
+	agent := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "klusterlet-agent", Namespace: agentNamespace}}
+	crashing := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "klusterlet-agent-7c9", Namespace: agentNamespace},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:                 "agent",
+			RestartCount:         12,
+			State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+			LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: "x509: certificate has expired"}},
+		}}},
+	}
+	pendingCSR := &certificatesv1.CertificateSigningRequest{ObjectMeta: metav1.ObjectMeta{
+		Name: "edge-1-csr-abc", Labels: map[string]string{clusterNameLabel: "edge-1"},
+	}}
+	approvedCSR := &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge-1-csr-old", Labels: map[string]string{clusterNameLabel: "edge-1"}},
+		Status: certificatesv1.CertificateSigningRequestStatus{Conditions: []certificatesv1.CertificateSigningRequestCondition{
+			{Type: certificatesv1.CertificateApproved, Status: corev1.ConditionTrue},
+		}},
+	}
+	tokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-token-abcdef", Namespace: "kube-system"},
+		Data:       map[string][]byte{"expiration": []byte(now.Add(-time.Hour).Format(time.RFC3339))},
+	}
+
+	tests := []struct {
+		name           string
+		managedCluster *unstructured.Unstructured
+		hub            []runtime.Object
+		spoke          []runtime.Object
+		wantProblems   []string
+		wantRepairable bool
+	}{
+		{
+			name:           "healthy",
+			managedCluster: accepted,
+			hub:            []runtime.Object{approvedCSR},
+			spoke:          []runtime.Object{agent, bootstrapKubeconfigSecret(testJWT(now.Add(time.Hour)))},
+			wantProblems:   []string{},
+			wantRepairable: true,
+		},
+		{
+			name:           "never joined",
+			spoke:          []runtime.Object{},
+			wantProblems:   []string{problemNotJoined, problemKlusterletMissing},
+			wantRepairable: true,
+		},
+		{
+			name:           "awaiting acceptance",
+			managedCluster: notAccepted,
+			hub:            []runtime.Object{pendingCSR},
+			spoke:          []runtime.Object{agent},
+			wantProblems:   []string{problemNotAccepted, problemPendingCSR},
+			wantRepairable: true,
+		},
+		{
+			name:           "crashlooping with an expired service account token",
+			managedCluster: accepted,
+			spoke:          []runtime.Object{agent, crashing, bootstrapKubeconfigSecret(testJWT(now.Add(-time.Minute)))},
+			wantProblems:   []string{problemKlusterletCrashing, problemTokenExpired},
+			wantRepairable: true,
+		},
+		{
+			name:           "expired bootstrap token",
+			managedCluster: accepted,
+			hub:            []runtime.Object{tokenSecret},
+			spoke:          []runtime.Object{agent, bootstrapKubeconfigSecret("abcdef.0123456789abcdef")},
+			wantProblems:   []string{problemTokenExpired},
+			wantRepairable: true,
+		},
+		{
+			name:           "deleted bootstrap token",
+			managedCluster: accepted,
+			spoke:          []runtime.Object{agent, bootstrapKubeconfigSecret("abcdef.0123456789abcdef")},
+			wantProblems:   []string{problemTokenExpired},
+			wantRepairable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			if tt.managedCluster != nil {
+				objects = append(objects, tt.managedCluster)
+			}
+			target := readinessTarget{
+				clusterName: "edge-1",
+				hub:         fake.NewSimpleClientset(tt.hub...),
+				hubDynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+					managedClusterGVR: "ManagedClusterList",
+				}, objects...),
+				spoke: fakeServerVersion(fake.NewSimpleClientset(tt.spoke...), "v1.29.2"),
+			}
+			diagnosis := diagnoseCluster(context.Background(), target, now)
+			problems := make([]string, 0, len(diagnosis.Findings))
+			for _, finding := range diagnosis.Findings {
+				problems = append(problems, finding.Problem)
+			}
+			if !reflect.DeepEqual(problems, tt.wantProblems) || diagnosis.Repairable != tt.wantRepairable {
+				t.Errorf("diagnoseCluster() = %+v, want problems %v repairable %v", diagnosis, tt.wantProblems, tt.wantRepairable)
+			}
+		})
+	}
+}
+
+func TestRestartCrashLoopingAgents(t *testing.T) {
+	crashing := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "klusterlet-agent-7c9", Namespace: agentNamespace},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		}}},
+	}
+	running := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "klusterlet-work-5d2", Namespace: agentNamespace}}
+	spoke := fake.NewSimpleClientset(crashing, running)
+
+	if err := restartCrashLoopingAgents(context.Background(), spoke); err != nil {
+		t.Fatalf("restartCrashLoopingAgents() error = %v", err)
+	}
+	pods, _ := spoke.CoreV1().Pods(agentNamespace).List(context.Background(), metav1.ListOptions{})
+	if len(pods.Items) != 1 || pods.Items[0].Name != running.Name {
+		t.Errorf("pods left = %+v, want only %s", pods.Items, running.Name)
+	}
+}
+
+func TestRepairClusterHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Joining"})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-2", Status: "Failed"})
+	router := gin.New()
+	router.POST("/clusters/:name/repair", plugin.RepairClusterHandler)
+
+	tests := []struct {
+		cluster string
+		want    int
+	}{
+		{cluster: "edge-9", want: http.StatusNotFound},
+		// A job is still working on the cluster
+		{cluster: "edge-1", want: http.StatusConflict},
+		// No kubeconfig was saved to reach the cluster with
+		{cluster: "edge-2", want: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/clusters/"+tt.cluster+"/repair", nil))
+		if recorder.Code != tt.want {
+			t.Errorf("POST /clusters/%s/repair status = %d, want %d, body %s", tt.cluster, recorder.Code, tt.want, recorder.Body)
+		}
+	}
+}
+
+func TestRetriesReuseManifestValues(t *testing.T) {
+	plugin := newTestPlugin(t)
+	plugin.secrets = &fileSecretStore{dir: t.TempDir(), box: plugin.box}
+	values := &ManifestValues{Registry: "mirror.example.com/ocm", ImageTag: "v0.13.0"}
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Failed", ManifestValues: values})
+	// Later status updates don't carry the values, so they must be kept
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Failed", Message: "Onboarding failed"})
+	if err := plugin.saveKubeconfig("edge-1", []byte(testHubKubeconfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := plugin.beginRetry(context.Background(), "edge-1", nil)
+	if err != nil {
+		t.Fatalf("beginRetry() error = %v", err)
+	}
+	if !reflect.DeepEqual(task.values, values) {
+		t.Errorf("retried with values %+v, want %+v", task.values, values)
+	}
+	plugin.jobs.Execute(task.jobID, func(context.Context) error { return nil })
+
+	jobID := plugin.jobs.Create(context.Background(), "repair", "edge-1").ID
+	plugin.repairJob(jobID, "edge-1", []byte(testHubKubeconfig), values, RepairDiagnosis{}, nil)
+	if got := plugin.resumable[jobID].ManifestValues; !reflect.DeepEqual(got, values) {
+		t.Errorf("repaired with values %+v, want %+v", got, values)
+	}
+	plugin.jobs.Execute(jobID, func(context.Context) error { return nil })
+}
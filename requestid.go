@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDHeader carries the correlation ID shared by the host and the plugin
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key holding the request ID
+const requestIDKey = "requestID"
+
+// requestIDContextKey stores the request ID on the request context so that
+// work started by a handler, such as jobs, can pick it up
+type requestIDContextKey struct{}
+
+// validRequestID limits incoming IDs to something safe to log and echo back
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// withRequestID wraps a handler so that every request carries an ID: the one
+// sent by the host when valid, or a new one. The ID is echoed in the response
+// header and stored on both the gin and the request context.
+func (cp *ClusterPlugin) withRequestID(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newJobID("req")
+		}
+		c.Set(requestIDKey, id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDContextKey{}, id))
+		c.Header(requestIDHeader, id)
+		handler(c)
+	}
+}
+
+// requestID returns the ID of the request being handled
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// requestIDFromContext returns the request ID stored on a request context
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// requestLogger returns the plugin logger annotated with the request ID
+func requestLogger(c *gin.Context) *slog.Logger {
+	if id := requestID(c); id != "" {
+		return logger().With("requestId", id)
+	}
+	return logger()
+}
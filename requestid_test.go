@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lockedBuffer collects the log records written by handlers and job workers
+type lockedBuffer struct {
+	mutex sync.Mutex
+	bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Buffer.String()
+}
+
+func (b *lockedBuffer) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Buffer.Reset()
+}
+
+func TestWithRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	var logs lockedBuffer
+	previous := logger()
+	pluginLogger.Store(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { pluginLogger.Store(previous) })
+
+	var jobID string
+	router := gin.New()
+	router.POST("/onboard", plugin.withRequestID(func(c *gin.Context) {
+		requestLogger(c).Info("Onboarding requested")
+		job := plugin.jobs.Create(c.Request.Context(), "onboard", "edge-1")
+		jobID = job.ID
+		plugin.jobs.Run(job.ID, func(context.Context) error { return nil })
+		c.JSON(http.StatusAccepted, gin.H{"requestId": requestID(c)})
+	}))
+	onboard := func(header string) (*httptest.ResponseRecorder, Job) {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/onboard", nil)
+		if header != "" {
+			request.Header.Set(requestIDHeader, header)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder, waitForJob(t, plugin.jobs, jobID, func(job Job) bool { return job.Finished() })
+	}
+	// logged returns the requestId attribute of each log record with msg,
+	// waiting for job workers to log it
+	logged := func(msg string) []string {
+		var ids []string
+		for deadline := time.Now().Add(5 * time.Second); len(ids) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			ids = loggedNow(&logs, msg)
+		}
+		return ids
+	}
+
+	recorder, job := onboard("host-req-42")
+	if got := recorder.Header().Get(requestIDHeader); got != "host-req-42" {
+		t.Errorf("echoed %s = %q, want the incoming ID", requestIDHeader, got)
+	}
+	if !strings.Contains(recorder.Body.String(), `"requestId":"host-req-42"`) {
+		t.Errorf("handler saw %s", recorder.Body.String())
+	}
+	if job.RequestID != "host-req-42" {
+		t.Errorf("job request ID = %q", job.RequestID)
+	}
+
+	for _, header := range []string{"", "not a valid id\n"} {
+		logs.Reset()
+		recorder, job = onboard(header)
+		id := recorder.Header().Get(requestIDHeader)
+		if !strings.HasPrefix(id, "req-") || !validRequestID.MatchString(id) {
+			t.Errorf("generated ID for %q = %q", header, id)
+		}
+		if job.RequestID != id {
+			t.Errorf("job request ID = %q, want the generated %q", job.RequestID, id)
+		}
+		requested, finished := logged("Onboarding requested"), logged("Job finished")
+		if len(requested) != 1 || requested[0] != id || len(finished) != 1 || finished[0] != id {
+			t.Errorf("logged request IDs %v and %v, want %q", requested, finished, id)
+		}
+	}
+}
+
+// loggedNow returns the requestId attribute of each JSON log record with msg
+func loggedNow(logs *lockedBuffer, msg string) []string {
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]interface{}
+		if json.Unmarshal([]byte(line), &record) == nil && record["msg"] == msg {
+			id, _ := record["requestId"].(string)
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
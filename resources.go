@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// managedClusterInfoGVR is the per-cluster node inventory the OCM
+// multicluster engine keeps in the cluster namespace of the hub
+var managedClusterInfoGVR = schema.GroupVersionResource{
+	Group:    "internal.open-cluster-management.io",
+	Version:  "v1beta1",
+	Resource: "managedclusterinfos",
+}
+
+// Sources of collected resources
+const (
+	resourcesFromHub   = "ManagedClusterInfo"
+	resourcesFromSpoke = "spoke"
+)
+
+// ClusterResources is the node count and allocatable CPU and memory of a
+// cluster, as last collected
+type ClusterResources struct {
+	Nodes      int `json:"nodes"`
+	ReadyNodes int `json:"readyNodes"`
+	// AllocatableCPU and AllocatableMemory are Kubernetes quantities summed
+	// over the nodes
+	AllocatableCPU    string `json:"allocatableCpu,omitempty"`
+	AllocatableMemory string `json:"allocatableMemory,omitempty"`
+	// Source is ManagedClusterInfo when read from the hub, or spoke when the
+	// nodes were listed with the kubeconfig saved at onboarding
+	Source      string `json:"source"`
+	CollectedAt string `json:"collectedAt"`
+}
+
+// ResourceTotals sums the resources of the clusters that reported them
+type ResourceTotals struct {
+	Clusters          int    `json:"clusters"`
+	Nodes             int    `json:"nodes"`
+	ReadyNodes        int    `json:"readyNodes"`
+	AllocatableCPU    string `json:"allocatableCpu"`
+	AllocatableMemory string `json:"allocatableMemory"`
+
+	cpu, memory resource.Quantity
+}
+
+func (t *ResourceTotals) add(r *ClusterResources) {
+	t.Clusters++
+	t.Nodes += r.Nodes
+	t.ReadyNodes += r.ReadyNodes
+	if q, err := resource.ParseQuantity(r.AllocatableCPU); err == nil {
+		t.cpu.Add(q)
+	}
+	if q, err := resource.ParseQuantity(r.AllocatableMemory); err == nil {
+		t.memory.Add(q)
+	}
+	t.AllocatableCPU, t.AllocatableMemory = t.cpu.String(), t.memory.String()
+}
+
+// FleetResources rolls the collected resources up for capacity planning
+type FleetResources struct {
+	Total    ResourceTotals            `json:"total"`
+	ByHub    map[string]ResourceTotals `json:"byHub"`
+	ByRegion map[string]ResourceTotals `json:"byRegion"`
+}
+
+func fleetResources(clusters []ClusterStatus) FleetResources {
+	fleet := FleetResources{ByHub: map[string]ResourceTotals{}, ByRegion: map[string]ResourceTotals{}}
+	for _, cluster := range clusters {
+		if cluster.Resources == nil {
+			continue
+		}
+		fleet.Total.add(cluster.Resources)
+		hub := fleet.ByHub[hubName(cluster)]
+		hub.add(cluster.Resources)
+		fleet.ByHub[hubName(cluster)] = hub
+		region := firstLabel(clusterLabelSet(cluster), regionLabels)
+		totals := fleet.ByRegion[region]
+		totals.add(cluster.Resources)
+		fleet.ByRegion[region] = totals
+	}
+	return fleet
+}
+
+// resourcesFromManagedClusterInfo counts the nodes listed by the
+// ManagedClusterInfo of a cluster and takes the allocatable CPU and memory
+// the registration agent reports on its ManagedCluster
+func resourcesFromManagedClusterInfo(ctx context.Context, hub dynamic.Interface, clusterName string) (ClusterResources, error) {
+	info, err := hub.Resource(managedClusterInfoGVR).Namespace(clusterName).Get(ctx, clusterName, metav1.GetOptions{})
+	if err != nil {
+		return ClusterResources{}, err
+	}
+	resources := ClusterResources{Source: resourcesFromHub}
+	nodes, _, _ := unstructured.NestedSlice(info.Object, "status", "nodeList")
+	for _, node := range nodes {
+		node, ok := node.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		resources.Nodes++
+		conditions, _, _ := unstructured.NestedSlice(node, "conditions")
+		for _, condition := range conditions {
+			condition, ok := condition.(map[string]interface{})
+			if ok && condition["type"] == string(corev1.NodeReady) && condition["status"] == string(corev1.ConditionTrue) {
+				resources.ReadyNodes++
+			}
+		}
+	}
+
+	managedCluster, err := hub.Resource(managedClusterGVR).Get(ctx, clusterName, metav1.GetOptions{})
+	if err != nil {
+		return ClusterResources{}, fmt.Errorf("failed to get ManagedCluster: %w", err)
+	}
+	allocatable, _, _ := unstructured.NestedStringMap(managedCluster.Object, "status", "allocatable")
+	resources.AllocatableCPU = allocatable[string(corev1.ResourceCPU)]
+	resources.AllocatableMemory = allocatable[string(corev1.ResourceMemory)]
+	return resources, nil
+}
+
+// resourcesFromNodes lists the nodes of a spoke directly
+func resourcesFromNodes(ctx context.Context, spoke kubernetes.Interface) (ClusterResources, error) {
+	nodes, err := spoke.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return ClusterResources{}, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	resources := ClusterResources{Source: resourcesFromSpoke, Nodes: len(nodes.Items)}
+	var cpu, memory resource.Quantity
+	for _, node := range nodes.Items {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				resources.ReadyNodes++
+			}
+		}
+		cpu.Add(node.Status.Allocatable[corev1.ResourceCPU])
+		memory.Add(node.Status.Allocatable[corev1.ResourceMemory])
+	}
+	resources.AllocatableCPU, resources.AllocatableMemory = cpu.String(), memory.String()
+	return resources, nil
+}
+
+// collectClusterResources reads the resources of a cluster from the hub,
+// falling back to the spoke when the hub has no ManagedClusterInfo for it or
+// can't be reached
+func collectClusterResources(ctx context.Context, target readinessTarget) (ClusterResources, error) {
+	var resources ClusterResources
+	err := fmt.Errorf("hub unreachable")
+	if target.hubDynamic != nil {
+		resources, err = resourcesFromManagedClusterInfo(ctx, target.hubDynamic, target.clusterName)
+	}
+	if err != nil && target.spoke != nil {
+		resources, err = resourcesFromNodes(ctx, target.spoke)
+	}
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return resources, fmt.Errorf("the hub has no ManagedClusterInfo for the cluster and no kubeconfig was saved at onboarding")
+		}
+		return resources, err
+	}
+	resources.CollectedAt = time.Now().UTC().Format(time.RFC3339)
+	return resources, nil
+}
+
+// resourceTarget connects to the hub of a cluster and, with the kubeconfig
+// saved at onboarding, to the spoke. Either may be missing.
+func (cp *ClusterPlugin) resourceTarget(record ClusterStatus) readinessTarget {
+	target := readinessTarget{clusterName: record.ClusterName}
+	if hub, err := cp.hubs.Get(hubName(record)); err == nil {
+		if _, hubConfig, err := hub.clientset(); err == nil {
+			if client, err := dynamic.NewForConfig(hubConfig); err == nil {
+				target.hubDynamic = client
+			}
+		}
+	}
+	if kubeconfigData := cp.savedKubeconfig(record.ClusterName); len(kubeconfigData) > 0 {
+		if spokeConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigData); err == nil {
+			if client, err := kubernetes.NewForConfig(spokeConfig); err == nil {
+				target.spoke = client
+			}
+		}
+	}
+	return target
+}
+
+// collectResources refreshes the resources of every Ready cluster
+func (cp *ClusterPlugin) collectResources(ctx context.Context) {
+	cp.mutex.RLock()
+	records, err := cp.store.List()
+	cp.mutex.RUnlock()
+	if err != nil {
+		logger().Warn("Failed to list clusters for resource collection", "error", err)
+		return
+	}
+
+	for _, record := range records {
+		if record.Status != "Ready" {
+			continue
+		}
+		resources, err := collectClusterResources(ctx, cp.resourceTarget(record))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger().Warn("Failed to collect cluster resources", "cluster", record.ClusterName, "error", err)
+			continue
+		}
+		cp.recordResources(record.ClusterName, resources)
+	}
+}
+
+// recordResources stores the collected resources of a cluster
+func (cp *ClusterPlugin) recordResources(clusterName string, resources ClusterResources) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if cp.closed {
+		return
+	}
+	record, exists, err := cp.store.Get(clusterName)
+	if err != nil || !exists {
+		return
+	}
+	record.Resources = &resources
+	if err := cp.store.Put(record); err != nil {
+		logger().Error("Failed to persist cluster resources", "cluster", clusterName, "error", err)
+		return
+	}
+	cp.statusCache.invalidate()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCollectClusterResources(t *testing.T) {
+	managedCluster := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.open-cluster-management.io/v1",
+		"kind":       "ManagedCluster",
+		"metadata":   map[string]interface{}{"name": "edge-1"},
+		"status":     map[string]interface{}{"allocatable": map[string]interface{}{"cpu": "7600m", "memory": "14Gi"}},
+	}}
+	info := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "internal.open-cluster-management.io/v1beta1",
+		"kind":       "ManagedClusterInfo",
+		"metadata":   map[string]interface{}{"name": "edge-1", "namespace": "edge-1"},
+		"status": map[string]interface{}{"nodeList": []interface{}{
+			map[string]interface{}{"name": "node-a", "conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}}},
+			map[string]interface{}{"name": "node-b", "conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "False"}}},
+		}},
+	}}
+	listKinds := map[schema.GroupVersionResource]string{
+		managedClusterGVR:     "ManagedClusterList",
+		managedClusterInfoGVR: "ManagedClusterInfoList",
+	}
+	node := func(name, cpu, memory string, ready corev1.ConditionStatus) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory)},
+				Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			},
+		}
+	}
+	spoke := fake.NewSimpleClientset(node("node-a", "3800m", "7Gi", corev1.ConditionTrue), node("node-b", "2", "8Gi", corev1.ConditionTrue))
+
+	tests := []struct {
+		name    string
+		target  readinessTarget
+		want    ClusterResources
+		wantErr bool
+	}{
+		{
+			name:   "from ManagedClusterInfo",
+			target: readinessTarget{clusterName: "edge-1", hubDynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, managedCluster, info), spoke: spoke},
+			want:   ClusterResources{Nodes: 2, ReadyNodes: 1, AllocatableCPU: "7600m", AllocatableMemory: "14Gi", Source: resourcesFromHub},
+		},
+		{
+			name:   "spoke without ManagedClusterInfo",
+			target: readinessTarget{clusterName: "edge-1", hubDynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, managedCluster), spoke: spoke},
+			want:   ClusterResources{Nodes: 2, ReadyNodes: 2, AllocatableCPU: "5800m", AllocatableMemory: "15Gi", Source: resourcesFromSpoke},
+		},
+		{
+			name:    "neither",
+			target:  readinessTarget{clusterName: "edge-1", hubDynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := collectClusterResources(context.Background(), tt.target)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("collectClusterResources() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil || got.CollectedAt == "" {
+				t.Fatalf("collectClusterResources() = %+v, %v", got, err)
+			}
+			got.CollectedAt = ""
+			if got != tt.want {
+				t.Errorf("collectClusterResources() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFleetResources(t *testing.T) {
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready", Labels: map[string]string{"region": "eu-west-1"}})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-2", Status: "Ready", Labels: map[string]string{"region": "eu-west-1"}})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-3", Status: "Pending"})
+	plugin.recordResources("edge-1", ClusterResources{Nodes: 3, ReadyNodes: 3, AllocatableCPU: "11400m", AllocatableMemory: "21Gi"})
+	plugin.recordResources("edge-2", ClusterResources{Nodes: 1, ReadyNodes: 0, AllocatableCPU: "600m", AllocatableMemory: "3Gi"})
+
+	// Status updates keep the resources
+	plugin.updateStatus("edge-2", "Unreachable", "lease expired")
+	clusters, _ := plugin.store.List()
+	fleet := fleetBreakdown(clusters).Resources
+	want := ResourceTotals{Clusters: 2, Nodes: 4, ReadyNodes: 3, AllocatableCPU: "12", AllocatableMemory: "24Gi"}
+	for name, got := range map[string]ResourceTotals{"total": fleet.Total, "eu-west-1": fleet.ByRegion["eu-west-1"]} {
+		got.cpu, got.memory = resource.Quantity{}, resource.Quantity{}
+		if got != want {
+			t.Errorf("%s = %+v, want %+v", name, got, want)
+		}
+	}
+	if _, counted := fleet.ByRegion["unknown"]; counted {
+		t.Errorf("clusters without resources counted: %+v", fleet.ByRegion)
+	}
+}
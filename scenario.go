@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/yaml"
+)
+
+// Scenario scripts the fleet of mock mode: the clusters it starts with, the
+// status changes they go through over time and the operations that fail, so
+// a UI can replay a situation without any infrastructure
+type Scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// StepDelayMillis overrides mockStepDelayMillis while the scenario runs
+	StepDelayMillis *int              `json:"stepDelayMillis,omitempty"`
+	Clusters        []ScenarioCluster `json:"clusters"`
+	Faults          []ScenarioFault   `json:"faults,omitempty"`
+}
+
+// ScenarioCluster is a cluster present when the scenario starts
+type ScenarioCluster struct {
+	Name   string            `json:"name"`
+	Hub    string            `json:"hub,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Status is the status the cluster starts in, Ready by default
+	Status      string               `json:"status,omitempty"`
+	Message     string               `json:"message,omitempty"`
+	Transitions []ScenarioTransition `json:"transitions,omitempty"`
+}
+
+// ScenarioTransition changes the status of a cluster AfterSeconds into the
+// scenario. Detached removes the cluster.
+type ScenarioTransition struct {
+	AfterSeconds int    `json:"afterSeconds"`
+	Status       string `json:"status"`
+	Message      string `json:"message,omitempty"`
+}
+
+// ScenarioFault fails the onboardings or detachments of the clusters it
+// matches
+type ScenarioFault struct {
+	// Cluster is a cluster name or a glob pattern such as edge-*
+	Cluster string `json:"cluster"`
+	// Operation is onboard, the default, or detach
+	Operation string `json:"operation,omitempty"`
+	// Step is the status the operation fails in, its last one by default
+	Step string `json:"step,omitempty"`
+	// AfterSeconds delays the failure until that long after the operation
+	// started
+	AfterSeconds int    `json:"afterSeconds,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// scenarioRun is the scenario being played
+type scenarioRun struct {
+	scenario  Scenario
+	startedAt time.Time
+	timers    []*time.Timer
+	// applied counts the transitions played so far
+	applied int
+}
+
+// PendingTransition is a transition of the running scenario still to come
+type PendingTransition struct {
+	ClusterName string `json:"clusterName"`
+	At          string `json:"at"`
+	Status      string `json:"status"`
+}
+
+// validateScenario checks the scenario against the onboarding pipeline and the
+// registered hubs, filling in the defaults
+func (cp *ClusterPlugin) validateScenario(scenario *Scenario) error {
+	if scenario.StepDelayMillis != nil && *scenario.StepDelayMillis < 0 {
+		return fmt.Errorf("stepDelayMillis must not be negative")
+	}
+	seen := make(map[string]bool, len(scenario.Clusters))
+	for i := range scenario.Clusters {
+		cluster := &scenario.Clusters[i]
+		if err := validateClusterName(cluster.Name); err != nil {
+			return fmt.Errorf("clusters[%d]: %w", i, err)
+		}
+		if seen[cluster.Name] {
+			return fmt.Errorf("clusters[%d]: duplicate cluster %s", i, cluster.Name)
+		}
+		seen[cluster.Name] = true
+		if _, err := cp.hubs.Get(cluster.Hub); err != nil {
+			return fmt.Errorf("clusters[%d]: %w", i, err)
+		}
+		if cluster.Status == "" {
+			cluster.Status = "Ready"
+		}
+		after := 0
+		for j, transition := range cluster.Transitions {
+			if transition.Status == "" {
+				return fmt.Errorf("clusters[%d].transitions[%d]: status is required", i, j)
+			}
+			if transition.AfterSeconds < after {
+				return fmt.Errorf("clusters[%d].transitions[%d]: afterSeconds must not go back in time", i, j)
+			}
+			after = transition.AfterSeconds
+		}
+	}
+
+	for i := range scenario.Faults {
+		fault := &scenario.Faults[i]
+		if _, err := path.Match(fault.Cluster, ""); fault.Cluster == "" || err != nil {
+			return fmt.Errorf("faults[%d]: invalid cluster pattern %q", i, fault.Cluster)
+		}
+		if fault.Operation == "" {
+			fault.Operation = "onboard"
+		}
+		if fault.Operation != "onboard" && fault.Operation != "detach" {
+			return fmt.Errorf("faults[%d]: invalid operation %q, must be onboard or detach", i, fault.Operation)
+		}
+		if fault.AfterSeconds < 0 {
+			return fmt.Errorf("faults[%d]: afterSeconds must not be negative", i)
+		}
+		if fault.Step == "" {
+			continue
+		}
+		known := false
+		for _, step := range cp.mockSteps(fault.Operation) {
+			known = known || step.status == fault.Step
+		}
+		if !known {
+			return fmt.Errorf("faults[%d]: %s has no step %q", i, fault.Operation, fault.Step)
+		}
+	}
+	return nil
+}
+
+// parseScenario reads a YAML or JSON scenario
+func (cp *ClusterPlugin) parseScenario(data []byte) (Scenario, error) {
+	var scenario Scenario
+	if err := yaml.UnmarshalStrict(data, &scenario); err != nil {
+		return Scenario{}, fmt.Errorf("invalid scenario: %w", err)
+	}
+	if err := cp.validateScenario(&scenario); err != nil {
+		return Scenario{}, fmt.Errorf("invalid scenario: %w", err)
+	}
+	return scenario, nil
+}
+
+// loadScenarioFile reads the scenario named by mockScenario
+func (cp *ClusterPlugin) loadScenarioFile(file string) (Scenario, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Scenario{}, fmt.Errorf("failed to read mock scenario: %w", err)
+	}
+	return cp.parseScenario(data)
+}
+
+// playScenario replaces the fleet with the clusters of the scenario and
+// schedules their transitions. The caller holds cp.mutex.
+func (cp *ClusterPlugin) playScenario(scenario Scenario) error {
+	cp.stopScenario()
+	clusters, err := cp.store.List()
+	if err != nil {
+		return err
+	}
+	for _, cluster := range clusters {
+		if err := cp.store.Delete(cluster.ClusterName); err != nil {
+			return err
+		}
+	}
+	// Replaying starts the timelines over
+	if _, err := cp.store.PruneHistory(time.Now().Add(time.Hour), 0); err != nil {
+		return err
+	}
+	cp.statusCache.invalidate()
+
+	run := &scenarioRun{scenario: scenario, startedAt: time.Now()}
+	cp.scenario = run
+	for _, cluster := range scenario.Clusters {
+		cp.putStatus(ClusterStatus{
+			ClusterName: cluster.Name,
+			Hub:         cluster.Hub,
+			Labels:      cluster.Labels,
+			Status:      cluster.Status,
+			Message:     cluster.Message,
+			LastUpdated: run.startedAt.Format(time.RFC3339),
+		})
+		for _, transition := range cluster.Transitions {
+			clusterName, transition := cluster.Name, transition
+			timer := time.AfterFunc(time.Duration(transition.AfterSeconds)*time.Second, func() {
+				cp.applyTransition(run, clusterName, transition)
+			})
+			run.timers = append(run.timers, timer)
+		}
+	}
+	logger().Info("Playing mock scenario", "scenario", scenario.Name, "clusters", len(scenario.Clusters))
+	return nil
+}
+
+// stopScenario cancels the transitions still to come. The caller holds
+// cp.mutex.
+func (cp *ClusterPlugin) stopScenario() {
+	if cp.scenario == nil {
+		return
+	}
+	for _, timer := range cp.scenario.timers {
+		timer.Stop()
+	}
+	cp.scenario = nil
+}
+
+// applyTransition plays one transition unless its scenario was replaced
+func (cp *ClusterPlugin) applyTransition(run *scenarioRun, clusterName string, transition ScenarioTransition) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if cp.scenario != run || cp.closed {
+		return
+	}
+	run.applied++
+	now := time.Now().Format(time.RFC3339)
+	if transition.Status != "Detached" {
+		cp.putStatus(ClusterStatus{ClusterName: clusterName, Status: transition.Status, Message: transition.Message, LastUpdated: now})
+		return
+	}
+	previous, exists, err := cp.store.Get(clusterName)
+	if err != nil || !exists {
+		return
+	}
+	cp.recordHistory(previous, ClusterStatus{ClusterName: clusterName, Status: "Detached", Message: transition.Message, LastUpdated: now})
+	if err := cp.store.Delete(clusterName); err != nil {
+		logger().Error("Failed to remove cluster from store", "cluster", clusterName, "error", err)
+		return
+	}
+	cp.statusCache.invalidate()
+	cp.broadcaster.Publish(StatusEvent{
+		ClusterName: clusterName,
+		Status:      "Detached",
+		Previous:    previous.Status,
+		Message:     transition.Message,
+		Timestamp:   now,
+	})
+}
+
+// mockFault returns the fault an operation on the cluster runs into, if any.
+// Without a scenario, onboardings of clusters named fail-* fail.
+func (cp *ClusterPlugin) mockFault(operation, clusterName string) *ScenarioFault {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	if cp.scenario == nil {
+		if operation == "onboard" && strings.HasPrefix(clusterName, mockFailPrefix) {
+			return &ScenarioFault{Cluster: mockFailPrefix + "*", Operation: operation}
+		}
+		return nil
+	}
+	for _, fault := range cp.scenario.scenario.Faults {
+		if matched, _ := path.Match(fault.Cluster, clusterName); matched && fault.Operation == operation {
+			return &fault
+		}
+	}
+	return nil
+}
+
+// mockStepDelay is how long each faked step takes
+func (cp *ClusterPlugin) mockStepDelay() time.Duration {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	if cp.scenario != nil && cp.scenario.scenario.StepDelayMillis != nil {
+		return time.Duration(*cp.scenario.scenario.StepDelayMillis) * time.Millisecond
+	}
+	return cp.mock.StepDelay
+}
+
+// pending lists the transitions of a run still to come, soonest
+// first. The caller holds cp.mutex.
+func (run *scenarioRun) pending() []PendingTransition {
+	elapsed := time.Since(run.startedAt)
+	pending := []PendingTransition{}
+	for _, cluster := range run.scenario.Clusters {
+		for _, transition := range cluster.Transitions {
+			after := time.Duration(transition.AfterSeconds) * time.Second
+			if after <= elapsed {
+				continue
+			}
+			pending = append(pending, PendingTransition{
+				ClusterName: cluster.Name,
+				At:          run.startedAt.Add(after).Format(time.RFC3339),
+				Status:      transition.Status,
+			})
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].At < pending[j].At })
+	return pending
+}
+
+// requireMockMode answers 409 outside mock mode
+func (cp *ClusterPlugin) requireMockMode(c *gin.Context) bool {
+	if cp.mode != modeMock {
+		respondProblem(c, http.StatusConflict, CodeConflict, "Scenarios are only available in mock mode")
+		return false
+	}
+	return true
+}
+
+// LoadScenarioHandler replaces the mock fleet with the YAML or JSON scenario
+// in the body and starts playing it
+func (cp *ClusterPlugin) LoadScenarioHandler(c *gin.Context) {
+	if !cp.requireMockMode(c) {
+		return
+	}
+	limit := cp.uploadLimit()
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondProblem(c, http.StatusRequestEntityTooLarge, CodePayloadTooLarge,
+			fmt.Sprintf("Scenario exceeds the limit of %d bytes", limit), "maxBytes", limit)
+		return
+	}
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Failed to read scenario")
+		return
+	}
+	scenario, err := cp.parseScenario(data)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeValidationFailed, err.Error())
+		return
+	}
+
+	cp.mutex.Lock()
+	err = cp.playScenario(scenario)
+	var startedAt time.Time
+	if cp.scenario != nil {
+		startedAt = cp.scenario.startedAt
+	}
+	cp.mutex.Unlock()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     message(c, MsgScenarioStarted, "scenario", scenario.Name),
+		"messageCode": MsgScenarioStarted,
+		"scenario":    scenario,
+		"startedAt":   startedAt.Format(time.RFC3339),
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// GetScenarioHandler reports the scenario being played and the transitions
+// still to come
+func (cp *ClusterPlugin) GetScenarioHandler(c *gin.Context) {
+	if !cp.requireMockMode(c) {
+		return
+	}
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	if cp.scenario == nil {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, "No scenario is being played")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"scenario":       cp.scenario.scenario,
+		"startedAt":      cp.scenario.startedAt.Format(time.RFC3339),
+		"elapsedSeconds": int(time.Since(cp.scenario.startedAt) / time.Second),
+		"applied":        cp.scenario.applied,
+		"pending":        cp.scenario.pending(),
+		"plugin":         "kubestellar-cluster-plugin",
+		"timestamp":      time.Now().Format(time.RFC3339),
+	})
+}
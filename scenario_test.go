@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testScenario = `
+name: outage
+clusters:
+  - name: edge-1
+    labels: {region: eu-west-1}
+    transitions:
+      - afterSeconds: 0
+        status: Unreachable
+        message: lease expired
+      - afterSeconds: 1
+        status: Ready
+  - name: edge-2
+    status: Joining
+    transitions:
+      - afterSeconds: 0
+        status: Detached
+faults:
+  - cluster: slow-*
+    step: Joining
+    afterSeconds: 1
+    message: agent never registered
+`
+
+func TestParseScenario(t *testing.T) {
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0})
+	scenario, err := plugin.parseScenario([]byte(testScenario))
+	if err != nil {
+		t.Fatalf("parseScenario() error = %v", err)
+	}
+	if scenario.Clusters[0].Status != "Ready" || scenario.Faults[0].Operation != "onboard" {
+		t.Errorf("defaults not filled in: %+v", scenario)
+	}
+
+	for name, body := range map[string]string{
+		"unknown field":   "name: x\nclusterz: []",
+		"duplicate":       "clusters: [{name: a}, {name: a}]",
+		"invalid name":    "clusters: [{name: Not_Valid}]",
+		"unknown hub":     "clusters: [{name: a, hub: its9}]",
+		"backwards":       "clusters: [{name: a, transitions: [{afterSeconds: 5, status: Failed}, {afterSeconds: 1, status: Ready}]}]",
+		"no status":       "clusters: [{name: a, transitions: [{afterSeconds: 5}]}]",
+		"bad pattern":     "faults: [{cluster: '['}]",
+		"bad operation":   "faults: [{cluster: a, operation: repair}]",
+		"unknown step":    "faults: [{cluster: a, step: Launching}]",
+		"detach step":     "faults: [{cluster: a, operation: detach, step: Joining}]",
+		"negative delays": "stepDelayMillis: -1",
+	} {
+		if _, err := plugin.parseScenario([]byte(body)); err == nil {
+			t.Errorf("%s: scenario accepted", name)
+		}
+	}
+}
+
+func TestScenarioPlayback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	file := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := os.WriteFile(file, []byte(testScenario), 0600); err != nil {
+		t.Fatal(err)
+	}
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode":                modeMock,
+		"mockScenario":        file,
+		"mockStepDelayMillis": 1,
+	})
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, "/api/plugins/kubestellar-cluster-plugin/v1"+path, strings.NewReader(body)))
+		return recorder
+	}
+	waitFor := func(check func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !check() {
+			if time.Now().After(deadline) {
+				t.Fatal("scenario did not get there")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The scenario replaces the generated fleet
+	clusters, _ := plugin.store.List()
+	if len(clusters) > 2 {
+		t.Fatalf("%d clusters, want the 2 of the scenario", len(clusters))
+	}
+	waitFor(func() bool {
+		_, exists, _ := plugin.store.Get("edge-2")
+		return !exists
+	})
+	waitFor(func() bool {
+		history, _ := plugin.store.History("edge-1")
+		return len(history) == 3 && history[2].Event == "recovered"
+	})
+	if history, _ := plugin.store.History("edge-2"); len(history) != 2 || history[1].Event != "detached" {
+		t.Errorf("edge-2 history = %+v", history)
+	}
+
+	started := time.Now()
+	recorder := serve(http.MethodPost, "/onboard?name=slow-edge", "")
+	var response OnboardResponse
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	job := waitForJob(t, plugin.jobs, response.JobID, func(job Job) bool { return job.Finished() })
+	if job.State != JobFailed || job.Message != "agent never registered" || time.Since(started) < time.Second {
+		t.Errorf("faulted onboarding = %s %q after %v", job.State, job.Message, time.Since(started))
+	}
+	if step := job.Steps[len(job.Steps)-2].Name; step != "Joining" {
+		t.Errorf("onboarding failed in %s, want Joining", step)
+	}
+
+	// Loading another scenario starts over
+	recorder = serve(http.MethodPost, "/mock/scenario", `{"name": "later", "clusters": [{"name": "edge-9", "transitions": [{"afterSeconds": 3600, "status": "Failed"}]}]}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("load scenario = %d %s", recorder.Code, recorder.Body.String())
+	}
+	clusters, _ = plugin.store.List()
+	if len(clusters) != 1 || clusters[0].ClusterName != "edge-9" {
+		t.Errorf("clusters after reload = %+v", clusters)
+	}
+	var progress struct {
+		Pending []PendingTransition `json:"pending"`
+	}
+	recorder = serve(http.MethodGet, "/mock/scenario", "")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &progress); err != nil || len(progress.Pending) != 1 || progress.Pending[0].Status != "Failed" {
+		t.Errorf("scenario progress = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(http.MethodPost, "/mock/scenario", "clusters: [{name: a, hub: its9}]"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid scenario = %d", recorder.Code)
+	}
+}
+
+func TestScenarioRequiresMockMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/plugins/kubestellar-cluster-plugin/v1/mock/scenario", strings.NewReader("name: x")))
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("scenario in live mode = %d", recorder.Code)
+	}
+}
@@ -0,0 +1,726 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultWindowMinutes is how long a scheduled operation may start late
+const defaultWindowMinutes = 60
+
+// ScheduleSpec defers an onboarding or detachment to a future time or to the
+// next maintenance window. Exactly one of At and Cron is set.
+type ScheduleSpec struct {
+	// At is the RFC3339 time the operation starts at
+	At string `json:"at,omitempty"`
+	// Cron is a five-field cron expression (minute hour day-of-month month
+	// day-of-week) marking when maintenance windows open; the operation
+	// starts when the next one does
+	Cron string `json:"cron,omitempty"`
+	// TimeZone is the IANA time zone Cron is evaluated in, UTC by default
+	TimeZone string `json:"timeZone,omitempty"`
+	// WindowMinutes is how long after its start the operation may still
+	// begin, 60 by default. A window missed while the plugin was down moves
+	// a Cron schedule to the next window and fails an At schedule.
+	WindowMinutes int `json:"windowMinutes,omitempty"`
+}
+
+// Validate checks the spec without regard to the current time
+func (s ScheduleSpec) Validate() error {
+	if (s.At == "") == (s.Cron == "") {
+		return fmt.Errorf("exactly one of schedule.at and schedule.cron must be set")
+	}
+	if s.At != "" {
+		if _, err := time.Parse(time.RFC3339, s.At); err != nil {
+			return fmt.Errorf("invalid schedule.at %q, must be an RFC3339 time", s.At)
+		}
+		if s.TimeZone != "" {
+			return fmt.Errorf("schedule.timeZone only applies to schedule.cron")
+		}
+	}
+	if s.Cron != "" {
+		if _, err := parseCron(s.Cron); err != nil {
+			return fmt.Errorf("invalid schedule.cron: %w", err)
+		}
+		if _, err := time.LoadLocation(s.TimeZone); err != nil {
+			return fmt.Errorf("invalid schedule.timeZone %q: %w", s.TimeZone, err)
+		}
+	}
+	if s.WindowMinutes < 0 {
+		return fmt.Errorf("schedule.windowMinutes must not be negative")
+	}
+	return nil
+}
+
+// window returns the first window of the spec that hasn't closed at now
+func (s ScheduleSpec) window(now time.Time) (time.Time, time.Time, error) {
+	length := time.Duration(s.WindowMinutes) * time.Minute
+	if s.WindowMinutes == 0 {
+		length = defaultWindowMinutes * time.Minute
+	}
+	if s.At != "" {
+		start, err := time.Parse(time.RFC3339, s.At)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		return start, start.Add(length), nil
+	}
+	expr, err := parseCron(s.Cron)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	// A window opened less than length ago is still open
+	start := expr.next(now.Add(-length).In(location))
+	if start.IsZero() {
+		return time.Time{}, time.Time{}, fmt.Errorf("cron expression %q never matches", s.Cron)
+	}
+	return start, start.Add(length), nil
+}
+
+// cronExpr is a parsed five-field cron expression. Each field is a bit set of
+// the values it matches.
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field: as in cron, a day matches
+	// both day fields when either is "*", and either of them otherwise
+	domAny, dowAny bool
+}
+
+// cronFields are the bounds of the cron fields, in order
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7},
+}
+
+// parseCron parses a five-field cron expression made of "*", values, ranges,
+// lists and steps such as "*/15" or "1-5/2"
+func parseCron(expr string) (cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return cronExpr{}, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		bounds := cronFields[i]
+		for _, item := range strings.Split(field, ",") {
+			set, err := parseCronItem(item, bounds.min, bounds.max)
+			if err != nil {
+				return cronExpr{}, fmt.Errorf("%s field %q: %w", bounds.name, field, err)
+			}
+			sets[i] |= set
+		}
+	}
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+	return cronExpr{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronItem(item string, min, max int) (uint64, error) {
+	rangePart, step := item, 1
+	if i := strings.Index(item, "/"); i >= 0 {
+		var err error
+		if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+			return 0, fmt.Errorf("invalid step %q", item[i+1:])
+		}
+		rangePart = item[:i]
+	}
+
+	low, high := min, max
+	switch {
+	case rangePart == "*":
+	case strings.Contains(rangePart, "-"):
+		bounds := strings.SplitN(rangePart, "-", 2)
+		var err error
+		if low, err = strconv.Atoi(bounds[0]); err != nil {
+			return 0, fmt.Errorf("invalid value %q", bounds[0])
+		}
+		if high, err = strconv.Atoi(bounds[1]); err != nil {
+			return 0, fmt.Errorf("invalid value %q", bounds[1])
+		}
+	default:
+		value, err := strconv.Atoi(rangePart)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %q", rangePart)
+		}
+		low = value
+		// "5/10" steps from 5 to the end of the range
+		if step == 1 {
+			high = value
+		}
+	}
+	if low < min || high > max || low > high {
+		return 0, fmt.Errorf("%q is out of range %d-%d", rangePart, min, max)
+	}
+
+	var set uint64
+	for value := low; value <= high; value += step {
+		set |= 1 << uint(value)
+	}
+	return set, nil
+}
+
+func (e cronExpr) matchesDay(t time.Time) bool {
+	dom := e.dom&(1<<uint(t.Day())) != 0
+	dow := e.dow&(1<<uint(t.Weekday())) != 0
+	if e.domAny || e.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t the expression matches, in t's
+// location, or the zero time if it matches none in the next five years
+func (e cronExpr) next(t time.Time) time.Time {
+	location := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case e.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+		case !e.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+		case e.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+		case e.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// ScheduleState describes where a schedule is in its lifecycle
+type ScheduleState string
+
+const (
+	ScheduleWaiting   ScheduleState = "Scheduled"
+	ScheduleStarted   ScheduleState = "Started"
+	ScheduleMissed    ScheduleState = "Missed"
+	ScheduleFailed    ScheduleState = "Failed"
+	ScheduleCancelled ScheduleState = "Cancelled"
+)
+
+// Schedule is an onboarding or detachment waiting for its window
+type Schedule struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	ClusterName string `json:"clusterName"`
+	// Hub is the hub an onboarding joins the cluster to
+	Hub       string        `json:"hub,omitempty"`
+	RequestID string        `json:"requestId,omitempty"`
+	Spec      ScheduleSpec  `json:"schedule"`
+	State     ScheduleState `json:"state"`
+	Message   string        `json:"message,omitempty"`
+	// WindowStart and WindowEnd bound when the operation may start
+	WindowStart string `json:"windowStart"`
+	WindowEnd   string `json:"windowEnd"`
+	// JobID is the job the operation runs as once started
+	JobID     string `json:"jobId,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+
+	// Inputs of the operation
+	Force        bool   `json:"force,omitempty"`
+	DrainSeconds int    `json:"drainSeconds,omitempty"`
+	SkipHooks    bool   `json:"skipHooks,omitempty"`
+	Provider     string `json:"provider,omitempty"`
+	// Tenant owns the schedule and the cluster it onboards
+	Tenant         string            `json:"tenant,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	ManifestValues *ManifestValues   `json:"manifestValues,omitempty"`
+
+	kubeconfig []byte
+}
+
+// Finished reports whether the schedule no longer waits for its window
+func (s Schedule) Finished() bool {
+	return s.State != ScheduleWaiting
+}
+
+// storedSchedule is a schedule as persisted, its kubeconfig sealed with the plugin key
+type storedSchedule struct {
+	Schedule
+	SealedKubeconfig []byte `json:"sealedKubeconfig,omitempty"`
+}
+
+// errScheduleConflict is returned when a cluster already has the same
+// operation scheduled
+var errScheduleConflict = errors.New("operation already scheduled")
+
+// ScheduleManager starts scheduled operations when their window opens
+type ScheduleManager struct {
+	schedules map[string]*Schedule
+	timers    map[string]*time.Timer
+	mutex     sync.Mutex
+	// path is the file schedules are persisted to, "" to keep them in memory
+	path string
+	box  *secretBox
+	// retention is how many finished schedules are kept; 0 keeps them all
+	retention int
+	// start begins the operation of a due schedule, returning its job ID
+	start   func(Schedule) (string, error)
+	stopped bool
+}
+
+// NewScheduleManager creates a schedule manager, restoring the schedules
+// persisted at path if any. Nothing starts before Start is called.
+func NewScheduleManager(path string, box *secretBox, retention int) (*ScheduleManager, error) {
+	sm := &ScheduleManager{
+		schedules: make(map[string]*Schedule),
+		timers:    make(map[string]*time.Timer),
+		path:      path,
+		box:       box,
+		retention: retention,
+	}
+	if path == "" {
+		return sm, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sm, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedules file: %w", err)
+	}
+	var stored []storedSchedule
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode schedules file: %w", err)
+	}
+	for i := range stored {
+		schedule := stored[i].Schedule
+		if len(stored[i].SealedKubeconfig) > 0 && !schedule.Finished() {
+			if schedule.kubeconfig, err = box.Open(stored[i].SealedKubeconfig); err != nil {
+				logger().Warn("Cannot restore scheduled operation", "schedule", schedule.ID, "cluster", schedule.ClusterName, "error", err)
+				schedule.State = ScheduleFailed
+				schedule.Message = fmt.Sprintf("Failed to open kubeconfig: %v", err)
+			}
+		}
+		sm.schedules[schedule.ID] = &schedule
+	}
+	return sm, nil
+}
+
+// Start arms the waiting schedules, starting each through start when its
+// window opens. Windows that closed while the plugin was down are handled
+// right away.
+func (sm *ScheduleManager) Start(start func(Schedule) (string, error)) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.start = start
+	sm.stopped = false
+	for id, schedule := range sm.schedules {
+		if !schedule.Finished() {
+			sm.arm(id)
+		}
+	}
+}
+
+// Stop disarms every schedule; they stay persisted for the next instance
+func (sm *ScheduleManager) Stop() {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.stopped = true
+	for id, timer := range sm.timers {
+		timer.Stop()
+		delete(sm.timers, id)
+	}
+}
+
+// Add registers a schedule for its next window and returns a snapshot of it
+func (sm *ScheduleManager) Add(ctx context.Context, schedule Schedule) (Schedule, error) {
+	start, end, err := schedule.Spec.window(time.Now())
+	if err != nil {
+		return Schedule{}, err
+	}
+	if !end.After(time.Now()) {
+		return Schedule{}, fmt.Errorf("schedule.at %s is in the past", schedule.Spec.At)
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	for _, existing := range sm.schedules {
+		if !existing.Finished() && existing.ClusterName == schedule.ClusterName && existing.Type == schedule.Type {
+			return Schedule{}, fmt.Errorf("%w: %s of cluster '%s' is scheduled by %s", errScheduleConflict, schedule.Type, schedule.ClusterName, existing.ID)
+		}
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	schedule.ID = newJobID("schedule")
+	schedule.RequestID = requestIDFromContext(ctx)
+	schedule.Tenant, _ = tenantFromContext(ctx)
+	schedule.State = ScheduleWaiting
+	schedule.WindowStart = start.Format(time.RFC3339)
+	schedule.WindowEnd = end.Format(time.RFC3339)
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+	sm.schedules[schedule.ID] = &schedule
+	if err := sm.save(); err != nil {
+		delete(sm.schedules, schedule.ID)
+		return Schedule{}, err
+	}
+	sm.arm(schedule.ID)
+	logger().Info("Operation scheduled", "schedule", schedule.ID, "type", schedule.Type,
+		"cluster", schedule.ClusterName, "windowStart", schedule.WindowStart)
+	return copySchedule(&schedule), nil
+}
+
+// Cancel stops a schedule that is still waiting for its window
+func (sm *ScheduleManager) Cancel(id string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	schedule, exists := sm.schedules[id]
+	if !exists {
+		return fmt.Errorf("schedule '%s' not found", id)
+	}
+	if schedule.Finished() {
+		return fmt.Errorf("schedule '%s' is not waiting (state: %s)", id, schedule.State)
+	}
+	if timer, ok := sm.timers[id]; ok {
+		timer.Stop()
+		delete(sm.timers, id)
+	}
+	sm.finish(schedule, ScheduleCancelled, "Schedule cancelled")
+	return nil
+}
+
+// Get returns a snapshot of a single schedule
+func (sm *ScheduleManager) Get(id string) (Schedule, bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	schedule, exists := sm.schedules[id]
+	if !exists {
+		return Schedule{}, false
+	}
+	return copySchedule(schedule), true
+}
+
+// List returns snapshots of all schedules, the soonest window first
+func (sm *ScheduleManager) List() []Schedule {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	schedules := make([]Schedule, 0, len(sm.schedules))
+	for _, schedule := range sm.schedules {
+		schedules = append(schedules, copySchedule(schedule))
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if schedules[i].WindowStart != schedules[j].WindowStart {
+			return schedules[i].WindowStart < schedules[j].WindowStart
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules
+}
+
+// arm sets the timer firing the schedule when its window opens. The caller
+// must hold the mutex.
+func (sm *ScheduleManager) arm(id string) {
+	if sm.stopped || sm.start == nil {
+		return
+	}
+	start, _ := time.Parse(time.RFC3339, sm.schedules[id].WindowStart)
+	sm.timers[id] = time.AfterFunc(time.Until(start), func() { sm.fire(id) })
+}
+
+// scheduleQueueRetry is how long a schedule waits for room in the job queue
+var scheduleQueueRetry = 30 * time.Second
+
+// fire starts the operation of a schedule whose window opened, or moves it
+// on when the window was missed
+func (sm *ScheduleManager) fire(id string) {
+	sm.mutex.Lock()
+	delete(sm.timers, id)
+	schedule, exists := sm.schedules[id]
+	if !exists || schedule.Finished() || sm.stopped {
+		sm.mutex.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if end, _ := time.Parse(time.RFC3339, schedule.WindowEnd); now.After(end) {
+		missed := fmt.Sprintf("Missed the window from %s to %s", schedule.WindowStart, schedule.WindowEnd)
+		if schedule.Spec.Cron == "" {
+			sm.finish(schedule, ScheduleMissed, missed)
+			sm.mutex.Unlock()
+			return
+		}
+		start, end, err := schedule.Spec.window(now)
+		if err != nil {
+			sm.finish(schedule, ScheduleFailed, err.Error())
+			sm.mutex.Unlock()
+			return
+		}
+		schedule.WindowStart = start.Format(time.RFC3339)
+		schedule.WindowEnd = end.Format(time.RFC3339)
+		schedule.Message = missed + ", moved to the next window"
+		schedule.UpdatedAt = now.Format(time.RFC3339)
+		logger().Warn("Scheduled operation missed its window", "schedule", id, "cluster", schedule.ClusterName, "next", schedule.WindowStart)
+		sm.persist()
+		sm.arm(id)
+		sm.mutex.Unlock()
+		return
+	}
+	// Claim the schedule so it can't be cancelled while its operation starts
+	operation := *schedule
+	schedule.State = ScheduleStarted
+	schedule.Message = fmt.Sprintf("Starting %s", schedule.Type)
+	start := sm.start
+	sm.mutex.Unlock()
+
+	jobID, err := start(operation)
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if errors.Is(err, errJobQueueFull) && !sm.stopped {
+		// Try again while the window is open; fire reports it missed otherwise
+		schedule.State = ScheduleWaiting
+		schedule.Message = fmt.Sprintf("Job queue is full, retrying in %s", scheduleQueueRetry)
+		schedule.UpdatedAt = time.Now().Format(time.RFC3339)
+		sm.persist()
+		sm.timers[id] = time.AfterFunc(scheduleQueueRetry, func() { sm.fire(id) })
+		return
+	}
+	if err != nil {
+		logger().Error("Scheduled operation failed to start", "schedule", id, "cluster", operation.ClusterName, "error", err)
+		sm.finish(schedule, ScheduleFailed, fmt.Sprintf("Failed to start %s: %v", operation.Type, err))
+		return
+	}
+	schedule.JobID = jobID
+	sm.finish(schedule, ScheduleStarted, fmt.Sprintf("Started as job %s", jobID))
+}
+
+// finish moves a schedule to a terminal state. The caller must hold the mutex.
+func (sm *ScheduleManager) finish(schedule *Schedule, state ScheduleState, message string) {
+	schedule.State = state
+	schedule.Message = message
+	schedule.UpdatedAt = time.Now().Format(time.RFC3339)
+	// The operation no longer needs its kubeconfig
+	schedule.kubeconfig = nil
+	sm.evictFinished()
+	sm.persist()
+}
+
+// evictFinished drops the oldest finished schedules beyond the retention
+// limit. The caller must hold the mutex.
+func (sm *ScheduleManager) evictFinished() {
+	if sm.retention <= 0 {
+		return
+	}
+	var finished []*Schedule
+	for _, schedule := range sm.schedules {
+		if schedule.Finished() {
+			finished = append(finished, schedule)
+		}
+	}
+	if len(finished) <= sm.retention {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].UpdatedAt < finished[j].UpdatedAt
+	})
+	for _, schedule := range finished[:len(finished)-sm.retention] {
+		delete(sm.schedules, schedule.ID)
+	}
+}
+
+// reseal rewrites the schedules file when a kubeconfig in it isn't sealed
+// with the current encryption key
+func (sm *ScheduleManager) reseal() (int, error) {
+	if sm.path == "" {
+		return 0, nil
+	}
+	data, err := os.ReadFile(sm.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schedules file: %w", err)
+	}
+	var stored []storedSchedule
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return 0, fmt.Errorf("failed to decode schedules file: %w", err)
+	}
+	stale := 0
+	for _, entry := range stored {
+		if len(entry.SealedKubeconfig) == 0 {
+			continue
+		}
+		if _, changed, err := sm.box.Reseal(entry.SealedKubeconfig); err == nil && changed {
+			stale++
+		}
+	}
+	if stale == 0 {
+		return 0, nil
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return stale, sm.save()
+}
+
+// persist saves the schedules, logging failures. The caller must hold the mutex.
+func (sm *ScheduleManager) persist() {
+	if err := sm.save(); err != nil {
+		logger().Warn("Failed to persist schedules", "error", err)
+	}
+}
+
+// save writes every schedule to the schedules file. The caller must hold the mutex.
+func (sm *ScheduleManager) save() error {
+	if sm.path == "" {
+		return nil
+	}
+	stored := make([]storedSchedule, 0, len(sm.schedules))
+	for _, schedule := range sm.schedules {
+		entry := storedSchedule{Schedule: *schedule}
+		if len(schedule.kubeconfig) > 0 {
+			sealed, err := sm.box.Seal(schedule.kubeconfig)
+			if err != nil {
+				return fmt.Errorf("failed to seal kubeconfig of schedule %s: %w", schedule.ID, err)
+			}
+			entry.SealedKubeconfig = sealed
+		}
+		stored = append(stored, entry)
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schedules: %w", err)
+	}
+	tmpPath := sm.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write schedules file: %w", err)
+	}
+	return os.Rename(tmpPath, sm.path)
+}
+
+func copySchedule(schedule *Schedule) Schedule {
+	snapshot := *schedule
+	snapshot.kubeconfig = nil
+	return snapshot
+}
+
+// scheduleOperation defers an onboarding or detachment to its schedule
+func (cp *ClusterPlugin) scheduleOperation(c *gin.Context, schedule Schedule) {
+	created, err := cp.schedules.Add(c.Request.Context(), schedule)
+	if errors.Is(err, errScheduleConflict) {
+		respondProblem(c, http.StatusConflict, CodeConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     message(c, MsgScheduleCreated, "cluster", created.ClusterName, "operation", created.Type, "windowStart", created.WindowStart),
+		"messageCode": MsgScheduleCreated,
+		"schedule":    created,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// startScheduled starts the operation of a schedule whose window opened
+func (cp *ClusterPlugin) startScheduled(schedule Schedule) (string, error) {
+	ctx := context.WithValue(context.Background(), requestIDContextKey{}, schedule.RequestID)
+	if schedule.Tenant != "" {
+		ctx = context.WithValue(ctx, tenantContextKey{}, schedule.Tenant)
+	}
+	if schedule.Type == "detach" {
+		jobID, _, err := cp.beginDetach(ctx, schedule.ClusterName, schedule.kubeconfig, schedule.Force, time.Duration(schedule.DrainSeconds)*time.Second, schedule.SkipHooks)
+		return jobID, err
+	}
+
+	jobID, existing, err := cp.beginOnboarding(ctx, schedule.ClusterName, schedule.Hub, schedule.Provider, "", schedule.Labels, schedule.Annotations)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return "", fmt.Errorf("cluster is already onboarded (status: %s)", existing.Status)
+	}
+	cp.runJob(jobID, cp.onboardingJob(jobID, schedule.ClusterName, schedule.kubeconfig, schedule.ManifestValues))
+	return jobID, nil
+}
+
+// ListSchedulesHandler returns all schedules, optionally filtered by cluster, type or state
+func (cp *ClusterPlugin) ListSchedulesHandler(c *gin.Context) {
+	clusterFilter := c.Query("cluster")
+	typeFilter := c.Query("type")
+	stateFilter := c.Query("state")
+
+	schedules := []Schedule{}
+	for _, schedule := range cp.schedules.List() {
+		if !visibleTo(c.Request.Context(), schedule.Tenant) {
+			continue
+		}
+		if clusterFilter != "" && schedule.ClusterName != clusterFilter {
+			continue
+		}
+		if typeFilter != "" && schedule.Type != typeFilter {
+			continue
+		}
+		if stateFilter != "" && string(schedule.State) != stateFilter {
+			continue
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schedules": schedules,
+		"total":     len(schedules),
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// CancelScheduleHandler cancels a schedule that hasn't started its operation
+func (cp *ClusterPlugin) CancelScheduleHandler(c *gin.Context) {
+	id := c.Param("id")
+	if schedule, exists := cp.schedules.Get(id); exists && !visibleTo(c.Request.Context(), schedule.Tenant) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Schedule '%s' not found", id))
+		return
+	}
+	if err := cp.schedules.Cancel(id); err != nil {
+		status, code := http.StatusConflict, CodeConflict
+		if _, exists := cp.schedules.Get(id); !exists {
+			status, code = http.StatusNotFound, CodeNotFound
+		}
+		respondProblem(c, status, code, err.Error())
+		return
+	}
+
+	schedule, _ := cp.schedules.Get(id)
+	c.JSON(http.StatusOK, gin.H{
+		"message":     message(c, MsgScheduleCancelled, "schedule", id),
+		"messageCode": MsgScheduleCancelled,
+		"schedule":    schedule,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
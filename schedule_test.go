@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, 10, 14, 10, 7, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "*/15 * * * *", want: time.Date(2026, 10, 14, 10, 15, 0, 0, time.UTC)},
+		{expr: "30 2 * * 6", want: time.Date(2026, 10, 17, 2, 30, 0, 0, time.UTC)},
+		{expr: "0 22 * * 0", want: time.Date(2026, 10, 18, 22, 0, 0, 0, time.UTC)},
+		{expr: "0 22 * * 7", want: time.Date(2026, 10, 18, 22, 0, 0, 0, time.UTC)},
+		{expr: "0 9 * * 1-5/2", want: time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)},
+		// Restricted day-of-month and day-of-week match either
+		{expr: "0 0 1,15 * 1", want: time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 3 1 1 *", want: time.Date(2027, 1, 1, 3, 0, 0, 0, time.UTC)},
+		{expr: "0 0 30 2 *"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron() error = %v", err)
+			}
+			if got := expr.next(from); !got.Equal(tt.want) {
+				t.Errorf("next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "0 0 * * MON"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestScheduleSpecValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    ScheduleSpec
+		wantErr bool
+	}{
+		{name: "at", spec: ScheduleSpec{At: "2026-10-17T02:00:00Z"}},
+		{name: "cron in a time zone", spec: ScheduleSpec{Cron: "0 2 * * 6", TimeZone: "UTC", WindowMinutes: 120}},
+		{name: "neither", spec: ScheduleSpec{}, wantErr: true},
+		{name: "both", spec: ScheduleSpec{At: "2026-10-17T02:00:00Z", Cron: "0 2 * * 6"}, wantErr: true},
+		{name: "at without zone offset", spec: ScheduleSpec{At: "2026-10-17 02:00"}, wantErr: true},
+		{name: "unknown time zone", spec: ScheduleSpec{Cron: "0 2 * * 6", TimeZone: "Mars/Olympus"}, wantErr: true},
+		{name: "negative window", spec: ScheduleSpec{At: "2026-10-17T02:00:00Z", WindowMinutes: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.spec.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func waitForSchedule(t *testing.T, sm *ScheduleManager, id string, state ScheduleState) Schedule {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		schedule, _ := sm.Get(id)
+		if schedule.State == state && (state != ScheduleStarted || schedule.JobID != "") {
+			return schedule
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("schedule %s is %s (%s), want %s", id, schedule.State, schedule.Message, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScheduleManager(t *testing.T) {
+	box, err := newSecretBox(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	sm, err := NewScheduleManager(path, box, 0)
+	if err != nil {
+		t.Fatalf("NewScheduleManager() error = %v", err)
+	}
+	started := make(chan Schedule, 1)
+	sm.Start(func(schedule Schedule) (string, error) {
+		started <- schedule
+		return "detach-1", nil
+	})
+	defer sm.Stop()
+	ctx := context.Background()
+
+	// A window that is already open starts right away
+	due, err := sm.Add(ctx, Schedule{
+		Type: "detach", ClusterName: "edge-1", kubeconfig: []byte("kubeconfig"),
+		Spec: ScheduleSpec{At: time.Now().Add(-time.Minute).Format(time.RFC3339)},
+	})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if operation := <-started; string(operation.kubeconfig) != "kubeconfig" {
+		t.Errorf("operation started with kubeconfig %q", operation.kubeconfig)
+	}
+	if schedule := waitForSchedule(t, sm, due.ID, ScheduleStarted); schedule.JobID != "detach-1" {
+		t.Errorf("started schedule = %+v", schedule)
+	}
+
+	later := ScheduleSpec{At: time.Now().Add(time.Hour).Format(time.RFC3339)}
+	waiting, err := sm.Add(ctx, Schedule{Type: "detach", ClusterName: "edge-2", Spec: later, kubeconfig: []byte("edge-2")})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := sm.Add(ctx, Schedule{Type: "detach", ClusterName: "edge-2", Spec: later}); !errors.Is(err, errScheduleConflict) {
+		t.Errorf("Add() of a second detach error = %v, want conflict", err)
+	}
+	if _, err := sm.Add(ctx, Schedule{Type: "onboard", ClusterName: "edge-3", Spec: ScheduleSpec{At: "2020-01-01T00:00:00Z"}}); err == nil {
+		t.Error("Add() in the past succeeded")
+	}
+
+	// Waiting schedules survive a restart, kubeconfig included
+	restored, err := NewScheduleManager(path, box, 0)
+	if err != nil {
+		t.Fatalf("NewScheduleManager() reload error = %v", err)
+	}
+	if schedule := restored.schedules[waiting.ID]; schedule == nil || string(schedule.kubeconfig) != "edge-2" {
+		t.Errorf("restored schedule = %+v", schedule)
+	}
+
+	if err := sm.Cancel(waiting.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if schedule, _ := sm.Get(waiting.ID); schedule.State != ScheduleCancelled {
+		t.Errorf("cancelled schedule state = %s", schedule.State)
+	}
+	if err := sm.Cancel(waiting.ID); err == nil {
+		t.Error("Cancel() of a cancelled schedule succeeded")
+	}
+}
+
+func TestScheduleManagerMissedWindow(t *testing.T) {
+	sm, err := NewScheduleManager("", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	at, err := sm.Add(ctx, Schedule{Type: "detach", ClusterName: "edge-1", Spec: ScheduleSpec{At: time.Now().Add(time.Hour).Format(time.RFC3339)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A daily window that isn't open now
+	opens := time.Now().UTC().Add(2 * time.Hour)
+	daily := ScheduleSpec{Cron: fmt.Sprintf("%d %d * * *", opens.Minute(), opens.Hour())}
+	cron, err := sm.Add(ctx, Schedule{Type: "detach", ClusterName: "edge-2", Spec: daily})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pretend the plugin was down during both windows
+	yesterday := time.Now().Add(-24 * time.Hour)
+	for _, id := range []string{at.ID, cron.ID} {
+		sm.schedules[id].WindowStart = yesterday.Format(time.RFC3339)
+		sm.schedules[id].WindowEnd = yesterday.Add(time.Hour).Format(time.RFC3339)
+	}
+
+	sm.Start(func(schedule Schedule) (string, error) {
+		t.Errorf("missed schedule %s started", schedule.ID)
+		return "", nil
+	})
+	defer sm.Stop()
+	waitForSchedule(t, sm, at.ID, ScheduleMissed)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		schedule, _ := sm.Get(cron.ID)
+		if start, _ := time.Parse(time.RFC3339, schedule.WindowStart); start.After(time.Now()) {
+			if schedule.State != ScheduleWaiting {
+				t.Errorf("cron schedule state = %s, want %s", schedule.State, ScheduleWaiting)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cron schedule wasn't moved to its next window: %+v", schedule)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScheduleHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	router := gin.New()
+	router.POST("/detach", plugin.DetachClusterHandler)
+	router.GET("/schedules", plugin.ListSchedulesHandler)
+	router.POST("/schedules/:id/cancel", plugin.CancelScheduleHandler)
+	detach := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/detach", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := detach(`{"clusterName": "edge-1", "schedule": {"cron": "0 2 * * 6", "timeZone": "UTC", "windowMinutes": 120}}`)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("scheduled detach status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	var created struct {
+		Schedule Schedule `json:"schedule"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ := plugin.store.Get("edge-1"); status.Status != "Ready" {
+		t.Errorf("scheduling changed the cluster status to %s", status.Status)
+	}
+
+	for body, want := range map[string]int{
+		`{"clusterName": "edge-1", "schedule": {"cron": "0 3 * * 0"}}`:   http.StatusConflict,
+		`{"clusterName": "unknown", "schedule": {"cron": "0 3 * * 0"}}`:  http.StatusNotFound,
+		`{"clusterName": "edge-1", "schedule": {"cron": "0 3 * * SUN"}}`: http.StatusBadRequest,
+	} {
+		if recorder := detach(body); recorder.Code != want {
+			t.Errorf("detach %s status = %d, want %d", body, recorder.Code, want)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/schedules?cluster=edge-1&state=Scheduled", nil))
+	var list struct {
+		Schedules []Schedule `json:"schedules"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Schedules) != 1 || list.Schedules[0].ID != created.Schedule.ID {
+		t.Errorf("GET /schedules = %+v", list.Schedules)
+	}
+
+	for _, want := range []int{http.StatusOK, http.StatusConflict} {
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/schedules/"+created.Schedule.ID+"/cancel", nil))
+		if recorder.Code != want {
+			t.Errorf("cancel status = %d, want %d", recorder.Code, want)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// schemaRefPrefix starts the $ref of a schema declared in the metadata
+const schemaRefPrefix = "#/schemas/"
+
+// JSONSchema is the subset of JSON Schema request bodies are validated with:
+// types, required and bounded fields, patterns, formats, enums, nested
+// objects and arrays, and $ref to another schema of the metadata as
+// "#/schemas/<name>"
+type JSONSchema struct {
+	Ref         string      `json:"$ref,omitempty"`
+	Title       string      `json:"title,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Type        schemaTypes `json:"type,omitempty"`
+	// Format is date-time or uri
+	Format  string        `json:"format,omitempty"`
+	Enum    []interface{} `json:"enum,omitempty"`
+	Default interface{}   `json:"default,omitempty"`
+
+	Properties map[string]*JSONSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	// AdditionalProperties is the schema of object members not listed in
+	// Properties, such as the values of a label map
+	AdditionalProperties *JSONSchema `json:"additionalProperties,omitempty"`
+
+	Items    *JSONSchema `json:"items,omitempty"`
+	MinItems *int        `json:"minItems,omitempty"`
+	MaxItems *int        `json:"maxItems,omitempty"`
+
+	MinLength *int   `json:"minLength,omitempty"`
+	MaxLength *int   `json:"maxLength,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+}
+
+// schemaTypes is the type keyword, a single type or a list of them
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+func (t schemaTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+var schemaTypeNames = map[string]bool{
+	"object": true, "array": true, "string": true, "integer": true, "number": true, "boolean": true, "null": true,
+}
+
+var schemaFormats = map[string]bool{"date-time": true, "uri": true}
+
+// FieldError is a member of a request body that doesn't match its schema
+type FieldError struct {
+	// Field is the path of the member, such as clusters[0].clusterName, or
+	// empty for the body itself
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validateSchemas checks that every schema uses supported keywords, has
+// valid patterns and only references declared schemas
+func validateSchemas(schemas map[string]*JSONSchema) []error {
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if schemas[name] == nil {
+			errs = append(errs, fmt.Errorf("schema %s is empty", name))
+			continue
+		}
+		schemas[name].check(name, schemas, &errs)
+	}
+	return errs
+}
+
+func (s *JSONSchema) check(path string, schemas map[string]*JSONSchema, errs *[]error) {
+	if s.Ref != "" {
+		if _, ok := schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]; !ok || !strings.HasPrefix(s.Ref, schemaRefPrefix) {
+			*errs = append(*errs, fmt.Errorf("schema %s: $ref %q does not name a declared schema", path, s.Ref))
+		}
+	}
+	for _, t := range s.Type {
+		if !schemaTypeNames[t] {
+			*errs = append(*errs, fmt.Errorf("schema %s: unknown type %q", path, t))
+		}
+	}
+	if s.Format != "" && !schemaFormats[s.Format] {
+		*errs = append(*errs, fmt.Errorf("schema %s: unsupported format %q", path, s.Format))
+	}
+	if s.Pattern != "" {
+		if _, err := regexp.Compile(s.Pattern); err != nil {
+			*errs = append(*errs, fmt.Errorf("schema %s: invalid pattern: %w", path, err))
+		}
+	}
+	for name, property := range s.Properties {
+		if property != nil {
+			property.check(path+"."+name, schemas, errs)
+		}
+	}
+	if s.AdditionalProperties != nil {
+		s.AdditionalProperties.check(path+".*", schemas, errs)
+	}
+	if s.Items != nil {
+		s.Items.check(path+"[]", schemas, errs)
+	}
+}
+
+// Validate returns every member of value that doesn't match the schema.
+// value is a decoded JSON document; schemas resolves $ref.
+func (s *JSONSchema) Validate(value interface{}, schemas map[string]*JSONSchema) []FieldError {
+	var errs []FieldError
+	s.validate(value, "", schemas, &errs, 0)
+	return errs
+}
+
+// maxSchemaDepth stops $ref cycles that a document nests too deep
+const maxSchemaDepth = 32
+
+func (s *JSONSchema) validate(value interface{}, field string, schemas map[string]*JSONSchema, errs *[]FieldError, depth int) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if depth > maxSchemaDepth {
+		fail("is nested too deeply")
+		return
+	}
+	if s.Ref != "" {
+		if target, ok := schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]; ok && target != nil {
+			target.validate(value, field, schemas, errs, depth+1)
+		}
+	}
+
+	if len(s.Type) > 0 && !s.Type.matches(value) {
+		fail("must be %s, not %s", strings.Join(s.Type, " or "), jsonTypeOf(value))
+		return
+	}
+	if len(s.Enum) > 0 && !enumContains(s.Enum, value) {
+		fail("must be one of %s", formatEnum(s.Enum))
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("must be at most %d characters long", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			if pattern, err := regexp.Compile(s.Pattern); err == nil && !pattern.MatchString(v) {
+				fail("must match %s", s.Pattern)
+			}
+		}
+		switch s.Format {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				fail("must be an RFC 3339 time")
+			}
+		case "uri":
+			if parsed, err := url.Parse(v); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+				fail("must be an absolute URI")
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", field, i), schemas, errs, depth+1)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, FieldError{Field: joinField(field, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := s.Properties[name]; ok && property != nil {
+				property.validate(v[name], joinField(field, name), schemas, errs, depth+1)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(v[name], joinField(field, name), schemas, errs, depth+1)
+			}
+		}
+	}
+}
+
+func (t schemaTypes) matches(value interface{}) bool {
+	actual := jsonTypeOf(value)
+	for _, expected := range t {
+		if expected == actual || (expected == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeOf names the JSON type of a decoded value, telling integers from
+// other numbers
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func enumContains(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) && jsonTypeOf(allowed) == jsonTypeOf(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		values[i] = fmt.Sprintf("%v", value)
+	}
+	return strings.Join(values, ", ")
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// withSchemaValidation rejects JSON request bodies that don't match the
+// schema of their endpoint before the handler runs, listing every field in
+// error at once
+func (cp *ClusterPlugin) withSchemaValidation(_ string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		endpoint, ok := cp.routeEndpoint(c)
+		// Multipart uploads and the like are validated by their handler
+		if !ok || endpoint.RequestSchema == "" || c.Request.Body == nil || !strings.HasSuffix(c.ContentType(), "json") {
+			handler(c)
+			return
+		}
+		schemas := cp.GetMetadata().Schemas
+		schema, ok := schemas[endpoint.RequestSchema]
+		if !ok || schema == nil {
+			handler(c)
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Failed to read request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		var payload interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				err = fmt.Errorf("%v at offset %d", syntaxErr, syntaxErr.Offset)
+			}
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Request body is not valid JSON: %v", err))
+			return
+		}
+		if errs := schema.Validate(payload, schemas); len(errs) > 0 {
+			requestLogger(c).Info("Rejected request body", "path", c.Request.URL.Path, "schema", endpoint.RequestSchema, "errors", len(errs))
+			respondProblem(c, http.StatusBadRequest, CodeValidationFailed,
+				fmt.Sprintf("Request body does not match the %s schema", endpoint.RequestSchema), "errors", errs)
+			return
+		}
+		handler(c)
+	}
+}
+
+// SchemaUsage names the schema of an endpoint's request body
+type SchemaUsage struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Schema string `json:"schema"`
+}
+
+// ListSchemasHandler returns the request body schemas and the endpoints they
+// apply to, for hosts that generate forms from them
+func (cp *ClusterPlugin) ListSchemasHandler(c *gin.Context) {
+	metadata := cp.GetMetadata()
+	usages := []SchemaUsage{}
+	for _, endpoint := range metadata.Endpoints {
+		if endpoint.RequestSchema != "" {
+			usages = append(usages, SchemaUsage{Method: endpoint.Method, Path: endpoint.Path, Schema: endpoint.RequestSchema})
+		}
+	}
+	schemas := metadata.Schemas
+	if schemas == nil {
+		schemas = map[string]*JSONSchema{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schemas":   schemas,
+		"endpoints": usages,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
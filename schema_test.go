@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestJSONSchemaValidate(t *testing.T) {
+	schemas := defaultMetadata().Schemas
+
+	tests := []struct {
+		name       string
+		schema     string
+		body       string
+		wantFields []string
+	}{
+		{name: "valid onboard", schema: "OnboardRequest", body: `{"clusterName": "edge-1", "labels": {"env": "prod"}, "schedule": {"windowMinutes": 30}}`},
+		{name: "missing name", schema: "OnboardRequest", body: `{"hub": "default"}`, wantFields: []string{"clusterName"}},
+		{name: "invalid name and label", schema: "OnboardRequest", body: `{"clusterName": "Edge_1", "labels": {"env": 1}}`, wantFields: []string{"clusterName", "labels.env"}},
+		{name: "fractional window", schema: "OnboardRequest", body: `{"clusterName": "edge-1", "schedule": {"windowMinutes": 1.5, "at": "tomorrow"}}`, wantFields: []string{"schedule.at", "schedule.windowMinutes"}},
+		{name: "batch items", schema: "BatchOnboardRequest", body: `{"clusters": [{"clusterName": "edge-1"}, {"provider": {"name": "openshift"}}]}`, wantFields: []string{"clusters[1].clusterName", "clusters[1].provider.name"}},
+		{name: "null label removes it", schema: "LabelsPatchRequest", body: `{"labels": {"env": null, "tier": "web"}}`},
+		{name: "unknown event", schema: "WebhookRequest", body: `{"url": "https://example.com/hook", "events": ["cluster.exploded"]}`, wantFields: []string{"events[0]"}},
+		{name: "relative url", schema: "WebhookRequest", body: `{"url": "/hook"}`, wantFields: []string{"url"}},
+		{name: "not an object", schema: "DetachRequest", body: `["edge-1"]`, wantFields: []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload interface{}
+			if err := json.Unmarshal([]byte(tt.body), &payload); err != nil {
+				t.Fatal(err)
+			}
+			errs := schemas[tt.schema].Validate(payload, schemas)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("Validate() = %+v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestValidateSchemas(t *testing.T) {
+	errs := validateSchemas(map[string]*JSONSchema{
+		"Broken": {
+			Type: schemaTypes{"object"},
+			Properties: map[string]*JSONSchema{
+				"name":  {Type: schemaTypes{"text"}},
+				"code":  {Type: schemaTypes{"string"}, Pattern: "("},
+				"owner": {Ref: "#/schemas/Missing"},
+				"when":  {Type: schemaTypes{"string"}, Format: "email"},
+			},
+		},
+	})
+	if len(errs) != 4 {
+		t.Errorf("validateSchemas() = %v, want 4 errors", errs)
+	}
+	if errs := validateSchemas(defaultMetadata().Schemas); len(errs) > 0 {
+		t.Errorf("embedded schemas are invalid: %v", errs)
+	}
+
+	metadata := defaultMetadata()
+	metadata.Endpoints[0].RequestSchema = "Missing"
+	if err := metadata.Validate((&ClusterPlugin{}).bareHandlers()); err == nil || !strings.Contains(err.Error(), `request schema "Missing"`) {
+		t.Errorf("Validate() = %v, want the undeclared request schema reported", err)
+	}
+}
+
+func TestSchemaValidationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	const prefix = "/api/plugins/kubestellar-cluster-plugin/v1"
+
+	post := func(body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, prefix+"/onboard", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := post(`{"clusterName": "Edge_1", "dryRun": "yes"}`)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("invalid body = %d, want 400: %s", recorder.Code, recorder.Body.String())
+	}
+	var problem struct {
+		Code   ErrorCode    `json:"code"`
+		Errors []FieldError `json:"errors"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Code != CodeValidationFailed || len(problem.Errors) != 2 {
+		t.Errorf("problem = %+v, want both fields reported", problem)
+	}
+
+	if recorder := post(`{"clusterName": `); recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "not valid JSON") {
+		t.Errorf("malformed body = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, prefix+"/schemas", nil))
+	var listed struct {
+		Schemas   map[string]JSONSchema `json:"schemas"`
+		Endpoints []SchemaUsage         `json:"endpoints"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := listed.Schemas["OnboardRequest"]; !ok || len(listed.Endpoints) == 0 {
+		t.Errorf("GET /schemas = %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchFields are the fields a search query can filter on with field:value,
+// each returning the values a cluster has for it
+var searchFields = map[string]func(cluster ClusterStatus, labels map[string]string) []string{
+	"name":   func(cluster ClusterStatus, _ map[string]string) []string { return []string{cluster.ClusterName} },
+	"status": func(cluster ClusterStatus, _ map[string]string) []string { return []string{cluster.Status} },
+	"hub":    func(cluster ClusterStatus, _ map[string]string) []string { return []string{hubName(cluster)} },
+	"tenant": func(cluster ClusterStatus, _ map[string]string) []string { return []string{cluster.Tenant} },
+	"provider": func(_ ClusterStatus, labels map[string]string) []string {
+		return labelValues(labels, providerLabels)
+	},
+	"region": func(_ ClusterStatus, labels map[string]string) []string {
+		return labelValues(labels, regionLabels)
+	},
+	"zone": func(_ ClusterStatus, labels map[string]string) []string {
+		return labelValues(labels, []string{"zone", zoneLabel})
+	},
+	"owner": func(cluster ClusterStatus, _ map[string]string) []string {
+		if cluster.Profile == nil {
+			return nil
+		}
+		return []string{cluster.Profile.Owner}
+	},
+	// label matches key=value, or key alone for any value
+	"label": func(_ ClusterStatus, labels map[string]string) []string {
+		values := make([]string, 0, 2*len(labels))
+		for key, value := range labels {
+			values = append(values, key, key+"="+value)
+		}
+		return values
+	},
+}
+
+func labelValues(labels map[string]string, keys []string) []string {
+	var values []string
+	for _, key := range keys {
+		if value := labels[key]; value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// searchDoc is what the index keeps of a cluster
+type searchDoc struct {
+	cluster ClusterStatus
+	// fields holds the lower-cased values of each search field
+	fields map[string][]string
+	tokens []string
+}
+
+func newSearchDoc(cluster ClusterStatus) *searchDoc {
+	cluster.ManagedCluster = nil
+	labels := clusterLabelSet(cluster)
+	doc := &searchDoc{cluster: cluster, fields: make(map[string][]string, len(searchFields))}
+	for field, values := range searchFields {
+		for _, value := range values(cluster, labels) {
+			if value != "" {
+				doc.fields[field] = append(doc.fields[field], strings.ToLower(value))
+			}
+		}
+	}
+
+	// Free text runs over the name, status, message, labels and profile
+	text := []string{cluster.ClusterName, cluster.Status, cluster.Message}
+	for key, value := range labels {
+		text = append(text, key, value)
+	}
+	if profile := cluster.Profile; profile != nil {
+		text = append(text, profile.DisplayName, profile.Description, profile.Owner, profile.Contact, profile.CostCenter)
+	}
+	seen := make(map[string]bool)
+	for _, s := range text {
+		for _, token := range searchTokens(s) {
+			if !seen[token] {
+				seen[token] = true
+				doc.tokens = append(doc.tokens, token)
+			}
+		}
+	}
+	return doc
+}
+
+// searchTokens lower-cases s and splits it into its alphanumeric runs, so a
+// search for edge-1 looks for edge and 1
+func searchTokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// searchIndex is an inverted index of the cluster inventory, kept in sync by
+// indexedClusterStore
+type searchIndex struct {
+	mutex sync.RWMutex
+	docs  map[string]*searchDoc
+	// postings maps each token to the clusters it occurs in
+	postings map[string]map[string]bool
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{docs: make(map[string]*searchDoc), postings: make(map[string]map[string]bool)}
+}
+
+// put indexes a cluster, replacing what was indexed for it before
+func (si *searchIndex) put(cluster ClusterStatus) {
+	doc := newSearchDoc(cluster)
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+	si.removeLocked(cluster.ClusterName)
+	si.docs[cluster.ClusterName] = doc
+	for _, token := range doc.tokens {
+		if si.postings[token] == nil {
+			si.postings[token] = make(map[string]bool)
+		}
+		si.postings[token][cluster.ClusterName] = true
+	}
+}
+
+func (si *searchIndex) remove(name string) {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+	si.removeLocked(name)
+}
+
+func (si *searchIndex) removeLocked(name string) {
+	doc, exists := si.docs[name]
+	if !exists {
+		return
+	}
+	for _, token := range doc.tokens {
+		delete(si.postings[token], name)
+		if len(si.postings[token]) == 0 {
+			delete(si.postings, token)
+		}
+	}
+	delete(si.docs, name)
+}
+
+// Search returns the clusters matching every term of the query, by name
+func (si *searchIndex) Search(query searchQuery) []ClusterStatus {
+	si.mutex.RLock()
+	defer si.mutex.RUnlock()
+
+	var candidates map[string]bool
+	for _, word := range query.words {
+		// A word matches the clusters holding a token it prefixes
+		matches := make(map[string]bool)
+		for token, names := range si.postings {
+			if !strings.HasPrefix(token, word) {
+				continue
+			}
+			for name := range names {
+				if candidates == nil || candidates[name] {
+					matches[name] = true
+				}
+			}
+		}
+		candidates = matches
+	}
+
+	results := []ClusterStatus{}
+	for name, doc := range si.docs {
+		if candidates != nil && !candidates[name] {
+			continue
+		}
+		if query.matchesFields(doc) {
+			results = append(results, doc.cluster)
+		}
+	}
+	sortClusters(results)
+	return results
+}
+
+// searchTerm is a field:value term. A value ending in * matches by prefix,
+// and a comma-separated list matches any of its values.
+type searchTerm struct {
+	field  string
+	values []string
+}
+
+// searchQuery is a parsed ?q: fielded terms and free-text words, all of
+// which a cluster must match
+type searchQuery struct {
+	terms []searchTerm
+	words []string
+}
+
+// parseSearchQuery splits q into terms on whitespace outside double quotes,
+// as in region:"us east". A term starting with a quote is free text even if
+// it holds a colon.
+func parseSearchQuery(q string) (searchQuery, error) {
+	var query searchQuery
+	var raw []string
+	var quoted []bool
+	var current strings.Builder
+	inQuotes, wasQuoted := false, false
+	flush := func() {
+		if current.Len() > 0 {
+			raw = append(raw, current.String())
+			quoted = append(quoted, wasQuoted)
+		}
+		current.Reset()
+		wasQuoted = false
+	}
+	for _, r := range q {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			if current.Len() == 0 {
+				wasQuoted = true
+			}
+		case unicode.IsSpace(r) && !inQuotes:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inQuotes {
+		return query, fmt.Errorf("unterminated quote in search query")
+	}
+	flush()
+
+	for i, term := range raw {
+		field, value, fielded := strings.Cut(term, ":")
+		if !fielded || quoted[i] {
+			query.words = append(query.words, searchTokens(term)...)
+			continue
+		}
+		field = strings.ToLower(field)
+		if _, known := searchFields[field]; !known {
+			return query, fmt.Errorf("unknown search field %q, must be one of %s", field, strings.Join(searchFieldNames(), ", "))
+		}
+		var values []string
+		for _, v := range strings.Split(strings.ToLower(value), ",") {
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return query, fmt.Errorf("search field %s needs a value", field)
+		}
+		query.terms = append(query.terms, searchTerm{field: field, values: values})
+	}
+	if len(query.terms) == 0 && len(query.words) == 0 {
+		return query, fmt.Errorf("search query is empty")
+	}
+	return query, nil
+}
+
+func searchFieldNames() []string {
+	names := make([]string, 0, len(searchFields))
+	for name := range searchFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matchesFields reports whether a cluster matches every fielded term
+func (q searchQuery) matchesFields(doc *searchDoc) bool {
+	for _, term := range q.terms {
+		if !term.matches(doc.fields[term.field]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t searchTerm) matches(have []string) bool {
+	for _, want := range t.values {
+		prefix, isPrefix := strings.CutSuffix(want, "*")
+		for _, value := range have {
+			if value == want || isPrefix && strings.HasPrefix(value, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// indexedClusterStore keeps the search index in sync with the store it wraps
+type indexedClusterStore struct {
+	ClusterStore
+	index *searchIndex
+}
+
+// newIndexedClusterStore indexes the clusters already in store
+func newIndexedClusterStore(store ClusterStore, index *searchIndex) (*indexedClusterStore, error) {
+	clusters, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to index cluster store: %w", err)
+	}
+	for _, cluster := range clusters {
+		index.put(cluster)
+	}
+	return &indexedClusterStore{ClusterStore: store, index: index}, nil
+}
+
+func (s *indexedClusterStore) Put(status ClusterStatus) error {
+	if err := s.ClusterStore.Put(status); err != nil {
+		return err
+	}
+	s.index.put(status)
+	return nil
+}
+
+func (s *indexedClusterStore) Delete(name string) error {
+	if err := s.ClusterStore.Delete(name); err != nil {
+		return err
+	}
+	s.index.remove(name)
+	return nil
+}
+
+// reseal reseals the wrapped store when it keeps sealed state
+func (s *indexedClusterStore) reseal() (int, error) {
+	if r, ok := s.ClusterStore.(resealer); ok {
+		return r.reseal()
+	}
+	return 0, nil
+}
+
+// baseStore returns the store a cluster store decorates, if any
+func baseStore(store ClusterStore) ClusterStore {
+	if indexed, ok := store.(*indexedClusterStore); ok {
+		return indexed.ClusterStore
+	}
+	return store
+}
+
+// SearchClustersHandler searches the inventory with ?q, free text over
+// names, statuses, messages, labels and profiles mixed with field:value
+// terms such as status:failed provider:eks region:us-east-1. Results are
+// paged with ?limit and ?continue.
+func (cp *ClusterPlugin) SearchClustersHandler(c *gin.Context) {
+	query, err := parseSearchQuery(c.Query("q"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	page := clusterQuery{}
+	if raw := c.Query("limit"); raw != "" {
+		if page.limit, err = strconv.Atoi(raw); err != nil || page.limit < 0 {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid limit %q", raw))
+			return
+		}
+	}
+	if raw := c.Query("continue"); raw != "" {
+		if page.offset, err = decodeContinue(raw); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	results := tenantClusters(c.Request.Context(), cp.search.Search(query))
+	clusters, next := page.Page(results)
+	c.JSON(http.StatusOK, gin.H{
+		"clusters":  clusters,
+		"total":     len(results),
+		"continue":  next,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchClusters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Failed", Message: "klusterlet image pull backoff", Labels: map[string]string{"provider": "eks", "region": "us-east-1"}})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-2", Status: "Ready", Labels: map[string]string{"provider": "eks", "env": "prod"}, Profile: &ClusterProfile{DisplayName: "Checkout edge", Region: "us-east-1"}})
+	plugin.store.Put(ClusterStatus{ClusterName: "lab-gke", Status: "Ready", Labels: map[string]string{"cloud": "gke", "env": "dev"}})
+
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	search := func(q string) (int, []string, string) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/clusters/search?q="+url.QueryEscape(q), nil))
+		var response struct {
+			Clusters []ClusterStatus `json:"clusters"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		var names []string
+		for _, cluster := range response.Clusters {
+			names = append(names, cluster.ClusterName)
+		}
+		return recorder.Code, names, recorder.Body.String()
+	}
+
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{q: "edge", want: "edge-1,edge-2"},
+		{q: "edge-2", want: "edge-2"},
+		{q: "image pull", want: "edge-1"},
+		{q: "checkout", want: "edge-2"},
+		{q: "status:failed", want: "edge-1"},
+		{q: "provider:eks region:us-east-1", want: "edge-1,edge-2"},
+		{q: "provider:gke", want: "lab-gke"},
+		{q: "status:ready env", want: "edge-2,lab-gke"},
+		{q: "label:env=prod", want: "edge-2"},
+		{q: "status:failed,ready name:edge*", want: "edge-1,edge-2"},
+		{q: `"image: pull"`, want: "edge-1"},
+		{q: "region:eu-west-1", want: ""},
+	}
+	for _, tt := range tests {
+		code, names, body := search(tt.q)
+		if code != http.StatusOK || strings.Join(names, ",") != tt.want {
+			t.Errorf("search %q = %d %v, want %s: %s", tt.q, code, names, tt.want, body)
+		}
+	}
+
+	// The index follows the store
+	plugin.store.Delete("edge-1")
+	record, _, _ := plugin.store.Get("lab-gke")
+	record.Status = "Failed"
+	plugin.store.Put(record)
+	if _, names, _ := search("status:failed"); strings.Join(names, ",") != "lab-gke" {
+		t.Errorf("after updates, status:failed = %v", names)
+	}
+
+	for _, q := range []string{"", "color:blue", "status:", `"unterminated`} {
+		if code, _, _ := search(q); code != http.StatusBadRequest {
+			t.Errorf("search %q = %d, want 400", q, code)
+		}
+	}
+}
+
+func TestSearchTokens(t *testing.T) {
+	got := strings.Join(searchTokens("Edge-1 image/pull ok"), " ")
+	if want := "edge 1 image pull ok"; got != want {
+		t.Errorf("searchTokens() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Secret store backends
+const (
+	secretStoreFile       = "file"
+	secretStoreKubernetes = "kubernetes"
+	secretStoreVault      = "vault"
+)
+
+// secretValueKey is the key holding the secret in a Kubernetes Secret or a Vault entry
+const secretValueKey = "value"
+
+// SecretStore keeps the credentials the plugin needs again later, such as
+// the kubeconfigs of onboarded clusters
+type SecretStore interface {
+	// Get returns the named secret, reporting false when there is none
+	Get(ctx context.Context, name string) ([]byte, bool, error)
+	Put(ctx context.Context, name string, data []byte) error
+	// Delete removes the named secret; deleting a missing one is not an error
+	Delete(ctx context.Context, name string) error
+	// Describe names the backend and where it keeps the secrets
+	Describe() string
+}
+
+// SecretStoreConfig is the secretStore section of the Initialize config
+type SecretStoreConfig struct {
+	// Type is file, the default, kubernetes or vault
+	Type string `json:"type,omitempty"`
+	// Context and Namespace select where the kubernetes backend keeps its
+	// Secrets: a namespace of the cluster behind a context of the plugin's
+	// kubeconfig, the its1 hub by default
+	Context   string `json:"context,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Address is the Vault server; Mount and Path locate the KV version 2
+	// secrets engine and the path the secrets are kept under
+	Address string `json:"address,omitempty"`
+	Mount   string `json:"mount,omitempty"`
+	Path    string `json:"path,omitempty"`
+	// TokenEnv names the environment variable holding the Vault token,
+	// VAULT_TOKEN by default
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	// VaultNamespace is the Vault Enterprise namespace, if any
+	VaultNamespace string `json:"vaultNamespace,omitempty"`
+}
+
+// Validate checks the backend type and the settings it needs
+func (sc SecretStoreConfig) Validate() error {
+	switch sc.Type {
+	case secretStoreFile, secretStoreKubernetes:
+	case secretStoreVault:
+		parsed, err := url.Parse(sc.Address)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("address must be an absolute http or https URL")
+		}
+		if os.Getenv(sc.TokenEnv) == "" {
+			return fmt.Errorf("environment variable %s holds no Vault token", sc.TokenEnv)
+		}
+	default:
+		return fmt.Errorf("unknown type %q, must be %s, %s or %s", sc.Type, secretStoreFile, secretStoreKubernetes, secretStoreVault)
+	}
+	return nil
+}
+
+func secretStoreConfigFromConfig(config map[string]interface{}) (SecretStoreConfig, error) {
+	sc := SecretStoreConfig{}
+	if raw, ok := config["secretStore"]; ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return sc, fmt.Errorf("invalid secretStore config: %w", err)
+		}
+		if err := json.Unmarshal(data, &sc); err != nil {
+			return sc, fmt.Errorf("invalid secretStore config: %w", err)
+		}
+	}
+	defaults := map[*string]string{
+		&sc.Type:      secretStoreFile,
+		&sc.Context:   builtinHub.Context,
+		&sc.Namespace: "kubestellar-cluster-plugin",
+		&sc.Mount:     "secret",
+		&sc.Path:      "kubestellar-cluster-plugin",
+		&sc.TokenEnv:  "VAULT_TOKEN",
+	}
+	for field, fallback := range defaults {
+		if *field == "" {
+			*field = fallback
+		}
+	}
+	if err := sc.Validate(); err != nil {
+		return sc, fmt.Errorf("invalid secretStore config: %w", err)
+	}
+	return sc, nil
+}
+
+// newSecretStore opens the configured backend. The file backend seals the
+// secrets with box in dir.
+func newSecretStore(config SecretStoreConfig, dir string, box *secretBox) (SecretStore, error) {
+	switch config.Type {
+	case secretStoreKubernetes:
+		client, _, err := GetClientSetWithConfigContext(config.Context)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to the secret store cluster: %w", err)
+		}
+		return &kubernetesSecretStore{client: client, namespace: config.Namespace}, nil
+	case secretStoreVault:
+		return &vaultSecretStore{
+			address:   strings.TrimSuffix(config.Address, "/"),
+			mount:     strings.Trim(config.Mount, "/"),
+			path:      strings.Trim(config.Path, "/"),
+			token:     os.Getenv(config.TokenEnv),
+			namespace: config.VaultNamespace,
+			client:    &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	default:
+		return &fileSecretStore{dir: dir, box: box}, nil
+	}
+}
+
+// fileSecretStore seals each secret into a file of dir
+type fileSecretStore struct {
+	dir string
+	box *secretBox
+}
+
+// Get opens the sealed file of a secret. A plaintext file left by versions
+// before encryption at rest is sealed in its place.
+func (fs *fileSecretStore) Get(ctx context.Context, name string) ([]byte, bool, error) {
+	sealed, err := os.ReadFile(fs.path(name))
+	if err == nil {
+		data, err := fs.box.Open(sealed)
+		if err != nil {
+			return nil, false, err
+		}
+		return data, true, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	legacyPath := filepath.Join(fs.dir, name)
+	data, err := os.ReadFile(legacyPath)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := fs.Put(ctx, name, data); err != nil {
+		logger().Warn("Failed to seal plaintext secret", "secret", name, "error", err)
+	} else if err := os.Remove(legacyPath); err != nil {
+		logger().Warn("Failed to remove plaintext secret", "secret", name, "error", err)
+	}
+	return data, true, nil
+}
+
+func (fs *fileSecretStore) Put(_ context.Context, name string, data []byte) error {
+	sealed, err := fs.box.Seal(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fs.path(name), sealed, 0600)
+}
+
+func (fs *fileSecretStore) Delete(_ context.Context, name string) error {
+	for _, path := range []string{fs.path(name), filepath.Join(fs.dir, name)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *fileSecretStore) Describe() string {
+	return "encrypted files in " + fs.dir
+}
+
+// reseal moves the secrets under the current encryption key
+func (fs *fileSecretStore) reseal() (int, error) {
+	return resealFiles(fs.box, filepath.Join(fs.dir, "*.enc"))
+}
+
+func (fs *fileSecretStore) path(name string) string {
+	return filepath.Join(fs.dir, name+".enc")
+}
+
+// kubernetesSecretStore keeps each secret in a Kubernetes Secret of namespace
+type kubernetesSecretStore struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+func (ks *kubernetesSecretStore) Get(ctx context.Context, name string) ([]byte, bool, error) {
+	secret, err := ks.client.CoreV1().Secrets(ks.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read secret %s/%s: %w", ks.namespace, name, err)
+	}
+	return secret.Data[secretValueKey], true, nil
+}
+
+func (ks *kubernetesSecretStore) Put(ctx context.Context, name string, data []byte) error {
+	secrets := ks.client.CoreV1().Secrets(ks.namespace)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ks.namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "kubestellar-cluster-plugin"},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{secretValueKey: data},
+	}
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		existing, getErr := secrets.Get(ctx, name, metav1.GetOptions{})
+		if getErr != nil {
+			return fmt.Errorf("failed to read secret %s/%s: %w", ks.namespace, name, getErr)
+		}
+		existing.Data = secret.Data
+		_, err = secrets.Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write secret %s/%s: %w", ks.namespace, name, err)
+	}
+	return nil
+}
+
+func (ks *kubernetesSecretStore) Delete(ctx context.Context, name string) error {
+	err := ks.client.CoreV1().Secrets(ks.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s/%s: %w", ks.namespace, name, err)
+	}
+	return nil
+}
+
+func (ks *kubernetesSecretStore) Describe() string {
+	return "Kubernetes Secrets in namespace " + ks.namespace
+}
+
+// vaultSecretStore keeps each secret in a HashiCorp Vault KV version 2
+// secrets engine, base64 encoded under the value key
+type vaultSecretStore struct {
+	address   string
+	mount     string
+	path      string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+func (vs *vaultSecretStore) Get(ctx context.Context, name string) ([]byte, bool, error) {
+	var response struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	found, err := vs.do(ctx, http.MethodGet, "data", name, nil, &response)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	data, err := base64.StdEncoding.DecodeString(response.Data.Data[secretValueKey])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid Vault secret %s: %w", name, err)
+	}
+	return data, true, nil
+}
+
+func (vs *vaultSecretStore) Put(ctx context.Context, name string, data []byte) error {
+	body := map[string]interface{}{
+		"data": map[string]string{secretValueKey: base64.StdEncoding.EncodeToString(data)},
+	}
+	_, err := vs.do(ctx, http.MethodPost, "data", name, body, nil)
+	return err
+}
+
+// Delete removes every version of the secret along with its metadata
+func (vs *vaultSecretStore) Delete(ctx context.Context, name string) error {
+	_, err := vs.do(ctx, http.MethodDelete, "metadata", name, nil, nil)
+	return err
+}
+
+func (vs *vaultSecretStore) Describe() string {
+	return fmt.Sprintf("Vault at %s, %s/%s", vs.address, vs.mount, vs.path)
+}
+
+// do calls the KV version 2 API and decodes the response into out. It
+// reports false when Vault has no such secret.
+func (vs *vaultSecretStore) do(ctx context.Context, method, kind, name string, body, out interface{}) (bool, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := fmt.Sprintf("%s/v1/%s/%s/%s/%s", vs.address, vs.mount, kind, vs.path, url.PathEscape(name))
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-Vault-Token", vs.token)
+	if vs.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", vs.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := vs.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to reach Vault: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode >= 300:
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, fmt.Errorf("vault answered %s %s with %s: %s", method, kind, resp.Status, strings.TrimSpace(string(message)))
+	case out != nil:
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return false, fmt.Errorf("invalid Vault response: %w", err)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newFakeVault serves the KV version 2 data and metadata endpoints of the
+// secret mount, requiring token
+func newFakeVault(t *testing.T, token string) *httptest.Server {
+	var mu sync.Mutex
+	entries := map[string]json.RawMessage{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+			key := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+			switch r.Method {
+			case http.MethodGet:
+				entry, ok := entries[key]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": json.RawMessage(entry)})
+			case http.MethodPost:
+				var body json.RawMessage
+				json.NewDecoder(r.Body).Decode(&body)
+				entries[key] = body
+				w.Write([]byte(`{"data":{"version":1}}`))
+			}
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/") && r.Method == http.MethodDelete:
+			delete(entries, strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSecretStore(t *testing.T) {
+	box := newTestSecretBox(t)
+	vault := newFakeVault(t, "vault-token")
+	stores := map[string]SecretStore{
+		"file":       &fileSecretStore{dir: t.TempDir(), box: box},
+		"kubernetes": &kubernetesSecretStore{client: fake.NewSimpleClientset(), namespace: "kubestellar-cluster-plugin"},
+		"vault": &vaultSecretStore{
+			address: vault.URL, mount: "secret", path: "kubestellar-cluster-plugin",
+			token: "vault-token", client: vault.Client(),
+		},
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if _, found, err := store.Get(ctx, "edge-1-kubeconfig"); err != nil || found {
+				t.Fatalf("Get() on an empty store = %v, %v, want not found", found, err)
+			}
+			for _, data := range []string{"first", testHubKubeconfig} {
+				if err := store.Put(ctx, "edge-1-kubeconfig", []byte(data)); err != nil {
+					t.Fatalf("Put() error = %v", err)
+				}
+			}
+			if got, found, err := store.Get(ctx, "edge-1-kubeconfig"); err != nil || !found || string(got) != testHubKubeconfig {
+				t.Errorf("Get() = %q, %v, %v, want the last kubeconfig put", got, found, err)
+			}
+
+			if err := store.Delete(ctx, "edge-1-kubeconfig"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, found, _ := store.Get(ctx, "edge-1-kubeconfig"); found {
+				t.Error("Get() found a deleted secret")
+			}
+			if err := store.Delete(ctx, "edge-1-kubeconfig"); err != nil {
+				t.Errorf("Delete() of a missing secret error = %v", err)
+			}
+		})
+	}
+}
+
+func TestFileSecretStoreSealsPlaintext(t *testing.T) {
+	dir := t.TempDir()
+	store := &fileSecretStore{dir: dir, box: newTestSecretBox(t)}
+	legacy := filepath.Join(dir, "edge-2-kubeconfig")
+	os.WriteFile(legacy, []byte(testHubKubeconfig), 0600)
+
+	if got, found, err := store.Get(context.Background(), "edge-2-kubeconfig"); err != nil || !found || string(got) != testHubKubeconfig {
+		t.Errorf("Get() of a plaintext file = %q, %v, %v", got, found, err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Error("plaintext kubeconfig was kept after sealing it")
+	}
+	sealed, _ := os.ReadFile(filepath.Join(dir, "edge-2-kubeconfig.enc"))
+	if len(sealed) == 0 || bytes.Contains(sealed, []byte("token: secret")) {
+		t.Error("plaintext kubeconfig wasn't sealed")
+	}
+}
+
+func TestVaultSecretStoreRejected(t *testing.T) {
+	vault := newFakeVault(t, "vault-token")
+	store := &vaultSecretStore{address: vault.URL, mount: "secret", path: "plugin", token: "stale", client: vault.Client()}
+	if err := store.Put(context.Background(), "edge-1-kubeconfig", []byte("data")); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put() with a stale token error = %v, want the 403 from Vault", err)
+	}
+}
+
+func TestSecretStoreConfigFromConfig(t *testing.T) {
+	t.Setenv("TEST_VAULT_TOKEN", "vault-token")
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "default", config: map[string]interface{}{}, want: secretStoreFile},
+		{name: "kubernetes", config: map[string]interface{}{"secretStore": map[string]interface{}{"type": "kubernetes"}}, want: secretStoreKubernetes},
+		{name: "vault", config: map[string]interface{}{"secretStore": map[string]interface{}{
+			"type": "vault", "address": "https://vault.example.com:8200", "tokenEnv": "TEST_VAULT_TOKEN",
+		}}, want: secretStoreVault},
+		{name: "unknown type", config: map[string]interface{}{"secretStore": map[string]interface{}{"type": "etcd"}}, wantErr: true},
+		{name: "vault without address", config: map[string]interface{}{"secretStore": map[string]interface{}{
+			"type": "vault", "tokenEnv": "TEST_VAULT_TOKEN",
+		}}, wantErr: true},
+		{name: "vault without token", config: map[string]interface{}{"secretStore": map[string]interface{}{
+			"type": "vault", "address": "https://vault.example.com:8200", "tokenEnv": "TEST_MISSING_VAULT_TOKEN",
+		}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := secretStoreConfigFromConfig(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("secretStoreConfigFromConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Type != tt.want {
+				t.Errorf("Type = %q, want %q", got.Type, tt.want)
+			}
+		})
+	}
+}
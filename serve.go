@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/yaml"
+)
+
+// main runs the plugin as a standalone HTTP server when started with --serve,
+// so handlers can be exercised without loading the .so into the backend, or
+// as an out-of-process gRPC plugin when launched by a host with the magic
+// cookie set
+func main() {
+	serve := flag.Bool("serve", false, "run the plugin as a standalone HTTP server")
+	addr := flag.String("addr", ":8080", "listen address for --serve")
+	configPath := flag.String("config", "", "YAML or JSON file holding the Initialize config")
+	prefix := flag.String("prefix", "", "path the metadata endpoints are mounted under (default /api/plugins/<id>)")
+	grpcAddr := flag.String("grpc-addr", "127.0.0.1:0", "listen address of the gRPC transport")
+	flag.Parse()
+
+	if os.Getenv(grpcMagicCookieKey) == grpcMagicCookieValue {
+		// stdout carries the handshake, so keep gin's route dump off it
+		gin.DefaultWriter = os.Stderr
+		if err := runGRPCServer(*grpcAddr); err != nil {
+			logger().Error("gRPC server failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if !*serve {
+		fmt.Println("kubestellar-cluster-plugin is meant to be built with -buildmode=plugin; use --serve to run it standalone")
+		return
+	}
+	if err := runServer(*addr, *configPath, *prefix); err != nil {
+		logger().Error("Standalone server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// runServer initializes the plugin, serves it until SIGINT or SIGTERM and
+// cleans it up
+func runServer(addr, configPath, prefix string) error {
+	config := map[string]interface{}{}
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("failed to parse config: %w", err)
+		}
+	}
+
+	cp := &ClusterPlugin{}
+	if err := cp.Initialize(config); err != nil {
+		return fmt.Errorf("failed to initialize plugin: %w", err)
+	}
+	defer func() {
+		if err := cp.Cleanup(); err != nil {
+			logger().Error("Plugin cleanup failed", "error", err)
+		}
+	}()
+
+	router, err := newServerRouter(cp, prefix)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Addr: addr, Handler: router, ReadHeaderTimeout: 10 * time.Second}
+	errCh := make(chan error, 1)
+	go func() {
+		logger().Info("Serving plugin", "addr", addr, "prefix", prefix)
+		errCh <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	case sig := <-signals:
+		logger().Info("Shutting down standalone server", "signal", sig.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// newServerRouter mounts the plugin handlers the way the backend does, under
+// prefix, plus /healthz and /metrics at the root
+func newServerRouter(cp *ClusterPlugin, prefix string) (*gin.Engine, error) {
+	metadata := cp.GetMetadata()
+	if prefix == "" {
+		prefix = "/api/plugins/" + metadata.ID
+	}
+
+	metrics := newServerMetrics()
+	router := gin.New()
+	router.Use(gin.Recovery(), metrics.middleware())
+
+	routes := router.Group(prefix)
+	// Browsers preflight cross-origin calls, so answer OPTIONS on every
+	// path. The preflights are mounted first to stay outside authentication.
+	preflight := cp.withResponseHeaders(cp.corsPreflight)
+	mounted := map[string]bool{}
+	for _, endpoint := range metadata.Endpoints {
+		if endpoint.Method == http.MethodOptions {
+			mounted[endpoint.Path] = true
+		}
+	}
+	if err := mountPlugin(routes, cp); err != nil {
+		return nil, err
+	}
+	for _, endpoint := range metadata.Endpoints {
+		if !mounted[endpoint.Path] {
+			routes.OPTIONS(endpoint.Path, preflight)
+			mounted[endpoint.Path] = true
+		}
+	}
+
+	router.GET("/healthz", func(c *gin.Context) {
+		if err := cp.Health(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"status": "unhealthy",
+				"error":  err.Error(),
+				"plugin": "kubestellar-cluster-plugin",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "plugin": "kubestellar-cluster-plugin"})
+	})
+	router.GET("/metrics", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", metrics.render(cp))
+	})
+	return router, nil
+}
+
+// mountPlugin registers the plugin endpoints on routes, wrapped in the
+// plugin middlewares when the handlers come without them
+func mountPlugin(routes gin.IRoutes, cp *ClusterPlugin) error {
+	cp.mutex.RLock()
+	hostMiddlewares := cp.hostMiddlewares
+	cp.mutex.RUnlock()
+	if hostMiddlewares {
+		for _, middleware := range cp.GetMiddlewares() {
+			routes = routes.Use(middleware.Handler)
+		}
+	}
+	return mountEndpoints(routes, cp.GetMetadata(), cp.GetHandlers())
+}
+
+// mountEndpoints registers the handler of every metadata endpoint on routes
+func mountEndpoints(routes gin.IRoutes, metadata PluginMetadata, handlers map[string]gin.HandlerFunc) error {
+	for _, endpoint := range metadata.Endpoints {
+		handler, ok := handlers[endpoint.Handler]
+		if !ok {
+			return fmt.Errorf("endpoint %s %s: unknown handler %q", endpoint.Method, endpoint.Path, endpoint.Handler)
+		}
+		routes.Handle(endpoint.Method, endpoint.Path, handler)
+	}
+	return nil
+}
+
+// requestKey identifies a request series in the standalone server metrics
+type requestKey struct {
+	method string
+	route  string
+	status int
+}
+
+// serverMetrics counts the requests served in standalone mode and renders
+// them, with the plugin state, in the Prometheus text format
+type serverMetrics struct {
+	mutex     sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[requestKey]float64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[requestKey]float64),
+	}
+}
+
+func (sm *serverMetrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		key := requestKey{method: c.Request.Method, route: route, status: c.Writer.Status()}
+		sm.mutex.Lock()
+		sm.requests[key]++
+		sm.durations[key] += time.Since(start).Seconds()
+		sm.mutex.Unlock()
+	}
+}
+
+func (sm *serverMetrics) render(cp *ClusterPlugin) []byte {
+	var out []byte
+	appendf := func(format string, args ...interface{}) {
+		out = append(out, fmt.Sprintf(format, args...)...)
+	}
+
+	up := 1
+	if cp.Health() != nil {
+		up = 0
+	}
+	appendf("# HELP kubestellar_plugin_up Whether the plugin health check passes.\n")
+	appendf("# TYPE kubestellar_plugin_up gauge\n")
+	appendf("kubestellar_plugin_up %d\n", up)
+
+	sm.mutex.Lock()
+	keys := make([]requestKey, 0, len(sm.requests))
+	for key := range sm.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	appendf("# HELP kubestellar_plugin_http_requests_total HTTP requests served.\n")
+	appendf("# TYPE kubestellar_plugin_http_requests_total counter\n")
+	for _, key := range keys {
+		appendf("kubestellar_plugin_http_requests_total{method=%q,route=%q,status=\"%d\"} %d\n",
+			key.method, key.route, key.status, sm.requests[key])
+	}
+	appendf("# HELP kubestellar_plugin_http_request_duration_seconds_sum Total time spent serving HTTP requests.\n")
+	appendf("# TYPE kubestellar_plugin_http_request_duration_seconds_sum counter\n")
+	for _, key := range keys {
+		appendf("kubestellar_plugin_http_request_duration_seconds_sum{method=%q,route=%q,status=\"%d\"} %s\n",
+			key.method, key.route, key.status, strconv.FormatFloat(sm.durations[key], 'f', -1, 64))
+	}
+	sm.mutex.Unlock()
+
+	cp.mutex.RLock()
+	store, jobs := cp.store, cp.jobs
+	cp.mutex.RUnlock()
+
+	if store != nil {
+		if statuses, err := store.List(); err == nil {
+			appendf("# HELP kubestellar_plugin_clusters Clusters known to the plugin by status.\n")
+			appendf("# TYPE kubestellar_plugin_clusters gauge\n")
+			writeCounts(appendf, "kubestellar_plugin_clusters", "status", statuses, func(s ClusterStatus) string { return s.Status })
+		}
+	}
+	if jobs != nil {
+		appendf("# HELP kubestellar_plugin_jobs Jobs retained by the plugin by state.\n")
+		appendf("# TYPE kubestellar_plugin_jobs gauge\n")
+		writeCounts(appendf, "kubestellar_plugin_jobs", "state", jobs.List(), func(j Job) string { return string(j.State) })
+
+		pool := jobs.PoolStats()
+		appendf("# HELP kubestellar_plugin_job_workers Maximum number of jobs running at once, 0 if unlimited.\n")
+		appendf("# TYPE kubestellar_plugin_job_workers gauge\n")
+		appendf("kubestellar_plugin_job_workers %d\n", pool.MaxConcurrency)
+		appendf("# HELP kubestellar_plugin_job_queue_capacity Maximum number of jobs waiting for a worker.\n")
+		appendf("# TYPE kubestellar_plugin_job_queue_capacity gauge\n")
+		appendf("kubestellar_plugin_job_queue_capacity %d\n", pool.QueueSize)
+		appendf("# HELP kubestellar_plugin_jobs_running Jobs holding a worker.\n")
+		appendf("# TYPE kubestellar_plugin_jobs_running gauge\n")
+		appendf("kubestellar_plugin_jobs_running %d\n", pool.Running)
+		appendf("# HELP kubestellar_plugin_jobs_queued Jobs waiting for a worker.\n")
+		appendf("# TYPE kubestellar_plugin_jobs_queued gauge\n")
+		appendf("kubestellar_plugin_jobs_queued %d\n", pool.Queued)
+		appendf("# HELP kubestellar_plugin_jobs_completed_total Jobs that released their worker.\n")
+		appendf("# TYPE kubestellar_plugin_jobs_completed_total counter\n")
+		appendf("kubestellar_plugin_jobs_completed_total %d\n", pool.Completed)
+		appendf("# HELP kubestellar_plugin_jobs_rejected_total Jobs refused because the queue was full.\n")
+		appendf("# TYPE kubestellar_plugin_jobs_rejected_total counter\n")
+		appendf("kubestellar_plugin_jobs_rejected_total %d\n", pool.Rejected)
+		appendf("# HELP kubestellar_plugin_job_queue_wait_seconds_avg Average time jobs waited for a worker.\n")
+		appendf("# TYPE kubestellar_plugin_job_queue_wait_seconds_avg gauge\n")
+		appendf("kubestellar_plugin_job_queue_wait_seconds_avg %s\n", strconv.FormatFloat(pool.AverageWaitSeconds, 'f', -1, 64))
+	}
+	return out
+}
+
+// writeCounts emits one sample per distinct label value of items
+func writeCounts[T any](appendf func(string, ...interface{}), name, label string, items []T, value func(T) string) {
+	counts := make(map[string]int)
+	for _, item := range items {
+		counts[value(item)]++
+	}
+	values := make([]string, 0, len(counts))
+	for v := range counts {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		appendf("%s{%s=%q} %d\n", name, label, v, counts[v])
+	}
+}
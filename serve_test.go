@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerRouter(t *testing.T) {
+	router, err := newServerRouter(&ClusterPlugin{}, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "healthz before initialize", path: "/healthz", wantStatus: http.StatusServiceUnavailable, wantBody: "plugin not initialized"},
+		{name: "openapi mounted under prefix", path: "/api/plugins/kubestellar-cluster-plugin/openapi.json", wantStatus: http.StatusOK, wantBody: `"openapi"`},
+		{name: "metrics", path: "/metrics", wantStatus: http.StatusOK, wantBody: `kubestellar_plugin_http_requests_total{method="GET",route="/api/plugins/kubestellar-cluster-plugin/openapi.json",status="200"} 1`},
+		{name: "unknown route", path: "/status", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if !strings.Contains(recorder.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", recorder.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
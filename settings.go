@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditChangesKey holds the settings a request changed, for its audit entry
+const auditChangesKey = "auditChanges"
+
+// Settings are the parts of the Initialize config that can change while the
+// plugin runs, through PUT /settings
+type Settings struct {
+	// LogLevel is debug, info, warn or error, empty when the host injected
+	// the logger
+	LogLevel string `json:"logLevel"`
+	// MaxConcurrentJobs and JobQueueSize bound the job worker pool; a
+	// concurrency of 0 runs every job right away
+	MaxConcurrentJobs     int `json:"maxConcurrentJobs"`
+	JobQueueSize          int `json:"jobQueueSize"`
+	StatusCacheTTLSeconds int `json:"statusCacheTTLSeconds"`
+	// MockStepDelayMillis is how long each faked step of mock mode takes
+	MockStepDelayMillis int `json:"mockStepDelayMillis"`
+	// Mode is live or mock. It decides which background workers run, so it
+	// only changes when the plugin is initialized again.
+	Mode string `json:"mode"`
+}
+
+// SettingsRequest is the JSON body accepted by PUT /settings. Fields left
+// out are unchanged.
+type SettingsRequest struct {
+	LogLevel              *string `json:"logLevel,omitempty"`
+	MaxConcurrentJobs     *int    `json:"maxConcurrentJobs,omitempty"`
+	JobQueueSize          *int    `json:"jobQueueSize,omitempty"`
+	StatusCacheTTLSeconds *int    `json:"statusCacheTTLSeconds,omitempty"`
+	MockStepDelayMillis   *int    `json:"mockStepDelayMillis,omitempty"`
+	// Mode is refused unless it is the current mode
+	Mode *string `json:"mode,omitempty"`
+}
+
+// Validate checks the values of the settings given
+func (r SettingsRequest) Validate() error {
+	if r.LogLevel == nil && r.MaxConcurrentJobs == nil && r.JobQueueSize == nil && r.StatusCacheTTLSeconds == nil && r.MockStepDelayMillis == nil && r.Mode == nil {
+		return fmt.Errorf("at least one setting is required")
+	}
+	if r.LogLevel != nil {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(*r.LogLevel)); err != nil {
+			return fmt.Errorf("invalid logLevel %q, must be debug, info, warn or error", *r.LogLevel)
+		}
+	}
+	for field, value := range map[string]*int{
+		"maxConcurrentJobs":     r.MaxConcurrentJobs,
+		"jobQueueSize":          r.JobQueueSize,
+		"statusCacheTTLSeconds": r.StatusCacheTTLSeconds,
+		"mockStepDelayMillis":   r.MockStepDelayMillis,
+	} {
+		if value != nil && *value < 0 {
+			return fmt.Errorf("%s must not be negative", field)
+		}
+	}
+	return nil
+}
+
+// SettingChange is a setting changed by PUT /settings
+type SettingChange struct {
+	Setting string `json:"setting"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// currentSettings reads the settings in effect. The caller holds cp.mutex.
+func (cp *ClusterPlugin) currentSettings() Settings {
+	pool := cp.jobs.PoolStats()
+	logLevel := ""
+	if !cp.hostLogger {
+		logLevel = strings.ToLower(pluginLogLevel.Level().String())
+	}
+	return Settings{
+		LogLevel:              logLevel,
+		MaxConcurrentJobs:     pool.MaxConcurrency,
+		JobQueueSize:          pool.QueueSize,
+		StatusCacheTTLSeconds: int(cp.statusCache.TTL() / time.Second),
+		MockStepDelayMillis:   int(cp.mock.StepDelay / time.Millisecond),
+		Mode:                  cp.mode,
+	}
+}
+
+// applySettings puts the settings of a validated request in effect and
+// returns those that changed. The caller holds cp.mutex.
+func (cp *ClusterPlugin) applySettings(req SettingsRequest) []SettingChange {
+	current := cp.currentSettings()
+	changes := []SettingChange{}
+	changed := func(setting string, from, to interface{}) {
+		if from != to {
+			changes = append(changes, SettingChange{Setting: setting, From: fmt.Sprint(from), To: fmt.Sprint(to)})
+		}
+	}
+
+	if req.LogLevel != nil {
+		var level slog.Level
+		level.UnmarshalText([]byte(*req.LogLevel))
+		pluginLogLevel.Set(level)
+		changed("logLevel", current.LogLevel, strings.ToLower(level.String()))
+	}
+	if req.MaxConcurrentJobs != nil || req.JobQueueSize != nil {
+		concurrency, queueSize := current.MaxConcurrentJobs, current.JobQueueSize
+		if req.MaxConcurrentJobs != nil {
+			concurrency = *req.MaxConcurrentJobs
+		}
+		if req.JobQueueSize != nil {
+			queueSize = *req.JobQueueSize
+		}
+		cp.jobs.ResizePool(concurrency, queueSize)
+		changed("maxConcurrentJobs", current.MaxConcurrentJobs, concurrency)
+		changed("jobQueueSize", current.JobQueueSize, queueSize)
+	}
+	if req.StatusCacheTTLSeconds != nil {
+		cp.statusCache.setTTL(time.Duration(*req.StatusCacheTTLSeconds) * time.Second)
+		changed("statusCacheTTLSeconds", current.StatusCacheTTLSeconds, *req.StatusCacheTTLSeconds)
+	}
+	if req.MockStepDelayMillis != nil {
+		cp.mock.StepDelay = time.Duration(*req.MockStepDelayMillis) * time.Millisecond
+		changed("mockStepDelayMillis", current.MockStepDelayMillis, *req.MockStepDelayMillis)
+	}
+	return changes
+}
+
+// GetSettingsHandler returns the runtime settings and whether they are
+// locked
+func (cp *ClusterPlugin) GetSettingsHandler(c *gin.Context) {
+	cp.mutex.RLock()
+	settings := cp.currentSettings()
+	cp.mutex.RUnlock()
+	c.JSON(http.StatusOK, gin.H{
+		"settings":  settings,
+		"readOnly":  cp.settingsReadOnly,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// UpdateSettingsHandler changes runtime settings without reinitializing the
+// plugin. The changes are logged and recorded on the audit entry of the
+// request. The settingsReadOnly config refuses every change.
+func (cp *ClusterPlugin) UpdateSettingsHandler(c *gin.Context) {
+	if cp.settingsReadOnly {
+		respondProblem(c, http.StatusForbidden, CodeSettingsLocked, "Settings are read-only, set by the settingsReadOnly config")
+		return
+	}
+	var req SettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if req.LogLevel != nil && cp.hostLogger {
+		respondProblem(c, http.StatusConflict, CodeConflict, "The log level is set by the logger the host injected")
+		return
+	}
+
+	cp.mutex.Lock()
+	if req.Mode != nil && *req.Mode != cp.mode {
+		mode := cp.mode
+		cp.mutex.Unlock()
+		respondProblem(c, http.StatusConflict, CodeConflict, fmt.Sprintf("The plugin runs in %s mode, changing it takes initializing the plugin again", mode))
+		return
+	}
+	changes := cp.applySettings(req)
+	settings := cp.currentSettings()
+	cp.mutex.Unlock()
+
+	c.Set(auditChangesKey, changes)
+	for _, change := range changes {
+		logger().Info("Setting changed", "setting", change.Setting, "from", change.From, "to", change.To)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"settings":  settings,
+		"changes":   changes,
+		"readOnly":  false,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUpdateSettings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0, "logLevel": "info"})
+	t.Cleanup(func() { pluginLogLevel.Set(slog.LevelInfo) })
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	put := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/settings", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := put(`{"logLevel": "debug", "maxConcurrentJobs": 3, "statusCacheTTLSeconds": 5, "mockStepDelayMillis": 20}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("PUT /settings = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		Settings Settings        `json:"settings"`
+		Changes  []SettingChange `json:"changes"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	want := Settings{LogLevel: "debug", MaxConcurrentJobs: 3, JobQueueSize: 100, StatusCacheTTLSeconds: 5, MockStepDelayMillis: 20, Mode: modeMock}
+	if response.Settings != want {
+		t.Errorf("settings = %+v, want %+v", response.Settings, want)
+	}
+	// The cache TTL was already 5 seconds
+	if len(response.Changes) != 3 || response.Changes[0] != (SettingChange{Setting: "logLevel", From: "info", To: "debug"}) {
+		t.Errorf("changes = %+v", response.Changes)
+	}
+	if !logger().Enabled(context.Background(), slog.LevelDebug) || plugin.jobs.PoolStats().MaxConcurrency != 3 || plugin.mockStepDelay() != 20*time.Millisecond {
+		t.Error("settings not in effect")
+	}
+
+	entries := plugin.audit.Query(AuditQuery{})
+	if len(entries) != 1 || entries[0].Handler != "UpdateSettingsHandler" || len(entries[0].Changes) != 3 {
+		t.Errorf("audit entries = %+v", entries)
+	}
+
+	for _, tt := range []struct {
+		name, body string
+		want       int
+	}{
+		{name: "empty", body: `{}`, want: http.StatusBadRequest},
+		{name: "invalid log level", body: `{"logLevel": "verbose"}`, want: http.StatusBadRequest},
+		{name: "negative concurrency", body: `{"maxConcurrentJobs": -1}`, want: http.StatusBadRequest},
+		{name: "mode change", body: `{"mode": "live"}`, want: http.StatusConflict},
+		{name: "same mode", body: `{"mode": "mock"}`, want: http.StatusOK},
+	} {
+		if recorder := put(tt.body); recorder.Code != tt.want {
+			t.Errorf("%s: PUT /settings = %d, want %d", tt.name, recorder.Code, tt.want)
+		}
+	}
+}
+
+func TestSettingsReadOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0, "settingsReadOnly": true})
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/settings", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"readOnly":true`) {
+		t.Fatalf("GET /settings = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPut, "/settings", strings.NewReader(`{"maxConcurrentJobs": 1}`))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), string(CodeSettingsLocked)) {
+		t.Errorf("PUT /settings = %d %s, want 403", recorder.Code, recorder.Body.String())
+	}
+	if plugin.jobs.PoolStats().MaxConcurrency == 1 {
+		t.Error("locked settings changed")
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// defaultBurnRateAlerts are the fast and slow burns of multiwindow burn rate
+// alerting: a 30 day budget spent in 2 days, or in 5 days
+var defaultBurnRateAlerts = []BurnRateAlert{
+	{WindowMinutes: 60, Threshold: 14.4},
+	{WindowMinutes: 360, Threshold: 6},
+}
+
+// BurnRateAlert fires when a cluster spends its error budget Threshold times
+// faster than the SLO allows over the last WindowMinutes
+type BurnRateAlert struct {
+	WindowMinutes int     `json:"windowMinutes"`
+	Threshold     float64 `json:"threshold"`
+}
+
+func (a BurnRateAlert) window() time.Duration {
+	return time.Duration(a.WindowMinutes) * time.Minute
+}
+
+// SLOConfig is one entry of the slos list of the Initialize config: the
+// share of a window a cluster, or each member of a group, must be Ready
+type SLOConfig struct {
+	Name string `json:"name"`
+	// Cluster or Group names what the SLO covers
+	Cluster string `json:"cluster,omitempty"`
+	Group   string `json:"group,omitempty"`
+	// TargetPercent is the availability objective, such as 99.9
+	TargetPercent float64 `json:"targetPercent"`
+	// WindowDays is the rolling window of the objective, 30 by default
+	WindowDays int `json:"windowDays,omitempty"`
+	// Alerts default to a 1 hour burn rate of 14.4 and a 6 hour one of 6
+	Alerts []BurnRateAlert `json:"alerts,omitempty"`
+}
+
+// Validate checks the SLO can be computed from history kept for retention
+func (s SLOConfig) Validate(retention time.Duration) error {
+	if errs := validation.IsDNS1123Label(s.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid SLO name '%s': %s", s.Name, strings.Join(errs, "; "))
+	}
+	if (s.Cluster == "") == (s.Group == "") {
+		return fmt.Errorf("SLO %s needs either a cluster or a group", s.Name)
+	}
+	if s.TargetPercent <= 0 || s.TargetPercent >= 100 {
+		return fmt.Errorf("SLO %s: targetPercent must be between 0 and 100", s.Name)
+	}
+	if s.WindowDays < 0 || time.Duration(s.WindowDays)*24*time.Hour > retention {
+		return fmt.Errorf("SLO %s: windowDays must be positive and within historyRetentionDays", s.Name)
+	}
+	for _, alert := range s.Alerts {
+		if alert.WindowMinutes <= 0 || alert.Threshold <= 0 {
+			return fmt.Errorf("SLO %s: alert windowMinutes and threshold must be positive", s.Name)
+		}
+	}
+	return nil
+}
+
+func (s SLOConfig) window() time.Duration {
+	return time.Duration(s.WindowDays) * 24 * time.Hour
+}
+
+// slosFromConfig reads the slos list, filling in the default window and
+// alerts
+func slosFromConfig(config map[string]interface{}, retention time.Duration) ([]SLOConfig, error) {
+	raw, ok := config["slos"]
+	if !ok {
+		return nil, nil
+	}
+	var slos []SLOConfig
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid slos config: %w", err)
+	}
+	if err := json.Unmarshal(data, &slos); err != nil {
+		return nil, fmt.Errorf("invalid slos config: %w", err)
+	}
+	seen := make(map[string]bool, len(slos))
+	for i := range slos {
+		if slos[i].WindowDays == 0 {
+			slos[i].WindowDays = 30
+		}
+		if len(slos[i].Alerts) == 0 {
+			slos[i].Alerts = defaultBurnRateAlerts
+		}
+		if err := slos[i].Validate(retention); err != nil {
+			return nil, fmt.Errorf("invalid slos config: slos[%d]: %w", i, err)
+		}
+		if seen[slos[i].Name] {
+			return nil, fmt.Errorf("invalid slos config: SLO %s is listed twice", slos[i].Name)
+		}
+		seen[slos[i].Name] = true
+	}
+	return slos, nil
+}
+
+// ErrorBudget is the downtime an SLO allows a cluster over its window and
+// how much of it was spent
+type ErrorBudget struct {
+	AllowedSeconds  int64 `json:"allowedSeconds"`
+	ConsumedSeconds int64 `json:"consumedSeconds"`
+	// RemainingPercent goes negative once the budget is exhausted
+	RemainingPercent float64 `json:"remainingPercent"`
+}
+
+// BurnRate is how many times faster than allowed a cluster spent its error
+// budget over the window of an alert
+type BurnRate struct {
+	WindowMinutes int     `json:"windowMinutes"`
+	Threshold     float64 `json:"threshold"`
+	Rate          float64 `json:"rate"`
+	Firing        bool    `json:"firing"`
+}
+
+// ClusterSLO is an SLO evaluated for one cluster
+type ClusterSLO struct {
+	ClusterName  string       `json:"clusterName"`
+	Availability Availability `json:"availability"`
+	ErrorBudget  ErrorBudget  `json:"errorBudget"`
+	BurnRates    []BurnRate   `json:"burnRates"`
+	// Met is set while the availability is at or above the target, or
+	// nothing was observed yet
+	Met bool `json:"met"`
+}
+
+// SLOStatus is an SLO evaluated for the clusters it covers
+type SLOStatus struct {
+	SLOConfig
+	Clusters []ClusterSLO `json:"clusters"`
+	// Breached counts the clusters below target, Firing those with a burn
+	// rate alert firing
+	Breached int    `json:"breached"`
+	Firing   int    `json:"firing"`
+	Error    string `json:"error,omitempty"`
+}
+
+// tally counts the breached and firing clusters
+func (s *SLOStatus) tally() {
+	s.Breached, s.Firing = 0, 0
+	for _, cluster := range s.Clusters {
+		if !cluster.Met {
+			s.Breached++
+		}
+		for _, burn := range cluster.BurnRates {
+			if burn.Firing {
+				s.Firing++
+				break
+			}
+		}
+	}
+}
+
+// downFraction is the share of the observed time a cluster wasn't Ready
+func downFraction(availability Availability) float64 {
+	if availability.ObservedSeconds == 0 {
+		return 0
+	}
+	return float64(availability.ObservedSeconds-availability.ReadySeconds) / float64(availability.ObservedSeconds)
+}
+
+// evaluateSLO measures a cluster against an SLO from its history, oldest
+// first
+func evaluateSLO(slo SLOConfig, clusterName string, entries []HistoryEntry, now time.Time) ClusterSLO {
+	allowedFraction := 1 - slo.TargetPercent/100
+	availability := computeAvailability(entries, now.Add(-slo.window()), now)
+	result := ClusterSLO{
+		ClusterName:  clusterName,
+		Availability: availability,
+		BurnRates:    make([]BurnRate, 0, len(slo.Alerts)),
+		Met:          availability.ObservedSeconds == 0 || downFraction(availability) <= allowedFraction,
+	}
+
+	allowed := float64(availability.ObservedSeconds) * allowedFraction
+	consumed := availability.ObservedSeconds - availability.ReadySeconds
+	result.ErrorBudget = ErrorBudget{AllowedSeconds: int64(allowed), ConsumedSeconds: consumed, RemainingPercent: 100}
+	if allowed > 0 {
+		result.ErrorBudget.RemainingPercent = math.Round((1-float64(consumed)/allowed)*10000) / 100
+	}
+
+	for _, alert := range slo.Alerts {
+		recent := computeAvailability(entries, now.Add(-alert.window()), now)
+		rate := math.Round(downFraction(recent)/allowedFraction*100) / 100
+		result.BurnRates = append(result.BurnRates, BurnRate{
+			WindowMinutes: alert.WindowMinutes,
+			Threshold:     alert.Threshold,
+			Rate:          rate,
+			Firing:        rate >= alert.Threshold,
+		})
+	}
+	return result
+}
+
+// evaluateSLOs measures the given clusters against every SLO covering them
+func (cp *ClusterPlugin) evaluateSLOs(clusters []ClusterStatus, now time.Time) ([]SLOStatus, error) {
+	history, err := cp.store.AllHistory()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]SLOStatus, 0, len(cp.slos))
+	for _, slo := range cp.slos {
+		status := SLOStatus{SLOConfig: slo, Clusters: []ClusterSLO{}}
+		var group ClusterGroup
+		if slo.Group != "" {
+			if group, err = cp.groups.Get(slo.Group); err != nil {
+				status.Error = fmt.Sprintf("group %s: %v", slo.Group, err)
+				statuses = append(statuses, status)
+				continue
+			}
+		}
+		for _, cluster := range clusters {
+			if cluster.ClusterName != slo.Cluster && (slo.Group == "" || !group.matches(cluster)) {
+				continue
+			}
+			status.Clusters = append(status.Clusters, evaluateSLO(slo, cluster.ClusterName, history[cluster.ClusterName], now))
+		}
+		status.tally()
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// sloAlerts remembers the burn rate alerts firing, by SLO, cluster and
+// window, so events go out when an alert starts or stops firing
+type sloAlerts struct {
+	mutex  sync.Mutex
+	firing map[string]bool
+}
+
+// update records the alerts now firing and returns those that changed
+func (a *sloAlerts) update(firing map[string]bool) (started, stopped []string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for key := range firing {
+		if !a.firing[key] {
+			started = append(started, key)
+		}
+	}
+	for key := range a.firing {
+		if !firing[key] {
+			stopped = append(stopped, key)
+		}
+	}
+	a.firing = firing
+	return started, stopped
+}
+
+// checkSLOs evaluates the SLOs and publishes an event when a burn rate
+// alert starts or stops firing
+func (cp *ClusterPlugin) checkSLOs(context.Context) {
+	cp.mutex.RLock()
+	clusters, err := cp.store.List()
+	var statuses []SLOStatus
+	if err == nil {
+		statuses, err = cp.evaluateSLOs(clusters, time.Now())
+	}
+	cp.mutex.RUnlock()
+	if err != nil {
+		logger().Warn("Failed to evaluate SLOs", "error", err)
+		return
+	}
+
+	type firingAlert struct {
+		slo     SLOStatus
+		cluster ClusterSLO
+		burn    BurnRate
+	}
+	firing := map[string]bool{}
+	alerts := map[string]firingAlert{}
+	for _, status := range statuses {
+		for _, cluster := range status.Clusters {
+			for _, burn := range cluster.BurnRates {
+				key := status.Name + "/" + cluster.ClusterName + "/" + strconv.Itoa(burn.WindowMinutes)
+				alerts[key] = firingAlert{slo: status, cluster: cluster, burn: burn}
+				if burn.Firing {
+					firing[key] = true
+				}
+			}
+		}
+	}
+
+	started, stopped := cp.sloAlerts.update(firing)
+	for _, key := range started {
+		alert := alerts[key]
+		message := fmt.Sprintf("SLO %s of cluster %s: error budget burning %.2fx over %d minutes, above %.2fx, %.2f%% left",
+			alert.slo.Name, alert.cluster.ClusterName, alert.burn.Rate, alert.burn.WindowMinutes, alert.burn.Threshold, alert.cluster.ErrorBudget.RemainingPercent)
+		logger().Warn("SLO burn rate alert firing", "slo", alert.slo.Name, "cluster", alert.cluster.ClusterName, "windowMinutes", alert.burn.WindowMinutes, "rate", alert.burn.Rate)
+		cp.publishSLOEvent(eventClusterSLOBurning, alert.slo.Name, alert.cluster, alert.burn, message)
+	}
+	for _, key := range stopped {
+		alert, ok := alerts[key]
+		if !ok {
+			// The cluster is gone or no longer covered
+			continue
+		}
+		message := fmt.Sprintf("SLO %s of cluster %s: error budget burn back to %.2fx over %d minutes, below %.2fx",
+			alert.slo.Name, alert.cluster.ClusterName, alert.burn.Rate, alert.burn.WindowMinutes, alert.burn.Threshold)
+		cp.publishSLOEvent(eventClusterSLORecovered, alert.slo.Name, alert.cluster, alert.burn, message)
+	}
+}
+
+func (cp *ClusterPlugin) publishSLOEvent(eventType, slo string, cluster ClusterSLO, burn BurnRate, message string) {
+	if cp.events == nil {
+		return
+	}
+	cp.events.Publish(Event{
+		Type:        eventType,
+		ClusterName: cluster.ClusterName,
+		Message:     message,
+		Attributes: map[string]string{
+			"slo":                    slo,
+			"windowMinutes":          strconv.Itoa(burn.WindowMinutes),
+			"burnRate":               strconv.FormatFloat(burn.Rate, 'f', 2, 64),
+			"threshold":              strconv.FormatFloat(burn.Threshold, 'f', 2, 64),
+			"budgetRemainingPercent": strconv.FormatFloat(cluster.ErrorBudget.RemainingPercent, 'f', 2, 64),
+		},
+	})
+}
+
+// GetSLOHandler returns the availability, error budget and burn rates of the
+// clusters covered by each SLO, optionally of one ?slo or ?cluster
+func (cp *ClusterPlugin) GetSLOHandler(c *gin.Context) {
+	name, clusterName := c.Query("slo"), c.Query("cluster")
+	cp.mutex.RLock()
+	clusters, err := cp.store.List()
+	var statuses []SLOStatus
+	if err == nil {
+		statuses, err = cp.evaluateSLOs(tenantClusters(c.Request.Context(), clusters), time.Now())
+	}
+	cp.mutex.RUnlock()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	slos := make([]SLOStatus, 0, len(statuses))
+	for _, status := range statuses {
+		if name != "" && status.Name != name {
+			continue
+		}
+		if clusterName != "" {
+			matched := []ClusterSLO{}
+			for _, cluster := range status.Clusters {
+				if cluster.ClusterName == clusterName {
+					matched = append(matched, cluster)
+				}
+			}
+			if len(matched) == 0 {
+				continue
+			}
+			status.Clusters = matched
+			status.tally()
+		}
+		slos = append(slos, status)
+	}
+	if name != "" && len(slos) == 0 && clusterName == "" {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("SLO '%s' not found", name))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"slos":      slos,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
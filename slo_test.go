@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSLOsFromConfig(t *testing.T) {
+	retention := 90 * 24 * time.Hour
+	slos, err := slosFromConfig(map[string]interface{}{
+		"slos": []interface{}{map[string]interface{}{"name": "edge", "cluster": "edge-1", "targetPercent": 99.9}},
+	}, retention)
+	if err != nil || len(slos) != 1 || slos[0].WindowDays != 30 || len(slos[0].Alerts) != 2 {
+		t.Fatalf("slosFromConfig() = %+v, %v", slos, err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		slo  map[string]interface{}
+		want string
+	}{
+		{name: "no scope", slo: map[string]interface{}{"name": "edge", "targetPercent": 99.9}, want: "cluster or a group"},
+		{name: "both scopes", slo: map[string]interface{}{"name": "edge", "cluster": "edge-1", "group": "prod", "targetPercent": 99.9}, want: "cluster or a group"},
+		{name: "target of 100", slo: map[string]interface{}{"name": "edge", "cluster": "edge-1", "targetPercent": 100}, want: "targetPercent"},
+		{name: "window past retention", slo: map[string]interface{}{"name": "edge", "cluster": "edge-1", "targetPercent": 99, "windowDays": 365}, want: "historyRetentionDays"},
+		{name: "invalid alert", slo: map[string]interface{}{"name": "edge", "cluster": "edge-1", "targetPercent": 99, "alerts": []interface{}{map[string]interface{}{"windowMinutes": 60}}}, want: "threshold"},
+		{name: "invalid name", slo: map[string]interface{}{"name": "Edge SLO", "cluster": "edge-1", "targetPercent": 99}, want: "invalid SLO name"},
+	} {
+		if _, err := slosFromConfig(map[string]interface{}{"slos": []interface{}{tt.slo}}, retention); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	duplicate := map[string]interface{}{"name": "edge", "cluster": "edge-1", "targetPercent": 99}
+	if _, err := slosFromConfig(map[string]interface{}{"slos": []interface{}{duplicate, duplicate}}, retention); err == nil {
+		t.Error("duplicate SLOs accepted")
+	}
+}
+
+func TestEvaluateSLO(t *testing.T) {
+	now := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+	slo := SLOConfig{Name: "edge", Cluster: "edge-1", TargetPercent: 99, WindowDays: 10, Alerts: defaultBurnRateAlerts}
+	entries := []HistoryEntry{
+		{Timestamp: now.AddDate(0, 0, -10).Format(time.RFC3339), Status: "Ready"},
+		// Half an hour down within the last hour
+		{Timestamp: now.Add(-30 * time.Minute).Format(time.RFC3339), Status: statusUnreachable},
+	}
+
+	result := evaluateSLO(slo, "edge-1", entries, now)
+	if !result.Met || result.ErrorBudget.AllowedSeconds != 8640 || result.ErrorBudget.ConsumedSeconds != 1800 {
+		t.Fatalf("evaluateSLO() = %+v", result)
+	}
+	if result.ErrorBudget.RemainingPercent != 79.17 {
+		t.Errorf("remaining budget = %v, want 79.17", result.ErrorBudget.RemainingPercent)
+	}
+	// 50% down over an hour burns a 1% budget 50 times too fast, 1/12 down
+	// over 6 hours 8.33 times
+	if burn := result.BurnRates[0]; burn.Rate != 50 || !burn.Firing {
+		t.Errorf("1h burn = %+v", burn)
+	}
+	if burn := result.BurnRates[1]; burn.Rate != 8.33 || !burn.Firing {
+		t.Errorf("6h burn = %+v", burn)
+	}
+
+	if unobserved := evaluateSLO(slo, "edge-2", nil, now); !unobserved.Met || unobserved.ErrorBudget.RemainingPercent != 100 || unobserved.BurnRates[0].Firing {
+		t.Errorf("evaluateSLO() without history = %+v", unobserved)
+	}
+}
+
+func TestCheckSLOs(t *testing.T) {
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode": modeMock, "mockFleetSize": 0,
+		"slos": []interface{}{map[string]interface{}{"name": "edge", "cluster": "edge-1", "targetPercent": 99.9}},
+	})
+	sink := &recordingSink{}
+	plugin.events.Subscribe("test", sink, []string{eventClusterSLOBurning, eventClusterSLORecovered})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: statusUnreachable})
+	plugin.store.AppendHistory(HistoryEntry{ClusterName: "edge-1", Timestamp: time.Now().Add(-2 * time.Hour).Format(time.RFC3339), Status: "Ready"})
+	plugin.store.AppendHistory(HistoryEntry{ClusterName: "edge-1", Timestamp: time.Now().Add(-10 * time.Minute).Format(time.RFC3339), Status: statusUnreachable})
+
+	plugin.checkSLOs(context.Background())
+	// Alerts already firing aren't announced again
+	plugin.checkSLOs(context.Background())
+	plugin.store.Delete("edge-1")
+	plugin.checkSLOs(context.Background())
+	plugin.events.Close(time.Second)
+
+	// Both the 1 hour and the 6 hour alert fire, and a cluster gone isn't
+	// announced as recovered
+	types := sink.types()
+	if len(types) != 2 || types[0] != eventClusterSLOBurning || types[1] != eventClusterSLOBurning {
+		t.Fatalf("events = %v", types)
+	}
+	if event := sink.events[0]; event.ClusterName != "edge-1" || event.Attributes["slo"] != "edge" || !event.Warning() {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestGetSLOHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"mode": modeMock, "mockFleetSize": 0,
+		"slos": []interface{}{
+			map[string]interface{}{"name": "edge", "cluster": "edge-1", "targetPercent": 99.9},
+			map[string]interface{}{"name": "prod", "group": "prod", "targetPercent": 99},
+		},
+	})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	plugin.store.AppendHistory(HistoryEntry{ClusterName: "edge-1", Timestamp: time.Now().Add(-time.Hour).Format(time.RFC3339), Status: "Ready"})
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slo"+query, nil))
+		return recorder
+	}
+
+	recorder := get("")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /slo = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		SLOs []SLOStatus `json:"slos"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if len(response.SLOs) != 2 || len(response.SLOs[0].Clusters) != 1 || !response.SLOs[0].Clusters[0].Met {
+		t.Fatalf("slos = %+v", response.SLOs)
+	}
+	// The group doesn't exist
+	if response.SLOs[1].Error == "" {
+		t.Errorf("prod SLO = %+v, want an error", response.SLOs[1])
+	}
+
+	if recorder := get("?cluster=edge-1"); !strings.Contains(recorder.Body.String(), `"name":"edge"`) || strings.Contains(recorder.Body.String(), `"name":"prod"`) {
+		t.Errorf("GET /slo?cluster=edge-1 = %s", recorder.Body.String())
+	}
+	if recorder := get("?slo=missing"); recorder.Code != http.StatusNotFound {
+		t.Errorf("GET /slo?slo=missing = %d, want 404", recorder.Code)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusSnapshot is the cluster inventory merged with the hub view, as
+// served by GET /status before filtering and paging
+type statusSnapshot struct {
+	clusters []ClusterStatus
+	hub      *HubSyncStatus
+	loadedAt time.Time
+}
+
+// statusCache keeps the last status snapshot for a TTL so that polling
+// clients don't rebuild it on every request. Changes the plugin makes to the
+// inventory drop it right away; the hub view may lag by up to the TTL.
+type statusCache struct {
+	ttl      time.Duration
+	mutex    sync.Mutex
+	snapshot *statusSnapshot
+	// generation is bumped by invalidate so that a snapshot loaded while the
+	// inventory changed isn't cached
+	generation uint64
+}
+
+func newStatusCache(ttl time.Duration) *statusCache {
+	return &statusCache{ttl: ttl}
+}
+
+// get returns the cached snapshot while it is fresh, loading a new one when
+// it expired or refresh is set
+func (sc *statusCache) get(refresh bool, load func() (statusSnapshot, error)) (statusSnapshot, error) {
+	sc.mutex.Lock()
+	if !refresh && sc.snapshot != nil && time.Since(sc.snapshot.loadedAt) < sc.ttl {
+		snapshot := *sc.snapshot
+		sc.mutex.Unlock()
+		return snapshot, nil
+	}
+	generation := sc.generation
+	sc.mutex.Unlock()
+
+	snapshot, err := load()
+	if err != nil {
+		return statusSnapshot{}, err
+	}
+	snapshot.loadedAt = time.Now()
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.ttl > 0 && sc.generation == generation {
+		sc.snapshot = &snapshot
+	}
+	return snapshot, nil
+}
+
+// setTTL changes how long snapshots are kept, dropping the cached one
+func (sc *statusCache) setTTL(ttl time.Duration) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.ttl = ttl
+	sc.snapshot = nil
+	sc.generation++
+}
+
+// TTL returns how long snapshots are kept
+func (sc *statusCache) TTL() time.Duration {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.ttl
+}
+
+// invalidate drops the cached snapshot after the inventory changed
+func (sc *statusCache) invalidate() {
+	if sc == nil {
+		return
+	}
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.snapshot = nil
+	sc.generation++
+}
+
+// loadStatusSnapshot reads the inventory and merges the hub view into it
+func (cp *ClusterPlugin) loadStatusSnapshot() (statusSnapshot, error) {
+	clusters, err := cp.store.List()
+	if err != nil {
+		return statusSnapshot{}, err
+	}
+	snapshot := statusSnapshot{clusters: clusters}
+	if cp.hub != nil {
+		status := cp.hub.Status()
+		snapshot.hub = &status
+		snapshot.clusters = cp.mergeHubState(clusters)
+	}
+	return snapshot, nil
+}
+
+// statusETag is a strong entity tag of a GET /status response, leaving out
+// its timestamp so that unchanged content keeps its tag
+func statusETag(response ClusterStatusResponse) string {
+	response.Timestamp = ""
+	data, _ := json.Marshal(response)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak tags
+// compare equal to their strong counterpart, as If-None-Match requires.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
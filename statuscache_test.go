@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStatusCache(t *testing.T) {
+	loads := 0
+	load := func() (statusSnapshot, error) {
+		loads++
+		return statusSnapshot{clusters: []ClusterStatus{{ClusterName: "edge-1"}}}, nil
+	}
+
+	cache := newStatusCache(time.Minute)
+	cache.get(false, load)
+	cache.get(false, load)
+	if loads != 1 {
+		t.Errorf("fresh snapshot loaded %d times, want 1", loads)
+	}
+	cache.get(true, load)
+	cache.invalidate()
+	cache.get(false, load)
+	if loads != 3 {
+		t.Errorf("refresh and invalidate loaded %d times in total, want 3", loads)
+	}
+
+	// A snapshot loaded while the inventory changed isn't kept
+	cache.invalidate()
+	cache.get(false, func() (statusSnapshot, error) {
+		cache.invalidate()
+		return load()
+	})
+	cache.get(false, load)
+	if loads != 5 {
+		t.Errorf("racing invalidate loaded %d times in total, want 5", loads)
+	}
+
+	disabled := newStatusCache(0)
+	loads = 0
+	disabled.get(false, load)
+	disabled.get(false, load)
+	if loads != 2 {
+		t.Errorf("disabled cache loaded %d times, want 2", loads)
+	}
+}
+
+func TestGetClusterStatusETag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	plugin.mutex.Lock()
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Pending"})
+	plugin.mutex.Unlock()
+	router := gin.New()
+	router.GET("/status", plugin.GetClusterStatusHandler)
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/status", nil)
+		if ifNoneMatch != "" {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET /status = %d with ETag %q", first.Code, etag)
+	}
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		if recorder := get(header); recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s = %d, want 304 without a body", header, recorder.Code)
+		}
+	}
+
+	// Changes made by the plugin show up before the cache expires
+	plugin.mutex.Lock()
+	plugin.putStatus(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	plugin.mutex.Unlock()
+	if recorder := get(etag); recorder.Code != http.StatusOK || recorder.Header().Get("ETag") == etag {
+		t.Errorf("GET /status after a change = %d with ETag %s", recorder.Code, recorder.Header().Get("ETag"))
+	}
+}
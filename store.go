@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ClusterStore persists the cluster inventory managed by the plugin
+type ClusterStore interface {
+	Get(name string) (ClusterStatus, bool, error)
+	List() ([]ClusterStatus, error)
+	Put(status ClusterStatus) error
+	Delete(name string) error
+	// AppendHistory records a status transition. History outlives the
+	// cluster record so availability can be reported after a detach.
+	AppendHistory(entry HistoryEntry) error
+	// History returns the recorded transitions of a cluster, oldest first
+	History(name string) ([]HistoryEntry, error)
+	// AllHistory returns the recorded transitions of every cluster
+	AllHistory() (map[string][]HistoryEntry, error)
+	// PruneHistory drops transitions recorded before cutoff and all but the
+	// newest maxEntries of each cluster, returning how many were dropped
+	PruneHistory(cutoff time.Time, maxEntries int) (int, error)
+	Close() error
+}
+
+// memoryClusterStore keeps the inventory in memory only
+type memoryClusterStore struct {
+	clusters map[string]ClusterStatus
+	history  map[string][]HistoryEntry
+	mutex    sync.RWMutex
+}
+
+func newMemoryClusterStore() *memoryClusterStore {
+	return &memoryClusterStore{clusters: make(map[string]ClusterStatus), history: make(map[string][]HistoryEntry)}
+}
+
+func (ms *memoryClusterStore) Get(name string) (ClusterStatus, bool, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	status, exists := ms.clusters[name]
+	return status, exists, nil
+}
+
+func (ms *memoryClusterStore) List() ([]ClusterStatus, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	clusters := make([]ClusterStatus, 0, len(ms.clusters))
+	for _, status := range ms.clusters {
+		clusters = append(clusters, status)
+	}
+	sortClusters(clusters)
+	return clusters, nil
+}
+
+func (ms *memoryClusterStore) Put(status ClusterStatus) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.clusters[status.ClusterName] = status
+	return nil
+}
+
+func (ms *memoryClusterStore) Delete(name string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	delete(ms.clusters, name)
+	return nil
+}
+
+func (ms *memoryClusterStore) AppendHistory(entry HistoryEntry) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.history[entry.ClusterName] = append(ms.history[entry.ClusterName], entry)
+	return nil
+}
+
+func (ms *memoryClusterStore) History(name string) ([]HistoryEntry, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return append([]HistoryEntry{}, ms.history[name]...), nil
+}
+
+func (ms *memoryClusterStore) AllHistory() (map[string][]HistoryEntry, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	history := make(map[string][]HistoryEntry, len(ms.history))
+	for name, entries := range ms.history {
+		history[name] = append([]HistoryEntry(nil), entries...)
+	}
+	return history, nil
+}
+
+func (ms *memoryClusterStore) PruneHistory(cutoff time.Time, maxEntries int) (int, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	pruned := 0
+	for name, entries := range ms.history {
+		kept := keptHistory(entries, cutoff, maxEntries)
+		pruned += len(entries) - len(kept)
+		if len(kept) == 0 {
+			delete(ms.history, name)
+		} else {
+			ms.history[name] = kept
+		}
+	}
+	return pruned, nil
+}
+
+func (ms *memoryClusterStore) Close() error {
+	return nil
+}
+
+var clustersBucket = []byte("clusters")
+
+// historyBucket holds a bucket of transitions per cluster, keyed by sequence
+var historyBucket = []byte("history")
+
+// boltClusterStore keeps the inventory in an embedded BoltDB file, each
+// cluster sealed with box since it can carry proxy credentials
+type boltClusterStore struct {
+	db  *bolt.DB
+	box *secretBox
+}
+
+func newBoltClusterStore(path string, box *secretBox) (*boltClusterStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cluster store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(clustersBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create store buckets: %w", err)
+	}
+
+	return &boltClusterStore{db: db, box: box}, nil
+}
+
+func (bs *boltClusterStore) Get(name string) (ClusterStatus, bool, error) {
+	var status ClusterStatus
+	var exists bool
+
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(clustersBucket).Get([]byte(name))
+		if data == nil {
+			return nil
+		}
+		exists = true
+		return bs.decode(data, &status)
+	})
+	if err != nil {
+		return ClusterStatus{}, false, fmt.Errorf("failed to read cluster '%s': %w", name, err)
+	}
+	return status, exists, nil
+}
+
+func (bs *boltClusterStore) List() ([]ClusterStatus, error) {
+	clusters := []ClusterStatus{}
+
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(clustersBucket).ForEach(func(_, data []byte) error {
+			var status ClusterStatus
+			if err := bs.decode(data, &status); err != nil {
+				return err
+			}
+			clusters = append(clusters, status)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clusters: %w", err)
+	}
+
+	sortClusters(clusters)
+	return clusters, nil
+}
+
+func (bs *boltClusterStore) Put(status ClusterStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to encode cluster '%s': %w", status.ClusterName, err)
+	}
+	if data, err = bs.box.Seal(data); err != nil {
+		return fmt.Errorf("failed to seal cluster '%s': %w", status.ClusterName, err)
+	}
+
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(clustersBucket).Put([]byte(status.ClusterName), data)
+	})
+}
+
+func (bs *boltClusterStore) Delete(name string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(clustersBucket).Delete([]byte(name))
+	})
+}
+
+// AppendHistory stores the transition unsealed: it holds no more than the
+// status and its message
+func (bs *boltClusterStore) AppendHistory(entry HistoryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode history of '%s': %w", entry.ClusterName, err)
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(entry.ClusterName))
+		if err != nil {
+			return err
+		}
+		sequence, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(historyKey(sequence), data)
+	})
+}
+
+func (bs *boltClusterStore) History(name string) ([]HistoryEntry, error) {
+	entries := []HistoryEntry{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(name))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, data []byte) error {
+			var entry HistoryEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of '%s': %w", name, err)
+	}
+	return entries, nil
+}
+
+func (bs *boltClusterStore) AllHistory() (map[string][]HistoryEntry, error) {
+	history := make(map[string][]HistoryEntry)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(historyBucket)
+		return root.ForEachBucket(func(name []byte) error {
+			return root.Bucket(name).ForEach(func(_, data []byte) error {
+				var entry HistoryEntry
+				if err := json.Unmarshal(data, &entry); err != nil {
+					return err
+				}
+				history[string(name)] = append(history[string(name)], entry)
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return history, nil
+}
+
+func (bs *boltClusterStore) PruneHistory(cutoff time.Time, maxEntries int) (int, error) {
+	pruned := 0
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(historyBucket)
+		var names [][]byte
+		err := root.ForEachBucket(func(name []byte) error {
+			names = append(names, append([]byte(nil), name...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			bucket := root.Bucket(name)
+			var keys [][]byte
+			var entries []HistoryEntry
+			err := bucket.ForEach(func(key, data []byte) error {
+				var entry HistoryEntry
+				if err := json.Unmarshal(data, &entry); err != nil {
+					return err
+				}
+				keys = append(keys, append([]byte(nil), key...))
+				entries = append(entries, entry)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			// Entries are kept in order, so the dropped ones are a prefix
+			dropped := len(entries) - len(keptHistory(entries, cutoff, maxEntries))
+			pruned += dropped
+			if dropped == len(keys) {
+				if err := root.DeleteBucket(name); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, key := range keys[:dropped] {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune history: %w", err)
+	}
+	return pruned, nil
+}
+
+func (bs *boltClusterStore) Close() error {
+	return bs.db.Close()
+}
+
+// decode opens a stored cluster. Versions before encryption at rest stored
+// plain JSON, which is read as is until reseal seals it.
+func (bs *boltClusterStore) decode(data []byte, status *ClusterStatus) error {
+	if !bytes.HasPrefix(data, []byte("{")) {
+		opened, err := bs.box.Open(data)
+		if err != nil {
+			return err
+		}
+		data = opened
+	}
+	return json.Unmarshal(data, status)
+}
+
+// reseal seals plain JSON clusters and those sealed with a previous key
+// under the current key
+func (bs *boltClusterStore) reseal() (int, error) {
+	resealed := 0
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(clustersBucket)
+		updates := map[string][]byte{}
+		err := bucket.ForEach(func(name, data []byte) error {
+			var sealed []byte
+			var changed bool
+			var err error
+			if bytes.HasPrefix(data, []byte("{")) {
+				sealed, err = bs.box.Seal(data)
+				changed = true
+			} else {
+				sealed, changed, err = bs.box.Reseal(data)
+			}
+			if err != nil {
+				return fmt.Errorf("cluster '%s': %w", name, err)
+			}
+			if changed {
+				updates[string(name)] = sealed
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for name, sealed := range updates {
+			if err := bucket.Put([]byte(name), sealed); err != nil {
+				return err
+			}
+		}
+		resealed = len(updates)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to reseal clusters: %w", err)
+	}
+	return resealed, nil
+}
+
+// historyKey orders transitions by the sequence they were appended in
+func historyKey(sequence uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sequence)
+	return key
+}
+
+// keptHistory returns the transitions that survive pruning: those recorded
+// at or after cutoff, at most the newest maxEntries
+func keptHistory(entries []HistoryEntry, cutoff time.Time, maxEntries int) []HistoryEntry {
+	start := 0
+	for start < len(entries) {
+		timestamp, err := time.Parse(time.RFC3339, entries[start].Timestamp)
+		if err == nil && !timestamp.Before(cutoff) {
+			break
+		}
+		start++
+	}
+	if maxEntries > 0 && len(entries)-start > maxEntries {
+		start = len(entries) - maxEntries
+	}
+	return entries[start:]
+}
+
+func sortClusters(clusters []ClusterStatus) {
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].ClusterName < clusters[j].ClusterName
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusEvent describes a single cluster state transition
+type StatusEvent struct {
+	ClusterName string `json:"clusterName"`
+	Status      string `json:"status"`
+	Previous    string `json:"previous,omitempty"`
+	Message     string `json:"message,omitempty"`
+	JobID       string `json:"jobId,omitempty"`
+	Timestamp   string `json:"timestamp"`
+	// Tenant owns the cluster; subscribers only get their tenant's events
+	Tenant string `json:"-"`
+}
+
+// statusBroadcaster fans cluster state transitions out to stream subscribers
+type statusBroadcaster struct {
+	subscribers map[chan StatusEvent]struct{}
+	closed      bool
+	mutex       sync.Mutex
+}
+
+func newStatusBroadcaster() *statusBroadcaster {
+	return &statusBroadcaster{subscribers: make(map[chan StatusEvent]struct{})}
+}
+
+// Subscribe registers a new subscriber channel
+func (sb *statusBroadcaster) Subscribe() chan StatusEvent {
+	ch := make(chan StatusEvent, 32)
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	if sb.closed {
+		close(ch)
+		return ch
+	}
+	sb.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Close ends every subscription so open streams terminate
+func (sb *statusBroadcaster) Close() {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	sb.closed = true
+	for ch := range sb.subscribers {
+		close(ch)
+		delete(sb.subscribers, ch)
+	}
+}
+
+// Unsubscribe removes a subscriber channel
+func (sb *statusBroadcaster) Unsubscribe(ch chan StatusEvent) {
+	sb.mutex.Lock()
+	delete(sb.subscribers, ch)
+	sb.mutex.Unlock()
+}
+
+// Publish sends an event to every subscriber, dropping it for slow consumers
+func (sb *statusBroadcaster) Publish(event StatusEvent) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	for ch := range sb.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// StreamClusterStatusHandler pushes cluster state transitions over Server-Sent Events
+func (cp *ClusterPlugin) StreamClusterStatusHandler(c *gin.Context) {
+	clusters, err := cp.store.List()
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeStoreError, "Failed to read cluster store")
+		return
+	}
+	clusters = tenantClusters(c.Request.Context(), clusters)
+
+	events := cp.broadcaster.Subscribe()
+	defer cp.broadcaster.Unsubscribe(events)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// Send the current inventory first so clients start from a consistent view
+	c.SSEvent("snapshot", clusters)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if !visibleTo(c.Request.Context(), event.Tenant) {
+				return true
+			}
+			c.SSEvent("status", event)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", gin.H{"timestamp": time.Now().Format(time.RFC3339)})
+			return true
+		}
+	})
+}
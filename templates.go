@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/yaml"
+)
+
+// agentNamespace holds the klusterlet agents on the spoke
+const agentNamespace = "open-cluster-management-agent"
+
+// wdsTarget is the target of the bindingPolicies templates, applied to the
+// WDS once the cluster has joined
+const wdsTarget = "wds"
+
+// ManifestValues are the settings rendered into the generated manifests.
+// The manifestValues section of the Initialize config sets the defaults and
+// an onboard request may override them.
+type ManifestValues struct {
+	// Registry replaces quay.io/open-cluster-management as the source of the
+	// klusterlet images, e.g. a mirror reachable from the spoke
+	Registry string `json:"registry,omitempty"`
+	// ImageTag is the tag of the mirrored images, defaulting to latest
+	ImageTag string `json:"imageTag,omitempty"`
+	// Proxy routes the klusterlet's connection to the hub and the plugin's
+	// connections to the spoke
+	Proxy *ProxyConfig `json:"proxy,omitempty"`
+	// NodeSelector and Tolerations place the klusterlet agents
+	NodeSelector map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations  []corev1.Toleration `json:"tolerations,omitempty"`
+	// Charts are installed on the spoke by the helm-charts step
+	Charts []HelmChart `json:"charts,omitempty"`
+}
+
+// Validate checks the values before they reach a template
+func (v ManifestValues) Validate() error {
+	if strings.ContainsAny(v.Registry, " \t\n") || strings.HasSuffix(v.Registry, "/") {
+		return fmt.Errorf("invalid registry %q", v.Registry)
+	}
+	if strings.ContainsAny(v.ImageTag, " \t\n/:") {
+		return fmt.Errorf("invalid imageTag %q", v.ImageTag)
+	}
+	if v.Proxy != nil {
+		if err := v.Proxy.Validate(); err != nil {
+			return fmt.Errorf("invalid proxy: %w", err)
+		}
+	}
+	for _, toleration := range v.Tolerations {
+		if toleration.Operator != "" && toleration.Operator != corev1.TolerationOpExists && toleration.Operator != corev1.TolerationOpEqual {
+			return fmt.Errorf("invalid toleration operator %q, must be Exists or Equal", toleration.Operator)
+		}
+	}
+	return validateCharts(v.Charts)
+}
+
+// Merge returns v with the settings of overrides on top; node selectors are
+// merged key by key while tolerations, charts and the proxy are replaced as
+// a whole
+func (v ManifestValues) Merge(overrides *ManifestValues) ManifestValues {
+	if overrides == nil {
+		return v
+	}
+	merged := v
+	if overrides.Registry != "" {
+		merged.Registry = overrides.Registry
+	}
+	if overrides.ImageTag != "" {
+		merged.ImageTag = overrides.ImageTag
+	}
+	if overrides.Proxy != nil {
+		merged.Proxy = overrides.Proxy
+	}
+	if len(overrides.NodeSelector) > 0 {
+		merged.NodeSelector = make(map[string]string, len(v.NodeSelector)+len(overrides.NodeSelector))
+		for key, value := range v.NodeSelector {
+			merged.NodeSelector[key] = value
+		}
+		for key, value := range overrides.NodeSelector {
+			merged.NodeSelector[key] = value
+		}
+	}
+	if len(overrides.Tolerations) > 0 {
+		merged.Tolerations = overrides.Tolerations
+	}
+	if len(overrides.Charts) > 0 {
+		merged.Charts = overrides.Charts
+	}
+	return merged
+}
+
+func manifestValuesFromConfig(config map[string]interface{}) (ManifestValues, error) {
+	var values ManifestValues
+	raw, ok := config["manifestValues"]
+	if !ok {
+		return values, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return values, fmt.Errorf("invalid manifestValues config: %w", err)
+	}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return values, fmt.Errorf("invalid manifestValues config: %w", err)
+	}
+	if err := values.Validate(); err != nil {
+		return values, fmt.Errorf("invalid manifestValues config: %w", err)
+	}
+	return values, nil
+}
+
+// manifestData is what the templates are rendered with
+type manifestData struct {
+	ClusterName string
+	Namespace   string
+	Labels      map[string]string
+	// Hub is the hub the cluster joins, set for the bindingPolicies templates
+	Hub      string
+	ImageTag string
+	// BootstrapKubeconfig is the kubeconfig the klusterlet bootstraps with,
+	// only built when it has to go through a proxy or trust a CA bundle
+	BootstrapKubeconfig string
+	// PullSecret is the docker config JSON of the airGap pull secret
+	PullSecret string
+	// Mirrors rewrite the image references of the rendered manifests
+	Mirrors map[string]string
+	Values  ManifestValues
+}
+
+func newManifestData(clusterName string, values ManifestValues) manifestData {
+	data := manifestData{
+		ClusterName: clusterName,
+		Namespace:   agentNamespace,
+		Labels:      clusterLabels(clusterName),
+		ImageTag:    values.ImageTag,
+		Values:      values,
+	}
+	if data.ImageTag == "" {
+		data.ImageTag = "latest"
+	}
+	return data
+}
+
+// manifestData returns the data the templates are rendered with for a
+// cluster, including the air-gap settings
+func (cp *ClusterPlugin) manifestData(clusterName string, values ManifestValues) manifestData {
+	data := newManifestData(clusterName, values)
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	data.PullSecret = string(cp.pullSecret)
+	data.Mirrors = cp.airGap.mirrors()
+	return data
+}
+
+// ManifestTemplate generates one manifest applied during onboarding
+type ManifestTemplate struct {
+	Name string `json:"name"`
+	// Target is the cluster the manifest is applied to: spoke, hub or wds
+	Target      string `json:"target"`
+	Description string `json:"description"`
+	// BeforeJoin manifests are applied before clusteradm join, such as the
+	// credentials the klusterlet operator pulls its image with
+	BeforeJoin bool `json:"beforeJoin,omitempty"`
+	// DeleteOnDetach wds manifests are deleted when the cluster is detached
+	DeleteOnDetach bool   `json:"deleteOnDetach,omitempty"`
+	Template       string `json:"template"`
+
+	parsed *template.Template
+}
+
+// parseManifestTemplate parses the text of t
+func parseManifestTemplate(t ManifestTemplate) (ManifestTemplate, error) {
+	parsed, err := template.New(t.Name).Funcs(templateFuncs).Option("missingkey=error").Parse(t.Template)
+	if err != nil {
+		return t, err
+	}
+	t.parsed = parsed
+	return t, nil
+}
+
+// Render executes the template. A template may render nothing when the
+// values don't call for its manifest.
+func (t ManifestTemplate) Render(data manifestData) (string, error) {
+	var out bytes.Buffer
+	if err := t.parsed.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", t.Name, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+var templateFuncs = template.FuncMap{
+	// toJSON renders a value as JSON, which is also valid YAML
+	"toJSON": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	"b64enc": func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	},
+	"list": func(values ...interface{}) []interface{} {
+		return values
+	},
+}
+
+// manifestTemplates are the built-in templates. Apart from the BeforeJoin
+// ones, they are applied in order after clusteradm join has installed the
+// klusterlet operator, overriding what it created.
+var manifestTemplates = []ManifestTemplate{
+	{
+		Name:        "image-pull-credentials",
+		Target:      "spoke",
+		Description: "Pull secret for the klusterlet images, rendered when airGap.pullSecretPath is set",
+		BeforeJoin:  true,
+		Template: `{{- if .PullSecret -}}
+{{- range list "open-cluster-management" .Namespace }}
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ toJSON . }}
+---
+apiVersion: v1
+kind: Secret
+type: kubernetes.io/dockerconfigjson
+metadata:
+  name: open-cluster-management-image-pull-credentials
+  namespace: {{ toJSON . }}
+data:
+  .dockerconfigjson: {{ b64enc $.PullSecret }}
+{{- end }}
+{{- end }}`,
+	},
+	{
+		Name:        "bootstrap-hub-kubeconfig",
+		Target:      "spoke",
+		Description: "Kubeconfig the klusterlet bootstraps with, rendered when a proxy is set",
+		Template: `{{- if .BootstrapKubeconfig -}}
+apiVersion: v1
+kind: Secret
+type: Opaque
+metadata:
+  name: bootstrap-hub-kubeconfig
+  namespace: {{ toJSON .Namespace }}
+data:
+  kubeconfig: {{ b64enc .BootstrapKubeconfig }}
+{{- end }}`,
+	},
+	{
+		Name:        "klusterlet",
+		Target:      "spoke",
+		Description: "Klusterlet with the image registry and node placement overrides",
+		Template: `apiVersion: operator.open-cluster-management.io/v1
+kind: Klusterlet
+metadata:
+  name: klusterlet
+spec:
+  clusterName: {{ toJSON .ClusterName }}
+  namespace: {{ toJSON .Namespace }}
+  deployOption:
+    mode: Singleton
+{{- with .Values.Registry }}
+  imagePullSpec: {{ printf "%s/registration-operator:%s" . $.ImageTag | toJSON }}
+  registrationImagePullSpec: {{ printf "%s/registration:%s" . $.ImageTag | toJSON }}
+  workImagePullSpec: {{ printf "%s/work:%s" . $.ImageTag | toJSON }}
+{{- end }}
+{{- if or .Values.NodeSelector .Values.Tolerations }}
+  nodePlacement:
+{{- with .Values.NodeSelector }}
+    nodeSelector: {{ toJSON . }}
+{{- end }}
+{{- with .Values.Tolerations }}
+    tolerations: {{ toJSON . }}
+{{- end }}
+{{- end }}`,
+	},
+	{
+		Name:        "managedcluster",
+		Target:      "hub",
+		Description: "ManagedCluster the klusterlet registers, shown in dry runs",
+		Template: `apiVersion: cluster.open-cluster-management.io/v1
+kind: ManagedCluster
+metadata:
+  name: {{ toJSON .ClusterName }}
+  labels: {{ toJSON .Labels }}
+spec:
+  hubAcceptsClient: true`,
+	},
+}
+
+func init() {
+	for i := range manifestTemplates {
+		parsed, err := parseManifestTemplate(manifestTemplates[i])
+		if err != nil {
+			// The built-in templates are part of the build, so this is a programming error
+			panic(fmt.Sprintf("invalid manifest template %s: %v", manifestTemplates[i].Name, err))
+		}
+		manifestTemplates[i] = parsed
+	}
+}
+
+// manifestTemplates returns the built-in templates followed by those of the
+// offline bundle
+func (cp *ClusterPlugin) manifestTemplates() []ManifestTemplate {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	if cp.templates == nil {
+		return manifestTemplates
+	}
+	return cp.templates
+}
+
+// renderManifests renders the templates for target applied before or after
+// the join, one manifest per YAML document, with their image references
+// rewritten to the mirrors
+func renderManifests(templates []ManifestTemplate, target string, beforeJoin bool, data manifestData) ([]string, error) {
+	var manifests []string
+	for _, t := range templates {
+		if t.Target != target || t.BeforeJoin != beforeJoin {
+			continue
+		}
+		rendered, err := t.Render(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, document := range strings.Split(rendered, "\n---") {
+			document = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(document), "---"))
+			if document == "" {
+				continue
+			}
+			manifest, err := rewriteImages(document+"\n", data.Mirrors)
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewrite images of %s: %w", t.Name, err)
+			}
+			manifests = append(manifests, manifest)
+		}
+	}
+	return manifests, nil
+}
+
+// bootstrapKubeconfig builds the kubeconfig the klusterlet bootstraps with,
+// reaching the hub endpoint published in its cluster-info ConfigMap, as
+// clusteradm join --force-internal-endpoint-lookup does, through the proxy
+func bootstrapKubeconfig(ctx context.Context, hub kubernetes.Interface, joinToken string, proxy ProxyConfig) (string, error) {
+	token := hubToken(joinToken)
+	if token == "" {
+		return "", fmt.Errorf("join command has no --hub-token")
+	}
+
+	clusterInfo, err := hub.CoreV1().ConfigMaps(metav1.NamespacePublic).Get(ctx, "cluster-info", metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to read hub cluster-info: %w", err)
+	}
+	published, err := clientcmd.Load([]byte(clusterInfo.Data["kubeconfig"]))
+	if err != nil {
+		return "", fmt.Errorf("invalid hub cluster-info kubeconfig: %w", err)
+	}
+	var hubCluster *clientcmdapi.Cluster
+	for _, cluster := range published.Clusters {
+		hubCluster = cluster
+		break
+	}
+	if hubCluster == nil {
+		return "", fmt.Errorf("hub cluster-info lists no cluster")
+	}
+
+	proxyURL, err := proxy.proxyFor(hubCluster.Server)
+	if err != nil {
+		return "", err
+	}
+
+	config := clientcmdapi.NewConfig()
+	config.Clusters["hub"] = &clientcmdapi.Cluster{
+		Server:                   hubCluster.Server,
+		CertificateAuthorityData: appendCABundle(hubCluster.CertificateAuthorityData, proxy.CABundle),
+		ProxyURL:                 proxyURL,
+	}
+	config.AuthInfos["bootstrap"] = &clientcmdapi.AuthInfo{Token: token}
+	config.Contexts["bootstrap"] = &clientcmdapi.Context{Cluster: "hub", AuthInfo: "bootstrap"}
+	config.CurrentContext = "bootstrap"
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// manifestResources resolves the API resource of each manifest
+type manifestResources struct {
+	client dynamic.Interface
+	mapper *restmapper.DeferredDiscoveryRESTMapper
+}
+
+func newManifestResources(restConfig *rest.Config) (*manifestResources, error) {
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	return &manifestResources{
+		client: client,
+		mapper: restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
+	}, nil
+}
+
+// resource parses a manifest and returns it with the client of its resource
+func (m *manifestResources) resource(manifest string) (*unstructured.Unstructured, dynamic.ResourceInterface, error) {
+	object := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(manifest), &object.Object); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	gvk := object.GroupVersionKind()
+	mapping, err := m.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown kind %s: %w", gvk, err)
+	}
+	var resource dynamic.ResourceInterface = m.client.Resource(mapping.Resource)
+	if object.GetNamespace() != "" {
+		resource = m.client.Resource(mapping.Resource).Namespace(object.GetNamespace())
+	}
+	return object, resource, nil
+}
+
+// applyManifests server-side applies manifests, taking over the fields they
+// set from whoever created the objects
+func applyManifests(ctx context.Context, restConfig *rest.Config, manifests []string) error {
+	resources, err := newManifestResources(restConfig)
+	if err != nil {
+		return err
+	}
+
+	force := true
+	for _, manifest := range manifests {
+		object, resource, err := resources.resource(manifest)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if _, err := resource.Patch(ctx, object.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: "kubestellar-cluster-plugin",
+			Force:        &force,
+		}); err != nil {
+			return fmt.Errorf("failed to apply %s %s: %w", object.GetKind(), object.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// deleteManifests deletes the objects of manifests, ignoring those already gone
+func deleteManifests(ctx context.Context, restConfig *rest.Config, manifests []string) error {
+	resources, err := newManifestResources(restConfig)
+	if err != nil {
+		return err
+	}
+	for _, manifest := range manifests {
+		object, resource, err := resources.resource(manifest)
+		if err != nil {
+			return err
+		}
+		if err := resource.Delete(ctx, object.GetName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s %s: %w", object.GetKind(), object.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// applySpokeManifests renders the spoke templates applied before or after
+// the join for an onboarding and applies them to the spoke
+func (cp *ClusterPlugin) applySpokeManifests(ctx context.Context, r *onboardingRun, beforeJoin bool) error {
+	data := cp.manifestData(r.clusterName, r.values)
+	if r.values.Proxy != nil && !beforeJoin {
+		hub, err := r.hubClient()
+		if err != nil {
+			return err
+		}
+		data.BootstrapKubeconfig, err = bootstrapKubeconfig(ctx, hub, r.joinToken, *r.values.Proxy)
+		if err != nil {
+			return fmt.Errorf("failed to build bootstrap kubeconfig: %w", err)
+		}
+	}
+	manifests, err := renderManifests(cp.manifestTemplates(), "spoke", beforeJoin, data)
+	if err != nil {
+		return err
+	}
+	if len(manifests) == 0 {
+		return nil
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(r.kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	return applyManifests(ctx, restConfig, manifests)
+}
+
+// ListTemplatesHandler returns the manifest templates and the default values.
+// With a cluster query parameter the templates are also rendered for that
+// cluster, with secret data redacted.
+func (cp *ClusterPlugin) ListTemplatesHandler(c *gin.Context) {
+	cp.mutex.RLock()
+	values := cp.manifestValues
+	cp.mutex.RUnlock()
+	clusterName := c.Query("cluster")
+	if clusterName != "" {
+		if err := validateClusterName(clusterName); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	type templateView struct {
+		ManifestTemplate
+		Rendered string `json:"rendered,omitempty"`
+	}
+	available := cp.manifestTemplates()
+	templates := make([]templateView, 0, len(available))
+	for _, t := range available {
+		view := templateView{ManifestTemplate: t}
+		if clusterName != "" {
+			data := cp.manifestData(clusterName, values)
+			if values.Proxy != nil {
+				data.BootstrapKubeconfig = "<bootstrap kubeconfig>"
+			}
+			manifests, err := renderManifests([]ManifestTemplate{t}, t.Target, t.BeforeJoin, data)
+			if err != nil {
+				respondProblem(c, http.StatusInternalServerError, CodeInternal, err.Error())
+				return
+			}
+			for i, manifest := range manifests {
+				if i > 0 {
+					view.Rendered += "---\n"
+				}
+				view.Rendered += redactSecretData(manifest)
+			}
+		}
+		templates = append(templates, view)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"templates": templates,
+		"values":    values,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
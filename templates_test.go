@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRenderManifests(t *testing.T) {
+	defaults := ManifestValues{Registry: "mirror.example.com/ocm", NodeSelector: map[string]string{"zone": "a"}}
+	values := defaults.Merge(&ManifestValues{
+		ImageTag:     "v0.13.1",
+		NodeSelector: map[string]string{"role": "infra"},
+		Tolerations:  []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+	})
+
+	manifests, err := renderManifests(manifestTemplates, "spoke", false, newManifestData("edge-1", values))
+	if err != nil {
+		t.Fatalf("renderManifests() error = %v", err)
+	}
+	// Without a proxy the bootstrap kubeconfig is left to clusteradm
+	if len(manifests) != 1 {
+		t.Fatalf("rendered %d spoke manifests, want 1: %v", len(manifests), manifests)
+	}
+	var klusterlet struct {
+		Spec struct {
+			ClusterName   string `json:"clusterName"`
+			ImagePullSpec string `json:"imagePullSpec"`
+			NodePlacement struct {
+				NodeSelector map[string]string   `json:"nodeSelector"`
+				Tolerations  []corev1.Toleration `json:"tolerations"`
+			} `json:"nodePlacement"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal([]byte(manifests[0]), &klusterlet); err != nil {
+		t.Fatalf("klusterlet is not valid YAML: %v\n%s", err, manifests[0])
+	}
+	spec := klusterlet.Spec
+	if spec.ClusterName != "edge-1" || spec.ImagePullSpec != "mirror.example.com/ocm/registration-operator:v0.13.1" {
+		t.Errorf("klusterlet spec = %+v", spec)
+	}
+	if spec.NodePlacement.NodeSelector["zone"] != "a" || spec.NodePlacement.NodeSelector["role"] != "infra" || len(spec.NodePlacement.Tolerations) != 1 {
+		t.Errorf("node placement = %+v", spec.NodePlacement)
+	}
+
+	values.Proxy = &ProxyConfig{HTTPSProxy: "http://proxy.example.com:3128"}
+	data := newManifestData("edge-1", values)
+	data.BootstrapKubeconfig = "kubeconfig"
+	manifests, err = renderManifests(manifestTemplates, "spoke", false, data)
+	if err != nil || len(manifests) != 2 || !strings.Contains(manifests[0], "kind: Secret") {
+		t.Errorf("renderManifests() with proxy = %v, %v", manifests, err)
+	}
+}
+
+func TestManifestValuesValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  ManifestValues
+		wantErr bool
+	}{
+		{name: "empty", values: ManifestValues{}},
+		{name: "valid", values: ManifestValues{Registry: "mirror:5000/ocm", ImageTag: "v1", Proxy: &ProxyConfig{HTTPSProxy: "https://proxy:3128"}}},
+		{name: "registry with spaces", values: ManifestValues{Registry: "mirror ocm"}, wantErr: true},
+		{name: "tag with colon", values: ManifestValues{ImageTag: "v1:x"}, wantErr: true},
+		{name: "socks proxy", values: ManifestValues{Proxy: &ProxyConfig{HTTPSProxy: "socks5://proxy:1080"}}, wantErr: true},
+		{name: "bad toleration", values: ManifestValues{Tolerations: []corev1.Toleration{{Operator: "In"}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.values.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBootstrapKubeconfig(t *testing.T) {
+	published := `apiVersion: v1
+kind: Config
+clusters:
+- name: ""
+  cluster:
+    server: https://hub-control-plane:6443
+    certificate-authority-data: Y2E=
+`
+	hub := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-info", Namespace: metav1.NamespacePublic},
+		Data:       map[string]string{"kubeconfig": published},
+	})
+
+	joinCommand := "clusteradm join --hub-token secret-token --hub-apiserver https://127.0.0.1:6443 --cluster-name <cluster_name>"
+	data, err := bootstrapKubeconfig(context.Background(), hub, joinCommand, ProxyConfig{HTTPSProxy: "http://proxy:3128", CABundle: "bundle"})
+	if err != nil {
+		t.Fatalf("bootstrapKubeconfig() error = %v", err)
+	}
+	config, err := clientcmd.Load([]byte(data))
+	if err != nil {
+		t.Fatalf("bootstrap kubeconfig is invalid: %v", err)
+	}
+	cluster := config.Clusters["hub"]
+	if cluster.Server != "https://hub-control-plane:6443" || cluster.ProxyURL != "http://proxy:3128" || string(cluster.CertificateAuthorityData) != "ca\nbundle" {
+		t.Errorf("hub cluster = %+v", cluster)
+	}
+	if config.AuthInfos["bootstrap"].Token != "secret-token" {
+		t.Errorf("token = %q", config.AuthInfos["bootstrap"].Token)
+	}
+
+	if _, err := bootstrapKubeconfig(context.Background(), hub, "clusteradm join", ProxyConfig{}); err == nil {
+		t.Error("bootstrapKubeconfig() without a token succeeded")
+	}
+}
+
+func TestListTemplatesHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := &ClusterPlugin{
+		manifestValues: ManifestValues{Proxy: &ProxyConfig{HTTPSProxy: "http://proxy:3128"}},
+		pullSecret:     []byte(`{"auths":{"registry.local":{"auth":"c2VjcmV0"}}}`),
+	}
+	router := gin.New()
+	router.GET("/templates", plugin.ListTemplatesHandler)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/templates?cluster=edge-1", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	var body struct {
+		Templates []struct {
+			Name     string `json:"name"`
+			Template string `json:"template"`
+			Rendered string `json:"rendered"`
+		} `json:"templates"`
+		Values ManifestValues `json:"values"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Templates) != len(manifestTemplates) || body.Values.Proxy == nil {
+		t.Fatalf("body = %+v", body)
+	}
+	for _, tmpl := range body.Templates {
+		if tmpl.Template == "" || tmpl.Rendered == "" {
+			t.Errorf("template %s is missing its text or rendering", tmpl.Name)
+		}
+		if strings.Contains(tmpl.Rendered, "kind: Secret") && !strings.Contains(tmpl.Rendered, "<redacted>") {
+			t.Errorf("template %s leaks secret data: %s", tmpl.Name, tmpl.Rendered)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/templates?cluster=Bad_Name", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid cluster status = %d, want 400", recorder.Code)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// tenantKey is the gin context key holding the tenant of the request
+const tenantKey = "tenant"
+
+// tenantContextKey stores the tenant on the request context too, so that the
+// records a handler creates, such as clusters and jobs, are owned by it
+type tenantContextKey struct{}
+
+// errTenantConflict is returned when a cluster name is already taken by a
+// cluster of another tenant
+var errTenantConflict = errors.New("cluster name is taken by another tenant")
+
+// TenancyConfig is the tenancy section of the Initialize config
+type TenancyConfig struct {
+	Enabled bool `json:"enabled"`
+	// Claim names the token claim holding the caller's tenant
+	Claim string `json:"claim,omitempty"`
+	// Header carries the tenant when the plugin doesn't authenticate
+	// callers itself and trusts the host to set it
+	Header string `json:"header,omitempty"`
+	// Subjects assigns tenants by token subject, for tokens without a claim
+	Subjects map[string]string `json:"subjects,omitempty"`
+	// DefaultTenant owns the requests no tenant is found for; without one
+	// they are refused
+	DefaultTenant string `json:"defaultTenant,omitempty"`
+}
+
+func tenancyConfigFromConfig(config map[string]interface{}) (*TenancyConfig, error) {
+	tc := TenancyConfig{Claim: "tenant", Header: "X-Tenant-ID"}
+	raw, ok := config["tenancy"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenancy config: %w", err)
+	}
+	if err := json.Unmarshal(data, &tc); err != nil {
+		return nil, fmt.Errorf("invalid tenancy config: %w", err)
+	}
+	if !tc.Enabled {
+		return nil, nil
+	}
+	tenants := []string{tc.DefaultTenant}
+	for _, tenant := range tc.Subjects {
+		tenants = append(tenants, tenant)
+	}
+	for _, tenant := range tenants {
+		if tenant == "" {
+			continue
+		}
+		if err := validateTenant(tenant); err != nil {
+			return nil, fmt.Errorf("invalid tenancy config: %w", err)
+		}
+	}
+	return &tc, nil
+}
+
+// validateTenant checks a tenant ID is a DNS label, like a namespace name
+func validateTenant(tenant string) error {
+	if errs := validation.IsDNS1123Label(tenant); len(errs) > 0 {
+		return fmt.Errorf("invalid tenant '%s': %s", tenant, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// tenantScopedHandlers are the endpoints that read or change clusters, and
+// only see those of the caller's tenant. The others, such as hubs and
+// webhooks, belong to the platform operator.
+var tenantScopedHandlers = map[string]bool{
+	"GetClusterStatusHandler":     true,
+	"StreamClusterStatusHandler":  true,
+	"OnboardClusterHandler":       true,
+	"StreamOnboardingLogsHandler": true,
+	"BatchOnboardHandler":         true,
+	"GetBatchHandler":             true,
+	"ImportClustersHandler":       true,
+	"GetImportReportHandler":      true,
+	"ImportTerraformHandler":      true,
+	"OnboardDiscoveredHandler":    true,
+	"ExportClustersHandler":       true,
+	"ExportCostsHandler":          true,
+	"GetSLOHandler":               true,
+	"GetAnalyticsHandler":         true,
+	"SearchClustersHandler":       true,
+	"GetClusterVersionsHandler":   true,
+	"DetachClusterHandler":        true,
+	"ListJobsHandler":             true,
+	"GetJobHandler":               true,
+	"CancelJobHandler":            true,
+	"GetJobCheckpointsHandler":    true,
+	"ListSchedulesHandler":        true,
+	"CancelScheduleHandler":       true,
+	"ListApprovalsHandler":        true,
+	"ApproveHandler":              true,
+	"GetClusterDetailHandler":     true,
+	"RepairClusterHandler":        true,
+	"GetClusterTokenHandler":      true,
+	"RotateClusterTokenHandler":   true,
+	"GetClusterHistoryHandler":    true,
+	"VerifyClusterHandler":        true,
+	"PatchClusterLabelsHandler":   true,
+	"PatchClusterHandler":         true,
+	"ListAddonsHandler":           true,
+	"BulkAddonHandler":            true,
+	"GetClusterAddonsHandler":     true,
+	"EnableClusterAddonHandler":   true,
+	"DisableClusterAddonHandler":  true,
+	"ListGroupsHandler":           true,
+	"GetGroupHandler":             true,
+	"ProvisionClusterHandler":     true,
+	"DeprovisionClusterHandler":   true,
+}
+
+// tenantOf resolves the tenant of a request: the claim or subject binding of
+// an authenticated caller, else the header when the plugin leaves
+// authentication to the host, else the default tenant
+func (cp *ClusterPlugin) tenantOf(c *gin.Context, tc *TenancyConfig, authenticates bool) (string, error) {
+	header := strings.TrimSpace(c.GetHeader(tc.Header))
+	tenant := ""
+	if caller, ok := principal(c); ok {
+		if claim, ok := caller.Claims[tc.Claim].(string); ok && claim != "" {
+			tenant = claim
+		} else {
+			tenant = tc.Subjects[caller.Subject]
+		}
+		if tenant != "" && header != "" && header != tenant {
+			return "", fmt.Errorf("%s header doesn't match the tenant of the token", tc.Header)
+		}
+	} else if !authenticates {
+		tenant = header
+	}
+	if tenant == "" {
+		tenant = tc.DefaultTenant
+	}
+	if tenant == "" {
+		return "", nil
+	}
+	return tenant, validateTenant(tenant)
+}
+
+// withTenant wraps a handler so that the request carries the caller's
+// tenant. Tenant-scoped endpoints are refused without one, and a cluster
+// named in the path must belong to it.
+func (cp *ClusterPlugin) withTenant(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		tc := cp.tenancy
+		authenticates := cp.auth != nil
+		cp.mutex.RUnlock()
+
+		if tc == nil {
+			handler(c)
+			return
+		}
+		tenant, err := cp.tenantOf(c, tc, authenticates)
+		if err != nil {
+			abortWithProblem(c, http.StatusForbidden, CodePermissionDenied, err.Error())
+			return
+		}
+		if tenant == "" {
+			if tenantScopedHandlers[handlerName] {
+				abortWithProblem(c, http.StatusForbidden, CodeTenantRequired, "No tenant could be determined for the request")
+				return
+			}
+			handler(c)
+			return
+		}
+		c.Set(tenantKey, tenant)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), tenantContextKey{}, tenant))
+
+		if tenantScopedHandlers[handlerName] {
+			for _, param := range []string{"name", "cluster"} {
+				name := c.Param(param)
+				if name == "" {
+					continue
+				}
+				// Another tenant's cluster looks like no cluster at all
+				if cluster, exists, err := cp.store.Get(name); err == nil && exists && cluster.Tenant != tenant {
+					abortWithProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found", name))
+					return
+				}
+			}
+		}
+		handler(c)
+	}
+}
+
+// tenantFromContext returns the tenant stored on a request context
+func tenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(string)
+	return tenant, ok
+}
+
+// visibleTo reports whether a record owned by owner may be seen by the
+// request of ctx. Work not done for a tenant, such as reconciling, sees
+// every record.
+func visibleTo(ctx context.Context, owner string) bool {
+	tenant, ok := tenantFromContext(ctx)
+	return !ok || tenant == owner
+}
+
+// tenantClusters keeps the clusters the request of ctx may see
+func tenantClusters(ctx context.Context, clusters []ClusterStatus) []ClusterStatus {
+	if _, ok := tenantFromContext(ctx); !ok {
+		return clusters
+	}
+	visible := make([]ClusterStatus, 0, len(clusters))
+	for _, cluster := range clusters {
+		if visibleTo(ctx, cluster.Tenant) {
+			visible = append(visible, cluster)
+		}
+	}
+	return visible
+}
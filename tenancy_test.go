@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"detachSafety": detachSafetyOff,
+		"tenancy":      map[string]interface{}{"enabled": true},
+	})
+	plugin.putStatus(ClusterStatus{ClusterName: "acme-1", Status: "Ready", Tenant: "acme"})
+	plugin.putStatus(ClusterStatus{ClusterName: "globex-1", Status: "Ready", Tenant: "globex"})
+	globexCtx := context.WithValue(context.Background(), tenantContextKey{}, "globex")
+	globexJob := plugin.jobs.Create(globexCtx, "onboard", "globex-1")
+	plugin.jobs.Run(globexJob.ID, func(context.Context) error { return nil })
+	waitForJob(t, plugin.jobs, globexJob.ID, func(job Job) bool { return job.Finished() })
+
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, path, tenant, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		if tenant != "" {
+			request.Header.Set("X-Tenant-ID", tenant)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve(http.MethodGet, "/status", "acme", "")
+	var status ClusterStatusResponse
+	json.Unmarshal(recorder.Body.Bytes(), &status)
+	if recorder.Code != http.StatusOK || len(status.Clusters) != 1 || status.Clusters[0].ClusterName != "acme-1" {
+		t.Errorf("acme status = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(http.MethodGet, "/status", "", ""); recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), string(CodeTenantRequired)) {
+		t.Errorf("status without a tenant = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(http.MethodGet, "/capabilities", "", ""); recorder.Code != http.StatusOK {
+		t.Errorf("unscoped endpoint without a tenant = %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodGet, "/status", "Not A Tenant", ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("invalid tenant = %d", recorder.Code)
+	}
+
+	// Another tenant's cluster and job look like they don't exist
+	if recorder := serve(http.MethodGet, "/clusters/globex-1", "acme", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("foreign cluster detail = %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodPost, "/detach", "acme", `{"clusterName": "globex-1"}`); recorder.Code != http.StatusNotFound {
+		t.Errorf("foreign detach = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(http.MethodGet, "/jobs/"+globexJob.ID, "acme", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("foreign job = %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodGet, "/jobs/"+globexJob.ID, "globex", ""); recorder.Code != http.StatusOK {
+		t.Errorf("own job = %d", recorder.Code)
+	}
+
+	if recorder := serve(http.MethodPost, "/detach", "globex", `{"clusterName": "globex-1", "dryRun": true}`); recorder.Code != http.StatusOK {
+		t.Errorf("own detach dry run = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Names are unique across tenants, but the taken cluster isn't revealed
+	acmeCtx := context.WithValue(context.Background(), tenantContextKey{}, "acme")
+	if _, existing, err := plugin.beginOnboarding(acmeCtx, "globex-1", builtinHub.Name, "", "", nil, nil); !errors.Is(err, errTenantConflict) || existing != nil {
+		t.Errorf("beginOnboarding() of a foreign name = %v, %v", existing, err)
+	}
+}
+
+func TestTenantOf(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tc, err := tenancyConfigFromConfig(map[string]interface{}{"tenancy": map[string]interface{}{
+		"enabled":  true,
+		"subjects": map[string]string{"ci-bot": "platform"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin := &ClusterPlugin{}
+
+	tests := []struct {
+		name          string
+		caller        *Principal
+		header        string
+		authenticates bool
+		want          string
+		wantErr       bool
+	}{
+		{name: "claim", caller: &Principal{Subject: "alice", Claims: map[string]interface{}{"tenant": "acme"}}, authenticates: true, want: "acme"},
+		{name: "claim and matching header", caller: &Principal{Subject: "alice", Claims: map[string]interface{}{"tenant": "acme"}}, header: "acme", authenticates: true, want: "acme"},
+		{name: "claim and other header", caller: &Principal{Subject: "alice", Claims: map[string]interface{}{"tenant": "acme"}}, header: "globex", authenticates: true, wantErr: true},
+		{name: "subject binding", caller: &Principal{Subject: "ci-bot"}, authenticates: true, want: "platform"},
+		{name: "header trusted from the host", header: "globex", want: "globex"},
+		{name: "header ignored when authenticating", header: "globex", authenticates: true, want: ""},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/status", nil)
+		if tt.header != "" {
+			c.Request.Header.Set("X-Tenant-ID", tt.header)
+		}
+		if tt.caller != nil {
+			c.Set(principalKey, *tt.caller)
+		}
+		got, err := plugin.tenantOf(c, tc, tt.authenticates)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: tenantOf() = %q, %v", tt.name, got, err)
+		}
+	}
+
+	if _, err := tenancyConfigFromConfig(map[string]interface{}{"tenancy": map[string]interface{}{"enabled": true, "defaultTenant": "Shared_Tenant"}}); err == nil {
+		t.Error("tenancyConfigFromConfig() accepted an invalid default tenant")
+	}
+}
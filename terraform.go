@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// TerraformImportRequest discovers clusters in a Terraform or OpenTofu state,
+// given inline or read from the backend that keeps it
+type TerraformImportRequest struct {
+	// State is the content of a terraform.tfstate file
+	State   json.RawMessage   `json:"state,omitempty"`
+	Backend *TerraformBackend `json:"backend,omitempty"`
+	// Onboard starts onboarding the discovered clusters as an import;
+	// otherwise their kubeconfigs are only stored as contexts
+	Onboard bool `json:"onboard,omitempty"`
+	// Hub and Labels apply to the clusters onboarded
+	Hub    string            `json:"hub,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// TerraformBackend locates a remote state
+type TerraformBackend struct {
+	// Type is s3 or http
+	Type string `json:"type"`
+	// Bucket, Key and Region locate the state of the s3 backend, read with
+	// the aws CLI and the credentials of the host. Endpoint points at an S3
+	// compatible service.
+	Bucket   string `json:"bucket,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	// Address is the state URL of the http backend, read with basic auth
+	// when Username is set
+	Address  string `json:"address,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// Validate checks that exactly one state is given and the backend is complete
+func (r TerraformImportRequest) Validate() error {
+	if (len(r.State) > 0) == (r.Backend != nil) {
+		return fmt.Errorf("exactly one of state and backend must be set")
+	}
+	if backend := r.Backend; backend != nil {
+		switch backend.Type {
+		case "s3":
+			if backend.Bucket == "" || backend.Key == "" {
+				return fmt.Errorf("the s3 backend requires bucket and key")
+			}
+			if strings.HasPrefix(backend.Region, "-") || strings.HasPrefix(backend.Endpoint, "-") {
+				return fmt.Errorf("backend region and endpoint must not start with '-'")
+			}
+		case "http":
+			parsed, err := url.Parse(backend.Address)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("the http backend requires an http or https address")
+			}
+		default:
+			return fmt.Errorf("unsupported backend type %q, must be s3 or http", backend.Type)
+		}
+	}
+	return validateLabels(r.Labels)
+}
+
+// DiscoveredCluster is a cluster found in a Terraform state
+type DiscoveredCluster struct {
+	Name string `json:"name"`
+	// Address is the resource address or output the cluster was found at
+	Address string `json:"address"`
+	Server  string `json:"server,omitempty"`
+	// Provider fetches the kubeconfig of a managed cluster whose state
+	// holds none
+	Provider *ProviderSpec `json:"provider,omitempty"`
+	// KubeconfigRef is the stored context holding the kubeconfig the state
+	// carried
+	KubeconfigRef string `json:"kubeconfigRef,omitempty"`
+	Error         string `json:"error,omitempty"`
+
+	kubeconfig []byte
+}
+
+// terraformState is the part of a version 4 state file, written by Terraform
+// 0.12 and later and by OpenTofu, that clusters are discovered in
+type terraformState struct {
+	Version          int    `json:"version"`
+	TerraformVersion string `json:"terraform_version"`
+	Serial           int    `json:"serial"`
+	Lineage          string `json:"lineage"`
+	Outputs          map[string]struct {
+		Value     json.RawMessage `json:"value"`
+		Sensitive bool            `json:"sensitive"`
+	} `json:"outputs"`
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey   json.RawMessage        `json:"index_key"`
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// terraformClusterResources read a cluster from the attributes of the
+// resource types that create one
+var terraformClusterResources = map[string]func(attributes map[string]interface{}) DiscoveredCluster{
+	"aws_eks_cluster": func(attributes map[string]interface{}) DiscoveredCluster {
+		name := stringAttribute(attributes, "name")
+		// arn:aws:eks:<region>:<account>:cluster/<name>
+		region := ""
+		if fields := strings.Split(stringAttribute(attributes, "arn"), ":"); len(fields) > 3 {
+			region = fields[3]
+		}
+		return DiscoveredCluster{
+			Name:     name,
+			Server:   stringAttribute(attributes, "endpoint"),
+			Provider: &ProviderSpec{Name: "eks", Cluster: name, Region: region},
+		}
+	},
+	"google_container_cluster": func(attributes map[string]interface{}) DiscoveredCluster {
+		name := stringAttribute(attributes, "name")
+		server := stringAttribute(attributes, "endpoint")
+		if server != "" {
+			server = "https://" + server
+		}
+		return DiscoveredCluster{
+			Name:   name,
+			Server: server,
+			Provider: &ProviderSpec{Name: "gke", Cluster: name,
+				Region: stringAttribute(attributes, "location"), Project: stringAttribute(attributes, "project")},
+		}
+	},
+	"azurerm_kubernetes_cluster": func(attributes map[string]interface{}) DiscoveredCluster {
+		name := stringAttribute(attributes, "name")
+		cluster := DiscoveredCluster{Name: name, kubeconfig: []byte(stringAttribute(attributes, "kube_config_raw"))}
+		if fqdn := stringAttribute(attributes, "fqdn"); fqdn != "" {
+			cluster.Server = "https://" + fqdn + ":443"
+		}
+		if len(cluster.kubeconfig) == 0 {
+			cluster.Provider = &ProviderSpec{Name: "aks", Cluster: name, ResourceGroup: stringAttribute(attributes, "resource_group_name")}
+		}
+		return cluster
+	},
+	"kind_cluster": func(attributes map[string]interface{}) DiscoveredCluster {
+		return DiscoveredCluster{
+			Name:       stringAttribute(attributes, "name"),
+			Server:     stringAttribute(attributes, "endpoint"),
+			kubeconfig: []byte(stringAttribute(attributes, "kubeconfig")),
+		}
+	},
+}
+
+func stringAttribute(attributes map[string]interface{}, key string) string {
+	value, _ := attributes[key].(string)
+	return value
+}
+
+// discoverTerraformClusters finds the clusters of a state: the resources
+// that create one and the string outputs holding a kubeconfig. An output
+// named after a discovered cluster gives it its kubeconfig.
+func discoverTerraformClusters(data []byte) ([]DiscoveredCluster, error) {
+	var state terraformState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid state: %w", err)
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("unsupported state version %d, state files of Terraform 0.12 and later and of OpenTofu are version 4", state.Version)
+	}
+
+	var clusters []DiscoveredCluster
+	byName := map[string]int{}
+	for _, resource := range state.Resources {
+		read, ok := terraformClusterResources[resource.Type]
+		if !ok || resource.Mode != "managed" {
+			continue
+		}
+		address := resource.Type + "." + resource.Name
+		if resource.Module != "" {
+			address = resource.Module + "." + address
+		}
+		for _, instance := range resource.Instances {
+			cluster := read(instance.Attributes)
+			cluster.Address = address
+			if len(instance.IndexKey) > 0 {
+				cluster.Address += "[" + string(instance.IndexKey) + "]"
+			}
+			byName[cluster.Name] = len(clusters)
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	outputs := make([]string, 0, len(state.Outputs))
+	for name := range state.Outputs {
+		outputs = append(outputs, name)
+	}
+	sort.Strings(outputs)
+	for _, output := range outputs {
+		var value string
+		if json.Unmarshal(state.Outputs[output].Value, &value) != nil {
+			continue
+		}
+		config, err := clientcmd.Load([]byte(value))
+		if err != nil || len(config.Contexts) == 0 {
+			continue
+		}
+		name := outputClusterName(output)
+		if i, exists := byName[name]; exists && len(clusters[i].kubeconfig) == 0 {
+			clusters[i].kubeconfig = []byte(value)
+			clusters[i].Provider = nil
+			continue
+		}
+		cluster := DiscoveredCluster{Name: name, Address: "output." + output, kubeconfig: []byte(value)}
+		if context, exists := config.Contexts[config.CurrentContext]; exists {
+			if server, exists := config.Clusters[context.Cluster]; exists {
+				cluster.Server = server.Server
+			}
+		}
+		byName[name] = len(clusters)
+		clusters = append(clusters, cluster)
+	}
+
+	for i := range clusters {
+		if errs := validation.IsDNS1123Subdomain(clusters[i].Name); len(errs) > 0 {
+			clusters[i].Error = fmt.Sprintf("invalid cluster name %q: %s", clusters[i].Name, strings.Join(errs, "; "))
+		}
+	}
+	return clusters, nil
+}
+
+// outputClusterName names the cluster of a kubeconfig output after the
+// output, without a kubeconfig affix
+func outputClusterName(output string) string {
+	name := strings.ToLower(strings.ReplaceAll(output, "_", "-"))
+	for _, affix := range []string{"-kubeconfig", "kubeconfig-", "-kube-config", "kube-config-"} {
+		if trimmed := strings.TrimSuffix(strings.TrimPrefix(name, affix), affix); trimmed != "" {
+			name = trimmed
+		}
+	}
+	return name
+}
+
+// renameKubeconfigContext returns the kubeconfig with its current context,
+// or its only one, renamed
+func renameKubeconfigContext(data []byte, name string) ([]byte, error) {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+	current := config.CurrentContext
+	if _, exists := config.Contexts[current]; !exists {
+		if len(config.Contexts) != 1 {
+			return nil, fmt.Errorf("kubeconfig has no current context")
+		}
+		for only := range config.Contexts {
+			current = only
+		}
+	}
+	context := config.Contexts[current]
+	delete(config.Contexts, current)
+	config.Contexts[name] = context
+	config.CurrentContext = name
+	return clientcmd.Write(*config)
+}
+
+// readTerraformBackend fetches the state a backend keeps
+func (cp *ClusterPlugin) readTerraformBackend(ctx context.Context, backend TerraformBackend) ([]byte, error) {
+	limit := cp.uploadLimit()
+	var data []byte
+	switch backend.Type {
+	case "s3":
+		args := []string{"s3", "cp", fmt.Sprintf("s3://%s/%s", backend.Bucket, strings.TrimPrefix(backend.Key, "/")), "-"}
+		if backend.Region != "" {
+			args = append(args, "--region", backend.Region)
+		}
+		if backend.Endpoint != "" {
+			args = append(args, "--endpoint-url", backend.Endpoint)
+		}
+		output, err := runProviderCommand(ctx, nil, "aws", args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state from S3: %w", err)
+		}
+		data = output
+	case "http":
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, backend.Address, nil)
+		if err != nil {
+			return nil, err
+		}
+		if backend.Username != "" {
+			request.SetBasicAuth(backend.Username, backend.Password)
+		}
+		client := &http.Client{Timeout: 30 * time.Second}
+		response, err := client.Do(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state: %w", err)
+		}
+		defer response.Body.Close()
+		// The http backend answers 204 while no state has been written
+		if response.StatusCode == http.StatusNoContent {
+			return nil, fmt.Errorf("the backend holds no state yet")
+		}
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("state backend answered %s", response.Status)
+		}
+		if data, err = io.ReadAll(io.LimitReader(response.Body, limit+1)); err != nil {
+			return nil, fmt.Errorf("failed to read state: %w", err)
+		}
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("state exceeds the limit of %d bytes", limit)
+	}
+	return data, nil
+}
+
+// ImportTerraformHandler discovers the clusters of a Terraform or OpenTofu
+// state. Kubeconfigs found in the state are stored as contexts named after
+// their cluster, and with onboard the clusters are onboarded as an import.
+func (cp *ClusterPlugin) ImportTerraformHandler(c *gin.Context) {
+	var req TerraformImportRequest
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, cp.uploadLimit()))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondProblem(c, http.StatusRequestEntityTooLarge, CodePayloadTooLarge,
+			fmt.Sprintf("Request exceeds the limit of %d bytes", cp.uploadLimit()), "maxBytes", cp.uploadLimit())
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeValidationFailed, err.Error())
+		return
+	}
+
+	state := []byte(req.State)
+	if req.Backend != nil {
+		if state, err = cp.readTerraformBackend(c.Request.Context(), *req.Backend); err != nil {
+			respondProblem(c, http.StatusBadGateway, CodeUnavailable, err.Error())
+			return
+		}
+	}
+	clusters, err := discoverTerraformClusters(state)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if req.Onboard && len(clusters) > cp.maxBatchSize {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("State holds more than the maximum of %d clusters", cp.maxBatchSize))
+		return
+	}
+
+	for i := range clusters {
+		cluster := &clusters[i]
+		if cluster.Error != "" || len(cluster.kubeconfig) == 0 {
+			continue
+		}
+		renamed, err := renameKubeconfigContext(cluster.kubeconfig, cluster.Name)
+		if err == nil {
+			_, err = cp.kubeconfigs.Import(renamed, []string{cluster.Name})
+		}
+		if err != nil {
+			cluster.Error = fmt.Sprintf("failed to store kubeconfig: %v", err)
+			continue
+		}
+		cluster.KubeconfigRef = "context:" + cluster.Name
+	}
+
+	response := gin.H{
+		"message":     message(c, MsgTerraformDiscovered, "count", len(clusters)),
+		"messageCode": MsgTerraformDiscovered,
+		"clusters":    clusters,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}
+	if !req.Onboard {
+		requestLogger(c).Info("Terraform state imported", "clusters", len(clusters))
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	batch := &Batch{
+		ID:        newJobID("import"),
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Source:    "import",
+	}
+	batch.Tenant, _ = tenantFromContext(c.Request.Context())
+	var tasks []batchTask
+	for i, cluster := range clusters {
+		item := BatchItem{Row: i + 1, ClusterName: cluster.Name, Error: cluster.Error}
+		if item.Error == "" {
+			spec := OnboardRequest{ClusterName: cluster.Name, Hub: req.Hub, Provider: cluster.Provider, Context: strings.TrimPrefix(cluster.KubeconfigRef, "context:")}
+			var task *batchTask
+			item, task = cp.submitBatchCluster(c.Request.Context(), spec, req.Labels)
+			item.Row = i + 1
+			if task != nil {
+				tasks = append(tasks, *task)
+			}
+		}
+		batch.Items = append(batch.Items, item)
+	}
+	cp.batches.Add(batch)
+	go cp.runBatch(batch.ID, tasks)
+
+	requestLogger(c).Info("Terraform state imported", "import", batch.ID, "clusters", len(clusters), "jobs", len(tasks))
+	response["message"] = message(c, MsgTerraformOnboarding, "count", len(clusters), "onboarding", len(tasks))
+	response["messageCode"] = MsgTerraformOnboarding
+	response["importId"] = batch.ID
+	response["items"] = batch.Items
+	c.JSON(http.StatusAccepted, response)
+}
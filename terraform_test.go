@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testKindKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: kind-dev
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: kind-dev
+  context:
+    cluster: kind-dev
+    user: kind-dev
+current-context: kind-dev
+users:
+- name: kind-dev
+  user:
+    token: secret
+`
+
+// testTerraformState returns a state holding an EKS cluster, a kind cluster,
+// a kubeconfig output and an unrelated output
+func testTerraformState(t *testing.T) string {
+	t.Helper()
+	state := map[string]interface{}{
+		"version":           4,
+		"terraform_version": "1.6.0",
+		"serial":            3,
+		"lineage":           "lineage",
+		"outputs": map[string]interface{}{
+			"edge_kubeconfig": map[string]interface{}{"value": strings.ReplaceAll(testKindKubeconfig, "kind-dev", "edge"), "sensitive": true},
+			"vpc_id":          map[string]interface{}{"value": "vpc-123"},
+		},
+		"resources": []interface{}{
+			map[string]interface{}{
+				"mode": "managed", "type": "aws_eks_cluster", "name": "prod",
+				"instances": []interface{}{map[string]interface{}{"attributes": map[string]interface{}{
+					"name": "prod-1", "arn": "arn:aws:eks:eu-west-1:123456789012:cluster/prod-1", "endpoint": "https://prod-1.eks.amazonaws.com",
+				}}},
+			},
+			map[string]interface{}{
+				"module": "module.dev", "mode": "managed", "type": "kind_cluster", "name": "this",
+				"instances": []interface{}{map[string]interface{}{"attributes": map[string]interface{}{
+					"name": "dev", "endpoint": "https://127.0.0.1:6443", "kubeconfig": testKindKubeconfig,
+				}}},
+			},
+			map[string]interface{}{
+				"mode": "data", "type": "aws_eks_cluster", "name": "existing",
+				"instances": []interface{}{map[string]interface{}{"attributes": map[string]interface{}{"name": "existing"}}},
+			},
+		},
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDiscoverTerraformClusters(t *testing.T) {
+	clusters, err := discoverTerraformClusters([]byte(testTerraformState(t)))
+	if err != nil {
+		t.Fatalf("discoverTerraformClusters() error = %v", err)
+	}
+	found := map[string]DiscoveredCluster{}
+	for _, cluster := range clusters {
+		found[cluster.Name] = cluster
+	}
+	if len(found) != 3 {
+		t.Fatalf("clusters = %+v", clusters)
+	}
+	if prod := found["prod-1"]; prod.Provider == nil || prod.Provider.Name != "eks" || prod.Provider.Region != "eu-west-1" || prod.Address != "aws_eks_cluster.prod" {
+		t.Errorf("prod-1 = %+v", prod)
+	}
+	if dev := found["dev"]; len(dev.kubeconfig) == 0 || dev.Provider != nil || dev.Address != "module.dev.kind_cluster.this" {
+		t.Errorf("dev = %+v", dev)
+	}
+	if edge := found["edge"]; len(edge.kubeconfig) == 0 || edge.Address != "output.edge_kubeconfig" || edge.Server != "https://127.0.0.1:6443" {
+		t.Errorf("edge = %+v", edge)
+	}
+
+	if _, err := discoverTerraformClusters([]byte(`{"version": 3}`)); err == nil {
+		t.Error("version 3 state accepted")
+	}
+}
+
+func TestImportTerraformHandler(t *testing.T) {
+	plugin, serve := reconcileRouter(t)
+
+	recorder := serve(http.MethodPost, "/clusters/import/terraform", `{"state": `+testTerraformState(t)+`}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("POST /clusters/import/terraform = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), "secret") {
+		t.Error("kubeconfig credentials are echoed")
+	}
+	for _, name := range []string{"dev", "edge"} {
+		data, err := plugin.kubeconfigs.Get(name)
+		if err != nil || !strings.Contains(string(data), "current-context: "+name) {
+			t.Errorf("stored context %s = %s, %v", name, data, err)
+		}
+	}
+	if _, exists, _ := plugin.store.Get("dev"); exists {
+		t.Error("clusters onboarded without onboard")
+	}
+
+	recorder = serve(http.MethodPost, "/clusters/import/terraform", `{"state": `+testTerraformState(t)+`, "onboard": true, "labels": {"source": "terraform"}}`)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("POST /clusters/import/terraform with onboard = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		ImportID string      `json:"importId"`
+		Items    []BatchItem `json:"items"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if response.ImportID == "" || len(response.Items) != 3 {
+		t.Fatalf("response = %s", recorder.Body.String())
+	}
+	if recorder := serve(http.MethodGet, "/clusters/import/"+response.ImportID+"/report?format=json", ""); recorder.Code != http.StatusOK {
+		t.Errorf("import report = %d", recorder.Code)
+	}
+
+	for name, body := range map[string]string{
+		"nothing":      `{}`,
+		"both":         `{"state": {}, "backend": {"type": "http", "address": "https://example.com/state"}}`,
+		"backend type": `{"backend": {"type": "gcs", "bucket": "b"}}`,
+		"s3 key":       `{"backend": {"type": "s3", "bucket": "b"}}`,
+		"http scheme":  `{"backend": {"type": "http", "address": "file:///etc/state"}}`,
+		"old state":    `{"state": {"version": 3}}`,
+	} {
+		if recorder := serve(http.MethodPost, "/clusters/import/terraform", body); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", name, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestReadTerraformBackend(t *testing.T) {
+	plugin := newTestPlugin(t)
+	state := testTerraformState(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "terraform" || password != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(state))
+	}))
+	defer backend.Close()
+	data, err := plugin.readTerraformBackend(context.Background(), TerraformBackend{Type: "http", Address: backend.URL, Username: "terraform", Password: "pass"})
+	if err != nil || string(data) != state {
+		t.Errorf("http backend = %q, %v", data, err)
+	}
+	if _, err := plugin.readTerraformBackend(context.Background(), TerraformBackend{Type: "http", Address: backend.URL}); err == nil {
+		t.Error("unauthorized read succeeded")
+	}
+
+	var cmd string
+	original := runProviderCommand
+	defer func() { runProviderCommand = original }()
+	runProviderCommand = func(_ context.Context, _ []string, name string, args ...string) ([]byte, error) {
+		cmd = strings.Join(append([]string{name}, args...), " ")
+		return []byte(state), nil
+	}
+	data, err = plugin.readTerraformBackend(context.Background(), TerraformBackend{Type: "s3", Bucket: "states", Key: "/fleet/terraform.tfstate", Region: "eu-west-1"})
+	if err != nil || string(data) != state {
+		t.Errorf("s3 backend = %q, %v", data, err)
+	}
+	if cmd != "aws s3 cp s3://states/fleet/terraform.tfstate - --region eu-west-1" {
+		t.Errorf("command = %q", cmd)
+	}
+}
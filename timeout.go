@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusClientClosedRequest is logged for requests whose client went away
+// before an answer was ready; nothing is sent back
+const statusClientClosedRequest = 499
+
+// defaultRequestTimeoutSeconds bounds requests when no timeout is configured
+const defaultRequestTimeoutSeconds = 60
+
+// RequestTimeoutConfig is the requestTimeout section of the Initialize config.
+// Streaming endpoints are only bounded when listed in Endpoints.
+type RequestTimeoutConfig struct {
+	// DefaultSeconds bounds every request; 0 leaves requests unbounded
+	DefaultSeconds int `json:"defaultSeconds"`
+	// Endpoints overrides the default per handler name; 0 leaves the
+	// endpoint unbounded
+	Endpoints map[string]int `json:"endpoints,omitempty"`
+}
+
+// Validate checks the timeouts and that endpoint timeouts name known handlers
+func (tc RequestTimeoutConfig) Validate(metadata PluginMetadata) error {
+	if tc.DefaultSeconds < 0 {
+		return fmt.Errorf("defaultSeconds must not be negative")
+	}
+	handlers := make(map[string]bool, len(metadata.Endpoints))
+	for _, endpoint := range metadata.Endpoints {
+		handlers[endpoint.Handler] = true
+	}
+	for handler, seconds := range tc.Endpoints {
+		if !handlers[handler] {
+			return fmt.Errorf("endpoints: no endpoint is served by %s", handler)
+		}
+		if seconds < 0 {
+			return fmt.Errorf("endpoints.%s: timeout must not be negative", handler)
+		}
+	}
+	return nil
+}
+
+// timeout returns how long a request to handlerName may take, 0 if unbounded
+func (tc RequestTimeoutConfig) timeout(handlerName string) time.Duration {
+	if seconds, ok := tc.Endpoints[handlerName]; ok {
+		return time.Duration(seconds) * time.Second
+	}
+	if doc := endpointDocs[handlerName]; doc.upgrade || doc.contentType == "text/event-stream" {
+		return 0
+	}
+	return time.Duration(tc.DefaultSeconds) * time.Second
+}
+
+func requestTimeoutConfigFromConfig(config map[string]interface{}) (RequestTimeoutConfig, error) {
+	tc := RequestTimeoutConfig{DefaultSeconds: defaultRequestTimeoutSeconds}
+	raw, ok := config["requestTimeout"]
+	if !ok {
+		return tc, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return tc, fmt.Errorf("invalid requestTimeout config: %w", err)
+	}
+	if err := json.Unmarshal(data, &tc); err != nil {
+		return tc, fmt.Errorf("invalid requestTimeout config: %w", err)
+	}
+	return tc, nil
+}
+
+// withTimeout bounds the request context by the endpoint timeout. Handlers
+// that give up on the deadline without answering get a 504 Gateway Timeout.
+func (cp *ClusterPlugin) withTimeout(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		timeout := cp.requestTimeouts.timeout(handlerName)
+		cp.mutex.RUnlock()
+		if timeout <= 0 {
+			handler(c)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		handler(c)
+		if !c.Writer.Written() {
+			cp.abortOnContext(c)
+		}
+	}
+}
+
+// abortOnContext answers a request whose context ended, reporting whether it
+// did. Requests past their deadline get a 504 Gateway Timeout; those whose
+// client disconnected are dropped.
+func (cp *ClusterPlugin) abortOnContext(c *gin.Context) bool {
+	ctx := c.Request.Context()
+	switch err := ctx.Err(); {
+	case err == nil:
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		requestLogger(c).Warn("Request timed out", "method", c.Request.Method, "path", c.Request.URL.Path)
+		abortWithProblem(c, http.StatusGatewayTimeout, CodeTimeout, "Request timed out before the operation completed")
+	default:
+		requestLogger(c).Info("Client disconnected", "method", c.Request.Method, "path", c.Request.URL.Path)
+		c.AbortWithStatus(statusClientClosedRequest)
+	}
+	return true
+}
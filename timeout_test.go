@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestTimeoutConfig(t *testing.T) {
+	metadata := (&ClusterPlugin{}).GetMetadata()
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		handler string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default", handler: "GetClusterStatusHandler", want: defaultRequestTimeoutSeconds * time.Second},
+		{name: "streams are unbounded", handler: "StreamClusterStatusHandler"},
+		{
+			name:    "endpoint override",
+			config:  map[string]interface{}{"defaultSeconds": 10, "endpoints": map[string]interface{}{"VerifyClusterHandler": 120}},
+			handler: "VerifyClusterHandler",
+			want:    120 * time.Second,
+		},
+		{
+			name:    "endpoint disabled",
+			config:  map[string]interface{}{"defaultSeconds": 10, "endpoints": map[string]interface{}{"OnboardClusterHandler": 0}},
+			handler: "OnboardClusterHandler",
+		},
+		{name: "no default", config: map[string]interface{}{"defaultSeconds": 0}, handler: "GetClusterStatusHandler"},
+		{name: "negative default", config: map[string]interface{}{"defaultSeconds": -1}, wantErr: true},
+		{name: "unknown handler", config: map[string]interface{}{"endpoints": map[string]interface{}{"NoSuchHandler": 5}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{}
+			if tt.config != nil {
+				config["requestTimeout"] = tt.config
+			}
+			tc, err := requestTimeoutConfigFromConfig(config)
+			if err == nil {
+				err = tc.Validate(metadata)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("config error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := tc.timeout(tt.handler); !tt.wantErr && got != tt.want {
+				t.Errorf("timeout(%s) = %v, want %v", tt.handler, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{
+		"requestTimeout": map[string]interface{}{"defaultSeconds": 0, "endpoints": map[string]interface{}{"VerifyClusterHandler": 1}},
+	})
+	// A handler that waits for its context without answering
+	blocking := func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	}
+	router := gin.New()
+	router.GET("/bounded", plugin.withTimeout("VerifyClusterHandler", blocking))
+	router.GET("/disconnect", plugin.withTimeout("GetClusterStatusHandler", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		if !plugin.abortOnContext(c) {
+			c.Status(http.StatusOK)
+		}
+	}))
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/bounded", nil))
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("request past its deadline = %d, want %d", recorder.Code, http.StatusGatewayTimeout)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/disconnect", nil).WithContext(ctx))
+	if recorder.Code != statusClientClosedRequest || recorder.Body.Len() != 0 {
+		t.Errorf("request of a disconnected client = %d with body %q", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// errBootstrapTokenGone is returned for a bootstrap token whose Secret was
+// removed from the hub, which revokes it
+var errBootstrapTokenGone = errors.New("bootstrap token no longer exists on the hub")
+
+// IssuedToken is the join token last handed to a cluster. The token itself
+// is never kept, only what identifies it and when it expires.
+type IssuedToken struct {
+	ClusterName string `json:"clusterName"`
+	Hub         string `json:"hub"`
+	// ID is the id of a bootstrap token, or a digest of a service account token
+	ID       string `json:"id"`
+	IssuedAt string `json:"issuedAt"`
+	// ExpiresAt is empty when the hub doesn't tell when the token expires
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	// Rotations counts the tokens issued to the cluster before this one
+	Rotations int `json:"rotations"`
+
+	expiresAt time.Time
+}
+
+// TokenManager tracks the join tokens issued to clusters so that pending
+// clusters can be warned about before their token expires
+type TokenManager struct {
+	tokens map[string]IssuedToken
+	// warned holds the token each cluster was last warned about, so a
+	// warning goes out once per token
+	warned map[string]string
+	mutex  sync.Mutex
+}
+
+func NewTokenManager() *TokenManager {
+	return &TokenManager{tokens: make(map[string]IssuedToken), warned: make(map[string]string)}
+}
+
+// Record stores the token issued to a cluster, replacing the previous one
+func (tm *TokenManager) Record(token IssuedToken) IssuedToken {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	if previous, exists := tm.tokens[token.ClusterName]; exists {
+		token.Rotations = previous.Rotations + 1
+	}
+	if !token.expiresAt.IsZero() {
+		token.ExpiresAt = token.expiresAt.UTC().Format(time.RFC3339)
+	}
+	tm.tokens[token.ClusterName] = token
+	return token
+}
+
+// Get returns the token last issued to a cluster
+func (tm *TokenManager) Get(clusterName string) (IssuedToken, bool) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	token, exists := tm.tokens[clusterName]
+	return token, exists
+}
+
+// Forget drops the token of a cluster that left the plugin
+func (tm *TokenManager) Forget(clusterName string) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	delete(tm.tokens, clusterName)
+	delete(tm.warned, clusterName)
+}
+
+// ExpiringBefore returns the tokens that expire before deadline, sorted by expiry
+func (tm *TokenManager) ExpiringBefore(deadline time.Time) []IssuedToken {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	var expiring []IssuedToken
+	for _, token := range tm.tokens {
+		if !token.expiresAt.IsZero() && token.expiresAt.Before(deadline) {
+			expiring = append(expiring, token)
+		}
+	}
+	sort.Slice(expiring, func(i, j int) bool {
+		return expiring[i].expiresAt.Before(expiring[j].expiresAt)
+	})
+	return expiring
+}
+
+// markWarned reports whether the cluster wasn't warned about token yet and
+// remembers that it now is
+func (tm *TokenManager) markWarned(token IssuedToken) bool {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	if tm.warned[token.ClusterName] == token.ID {
+		return false
+	}
+	tm.warned[token.ClusterName] = token.ID
+	return true
+}
+
+// hubToken returns the --hub-token of a join command printed by clusteradm get token
+func hubToken(joinCommand string) string {
+	fields := strings.Fields(joinCommand)
+	for i, field := range fields {
+		if field == "--hub-token" && i+1 < len(fields) {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+// tokenID identifies a token without revealing it
+func tokenID(token string) string {
+	if match := bootstrapTokenPattern.FindStringSubmatch(token); match != nil {
+		return match[1]
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// joinTokenExpiry returns when a join token expires: a bootstrap token by its
+// Secret on the hub, a service account token by its exp claim. The zero time
+// means the expiry can't be told.
+func joinTokenExpiry(ctx context.Context, hub kubernetes.Interface, token string) (time.Time, error) {
+	if match := bootstrapTokenPattern.FindStringSubmatch(token); match != nil {
+		secret, err := hub.CoreV1().Secrets("kube-system").Get(ctx, "bootstrap-token-"+match[1], metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return time.Time{}, errBootstrapTokenGone
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		expiration, err := time.Parse(time.RFC3339, string(secret.Data["expiration"]))
+		if err != nil {
+			return time.Time{}, nil
+		}
+		return expiration, nil
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, nil
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(claims.Exp, 0).UTC(), nil
+}
+
+// recordJoinToken tracks the token of a join command issued to a cluster by
+// hub. An unknown expiry doesn't stop the onboarding, it only means there is
+// nothing to warn about.
+func (cp *ClusterPlugin) recordJoinToken(ctx context.Context, clusterName string, hub Hub, joinCommand string) IssuedToken {
+	token := hubToken(joinCommand)
+	issued := IssuedToken{
+		ClusterName: clusterName,
+		Hub:         hub.Name,
+		ID:          tokenID(token),
+		IssuedAt:    time.Now().Format(time.RFC3339),
+	}
+	if hubClient, _, err := hub.clientset(); err != nil {
+		logger().Warn("Failed to connect to hub to read join token expiry", "cluster", clusterName, "hub", hub.Name, "error", err)
+	} else if issued.expiresAt, err = joinTokenExpiry(ctx, hubClient, token); err != nil {
+		logger().Warn("Failed to read join token expiry", "cluster", clusterName, "hub", hub.Name, "error", err)
+	}
+	return cp.tokens.Record(issued)
+}
+
+// replaceBootstrapToken swaps the token of the klusterlet's bootstrap
+// kubeconfig on the spoke. It reports false when the klusterlet hasn't been
+// applied yet, so there is nothing to replace.
+func replaceBootstrapToken(ctx context.Context, spoke kubernetes.Interface, token string) (bool, error) {
+	secrets := spoke.CoreV1().Secrets(agentNamespace)
+	secret, err := secrets.Get(ctx, "bootstrap-hub-kubeconfig", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read bootstrap kubeconfig: %w", err)
+	}
+	config, err := clientcmd.Load(secret.Data["kubeconfig"])
+	if err != nil {
+		return false, fmt.Errorf("invalid bootstrap kubeconfig: %w", err)
+	}
+	kubeContext, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return false, fmt.Errorf("bootstrap kubeconfig has no current context")
+	}
+	auth, ok := config.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		return false, fmt.Errorf("bootstrap kubeconfig has no user for context %s", config.CurrentContext)
+	}
+	auth.Token = token
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode bootstrap kubeconfig: %w", err)
+	}
+	secret.Data["kubeconfig"] = data
+	if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return false, fmt.Errorf("failed to update bootstrap kubeconfig: %w", err)
+	}
+	return true, nil
+}
+
+// checkTokens warns once per token about pending clusters whose join token
+// expires within tokenWarning, or already has
+func (cp *ClusterPlugin) checkTokens(context.Context) {
+	now := time.Now()
+	for _, token := range cp.tokens.ExpiringBefore(now.Add(cp.tokenWarning)) {
+		cp.mutex.RLock()
+		record, exists, err := cp.store.Get(token.ClusterName)
+		cp.mutex.RUnlock()
+		if err != nil || !exists || record.Status == "Ready" || !cp.tokens.markWarned(token) {
+			continue
+		}
+		message := fmt.Sprintf("Join token %s expires at %s", token.ID, token.ExpiresAt)
+		if !token.expiresAt.After(now) {
+			message = fmt.Sprintf("Join token %s expired at %s", token.ID, token.ExpiresAt)
+		}
+		logger().Warn("Join token expiring", "cluster", token.ClusterName, "token", token.ID, "expiresAt", token.ExpiresAt)
+		cp.notify(eventClusterTokenExpiring, token.ClusterName, record.JobID, message)
+	}
+}
+
+// tokenHealth reports the plugin degraded while pending clusters hold join
+// tokens about to expire. The caller must hold the mutex.
+func (cp *ClusterPlugin) tokenHealth() ComponentHealth {
+	component := ComponentHealth{Name: "tokens", State: HealthHealthy}
+	var expiring []string
+	for _, token := range cp.tokens.ExpiringBefore(time.Now().Add(cp.tokenWarning)) {
+		if record, exists, err := cp.store.Get(token.ClusterName); err == nil && exists && record.Status != "Ready" {
+			expiring = append(expiring, token.ClusterName)
+		}
+	}
+	component.Details = map[string]interface{}{"expiring": len(expiring)}
+	if len(expiring) > 0 {
+		component.State = HealthDegraded
+		component.Message = "join tokens of pending clusters expire soon: " + strings.Join(expiring, ", ")
+	}
+	return component
+}
+
+// GetClusterTokenHandler returns the join token last issued to a cluster,
+// without the token itself
+func (cp *ClusterPlugin) GetClusterTokenHandler(c *gin.Context) {
+	clusterName := c.Param("name")
+	token, exists := cp.tokens.Get(clusterName)
+	if !exists {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("No join token was issued to cluster '%s'", clusterName))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":     token,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// RotateClusterTokenHandler issues a fresh join token to a cluster that
+// hasn't joined yet and, once its klusterlet is applied, hands the token to
+// it through the bootstrap kubeconfig on the spoke
+func (cp *ClusterPlugin) RotateClusterTokenHandler(c *gin.Context) {
+	clusterName := c.Param("name")
+
+	cp.mutex.RLock()
+	record, exists, err := cp.store.Get(clusterName)
+	cp.mutex.RUnlock()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if !exists {
+		respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin", clusterName))
+		return
+	}
+	if record.Status == "Ready" || record.Status == statusUnreachable {
+		respondProblem(c, http.StatusConflict, CodeConflict, fmt.Sprintf("Cluster '%s' has joined its hub and no longer uses a join token", clusterName))
+		return
+	}
+	hub, err := cp.clusterHub(clusterName)
+	if err != nil {
+		respondProblem(c, http.StatusBadGateway, CodeHubUnreachable, fmt.Sprintf("Failed to resolve hub: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Minute)
+	defer cancel()
+	joinCommand, err := cp.getClusterAdmToken(ctx, hub)
+	if err != nil {
+		respondProblem(c, http.StatusBadGateway, CodeHubUnreachable, err.Error())
+		return
+	}
+	token := cp.recordJoinToken(ctx, clusterName, hub, joinCommand)
+
+	// A klusterlet waiting on the hub picks the new token up from its bootstrap kubeconfig
+	var delivered bool
+	if kubeconfigData := cp.savedKubeconfig(clusterName); len(kubeconfigData) > 0 {
+		spokeConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigData)
+		if err == nil {
+			var spoke *kubernetes.Clientset
+			if spoke, err = kubernetes.NewForConfig(spokeConfig); err == nil {
+				delivered, err = replaceBootstrapToken(ctx, spoke, hubToken(joinCommand))
+			}
+		}
+		if err != nil {
+			respondProblem(c, http.StatusBadGateway, CodeClusterUnreachable, fmt.Sprintf("Issued join token %s but failed to hand it to the cluster: %v", token.ID, err))
+			return
+		}
+	}
+	requestLogger(c).Info("Rotated join token", "cluster", clusterName, "token", token.ID, "delivered", delivered)
+	cp.logs.Append(clusterName, "info", fmt.Sprintf("Join token rotated to %s", token.ID))
+
+	c.JSON(http.StatusOK, TokenRotateResponse{
+		Message:     message(c, MsgTokenRotated, "cluster", clusterName),
+		MessageCode: MsgTokenRotated,
+		ClusterName: clusterName,
+		Token:       token,
+		Delivered:   delivered,
+		Plugin:      "kubestellar-cluster-plugin",
+		Timestamp:   time.Now().Format(time.RFC3339),
+	})
+}
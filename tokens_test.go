@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func TestTokenManager(t *testing.T) {
+	tokens := NewTokenManager()
+	now := time.Now()
+	tokens.Record(IssuedToken{ClusterName: "edge-1", ID: "aaaaaa", expiresAt: now.Add(time.Hour)})
+	tokens.Record(IssuedToken{ClusterName: "edge-2", ID: "bbbbbb", expiresAt: now.Add(5 * time.Minute)})
+	tokens.Record(IssuedToken{ClusterName: "edge-3", ID: "sha256:0a1b2c3d4e5f"})
+
+	rotated := tokens.Record(IssuedToken{ClusterName: "edge-1", ID: "cccccc", expiresAt: now.Add(-time.Minute)})
+	if rotated.Rotations != 1 || rotated.ExpiresAt == "" {
+		t.Errorf("rotated token = %+v, want one rotation and an expiry", rotated)
+	}
+
+	expiring := tokens.ExpiringBefore(now.Add(10 * time.Minute))
+	if len(expiring) != 2 || expiring[0].ClusterName != "edge-1" || expiring[1].ClusterName != "edge-2" {
+		t.Fatalf("ExpiringBefore() = %+v, want edge-1 then edge-2", expiring)
+	}
+	if !tokens.markWarned(expiring[0]) || tokens.markWarned(expiring[0]) {
+		t.Error("markWarned() should report the first warning about a token only")
+	}
+
+	tokens.Forget("edge-1")
+	if _, exists := tokens.Get("edge-1"); exists {
+		t.Error("Get() found a forgotten token")
+	}
+	if token, exists := tokens.Get("edge-3"); !exists || token.ExpiresAt != "" {
+		t.Errorf("Get(edge-3) = %+v, %v, want a token without expiry", token, exists)
+	}
+}
+
+func TestJoinTokenExpiry(t *testing.T) {
+	expiration := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	hub := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "bootstrap-token-abcdef"},
+		Data:       map[string][]byte{"expiration": []byte(expiration.Format(time.RFC3339))},
+	})
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp": %d}`, expiration.Unix())))
+
+	tests := []struct {
+		name    string
+		token   string
+		want    time.Time
+		wantErr error
+	}{
+		{name: "bootstrap token", token: "abcdef.0123456789abcdef", want: expiration},
+		{name: "revoked bootstrap token", token: "zyxwvu.0123456789abcdef", wantErr: errBootstrapTokenGone},
+		{name: "service account token", token: "e30." + claims + ".signature", want: expiration},
+		{name: "opaque token", token: "opaque"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := joinTokenExpiry(context.Background(), hub, tt.token)
+			if !errors.Is(err, tt.wantErr) || !got.Equal(tt.want) {
+				t.Errorf("joinTokenExpiry() = %v, %v, want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	if got := hubToken("clusteradm join --hub-token abcdef.0123456789abcdef --hub-apiserver https://hub:6443"); got != "abcdef.0123456789abcdef" {
+		t.Errorf("hubToken() = %q", got)
+	}
+	if got := tokenID("abcdef.0123456789abcdef"); got != "abcdef" {
+		t.Errorf("tokenID() of a bootstrap token = %q, want its id", got)
+	}
+}
+
+func TestReplaceBootstrapToken(t *testing.T) {
+	spoke := fake.NewSimpleClientset()
+	if delivered, err := replaceBootstrapToken(context.Background(), spoke, "new-token"); err != nil || delivered {
+		t.Fatalf("replaceBootstrapToken() without a klusterlet = %v, %v, want false, nil", delivered, err)
+	}
+
+	spoke = fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: agentNamespace, Name: "bootstrap-hub-kubeconfig"},
+		Data:       map[string][]byte{"kubeconfig": []byte(testHubKubeconfig)},
+	})
+	if delivered, err := replaceBootstrapToken(context.Background(), spoke, "new-token"); err != nil || !delivered {
+		t.Fatalf("replaceBootstrapToken() = %v, %v, want true, nil", delivered, err)
+	}
+	secret, _ := spoke.CoreV1().Secrets(agentNamespace).Get(context.Background(), "bootstrap-hub-kubeconfig", metav1.GetOptions{})
+	config, err := clientcmd.Load(secret.Data["kubeconfig"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.AuthInfos["admin"].Token; got != "new-token" {
+		t.Errorf("bootstrap token = %q, want new-token", got)
+	}
+	if got := config.Clusters["wds2"].Server; got != "https://wds2.example.com:6443" {
+		t.Errorf("hub server = %q, want it kept", got)
+	}
+}
+
+func TestCheckTokens(t *testing.T) {
+	plugin := newTestPlugin(t)
+	sink := &recordingSink{}
+	plugin.events.Subscribe("test", sink, []string{eventClusterTokenExpiring})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Joining"})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-2", Status: "Ready"})
+	soon := time.Now().Add(time.Minute)
+	plugin.tokens.Record(IssuedToken{ClusterName: "edge-1", ID: "aaaaaa", expiresAt: soon})
+	plugin.tokens.Record(IssuedToken{ClusterName: "edge-2", ID: "bbbbbb", expiresAt: soon})
+
+	if component := plugin.tokenHealth(); component.State != HealthDegraded {
+		t.Errorf("tokenHealth() = %+v, want degraded", component)
+	}
+	plugin.checkTokens(context.Background())
+	plugin.checkTokens(context.Background())
+	plugin.events.Close(time.Second)
+
+	if len(sink.events) != 1 || sink.events[0].ClusterName != "edge-1" || !sink.events[0].Warning() {
+		t.Errorf("events = %+v, want one warning about edge-1", sink.events)
+	}
+}
+
+func TestRotateClusterTokenHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	router := gin.New()
+	router.POST("/clusters/:name/token/rotate", plugin.RotateClusterTokenHandler)
+	router.GET("/clusters/:name/token", plugin.GetClusterTokenHandler)
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{method: http.MethodPost, path: "/clusters/edge-9/token/rotate", want: http.StatusNotFound},
+		// A joined cluster authenticates with its own credentials
+		{method: http.MethodPost, path: "/clusters/edge-1/token/rotate", want: http.StatusConflict},
+		{method: http.MethodGet, path: "/clusters/edge-1/token", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, nil))
+		if recorder.Code != tt.want {
+			t.Errorf("%s %s status = %d, want %d, body %s", tt.method, tt.path, recorder.Code, tt.want, recorder.Body)
+		}
+	}
+
+	plugin.tokens.Record(IssuedToken{ClusterName: "edge-1", ID: "aaaaaa"})
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/clusters/edge-1/token", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("GET /clusters/edge-1/token status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+}
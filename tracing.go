@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/ansh7432/pluginv2"
+
+// tracePropagator reads the W3C trace context and baggage sent by the host
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// newTracer builds the plugin tracer from the Initialize config. Tracing is off
+// unless tracingEnabled is set, in which case spans are exported over OTLP/HTTP
+// to otlpEndpoint (host:port, otlpInsecure for plain HTTP) with
+// tracingSampleRatio of new traces sampled. The plugin keeps its own provider
+// rather than the global one so it never changes the host's tracing setup.
+func newTracer(config map[string]interface{}, version string) (trace.Tracer, func(context.Context) error, error) {
+	if !configBool(config, "tracingEnabled", false) {
+		return noop.NewTracerProvider().Tracer(tracerName), func(context.Context) error { return nil }, nil
+	}
+
+	ratio := configFloat(config, "tracingSampleRatio", 1)
+	if ratio < 0 || ratio > 1 {
+		return nil, nil, fmt.Errorf("tracingSampleRatio must be between 0 and 1, got %v", ratio)
+	}
+
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(configString(config, "otlpEndpoint", "localhost:4318")),
+	}
+	if configBool(config, "otlpInsecure", false) {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "kubestellar-cluster-plugin"),
+			attribute.String("service.version", version),
+		)),
+	)
+	return provider.Tracer(tracerName), provider.Shutdown, nil
+}
+
+// withTracing wraps a handler in a server span, continuing the trace the host
+// propagated in the request headers
+func (cp *ClusterPlugin) withTracing(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		tracer := cp.tracer
+		cp.mutex.RUnlock()
+		if tracer == nil {
+			handler(c)
+			return
+		}
+
+		ctx := tracePropagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := tracer.Start(ctx, handlerName,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", c.FullPath()),
+				attribute.String("request.id", requestID(c)),
+			),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		handler(c)
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// phaseTracker turns the steps of a job into consecutive child spans of the
+// job span: each step ends the previous one
+type phaseTracker struct {
+	tracer  trace.Tracer
+	parent  context.Context
+	current trace.Span
+	mutex   sync.Mutex
+}
+
+type phaseTrackerKey struct{}
+
+func withPhaseTracker(ctx context.Context, tracer trace.Tracer) (context.Context, *phaseTracker) {
+	tracker := &phaseTracker{tracer: tracer, parent: ctx}
+	return context.WithValue(ctx, phaseTrackerKey{}, tracker), tracker
+}
+
+// startPhase ends the running phase span of the job in ctx and starts the next
+func startPhase(ctx context.Context, name string, attributes ...attribute.KeyValue) {
+	tracker, ok := ctx.Value(phaseTrackerKey{}).(*phaseTracker)
+	if !ok {
+		return
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.current != nil {
+		tracker.current.End()
+	}
+	_, tracker.current = tracker.tracer.Start(tracker.parent, name, trace.WithAttributes(attributes...))
+}
+
+// end closes the last phase span, recording err on it
+func (t *phaseTracker) end(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.current == nil {
+		return
+	}
+	if err != nil {
+		t.current.RecordError(err)
+		t.current.SetStatus(codes.Error, err.Error())
+	}
+	t.current.End()
+	t.current = nil
+}
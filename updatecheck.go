@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// maxArtifactSize bounds a downloaded release artifact
+const maxArtifactSize = 512 << 20
+
+// UpdateCheckConfig is the updateCheck section of the Initialize config. The
+// check is disabled unless a URL is given.
+type UpdateCheckConfig struct {
+	// URL returns the latest release, either as a GitHub releases API
+	// response (.../releases/latest) or as a registry document with version,
+	// artifactUrl and sha256 fields
+	URL string `json:"url"`
+	// IntervalSeconds is the time between checks, 6 hours by default
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+	// Download fetches the artifact of a newer release into DownloadDir so
+	// that the host can load it
+	Download    bool   `json:"download,omitempty"`
+	DownloadDir string `json:"downloadDir,omitempty"`
+	// Asset names the GitHub release asset to download, defaulting to the
+	// first .so asset
+	Asset string `json:"asset,omitempty"`
+	// TokenEnv names the environment variable holding a bearer token for
+	// private registries
+	TokenEnv string `json:"tokenEnv,omitempty"`
+}
+
+// Validate checks the URL and interval
+func (uc UpdateCheckConfig) Validate() error {
+	parsed, err := url.Parse(uc.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if uc.IntervalSeconds < 0 {
+		return fmt.Errorf("intervalSeconds must not be negative")
+	}
+	return nil
+}
+
+// updateCheckConfigFromConfig reads the updateCheck section, reporting false
+// when it is absent
+func updateCheckConfigFromConfig(config map[string]interface{}, downloadDir string) (UpdateCheckConfig, bool, error) {
+	var uc UpdateCheckConfig
+	raw, ok := config["updateCheck"]
+	if !ok {
+		return uc, false, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return uc, false, fmt.Errorf("invalid updateCheck config: %w", err)
+	}
+	if err := json.Unmarshal(data, &uc); err != nil {
+		return uc, false, fmt.Errorf("invalid updateCheck config: %w", err)
+	}
+	if err := uc.Validate(); err != nil {
+		return uc, false, fmt.Errorf("invalid updateCheck config: %w", err)
+	}
+	if uc.IntervalSeconds == 0 {
+		uc.IntervalSeconds = 6 * 60 * 60
+	}
+	if uc.DownloadDir == "" {
+		uc.DownloadDir = downloadDir
+	}
+	return uc, true, nil
+}
+
+// UpdateStatus is the outcome of the last update check
+type UpdateStatus struct {
+	CurrentVersion  string `json:"currentVersion"`
+	LatestVersion   string `json:"latestVersion,omitempty"`
+	UpdateAvailable bool   `json:"updateAvailable"`
+	ReleaseURL      string `json:"releaseUrl,omitempty"`
+	CheckedAt       string `json:"checkedAt,omitempty"`
+	Error           string `json:"error,omitempty"`
+	// ArtifactPath is where the downloaded artifact of the latest release
+	// waits for the host to load it
+	ArtifactPath   string `json:"artifactPath,omitempty"`
+	ArtifactSHA256 string `json:"artifactSha256,omitempty"`
+}
+
+// releaseInfo is the latest release as served by GitHub or a plugin registry
+type releaseInfo struct {
+	// GitHub releases API fields
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+	Assets  []struct {
+		Name               string `json:"name"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+	} `json:"assets"`
+	// Registry fields
+	Version     string `json:"version"`
+	URL         string `json:"url"`
+	ArtifactURL string `json:"artifactUrl"`
+	SHA256      string `json:"sha256"`
+}
+
+// latest returns the release version, its page and the artifact to download
+func (r releaseInfo) latest(asset string) (string, string, string) {
+	if r.Version != "" {
+		return r.Version, r.URL, r.ArtifactURL
+	}
+	artifact := ""
+	for _, a := range r.Assets {
+		if (asset != "" && a.Name == asset) || (asset == "" && strings.HasSuffix(a.Name, ".so")) {
+			artifact = a.BrowserDownloadURL
+			break
+		}
+	}
+	return r.TagName, r.HTMLURL, artifact
+}
+
+// updateChecker periodically compares the running version with the latest
+// release, optionally downloading it
+type updateChecker struct {
+	config  UpdateCheckConfig
+	current string
+	client  *http.Client
+	// onUpdate is called once for every newer release found
+	onUpdate func(UpdateStatus)
+
+	status   UpdateStatus
+	notified string
+	cancel   context.CancelFunc
+	done     chan struct{}
+	mutex    sync.RWMutex
+}
+
+func newUpdateChecker(config UpdateCheckConfig, current string) *updateChecker {
+	return &updateChecker{
+		config:  config,
+		current: current,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		status:  UpdateStatus{CurrentVersion: current},
+	}
+}
+
+// Start checks right away and then every interval until Stop is called
+func (uc *updateChecker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	uc.cancel = cancel
+	uc.done = make(chan struct{})
+
+	go func() {
+		defer close(uc.done)
+		ticker := time.NewTicker(time.Duration(uc.config.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			uc.check(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends the checks and waits for a running one to finish
+func (uc *updateChecker) Stop() {
+	if uc.cancel == nil {
+		return
+	}
+	uc.cancel()
+	<-uc.done
+}
+
+// Status returns the outcome of the last check
+func (uc *updateChecker) Status() UpdateStatus {
+	uc.mutex.RLock()
+	defer uc.mutex.RUnlock()
+	return uc.status
+}
+
+// check fetches the latest release and records whether it is newer
+func (uc *updateChecker) check(ctx context.Context) {
+	status := UpdateStatus{CurrentVersion: uc.current, CheckedAt: time.Now().Format(time.RFC3339)}
+	err := uc.compare(ctx, &status)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		logger().Warn("Plugin update check failed", "url", uc.config.URL, "error", err)
+		status.Error = err.Error()
+		// Keep what the last check found when the release couldn't be read
+		if status.LatestVersion == "" {
+			previous := uc.Status()
+			status.LatestVersion = previous.LatestVersion
+			status.UpdateAvailable = previous.UpdateAvailable
+			status.ReleaseURL = previous.ReleaseURL
+			status.ArtifactPath = previous.ArtifactPath
+			status.ArtifactSHA256 = previous.ArtifactSHA256
+		}
+	}
+
+	uc.mutex.Lock()
+	uc.status = status
+	// A release is announced once it is fully available, artifact included
+	notify := err == nil && status.UpdateAvailable && uc.notified != status.LatestVersion
+	if notify {
+		uc.notified = status.LatestVersion
+	}
+	uc.mutex.Unlock()
+
+	if notify {
+		logger().Info("Plugin update available", "current", status.CurrentVersion, "latest", status.LatestVersion, "artifact", status.ArtifactPath)
+		if uc.onUpdate != nil {
+			uc.onUpdate(status)
+		}
+	}
+}
+
+// compare fills status from the latest release, downloading its artifact
+// when it is newer and downloads are enabled
+func (uc *updateChecker) compare(ctx context.Context, status *UpdateStatus) error {
+	current, err := version.ParseSemantic(uc.current)
+	if err != nil {
+		return fmt.Errorf("running version %q is not a semantic version: %w", uc.current, err)
+	}
+	body, err := uc.get(ctx, uc.config.URL)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	var release releaseInfo
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&release); err != nil {
+		return fmt.Errorf("failed to decode release: %w", err)
+	}
+
+	latestVersion, releaseURL, artifactURL := release.latest(uc.config.Asset)
+	latest, err := version.ParseSemantic(latestVersion)
+	if err != nil {
+		return fmt.Errorf("latest version %q is not a semantic version: %w", latestVersion, err)
+	}
+	status.LatestVersion = latestVersion
+	status.ReleaseURL = releaseURL
+	status.UpdateAvailable = current.LessThan(latest)
+	if !status.UpdateAvailable || !uc.config.Download {
+		return nil
+	}
+	if artifactURL == "" {
+		return fmt.Errorf("release %s has no artifact to download", latestVersion)
+	}
+	status.ArtifactPath, status.ArtifactSHA256, err = uc.download(ctx, artifactURL, latestVersion, release.SHA256)
+	return err
+}
+
+// download saves a release artifact once per version, checking it against
+// the expected digest when the registry provides one
+func (uc *updateChecker) download(ctx context.Context, artifactURL, release, expected string) (string, string, error) {
+	name := path.Base(artifactURL)
+	if parsed, err := url.Parse(artifactURL); err == nil {
+		name = path.Base(parsed.Path)
+	}
+	target := filepath.Join(uc.config.DownloadDir, strings.TrimPrefix(release, "v"), name)
+	if data, err := os.ReadFile(target); err == nil {
+		sum := sha256.Sum256(data)
+		if digest := hex.EncodeToString(sum[:]); expected == "" || strings.EqualFold(digest, expected) {
+			return target, digest, nil
+		}
+	}
+
+	body, err := uc.get(ctx, artifactURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer body.Close()
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", "", fmt.Errorf("failed to create download directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), name+".*.tmp")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create artifact file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(body, maxArtifactSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to download artifact: %w", err)
+	}
+	if written > maxArtifactSize {
+		return "", "", fmt.Errorf("artifact is larger than %d bytes", maxArtifactSize)
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if expected != "" && !strings.EqualFold(digest, expected) {
+		return "", "", fmt.Errorf("artifact checksum %s does not match the expected %s", digest, expected)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return "", "", fmt.Errorf("failed to save artifact: %w", err)
+	}
+	return target, digest, nil
+}
+
+// get issues an authenticated GET, returning the body of a 200 response
+func (uc *updateChecker) get(ctx context.Context, target string) (io.ReadCloser, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json, application/json;q=0.9, */*;q=0.8")
+	if uc.config.TokenEnv != "" {
+		if token := os.Getenv(uc.config.TokenEnv); token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	response, err := uc.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", target, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("GET %s returned %s", target, response.Status)
+	}
+	return response.Body, nil
+}
+
+// onPluginUpdate announces a newer release on the event bus
+func (cp *ClusterPlugin) onPluginUpdate(status UpdateStatus) {
+	if cp.events == nil {
+		return
+	}
+	attributes := map[string]string{"currentVersion": status.CurrentVersion, "latestVersion": status.LatestVersion}
+	if status.ArtifactPath != "" {
+		attributes["artifactPath"] = status.ArtifactPath
+		attributes["artifactSha256"] = status.ArtifactSHA256
+	}
+	cp.events.Publish(Event{
+		Type:       eventPluginUpdateAvailable,
+		Message:    fmt.Sprintf("Plugin version %s is available, running %s", status.LatestVersion, status.CurrentVersion),
+		Attributes: attributes,
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestUpdateChecker(t *testing.T) {
+	artifact := []byte("plugin artifact")
+	sum := sha256.Sum256(artifact)
+	digest := hex.EncodeToString(sum[:])
+
+	var server *httptest.Server
+	releases := map[string]interface{}{}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download/pluginv2.so":
+			w.Write(artifact)
+		default:
+			release, ok := releases[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(release)
+		}
+	}))
+	defer server.Close()
+	releases["/github"] = map[string]interface{}{
+		"tag_name": "v1.2.0",
+		"html_url": "https://github.com/example/plugin/releases/v1.2.0",
+		"assets": []map[string]interface{}{
+			{"name": "checksums.txt", "browser_download_url": server.URL + "/download/checksums.txt"},
+			{"name": "pluginv2.so", "browser_download_url": server.URL + "/download/pluginv2.so"},
+		},
+	}
+	releases["/registry"] = map[string]interface{}{"version": "1.3.0", "artifactUrl": server.URL + "/download/pluginv2.so", "sha256": digest}
+	releases["/tampered"] = map[string]interface{}{"version": "1.3.0", "artifactUrl": server.URL + "/download/pluginv2.so", "sha256": "00"}
+	releases["/older"] = map[string]interface{}{"version": "0.9.0"}
+
+	tests := []struct {
+		name          string
+		path          string
+		download      bool
+		wantAvailable bool
+		wantArtifact  bool
+		wantErr       bool
+	}{
+		{name: "github release", path: "/github", wantAvailable: true},
+		{name: "github download", path: "/github", download: true, wantAvailable: true, wantArtifact: true},
+		{name: "registry download", path: "/registry", download: true, wantAvailable: true, wantArtifact: true},
+		{name: "checksum mismatch", path: "/tampered", download: true, wantAvailable: true, wantErr: true},
+		{name: "older release", path: "/older", download: true},
+		{name: "registry unreachable", path: "/missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := newUpdateChecker(UpdateCheckConfig{
+				URL:         server.URL + tt.path,
+				Download:    tt.download,
+				DownloadDir: t.TempDir(),
+			}, "1.0.0")
+			var notified []UpdateStatus
+			checker.onUpdate = func(status UpdateStatus) { notified = append(notified, status) }
+			checker.check(context.Background())
+			checker.check(context.Background())
+
+			status := checker.Status()
+			if (status.Error != "") != tt.wantErr {
+				t.Fatalf("Status().Error = %q, wantErr %v", status.Error, tt.wantErr)
+			}
+			if status.UpdateAvailable != tt.wantAvailable {
+				t.Errorf("UpdateAvailable = %v, want %v", status.UpdateAvailable, tt.wantAvailable)
+			}
+			// Releases are announced once, and only when fully available
+			wantNotified := 0
+			if tt.wantAvailable && !tt.wantErr {
+				wantNotified = 1
+			}
+			if len(notified) != wantNotified {
+				t.Errorf("onUpdate called %d times, want %d", len(notified), wantNotified)
+			}
+			if !tt.wantArtifact {
+				if status.ArtifactPath != "" {
+					t.Errorf("ArtifactPath = %q, want none", status.ArtifactPath)
+				}
+				return
+			}
+			data, err := os.ReadFile(status.ArtifactPath)
+			if err != nil || string(data) != string(artifact) || status.ArtifactSHA256 != digest {
+				t.Errorf("artifact %s = %q, %v with digest %s", status.ArtifactPath, data, err, status.ArtifactSHA256)
+			}
+		})
+	}
+}
+
+func TestUpdateCheckConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		enabled bool
+		wantErr bool
+	}{
+		{name: "absent"},
+		{name: "registry", config: map[string]interface{}{"url": "https://plugins.example.com/pluginv2/latest"}, enabled: true},
+		{name: "relative url", config: map[string]interface{}{"url": "/latest"}, wantErr: true},
+		{name: "negative interval", config: map[string]interface{}{"url": "https://example.com", "intervalSeconds": -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{}
+			if tt.config != nil {
+				config["updateCheck"] = tt.config
+			}
+			uc, enabled, err := updateCheckConfigFromConfig(config, "/tmp/updates")
+			if (err != nil) != tt.wantErr || enabled != tt.enabled {
+				t.Fatalf("updateCheckConfigFromConfig() = %v, %v, want enabled %v, wantErr %v", enabled, err, tt.enabled, tt.wantErr)
+			}
+			if enabled && (uc.IntervalSeconds <= 0 || uc.DownloadDir != "/tmp/updates") {
+				t.Errorf("defaults not applied: %+v", uc)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// defaultMaxKubeconfigUploadBytes bounds uploaded kubeconfig files; real ones
+// are a few kilobytes even with embedded certificates
+const defaultMaxKubeconfigUploadBytes = 1 << 20
+
+// multipartOverhead leaves room for the form fields and part headers sent
+// along with the kubeconfig file
+const multipartOverhead = 64 << 10
+
+// UploadedContext describes a context of an uploaded kubeconfig so the
+// dashboard can offer a choice between them
+type UploadedContext struct {
+	Name    string `json:"name"`
+	Cluster string `json:"cluster"`
+	Server  string `json:"server"`
+	Current bool   `json:"current,omitempty"`
+}
+
+// uploadLimit is the largest file accepted by the upload endpoints
+func (cp *ClusterPlugin) uploadLimit() int64 {
+	if cp.maxUploadBytes <= 0 {
+		return defaultMaxKubeconfigUploadBytes
+	}
+	return cp.maxUploadBytes
+}
+
+// readKubeconfigUpload reads the "kubeconfig" file of a multipart request,
+// which is nil when none was attached. It answers the request and returns
+// false when the file is too large or not a kubeconfig. The form fields can
+// be read once it returns.
+func (cp *ClusterPlugin) readKubeconfigUpload(c *gin.Context) ([]byte, bool) {
+	limit := cp.uploadLimit()
+	tooLarge := func() ([]byte, bool) {
+		respondProblem(c, http.StatusRequestEntityTooLarge, CodePayloadTooLarge,
+			fmt.Sprintf("Kubeconfig file exceeds the limit of %d bytes", limit), "maxBytes", limit)
+		return nil, false
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+	file, err := c.FormFile("kubeconfig")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return tooLarge()
+	}
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, true
+	}
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Failed to retrieve kubeconfig file")
+		return nil, false
+	}
+	if file.Size > limit {
+		return tooLarge()
+	}
+	f, err := file.Open()
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeInternal, "Failed to open kubeconfig file")
+		return nil, false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		respondProblem(c, http.StatusInternalServerError, CodeInternal, "Failed to read kubeconfig file")
+		return nil, false
+	}
+	if int64(len(data)) > limit {
+		return tooLarge()
+	}
+
+	if err := checkKubeconfigContent(data); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidKubeconfig, err.Error())
+		return nil, false
+	}
+	return data, true
+}
+
+// checkKubeconfigContent rejects uploads that can't be a kubeconfig before
+// they are parsed: empty, binary or without a single context
+func checkKubeconfigContent(data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return fmt.Errorf("kubeconfig file is empty")
+	}
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return fmt.Errorf("kubeconfig file is not a text file")
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+	if len(config.Contexts) == 0 {
+		return fmt.Errorf("kubeconfig contains no contexts")
+	}
+	return nil
+}
+
+// uploadedContexts lists the contexts of a kubeconfig, sorted by name
+func uploadedContexts(config *clientcmdapi.Config) []UploadedContext {
+	contexts := make([]UploadedContext, 0, len(config.Contexts))
+	for name, context := range config.Contexts {
+		uploaded := UploadedContext{Name: name, Cluster: context.Cluster, Current: name == config.CurrentContext}
+		if cluster, exists := config.Clusters[context.Cluster]; exists {
+			uploaded.Server = cluster.Server
+		}
+		contexts = append(contexts, uploaded)
+	}
+	sort.Slice(contexts, func(i, j int) bool { return contexts[i].Name < contexts[j].Name })
+	return contexts
+}
+
+// selectUploadContext picks the context of an uploaded kubeconfig to onboard
+// with: the requested one, the only one, or the one named after the
+// cluster. Otherwise the choice is left to the caller, so a kubeconfig full
+// of contexts never onboards whichever happened to be current.
+func selectUploadContext(config *clientcmdapi.Config, requested, clusterName string) (string, bool) {
+	if requested != "" {
+		_, exists := config.Contexts[requested]
+		return requested, exists
+	}
+	if len(config.Contexts) == 1 {
+		for name := range config.Contexts {
+			return name, true
+		}
+	}
+	if _, exists := config.Contexts[clusterName]; exists && clusterName != "" {
+		return clusterName, true
+	}
+	return "", false
+}
+
+// onboardingUpload resolves the kubeconfig file uploaded to POST /onboard to
+// a kubeconfig holding just the selected context, and the cluster name,
+// which defaults to the context name. It answers the request and returns
+// false when the upload can't be used.
+func (cp *ClusterPlugin) onboardingUpload(c *gin.Context, data []byte, clusterName string) ([]byte, string, bool) {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidKubeconfig, fmt.Sprintf("invalid kubeconfig: %v", err))
+		return nil, "", false
+	}
+	requested := c.PostForm("context")
+	contextName, ok := selectUploadContext(config, requested, clusterName)
+	if !ok {
+		detail := "Kubeconfig has several contexts, choose one with the context field"
+		if requested != "" {
+			detail = fmt.Sprintf("Context '%s' not found in kubeconfig", requested)
+		}
+		respondProblem(c, http.StatusUnprocessableEntity, CodeContextRequired, detail, "contexts", uploadedContexts(config))
+		return nil, "", false
+	}
+	if clusterName == "" {
+		if err := validateClusterName(contextName); err != nil {
+			respondProblem(c, http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("Cluster name is required, context '%s' can't be used as one", contextName))
+			return nil, "", false
+		}
+		clusterName = contextName
+	}
+
+	// Files referenced by the kubeconfig live on the uploader's machine, so
+	// the context must be usable with what was uploaded
+	clientConfig := clientcmd.NewNonInteractiveClientConfig(*config, contextName, &clientcmd.ConfigOverrides{}, nil)
+	if _, err := clientConfig.ClientConfig(); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidKubeconfig,
+			fmt.Sprintf("context '%s' is not usable, embed its certificates and credentials: %v", contextName, err))
+		return nil, "", false
+	}
+	kubeconfigData, err := extractContextConfig(config, contextName)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidKubeconfig, err.Error())
+		return nil, "", false
+	}
+	requestLogger(c).Info("Using uploaded kubeconfig", "cluster", clusterName, "context", contextName, "contexts", len(config.Contexts))
+	return kubeconfigData, clusterName, true
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const twoContextKubeconfig = `apiVersion: v1
+kind: Config
+current-context: edge-1
+clusters:
+- name: edge-1
+  cluster:
+    server: https://edge-1.example.com:6443
+    insecure-skip-tls-verify: true
+- name: edge-2
+  cluster:
+    server: https://edge-2.example.com:6443
+    certificate-authority: /home/someone/.kube/edge-2-ca.crt
+contexts:
+- name: edge-1
+  context:
+    cluster: edge-1
+    user: admin
+- name: edge-2
+  context:
+    cluster: edge-2
+    user: admin
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+// multipartUpload builds a POST /onboard form with the kubeconfig file and
+// the given fields
+func multipartUpload(t *testing.T, url string, kubeconfig []byte, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range fields {
+		if err := writer.WriteField(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if kubeconfig != nil {
+		part, err := writer.CreateFormFile("kubeconfig", "config")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(kubeconfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodPost, url, &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func TestSelectUploadContext(t *testing.T) {
+	config, err := clientcmd.Load([]byte(twoContextKubeconfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	single := config.DeepCopy()
+	delete(single.Contexts, "edge-2")
+
+	tests := []struct {
+		name        string
+		config      string
+		requested   string
+		clusterName string
+		want        string
+		wantOK      bool
+	}{
+		{name: "requested", requested: "edge-2", want: "edge-2", wantOK: true},
+		{name: "requested missing", requested: "edge-3", want: "edge-3"},
+		{name: "named after cluster", clusterName: "edge-1", want: "edge-1", wantOK: true},
+		{name: "ambiguous", clusterName: "edge-9"},
+		{name: "only context", config: "single", clusterName: "edge-9", want: "edge-1", wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := config
+			if tt.config == "single" {
+				source = single
+			}
+			got, ok := selectUploadContext(source, tt.requested, tt.clusterName)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("selectUploadContext() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCheckKubeconfigContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "valid", data: twoContextKubeconfig},
+		{name: "empty", data: " \n", wantErr: "empty"},
+		{name: "binary", data: "\x7fELF\x00\x01", wantErr: "not a text file"},
+		{name: "not yaml", data: "clusters: [", wantErr: "invalid kubeconfig"},
+		{name: "no contexts", data: "apiVersion: v1\nkind: Config\n", wantErr: "no contexts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkKubeconfigContent([]byte(tt.data))
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("checkKubeconfigContent() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkKubeconfigContent() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOnboardUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"maxKubeconfigUploadBytes": 4096})
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	const url = "/api/plugins/kubestellar-cluster-plugin/v1/onboard"
+
+	onboard := func(kubeconfig []byte, fields map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, multipartUpload(t, url, kubeconfig, fields))
+		var body map[string]interface{}
+		_ = json.Unmarshal(recorder.Body.Bytes(), &body)
+		return recorder, body
+	}
+
+	recorder, body := onboard([]byte(twoContextKubeconfig), map[string]string{"name": "edge-9"})
+	if recorder.Code != http.StatusUnprocessableEntity || body["code"] != string(CodeContextRequired) {
+		t.Fatalf("ambiguous upload = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if contexts, _ := body["contexts"].([]interface{}); len(contexts) != 2 {
+		t.Errorf("contexts = %v, want both offered", body["contexts"])
+	}
+
+	recorder, body = onboard([]byte(twoContextKubeconfig), map[string]string{"context": "edge-2"})
+	if recorder.Code != http.StatusBadRequest || body["code"] != string(CodeInvalidKubeconfig) {
+		t.Errorf("context with a local CA file = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder, body = onboard(bytes.Repeat([]byte("#"), 8192), nil)
+	if recorder.Code != http.StatusRequestEntityTooLarge || body["code"] != string(CodePayloadTooLarge) || body["maxBytes"] != float64(4096) {
+		t.Errorf("oversized upload = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder, body = onboard([]byte("\x00\x01\x02"), map[string]string{"name": "edge-1"})
+	if recorder.Code != http.StatusBadRequest || body["code"] != string(CodeInvalidKubeconfig) {
+		t.Errorf("binary upload = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder, _ = onboard(nil, nil)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("empty form = %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestOnboardingUploadDefaultsClusterName(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	plugin := newTestPlugin(t)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = multipartUpload(t, "/onboard", nil, map[string]string{"context": "edge-1"})
+
+	data, clusterName, ok := plugin.onboardingUpload(c, []byte(twoContextKubeconfig), "")
+	if !ok {
+		t.Fatalf("onboardingUpload() rejected the upload: %s", recorder.Body.String())
+	}
+	if clusterName != "edge-1" {
+		t.Errorf("clusterName = %q, want the context name", clusterName)
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Contexts) != 1 || config.CurrentContext != "edge-1" {
+		t.Errorf("kubeconfig has contexts %v, current %q, want only edge-1", config.Contexts, config.CurrentContext)
+	}
+}
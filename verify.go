@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var managedClusterAddOnGVR = schema.GroupVersionResource{
+	Group:    "addon.open-cluster-management.io",
+	Version:  "v1alpha1",
+	Resource: "managedclusteraddons",
+}
+
+// Readiness gates, run in this order
+const (
+	gateHubAvailable = "hub-available"
+	gateNodesReady   = "nodes-ready"
+	gateAddons       = "addons"
+	gateAPILatency   = "api-latency"
+	gateVersionSkew  = "version-skew"
+)
+
+var readinessGateNames = []string{gateHubAvailable, gateNodesReady, gateAddons, gateAPILatency, gateVersionSkew}
+
+// ReadinessGates is the readinessGates section of the Initialize config. A
+// cluster is only marked Ready once every enabled gate passes.
+type ReadinessGates struct {
+	// Disabled names the gates to skip
+	Disabled []string `json:"disabled,omitempty"`
+	// RequiredAddons are ManagedClusterAddOns that must be available
+	RequiredAddons []string `json:"requiredAddons,omitempty"`
+	// MaxAPILatencyMs bounds the median spoke API round trip, default 1000
+	MaxAPILatencyMs int `json:"maxAPILatencyMs,omitempty"`
+	// MaxVersionSkew bounds the minor versions between hub and spoke, default 3
+	MaxVersionSkew int `json:"maxVersionSkew,omitempty"`
+	// IntervalSeconds is how long to wait between runs while the gates
+	// fail, default 10; the verify step timeout bounds the wait
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+}
+
+func readinessGatesFromConfig(config map[string]interface{}) (ReadinessGates, error) {
+	gates := ReadinessGates{MaxAPILatencyMs: 1000, MaxVersionSkew: 3, IntervalSeconds: 10}
+	if raw, ok := config["readinessGates"]; ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return gates, fmt.Errorf("invalid readinessGates config: %w", err)
+		}
+		if err := json.Unmarshal(data, &gates); err != nil {
+			return gates, fmt.Errorf("invalid readinessGates config: %w", err)
+		}
+	}
+	for _, name := range gates.Disabled {
+		known := false
+		for _, gate := range readinessGateNames {
+			known = known || gate == name
+		}
+		if !known {
+			return gates, fmt.Errorf("invalid readinessGates config: unknown gate %q, must be one of %s", name, strings.Join(readinessGateNames, ", "))
+		}
+	}
+	if gates.MaxAPILatencyMs <= 0 || gates.MaxVersionSkew < 0 || gates.IntervalSeconds <= 0 {
+		return gates, fmt.Errorf("invalid readinessGates config: maxAPILatencyMs and intervalSeconds must be positive and maxVersionSkew not negative")
+	}
+	return gates, nil
+}
+
+func (g ReadinessGates) enabled(name string) bool {
+	for _, disabled := range g.Disabled {
+		if disabled == name {
+			return false
+		}
+	}
+	return true
+}
+
+// GateResult is the outcome of one readiness gate
+type GateResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// VerificationReport is the outcome of a run of the readiness gates
+type VerificationReport struct {
+	Passed    bool         `json:"passed"`
+	Gates     []GateResult `json:"gates"`
+	CheckedAt string       `json:"checkedAt"`
+}
+
+// failed lists the names of the gates that did not pass
+func (r VerificationReport) failed() []string {
+	var names []string
+	for _, gate := range r.Gates {
+		if !gate.Passed {
+			names = append(names, gate.Name)
+		}
+	}
+	return names
+}
+
+// readinessTarget holds the clients the gates check a cluster with
+type readinessTarget struct {
+	clusterName string
+	spoke       kubernetes.Interface
+	hub         kubernetes.Interface
+	hubDynamic  dynamic.Interface
+}
+
+// Run checks every enabled gate once
+func (g ReadinessGates) Run(ctx context.Context, target readinessTarget) VerificationReport {
+	checks := map[string]func(context.Context, readinessTarget) (string, error){
+		gateHubAvailable: checkHubAvailable,
+		gateNodesReady:   checkNodesReady,
+		gateAddons:       g.checkAddons,
+		gateAPILatency:   g.checkAPILatency,
+		gateVersionSkew:  g.checkVersionSkew,
+	}
+
+	report := VerificationReport{Passed: true, Gates: []GateResult{}}
+	for _, name := range readinessGateNames {
+		if !g.enabled(name) {
+			continue
+		}
+		result := GateResult{Name: name, Passed: true}
+		message, err := checks[name](ctx, target)
+		if err != nil {
+			result.Passed, result.Message = false, err.Error()
+			report.Passed = false
+		} else {
+			result.Message = message
+		}
+		report.Gates = append(report.Gates, result)
+	}
+	report.CheckedAt = time.Now().Format(time.RFC3339)
+	return report
+}
+
+func checkHubAvailable(ctx context.Context, target readinessTarget) (string, error) {
+	object, err := target.hubDynamic.Resource(managedClusterGVR).Get(ctx, target.clusterName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get ManagedCluster: %w", err)
+	}
+	state := managedClusterStateFrom(object)
+	if state.Available != "True" {
+		return "", fmt.Errorf("ManagedCluster is %s", state.Phase())
+	}
+	return "ManagedCluster is available", nil
+}
+
+func checkNodesReady(ctx context.Context, target readinessTarget) (string, error) {
+	nodes, err := target.spoke.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list nodes: %w", err)
+	}
+	if len(nodes.Items) == 0 {
+		return "", fmt.Errorf("cluster has no nodes")
+	}
+	var notReady []string
+	for _, node := range nodes.Items {
+		ready := false
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				ready = condition.Status == corev1.ConditionTrue
+			}
+		}
+		if !ready {
+			notReady = append(notReady, node.Name)
+		}
+	}
+	if len(notReady) > 0 {
+		sort.Strings(notReady)
+		return "", fmt.Errorf("%d of %d nodes not ready: %s", len(notReady), len(nodes.Items), strings.Join(notReady, ", "))
+	}
+	return fmt.Sprintf("%d nodes ready", len(nodes.Items)), nil
+}
+
+func (g ReadinessGates) checkAddons(ctx context.Context, target readinessTarget) (string, error) {
+	if len(g.RequiredAddons) == 0 {
+		return "No addons required", nil
+	}
+	var missing []string
+	for _, addon := range g.RequiredAddons {
+		object, err := target.hubDynamic.Resource(managedClusterAddOnGVR).Namespace(target.clusterName).Get(ctx, addon, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			missing = append(missing, addon+" (not installed)")
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get addon %s: %w", addon, err)
+		}
+		if !addonAvailable(object) {
+			missing = append(missing, addon+" (not available)")
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("required addons missing: %s", strings.Join(missing, ", "))
+	}
+	return fmt.Sprintf("%d required addons available", len(g.RequiredAddons)), nil
+}
+
+// addonAvailable reports whether a ManagedClusterAddOn has the Available condition
+func addonAvailable(addon *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(addon.Object, "status", "conditions")
+	for _, raw := range conditions {
+		condition, ok := raw.(map[string]interface{})
+		if ok && condition["type"] == "Available" {
+			return condition["status"] == "True"
+		}
+	}
+	return false
+}
+
+func (g ReadinessGates) checkAPILatency(ctx context.Context, target readinessTarget) (string, error) {
+	const samples = 3
+	latencies := make([]time.Duration, 0, samples)
+	for i := 0; i < samples; i++ {
+		start := time.Now()
+		if _, err := target.spoke.Discovery().ServerVersion(); err != nil {
+			return "", fmt.Errorf("API request failed: %w", err)
+		}
+		latencies = append(latencies, time.Since(start))
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	median := latencies[samples/2]
+	limit := time.Duration(g.MaxAPILatencyMs) * time.Millisecond
+	if median > limit {
+		return "", fmt.Errorf("median API latency %s exceeds %s", median.Round(time.Millisecond), limit)
+	}
+	return fmt.Sprintf("median API latency %s", median.Round(time.Millisecond)), nil
+}
+
+func (g ReadinessGates) checkVersionSkew(_ context.Context, target readinessTarget) (string, error) {
+	versions := make([]*version.Version, 0, 2)
+	for _, client := range []kubernetes.Interface{target.hub, target.spoke} {
+		info, err := client.Discovery().ServerVersion()
+		if err != nil {
+			return "", fmt.Errorf("failed to read server version: %w", err)
+		}
+		parsed, err := version.ParseGeneric(info.GitVersion)
+		if err != nil {
+			return "", fmt.Errorf("invalid server version %q: %w", info.GitVersion, err)
+		}
+		versions = append(versions, parsed)
+	}
+	hub, spoke := versions[0], versions[1]
+	skew := int(hub.Minor()) - int(spoke.Minor())
+	if skew < 0 {
+		skew = -skew
+	}
+	if hub.Major() != spoke.Major() || skew > g.MaxVersionSkew {
+		return "", fmt.Errorf("spoke %s is more than %d minor versions from hub %s", spoke, g.MaxVersionSkew, hub)
+	}
+	return fmt.Sprintf("spoke %s, hub %s", spoke, hub), nil
+}
+
+// await runs the gates until they all pass or ctx ends, returning the last report
+func (g ReadinessGates) await(ctx context.Context, target readinessTarget) VerificationReport {
+	for {
+		report := g.Run(ctx, target)
+		if report.Passed {
+			return report
+		}
+		select {
+		case <-ctx.Done():
+			return report
+		case <-time.After(time.Duration(g.IntervalSeconds) * time.Second):
+		}
+	}
+}
+
+// readinessTarget connects to a cluster through its kubeconfig and to its hub
+func (cp *ClusterPlugin) readinessTarget(clusterName string, kubeconfigData []byte) (readinessTarget, error) {
+	target := readinessTarget{clusterName: clusterName}
+	spokeConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigData)
+	if err != nil {
+		return target, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	if target.spoke, err = kubernetes.NewForConfig(spokeConfig); err != nil {
+		return target, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	clusterHub, err := cp.clusterHub(clusterName)
+	if err != nil {
+		return target, err
+	}
+	hub, hubConfig, err := clusterHub.clientset()
+	if err != nil {
+		return target, fmt.Errorf("failed to get hub clientset: %w", err)
+	}
+	target.hub = hub
+	if target.hubDynamic, err = dynamic.NewForConfig(hubConfig); err != nil {
+		return target, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	return target, nil
+}
+
+// recordVerification keeps the latest report with the cluster
+func (cp *ClusterPlugin) recordVerification(clusterName string, report VerificationReport) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if cp.closed {
+		return
+	}
+	record, exists, err := cp.store.Get(clusterName)
+	if err != nil || !exists {
+		return
+	}
+	record.Verification = &report
+	if err := cp.store.Put(record); err != nil {
+		logger().Error("Failed to persist verification report", "cluster", clusterName, "error", err)
+	}
+	cp.statusCache.invalidate()
+}
+
+// verifyReadiness is the verify onboarding step: it waits for the readiness
+// gates and fails the onboarding when they don't pass in time
+func (cp *ClusterPlugin) verifyReadiness(ctx context.Context, r *onboardingRun) error {
+	target, err := cp.readinessTarget(r.clusterName, r.kubeconfig)
+	if err != nil {
+		return err
+	}
+	report := cp.readinessGates.await(ctx, target)
+	cp.recordVerification(r.clusterName, report)
+	if !report.Passed {
+		return fmt.Errorf("readiness gates failed: %s", strings.Join(report.failed(), ", "))
+	}
+	return nil
+}
+
+// VerifyClusterHandler returns the readiness gate results of a cluster. The
+// gates are run again when refresh=true is passed or none were recorded yet.
+func (cp *ClusterPlugin) VerifyClusterHandler(c *gin.Context) {
+	clusterName := c.Param("name")
+
+	cp.mutex.RLock()
+	record, exists, err := cp.store.Get(clusterName)
+	cp.mutex.RUnlock()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if !exists {
+		respondProblem(c, http.StatusNotFound, CodeClusterNotFound, fmt.Sprintf("Cluster '%s' not found in plugin", clusterName))
+		return
+	}
+
+	report := record.Verification
+	if report == nil || c.Query("refresh") == "true" {
+		kubeconfigData := cp.savedKubeconfig(clusterName)
+		if len(kubeconfigData) == 0 {
+			respondProblem(c, http.StatusConflict, CodeConflict, fmt.Sprintf("No saved kubeconfig to verify cluster '%s' with", clusterName))
+			return
+		}
+		target, err := cp.readinessTarget(clusterName, kubeconfigData)
+		if err != nil {
+			respondProblem(c, http.StatusBadGateway, CodeHubUnreachable, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+		cp.mutex.RLock()
+		gates := cp.readinessGates
+		cp.mutex.RUnlock()
+		fresh := gates.Run(ctx, target)
+		// A report cut short by the request ending isn't worth keeping
+		if cp.abortOnContext(c) {
+			return
+		}
+		cp.recordVerification(clusterName, fresh)
+		report = &fresh
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clusterName":  clusterName,
+		"verification": report,
+		"plugin":       "kubestellar-cluster-plugin",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	versioninfo "k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func withConditions(object *unstructured.Unstructured, conditions map[string]string) *unstructured.Unstructured {
+	var list []interface{}
+	for conditionType, status := range conditions {
+		list = append(list, map[string]interface{}{"type": conditionType, "status": status})
+	}
+	object.Object["status"] = map[string]interface{}{"conditions": list}
+	return object
+}
+
+func fakeServerVersion(client *fake.Clientset, gitVersion string) *fake.Clientset {
+	client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &versioninfo.Info{GitVersion: gitVersion}
+	return client
+}
+
+func TestReadinessGates(t *testing.T) {
+	managedCluster := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.open-cluster-management.io/v1",
+		"kind":       "ManagedCluster",
+		"metadata":   map[string]interface{}{"name": "edge-1"},
+	}}
+	addon := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "addon.open-cluster-management.io/v1alpha1",
+		"kind":       "ManagedClusterAddOn",
+		"metadata":   map[string]interface{}{"name": "status-addon", "namespace": "edge-1"},
+	}}
+	hubDynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		managedClusterGVR:      "ManagedClusterList",
+		managedClusterAddOnGVR: "ManagedClusterAddOnList",
+	},
+		withConditions(managedCluster, map[string]string{"ManagedClusterConditionAvailable": "True"}),
+		withConditions(addon, map[string]string{"Available": "True"}),
+	)
+
+	ready := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}, Status: corev1.NodeStatus{
+		Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+	}}
+	notReady := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-2"}, Status: corev1.NodeStatus{
+		Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}},
+	}}
+
+	gates, err := readinessGatesFromConfig(map[string]interface{}{
+		"readinessGates": map[string]interface{}{"requiredAddons": []interface{}{"status-addon"}, "maxVersionSkew": 1},
+	})
+	if err != nil {
+		t.Fatalf("readinessGatesFromConfig() error = %v", err)
+	}
+
+	target := readinessTarget{
+		clusterName: "edge-1",
+		spoke:       fakeServerVersion(fake.NewSimpleClientset(&ready), "v1.29.2"),
+		hub:         fakeServerVersion(fake.NewSimpleClientset(), "v1.30.0"),
+		hubDynamic:  hubDynamic,
+	}
+	if report := gates.Run(context.Background(), target); !report.Passed || len(report.Gates) != len(readinessGateNames) {
+		t.Errorf("Run() = %+v, want every gate passed", report)
+	}
+
+	target.spoke = fakeServerVersion(fake.NewSimpleClientset(&ready, &notReady), "v1.27.0")
+	report := gates.Run(context.Background(), target)
+	failed := report.failed()
+	if report.Passed || len(failed) != 2 || failed[0] != gateNodesReady || failed[1] != gateVersionSkew {
+		t.Errorf("Run() failed gates = %v, want %s and %s", failed, gateNodesReady, gateVersionSkew)
+	}
+
+	gates.Disabled = []string{gateNodesReady, gateVersionSkew}
+	gates.RequiredAddons = append(gates.RequiredAddons, "missing-addon")
+	if failed := gates.Run(context.Background(), target).failed(); len(failed) != 1 || failed[0] != gateAddons {
+		t.Errorf("Run() with disabled gates failed %v, want %s", failed, gateAddons)
+	}
+}
+
+func TestReadinessGatesFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		gates   map[string]interface{}
+		wantErr bool
+	}{
+		{name: "defaults", gates: map[string]interface{}{}},
+		{name: "disable a gate", gates: map[string]interface{}{"disabled": []interface{}{gateAPILatency}}},
+		{name: "unknown gate", gates: map[string]interface{}{"disabled": []interface{}{"dns"}}, wantErr: true},
+		{name: "negative skew", gates: map[string]interface{}{"maxVersionSkew": -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readinessGatesFromConfig(map[string]interface{}{"readinessGates": tt.gates}); (err != nil) != tt.wantErr {
+				t.Errorf("readinessGatesFromConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteDeprecation is the legacyRoutes section of plugin.yaml: when the
+// unversioned routes of earlier releases are deprecated and when they go away
+type RouteDeprecation struct {
+	// Deprecation and Sunset are dates such as 2027-04-30
+	Deprecation string `json:"deprecation,omitempty"`
+	Sunset      string `json:"sunset,omitempty"`
+}
+
+var apiVersionPattern = regexp.MustCompile(`^v[1-9][0-9]*$`)
+
+// expandAPIVersions mounts every unversioned endpoint under the current API
+// version as well. The unversioned route keeps working during the
+// transition, deprecated as legacyRoutes says, with the versioned one as
+// its successor. Endpoints already declared under the version are kept as
+// they are.
+func expandAPIVersions(metadata *PluginMetadata) {
+	if metadata.APIVersion == "" {
+		return
+	}
+	declared := make(map[string]bool, len(metadata.Endpoints))
+	for _, endpoint := range metadata.Endpoints {
+		declared[endpoint.Method+" "+endpoint.Path] = true
+	}
+
+	var versioned []EndpointConfig
+	for i, endpoint := range metadata.Endpoints {
+		if endpoint.Version != "" {
+			continue
+		}
+		current := endpoint
+		current.Version = metadata.APIVersion
+		current.Path = "/" + metadata.APIVersion + endpoint.Path
+		if !declared[current.Method+" "+current.Path] {
+			versioned = append(versioned, current)
+		}
+		if legacy := metadata.LegacyRoutes; legacy != nil {
+			metadata.Endpoints[i].Deprecation = legacy.Deprecation
+			metadata.Endpoints[i].Sunset = legacy.Sunset
+			metadata.Endpoints[i].Successor = current.Path
+		}
+	}
+	metadata.Endpoints = append(metadata.Endpoints, versioned...)
+}
+
+// parseRouteDate parses the dates of Deprecation and Sunset
+func parseRouteDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// validateVersioning checks the version and the deprecation of an endpoint
+func validateVersioning(endpoint EndpointConfig) []error {
+	var errs []error
+	if endpoint.Version != "" {
+		if !apiVersionPattern.MatchString(endpoint.Version) {
+			errs = append(errs, fmt.Errorf("endpoint %s: invalid version %q, must look like v1", endpoint.Path, endpoint.Version))
+		} else if !strings.HasPrefix(endpoint.Path, "/"+endpoint.Version+"/") {
+			errs = append(errs, fmt.Errorf("endpoint %s: path must start with /%s/", endpoint.Path, endpoint.Version))
+		}
+	}
+	for field, value := range map[string]string{"deprecation": endpoint.Deprecation, "sunset": endpoint.Sunset} {
+		if value == "" {
+			continue
+		}
+		if _, err := parseRouteDate(value); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s: invalid %s date %q", endpoint.Path, field, value))
+		}
+	}
+	if endpoint.Sunset != "" && endpoint.Deprecation == "" {
+		errs = append(errs, fmt.Errorf("endpoint %s: a sunset needs a deprecation date", endpoint.Path))
+	}
+	return errs
+}
+
+// routeEndpoint returns the metadata endpoint of the matched route. The
+// longest endpoint path wins, so /v1/status isn't taken for /status.
+func (cp *ClusterPlugin) routeEndpoint(c *gin.Context) (EndpointConfig, bool) {
+	endpoints := cp.GetMetadata().Endpoints
+	route := c.FullPath()
+	var best EndpointConfig
+	found := false
+	for _, endpoint := range endpoints {
+		if endpoint.Method != c.Request.Method || (found && len(endpoint.Path) <= len(best.Path)) {
+			continue
+		}
+		matched := false
+		if route != "" {
+			// The route pattern ends with the endpoint path, parameters included
+			matched = strings.HasSuffix(route, endpoint.Path)
+		} else {
+			_, matched = matchPathSuffix(endpoint.Path, c.Request.URL.Path)
+		}
+		if matched {
+			best, found = endpoint, true
+		}
+	}
+	return best, found
+}
+
+// withDeprecation announces the retirement of deprecated routes with the
+// Deprecation (RFC 9745) and Sunset (RFC 8594) headers, linking the route
+// that replaces them
+func (cp *ClusterPlugin) withDeprecation(_ string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		endpoint, ok := cp.routeEndpoint(c)
+		if !ok || endpoint.Deprecation == "" {
+			handler(c)
+			return
+		}
+
+		if deprecated, err := parseRouteDate(endpoint.Deprecation); err == nil {
+			c.Header("Deprecation", fmt.Sprintf("@%d", deprecated.Unix()))
+		}
+		if endpoint.Sunset != "" {
+			if sunset, err := parseRouteDate(endpoint.Sunset); err == nil {
+				c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+		}
+		if successor := successorURL(c, endpoint); successor != "" {
+			c.Header("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		}
+		handler(c)
+	}
+}
+
+// successorURL returns the path of the route replacing the requested one,
+// under the same mount point and with the same path parameters
+func successorURL(c *gin.Context, endpoint EndpointConfig) string {
+	if endpoint.Successor == "" {
+		return ""
+	}
+	suffix, ok := matchPathSuffix(endpoint.Path, c.Request.URL.Path)
+	if !ok {
+		return ""
+	}
+	prefix := strings.TrimSuffix(strings.TrimSuffix(c.Request.URL.Path, "/"), suffix)
+	successor := pathParamPattern.ReplaceAllStringFunc(endpoint.Successor, func(param string) string {
+		return c.Param(strings.TrimPrefix(param, ":"))
+	})
+	return prefix + successor
+}
+
+// sortBySpecificity orders endpoints longest path first, so that suffix
+// matching tries /v1/status before /status
+func sortBySpecificity(endpoints []EndpointConfig) {
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		return strings.Count(endpoints[i].Path, "/") > strings.Count(endpoints[j].Path, "/")
+	})
+}
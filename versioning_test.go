@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExpandAPIVersions(t *testing.T) {
+	metadata := PluginMetadata{
+		APIVersion:   "v1",
+		LegacyRoutes: &RouteDeprecation{Deprecation: "2026-10-16", Sunset: "2027-04-30"},
+		Endpoints: []EndpointConfig{
+			{Path: "/status", Method: http.MethodGet, Handler: "GetClusterStatusHandler"},
+			{Path: "/clusters/:name", Method: http.MethodGet, Handler: "GetClusterDetailHandler"},
+			// Declared under the version already, so not generated again
+			{Path: "/v1/clusters/:name", Method: http.MethodGet, Handler: "GetClusterDetailHandler", Version: "v1", Description: "own"},
+		},
+	}
+	expandAPIVersions(&metadata)
+
+	routes := map[string]EndpointConfig{}
+	for _, endpoint := range metadata.Endpoints {
+		routes[endpoint.Method+" "+endpoint.Path] = endpoint
+	}
+	if len(metadata.Endpoints) != 4 {
+		t.Fatalf("expanded endpoints = %+v, want 4", metadata.Endpoints)
+	}
+	if legacy := routes["GET /status"]; legacy.Sunset != "2027-04-30" || legacy.Successor != "/v1/status" {
+		t.Errorf("legacy endpoint = %+v, want it deprecated in favor of /v1/status", legacy)
+	}
+	if current := routes["GET /v1/status"]; current.Version != "v1" || current.Deprecation != "" {
+		t.Errorf("versioned endpoint = %+v, want a current v1 endpoint", current)
+	}
+	if declared := routes["GET /v1/clusters/:name"]; declared.Description != "own" {
+		t.Errorf("declared v1 endpoint was replaced: %+v", declared)
+	}
+}
+
+func TestValidateVersioning(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint EndpointConfig
+		wantErr  string
+	}{
+		{name: "current", endpoint: EndpointConfig{Path: "/v2/status", Version: "v2"}},
+		{name: "deprecated", endpoint: EndpointConfig{Path: "/status", Deprecation: "2026-10-16", Sunset: "2027-04-30T00:00:00Z"}},
+		{name: "bad version", endpoint: EndpointConfig{Path: "/beta/status", Version: "beta"}, wantErr: "invalid version"},
+		{name: "path outside version", endpoint: EndpointConfig{Path: "/status", Version: "v2"}, wantErr: "must start with /v2/"},
+		{name: "bad date", endpoint: EndpointConfig{Path: "/status", Deprecation: "soon"}, wantErr: "invalid deprecation date"},
+		{name: "sunset alone", endpoint: EndpointConfig{Path: "/status", Sunset: "2027-04-30"}, wantErr: "needs a deprecation date"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateVersioning(tt.endpoint)
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Errorf("validateVersioning() = %v, want no errors", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Errorf("validateVersioning() = %v, want an error containing %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeprecationHeaders(t *testing.T) {
+	plugin := newTestPlugin(t)
+	router, err := newServerRouter(plugin, "")
+	if err != nil {
+		t.Fatalf("newServerRouter() error = %v", err)
+	}
+	const prefix = "/api/plugins/kubestellar-cluster-plugin"
+
+	tests := []struct {
+		path     string
+		wantLink string
+	}{
+		{path: "/clusters/edge-1/token", wantLink: `<` + prefix + `/v1/clusters/edge-1/token>; rel="successor-version"`},
+		{path: "/v1/clusters/edge-1/token"},
+		{path: "/openapi.json", wantLink: `<` + prefix + `/v1/openapi.json>; rel="successor-version"`},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, prefix+tt.path, nil))
+		if recorder.Code == http.StatusNotFound && recorder.Header().Get("Content-Type") == "text/plain" {
+			t.Fatalf("GET %s is not routed", tt.path)
+		}
+		header := recorder.Header()
+		if tt.wantLink == "" {
+			if header.Get("Deprecation") != "" || header.Get("Sunset") != "" {
+				t.Errorf("GET %s carries deprecation headers %v", tt.path, header)
+			}
+			continue
+		}
+		if got := header.Get("Deprecation"); got != "@1792108800" {
+			t.Errorf("GET %s Deprecation = %q, want @1792108800", tt.path, got)
+		}
+		if got := header.Get("Sunset"); got != "Fri, 30 Apr 2027 00:00:00 GMT" {
+			t.Errorf("GET %s Sunset = %q", tt.path, got)
+		}
+		if got := header.Get("Link"); got != tt.wantLink {
+			t.Errorf("GET %s Link = %q, want %q", tt.path, got, tt.wantLink)
+		}
+	}
+}
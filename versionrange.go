@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// versionRange is a parsed version constraint: a version satisfies it when
+// it satisfies every comparison of any one of its alternatives. The syntax
+// follows npm semver ranges:
+//
+//	>=1.28.0 <1.31        comparisons separated by spaces or commas
+//	>=0.21 || ^1.0        alternatives separated by ||
+//	^1.2.3  ~1.2  1.2.x   caret, tilde and wildcard ranges
+//	1.2 - 1.4             inclusive hyphen ranges
+//
+// A bare full version such as 1.21.0 must match exactly.
+type versionRange [][]versionComparison
+
+// versionComparison compares a version with a bound
+type versionComparison struct {
+	operator string
+	bound    *version.Version
+}
+
+// partialVersion is a version whose trailing components may be missing or
+// wildcards, such as 1.2 or 1.x
+type partialVersion struct {
+	parts []uint
+	// full is the parsed version when all three components are given
+	full *version.Version
+}
+
+func parsePartialVersion(s string) (partialVersion, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if s == "" || s == "*" || s == "x" || s == "X" {
+		return partialVersion{}, nil
+	}
+	core := s
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		core = s[:i]
+	}
+	fields := strings.Split(core, ".")
+	if len(fields) > 3 {
+		return partialVersion{}, fmt.Errorf("invalid constraint version %q", s)
+	}
+	var p partialVersion
+	for _, field := range fields {
+		if field == "*" || field == "x" || field == "X" {
+			break
+		}
+		n, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return partialVersion{}, fmt.Errorf("invalid constraint version %q", s)
+		}
+		p.parts = append(p.parts, uint(n))
+	}
+	if len(p.parts) < 3 {
+		if core != s {
+			return partialVersion{}, fmt.Errorf("invalid constraint version %q: a pre-release needs a full version", s)
+		}
+		return p, nil
+	}
+	full, err := version.ParseSemantic(s)
+	if err != nil {
+		return partialVersion{}, fmt.Errorf("invalid constraint version %q: %w", s, err)
+	}
+	p.full = full
+	return p, nil
+}
+
+// lower is the smallest version the partial version stands for
+func (p partialVersion) lower() *version.Version {
+	if p.full != nil {
+		return p.full
+	}
+	parts := append(append([]uint(nil), p.parts...), 0, 0, 0)
+	return version.MajorMinor(parts[0], parts[1]).WithPatch(parts[2])
+}
+
+// next is the smallest version above every version the partial version
+// stands for, bumping its last given component
+func (p partialVersion) next() *version.Version {
+	switch len(p.parts) {
+	case 1:
+		return version.MajorMinor(p.parts[0]+1, 0).WithPatch(0)
+	case 2:
+		return version.MajorMinor(p.parts[0], p.parts[1]+1).WithPatch(0)
+	default:
+		return version.MajorMinor(p.parts[0], p.parts[1]).WithPatch(p.parts[2] + 1)
+	}
+}
+
+func parseVersionRange(constraint string) (versionRange, error) {
+	var r versionRange
+	for _, alternative := range strings.Split(constraint, "||") {
+		comparisons, err := parseComparisons(alternative)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, comparisons)
+	}
+	return r, nil
+}
+
+// parseComparisons parses one alternative of a range into the comparisons
+// that must all hold
+func parseComparisons(s string) ([]versionComparison, error) {
+	s = strings.ReplaceAll(s, ",", " ")
+	// Operators may be separated from their version, as in ">= 1.2"
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		s = strings.ReplaceAll(s, op+" ", op)
+	}
+	tokens := strings.Fields(s)
+
+	if len(tokens) == 3 && tokens[1] == "-" {
+		from, err := parsePartialVersion(tokens[0])
+		if err != nil {
+			return nil, err
+		}
+		to, err := parsePartialVersion(tokens[2])
+		if err != nil {
+			return nil, err
+		}
+		comparisons := []versionComparison{{">=", from.lower()}}
+		switch {
+		case to.full != nil:
+			comparisons = append(comparisons, versionComparison{"<=", to.full})
+		case len(to.parts) > 0:
+			comparisons = append(comparisons, versionComparison{"<", to.next()})
+		}
+		return comparisons, nil
+	}
+
+	var comparisons []versionComparison
+	for _, token := range tokens {
+		parsed, err := parseComparison(token)
+		if err != nil {
+			return nil, err
+		}
+		comparisons = append(comparisons, parsed...)
+	}
+	return comparisons, nil
+}
+
+func parseComparison(token string) ([]versionComparison, error) {
+	operator := ""
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(token, op) {
+			operator = op
+			token = strings.TrimPrefix(token, op)
+			break
+		}
+	}
+	p, err := parsePartialVersion(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.parts) == 0 {
+		switch operator {
+		case "", "=", ">=", "<=", "^", "~":
+			// Any version
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("invalid constraint %s%s: no version matches", operator, token)
+		}
+	}
+
+	exact := p.full != nil
+	switch operator {
+	case "", "=":
+		if exact {
+			return []versionComparison{{"=", p.full}}, nil
+		}
+		return []versionComparison{{">=", p.lower()}, {"<", p.next()}}, nil
+	case "!=":
+		if exact {
+			return []versionComparison{{"!=", p.full}}, nil
+		}
+		return nil, fmt.Errorf("invalid constraint !=%s: a full version is required", token)
+	case ">=", "<":
+		return []versionComparison{{operator, p.lower()}}, nil
+	case ">":
+		if exact {
+			return []versionComparison{{">", p.full}}, nil
+		}
+		return []versionComparison{{">=", p.next()}}, nil
+	case "<=":
+		if exact {
+			return []versionComparison{{"<=", p.full}}, nil
+		}
+		return []versionComparison{{"<", p.next()}}, nil
+	case "~":
+		// ~1.2.3 and ~1.2 allow patch updates, ~1 minor ones
+		upper := partialVersion{parts: p.parts[:min(len(p.parts), 2)]}
+		return []versionComparison{{">=", p.lower()}, {"<", upper.next()}}, nil
+	default:
+		// ^ allows updates that keep the leftmost non-zero component
+		keep := len(p.parts)
+		for i, part := range p.parts {
+			if part != 0 || i == len(p.parts)-1 {
+				keep = i + 1
+				break
+			}
+		}
+		upper := partialVersion{parts: p.parts[:keep]}
+		return []versionComparison{{">=", p.lower()}, {"<", upper.next()}}, nil
+	}
+}
+
+// allows reports whether v satisfies the range
+func (r versionRange) allows(v *version.Version) bool {
+	for _, comparisons := range r {
+		satisfied := true
+		for _, c := range comparisons {
+			if !c.allows(v) {
+				satisfied = false
+				break
+			}
+		}
+		if satisfied {
+			return true
+		}
+	}
+	return false
+}
+
+func (c versionComparison) allows(v *version.Version) bool {
+	cmp := 0
+	if v.LessThan(c.bound) {
+		cmp = -1
+	} else if c.bound.LessThan(v) {
+		cmp = 1
+	}
+	switch c.operator {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// parseVersion reads a reported version such as v1.28.3+k3s1 or 1.21,
+// keeping pre-release ordering when the version is semantic
+func parseVersion(s string) (*version.Version, error) {
+	if v, err := version.ParseSemantic(s); err == nil {
+		return v, nil
+	}
+	return version.ParseGeneric(s)
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestVersionRange(t *testing.T) {
+	tests := []struct {
+		constraint string
+		allowed    []string
+		denied     []string
+		wantErr    bool
+	}{
+		{constraint: ">=1.28.0 <1.31", allowed: []string{"1.28.0", "1.30.9"}, denied: []string{"1.27.9", "1.31.0"}},
+		{constraint: ">=1.28.0, <1.31", allowed: []string{"1.29.2"}, denied: []string{"1.31.1"}},
+		{constraint: "^0.21 || ^1.0", allowed: []string{"0.21.0", "0.21.7", "1.4.0"}, denied: []string{"0.22.0", "2.0.0"}},
+		{constraint: "^1.2.3", allowed: []string{"1.2.3", "1.9.0"}, denied: []string{"1.2.2", "2.0.0"}},
+		{constraint: "^0.0.3", allowed: []string{"0.0.3"}, denied: []string{"0.0.4"}},
+		{constraint: "~1.2.3", allowed: []string{"1.2.9"}, denied: []string{"1.3.0"}},
+		{constraint: "~1", allowed: []string{"1.9.0"}, denied: []string{"2.0.0"}},
+		{constraint: "1.2.x", allowed: []string{"1.2.0", "1.2.5"}, denied: []string{"1.3.0"}},
+		{constraint: "1.x", allowed: []string{"1.0.0", "1.99.0"}, denied: []string{"2.0.0", "0.9.0"}},
+		{constraint: "*", allowed: []string{"0.0.1", "9.0.0"}},
+		{constraint: "1.2 - 1.4", allowed: []string{"1.2.0", "1.4.9"}, denied: []string{"1.1.9", "1.5.0"}},
+		{constraint: "1.2.0 - 1.4.0", allowed: []string{"1.4.0"}, denied: []string{"1.4.1"}},
+		{constraint: ">1.2", allowed: []string{"1.3.0"}, denied: []string{"1.2.9"}},
+		{constraint: "<=1.2", allowed: []string{"1.2.9"}, denied: []string{"1.3.0"}},
+		{constraint: ">= 0.21.0", allowed: []string{"v0.23.1", "0.21.0+build", "1.0"}, denied: []string{"0.20.0", "0.21.0-rc.1"}},
+		// Pre-releases come before their release
+		{constraint: ">=1.2.0-beta.1", allowed: []string{"1.2.0-beta.2", "1.2.0"}, denied: []string{"1.2.0-alpha.1"}},
+		{constraint: ">=latest", wantErr: true},
+		{constraint: "1.2.3.4", wantErr: true},
+		{constraint: "!=1.2", wantErr: true},
+		{constraint: ">*", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			allowed, err := parseVersionRange(tt.constraint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVersionRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, v := range tt.allowed {
+				if parsed, err := parseVersion(v); err != nil || !allowed.allows(parsed) {
+					t.Errorf("%s does not allow %s (%v)", tt.constraint, v, err)
+				}
+			}
+			for _, v := range tt.denied {
+				if parsed, err := parseVersion(v); err != nil || allowed.allows(parsed) {
+					t.Errorf("%s allows %s (%v)", tt.constraint, v, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Reasons a version is flagged in the version report
+const (
+	skewUnsupported  = "unsupported"
+	skewNewerThanHub = "newer-than-hub"
+	skewBehindHub    = "behind-hub"
+)
+
+// clusterManagerDeployment is the OCM hub operator, whose image tells the
+// version of the hub components the klusterlets register with
+const clusterManagerDeployment = "cluster-manager"
+
+// VersionSkewConfig is the versionSkew section of the Initialize config: how
+// many minor versions a cluster may trail its hub before the version report
+// flags it
+type VersionSkewConfig struct {
+	// KubernetesMinors bounds how far the Kubernetes version of a cluster
+	// may trail the hub's, 3 by default
+	KubernetesMinors int `json:"kubernetesMinors,omitempty"`
+	// AgentMinors bounds how far the klusterlet agents may trail the hub's
+	// cluster manager, 1 by default
+	AgentMinors int `json:"agentMinors,omitempty"`
+}
+
+func versionSkewFromConfig(config map[string]interface{}) (VersionSkewConfig, error) {
+	skew := VersionSkewConfig{KubernetesMinors: 3, AgentMinors: 1}
+	raw, ok := config["versionSkew"]
+	if !ok {
+		return skew, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return skew, fmt.Errorf("invalid versionSkew config: %w", err)
+	}
+	if err := json.Unmarshal(data, &skew); err != nil {
+		return skew, fmt.Errorf("invalid versionSkew config: %w", err)
+	}
+	if skew.KubernetesMinors < 0 || skew.AgentMinors < 0 {
+		return skew, fmt.Errorf("invalid versionSkew config: kubernetesMinors and agentMinors can't be negative")
+	}
+	return skew, nil
+}
+
+// VersionIssue is a version the report flags
+type VersionIssue struct {
+	// Component is kubernetes or the name of an agent Deployment
+	Component string `json:"component"`
+	Version   string `json:"version"`
+	// Reason is unsupported, newer-than-hub or behind-hub
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// HubVersions are the versions a hub runs
+type HubVersions struct {
+	Name                  string   `json:"name"`
+	KubernetesVersion     string   `json:"kubernetesVersion,omitempty"`
+	ClusterManagerVersion string   `json:"clusterManagerVersion,omitempty"`
+	Errors                []string `json:"errors,omitempty"`
+}
+
+// ClusterVersions are the versions a cluster runs and the skew found in them
+type ClusterVersions struct {
+	ClusterName       string         `json:"clusterName"`
+	Hub               string         `json:"hub"`
+	Status            string         `json:"status"`
+	KubernetesVersion string         `json:"kubernetesVersion,omitempty"`
+	Agents            []AgentVersion `json:"agents"`
+	Issues            []VersionIssue `json:"issues"`
+	// UpgradeReady is set when every version is known and none is flagged
+	UpgradeReady bool     `json:"upgradeReady"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// VersionSummary counts the clusters of the report
+type VersionSummary struct {
+	Clusters     int `json:"clusters"`
+	UpgradeReady int `json:"upgradeReady"`
+	Flagged      int `json:"flagged"`
+	// Unknown clusters couldn't be read, nor flagged
+	Unknown int `json:"unknown"`
+	// ByKubernetesMinor counts the clusters on each Kubernetes minor version
+	ByKubernetesMinor map[string]int `json:"byKubernetesMinor"`
+}
+
+// minorsBehind returns how many minor versions have trails want, false when
+// either can't be parsed or their major versions differ
+func minorsBehind(have, want string) (int, bool) {
+	h, err := parseVersion(have)
+	if err != nil {
+		return 0, false
+	}
+	w, err := parseVersion(want)
+	if err != nil || h.Major() != w.Major() {
+		return 0, false
+	}
+	return int(w.Minor()) - int(h.Minor()), true
+}
+
+// skewIssue compares the version of a component with the hub's
+func skewIssue(component, have, hubVersion, hubComponent string, allowed int) *VersionIssue {
+	behind, ok := minorsBehind(have, hubVersion)
+	switch {
+	case !ok:
+		return nil
+	case behind < 0:
+		return &VersionIssue{Component: component, Version: have, Reason: skewNewerThanHub,
+			Message: fmt.Sprintf("%s %s is newer than the hub's %s %s", component, have, hubComponent, hubVersion)}
+	case behind > allowed:
+		return &VersionIssue{Component: component, Version: have, Reason: skewBehindHub,
+			Message: fmt.Sprintf("%s %s trails the hub's %s %s by %d minor versions, more than the %d supported", component, have, hubComponent, hubVersion, behind, allowed)}
+	}
+	return nil
+}
+
+// checkVersions flags the versions of a cluster that break the kubernetes
+// compatibility constraint of the plugin or skew too far from its hub
+func checkVersions(cluster *ClusterVersions, hub HubVersions, constraint string, skew VersionSkewConfig) {
+	cluster.Issues = []VersionIssue{}
+	if version := cluster.KubernetesVersion; version != "" {
+		if constraint != "" {
+			if ok, problem := evaluateConstraint(version, constraint); !ok {
+				cluster.Issues = append(cluster.Issues, VersionIssue{Component: "kubernetes", Version: version, Reason: skewUnsupported,
+					Message: fmt.Sprintf("Kubernetes %s: %s", version, problem)})
+			}
+		}
+		if issue := skewIssue("kubernetes", version, hub.KubernetesVersion, "Kubernetes", skew.KubernetesMinors); issue != nil {
+			cluster.Issues = append(cluster.Issues, *issue)
+		}
+	}
+	for _, agent := range cluster.Agents {
+		if issue := skewIssue(agent.Name, agent.Version, hub.ClusterManagerVersion, "cluster manager", skew.AgentMinors); issue != nil {
+			cluster.Issues = append(cluster.Issues, *issue)
+		}
+	}
+	cluster.UpgradeReady = cluster.KubernetesVersion != "" && len(cluster.Agents) > 0 && len(cluster.Issues) == 0
+}
+
+// summarizeVersions counts the clusters of a version report
+func summarizeVersions(clusters []ClusterVersions) VersionSummary {
+	summary := VersionSummary{Clusters: len(clusters), ByKubernetesMinor: map[string]int{}}
+	for _, cluster := range clusters {
+		switch {
+		case len(cluster.Issues) > 0:
+			summary.Flagged++
+		case cluster.UpgradeReady:
+			summary.UpgradeReady++
+		default:
+			summary.Unknown++
+		}
+		minor := "unknown"
+		if v, err := parseVersion(cluster.KubernetesVersion); err == nil {
+			minor = fmt.Sprintf("%d.%d", v.Major(), v.Minor())
+		}
+		summary.ByKubernetesMinor[minor]++
+	}
+	return summary
+}
+
+// hubVersions reads the Kubernetes version of a hub and the version of its
+// cluster manager
+func hubVersions(ctx context.Context, name string, hub kubernetes.Interface) HubVersions {
+	versions := HubVersions{Name: name}
+	if serverVersion, err := hub.Discovery().ServerVersion(); err != nil {
+		versions.Errors = append(versions.Errors, fmt.Sprintf("failed to read server version: %v", err))
+	} else {
+		versions.KubernetesVersion = serverVersion.GitVersion
+	}
+	deployment, err := hub.AppsV1().Deployments("open-cluster-management").Get(ctx, clusterManagerDeployment, metav1.GetOptions{})
+	if err != nil {
+		versions.Errors = append(versions.Errors, fmt.Sprintf("failed to get the cluster manager: %v", err))
+	} else if containers := deployment.Spec.Template.Spec.Containers; len(containers) > 0 {
+		versions.ClusterManagerVersion = imageVersion(containers[0].Image)
+	}
+	return versions
+}
+
+// GetClusterVersionsHandler reports the Kubernetes and agent versions of the
+// clusters, optionally of one ?hub, flagging those the plugin doesn't
+// support or that skew too far from their hub
+func (cp *ClusterPlugin) GetClusterVersionsHandler(c *gin.Context) {
+	cp.mutex.RLock()
+	records, err := cp.store.List()
+	cp.mutex.RUnlock()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	records = tenantClusters(c.Request.Context(), records)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+	constraint := cp.GetMetadata().Compatibility["kubernetes"]
+	hubs := map[string]HubVersions{}
+	clusters := []ClusterVersions{}
+	for _, record := range records {
+		name := hubName(record)
+		if filter := c.Query("hub"); filter != "" && filter != name {
+			continue
+		}
+		cluster := ClusterVersions{ClusterName: record.ClusterName, Hub: name, Status: record.Status, Agents: []AgentVersion{}}
+		hub, err := cp.hubs.Get(name)
+		if err != nil {
+			cluster.Errors = append(cluster.Errors, err.Error())
+			checkVersions(&cluster, HubVersions{Name: name}, constraint, cp.versionSkew)
+			clusters = append(clusters, cluster)
+			continue
+		}
+		target, err := cp.detailTarget(hub, record.ClusterName)
+		if err != nil {
+			cluster.Errors = append(cluster.Errors, err.Error())
+		} else {
+			if _, read := hubs[name]; !read {
+				hubs[name] = hubVersions(ctx, name, target.hub)
+			}
+			detail, err := describeCluster(ctx, target)
+			if err != nil {
+				cluster.Errors = append(cluster.Errors, fmt.Sprintf("failed to describe cluster on hub %s: %v", name, err))
+			}
+			cluster.KubernetesVersion, cluster.Agents = detail.KubernetesVersion, detail.Agents
+			cluster.Errors = append(cluster.Errors, detail.Errors...)
+		}
+		checkVersions(&cluster, hubs[name], constraint, cp.versionSkew)
+		clusters = append(clusters, cluster)
+	}
+	if cp.abortOnContext(c) {
+		return
+	}
+
+	hubList := make([]HubVersions, 0, len(hubs))
+	for _, hub := range hubs {
+		hubList = append(hubList, hub)
+	}
+	sort.Slice(hubList, func(i, j int) bool { return hubList[i].Name < hubList[j].Name })
+	c.JSON(http.StatusOK, gin.H{
+		"hubs":       hubList,
+		"clusters":   clusters,
+		"summary":    summarizeVersions(clusters),
+		"constraint": constraint,
+		"skew":       cp.versionSkew,
+		"plugin":     "kubestellar-cluster-plugin",
+		"timestamp":  time.Now().Format(time.RFC3339),
+	})
+}
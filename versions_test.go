@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckVersions(t *testing.T) {
+	hub := HubVersions{Name: "its1", KubernetesVersion: "v1.30.2", ClusterManagerVersion: "v0.14.0"}
+	skew := VersionSkewConfig{KubernetesMinors: 3, AgentMinors: 1}
+	agent := func(version string) []AgentVersion {
+		return []AgentVersion{{Name: "klusterlet-agent", Version: version}}
+	}
+
+	tests := []struct {
+		name        string
+		kubernetes  string
+		agents      []AgentVersion
+		wantReasons []string
+		wantReady   bool
+	}{
+		{name: "within skew", kubernetes: "v1.29.4+k3s1", agents: agent("v0.13.1"), wantReady: true},
+		{name: "unsupported by the plugin", kubernetes: "v1.27.3", agents: agent("v0.14.0"), wantReasons: []string{skewUnsupported}},
+		{name: "too far behind the hub", kubernetes: "v1.26.1", agents: agent("v0.14.0"), wantReasons: []string{skewUnsupported, skewBehindHub}},
+		{name: "newer than the hub", kubernetes: "v1.31.0", agents: agent("v0.14.0"), wantReasons: []string{skewNewerThanHub}},
+		{name: "agent behind", kubernetes: "v1.30.0", agents: agent("v0.12.0"), wantReasons: []string{skewBehindHub}},
+		{name: "agent pinned by digest", kubernetes: "v1.30.0", agents: agent("sha256:abc"), wantReady: true},
+		{name: "versions unknown", wantReady: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := ClusterVersions{ClusterName: "edge-1", KubernetesVersion: tt.kubernetes, Agents: tt.agents}
+			checkVersions(&cluster, hub, ">=1.28.0", skew)
+			var reasons []string
+			for _, issue := range cluster.Issues {
+				reasons = append(reasons, issue.Reason)
+			}
+			if len(reasons) != len(tt.wantReasons) {
+				t.Fatalf("issues = %+v, want %v", cluster.Issues, tt.wantReasons)
+			}
+			for i := range reasons {
+				if reasons[i] != tt.wantReasons[i] {
+					t.Errorf("issues = %+v, want %v", cluster.Issues, tt.wantReasons)
+				}
+			}
+			if cluster.UpgradeReady != tt.wantReady {
+				t.Errorf("UpgradeReady = %v, want %v", cluster.UpgradeReady, tt.wantReady)
+			}
+		})
+	}
+
+	summary := summarizeVersions([]ClusterVersions{
+		{KubernetesVersion: "v1.30.1", UpgradeReady: true},
+		{KubernetesVersion: "v1.30.4", Issues: []VersionIssue{{Reason: skewBehindHub}}},
+		{},
+	})
+	if summary.UpgradeReady != 1 || summary.Flagged != 1 || summary.Unknown != 1 || summary.ByKubernetesMinor["1.30"] != 2 {
+		t.Errorf("summarizeVersions() = %+v", summary)
+	}
+}
+
+func TestHubVersions(t *testing.T) {
+	hub := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterManagerDeployment, Namespace: "open-cluster-management"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "operator", Image: "quay.io/open-cluster-management/registration-operator:v0.14.0"}},
+		}}},
+	})
+	hub.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.30.2"}
+	if got := hubVersions(context.Background(), "its1", hub); got.KubernetesVersion != "v1.30.2" || got.ClusterManagerVersion != "v0.14.0" || len(got.Errors) != 0 {
+		t.Errorf("hubVersions() = %+v", got)
+	}
+}
+
+func TestGetClusterVersionsUnreachableHub(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("KUBECONFIG", t.TempDir()+"/missing")
+	plugin := newTestPluginWithConfig(t, map[string]interface{}{"mode": modeMock, "mockFleetSize": 0})
+	plugin.store.Put(ClusterStatus{ClusterName: "edge-1", Status: "Ready"})
+	router := gin.New()
+	if err := mountEndpoints(router, plugin.GetMetadata(), plugin.GetHandlers()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/clusters/versions", nil))
+	var response struct {
+		Clusters []ClusterVersions `json:"clusters"`
+		Summary  VersionSummary    `json:"summary"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if recorder.Code != http.StatusOK || len(response.Clusters) != 1 || len(response.Clusters[0].Errors) == 0 || response.Summary.Unknown != 1 {
+		t.Errorf("versions = %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestVersionSkewFromConfig(t *testing.T) {
+	skew, err := versionSkewFromConfig(map[string]interface{}{"versionSkew": map[string]interface{}{"agentMinors": 2}})
+	if err != nil || skew.KubernetesMinors != 3 || skew.AgentMinors != 2 {
+		t.Errorf("versionSkewFromConfig() = %+v, %v", skew, err)
+	}
+	if _, err := versionSkewFromConfig(map[string]interface{}{"versionSkew": map[string]interface{}{"kubernetesMinors": -1}}); err == nil {
+		t.Error("expected a negative skew to be rejected")
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Lifecycle events delivered to webhooks
+const (
+	eventClusterOnboarded      = "cluster.onboarded"
+	eventClusterFailed         = "cluster.failed"
+	eventClusterDetached       = "cluster.detached"
+	eventClusterUnreachable    = "cluster.unreachable"
+	eventClusterReachable      = "cluster.reachable"
+	eventClusterTokenExpiring  = "cluster.token.expiring"
+	eventClusterSLOBurning     = "cluster.slo.burning"
+	eventClusterSLORecovered   = "cluster.slo.recovered"
+	eventPluginHealthDegraded  = "plugin.health.degraded"
+	eventPluginUpdateAvailable = "plugin.update.available"
+)
+
+// Headers set on every delivery
+const (
+	webhookSignatureHeader = "X-KubeStellar-Signature"
+	webhookEventHeader     = "X-KubeStellar-Event"
+	webhookDeliveryHeader  = "X-KubeStellar-Delivery"
+)
+
+var webhookEvents = map[string]bool{
+	eventClusterOnboarded:      true,
+	eventClusterFailed:         true,
+	eventClusterDetached:       true,
+	eventClusterUnreachable:    true,
+	eventClusterReachable:      true,
+	eventClusterTokenExpiring:  true,
+	eventClusterSLOBurning:     true,
+	eventClusterSLORecovered:   true,
+	eventPluginHealthDegraded:  true,
+	eventPluginUpdateAvailable: true,
+}
+
+// Webhook is a registered receiver of lifecycle events. An empty Events list
+// subscribes to every event. The secret signs deliveries and is never returned.
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url" binding:"required"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+	// LastDelivery reports the outcome of the most recent delivery
+	LastDelivery *WebhookDelivery `json:"lastDelivery,omitempty"`
+}
+
+// Validate checks the webhook URL and event filter
+func (w Webhook) Validate() error {
+	parsed, err := url.Parse(w.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	for _, event := range w.Events {
+		if !webhookEvents[event] {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+func (w Webhook) subscribed(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, candidate := range w.Events {
+		if candidate == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the outcome of delivering one event to one webhook
+type WebhookDelivery struct {
+	ID         string `json:"id"`
+	Event      string `json:"event"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Delivered  bool   `json:"delivered"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// WebhookEvent is the JSON body POSTed to webhooks
+type WebhookEvent struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	ClusterName string `json:"clusterName,omitempty"`
+	JobID       string `json:"jobId,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Timestamp   string `json:"timestamp"`
+}
+
+// WebhookNotifier delivers signed events to the registered webhooks in the
+// background, retrying failed deliveries with exponential backoff
+type WebhookNotifier struct {
+	hooks       map[string]*Webhook
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	pending     int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mutex  sync.RWMutex
+}
+
+// NewWebhookNotifier creates a notifier making up to maxAttempts attempts per
+// delivery, the first retry waiting backoff
+func NewWebhookNotifier(timeout time.Duration, maxAttempts int, backoff time.Duration) *WebhookNotifier {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookNotifier{
+		hooks:       make(map[string]*Webhook),
+		client:      &http.Client{Timeout: timeout},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// webhooksFromConfig reads the "webhooks" list of the Initialize config
+func webhooksFromConfig(config map[string]interface{}) ([]Webhook, error) {
+	raw, ok := config["webhooks"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhooks config: %w", err)
+	}
+	var hooks []Webhook
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("invalid webhooks config: %w", err)
+	}
+	return hooks, nil
+}
+
+// Add registers a webhook, assigning an ID when it has none
+func (wn *WebhookNotifier) Add(hook Webhook) (Webhook, error) {
+	if err := hook.Validate(); err != nil {
+		return Webhook{}, err
+	}
+	if hook.ID == "" {
+		hook.ID = newJobID("webhook")
+	}
+	hook.LastDelivery = nil
+
+	wn.mutex.Lock()
+	defer wn.mutex.Unlock()
+	if _, exists := wn.hooks[hook.ID]; exists {
+		return Webhook{}, fmt.Errorf("webhook '%s' already exists", hook.ID)
+	}
+	wn.hooks[hook.ID] = &hook
+	return redactWebhook(hook), nil
+}
+
+// Remove unregisters a webhook
+func (wn *WebhookNotifier) Remove(id string) bool {
+	wn.mutex.Lock()
+	defer wn.mutex.Unlock()
+	if _, exists := wn.hooks[id]; !exists {
+		return false
+	}
+	delete(wn.hooks, id)
+	return true
+}
+
+// List returns the registered webhooks sorted by ID, without their secrets
+func (wn *WebhookNotifier) List() []Webhook {
+	wn.mutex.RLock()
+	defer wn.mutex.RUnlock()
+
+	hooks := make([]Webhook, 0, len(wn.hooks))
+	for _, hook := range wn.hooks {
+		hooks = append(hooks, redactWebhook(*hook))
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].ID < hooks[j].ID
+	})
+	return hooks
+}
+
+// Pending returns the number of deliveries not yet finished
+func (wn *WebhookNotifier) Pending() int {
+	wn.mutex.RLock()
+	defer wn.mutex.RUnlock()
+	return wn.pending
+}
+
+func redactWebhook(hook Webhook) Webhook {
+	hook.Secret = ""
+	if hook.LastDelivery != nil {
+		delivery := *hook.LastDelivery
+		hook.LastDelivery = &delivery
+	}
+	return hook
+}
+
+// Notify queues event for delivery to every webhook subscribed to its type
+func (wn *WebhookNotifier) Notify(event WebhookEvent) {
+	event.ID = newJobID("event")
+	if event.Timestamp == "" {
+		event.Timestamp = time.Now().Format(time.RFC3339)
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger().Error("Failed to encode webhook event", "event", event.Type, "error", err)
+		return
+	}
+
+	wn.mutex.Lock()
+	defer wn.mutex.Unlock()
+	if wn.ctx.Err() != nil {
+		return
+	}
+	for _, hook := range wn.hooks {
+		if !hook.subscribed(event.Type) {
+			continue
+		}
+		wn.pending++
+		wn.wg.Add(1)
+		go wn.deliver(*hook, event, body)
+	}
+}
+
+// deliver POSTs one event to one webhook until it succeeds, fails
+// permanently, runs out of attempts or the notifier is closed
+func (wn *WebhookNotifier) deliver(hook Webhook, event WebhookEvent, body []byte) {
+	defer wn.wg.Done()
+
+	delivery := WebhookDelivery{ID: event.ID, Event: event.Type}
+	backoff := wn.backoff
+	for {
+		delivery.Attempts++
+		var retry bool
+		delivery.StatusCode, retry, delivery.Error = wn.post(hook, event, body)
+		if delivery.Error == "" {
+			delivery.Delivered = true
+			break
+		}
+		if !retry || delivery.Attempts >= wn.maxAttempts {
+			break
+		}
+		if !wn.sleep(backoff) {
+			delivery.Error = "delivery abandoned on shutdown: " + delivery.Error
+			break
+		}
+		backoff *= 2
+	}
+	delivery.Timestamp = time.Now().Format(time.RFC3339)
+
+	if !delivery.Delivered {
+		logger().Warn("Webhook delivery failed", "webhook", hook.ID, "event", event.Type,
+			"attempts", delivery.Attempts, "error", delivery.Error)
+	}
+
+	wn.mutex.Lock()
+	defer wn.mutex.Unlock()
+	wn.pending--
+	if registered, exists := wn.hooks[hook.ID]; exists {
+		registered.LastDelivery = &delivery
+	}
+}
+
+// sleep waits for d, returning false if the notifier is closed meanwhile
+func (wn *WebhookNotifier) sleep(d time.Duration) bool {
+	select {
+	case <-wn.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// post sends a single signed request, reporting whether a failure is worth retrying
+func (wn *WebhookNotifier) post(hook Webhook, event WebhookEvent, body []byte) (int, bool, string) {
+	// The attempt in flight is bounded by the client timeout rather than
+	// cancelled on Close, so shutdown lets it finish
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err.Error()
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookEventHeader, event.Type)
+	request.Header.Set(webhookDeliveryHeader, event.ID)
+	if hook.Secret != "" {
+		request.Header.Set(webhookSignatureHeader, signWebhookBody(hook.Secret, body))
+	}
+
+	response, err := wn.client.Do(request)
+	if err != nil {
+		return 0, true, err.Error()
+	}
+	response.Body.Close()
+
+	switch {
+	case response.StatusCode < 300:
+		return response.StatusCode, false, ""
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		return response.StatusCode, true, response.Status
+	default:
+		return response.StatusCode, false, response.Status
+	}
+}
+
+// signWebhookBody returns the HMAC-SHA256 signature receivers verify with the shared secret
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Close stops accepting events and waits up to timeout for in-flight
+// deliveries; pending retries are abandoned
+func (wn *WebhookNotifier) Close(timeout time.Duration) {
+	wn.mutex.Lock()
+	wn.cancel()
+	wn.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wn.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logger().Warn("Webhook deliveries still running after shutdown timeout", "timeout", timeout)
+	}
+}
+
+// ListWebhooksHandler lists the registered webhooks
+func (cp *ClusterPlugin) ListWebhooksHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks":  cp.webhooks.List(),
+		"pending":   cp.webhooks.Pending(),
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// CreateWebhookHandler registers a webhook
+func (cp *ClusterPlugin) CreateWebhookHandler(c *gin.Context) {
+	var hook Webhook
+	if err := c.ShouldBindJSON(&hook); err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	created, err := cp.webhooks.Add(hook)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook":   created,
+		"plugin":    "kubestellar-cluster-plugin",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// DeleteWebhookHandler unregisters a webhook
+func (cp *ClusterPlugin) DeleteWebhookHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !cp.webhooks.Remove(id) {
+		respondProblem(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("webhook '%s' not found", id))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     message(c, MsgWebhookDeleted, "webhook", id),
+		"messageCode": MsgWebhookDeleted,
+		"plugin":      "kubestellar-cluster-plugin",
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
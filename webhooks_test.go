@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    Webhook
+		wantErr bool
+	}{
+		{name: "valid", hook: Webhook{URL: "https://example.com/hook", Events: []string{eventClusterOnboarded}}},
+		{name: "all events", hook: Webhook{URL: "http://example.com/hook"}},
+		{name: "relative url", hook: Webhook{URL: "/hook"}, wantErr: true},
+		{name: "unsupported scheme", hook: Webhook{URL: "ftp://example.com/hook"}, wantErr: true},
+		{name: "unknown event", hook: Webhook{URL: "https://example.com/hook", Events: []string{"cluster.exploded"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.hook.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		events        []string
+		wantAttempts  int32
+		wantDelivered bool
+	}{
+		{name: "delivered first time", statuses: []int{http.StatusOK}, wantAttempts: 1, wantDelivered: true},
+		{name: "retried after server error", statuses: []int{http.StatusBadGateway, http.StatusNoContent}, wantAttempts: 2, wantDelivered: true},
+		{name: "client error is not retried", statuses: []int{http.StatusBadRequest}, wantAttempts: 1},
+		{name: "gives up after max attempts", statuses: []int{500, 500, 500, 500}, wantAttempts: 3},
+		{name: "filtered out", statuses: []int{http.StatusOK}, events: []string{eventClusterDetached}, wantAttempts: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&attempts, 1)
+				body, _ := io.ReadAll(r.Body)
+				if got := r.Header.Get(webhookSignatureHeader); got != signWebhookBody("s3cret", body) {
+					t.Errorf("signature = %q, want a valid HMAC", got)
+				}
+				w.WriteHeader(tt.statuses[int(n)-1])
+			}))
+			defer server.Close()
+
+			notifier := NewWebhookNotifier(time.Second, 3, time.Millisecond)
+			hook, err := notifier.Add(Webhook{URL: server.URL, Secret: "s3cret", Events: tt.events})
+			if err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+			if hook.Secret != "" {
+				t.Errorf("Add() returned the secret")
+			}
+
+			notifier.Notify(WebhookEvent{Type: eventClusterOnboarded, ClusterName: "cluster1"})
+			deadline := time.Now().Add(5 * time.Second)
+			for notifier.Pending() > 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			notifier.Close(time.Second)
+
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if tt.wantAttempts == 0 {
+				return
+			}
+			delivery := notifier.List()[0].LastDelivery
+			if delivery == nil || delivery.Delivered != tt.wantDelivered {
+				t.Errorf("last delivery = %+v, want delivered %v", delivery, tt.wantDelivered)
+			}
+		})
+	}
+}